@@ -30,6 +30,8 @@ type Options struct {
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	// SameSite restricts cross-site sending of the cookie (0 means no 'SameSite' attribute specified).
+	SameSite http.SameSite
 }
 
 // Session --------------------------------------------------------------------
@@ -195,6 +197,7 @@ func NewCookie(name, value string, options *Options) *http.Cookie {
 		MaxAge:   options.MaxAge,
 		Secure:   options.Secure,
 		HttpOnly: options.HttpOnly,
+		SameSite: options.SameSite,
 	}
 	if options.MaxAge > 0 {
 		d := time.Duration(options.MaxAge) * time.Second
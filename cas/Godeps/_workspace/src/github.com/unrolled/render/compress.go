@@ -0,0 +1,207 @@
+package render
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Compression is the level that responses are compressed with, when the client accepts it.
+type Compression int
+
+const (
+	// CompressionOff disables compression.
+	CompressionOff Compression = iota
+	// CompressionDefault compresses with the default level (balancing speed and size).
+	CompressionDefault
+	// CompressionBestSpeed compresses as fast as possible.
+	CompressionBestSpeed
+	// CompressionBestCompression compresses responses as small as possible.
+	CompressionBestCompression
+)
+
+func (c Compression) level() int {
+	switch c {
+	case CompressionBestSpeed:
+		return gzip.BestSpeed
+	case CompressionBestCompression:
+		return gzip.BestCompression
+	default:
+		return gzip.DefaultCompression
+	}
+}
+
+const (
+	// ContentEncoding header constant.
+	ContentEncoding = "Content-Encoding"
+	// AcceptEncoding header constant.
+	AcceptEncoding = "Accept-Encoding"
+	// Vary header constant.
+	Vary = "Vary"
+
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header (preferring gzip when they rank equally).
+// Returns "" if neither is accepted.
+func negotiateEncoding(header string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if len(coding) > 0 {
+			qualities[coding] = parseQuality(params[1:])
+		}
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range []string{encodingGzip, encodingDeflate} {
+		quality, ok := qualities[encoding]
+		if !ok {
+			quality, ok = qualities["*"]
+		}
+		if ok && quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// Compress wraps a handler so that responses rendered with r are compressed, if the client accepts it.
+// Compression is negotiated with the request's Accept-Encoding header, and responses smaller than
+// CompressionMinSize, as well as Data responses, are sent uncompressed. Other responses written by the
+// handler are never compressed. Handlers are returned unchanged if Compression is off.
+func (r *Render) Compress(h http.Handler) http.Handler {
+	if r.opt.Compression == CompressionOff {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rw := requestWriterFor(w)
+		rw.encoding = negotiateEncoding(req.Header.Get(AcceptEncoding))
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// compressWriter compresses a response once it reaches a minimum size.
+// The status is held back with the start of the body until the response is large enough (or is flushed)
+// to be worth compressing, so that the headers can still be changed.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	minSize  int
+
+	status  int
+	buf     []byte
+	started bool
+	encoder io.WriteCloser
+}
+
+// compressWriter returns a writer compressing the response if it was negotiated with Compress, otherwise nil.
+func (r *Render) compressWriter(w http.ResponseWriter) *compressWriter {
+	rw, ok := w.(*requestWriter)
+	if !ok || r.opt.Compression == CompressionOff {
+		return nil
+	}
+
+	// Caches must not serve compressed responses to clients that do not accept them (and vice versa).
+	w.Header().Add(Vary, AcceptEncoding)
+	if len(rw.encoding) == 0 {
+		return nil
+	}
+	return &compressWriter{
+		ResponseWriter: w,
+		encoding:       rw.encoding,
+		level:          r.opt.Compression.level(),
+		minSize:        r.opt.CompressionMinSize,
+	}
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.started {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) >= cw.minSize {
+			if err := cw.start(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// start sends the status and the held back body, compressed or not.
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	if compress && bodyAllowed(cw.status) {
+		// The length of the compressed body is not known in advance.
+		cw.Header().Del(ContentLength)
+		cw.Header().Set(ContentEncoding, cw.encoding)
+		if cw.encoding == encodingGzip {
+			cw.encoder, _ = gzip.NewWriterLevel(cw.ResponseWriter, cw.level)
+		} else {
+			cw.encoder, _ = flate.NewWriter(cw.ResponseWriter, cw.level)
+		}
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := cw.Write(buf)
+	return err
+}
+
+// Flush implements http.Flusher, sending the response so far (compressed).
+func (cw *compressWriter) Flush() {
+	if !cw.started && cw.status != 0 {
+		cw.start(len(cw.buf) > 0)
+	}
+	if flusher, ok := cw.encoder.(interface {
+		Flush() error
+	}); ok {
+		flusher.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends any held back response, uncompressed if it is smaller than the minimum size, and ends compression.
+func (cw *compressWriter) Close() error {
+	if !cw.started {
+		if cw.status == 0 {
+			return nil
+		}
+		if err := cw.start(false); err != nil {
+			return err
+		}
+	}
+	if cw.encoder != nil {
+		return cw.encoder.Close()
+	}
+	return nil
+}
+
+// bodyAllowed reports whether a response with the given status can have a body.
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
@@ -4,10 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"encoding/xml"
+	"fmt"
 	"html/template"
 	"net/http"
+	"regexp"
 )
 
+// DefaultJSONPCallbackPattern matches the JSONP callbacks rendered by default: JavaScript identifiers, or dotted paths of them.
+var DefaultJSONPCallbackPattern = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$.]*$`)
+
 // Engine is the generic interface for all responses.
 type Engine interface {
 	Render(http.ResponseWriter, interface{}) error
@@ -17,6 +22,7 @@ type Engine interface {
 type Head struct {
 	ContentType string
 	Status      int
+	Headers     http.Header // Extra headers, set before the status line (except Content-Type).
 }
 
 // Data built-in renderer.
@@ -27,8 +33,20 @@ type Data struct {
 // HTML built-in renderer.
 type HTML struct {
 	Head
-	Name      string
-	Templates *template.Template
+	Name       string
+	Templates  *template.Template
+	BufferPool *BufferPool // Buffers to execute templates into, a new buffer is used for each response if nil.
+	Streaming  bool
+}
+
+// StreamingError is returned when rendering fails after a streamed response has started,
+// meaning the status and headers (and possibly part of the body) have already been sent.
+type StreamingError struct {
+	Err error
+}
+
+func (e *StreamingError) Error() string {
+	return "error while streaming response: " + e.Err.Error()
 }
 
 // JSON built-in renderer.
@@ -43,8 +61,9 @@ type JSON struct {
 // JSONP built-in renderer.
 type JSONP struct {
 	Head
-	Indent   bool
-	Callback string
+	Indent          bool
+	Callback        string
+	CallbackPattern *regexp.Regexp // Callbacks allowed, DefaultJSONPCallbackPattern if nil.
 }
 
 // Text built-in renderer.
@@ -59,12 +78,45 @@ type XML struct {
 	Prefix []byte
 }
 
+// YAML built-in renderer.
+type YAML struct {
+	Head
+	Indent bool
+	Prefix []byte
+}
+
+// MsgPack built-in renderer.
+type MsgPack struct {
+	Head
+}
+
+// Empty built-in renderer, for responses with only a status and headers.
+type Empty struct {
+	Head
+}
+
+func (h Head) head() Head {
+	return h
+}
+
 // Write outputs the header content.
 func (h Head) Write(w http.ResponseWriter) {
+	h.writeHeaders(w)
 	w.Header().Set(ContentType, h.ContentType)
 	w.WriteHeader(h.Status)
 }
 
+// writeHeaders sets the extra headers on the response, except Content-Type.
+func (h Head) writeHeaders(w http.ResponseWriter) {
+	for key, values := range h.Headers {
+		key = http.CanonicalHeaderKey(key)
+		if key == ContentType {
+			continue
+		}
+		w.Header()[key] = append([]string(nil), values...)
+	}
+}
+
 // Render a data response.
 func (d Data) Render(w http.ResponseWriter, v interface{}) error {
 	c := w.Header().Get(ContentType)
@@ -79,8 +131,16 @@ func (d Data) Render(w http.ResponseWriter, v interface{}) error {
 
 // Render a HTML response.
 func (h HTML) Render(w http.ResponseWriter, binding interface{}) error {
-	// Retrieve a buffer from the pool to write to.
-	out := bufPool.Get()
+	if h.Streaming {
+		return h.renderStreamingHTML(w, binding)
+	}
+
+	// Retrieve a buffer from the pool to write to, returning it once the response has been written.
+	out := new(bytes.Buffer)
+	if h.BufferPool != nil {
+		out = h.BufferPool.Get()
+		defer h.BufferPool.Put(out)
+	}
 	err := h.Templates.ExecuteTemplate(out, h.Name, binding)
 	if err != nil {
 		return err
@@ -88,9 +148,19 @@ func (h HTML) Render(w http.ResponseWriter, binding interface{}) error {
 
 	h.Head.Write(w)
 	out.WriteTo(w)
+	return nil
+}
 
-	// Return the buffer to the pool.
-	bufPool.Put(out)
+func (h HTML) renderStreamingHTML(w http.ResponseWriter, binding interface{}) error {
+	// Fail before anything is written if the template does not exist.
+	if h.Templates.Lookup(h.Name) == nil {
+		return fmt.Errorf("html/template: %q is undefined", h.Name)
+	}
+
+	h.Head.Write(w)
+	if err := h.Templates.ExecuteTemplate(w, h.Name, binding); err != nil {
+		return &StreamingError{Err: err}
+	}
 	return nil
 }
 
@@ -100,6 +170,19 @@ func (j JSON) Render(w http.ResponseWriter, v interface{}) error {
 		return j.renderStreamingJSON(w, v)
 	}
 
+	result, err := j.marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// JSON marshaled fine, write out the result.
+	j.Head.Write(w)
+	w.Write(result)
+	return nil
+}
+
+// marshal returns the body of a (non streaming) JSON response: the prefix, followed by the marshaled value.
+func (j JSON) marshal(v interface{}) ([]byte, error) {
 	var result []byte
 	var err error
 
@@ -110,7 +193,7 @@ func (j JSON) Render(w http.ResponseWriter, v interface{}) error {
 		result, err = json.Marshal(v)
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Unescape HTML if needed.
@@ -120,13 +203,10 @@ func (j JSON) Render(w http.ResponseWriter, v interface{}) error {
 		result = bytes.Replace(result, []byte("\\u0026"), []byte("&"), -1)
 	}
 
-	// JSON marshaled fine, write out the result.
-	j.Head.Write(w)
 	if len(j.Prefix) > 0 {
-		w.Write(j.Prefix)
+		result = append(append([]byte{}, j.Prefix...), result...)
 	}
-	w.Write(result)
-	return nil
+	return result, nil
 }
 
 func (j JSON) renderStreamingJSON(w http.ResponseWriter, v interface{}) error {
@@ -140,6 +220,15 @@ func (j JSON) renderStreamingJSON(w http.ResponseWriter, v interface{}) error {
 
 // Render a JSONP response.
 func (j JSONP) Render(w http.ResponseWriter, v interface{}) error {
+	// The callback is output as is, so refuse anything that could be script rather than a function name.
+	pattern := j.CallbackPattern
+	if pattern == nil {
+		pattern = DefaultJSONPCallbackPattern
+	}
+	if !pattern.MatchString(j.Callback) {
+		return &InvalidCallbackError{Callback: j.Callback}
+	}
+
 	var result []byte
 	var err error
 
@@ -179,6 +268,19 @@ func (t Text) Render(w http.ResponseWriter, v interface{}) error {
 
 // Render an XML response.
 func (x XML) Render(w http.ResponseWriter, v interface{}) error {
+	result, err := x.marshal(v)
+	if err != nil {
+		return err
+	}
+
+	// XML marshaled fine, write out the result.
+	x.Head.Write(w)
+	w.Write(result)
+	return nil
+}
+
+// marshal returns the body of an XML response: the prefix, followed by the marshaled value.
+func (x XML) marshal(v interface{}) ([]byte, error) {
 	var result []byte
 	var err error
 
@@ -189,14 +291,54 @@ func (x XML) Render(w http.ResponseWriter, v interface{}) error {
 		result, err = xml.Marshal(v)
 	}
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// XML marshaled fine, write out the result.
-	x.Head.Write(w)
 	if len(x.Prefix) > 0 {
-		w.Write(x.Prefix)
+		result = append(append([]byte{}, x.Prefix...), result...)
 	}
+	return result, nil
+}
+
+// Render a YAML response.
+func (y YAML) Render(w http.ResponseWriter, v interface{}) error {
+	result, err := marshalYAML(v, y.Indent)
+	if err != nil {
+		return err
+	}
+
+	// YAML marshaled fine, write out the result.
+	y.Head.Write(w)
+	if len(y.Prefix) > 0 {
+		w.Write(y.Prefix)
+	}
+	w.Write(result)
+	return nil
+}
+
+// Render an empty response: the status line and headers only. Responses that can't have a body (ie: 204 No Content)
+// are sent without a Content-Type or Content-Length, others with a Content-Length of 0.
+func (e Empty) Render(w http.ResponseWriter, v interface{}) error {
+	e.Head.writeHeaders(w)
+	if bodyAllowed(e.Head.Status) {
+		w.Header().Set("Content-Length", "0")
+	} else {
+		w.Header().Del(ContentType)
+		w.Header().Del("Content-Length")
+	}
+	w.WriteHeader(e.Head.Status)
+	return nil
+}
+
+// Render a MessagePack response.
+func (m MsgPack) Render(w http.ResponseWriter, v interface{}) error {
+	result, err := marshalMsgPack(v)
+	if err != nil {
+		return err
+	}
+
+	// MessagePack marshaled fine, write out the result.
+	m.Head.Write(w)
 	w.Write(result)
 	return nil
 }
@@ -0,0 +1,68 @@
+package render
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// TemplateError is a template file that could not be loaded.
+type TemplateError struct {
+	// File (or asset) the template was read from.
+	File string
+	// Line of the file the error is on, 0 if it could not be read or parsed.
+	Line int
+	// Error from reading or parsing the template.
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+// Parse errors are reported as "template: <name>:<line>: <description>".
+var templateErrorLine = regexp.MustCompile(`^template: .*?:(\d+):`)
+
+func newTemplateError(file string, err error) *TemplateError {
+	tmplErr := &TemplateError{File: file, Err: err}
+	if match := templateErrorLine.FindStringSubmatch(err.Error()); match != nil {
+		tmplErr.Line, _ = strconv.Atoi(match[1])
+	}
+	return tmplErr
+}
+
+// TemplateCollisionError is a template name given to several template files, as loading one would replace the other.
+type TemplateCollisionError struct {
+	// Name the files are both given.
+	Name string
+	// Files given the name, in the order they were loaded.
+	Files []string
+}
+
+func (e *TemplateCollisionError) Error() string {
+	return fmt.Sprintf("template %q is loaded from both %s", e.Name, strings.Join(e.Files, " and "))
+}
+
+// InvalidCallbackError is a JSONP callback that does not match the JSONPCallbackPattern, and was not rendered.
+type InvalidCallbackError struct {
+	Callback string
+}
+
+func (e *InvalidCallbackError) Error() string {
+	return fmt.Sprintf("render: invalid JSONP callback %q", e.Callback)
+}
+
+// TemplateErrors lists every template file that could not be loaded.
+type TemplateErrors []*TemplateError
+
+func (e TemplateErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "render: failed to load templates: " + strings.Join(messages, "; ")
+}
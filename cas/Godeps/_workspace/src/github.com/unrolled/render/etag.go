@@ -0,0 +1,108 @@
+package render
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+const (
+	// ETagHeader header constant.
+	ETagHeader = "ETag"
+	// IfNoneMatch header constant.
+	IfNoneMatch = "If-None-Match"
+)
+
+// Conditional wraps a handler so that responses rendered with r answer conditional GET requests:
+// if ETag is set and the request's If-None-Match header matches the rendered body, 304 Not Modified is sent instead.
+func (r *Render) Conditional(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		rw := requestWriterFor(w)
+		if req.Method == "GET" || req.Method == "HEAD" {
+			rw.ifNoneMatch = req.Header.Get(IfNoneMatch)
+		}
+		h.ServeHTTP(rw, req)
+	})
+}
+
+// etagWriter holds back a rendered response, so that its ETag can be computed from the body.
+type etagWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (ew *etagWriter) WriteHeader(status int) {
+	if ew.status == 0 {
+		ew.status = status
+	}
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if ew.status == 0 {
+		ew.status = http.StatusOK
+	}
+	return ew.body.Write(b)
+}
+
+// etagWriter returns a writer to render e into if it should be sent with an ETag, otherwise nil.
+// Streamed responses are sent as they are rendered, so they do not have ETags.
+func (r *Render) etagWriter(w http.ResponseWriter, e Engine) *etagWriter {
+	if !r.opt.ETag {
+		return nil
+	}
+	switch engine := e.(type) {
+	case HTML:
+		if engine.Streaming {
+			return nil
+		}
+	case JSON:
+		if engine.StreamingJSON {
+			return nil
+		}
+	case XML, YAML, MsgPack:
+	default:
+		return nil
+	}
+	return &etagWriter{ResponseWriter: w}
+}
+
+// writeTo sends the held back response to w with its ETag, or Not Modified if ifNoneMatch matches it.
+// Only successful (200) responses are given ETags.
+func (ew *etagWriter) writeTo(w http.ResponseWriter, ifNoneMatch string) {
+	if ew.status != http.StatusOK {
+		w.WriteHeader(ew.status)
+		ew.body.WriteTo(w)
+		return
+	}
+
+	// The tag is weak, as the body may be sent compressed.
+	sum := sha1.Sum(ew.body.Bytes())
+	etag := `W/"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set(ETagHeader, etag)
+
+	if etagMatches(ifNoneMatch, etag) {
+		w.Header().Del(ContentType)
+		w.Header().Del(ContentLength)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.WriteHeader(ew.status)
+	ew.body.WriteTo(w)
+}
+
+// etagMatches reports whether an If-None-Match header matches an ETag, using weak comparison.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if len(ifNoneMatch) == 0 {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
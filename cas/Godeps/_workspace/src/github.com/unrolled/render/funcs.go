@@ -0,0 +1,58 @@
+package render
+
+import (
+	"fmt"
+	"html/template"
+)
+
+// AddFunc registers a template function after construction, see AddFuncs.
+func (r *Render) AddFunc(name string, fn interface{}) error {
+	return r.AddFuncs(template.FuncMap{name: fn})
+}
+
+// AddFuncs registers template functions after construction (ie: helpers that depend on runtime configuration),
+// recompiling the templates with them. Renders already in progress finish with the previous templates.
+//
+// Functions are applied after those in Options.Funcs, and names collide last-wins: a function registered again
+// (here or in Options.Funcs) replaces the previous one, so templates calling a function that is only known later
+// can be parsed by registering a placeholder in Options.Funcs. The built-in helpers (yield, block, current,
+// partial and include) are always applied last, so functions with their names never take effect.
+//
+// An error is returned, and the functions are not registered, if a function is invalid or the templates fail to
+// recompile.
+func (r *Render) AddFuncs(funcs template.FuncMap) error {
+	if err := checkFuncs(funcs); err != nil {
+		return err
+	}
+
+	r.compileLk.Lock()
+	defer r.compileLk.Unlock()
+
+	previous := r.funcs
+	merged := template.FuncMap{}
+	for name, fn := range previous {
+		merged[name] = fn
+	}
+	for name, fn := range funcs {
+		merged[name] = fn
+	}
+
+	r.funcs = merged
+	if err := r.compileTemplates(); err != nil {
+		r.funcs = previous
+		return err
+	}
+	return nil
+}
+
+// checkFuncs returns the error template.Funcs would panic with for an invalid function map (ie: a value that is not
+// a function, or a name that is not an identifier).
+func checkFuncs(funcs template.FuncMap) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("render: invalid template function: %v", p)
+		}
+	}()
+	template.New("").Funcs(funcs)
+	return nil
+}
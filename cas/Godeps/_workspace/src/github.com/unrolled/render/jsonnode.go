@@ -0,0 +1,63 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+)
+
+// jsonNode is a value decoded from JSON, keeping the order of object keys (so that encodings made from it
+// are deterministic, like JSON's).
+type jsonNode struct {
+	value  interface{} // nil, bool, json.Number or string, if the node is not an object or array
+	isMap  bool
+	isSeq  bool
+	keys   []string
+	values []*jsonNode
+}
+
+// decodeJSONNode marshals v as JSON and decodes the result, so that other encodings accept the same values,
+// and honour the same struct tags and Marshaler implementations, as JSON.
+func decodeJSONNode(v interface{}) (*jsonNode, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	return readJSONNode(dec)
+}
+
+func readJSONNode(dec *json.Decoder) (*jsonNode, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return &jsonNode{value: token}, nil
+	}
+
+	node := &jsonNode{isMap: delim == '{', isSeq: delim == '['}
+	for dec.More() {
+		if node.isMap {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			node.keys = append(node.keys, key.(string))
+		}
+		value, err := readJSONNode(dec)
+		if err != nil {
+			return nil, err
+		}
+		node.values = append(node.values, value)
+	}
+	// Consume the closing delimiter.
+	if _, err := dec.Token(); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return node, nil
+}
@@ -0,0 +1,131 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// marshalMsgPack encodes v as MessagePack, going through its JSON encoding.
+// Integers are encoded in the smallest form that holds them, and other numbers as 64 bit floats.
+func marshalMsgPack(v interface{}) ([]byte, error) {
+	node, err := decodeJSONNode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeMsgPack(&buf, node)
+	return buf.Bytes(), nil
+}
+
+func writeMsgPack(buf *bytes.Buffer, node *jsonNode) {
+	switch {
+	case node.isSeq:
+		writeMsgPackHeader(buf, len(node.values), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, item := range node.values {
+			writeMsgPack(buf, item)
+		}
+		return
+	case node.isMap:
+		writeMsgPackHeader(buf, len(node.values), 0x80, 16, 0, 0xde, 0xdf)
+		for i, item := range node.values {
+			writeMsgPackString(buf, node.keys[i])
+			writeMsgPack(buf, item)
+		}
+		return
+	}
+
+	switch v := node.value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		writeMsgPackNumber(buf, v)
+	case string:
+		writeMsgPackString(buf, v)
+	}
+}
+
+func writeMsgPackString(buf *bytes.Buffer, s string) {
+	writeMsgPackHeader(buf, len(s), 0xa0, 32, 0xd9, 0xda, 0xdb)
+	buf.WriteString(s)
+}
+
+// writeMsgPackHeader writes the type and length of a string, array or map, in its fix form if the length
+// is below fixLimit, otherwise with an 8 (if there is one), 16 or 32 bit length.
+func writeMsgPackHeader(buf *bytes.Buffer, n int, fix byte, fixLimit int, code8, code16, code32 byte) {
+	switch {
+	case n < fixLimit:
+		buf.WriteByte(fix | byte(n))
+	case code8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(code8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(code16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(code32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func writeMsgPackNumber(buf *bytes.Buffer, n json.Number) {
+	s := n.String()
+	if !strings.ContainsAny(s, ".eE") {
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			writeMsgPackInt(buf, i)
+			return
+		}
+		if u, err := strconv.ParseUint(s, 10, 64); err == nil {
+			buf.WriteByte(0xcf)
+			binary.Write(buf, binary.BigEndian, u)
+			return
+		}
+	}
+
+	f, _ := n.Float64()
+	buf.WriteByte(0xcb)
+	binary.Write(buf, binary.BigEndian, f)
+}
+
+func writeMsgPackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= math.MaxInt8:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(int8(i)))
+	case i >= 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(i))
+	case i >= 0:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, uint64(i))
+	case i >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
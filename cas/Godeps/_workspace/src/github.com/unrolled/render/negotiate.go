@@ -0,0 +1,134 @@
+package render
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentXMLApplication is the alternative media type for XML data, which Auto also renders as XML.
+const ContentXMLApplication = "application/xml"
+
+// acceptRange is a media range from an Accept header, with its quality value.
+type acceptRange struct {
+	mediaType string
+	subType   string
+	quality   float64
+}
+
+// parseAccept parses the media ranges of an Accept header, skipping any that are malformed.
+func parseAccept(header string) []acceptRange {
+	ranges := []acceptRange{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+		slash := strings.Index(mediaRange, "/")
+		if slash <= 0 || slash == len(mediaRange)-1 {
+			continue
+		}
+
+		ranges = append(ranges, acceptRange{
+			mediaType: mediaRange[:slash],
+			subType:   mediaRange[slash+1:],
+			quality:   parseQuality(params[1:]),
+		})
+	}
+	return ranges
+}
+
+// parseQuality returns the quality value (q=) among the parameters of a header entry, 1 if there is none.
+// Invalid quality values are treated as 0.
+func parseQuality(params []string) float64 {
+	quality := 1.0
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(strings.ToLower(param), "q=") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(param[2:]), 64)
+		if err != nil || q < 0 || q > 1 {
+			q = 0
+		}
+		quality = q
+	}
+	return quality
+}
+
+// acceptQuality returns the quality given to a media type by the most specific matching range, or 0 if none match.
+func acceptQuality(ranges []acceptRange, mediaType string) float64 {
+	slash := strings.Index(mediaType, "/")
+	typ, subType := mediaType[:slash], mediaType[slash+1:]
+
+	quality, specificity := 0.0, -1
+	for _, ar := range ranges {
+		s := -1
+		switch {
+		case ar.mediaType == typ && ar.subType == subType:
+			s = 2
+		case ar.mediaType == typ && ar.subType == "*":
+			s = 1
+		case ar.mediaType == "*" && ar.subType == "*":
+			s = 0
+		}
+		if s > specificity {
+			quality, specificity = ar.quality, s
+		}
+	}
+	return quality
+}
+
+// negotiate picks the media type from candidates that the Accept header ranks highest.
+// A missing header accepts anything. The default wins ties, otherwise they go to the earliest candidate.
+// Returns false if the header does not accept any of the candidates.
+func negotiate(header string, candidates []string, defaultType string) (string, bool) {
+	if len(strings.TrimSpace(header)) == 0 {
+		header = "*/*"
+	}
+
+	ranges := parseAccept(header)
+	best, bestQuality := "", 0.0
+	for _, candidate := range candidates {
+		quality := acceptQuality(ranges, candidate)
+		if quality > bestQuality || (quality == bestQuality && quality > 0 && candidate == defaultType) {
+			best, bestQuality = candidate, quality
+		}
+	}
+	return best, bestQuality > 0
+}
+
+// Auto renders data as JSON, XML, YAML, MessagePack or HTML (with the htmlName template), depending on the request's Accept header.
+// Only the media types in AutoMediaTypes are considered, and HTML only if htmlName is given.
+// Responds with 406 Not Acceptable if none of them are accepted.
+func (r *Render) Auto(w http.ResponseWriter, req *http.Request, status int, data interface{}, htmlName string) {
+	candidates := []string{}
+	for _, mediaType := range r.opt.AutoMediaTypes {
+		if isHTMLMediaType(mediaType) && len(htmlName) == 0 {
+			continue
+		}
+		candidates = append(candidates, mediaType)
+	}
+
+	w.Header().Add("Vary", "Accept")
+	mediaType, ok := negotiate(req.Header.Get("Accept"), candidates, r.opt.AutoDefault)
+	if !ok {
+		r.Text(w, http.StatusNotAcceptable, http.StatusText(http.StatusNotAcceptable))
+		return
+	}
+
+	switch {
+	case isHTMLMediaType(mediaType):
+		r.HTML(w, status, htmlName, data)
+	case mediaType == ContentXML || mediaType == ContentXMLApplication:
+		r.XML(w, status, data)
+	case mediaType == ContentYAML:
+		r.YAML(w, status, data)
+	case mediaType == ContentMsgPack:
+		r.MsgPack(w, status, data)
+	default:
+		r.JSON(w, status, data)
+	}
+}
+
+func isHTMLMediaType(mediaType string) bool {
+	return mediaType == ContentHTML || mediaType == ContentXHTML
+}
@@ -1,14 +1,19 @@
 package render
 
 import (
-	"bytes"
 	"fmt"
 	"html/template"
+	"io/fs"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
@@ -20,6 +25,8 @@ const (
 	ContentJSON = "application/json"
 	// ContentJSONP header value for JSONP data.
 	ContentJSONP = "application/javascript"
+	// ContentMsgPack header value for MessagePack data.
+	ContentMsgPack = "application/msgpack"
 	// ContentLength header constant.
 	ContentLength = "Content-Length"
 	// ContentText header value for Text data.
@@ -30,11 +37,14 @@ const (
 	ContentXHTML = "application/xhtml+xml"
 	// ContentXML header value for XML data.
 	ContentXML = "text/xml"
+	// ContentYAML header value for YAML data.
+	ContentYAML = "text/yaml"
 	// Default character encoding.
 	defaultCharset = "UTF-8"
 )
 
-// Included helper functions for use when rendering HTML.
+// Included helper functions for use when rendering HTML. yield, block and current are bound for each render in
+// layouts (see addLayoutFuncs), and partial and include for each clone of a compiled set (see compiledTemplates).
 var helperFuncs = template.FuncMap{
 	"yield": func() (string, error) {
 		return "", fmt.Errorf("yield called with no layout defined")
@@ -45,6 +55,12 @@ var helperFuncs = template.FuncMap{
 	"current": func() (string, error) {
 		return "", nil
 	},
+	"partial": func(name string, binding interface{}) (string, error) {
+		return "", fmt.Errorf("partial called outside of a Render")
+	},
+	"include": func(name string, binding interface{}) (string, error) {
+		return "", fmt.Errorf("include called outside of a Render")
+	},
 }
 
 // Delims represents a set of Left and Right delimiters for HTML template rendering.
@@ -59,13 +75,17 @@ type Delims struct {
 type Options struct {
 	// Directory to load templates. Default is "templates".
 	Directory string
+	// File system (ie: an embed.FS) to load templates from, in place of the directory or assets. Templates are loaded from Directory within it. Defaults to nil.
+	FileSystem fs.FS
 	// Asset function to use in place of directory. Defaults to nil.
 	Asset func(name string) ([]byte, error)
 	// AssetNames function to use in place of directory. Defaults to nil.
 	AssetNames func() []string
 	// Layout template name. Will not render a layout if blank (""). Defaults to blank ("").
 	Layout string
-	// Extensions to parse template files from. Defaults to [".tmpl"].
+	// Layout template names to nest templates in, innermost first (the yield of each layout renders the previous one). Overrides Layout. Defaults to [].
+	Layouts []string
+	// Extensions to parse template files from, matched regardless of case (the longest matching one is trimmed from the template name). Defaults to [".tmpl"].
 	Extensions []string
 	// Funcs is a slice of FuncMaps to apply to the template upon compilation. This is useful for helper functions. Defaults to [].
 	Funcs []template.FuncMap
@@ -81,22 +101,118 @@ type Options struct {
 	PrefixJSON []byte
 	// Prefixes the XML output with the given bytes.
 	PrefixXML []byte
+	// Outputs human readable (block style) YAML, instead of flow style. Default is false.
+	IndentYAML bool
+	// Prefixes the YAML output with the given bytes (ie: a "---" document start line).
+	PrefixYAML []byte
 	// Allows changing of output to XHTML instead of HTML. Default is "text/html"
 	HTMLContentType string
 	// If IsDevelopment is set to true, this will recompile the templates on every request. Default is false.
 	IsDevelopment bool
+	// Watch the template directory, recompiling the templates only when a file changes (instead of on every request in development). Ignored when templates are loaded from Asset. Default is false.
+	WatchTemplates bool
+	// How often the template directory is checked for changes when WatchTemplates is set. Default is 250ms.
+	WatchInterval time.Duration
 	// Unescape HTML characters "&<>" to their original values. Default is false.
 	UnEscapeHTML bool
 	// Streams JSON responses instead of marshalling prior to sending. Default is false.
 	StreamingJSON bool
+	// Streams HTML responses instead of buffering the executed template prior to sending. Only applies when no layout is used. Default is false.
+	StreamingHTML bool
 	// Require that all blocks executed in the layout are implemented in all templates using the layout. Default is false.
 	RequireBlocks bool
+	// Template to render (with an ErrorData binding) when rendering fails, instead of the raw error. Ignored if IsDevelopment is set. Default is blank ("").
+	ErrorHTML string
+	// Builds the binding passed to the ErrorHTML template from the ErrorData (ie: to add values every page is rendered with). Default is nil (the ErrorData is passed as is).
+	ErrorBinding func(data ErrorData) interface{}
+	// Compress responses rendered within the Compress handler, if the client accepts it. Default is CompressionOff.
+	Compression Compression
+	// Responses smaller than this many bytes are not compressed. Default is 1024.
+	CompressionMinSize int
+	// Number of buffers kept for reuse when executing templates. Default is 64.
+	BufferPoolSize int
+	// Set an ETag (hash of the body) on buffered HTML, JSON, XML, YAML and MessagePack responses, answering matching conditional requests within the Conditional handler with 304 Not Modified. Default is false.
+	ETag bool
+	// Media types that Auto may respond with (JSON, XML, YAML, MessagePack and HTML types). Defaults to ["application/json", "text/xml", "application/xml", "text/html"].
+	AutoMediaTypes []string
+	// Media type Auto responds with when the request has no Accept header, or accepts several types equally. Defaults to the first of AutoMediaTypes.
+	AutoDefault string
+	// How template files given the same name (ie: "home.tmpl" and "home.html", with both extensions) are handled. Default is TemplateCollisionsError.
+	TemplateCollisions TemplateCollisions
+	// Callbacks JSONP responses may use, other callbacks are refused with an InvalidCallbackError. Should be anchored (^...$). Defaults to DefaultJSONPCallbackPattern.
+	JSONPCallbackPattern *regexp.Regexp
+}
+
+// TemplateCollisions is how template files that are given the same name, one of which would replace the other, are handled.
+type TemplateCollisions int
+
+const (
+	// TemplateCollisionsError fails to load the templates, with a TemplateCollisionError naming both files.
+	TemplateCollisionsError TemplateCollisions = iota
+	// TemplateCollisionsWarn logs both files, and keeps the template from the file loaded last.
+	TemplateCollisionsWarn
+)
+
+// ErrorData is the binding passed to the ErrorHTML template.
+type ErrorData struct {
+	// Error that caused rendering to fail.
+	Error error
+	// Status of the error response (the originally requested status if it was an error status, otherwise 500).
+	Status int
 }
 
 // HTMLOptions is a struct for overriding some rendering Options for specific HTML call.
 type HTMLOptions struct {
 	// Layout template name. Overrides Options.Layout.
 	Layout string
+	// Layout template names, innermost first. Overrides Options.Layouts and Layout.
+	Layouts []string
+	// Headers to set on the response, before the status line. A Content-Type header is ignored (see Options.HTMLContentType). Options only setting Headers keep the default layouts.
+	Headers http.Header
+}
+
+// ContentTypeOption is a struct for overriding the Content-Type (and setting headers) of a specific JSON, JSONP, XML, YAML, MsgPack, Text or Data call.
+type ContentTypeOption struct {
+	// Content-Type to respond with (ie: "application/vnd.myapp+json"). The charset is appended as usual, unless it already includes one.
+	ContentType string
+	// Don't append the charset to the Content-Type.
+	NoCharset bool
+	// Headers to set on the response (ie: Location or X-RateLimit-Remaining), before the status line. A Content-Type header is ignored, ContentType overrides it instead.
+	Headers http.Header
+}
+
+// headersOption returns the headers to set on the response for a call.
+func headersOption(opts []ContentTypeOption) http.Header {
+	if len(opts) > 0 {
+		return opts[0].Headers
+	}
+	return nil
+}
+
+// contentType returns the Content-Type for a call, appending the charset to text types.
+func (r *Render) contentType(defaultType string, withCharset bool, opts []ContentTypeOption) string {
+	contentType := defaultType
+	if len(opts) > 0 {
+		if len(opts[0].ContentType) > 0 {
+			contentType = opts[0].ContentType
+		}
+		withCharset = withCharset && !opts[0].NoCharset
+	}
+	if withCharset && !strings.Contains(strings.ToLower(contentType), "charset=") {
+		contentType += r.compiledCharset
+	}
+	return contentType
+}
+
+// layoutChain returns the layouts to render a template in, innermost first.
+func (opt HTMLOptions) layoutChain() []string {
+	if len(opt.Layouts) > 0 {
+		return opt.Layouts
+	}
+	if len(opt.Layout) > 0 {
+		return []string{opt.Layout}
+	}
+	return nil
 }
 
 // Render is a service that provides functions for easily writing JSON, XML,
@@ -104,12 +220,28 @@ type HTMLOptions struct {
 type Render struct {
 	// Customize Secure with an Options struct.
 	opt             Options
-	templates       *template.Template
+	templates       *compiledTemplates
+	templatesLk     sync.RWMutex
+	funcs           template.FuncMap // Functions registered with AddFuncs
+	compileLk       sync.Mutex       // Held while compiling templates, and while changing funcs
+	watcher         *templateWatcher
+	bufPool         *BufferPool
 	compiledCharset string
 }
 
 // New constructs a new Render instance with the supplied options.
+// It panics if the templates fail to load, see NewWithErr.
 func New(options ...Options) *Render {
+	r, err := NewWithErr(options...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// NewWithErr constructs a new Render instance with the supplied options, or returns the error
+// (a TemplateErrors, listing every template that failed to load) if the templates cannot be loaded.
+func NewWithErr(options ...Options) (*Render, error) {
 	var o Options
 	if len(options) == 0 {
 		o = Options{}
@@ -122,14 +254,18 @@ func New(options ...Options) *Render {
 	}
 
 	r.prepareOptions()
-	r.compileTemplates()
 
 	// Create a new buffer pool for writing templates into.
-	if bufPool == nil {
-		bufPool = NewBufferPool(64)
+	r.bufPool = NewBufferPool(r.opt.BufferPoolSize)
+
+	if err := r.recompileTemplates(); err != nil {
+		return nil, err
+	}
+	if r.opt.WatchTemplates && r.opt.FileSystem == nil && !r.usesAssets() {
+		r.watcher = newTemplateWatcher(&r)
 	}
 
-	return &r
+	return &r, nil
 }
 
 func (r *Render) prepareOptions() {
@@ -148,23 +284,119 @@ func (r *Render) prepareOptions() {
 	if len(r.opt.HTMLContentType) == 0 {
 		r.opt.HTMLContentType = ContentHTML
 	}
+	if r.opt.WatchInterval <= 0 {
+		r.opt.WatchInterval = 250 * time.Millisecond
+	}
+	if r.opt.BufferPoolSize <= 0 {
+		r.opt.BufferPoolSize = 64
+	}
+	if r.opt.CompressionMinSize <= 0 {
+		r.opt.CompressionMinSize = 1024
+	}
+	if len(r.opt.AutoMediaTypes) == 0 {
+		r.opt.AutoMediaTypes = []string{ContentJSON, ContentXML, ContentXMLApplication, ContentHTML}
+	}
+	if len(r.opt.AutoDefault) == 0 {
+		r.opt.AutoDefault = r.opt.AutoMediaTypes[0]
+	}
+	if r.opt.JSONPCallbackPattern == nil {
+		r.opt.JSONPCallbackPattern = DefaultJSONPCallbackPattern
+	}
 }
 
-func (r *Render) compileTemplates() {
-	if r.opt.Asset == nil || r.opt.AssetNames == nil {
-		r.compileTemplatesFromDir()
-		return
+func (r *Render) usesAssets() bool {
+	return r.opt.Asset != nil && r.opt.AssetNames != nil
+}
+
+// recompileTemplates compiles the templates and replaces the current ones, which are kept if compilation fails.
+func (r *Render) recompileTemplates() error {
+	r.compileLk.Lock()
+	defer r.compileLk.Unlock()
+	return r.compileTemplates()
+}
+
+// compileTemplates does the work of recompileTemplates, with compileLk held (so that the templates replacing the
+// current ones never miss functions registered meanwhile).
+func (r *Render) compileTemplates() error {
+	var templates *template.Template
+	var err error
+	if r.opt.FileSystem != nil {
+		templates, err = r.compileTemplatesFromFS()
+	} else if r.usesAssets() {
+		templates, err = r.compileTemplatesFromAsset()
+	} else {
+		templates, err = r.compileTemplatesFromDir()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Swap in the new set, renders in progress finish with the one they started with.
+	r.templatesLk.Lock()
+	r.templates = &compiledTemplates{set: templates}
+	r.templatesLk.Unlock()
+	return nil
+}
+
+// currentTemplates returns the most recently compiled templates.
+func (r *Render) currentTemplates() *compiledTemplates {
+	r.templatesLk.RLock()
+	defer r.templatesLk.RUnlock()
+	return r.templates
+}
+
+// compiledTemplates is a compiled template set. The set itself is never executed: each render executes a clone of it
+// (clones are kept for reuse), as the functions bound for a render (ie: yield) must not be seen by concurrent renders.
+type compiledTemplates struct {
+	set    *template.Template
+	clones sync.Pool
+}
+
+// get returns a clone of the set for a single render, which must be given back with put once rendered.
+func (c *compiledTemplates) get(r *Render) (*template.Template, error) {
+	if clone, ok := c.clones.Get().(*template.Template); ok {
+		return clone, nil
+	}
+
+	clone, err := c.set.Clone()
+	if err != nil {
+		return nil, err
+	}
+	// Bind the helpers that render other templates from the clone.
+	partial := r.partialFunc(clone)
+	clone.Funcs(template.FuncMap{
+		"partial": partial,
+		"include": partial,
+	})
+	return clone, nil
+}
+
+// put gives back a clone for reuse, unbinding the functions bound for the render (and the binding they hold).
+func (c *compiledTemplates) put(clone *template.Template) {
+	clone.Funcs(layoutHelperFuncs)
+	c.clones.Put(clone)
+}
+
+// partialFunc returns the helper that renders the named template from a set with the given binding,
+// for use as {{ partial "name" . }} (or include).
+func (r *Render) partialFunc(templates *template.Template) func(string, interface{}) (template.HTML, error) {
+	return func(name string, binding interface{}) (template.HTML, error) {
+		if templates.Lookup(name) == nil {
+			return "", fmt.Errorf("partial %q is not defined", name)
+		}
+		return r.execute(templates, name, binding)
 	}
-	r.compileTemplatesFromAsset()
 }
 
-func (r *Render) compileTemplatesFromDir() {
+func (r *Render) compileTemplatesFromDir() (*template.Template, error) {
 	dir := r.opt.Directory
-	r.templates = template.New(dir)
-	r.templates.Delims(r.opt.Delims.Left, r.opt.Delims.Right)
+	templates := template.New(dir)
+	templates.Delims(r.opt.Delims.Left, r.opt.Delims.Right)
 
 	// Walk the supplied directory and compile any files that match our extension list.
-	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+	var errs TemplateErrors
+	loaded := map[string]string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		// fmt.Println("path: ", path)
 		// Fix same-extension-dirs bug: some dir might be named to: "users.tmpl", "local.html"
 		// These dirs should be excluded as they are not valid golang templates, but files under
@@ -179,40 +411,41 @@ func (r *Render) compileTemplatesFromDir() {
 			return err
 		}
 
-		ext := ""
-		if strings.Index(rel, ".") != -1 {
-			ext = filepath.Ext(rel)
-		}
-
-		for _, extension := range r.opt.Extensions {
-			if ext == extension {
-				buf, err := ioutil.ReadFile(path)
-				if err != nil {
-					panic(err)
-				}
-
-				name := (rel[0 : len(rel)-len(ext)])
-				tmpl := r.templates.New(filepath.ToSlash(name))
+		if name, ok := r.templateName(filepath.ToSlash(rel)); ok {
+			if tmplErr := r.checkCollision(loaded, name, path); tmplErr != nil {
+				errs = append(errs, tmplErr)
+				return nil
+			}
 
-				// Add our funcmaps.
-				for _, funcs := range r.opt.Funcs {
-					tmpl.Funcs(funcs)
-				}
+			buf, err := ioutil.ReadFile(path)
+			if err != nil {
+				errs = append(errs, &TemplateError{File: path, Err: err})
+				return nil
+			}
 
-				// Break out if this parsing fails. We don't want any silent server starts.
-				template.Must(tmpl.Funcs(helperFuncs).Parse(string(buf)))
-				break
+			if tmplErr := r.parseTemplate(templates, name, path, buf); tmplErr != nil {
+				errs = append(errs, tmplErr)
 			}
 		}
 		return nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	// Fail if any template could not be loaded. We don't want any silent server starts.
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return templates, nil
 }
 
-func (r *Render) compileTemplatesFromAsset() {
+func (r *Render) compileTemplatesFromAsset() (*template.Template, error) {
 	dir := r.opt.Directory
-	r.templates = template.New(dir)
-	r.templates.Delims(r.opt.Delims.Left, r.opt.Delims.Right)
+	templates := template.New(dir)
+	templates.Delims(r.opt.Delims.Left, r.opt.Delims.Right)
 
+	var errs TemplateErrors
+	loaded := map[string]string{}
 	for _, path := range r.opt.AssetNames() {
 		if !strings.HasPrefix(path, dir) {
 			continue
@@ -220,98 +453,328 @@ func (r *Render) compileTemplatesFromAsset() {
 
 		rel, err := filepath.Rel(dir, path)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 
-		ext := ""
-		if strings.Index(rel, ".") != -1 {
-			ext = "." + strings.Join(strings.Split(rel, ".")[1:], ".")
+		if name, ok := r.templateName(filepath.ToSlash(rel)); ok {
+			if tmplErr := r.checkCollision(loaded, name, path); tmplErr != nil {
+				errs = append(errs, tmplErr)
+				continue
+			}
+
+			buf, err := r.opt.Asset(path)
+			if err != nil {
+				errs = append(errs, &TemplateError{File: path, Err: err})
+				continue
+			}
+
+			if tmplErr := r.parseTemplate(templates, name, path, buf); tmplErr != nil {
+				errs = append(errs, tmplErr)
+			}
 		}
+	}
+	// Fail if any template could not be loaded. We don't want any silent server starts.
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return templates, nil
+}
 
-		for _, extension := range r.opt.Extensions {
-			if ext == extension {
+func (r *Render) compileTemplatesFromFS() (*template.Template, error) {
+	dir := r.opt.Directory
+	templates := template.New(dir)
+	templates.Delims(r.opt.Delims.Left, r.opt.Delims.Right)
+
+	// Walk the directory within the file system (using slash separated paths, unlike compileTemplatesFromDir).
+	dir = path.Clean(dir)
+	var errs TemplateErrors
+	loaded := map[string]string{}
+	err := fs.WalkDir(r.opt.FileSystem, dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
 
-				buf, err := r.opt.Asset(path)
-				if err != nil {
-					panic(err)
-				}
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+		if dir == "." {
+			rel = p
+		}
 
-				name := (rel[0 : len(rel)-len(ext)])
-				tmpl := r.templates.New(filepath.ToSlash(name))
+		if name, ok := r.templateName(rel); ok {
+			if tmplErr := r.checkCollision(loaded, name, p); tmplErr != nil {
+				errs = append(errs, tmplErr)
+				return nil
+			}
 
-				// Add our funcmaps.
-				for _, funcs := range r.opt.Funcs {
-					tmpl.Funcs(funcs)
-				}
+			buf, err := fs.ReadFile(r.opt.FileSystem, p)
+			if err != nil {
+				errs = append(errs, &TemplateError{File: p, Err: err})
+				return nil
+			}
 
-				// Break out if this parsing fails. We don't want any silent server starts.
-				template.Must(tmpl.Funcs(helperFuncs).Parse(string(buf)))
-				break
+			if tmplErr := r.parseTemplate(templates, name, p, buf); tmplErr != nil {
+				errs = append(errs, tmplErr)
 			}
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Fail if any template could not be loaded. We don't want any silent server starts.
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return templates, nil
+}
+
+// templateName returns the name of the template in the file at rel (a slash separated path, relative to the template
+// directory), and whether the file has one of the template extensions. Extensions are matched regardless of case on
+// the file name, and the longest matching one is trimmed: with [".tmpl"], "users.partial.TMPL" is "users.partial"
+// whether it is loaded from the directory, assets or a file system.
+func (r *Render) templateName(rel string) (string, bool) {
+	base := path.Base(rel)
+	ext := ""
+	for _, extension := range r.opt.Extensions {
+		if len(extension) > len(ext) && len(base) > len(extension) && strings.EqualFold(base[len(base)-len(extension):], extension) {
+			ext = extension
+		}
+	}
+	if len(ext) == 0 {
+		return "", false
+	}
+	return rel[:len(rel)-len(ext)], true
+}
+
+// checkCollision records that the named template is loaded from the file at path, returning an error if another file
+// (in loaded, by template name) was already given the name and collisions are not only warned about.
+func (r *Render) checkCollision(loaded map[string]string, name, path string) *TemplateError {
+	other, collides := loaded[name]
+	loaded[name] = path
+	if !collides {
+		return nil
+	}
+
+	err := &TemplateCollisionError{Name: name, Files: []string{other, path}}
+	if r.opt.TemplateCollisions == TemplateCollisionsWarn {
+		log.Printf("render: %v, using %s", err, path)
+		return nil
+	}
+	return &TemplateError{File: path, Err: err}
+}
+
+// parseTemplate adds the template read from a file to a set, returning the error if it fails to parse.
+func (r *Render) parseTemplate(templates *template.Template, name, path string, buf []byte) *TemplateError {
+	tmpl := templates.New(name)
+
+	// Add our funcmaps, then those registered since construction.
+	for _, funcs := range r.opt.Funcs {
+		tmpl.Funcs(funcs)
+	}
+	tmpl.Funcs(r.funcs)
+
+	if _, err := tmpl.Funcs(helperFuncs).Parse(string(buf)); err != nil {
+		return newTemplateError(path, err)
 	}
+	return nil
 }
 
 // TemplateLookup is a wrapper around template.Lookup and returns
 // the template with the given name that is associated with t, or nil
-// if there is no such template
+// if there is no such template. The template must not be executed
+// (templates can no longer be rendered once it has been), use HTML instead.
 func (r *Render) TemplateLookup(t string) *template.Template {
-	return r.templates.Lookup(t)
+	return r.currentTemplates().set.Lookup(t)
 }
 
-func (r *Render) execute(name string, binding interface{}) (*bytes.Buffer, error) {
-	buf := new(bytes.Buffer)
-	return buf, r.templates.ExecuteTemplate(buf, name, binding)
+// execute renders a template from a set into a buffer borrowed from the pool, for templates rendered inside others.
+func (r *Render) execute(templates *template.Template, name string, binding interface{}) (template.HTML, error) {
+	buf := r.bufPool.Get()
+	defer r.bufPool.Put(buf)
+	err := templates.ExecuteTemplate(buf, name, binding)
+	// Return safe HTML here since we are rendering our own template.
+	return template.HTML(buf.String()), err
 }
 
-func (r *Render) addLayoutFuncs(name string, binding interface{}) {
+// Close stops watching the template directory, if WatchTemplates is set.
+func (r *Render) Close() error {
+	if r.watcher != nil {
+		r.watcher.Stop()
+	}
+	return nil
+}
+
+// Default yield, block and current helpers, which clones are given back with (see compiledTemplates.put).
+var layoutHelperFuncs = template.FuncMap{
+	"yield":   helperFuncs["yield"],
+	"block":   helperFuncs["block"],
+	"current": helperFuncs["current"],
+}
+
+// addLayoutFuncs binds the yield, block and current helpers of a clone for rendering the named template in layouts.
+func (r *Render) addLayoutFuncs(templates *template.Template, name string, binding interface{}, layouts []string) {
+	// Templates from innermost (the rendered template) to outermost layout, which is executed first.
+	// Each call to yield renders the template one level in from the one being executed.
+	chain := append([]string{name}, layouts...)
+	level := len(chain) - 1
+
 	funcs := template.FuncMap{
 		"yield": func() (template.HTML, error) {
-			buf, err := r.execute(name, binding)
-			// Return safe HTML here since we are rendering our own template.
-			return template.HTML(buf.String()), err
+			if level == 0 {
+				return "", fmt.Errorf("yield called outside of a layout")
+			}
+			level--
+			html, err := r.execute(templates, chain[level], binding)
+			level++
+			return html, err
 		},
 		"current": func() (string, error) {
 			return name, nil
 		},
 		"block": func(blockName string) (template.HTML, error) {
 			fullBlockName := fmt.Sprintf("%s-%s", blockName, name)
-			if r.opt.RequireBlocks || r.TemplateLookup(fullBlockName) != nil {
-				buf, err := r.execute(fullBlockName, binding)
-				// Return safe HTML here since we are rendering our own template.
-				return template.HTML(buf.String()), err
+			if r.opt.RequireBlocks || templates.Lookup(fullBlockName) != nil {
+				return r.execute(templates, fullBlockName, binding)
 			}
 			return "", nil
 		},
 	}
-	if tpl := r.templates.Lookup(name); tpl != nil {
-		tpl.Funcs(funcs)
-	}
+	templates.Funcs(funcs)
 }
 
 func (r *Render) prepareHTMLOptions(htmlOpt []HTMLOptions) HTMLOptions {
-	if len(htmlOpt) > 0 {
+	if len(htmlOpt) > 0 && (len(htmlOpt[0].Layout) > 0 || htmlOpt[0].Layouts != nil || htmlOpt[0].Headers == nil) {
 		return htmlOpt[0]
 	}
 
-	return HTMLOptions{
-		Layout: r.opt.Layout,
+	opt := HTMLOptions{
+		Layout:  r.opt.Layout,
+		Layouts: r.opt.Layouts,
+	}
+	if len(htmlOpt) > 0 {
+		opt.Headers = htmlOpt[0].Headers
 	}
+	return opt
 }
 
 // Render is the generic function called by XML, JSON, Data, HTML, and can be called by custom implementations.
 func (r *Render) Render(w http.ResponseWriter, e Engine, data interface{}) {
-	err := e.Render(w, data)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	// Binary data is usually compressed already, so is always sent as is.
+	out := w
+	if _, isData := e.(Data); !isData {
+		if cw := r.compressWriter(w); cw != nil {
+			defer cw.Close()
+			out = cw
+		}
+	}
+
+	hw := &headerWriter{ResponseWriter: out}
+	var err error
+	if ew := r.etagWriter(hw, e); ew != nil {
+		if err = e.Render(ew, data); err == nil {
+			ifNoneMatch := ""
+			if rw, ok := w.(*requestWriter); ok {
+				ifNoneMatch = rw.ifNoneMatch
+			}
+			ew.writeTo(hw, ifNoneMatch)
+		}
+	} else {
+		err = e.Render(hw, data)
+	}
+	if err == nil {
+		return
+	}
+
+	// The status line has already been sent (ie: for streamed responses), so the error can only be logged
+	// (and, in development, appended to the partial response).
+	if hw.wroteHeader {
+		log.Printf("render: %v", err)
+		if r.opt.IsDevelopment {
+			fmt.Fprintln(out, err.Error())
+		}
+		return
+	}
+
+	status := http.StatusInternalServerError
+	if h, ok := e.(interface {
+		head() Head
+	}); ok && h.head().Status >= 400 {
+		status = h.head().Status
+	}
+	// The callback is part of the request, not a failure of the server.
+	if _, ok := err.(*InvalidCallbackError); ok {
+		status = http.StatusBadRequest
+	}
+	r.renderError(w, status, err)
+}
+
+// renderError writes the error response for a failed render, using the ErrorHTML template outside of development.
+func (r *Render) renderError(w http.ResponseWriter, status int, err error) {
+	if r.opt.IsDevelopment || len(r.opt.ErrorHTML) == 0 {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	out := r.bufPool.Get()
+	defer r.bufPool.Put(out)
+	data := ErrorData{Error: err, Status: status}
+	var binding interface{} = data
+	if r.opt.ErrorBinding != nil {
+		binding = r.opt.ErrorBinding(data)
+	}
+	compiled := r.currentTemplates()
+	templates, tmplErr := compiled.get(r)
+	if tmplErr == nil {
+		defer compiled.put(templates)
+		tmplErr = templates.ExecuteTemplate(out, r.opt.ErrorHTML, binding)
+	}
+	if tmplErr != nil {
+		log.Printf("render: failed to render error template %q: %v", r.opt.ErrorHTML, tmplErr)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	w.Header().Set(ContentType, r.opt.HTMLContentType+r.compiledCharset)
+	w.WriteHeader(status)
+	out.WriteTo(w)
+}
+
+// headerWriter records whether the status line of a response has been written.
+type headerWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (hw *headerWriter) WriteHeader(status int) {
+	hw.wroteHeader = true
+	hw.ResponseWriter.WriteHeader(status)
+}
+
+func (hw *headerWriter) Write(b []byte) (int, error) {
+	hw.wroteHeader = true
+	return hw.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, so streamed responses can still be flushed.
+func (hw *headerWriter) Flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
 	}
 }
 
 // Data writes out the raw bytes as binary data.
-func (r *Render) Data(w http.ResponseWriter, status int, v []byte) {
+func (r *Render) Data(w http.ResponseWriter, status int, v []byte, opts ...ContentTypeOption) {
 	head := Head{
-		ContentType: ContentBinary,
+		ContentType: r.contentType(ContentBinary, false, opts),
 		Status:      status,
+		Headers:     headersOption(opts),
+	}
+
+	// Data responses use a Content-Type already set on the response, unless it is overridden for this call.
+	if len(opts) > 0 && len(opts[0].ContentType) > 0 {
+		w.Header().Set(ContentType, head.ContentType)
 	}
 
 	d := Data{
@@ -321,39 +784,82 @@ func (r *Render) Data(w http.ResponseWriter, status int, v []byte) {
 	r.Render(w, d, v)
 }
 
-// HTML builds up the response from the specified template and bindings.
-func (r *Render) HTML(w http.ResponseWriter, status int, name string, binding interface{}, htmlOpt ...HTMLOptions) {
-	// If we are in development mode, recompile the templates on every HTML request.
-	if r.opt.IsDevelopment {
-		r.compileTemplates()
+// prepareHTML gets a clone of the compiled templates to render the named template with, in its layouts if there are
+// any, returning the clone, the name of the template to execute and whether it is a layout.
+// The clone must be given back with compiled.put once rendered.
+func (r *Render) prepareHTML(name string, binding interface{}, htmlOpt []HTMLOptions) (*compiledTemplates, *template.Template, string, bool, error) {
+	// If we are in development mode, recompile the templates on every HTML render (unless they are being watched).
+	if r.opt.IsDevelopment && r.watcher == nil {
+		if err := r.recompileTemplates(); err != nil {
+			return nil, nil, "", false, err
+		}
+	}
+
+	// Render from a clone of a single compiled set, even if the templates are recompiled meanwhile.
+	compiled := r.currentTemplates()
+	templates, err := compiled.get(r)
+	if err != nil {
+		return nil, nil, "", false, err
 	}
 
 	opt := r.prepareHTMLOptions(htmlOpt)
-	// Assign a layout if there is one.
-	if len(opt.Layout) > 0 {
-		r.addLayoutFuncs(name, binding)
-		name = opt.Layout
+	// Assign layouts if there are any, rendering from the outermost one.
+	layouts := opt.layoutChain()
+	if len(layouts) > 0 {
+		r.addLayoutFuncs(templates, name, binding, layouts)
+		name = layouts[len(layouts)-1]
+	}
+	return compiled, templates, name, len(layouts) > 0, nil
+}
+
+// HTML builds up the response from the specified template and bindings.
+func (r *Render) HTML(w http.ResponseWriter, status int, name string, binding interface{}, htmlOpt ...HTMLOptions) {
+	compiled, templates, name, inLayout, err := r.prepareHTML(name, binding, htmlOpt)
+	if err != nil {
+		r.renderError(w, http.StatusInternalServerError, err)
+		return
 	}
+	defer compiled.put(templates)
 
 	head := Head{
 		ContentType: r.opt.HTMLContentType + r.compiledCharset,
 		Status:      status,
+		Headers:     r.prepareHTMLOptions(htmlOpt).Headers,
 	}
 
 	h := HTML{
-		Head:      head,
-		Name:      name,
-		Templates: r.templates,
+		Head:       head,
+		Name:       name,
+		Templates:  templates,
+		BufferPool: r.bufPool,
+		Streaming:  r.opt.StreamingHTML && !inLayout,
 	}
 
 	r.Render(w, h, binding)
 }
 
+// HTMLBytes renders the specified template and bindings (in the same layouts HTML would) into a byte slice,
+// for output that isn't an HTTP response (ie: emails). It is safe to call concurrently with HTML and other renders.
+func (r *Render) HTMLBytes(name string, binding interface{}, htmlOpt ...HTMLOptions) ([]byte, error) {
+	compiled, templates, name, _, err := r.prepareHTML(name, binding, htmlOpt)
+	if err != nil {
+		return nil, err
+	}
+	defer compiled.put(templates)
+
+	html, err := r.execute(templates, name, binding)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(html), nil
+}
+
 // JSON marshals the given interface object and writes the JSON response.
-func (r *Render) JSON(w http.ResponseWriter, status int, v interface{}) {
+func (r *Render) JSON(w http.ResponseWriter, status int, v interface{}, opts ...ContentTypeOption) {
 	head := Head{
-		ContentType: ContentJSON + r.compiledCharset,
+		ContentType: r.contentType(ContentJSON, true, opts),
 		Status:      status,
+		Headers:     headersOption(opts),
 	}
 
 	j := JSON{
@@ -367,27 +873,47 @@ func (r *Render) JSON(w http.ResponseWriter, status int, v interface{}) {
 	r.Render(w, j, v)
 }
 
+// JSONBytes marshals the given interface object into the body a JSON response would have, with the same
+// indentation, prefix and HTML escaping (as a buffered response, even if StreamingJSON is set).
+func (r *Render) JSONBytes(v interface{}) ([]byte, error) {
+	j := JSON{
+		Indent:       r.opt.IndentJSON,
+		Prefix:       r.opt.PrefixJSON,
+		UnEscapeHTML: r.opt.UnEscapeHTML,
+	}
+
+	return j.marshal(v)
+}
+
 // JSONP marshals the given interface object and writes the JSON response.
-func (r *Render) JSONP(w http.ResponseWriter, status int, callback string, v interface{}) {
+func (r *Render) JSONP(w http.ResponseWriter, status int, callback string, v interface{}, opts ...ContentTypeOption) {
 	head := Head{
-		ContentType: ContentJSONP + r.compiledCharset,
+		ContentType: r.contentType(ContentJSONP, true, opts),
 		Status:      status,
+		Headers:     headersOption(opts),
 	}
 
 	j := JSONP{
-		Head:     head,
-		Indent:   r.opt.IndentJSON,
-		Callback: callback,
+		Head:            head,
+		Indent:          r.opt.IndentJSON,
+		Callback:        callback,
+		CallbackPattern: r.opt.JSONPCallbackPattern,
 	}
 
 	r.Render(w, j, v)
 }
 
 // Text writes out a string as plain text.
-func (r *Render) Text(w http.ResponseWriter, status int, v string) {
+func (r *Render) Text(w http.ResponseWriter, status int, v string, opts ...ContentTypeOption) {
 	head := Head{
-		ContentType: ContentText + r.compiledCharset,
+		ContentType: r.contentType(ContentText, true, opts),
 		Status:      status,
+		Headers:     headersOption(opts),
+	}
+
+	// Text responses use a Content-Type already set on the response, unless it is overridden for this call.
+	if len(opts) > 0 && len(opts[0].ContentType) > 0 {
+		w.Header().Set(ContentType, head.ContentType)
 	}
 
 	t := Text{
@@ -398,10 +924,11 @@ func (r *Render) Text(w http.ResponseWriter, status int, v string) {
 }
 
 // XML marshals the given interface object and writes the XML response.
-func (r *Render) XML(w http.ResponseWriter, status int, v interface{}) {
+func (r *Render) XML(w http.ResponseWriter, status int, v interface{}, opts ...ContentTypeOption) {
 	head := Head{
-		ContentType: ContentXML + r.compiledCharset,
+		ContentType: r.contentType(ContentXML, true, opts),
 		Status:      status,
+		Headers:     headersOption(opts),
 	}
 
 	x := XML{
@@ -412,3 +939,64 @@ func (r *Render) XML(w http.ResponseWriter, status int, v interface{}) {
 
 	r.Render(w, x, v)
 }
+
+// XMLBytes marshals the given interface object into the body an XML response would have, with the same
+// indentation and prefix.
+func (r *Render) XMLBytes(v interface{}) ([]byte, error) {
+	x := XML{
+		Indent: r.opt.IndentXML,
+		Prefix: r.opt.PrefixXML,
+	}
+
+	return x.marshal(v)
+}
+
+// YAML marshals the given interface object and writes the YAML response.
+func (r *Render) YAML(w http.ResponseWriter, status int, v interface{}, opts ...ContentTypeOption) {
+	head := Head{
+		ContentType: r.contentType(ContentYAML, true, opts),
+		Status:      status,
+		Headers:     headersOption(opts),
+	}
+
+	y := YAML{
+		Head:   head,
+		Indent: r.opt.IndentYAML,
+		Prefix: r.opt.PrefixYAML,
+	}
+
+	r.Render(w, y, v)
+}
+
+// Status writes a response with the given status and an empty body (ie: 202 Accepted). Only the Headers of the
+// option are used. It is written directly to w, as there is nothing to compress or tag.
+func (r *Render) Status(w http.ResponseWriter, status int, opts ...ContentTypeOption) {
+	e := Empty{
+		Head: Head{
+			Status:  status,
+			Headers: headersOption(opts),
+		},
+	}
+
+	e.Render(w, nil)
+}
+
+// NoContent writes a 204 No Content response (ie: for a DELETE), without a body or Content-Type.
+func (r *Render) NoContent(w http.ResponseWriter, opts ...ContentTypeOption) {
+	r.Status(w, http.StatusNoContent, opts...)
+}
+
+// MsgPack marshals the given interface object and writes the MessagePack response.
+func (r *Render) MsgPack(w http.ResponseWriter, status int, v interface{}, opts ...ContentTypeOption) {
+	head := Head{
+		ContentType: r.contentType(ContentMsgPack, false, opts),
+		Status:      status,
+		Headers:     headersOption(opts),
+	}
+
+	m := MsgPack{
+		Head: head,
+	}
+
+	r.Render(w, m, v)
+}
@@ -0,0 +1,106 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type Greeting struct {
+	One string `json:"one" xml:"one"`
+}
+
+func renderAuto(render *Render, accept, htmlName string) *httptest.ResponseRecorder {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		render.Auto(w, req, http.StatusOK, Greeting{"hello"}, htmlName)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	if len(accept) > 0 {
+		req.Header.Set("Accept", accept)
+	}
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestAutoJSON(t *testing.T) {
+	render := New(Options{Directory: "fixtures/basic"})
+
+	res := renderAuto(render, "application/json", "hello")
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ContentType), ContentJSON+"; charset=UTF-8")
+	expect(t, res.Header().Get("Vary"), "Accept")
+	expect(t, res.Body.String(), `{"one":"hello"}`)
+}
+
+func TestAutoXML(t *testing.T) {
+	render := New(Options{Directory: "fixtures/basic"})
+
+	res := renderAuto(render, "text/xml", "hello")
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ContentType), ContentXML+"; charset=UTF-8")
+	expect(t, res.Body.String(), "<Greeting><one>hello</one></Greeting>")
+}
+
+func TestAutoHTML(t *testing.T) {
+	render := New(Options{Directory: "fixtures/basic"})
+
+	res := renderAuto(render, "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", "hello")
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ContentType), ContentHTML+"; charset=UTF-8")
+	expect(t, strings.HasPrefix(res.Body.String(), "<h1>Hello"), true)
+}
+
+func TestAutoQualityValues(t *testing.T) {
+	render := New(Options{Directory: "fixtures/basic"})
+
+	res := renderAuto(render, "application/json;q=0.5, application/xml;q=0.9", "")
+	expect(t, res.Header().Get(ContentType), ContentXML+"; charset=UTF-8")
+
+	// A quality of 0 refuses the type, even if it matches a wildcard
+	res = renderAuto(render, "*/*, application/json;q=0", "")
+	expect(t, res.Header().Get(ContentType), ContentXML+"; charset=UTF-8")
+}
+
+func TestAutoWildcard(t *testing.T) {
+	render := New(Options{Directory: "fixtures/basic"})
+
+	res := renderAuto(render, "*/*", "hello")
+	expect(t, res.Header().Get(ContentType), ContentJSON+"; charset=UTF-8")
+
+	res = renderAuto(render, "text/*", "")
+	expect(t, res.Header().Get(ContentType), ContentXML+"; charset=UTF-8")
+}
+
+func TestAutoDefault(t *testing.T) {
+	render := New(Options{
+		Directory:   "fixtures/basic",
+		AutoDefault: ContentXML,
+	})
+
+	res := renderAuto(render, "", "hello")
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ContentType), ContentXML+"; charset=UTF-8")
+
+	res = renderAuto(render, "*/*", "hello")
+	expect(t, res.Header().Get(ContentType), ContentXML+"; charset=UTF-8")
+}
+
+func TestAutoNotAcceptable(t *testing.T) {
+	render := New(Options{
+		Directory:      "fixtures/basic",
+		AutoMediaTypes: []string{ContentJSON},
+	})
+
+	res := renderAuto(render, "text/xml, image/*", "hello")
+	expect(t, res.Code, http.StatusNotAcceptable)
+
+	// HTML is only acceptable when a template is given
+	res = renderAuto(New(Options{Directory: "fixtures/basic"}), "text/html", "")
+	expect(t, res.Code, http.StatusNotAcceptable)
+}
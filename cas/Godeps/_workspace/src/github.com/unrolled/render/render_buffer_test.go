@@ -0,0 +1,37 @@
+package render
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBufferPoolSize(t *testing.T) {
+	expect(t, cap(New().bufPool.c), 64)
+	expect(t, cap(New(Options{BufferPoolSize: 2}).bufPool.c), 2)
+}
+
+func TestBufferPoolPerRender(t *testing.T) {
+	small := New(Options{
+		Directory:      "fixtures/basic",
+		BufferPoolSize: 1,
+	})
+	large := New(Options{
+		Directory:      "fixtures/basic",
+		BufferPoolSize: 8,
+	})
+	expect(t, small.bufPool != large.bufPool, true)
+
+	// Buffers used to render with one are returned to its own pool
+	res := renderHTMLBody(large, "hello", "gophers")
+	expect(t, res.Code, http.StatusOK)
+	expect(t, len(large.bufPool.c), 1)
+	expect(t, len(small.bufPool.c), 0)
+
+	// And each pool keeps no more buffers than its size
+	for i := 0; i < 3; i++ {
+		small.bufPool.Put(small.bufPool.Get())
+		small.bufPool.Put(NewBufferPool(1).Get())
+	}
+	expect(t, len(small.bufPool.c), 1)
+	expect(t, len(large.bufPool.c), 1)
+}
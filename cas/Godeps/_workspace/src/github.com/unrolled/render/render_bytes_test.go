@@ -0,0 +1,93 @@
+package render
+
+import (
+	"net/http"
+	"testing"
+)
+
+type bytesFixture struct {
+	One   string   `json:"one" xml:"one"`
+	Two   []string `json:"two" xml:"two"`
+	Three Greeting `json:"three" xml:"three"`
+}
+
+var bytesValue = bytesFixture{"<hello> & goodbye", []string{"a", "b"}, Greeting{"world"}}
+
+func TestJSONBytesMatchResponse(t *testing.T) {
+	for _, opt := range []Options{
+		{},
+		{IndentJSON: true},
+		{PrefixJSON: []byte(")]}',\n")},
+		{UnEscapeHTML: true},
+		{IndentJSON: true, PrefixJSON: []byte("while(1);"), UnEscapeHTML: true},
+	} {
+		render := New(opt)
+
+		out, err := render.JSONBytes(bytesValue)
+		expect(t, err, nil)
+		res := serveRender(func(w http.ResponseWriter) { render.JSON(w, http.StatusOK, bytesValue) })
+		expect(t, string(out), res.Body.String())
+	}
+
+	out, _ := New(Options{UnEscapeHTML: true, PrefixJSON: []byte("while(1);")}).JSONBytes(bytesValue)
+	expect(t, string(out), `while(1);{"one":"<hello> & goodbye","two":["a","b"],"three":{"one":"world"}}`)
+}
+
+func TestJSONBytesStreaming(t *testing.T) {
+	render := New(Options{
+		StreamingJSON: true,
+	})
+
+	// Bytes are always marshaled as for a buffered response
+	out, err := render.JSONBytes(Greeting{"hello"})
+	expect(t, err, nil)
+	expect(t, string(out), `{"one":"hello"}`)
+}
+
+func TestXMLBytesMatchResponse(t *testing.T) {
+	for _, opt := range []Options{
+		{},
+		{IndentXML: true},
+		{PrefixXML: []byte("<?xml version='1.0' encoding='UTF-8'?>\n")},
+		{IndentXML: true, PrefixXML: []byte("<?xml version='1.0'?>")},
+	} {
+		render := New(opt)
+
+		out, err := render.XMLBytes(bytesValue)
+		expect(t, err, nil)
+		res := serveRender(func(w http.ResponseWriter) { render.XML(w, http.StatusOK, bytesValue) })
+		expect(t, string(out), res.Body.String())
+	}
+
+	out, _ := New(Options{IndentXML: true}).XMLBytes(Greeting{"hello"})
+	expect(t, string(out), "<Greeting>\n  <one>hello</one>\n</Greeting>\n")
+}
+
+func TestBytesErrors(t *testing.T) {
+	render := New(Options{
+		PrefixJSON: []byte("while(1);"),
+		PrefixXML:  []byte("<?xml version='1.0'?>"),
+	})
+
+	out, err := render.JSONBytes(map[string]interface{}{"fn": func() {}})
+	expect(t, out == nil, true)
+	expect(t, err != nil, true)
+
+	out, err = render.XMLBytes(map[string]string{"one": "hello"})
+	expect(t, out == nil, true)
+	expect(t, err != nil, true)
+}
+
+func TestBytesKeepOptions(t *testing.T) {
+	prefix := []byte("while(1);")
+	render := New(Options{
+		PrefixJSON: prefix,
+	})
+
+	// Marshaling to bytes doesn't write into the configured prefix
+	out, _ := render.JSONBytes(Greeting{"hello"})
+	out[0] = 'W'
+	out, _ = render.JSONBytes(Greeting{"hello"})
+	expect(t, string(out), `while(1);{"one":"hello"}`)
+	expect(t, string(prefix), "while(1);")
+}
@@ -0,0 +1,165 @@
+package render
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Large enough to be compressed with the default CompressionMinSize
+var largeBody = strings.Repeat("gophers ", 256)
+
+func renderCompressed(render *Render, acceptEncoding string, f func(w http.ResponseWriter)) *httptest.ResponseRecorder {
+	h := render.Compress(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		f(w)
+	}))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	if len(acceptEncoding) > 0 {
+		req.Header.Set(AcceptEncoding, acceptEncoding)
+	}
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func decompressedBody(t *testing.T, res *httptest.ResponseRecorder) string {
+	var reader io.Reader
+	switch res.Header().Get(ContentEncoding) {
+	case "gzip":
+		gz, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(res.Body)
+	default:
+		t.Fatalf("Unexpected Content-Encoding %q", res.Header().Get(ContentEncoding))
+	}
+
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+func TestCompressHTMLGzip(t *testing.T) {
+	render := New(Options{
+		Directory:   "fixtures/basic",
+		Compression: CompressionDefault,
+	})
+
+	res := renderCompressed(render, "gzip, deflate", func(w http.ResponseWriter) {
+		// A length set for the uncompressed body must not be sent
+		w.Header().Set(ContentLength, "2071")
+		render.HTML(w, http.StatusCreated, "hello", largeBody)
+	})
+
+	expect(t, res.Code, http.StatusCreated)
+	expect(t, res.Header().Get(ContentEncoding), "gzip")
+	expect(t, res.Header().Get(Vary), AcceptEncoding)
+	expect(t, res.Header().Get(ContentLength), "")
+	expect(t, res.Header().Get(ContentType), ContentHTML+"; charset=UTF-8")
+	expect(t, decompressedBody(t, res), "<h1>Hello "+largeBody+"</h1>\n")
+}
+
+func TestCompressJSONDeflate(t *testing.T) {
+	render := New(Options{
+		Compression: CompressionBestCompression,
+	})
+
+	res := renderCompressed(render, "gzip;q=0.5, deflate", func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusOK, Greeting{largeBody})
+	})
+
+	expect(t, res.Header().Get(ContentEncoding), "deflate")
+	expect(t, res.Header().Get(Vary), AcceptEncoding)
+	expect(t, decompressedBody(t, res), `{"one":"`+largeBody+`"}`)
+}
+
+func TestCompressStreaming(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		Compression:   CompressionBestSpeed,
+		StreamingHTML: true,
+		StreamingJSON: true,
+	})
+
+	res := renderCompressed(render, "gzip", func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "hello", largeBody)
+	})
+	expect(t, res.Header().Get(ContentEncoding), "gzip")
+	expect(t, decompressedBody(t, res), "<h1>Hello "+largeBody+"</h1>\n")
+
+	res = renderCompressed(render, "gzip", func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusOK, Greeting{largeBody})
+	})
+	expect(t, res.Header().Get(ContentEncoding), "gzip")
+	expect(t, decompressedBody(t, res), `{"one":"`+largeBody+`"}`+"\n")
+}
+
+func TestCompressSkipsSmallResponses(t *testing.T) {
+	render := New(Options{
+		Directory:   "fixtures/basic",
+		Compression: CompressionDefault,
+	})
+
+	res := renderCompressed(render, "gzip", func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "hello", "gophers")
+	})
+
+	expect(t, res.Header().Get(ContentEncoding), "")
+	expect(t, res.Header().Get(Vary), AcceptEncoding)
+	expect(t, res.Body.String(), "<h1>Hello gophers</h1>\n")
+}
+
+func TestCompressNotAccepted(t *testing.T) {
+	render := New(Options{
+		Directory:   "fixtures/basic",
+		Compression: CompressionDefault,
+	})
+
+	for _, acceptEncoding := range []string{"", "identity", "gzip;q=0, br"} {
+		res := renderCompressed(render, acceptEncoding, func(w http.ResponseWriter) {
+			render.HTML(w, http.StatusOK, "hello", largeBody)
+		})
+
+		expect(t, res.Header().Get(ContentEncoding), "")
+		expect(t, res.Header().Get(Vary), AcceptEncoding)
+		expect(t, res.Body.String(), "<h1>Hello "+largeBody+"</h1>\n")
+	}
+}
+
+func TestCompressSkipsData(t *testing.T) {
+	render := New(Options{
+		Compression: CompressionDefault,
+	})
+
+	res := renderCompressed(render, "gzip", func(w http.ResponseWriter) {
+		render.Data(w, http.StatusOK, []byte(largeBody))
+	})
+
+	expect(t, res.Header().Get(ContentEncoding), "")
+	expect(t, res.Body.String(), largeBody)
+}
+
+func TestCompressOff(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+	})
+
+	res := renderCompressed(render, "gzip", func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "hello", largeBody)
+	})
+
+	expect(t, res.Header().Get(ContentEncoding), "")
+	expect(t, res.Header().Get(Vary), "")
+	expect(t, res.Body.String(), "<h1>Hello "+largeBody+"</h1>\n")
+}
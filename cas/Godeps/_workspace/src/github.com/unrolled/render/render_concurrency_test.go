@@ -0,0 +1,138 @@
+package render
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// hammerHTML renders from many goroutines at once, checking each response with the expected body for its binding.
+func hammerHTML(t *testing.T, render *Render, name string, binding func(i int) interface{}, expected func(i int) string, htmlOpt ...HTMLOptions) {
+	var wg sync.WaitGroup
+	start := make(chan struct{})
+	for g := 0; g < 32; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			<-start
+			for j := 0; j < 50; j++ {
+				i := g*100 + j
+				res := renderHTMLBody(render, name, binding(i), htmlOpt...)
+				if res.Code != http.StatusOK || res.Body.String() != expected(i) {
+					t.Errorf("Expected %q, got %d %q", expected(i), res.Code, res.Body.String())
+				}
+			}
+		}(g)
+	}
+	close(start)
+	wg.Wait()
+}
+
+// yieldingName gives way to other goroutines when it is rendered, so that renders interleave.
+type yieldingName string
+
+func (n yieldingName) String() string {
+	runtime.Gosched()
+	return string(n)
+}
+
+func gopher(i int) interface{} {
+	return yieldingName(fmt.Sprintf("gopher %d", i))
+}
+
+func TestHTMLConcurrentDevelopment(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		Layout:        "layout",
+		IsDevelopment: true,
+	})
+
+	hammerHTML(t, render, "content", gopher, func(i int) string {
+		return fmt.Sprintf("head\n<h1>gopher %d</h1>\n\nfoot\n", i)
+	})
+}
+
+func TestHTMLConcurrentLayouts(t *testing.T) {
+	render := New(Options{
+		FileSystem: fstest.MapFS{
+			"templates/layout.tmpl":  {Data: []byte("<title>{{ . }}</title>{{ yield }}")},
+			"templates/section.tmpl": {Data: []byte("<section>{{ . }}</section>{{ yield }}")},
+			"templates/content.tmpl": {Data: []byte("<h1>{{ . }}</h1>")},
+		},
+		Layouts: []string{"section", "layout"},
+	})
+
+	// Each render's yield renders its own binding, even while other renders are in progress
+	hammerHTML(t, render, "content", gopher, func(i int) string {
+		return fmt.Sprintf("<title>gopher %d</title><section>gopher %d</section><h1>gopher %d</h1>", i, i, i)
+	})
+
+	// Renders without a layout don't see the layout of earlier renders
+	expect(t, renderHTMLBody(render, "content", "gophers", HTMLOptions{Layouts: []string{}}).Body.String(), "<h1>gophers</h1>")
+	expect(t, renderHTMLBody(render, "layout", "gophers", HTMLOptions{Layouts: []string{}}).Code, http.StatusInternalServerError)
+}
+
+func TestHTMLBytesConcurrentWithHTML(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		Layouts:       []string{"section_layout", "layout"},
+		IsDevelopment: true,
+	})
+	expected := func(i int) string {
+		return fmt.Sprintf("head\nsection content\n<h1>gopher %d</h1>\n\nend section\n\nfoot\n", i)
+	}
+
+	// Renders to bytes and to responses don't see each other's bindings (or layouts) while the templates are recompiled
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		hammerHTML(t, render, "content", gopher, expected)
+	}()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				i := g*100 + j
+				out, err := render.HTMLBytes("content", gopher(i))
+				if err != nil || string(out) != expected(i) {
+					t.Errorf("Expected %q, got %q (%v)", expected(i), out, err)
+				}
+				out, err = render.HTMLBytes("content", gopher(i), HTMLOptions{Layouts: []string{}})
+				if err != nil || string(out) != fmt.Sprintf("<h1>gopher %d</h1>\n", i) {
+					t.Errorf("Expected content alone, got %q (%v)", out, err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	<-done
+}
+
+func TestHTMLConcurrentPartialsWhileRecompiling(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/partials",
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			if err := render.recompileTemplates(); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+
+	hammerHTML(t, render, "page", func(i int) interface{} {
+		return map[string]string{"Title": fmt.Sprintf("Page %d", i), "Href": fmt.Sprintf("/%d", i)}
+	}, func(i int) string {
+		return fmt.Sprintf("<main><nav>Page %d <a href=\"/%d\">/%d</a></nav></main>\n", i, i, i)
+	})
+	<-done
+}
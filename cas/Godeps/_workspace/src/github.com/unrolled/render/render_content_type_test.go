@@ -0,0 +1,80 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serveRender(f func(w http.ResponseWriter)) *httptest.ResponseRecorder {
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		f(w)
+	}).ServeHTTP(res, req)
+	return res
+}
+
+func TestContentTypeDefaults(t *testing.T) {
+	render := New(Options{
+		Charset: "ISO-8859-1",
+	})
+
+	res := serveRender(func(w http.ResponseWriter) { render.JSON(w, http.StatusOK, Greeting{"hello"}) })
+	expect(t, res.Header().Get(ContentType), ContentJSON+"; charset=ISO-8859-1")
+
+	res = serveRender(func(w http.ResponseWriter) { render.XML(w, http.StatusOK, Greeting{"hello"}) })
+	expect(t, res.Header().Get(ContentType), ContentXML+"; charset=ISO-8859-1")
+
+	res = serveRender(func(w http.ResponseWriter) { render.Data(w, http.StatusOK, []byte("hello")) })
+	expect(t, res.Header().Get(ContentType), ContentBinary)
+
+	// Empty options keep the defaults
+	res = serveRender(func(w http.ResponseWriter) { render.JSON(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{}) })
+	expect(t, res.Header().Get(ContentType), ContentJSON+"; charset=ISO-8859-1")
+}
+
+func TestContentTypeOverride(t *testing.T) {
+	render := New()
+
+	res := serveRender(func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{ContentType: "application/vnd.myapp+json"})
+	})
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ContentType), "application/vnd.myapp+json; charset=UTF-8")
+	expect(t, res.Body.String(), `{"one":"hello"}`)
+
+	res = serveRender(func(w http.ResponseWriter) {
+		render.XML(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{ContentType: "application/vnd.myapp+xml", NoCharset: true})
+	})
+	expect(t, res.Header().Get(ContentType), "application/vnd.myapp+xml")
+	expect(t, res.Body.String(), "<Greeting><one>hello</one></Greeting>")
+
+	// A charset included in the override is kept
+	res = serveRender(func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{ContentType: "application/json; charset=utf-16"})
+	})
+	expect(t, res.Header().Get(ContentType), "application/json; charset=utf-16")
+
+	// Other calls are unaffected
+	res = serveRender(func(w http.ResponseWriter) { render.JSON(w, http.StatusOK, Greeting{"hello"}) })
+	expect(t, res.Header().Get(ContentType), ContentJSON+"; charset=UTF-8")
+}
+
+func TestContentTypeOverrideData(t *testing.T) {
+	render := New()
+
+	res := serveRender(func(w http.ResponseWriter) {
+		w.Header().Set(ContentType, "image/jpeg")
+		render.Data(w, http.StatusOK, []byte("hello"), ContentTypeOption{ContentType: "image/png"})
+	})
+	expect(t, res.Header().Get(ContentType), "image/png")
+	expect(t, res.Body.String(), "hello")
+
+	// Without an override, a Content-Type set on the response is still used
+	res = serveRender(func(w http.ResponseWriter) {
+		w.Header().Set(ContentType, "image/jpeg")
+		render.Data(w, http.StatusOK, []byte("hello"))
+	})
+	expect(t, res.Header().Get(ContentType), "image/jpeg")
+}
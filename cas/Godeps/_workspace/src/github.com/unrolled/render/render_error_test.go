@@ -0,0 +1,159 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestErrorHTMLTemplate(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ErrorHTML: "error",
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, "nope", nil)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, 500)
+	expect(t, res.Header().Get(ContentType), ContentHTML+"; charset=UTF-8")
+	expect(t, res.Body.String(), "<h1>Error 500</h1>\n")
+}
+
+func TestErrorHTMLCustomBinding(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ErrorHTML: "error",
+		ErrorBinding: func(data ErrorData) interface{} {
+			return map[string]interface{}{"Status": strconv.Itoa(data.Status) + "!"}
+		},
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, "nope", nil)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, 500)
+	expect(t, res.Body.String(), "<h1>Error 500!</h1>\n")
+}
+
+func TestErrorHTMLKeepsErrorStatus(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ErrorHTML: "error",
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusNotFound, "nope", nil)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusNotFound)
+	expect(t, res.Body.String(), "<h1>Error 404</h1>\n")
+}
+
+func TestErrorHTMLWithLayout(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layout:    "layout",
+		ErrorHTML: "error",
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusForbidden, "fail", failingBinding{})
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusForbidden)
+	expect(t, res.Body.String(), "<h1>Error 403</h1>\n")
+}
+
+func TestErrorHTMLDevelopmentShowsRawError(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		ErrorHTML:     "error",
+		IsDevelopment: true,
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusBadRequest, "nope", nil)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusBadRequest)
+	expect(t, res.Body.String(), "html/template: \"nope\" is undefined\n")
+}
+
+func TestErrorHTMLMissingErrorTemplate(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ErrorHTML: "missing-error",
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, "nope", nil)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	// Template internals are not leaked even if the error template can't be rendered
+	expect(t, res.Code, 500)
+	expect(t, strings.Contains(res.Body.String(), "nope"), false)
+}
+
+func TestErrorHTMLForOtherEngines(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ErrorHTML: "error",
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.JSON(w, http.StatusUnprocessableEntity, func() {})
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusUnprocessableEntity)
+	expect(t, res.Body.String(), "<h1>Error 422</h1>\n")
+}
+
+func TestErrorWithoutErrorHTMLKeepsErrorStatus(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusNotFound, "nope", nil)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusNotFound)
+	expect(t, res.Body.String(), "html/template: \"nope\" is undefined\n")
+}
@@ -0,0 +1,156 @@
+package render
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewWithErrMalformedTemplates(t *testing.T) {
+	r, err := NewWithErr(Options{
+		Directory: "fixtures/malformed",
+	})
+
+	expect(t, r == nil, true)
+	errs, ok := err.(TemplateErrors)
+	expect(t, ok, true)
+	expect(t, len(errs), 2)
+
+	// Every failure is reported, with its file and line
+	expect(t, errs[0].File, filepath.Join("fixtures", "malformed", "admin", "worse.tmpl"))
+	expect(t, errs[0].Line, 1)
+	expect(t, errs[1].File, filepath.Join("fixtures", "malformed", "bad.tmpl"))
+	expect(t, errs[1].Line, 3)
+	expect(t, strings.HasPrefix(errs[1].Error(), filepath.Join("fixtures", "malformed", "bad.tmpl")+":3: "), true)
+	expect(t, strings.Contains(err.Error(), "missing value for if"), true)
+}
+
+func TestNewWithErrAssets(t *testing.T) {
+	assets := map[string]string{
+		"templates/good.tmpl":    "Fine",
+		"templates/bad.tmpl":     "{{ .Title",
+		"templates/missing.tmpl": "",
+	}
+
+	_, err := NewWithErr(Options{
+		Asset: func(name string) ([]byte, error) {
+			if name == "templates/missing.tmpl" {
+				return nil, errors.New("asset not found")
+			}
+			return []byte(assets[name]), nil
+		},
+		AssetNames: func() []string {
+			return []string{"templates/good.tmpl", "templates/bad.tmpl", "templates/missing.tmpl"}
+		},
+	})
+
+	errs, ok := err.(TemplateErrors)
+	expect(t, ok, true)
+	expect(t, len(errs), 2)
+	expect(t, errs[0].File, "templates/bad.tmpl")
+	expect(t, errs[0].Line, 1)
+	expect(t, errs[1].Error(), "templates/missing.tmpl: asset not found")
+}
+
+func TestNewWithErrValidTemplates(t *testing.T) {
+	r, err := NewWithErr(Options{
+		Directory: "fixtures/basic",
+	})
+
+	expect(t, err, nil)
+	expect(t, renderHTMLBody(r, "hello", "gophers").Body.String(), "<h1>Hello gophers</h1>\n")
+}
+
+func TestNewPanicsOnMalformedTemplates(t *testing.T) {
+	defer func() {
+		err, ok := recover().(error)
+		expect(t, ok, true)
+		expect(t, strings.Contains(err.Error(), "bad.tmpl:3:"), true)
+	}()
+
+	New(Options{
+		Directory: "fixtures/malformed",
+	})
+	t.Error("New did not panic")
+}
+
+func TestDevelopmentMalformedTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render-errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hello.tmpl")
+	ioutil.WriteFile(path, []byte("Hello"), 0644)
+	r := New(Options{
+		Directory:     dir,
+		IsDevelopment: true,
+	})
+
+	// Recompiling for a request reports the error instead of panicking
+	ioutil.WriteFile(path, []byte("{{ if }}"), 0644)
+	res := renderHTMLBody(r, "hello", nil)
+	expect(t, res.Code, http.StatusInternalServerError)
+	expect(t, strings.Contains(res.Body.String(), "hello.tmpl:1:"), true)
+}
+
+func TestNewWithErrTemplateCollisions(t *testing.T) {
+	_, err := NewWithErr(Options{
+		Directory:  "fixtures/collisions",
+		Extensions: []string{".tmpl", ".html"},
+	})
+
+	errs, ok := err.(TemplateErrors)
+	expect(t, ok, true)
+	expect(t, len(errs), 1)
+	html := filepath.Join("fixtures", "collisions", "admin", "nav.html")
+	tmpl := filepath.Join("fixtures", "collisions", "admin", "nav.tmpl")
+	expect(t, errs[0].File, tmpl)
+	collision, ok := errs[0].Err.(*TemplateCollisionError)
+	expect(t, ok, true)
+	expect(t, collision.Name, "admin/nav")
+	expect(t, len(collision.Files), 2)
+	expect(t, collision.Files[0], html)
+	expect(t, collision.Files[1], tmpl)
+	expect(t, strings.Contains(err.Error(), `template "admin/nav" is loaded from both `+html+" and "+tmpl), true)
+
+	// Without the colliding extension, the templates load
+	r, err := NewWithErr(Options{
+		Directory: "fixtures/collisions",
+	})
+	expect(t, err, nil)
+	expect(t, renderHTMLBody(r, "home", nil).Body.String(), "<main><nav>From tmpl</nav>\n</main>\n")
+}
+
+func TestNewWithErrTemplateCollisionsAssets(t *testing.T) {
+	_, err := NewWithErr(Options{
+		Asset: func(name string) ([]byte, error) {
+			return []byte(name), nil
+		},
+		AssetNames: func() []string {
+			return []string{"templates/admin/nav.tmpl", "templates/home.tmpl", "templates/admin/nav.TMPL"}
+		},
+	})
+
+	errs, ok := err.(TemplateErrors)
+	expect(t, ok, true)
+	expect(t, len(errs), 1)
+	expect(t, errs[0].Error(), `templates/admin/nav.TMPL: template "admin/nav" is loaded from both templates/admin/nav.tmpl and templates/admin/nav.TMPL`)
+}
+
+func TestTemplateCollisionsWarn(t *testing.T) {
+	r, err := NewWithErr(Options{
+		Directory:          "fixtures/collisions",
+		Extensions:         []string{".tmpl", ".html"},
+		TemplateCollisions: TemplateCollisionsWarn,
+	})
+
+	// The file loaded last is used
+	expect(t, err, nil)
+	expect(t, renderHTMLBody(r, "admin/nav", nil).Body.String(), "<nav>From tmpl</nav>\n")
+}
@@ -0,0 +1,132 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func renderConditional(render *Render, method, ifNoneMatch string, f func(w http.ResponseWriter)) *httptest.ResponseRecorder {
+	h := render.Conditional(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		f(w)
+	}))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, "/foo", nil)
+	if len(ifNoneMatch) > 0 {
+		req.Header.Set(IfNoneMatch, ifNoneMatch)
+	}
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestETagMiss(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ETag:      true,
+	})
+	renderHello := func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "hello", "gophers")
+	}
+
+	res := renderConditional(render, "GET", "", renderHello)
+	etag := res.Header().Get(ETagHeader)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, strings.HasPrefix(etag, `W/"`), true)
+	expect(t, res.Body.String(), "<h1>Hello gophers</h1>\n")
+
+	// The same body always has the same tag
+	res = renderConditional(render, "GET", `W/"other"`, renderHello)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ETagHeader), etag)
+	expect(t, res.Body.String(), "<h1>Hello gophers</h1>\n")
+}
+
+func TestETagHit(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ETag:      true,
+	})
+	renderers := []func(w http.ResponseWriter){
+		func(w http.ResponseWriter) { render.HTML(w, http.StatusOK, "hello", "gophers") },
+		func(w http.ResponseWriter) { render.JSON(w, http.StatusOK, Greeting{"hello"}) },
+		func(w http.ResponseWriter) { render.XML(w, http.StatusOK, Greeting{"hello"}) },
+	}
+
+	for _, f := range renderers {
+		etag := renderConditional(render, "GET", "", f).Header().Get(ETagHeader)
+		expect(t, len(etag) > 0, true)
+
+		res := renderConditional(render, "GET", `"stale", `+etag, f)
+		expect(t, res.Code, http.StatusNotModified)
+		expect(t, res.Header().Get(ETagHeader), etag)
+		expect(t, res.Body.Len(), 0)
+
+		// Strong and weak tags are compared weakly
+		res = renderConditional(render, "GET", strings.TrimPrefix(etag, "W/"), f)
+		expect(t, res.Code, http.StatusNotModified)
+	}
+}
+
+func TestETagOnlyForGetAndSuccess(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ETag:      true,
+	})
+
+	res := renderConditional(render, "POST", "*", func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusOK, Greeting{"hello"})
+	})
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.String(), `{"one":"hello"}`)
+
+	res = renderConditional(render, "GET", "*", func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusNotFound, Greeting{"hello"})
+	})
+	expect(t, res.Code, http.StatusNotFound)
+	expect(t, res.Header().Get(ETagHeader), "")
+	expect(t, res.Body.String(), `{"one":"hello"}`)
+}
+
+func TestETagOff(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+	})
+
+	res := renderConditional(render, "GET", "*", func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "hello", "gophers")
+	})
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ETagHeader), "")
+}
+
+func TestETagCompressed(t *testing.T) {
+	render := New(Options{
+		Directory:   "fixtures/basic",
+		ETag:        true,
+		Compression: CompressionDefault,
+	})
+	renderHello := func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "hello", largeBody)
+	}
+
+	res := renderCompressed(render, "gzip", renderHello)
+	etag := res.Header().Get(ETagHeader)
+	expect(t, res.Header().Get(ContentEncoding), "gzip")
+	expect(t, decompressedBody(t, res), "<h1>Hello "+largeBody+"</h1>\n")
+
+	h := render.Compress(render.Conditional(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		renderHello(w)
+	})))
+	res = httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	req.Header.Set(AcceptEncoding, "gzip")
+	req.Header.Set(IfNoneMatch, etag)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusNotModified)
+	expect(t, res.Header().Get(ETagHeader), etag)
+	expect(t, res.Header().Get(ContentEncoding), "")
+	expect(t, res.Body.Len(), 0)
+}
@@ -0,0 +1,78 @@
+package render
+
+import (
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// extensionsLoaders loads fixtures/extensions from the directory, assets and a file system.
+func extensionsLoaders(t *testing.T, extensions []string) map[string]*Render {
+	dir := "fixtures/extensions"
+	names := []string{}
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if info != nil && !info.IsDir() {
+			names = append(names, filepath.ToSlash(path))
+		}
+		return nil
+	})
+
+	loaders := map[string]Options{
+		"directory": {Directory: dir},
+		"assets": {
+			Directory:  dir,
+			Asset:      func(name string) ([]byte, error) { return ioutil.ReadFile(name) },
+			AssetNames: func() []string { return names },
+		},
+		"file system": {Directory: dir, FileSystem: os.DirFS(".")},
+	}
+	renders := map[string]*Render{}
+	for loader, opt := range loaders {
+		opt.Extensions = extensions
+		render, err := NewWithErr(opt)
+		if err != nil {
+			t.Fatalf("%s: %v", loader, err)
+		}
+		renders[loader] = render
+	}
+	return renders
+}
+
+func TestTemplateExtensionsCase(t *testing.T) {
+	for loader, render := range extensionsLoaders(t, []string{".tmpl"}) {
+		res := renderHTMLBody(render, "UPPER", "gophers")
+		if res.Body.String() != "Upper gophers\n" {
+			t.Errorf("%s: expected UPPER.TMPL to be loaded as UPPER, got %q", loader, res.Body.String())
+		}
+		res = renderHTMLBody(render, "page", "gophers")
+		if res.Body.String() != "<ul><li>gophers</li>\n</ul>\n" {
+			t.Errorf("%s: expected page.Tmpl to be loaded as page, got %q", loader, res.Body.String())
+		}
+		if render.TemplateLookup("notes") != nil || render.TemplateLookup("notes.txt") != nil {
+			t.Errorf("%s: expected notes.txt to be skipped", loader)
+		}
+	}
+}
+
+func TestTemplateExtensionsMultiDot(t *testing.T) {
+	for loader, render := range extensionsLoaders(t, []string{".tmpl"}) {
+		for _, name := range []string{"users.partial", "mail.html", "v1.2/inner"} {
+			if render.TemplateLookup(name) == nil {
+				t.Errorf("%s: expected a template named %q", loader, name)
+			}
+		}
+		expect(t, render.TemplateLookup("users"), (*template.Template)(nil))
+	}
+
+	// The longest matching extension is trimmed
+	for loader, render := range extensionsLoaders(t, []string{".tmpl", ".HTML.tmpl"}) {
+		if render.TemplateLookup("mail") == nil || render.TemplateLookup("mail.html") != nil {
+			t.Errorf("%s: expected mail.html.tmpl to be loaded as mail", loader)
+		}
+		if render.TemplateLookup("users.partial") == nil {
+			t.Errorf("%s: expected a template named users.partial", loader)
+		}
+	}
+}
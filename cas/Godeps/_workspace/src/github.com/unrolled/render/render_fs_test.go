@@ -0,0 +1,93 @@
+package render
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+var templatesFS = fstest.MapFS{
+	"templates/hello.tmpl":        {Data: []byte("<h1>Hello {{ . }}</h1>")},
+	"templates/admin/index.tmpl":  {Data: []byte(`<main>{{ partial "admin/nav" . }}</main>`)},
+	"templates/admin/nav.tmpl":    {Data: []byte("<nav>{{ . }}</nav>")},
+	"templates/layout.tmpl":       {Data: []byte("<body>{{ yield }}</body>")},
+	"templates/notes.txt":         {Data: []byte("{{ not a template")},
+	"templates/users.tmpl/x.tmpl": {Data: []byte("x")},
+	"other/ignored.tmpl":          {Data: []byte("ignored")},
+}
+
+func TestFileSystemTemplates(t *testing.T) {
+	render := New(Options{
+		FileSystem: templatesFS,
+	})
+
+	res := renderHTMLBody(render, "hello", "gophers")
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.String(), "<h1>Hello gophers</h1>")
+
+	expect(t, renderHTMLBody(render, "admin/index", "admin").Body.String(), "<main><nav>admin</nav></main>")
+	// Directories named like templates are walked, not parsed
+	expect(t, renderHTMLBody(render, "users.tmpl/x", nil).Body.String(), "x")
+	expect(t, render.TemplateLookup("notes"), (*template.Template)(nil))
+	expect(t, render.TemplateLookup("ignored"), (*template.Template)(nil))
+}
+
+func TestFileSystemLayout(t *testing.T) {
+	render := New(Options{
+		FileSystem: templatesFS,
+		Directory:  "templates/",
+		Layout:     "layout",
+	})
+
+	expect(t, renderHTMLBody(render, "hello", "gophers").Body.String(), "<body><h1>Hello gophers</h1></body>")
+}
+
+func TestFileSystemRoot(t *testing.T) {
+	render := New(Options{
+		FileSystem: fstest.MapFS{
+			"hello.tmpl":      {Data: []byte("Hello")},
+			"nested/one.tmpl": {Data: []byte("One")},
+		},
+		Directory: ".",
+	})
+
+	expect(t, renderHTMLBody(render, "hello", nil).Body.String(), "Hello")
+	expect(t, renderHTMLBody(render, "nested/one", nil).Body.String(), "One")
+}
+
+func TestFileSystemPreferredOverAssets(t *testing.T) {
+	render := New(Options{
+		FileSystem: templatesFS,
+		Asset: func(name string) ([]byte, error) {
+			return []byte("from assets"), nil
+		},
+		AssetNames: func() []string {
+			return []string{"templates/hello.tmpl"}
+		},
+	})
+
+	expect(t, renderHTMLBody(render, "hello", "gophers").Body.String(), "<h1>Hello gophers</h1>")
+}
+
+func TestFileSystemErrors(t *testing.T) {
+	_, err := NewWithErr(Options{
+		FileSystem: fstest.MapFS{
+			"templates/good.tmpl": {Data: []byte("Fine")},
+			"templates/bad.tmpl":  {Data: []byte("Fine\n{{ end }}")},
+		},
+	})
+
+	errs, ok := err.(TemplateErrors)
+	expect(t, ok, true)
+	expect(t, len(errs), 1)
+	expect(t, errs[0].File, "templates/bad.tmpl")
+	expect(t, errs[0].Line, 2)
+
+	// A missing directory is reported too
+	_, err = NewWithErr(Options{
+		FileSystem: fstest.MapFS{},
+	})
+	expect(t, err != nil && strings.Contains(err.Error(), "templates"), true)
+}
@@ -0,0 +1,126 @@
+package render
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestAddFuncAfterConstruction(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/custom_funcs",
+		Funcs: []template.FuncMap{{
+			"myCustomFunc": func() string { return "placeholder" },
+		}},
+	})
+	expect(t, renderHTMLBody(render, "index", nil).Body.String(), "placeholder\n")
+
+	err := render.AddFunc("myCustomFunc", func() string { return "My custom function" })
+	expect(t, err, nil)
+
+	res := renderHTMLBody(render, "index", nil)
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.String(), "My custom function\n")
+}
+
+func TestAddFuncsUsedByNewTemplates(t *testing.T) {
+	files := fstest.MapFS{"templates/hello.tmpl": {Data: []byte("Hello")}}
+	render := New(Options{FileSystem: files})
+
+	// Templates calling the functions can be loaded once they are registered (ie: in development)
+	files["templates/link.tmpl"] = &fstest.MapFile{Data: []byte(`<a href="{{ url . }}">{{ shout . }}</a>`)}
+	err := render.AddFuncs(template.FuncMap{
+		"url":   func(path string) string { return "https://example.com/" + path },
+		"shout": strings.ToUpper,
+	})
+	expect(t, err, nil)
+
+	expect(t, renderHTMLBody(render, "link", "home").Body.String(), `<a href="https://example.com/home">HOME</a>`)
+	expect(t, renderHTMLBody(render, "hello", nil).Body.String(), "Hello")
+
+	// Functions accumulate
+	expect(t, render.AddFunc("whisper", strings.ToLower), nil)
+	files["templates/link.tmpl"] = &fstest.MapFile{Data: []byte(`{{ shout . }} {{ whisper . }}`)}
+	expect(t, render.recompileTemplates(), nil)
+	expect(t, renderHTMLBody(render, "link", "Home").Body.String(), "HOME home")
+}
+
+func TestAddFuncInvalid(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/custom_funcs",
+		Funcs: []template.FuncMap{{
+			"myCustomFunc": func() string { return "placeholder" },
+		}},
+	})
+
+	for name, fn := range map[string]interface{}{
+		"myCustomFunc": "not a function",
+		"not-a-name":   func() string { return "" },
+	} {
+		if err := render.AddFunc(name, fn); err == nil {
+			t.Errorf("Expected an error registering %q", name)
+		}
+	}
+	expect(t, renderHTMLBody(render, "index", nil).Body.String(), "placeholder\n")
+}
+
+func TestAddFuncsKeepsTemplatesOnError(t *testing.T) {
+	files := fstest.MapFS{"templates/hello.tmpl": {Data: []byte("Hello")}}
+	render := New(Options{FileSystem: files})
+
+	files["templates/broken.tmpl"] = &fstest.MapFile{Data: []byte("{{ undefinedFunc }}")}
+	if err := render.AddFunc("shout", strings.ToUpper); err == nil {
+		t.Fatal("Expected recompiling the templates to fail")
+	}
+	expect(t, renderHTMLBody(render, "hello", nil).Body.String(), "Hello")
+
+	// The function was not registered
+	delete(files, "templates/broken.tmpl")
+	files["templates/shout.tmpl"] = &fstest.MapFile{Data: []byte("{{ shout . }}")}
+	if err := render.recompileTemplates(); err == nil {
+		t.Error("Expected shout to be undefined")
+	}
+}
+
+func TestAddFuncBuiltinNames(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layout:    "layout",
+	})
+
+	expect(t, render.AddFunc("yield", func() string { return "overridden" }), nil)
+	expect(t, renderHTMLBody(render, "content", "gophers").Body.String(), "head\n<h1>gophers</h1>\n\nfoot\n")
+}
+
+func TestAddFuncConcurrentRenders(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/custom_funcs",
+		Funcs: []template.FuncMap{{
+			"myCustomFunc": func() string { return "placeholder" },
+		}},
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				body := renderHTMLBody(render, "index", nil).Body.String()
+				if body != "placeholder\n" && body != "replaced\n" {
+					t.Errorf("Unexpected body %q", body)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		if err := render.AddFunc("myCustomFunc", func() string { return "replaced" }); err != nil {
+			t.Error(err)
+		}
+	}
+	wg.Wait()
+	expect(t, renderHTMLBody(render, "index", nil).Body.String(), "replaced\n")
+}
@@ -0,0 +1,135 @@
+package render
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHeadersOption(t *testing.T) {
+	render := New()
+	headers := http.Header{"Location": {"/things/1"}, "X-RateLimit-Remaining": {"41"}}
+
+	res := serveRender(func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusCreated, Greeting{"hello"}, ContentTypeOption{Headers: headers})
+	})
+	expect(t, res.Code, http.StatusCreated)
+	expect(t, res.Header().Get("Location"), "/things/1")
+	expect(t, res.Header().Get("X-RateLimit-Remaining"), "41")
+	expect(t, res.Header().Get(ContentType), ContentJSON+"; charset=UTF-8")
+	expect(t, res.Body.String(), `{"one":"hello"}`)
+
+	for name, f := range map[string]func(w http.ResponseWriter){
+		"XML": func(w http.ResponseWriter) {
+			render.XML(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{Headers: headers})
+		},
+		"YAML": func(w http.ResponseWriter) {
+			render.YAML(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{Headers: headers})
+		},
+		"MsgPack": func(w http.ResponseWriter) {
+			render.MsgPack(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{Headers: headers})
+		},
+		"JSONP": func(w http.ResponseWriter) {
+			render.JSONP(w, http.StatusOK, "cb", Greeting{"hello"}, ContentTypeOption{Headers: headers})
+		},
+		"Text": func(w http.ResponseWriter) {
+			render.Text(w, http.StatusOK, "hello", ContentTypeOption{Headers: headers})
+		},
+		"Data": func(w http.ResponseWriter) {
+			render.Data(w, http.StatusOK, []byte("hello"), ContentTypeOption{Headers: headers})
+		},
+	} {
+		res := serveRender(f)
+		if res.Header().Get("Location") != "/things/1" || res.Header().Get("X-RateLimit-Remaining") != "41" || res.Body.Len() == 0 {
+			t.Errorf("%s: expected the custom headers alongside the body, got %v %q", name, res.Header(), res.Body.String())
+		}
+	}
+}
+
+func TestHeadersOptionContentType(t *testing.T) {
+	render := New()
+
+	// Content-Type headers are ignored, while ContentType still overrides it
+	res := serveRender(func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{Headers: http.Header{"content-type": {"text/html"}, "x-custom": {"a", "b"}}})
+	})
+	expect(t, res.Header().Get(ContentType), ContentJSON+"; charset=UTF-8")
+	expect(t, len(res.Header()["X-Custom"]), 2)
+
+	res = serveRender(func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusOK, Greeting{"hello"}, ContentTypeOption{ContentType: "application/vnd.myapp+json", Headers: http.Header{ContentType: {"text/html"}}})
+	})
+	expect(t, res.Header().Get(ContentType), "application/vnd.myapp+json; charset=UTF-8")
+
+	res = serveRender(func(w http.ResponseWriter) {
+		render.Text(w, http.StatusOK, "hello", ContentTypeOption{ContentType: "text/csv", Headers: http.Header{"Content-Disposition": {"attachment"}}})
+	})
+	expect(t, res.Header().Get(ContentType), "text/csv; charset=UTF-8")
+	expect(t, res.Header().Get("Content-Disposition"), "attachment")
+	expect(t, res.Body.String(), "hello")
+}
+
+func TestHeadersOptionHTML(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layout:    "layout",
+	})
+	headers := http.Header{"Cache-Control": {"no-store"}}
+
+	// Options only setting headers keep the default layout
+	res := serveRender(func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "content", "gophers", HTMLOptions{Headers: headers})
+	})
+	expect(t, res.Header().Get("Cache-Control"), "no-store")
+	expect(t, res.Header().Get(ContentType), ContentHTML+"; charset=UTF-8")
+	expect(t, res.Body.String(), "head\n<h1>gophers</h1>\n\nfoot\n")
+
+	res = serveRender(func(w http.ResponseWriter) {
+		render.HTML(w, http.StatusOK, "content", "gophers", HTMLOptions{Layouts: []string{}, Headers: headers})
+	})
+	expect(t, res.Header().Get("Cache-Control"), "no-store")
+	expect(t, res.Body.String(), "<h1>gophers</h1>\n")
+}
+
+func TestHeadersOptionNotSetOnErrors(t *testing.T) {
+	render := New()
+
+	res := serveRender(func(w http.ResponseWriter) {
+		render.JSON(w, http.StatusCreated, map[string]interface{}{"fn": func() {}}, ContentTypeOption{Headers: http.Header{"Location": {"/things/1"}}})
+	})
+	expect(t, res.Code, http.StatusInternalServerError)
+	expect(t, res.Header().Get("Location"), "")
+}
+
+func TestNoContent(t *testing.T) {
+	render := New()
+
+	res := serveRender(func(w http.ResponseWriter) {
+		w.Header().Set(ContentType, ContentJSON)
+		render.NoContent(w, ContentTypeOption{Headers: http.Header{"X-Request-Id": {"abc"}, ContentType: {ContentJSON}}})
+	})
+	expect(t, res.Code, http.StatusNoContent)
+	expect(t, res.Body.Len(), 0)
+	expect(t, res.Header().Get("X-Request-Id"), "abc")
+	_, hasContentType := res.Header()[ContentType]
+	expect(t, hasContentType, false)
+	_, hasLength := res.Header()["Content-Length"]
+	expect(t, hasLength, false)
+
+	res = serveRender(func(w http.ResponseWriter) { render.Status(w, http.StatusNotModified) })
+	expect(t, res.Code, http.StatusNotModified)
+	expect(t, res.Body.Len(), 0)
+	expect(t, len(res.Header()), 0)
+}
+
+func TestStatus(t *testing.T) {
+	render := New()
+
+	res := serveRender(func(w http.ResponseWriter) {
+		render.Status(w, http.StatusAccepted, ContentTypeOption{Headers: http.Header{"Location": {"/jobs/1"}}})
+	})
+	expect(t, res.Code, http.StatusAccepted)
+	expect(t, res.Body.Len(), 0)
+	expect(t, res.Header().Get("Location"), "/jobs/1")
+	expect(t, res.Header().Get("Content-Length"), "0")
+	expect(t, res.Header().Get(ContentType), "")
+}
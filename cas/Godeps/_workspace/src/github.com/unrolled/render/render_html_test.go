@@ -0,0 +1,296 @@
+package render
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type failingBinding struct{}
+
+func (failingBinding) Fail() (string, error) {
+	return "", errors.New("failed mid-template")
+}
+
+func TestHTMLStreaming(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		StreamingHTML: true,
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusCreated, "hello", "gophers")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusCreated)
+	expect(t, res.Header().Get(ContentType), ContentHTML+"; charset=UTF-8")
+	expect(t, res.Body.String(), "<h1>Hello gophers</h1>\n")
+}
+
+func TestHTMLStreamingMissingTemplate(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		StreamingHTML: true,
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, "nope", nil)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, 500)
+	expect(t, res.Body.String(), "html/template: \"nope\" is undefined\n")
+}
+
+func TestHTMLStreamingErrorMidTemplate(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		StreamingHTML: true,
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, "fail", failingBinding{})
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	// The status was sent before the error occurred, and the partial body is not followed by the error
+	expect(t, res.Code, http.StatusOK)
+	expect(t, strings.HasPrefix(res.Body.String(), "<h1>Before</h1>\n"), true)
+	expect(t, strings.Contains(res.Body.String(), "After"), false)
+	expect(t, strings.Contains(res.Body.String(), "failed mid-template"), false)
+}
+
+func TestHTMLStreamingErrorMidTemplateDevelopment(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		StreamingHTML: true,
+		IsDevelopment: true,
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, "fail", failingBinding{})
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, strings.Contains(res.Body.String(), "failed mid-template"), true)
+}
+
+func TestHTMLStreamingWithLayoutBuffers(t *testing.T) {
+	render := New(Options{
+		Directory:     "fixtures/basic",
+		Layout:        "layout",
+		StreamingHTML: true,
+	})
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, "fail", failingBinding{})
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+
+	// Layouts are buffered, so the error still results in a 500 with nothing partially rendered
+	expect(t, res.Code, 500)
+	expect(t, strings.Contains(res.Body.String(), "Before"), false)
+}
+
+func renderHTMLBody(render *Render, name string, binding interface{}, htmlOpt ...HTMLOptions) *httptest.ResponseRecorder {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		render.HTML(w, http.StatusOK, name, binding, htmlOpt...)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestHTMLLayout(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layout:    "layout",
+	})
+
+	res := renderHTMLBody(render, "content", "gophers")
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.String(), "head\n<h1>gophers</h1>\n\nfoot\n")
+}
+
+func TestHTMLNestedLayouts(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layouts:   []string{"section_layout", "layout"},
+	})
+
+	res := renderHTMLBody(render, "content", "gophers")
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.String(), "head\nsection content\n<h1>gophers</h1>\n\nend section\n\nfoot\n")
+}
+
+func TestHTMLNestedLayoutsOverride(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layout:    "layout",
+	})
+
+	res := renderHTMLBody(render, "content", "gophers", HTMLOptions{Layouts: []string{"section_layout", "another_layout"}})
+	expect(t, res.Body.String(), "another head\nsection content\n<h1>gophers</h1>\n\nend section\n\nanother foot\n")
+
+	// Other renders keep using the default layout
+	res = renderHTMLBody(render, "content", "gophers")
+	expect(t, res.Body.String(), "head\n<h1>gophers</h1>\n\nfoot\n")
+}
+
+func TestHTMLNestedLayoutsCurrent(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layouts:   []string{"current_layout", "section_layout"},
+	})
+
+	res := renderHTMLBody(render, "content", "gophers")
+	expect(t, res.Body.String(), "section content\ncontent head\n<h1>gophers</h1>\n\ncontent foot\n\nend section\n")
+}
+
+func TestHTMLNestedLayoutsYieldTwice(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layouts:   []string{"section_layout", "double_layout"},
+	})
+
+	res := renderHTMLBody(render, "content", "gophers")
+	section := "section content\n<h1>gophers</h1>\n\nend section\n"
+	expect(t, res.Body.String(), section+"|"+section+"\n")
+}
+
+func TestHTMLNestedPartials(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/partials",
+	})
+
+	res := renderHTMLBody(render, "page", map[string]string{"Title": "<Home>", "Href": "/home"})
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Body.String(), "<main><nav>&lt;Home&gt; <a href=\"/home\">/home</a></nav></main>\n")
+}
+
+func TestHTMLPartialInLayout(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/partials",
+		Layout:    "shared/nav",
+	})
+
+	res := renderHTMLBody(render, "shared/link", map[string]string{"Title": "Home", "Href": "/home"})
+
+	// The layout's partial is rendered, with the binding passed to it
+	expect(t, res.Code, http.StatusOK)
+	expect(t, strings.Contains(res.Body.String(), "<nav>Home <a href"), true)
+}
+
+func TestHTMLMissingPartial(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/partials",
+	})
+
+	res := renderHTMLBody(render, "missing", nil)
+
+	expect(t, res.Code, http.StatusInternalServerError)
+	expect(t, strings.Contains(res.Body.String(), `partial "shared/nope" is not defined`), true)
+}
+
+func TestHTMLPartialDevelopmentRecompile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "render-partials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeTemplate := func(name, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".tmpl"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTemplate("page", `{{ partial "nav" . }}`)
+	writeTemplate("nav", `old {{ . }}`)
+
+	render := New(Options{
+		Directory:     dir,
+		IsDevelopment: true,
+	})
+	expect(t, renderHTMLBody(render, "page", "nav").Body.String(), "old nav")
+
+	// Partials are resolved against the recompiled templates
+	writeTemplate("nav", `new {{ . }}`)
+	expect(t, renderHTMLBody(render, "page", "nav").Body.String(), "new nav")
+}
+
+func TestHTMLBytes(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+	})
+
+	out, err := render.HTMLBytes("hello", "gophers")
+	expect(t, err, nil)
+	expect(t, string(out), "<h1>Hello gophers</h1>\n")
+}
+
+func TestHTMLBytesLayout(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layout:    "layout",
+	})
+
+	out, err := render.HTMLBytes("content", "gophers")
+	expect(t, err, nil)
+	expect(t, string(out), "head\n<h1>gophers</h1>\n\nfoot\n")
+
+	// Layouts given for the render override the default one, as with HTML
+	out, err = render.HTMLBytes("content", "gophers", HTMLOptions{Layouts: []string{"section_layout", "another_layout"}})
+	expect(t, err, nil)
+	expect(t, string(out), "another head\nsection content\n<h1>gophers</h1>\n\nend section\n\nanother foot\n")
+
+	out, err = render.HTMLBytes("content", "gophers", HTMLOptions{Layouts: []string{}})
+	expect(t, err, nil)
+	expect(t, string(out), "<h1>gophers</h1>\n")
+}
+
+func TestHTMLBytesErrors(t *testing.T) {
+	render := New(Options{
+		Directory: "fixtures/basic",
+		Layout:    "layout",
+	})
+
+	out, err := render.HTMLBytes("nope", "gophers")
+	expect(t, out == nil, true)
+	expect(t, err != nil && strings.Contains(err.Error(), `"nope" is undefined`), true)
+
+	out, err = render.HTMLBytes("fail", failingBinding{}, HTMLOptions{Layouts: []string{}})
+	expect(t, out == nil, true)
+	expect(t, err != nil && strings.Contains(err.Error(), "failed mid-template"), true)
+
+	// Renders after a failed one are unaffected
+	out, err = render.HTMLBytes("content", "gophers")
+	expect(t, err, nil)
+	expect(t, string(out), "head\n<h1>gophers</h1>\n\nfoot\n")
+}
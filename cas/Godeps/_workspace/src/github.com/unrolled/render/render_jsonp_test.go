@@ -0,0 +1,66 @@
+package render
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func renderJSONP(render *Render, callback string) *httptest.ResponseRecorder {
+	return serveRender(func(w http.ResponseWriter) { render.JSONP(w, http.StatusOK, callback, Greeting{"hello"}) })
+}
+
+func TestJSONPCallback(t *testing.T) {
+	render := New()
+
+	for _, callback := range []string{"callback", "jQuery.cb_1", "$", "_cb$2", "app.handlers.onData"} {
+		res := renderJSONP(render, callback)
+		expect(t, res.Code, http.StatusOK)
+		expect(t, res.Header().Get(ContentType), ContentJSONP+"; charset=UTF-8")
+		expect(t, res.Body.String(), callback+`({"one":"hello"});`)
+	}
+}
+
+func TestJSONPCallbackInjection(t *testing.T) {
+	render := New()
+
+	for _, callback := range []string{"alert(1)//", "", "1cb", "cb;alert(1)", "cb\n", "<script>", "cb cb", "cb[0]"} {
+		res := renderJSONP(render, callback)
+		expect(t, res.Code, http.StatusBadRequest)
+		expect(t, strings.Contains(res.Body.String(), `({"one":"hello"})`), false)
+		expect(t, strings.Contains(res.Body.String(), "invalid JSONP callback"), true)
+	}
+}
+
+func TestJSONPCallbackRejected(t *testing.T) {
+	// The engine refuses invalid callbacks before writing anything.
+	res := serveRender(func(w http.ResponseWriter) {
+		err := JSONP{Head: Head{ContentType: ContentJSONP, Status: http.StatusOK}, Callback: "alert(1)//"}.Render(w, Greeting{"hello"})
+		callbackErr, ok := err.(*InvalidCallbackError)
+		expect(t, ok, true)
+		expect(t, callbackErr.Callback, "alert(1)//")
+	})
+	expect(t, res.Body.Len(), 0)
+	expect(t, res.Header().Get(ContentType), "")
+
+	// Outside of development, the error template is rendered
+	render := New(Options{
+		Directory: "fixtures/basic",
+		ErrorHTML: "error",
+	})
+	res = renderJSONP(render, "alert(1)//")
+	expect(t, res.Code, http.StatusBadRequest)
+	expect(t, res.Header().Get(ContentType), ContentHTML+"; charset=UTF-8")
+}
+
+func TestJSONPCallbackPattern(t *testing.T) {
+	render := New(Options{
+		JSONPCallbackPattern: regexp.MustCompile(`^[a-z]+$`),
+	})
+
+	expect(t, renderJSONP(render, "callback").Code, http.StatusOK)
+	expect(t, renderJSONP(render, "jQuery.cb").Code, http.StatusBadRequest)
+	expect(t, renderJSONP(render, "cb_1").Code, http.StatusBadRequest)
+}
@@ -0,0 +1,255 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type msgPackNumbers struct {
+	Small    int     `json:"small"`
+	Negative int     `json:"negative"`
+	Byte     int     `json:"byte"`
+	Short    int     `json:"short"`
+	Int      int64   `json:"int"`
+	MinInt   int64   `json:"minInt"`
+	MaxUint  uint64  `json:"maxUint"`
+	Float    float64 `json:"float"`
+}
+
+func renderMsgPack(render *Render, v interface{}) *httptest.ResponseRecorder {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		render.MsgPack(w, http.StatusOK, v)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+	return res
+}
+
+func TestMsgPackBasic(t *testing.T) {
+	render := New()
+
+	res := renderMsgPack(render, Greeting{"hello"})
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ContentType), ContentMsgPack)
+	// fixmap(1) fixstr("one") fixstr("hello")
+	expect(t, res.Body.String(), "\x81\xa3one\xa5hello")
+}
+
+func TestMsgPackRoundTrip(t *testing.T) {
+	render := New()
+
+	res := renderMsgPack(render, yamlFixture)
+
+	expect(t, res.Code, http.StatusOK)
+	value := decodeTestMsgPack(t, res.Body.Bytes())
+	if !reflect.DeepEqual(value, jsonValue(t, yamlFixture)) {
+		t.Errorf("MessagePack did not round-trip, got %#v", value)
+	}
+}
+
+func TestMsgPackNumbersAndLengths(t *testing.T) {
+	render := New()
+
+	numbers := msgPackNumbers{1, -5, 200, -1000, 1 << 40, math.MinInt64, math.MaxUint64, 1.25}
+	value := decodeTestMsgPack(t, renderMsgPack(render, numbers).Body.Bytes())
+	expect(t, value.(map[string]interface{})["int"], float64(1<<40))
+	expect(t, value.(map[string]interface{})["minInt"], int64(math.MinInt64))
+	expect(t, value.(map[string]interface{})["maxUint"], uint64(math.MaxUint64))
+	expect(t, value.(map[string]interface{})["float"], 1.25)
+
+	// Strings, arrays and maps too long for their fix forms
+	long := map[string]interface{}{
+		"str8":    strings.Repeat("a", 100),
+		"str16":   strings.Repeat("b", 1000),
+		"str32":   strings.Repeat("c", 70000),
+		"array":   make([]int, 20),
+		"array32": make([]bool, 70000),
+	}
+	for i := 0; i < 20; i++ {
+		long[fmt.Sprintf("key%d", i)] = i
+	}
+	value = decodeTestMsgPack(t, renderMsgPack(render, long).Body.Bytes())
+	if !reflect.DeepEqual(value, jsonValue(t, long)) {
+		t.Error("MessagePack with long values did not round-trip")
+	}
+}
+
+func TestMsgPackError(t *testing.T) {
+	render := New()
+
+	res := renderMsgPack(render, map[string]interface{}{"fn": func() {}})
+	expect(t, res.Code, http.StatusInternalServerError)
+}
+
+// Service lists, as returned by the CAS API
+func benchmarkServices() []map[string]interface{} {
+	services := make([]map[string]interface{}, 1000)
+	for i := range services {
+		services[i] = map[string]interface{}{
+			"name":       fmt.Sprintf("service-%d", i),
+			"url":        fmt.Sprintf("https://service-%d.example.com/cas/login", i),
+			"adminEmail": fmt.Sprintf("admin-%d@example.com", i),
+			"enabled":    i%2 == 0,
+			"ttl":        7200,
+		}
+	}
+	return services
+}
+
+func BenchmarkMsgPackServices(b *testing.B) {
+	services := benchmarkServices()
+	b.ReportAllocs()
+	var size int
+	for i := 0; i < b.N; i++ {
+		data, err := marshalMsgPack(services)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(data)
+	}
+	b.ReportMetric(float64(size), "bytes/payload")
+}
+
+func BenchmarkJSONServices(b *testing.B) {
+	services := benchmarkServices()
+	b.ReportAllocs()
+	var size int
+	for i := 0; i < b.N; i++ {
+		data, err := json.Marshal(services)
+		if err != nil {
+			b.Fatal(err)
+		}
+		size = len(data)
+	}
+	b.ReportMetric(float64(size), "bytes/payload")
+}
+
+/*
+ * Decoder for the MessagePack written by the MsgPack renderer, which is only
+ * needed to check that it can be read back.
+ */
+
+// decodeTestMsgPack decodes MessagePack into the values json.Unmarshal would give, except for integers
+// (decoded as int64, or uint64 if too large), which are converted to float64 when they fit exactly
+func decodeTestMsgPack(t *testing.T, data []byte) interface{} {
+	r := bytes.NewReader(data)
+	value, err := readTestMsgPack(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 0 {
+		t.Fatalf("%d unexpected trailing bytes", r.Len())
+	}
+	return value
+}
+
+func readTestMsgPack(r *bytes.Reader) (interface{}, error) {
+	code, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	readUint := func(size int) uint64 {
+		buf := make([]byte, 8)
+		io.ReadFull(r, buf[8-size:])
+		return binary.BigEndian.Uint64(buf)
+	}
+	readInt := func(size int) int64 {
+		return int64(readUint(size)<<uint(64-8*size)) >> uint(64-8*size)
+	}
+
+	switch {
+	case code <= 0x7f:
+		return smallTestInt(int64(code)), nil
+	case code >= 0xe0:
+		return smallTestInt(int64(int8(code))), nil
+	case code&0xe0 == 0xa0:
+		return readTestMsgPackString(r, int(code&0x1f))
+	case code&0xf0 == 0x90:
+		return readTestMsgPackArray(r, int(code&0x0f))
+	case code&0xf0 == 0x80:
+		return readTestMsgPackMap(r, int(code&0x0f))
+	}
+
+	switch code {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xcb:
+		return math.Float64frombits(readUint(8)), nil
+	case 0xcc, 0xcd, 0xce:
+		return smallTestInt(int64(readUint(1 << (code - 0xcc)))), nil
+	case 0xcf:
+		u := readUint(8)
+		if u > math.MaxInt64 {
+			return u, nil
+		}
+		return smallTestInt(int64(u)), nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		return smallTestInt(readInt(1 << (code - 0xd0))), nil
+	case 0xd9, 0xda, 0xdb:
+		return readTestMsgPackString(r, int(readUint(1<<(code-0xd9))))
+	case 0xdc, 0xdd:
+		return readTestMsgPackArray(r, int(readUint(2<<(code-0xdc))))
+	case 0xde, 0xdf:
+		return readTestMsgPackMap(r, int(readUint(2<<(code-0xde))))
+	}
+	return nil, fmt.Errorf("unexpected MessagePack code 0x%x", code)
+}
+
+// smallTestInt returns integers that a float64 holds exactly as float64, like json.Unmarshal
+func smallTestInt(i int64) interface{} {
+	if i > -(1<<53) && i < 1<<53 {
+		return float64(i)
+	}
+	return i
+}
+
+func readTestMsgPackString(r *bytes.Reader, n int) (interface{}, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func readTestMsgPackArray(r *bytes.Reader, n int) (interface{}, error) {
+	array := make([]interface{}, n)
+	for i := range array {
+		item, err := readTestMsgPack(r)
+		if err != nil {
+			return nil, err
+		}
+		array[i] = item
+	}
+	return array, nil
+}
+
+func readTestMsgPackMap(r *bytes.Reader, n int) (interface{}, error) {
+	m := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := readTestMsgPack(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readTestMsgPack(r)
+		if err != nil {
+			return nil, err
+		}
+		m[key.(string)] = value
+	}
+	return m, nil
+}
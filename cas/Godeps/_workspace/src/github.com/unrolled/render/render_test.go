@@ -0,0 +1,13 @@
+package render
+
+import (
+	"reflect"
+	"testing"
+)
+
+/* Test Helper */
+func expect(t *testing.T, a interface{}, b interface{}) {
+	if a != b {
+		t.Errorf("Expected ||%#v|| (type %v) - Got ||%#v|| (type %v)", b, reflect.TypeOf(b), a, reflect.TypeOf(a))
+	}
+}
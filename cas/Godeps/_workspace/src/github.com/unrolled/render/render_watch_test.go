@@ -0,0 +1,111 @@
+package render
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// watchedRender creates a Render watching a temporary template directory, returning a function to write templates.
+func watchedRender(t *testing.T, templates map[string]string) (*Render, func(name, contents string), func()) {
+	dir, err := ioutil.TempDir("", "render-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTemplate := func(name, contents string) {
+		if err := ioutil.WriteFile(filepath.Join(dir, name+".tmpl"), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, contents := range templates {
+		writeTemplate(name, contents)
+	}
+
+	render := New(Options{
+		Directory:      dir,
+		WatchTemplates: true,
+		WatchInterval:  5 * time.Millisecond,
+	})
+	cleanup := func() {
+		render.Close()
+		os.RemoveAll(dir)
+	}
+	return render, writeTemplate, cleanup
+}
+
+// eventually checks a condition until it holds, failing the test if it does not within a second.
+func eventually(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition was not met before the deadline")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchTemplatesChange(t *testing.T) {
+	render, writeTemplate, cleanup := watchedRender(t, map[string]string{"hello": "Hello {{ . }}"})
+	defer cleanup()
+
+	expect(t, renderHTMLBody(render, "hello", "gophers").Body.String(), "Hello gophers")
+
+	writeTemplate("hello", "Goodbye {{ . }}")
+	eventually(t, func() bool {
+		return renderHTMLBody(render, "hello", "gophers").Body.String() == "Goodbye gophers"
+	})
+}
+
+func TestWatchTemplatesAddRemove(t *testing.T) {
+	render, writeTemplate, cleanup := watchedRender(t, map[string]string{"hello": "Hello"})
+	defer cleanup()
+
+	writeTemplate("added", "Added")
+	eventually(t, func() bool {
+		return render.TemplateLookup("added") != nil
+	})
+	expect(t, renderHTMLBody(render, "added", nil).Body.String(), "Added")
+
+	os.Remove(filepath.Join(render.opt.Directory, "hello.tmpl"))
+	eventually(t, func() bool {
+		return render.TemplateLookup("hello") == nil
+	})
+}
+
+func TestWatchTemplatesParseError(t *testing.T) {
+	render, writeTemplate, cleanup := watchedRender(t, map[string]string{"hello": "Hello"})
+	defer cleanup()
+
+	// The previous templates are kept while a template fails to parse
+	writeTemplate("broken", "{{ if }")
+	writeTemplate("added", "Added")
+	time.Sleep(50 * time.Millisecond)
+	expect(t, render.TemplateLookup("added") == nil, true)
+	expect(t, renderHTMLBody(render, "hello", nil).Body.String(), "Hello")
+
+	// And the watcher picks up the fix
+	writeTemplate("broken", "Fixed")
+	eventually(t, func() bool {
+		return renderHTMLBody(render, "broken", nil).Body.String() == "Fixed"
+	})
+	expect(t, renderHTMLBody(render, "added", nil).Body.String(), "Added")
+}
+
+func TestWatchTemplatesInDevelopment(t *testing.T) {
+	render, writeTemplate, cleanup := watchedRender(t, map[string]string{"hello": "Hello"})
+	defer cleanup()
+	render.opt.IsDevelopment = true
+
+	// Templates are not recompiled on each request while they are watched
+	before := render.currentTemplates()
+	renderHTMLBody(render, "hello", nil)
+	expect(t, render.currentTemplates() == before, true)
+
+	writeTemplate("hello", "Hello again")
+	eventually(t, func() bool {
+		return renderHTMLBody(render, "hello", nil).Body.String() == "Hello again"
+	})
+}
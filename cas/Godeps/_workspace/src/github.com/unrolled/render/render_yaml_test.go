@@ -0,0 +1,200 @@
+package render
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+type yamlService struct {
+	Name       string            `json:"name"`
+	URL        string            `json:"url"`
+	Port       int               `json:"port"`
+	Ratio      float64           `json:"ratio"`
+	Enabled    bool              `json:"enabled"`
+	Tags       []string          `json:"tags"`
+	Labels     map[string]string `json:"labels"`
+	Owner      *yamlService      `json:"owner"`
+	Backends   []yamlService     `json:"backends,omitempty"`
+	Matrix     [][]int           `json:"matrix"`
+	Empty      []string          `json:"empty"`
+	unexported string
+}
+
+var yamlFixture = yamlService{
+	Name:    "casgo",
+	URL:     "https://localhost:9090/login?service=a&b=c",
+	Port:    9090,
+	Ratio:   0.5,
+	Enabled: true,
+	Tags:    []string{"yes", "", "1.5", "a: b", "- dash", "line\nbreak", "<html>", "plain words"},
+	Labels:  map[string]string{"env": "prod", "key: with colon": "null"},
+	Backends: []yamlService{
+		{Name: "memory", Tags: []string{}, Matrix: [][]int{}},
+		{Name: "rethinkdb", Port: 28015, Tags: []string{"db"}},
+	},
+	Matrix: [][]int{{1, 2}, {}, {3}},
+	Empty:  []string{},
+}
+
+func renderYAML(render *Render, v interface{}) *httptest.ResponseRecorder {
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		render.YAML(w, http.StatusOK, v)
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/foo", nil)
+	h.ServeHTTP(res, req)
+	return res
+}
+
+// jsonValue returns v as it would be decoded from its JSON encoding.
+func jsonValue(t *testing.T, v interface{}) interface{} {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	return value
+}
+
+func TestYAMLBasic(t *testing.T) {
+	render := New()
+
+	res := renderYAML(render, Greeting{"hello"})
+
+	expect(t, res.Code, http.StatusOK)
+	expect(t, res.Header().Get(ContentType), ContentYAML+"; charset=UTF-8")
+	expect(t, res.Body.String(), "{\"one\":\"hello\"}\n")
+}
+
+func TestYAMLIndent(t *testing.T) {
+	render := New(Options{
+		IndentYAML: true,
+		PrefixYAML: []byte("---\n"),
+	})
+
+	res := renderYAML(render, struct {
+		One   string   `json:"one"`
+		Two   []string `json:"two"`
+		Three Greeting `json:"three"`
+	}{"hello", []string{"a", "true"}, Greeting{"world"}})
+
+	expect(t, res.Header().Get(ContentType), ContentYAML+"; charset=UTF-8")
+	expect(t, res.Body.String(), "---\none: hello\ntwo:\n  - a\n  - \"true\"\nthree:\n  one: world\n")
+}
+
+func TestYAMLRoundTrip(t *testing.T) {
+	for _, indent := range []bool{false, true} {
+		render := New(Options{
+			IndentYAML: indent,
+		})
+
+		res := renderYAML(render, yamlFixture)
+
+		expect(t, res.Code, http.StatusOK)
+		value := parseTestYAML(t, res.Body.String())
+		if !reflect.DeepEqual(value, jsonValue(t, yamlFixture)) {
+			t.Errorf("YAML (indented: %v) did not round-trip, got %#v from:\n%s", indent, value, res.Body.String())
+		}
+	}
+}
+
+func TestYAMLScalar(t *testing.T) {
+	render := New(Options{
+		IndentYAML: true,
+	})
+
+	expect(t, renderYAML(render, "no").Body.String(), "\"no\"\n")
+	expect(t, renderYAML(render, 42).Body.String(), "42\n")
+	expect(t, renderYAML(render, nil).Body.String(), "null\n")
+	expect(t, renderYAML(render, []int{}).Body.String(), "[]\n")
+}
+
+func TestYAMLError(t *testing.T) {
+	render := New(Options{
+		IndentYAML: true,
+	})
+
+	res := renderYAML(render, map[string]interface{}{"fn": func() {}})
+	expect(t, res.Code, http.StatusInternalServerError)
+}
+
+/*
+ * Parser for the block style YAML written by the YAML renderer, which is only
+ * needed to check that it can be read back.
+ */
+
+var testYAMLKey = regexp.MustCompile(`^("(?:[^"\\]|\\.)*"|[^" -][^:]*):(?: (.*))?$`)
+
+func parseTestYAML(t *testing.T, doc string) interface{} {
+	lines := strings.Split(strings.TrimRight(doc, "\n"), "\n")
+	if len(lines) == 1 && !testYAMLKey.MatchString(lines[0]) && !strings.HasPrefix(lines[0], "-") {
+		return parseTestYAMLScalar(t, lines[0])
+	}
+	value, next := parseTestYAMLBlock(t, lines, 0, 0)
+	if next != len(lines) {
+		t.Fatalf("Unexpected YAML at line %d: %q", next+1, lines[next])
+	}
+	return value
+}
+
+func testYAMLIndent(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+func parseTestYAMLBlock(t *testing.T, lines []string, i, indent int) (interface{}, int) {
+	if strings.HasPrefix(lines[i][indent:], "-") {
+		seq := []interface{}{}
+		for i < len(lines) && testYAMLIndent(lines[i]) == indent && strings.HasPrefix(lines[i][indent:], "-") {
+			content := strings.TrimPrefix(lines[i][indent+1:], " ")
+			if testYAMLKey.MatchString(content) || strings.HasPrefix(content, "- ") {
+				// A mapping or sequence starting on the same line as the dash
+				lines[i] = strings.Repeat(" ", indent+2) + content
+				value, next := parseTestYAMLBlock(t, lines, i, indent+2)
+				seq, i = append(seq, value), next
+				continue
+			}
+			seq, i = append(seq, parseTestYAMLScalar(t, content)), i+1
+		}
+		return seq, i
+	}
+
+	mapping := map[string]interface{}{}
+	for i < len(lines) && testYAMLIndent(lines[i]) == indent {
+		match := testYAMLKey.FindStringSubmatch(lines[i][indent:])
+		if match == nil {
+			t.Fatalf("Invalid YAML mapping entry: %q", lines[i])
+		}
+		key := parseTestYAMLScalar(t, match[1]).(string)
+		if len(match[2]) > 0 {
+			mapping[key], i = parseTestYAMLScalar(t, match[2]), i+1
+			continue
+		}
+		mapping[key], i = parseTestYAMLBlock(t, lines, i+1, testYAMLIndent(lines[i+1]))
+	}
+	return mapping, i
+}
+
+func parseTestYAMLScalar(t *testing.T, s string) interface{} {
+	switch s {
+	case "{}":
+		return map[string]interface{}{}
+	case "[]":
+		return []interface{}{}
+	}
+
+	// Quoted strings, numbers, booleans and null (and flow style documents) are read as JSON
+	var value interface{}
+	if err := json.Unmarshal([]byte(s), &value); err == nil {
+		return value
+	}
+	return s
+}
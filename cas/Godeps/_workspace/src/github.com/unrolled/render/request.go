@@ -0,0 +1,28 @@
+package render
+
+import "net/http"
+
+// requestWriter carries what Render needs to know about the request a response is for,
+// which is recorded by the Compress and Conditional handlers.
+type requestWriter struct {
+	http.ResponseWriter
+	// Content encoding negotiated with Accept-Encoding, "" for none.
+	encoding string
+	// If-None-Match header of a GET or HEAD request.
+	ifNoneMatch string
+}
+
+// requestWriterFor returns w if it is already a requestWriter (so that handlers can be combined), or wraps it.
+func requestWriterFor(w http.ResponseWriter) *requestWriter {
+	if rw, ok := w.(*requestWriter); ok {
+		return rw
+	}
+	return &requestWriter{ResponseWriter: w}
+}
+
+// Flush implements http.Flusher, so streamed responses can still be flushed.
+func (rw *requestWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
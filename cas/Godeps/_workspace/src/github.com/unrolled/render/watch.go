@@ -0,0 +1,101 @@
+package render
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// templateFile is the state of a template file used to detect changes.
+type templateFile struct {
+	modTime time.Time
+	size    int64
+}
+
+// templateWatcher recompiles the templates of a Render when files in its directory are changed, added or removed.
+// The directory is checked every WatchInterval, and templates are only recompiled once a check finds no further
+// changes, so that rapid edits are coalesced into a single recompilation.
+type templateWatcher struct {
+	r        *Render
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newTemplateWatcher(r *Render) *templateWatcher {
+	w := &templateWatcher{
+		r:    r,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go w.run(r.templateFiles())
+	return w
+}
+
+// Stop the watcher, waiting for a recompilation in progress to finish.
+func (w *templateWatcher) Stop() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+func (w *templateWatcher) run(files map[string]templateFile) {
+	defer close(w.done)
+
+	ticker := time.NewTicker(w.r.opt.WatchInterval)
+	defer ticker.Stop()
+
+	pending := false
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+
+		current := w.r.templateFiles()
+		if !sameTemplateFiles(files, current) {
+			// Wait for the directory to settle before recompiling.
+			files = current
+			pending = true
+			continue
+		}
+		if !pending {
+			continue
+		}
+
+		// A template that fails to parse keeps the previous templates in use, until it is changed again.
+		pending = false
+		if err := w.r.recompileTemplates(); err != nil {
+			log.Printf("render: failed to recompile templates: %v", err)
+		}
+	}
+}
+
+// templateFiles returns the state of the template files in the directory, by path.
+func (r *Render) templateFiles() map[string]templateFile {
+	files := map[string]templateFile{}
+	filepath.Walk(r.opt.Directory, func(path string, info os.FileInfo, err error) error {
+		if info == nil || info.IsDir() {
+			return nil
+		}
+		if _, ok := r.templateName(filepath.ToSlash(path)); ok {
+			files[path] = templateFile{modTime: info.ModTime(), size: info.Size()}
+		}
+		return nil
+	})
+	return files
+}
+
+func sameTemplateFiles(a, b map[string]templateFile) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, file := range a {
+		if other, ok := b[path]; !ok || !other.modTime.Equal(file.modTime) || other.size != file.size {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// marshalYAML encodes v as YAML, going through its JSON encoding. Unless indented, the (compact) JSON itself
+// is used, as JSON is valid flow style YAML.
+func marshalYAML(v interface{}, indent bool) ([]byte, error) {
+	if !indent {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+
+	node, err := decodeJSONNode(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	switch {
+	case !node.isMap && !node.isSeq:
+		buf.WriteString(yamlScalar(node.value) + "\n")
+	case len(node.values) == 0 && node.isMap:
+		buf.WriteString("{}\n")
+	case len(node.values) == 0:
+		buf.WriteString("[]\n")
+	default:
+		writeYAMLNode(&buf, node, 0)
+	}
+	return buf.Bytes(), nil
+}
+
+// writeYAMLNode writes a mapping or sequence in block style, at the given indentation.
+func writeYAMLNode(buf *bytes.Buffer, node *jsonNode, indent int) {
+	prefix := strings.Repeat(" ", indent)
+	for i, value := range node.values {
+		if node.isMap {
+			buf.WriteString(prefix + yamlString(node.keys[i]) + ":")
+		} else {
+			buf.WriteString(prefix + "-")
+		}
+
+		switch {
+		case !value.isMap && !value.isSeq:
+			buf.WriteString(" " + yamlScalar(value.value) + "\n")
+		case len(value.values) == 0 && value.isMap:
+			buf.WriteString(" {}\n")
+		case len(value.values) == 0:
+			buf.WriteString(" []\n")
+		case node.isSeq:
+			// Items start on the same line as their dash.
+			var item bytes.Buffer
+			writeYAMLNode(&item, value, indent+2)
+			buf.WriteString(" ")
+			buf.Write(item.Bytes()[indent+2:])
+		default:
+			buf.WriteString("\n")
+			writeYAMLNode(buf, value, indent+2)
+		}
+	}
+}
+
+func yamlScalar(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return yamlString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Strings that can be written unquoted (plain), without being read back as another type.
+var yamlPlainString = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_./-]*( [A-Za-z0-9_./-]+)*$`)
+
+var yamlReservedWords = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true, "y": true, "n": true,
+	"null": true, "~": true,
+}
+
+// yamlString returns a string as a plain scalar if it is safe to, otherwise double quoted (JSON escapes are valid YAML).
+func yamlString(s string) string {
+	if yamlPlainString.MatchString(s) && !yamlReservedWords[strings.ToLower(s)] {
+		return s
+	}
+	var quoted bytes.Buffer
+	enc := json.NewEncoder(&quoted)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	return strings.TrimSuffix(quoted.String(), "\n")
+}
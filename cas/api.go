@@ -1,10 +1,15 @@
 package cas
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/context"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/mux"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
 /*
@@ -12,10 +17,67 @@ import (
  */
 
 func NewCasgoFrontendAPI(c *CAS) (*FrontendAPI, error) {
-	return &FrontendAPI{casServer: c}, nil
+	maxBodySize, err := configInt(c.Config, "apiMaxBodySize")
+	if err != nil {
+		return nil, err
+	}
+	if maxBodySize < 0 {
+		return nil, fmt.Errorf("Invalid apiMaxBodySize [%d], must be 0 (unlimited) or more", maxBodySize)
+	}
+	requireIfMatch, err := configBool(c.Config, "requireServiceIfMatch")
+	if err != nil {
+		return nil, err
+	}
+	return &FrontendAPI{casServer: c, MaxBodySize: int64(maxBodySize), RequireIfMatch: requireIfMatch}, nil
 }
 
-// Utility function to authenticate an API user, whether user is using a web-session or passed an API key
+// Body of API error responses, with a machine-readable code alongside the message (meant for humans)
+func apiErrorResponse(casErr *CASServerError) map[string]interface{} {
+	code := casErr.Code
+	if len(code) == 0 {
+		code = "INTERNAL_ERROR"
+	}
+	return map[string]interface{}{
+		"status":  "error",
+		"code":    code,
+		"message": casErr.Msg,
+	}
+}
+
+// HTTP status of API error responses (errors without one are reported as server errors)
+func apiErrorStatus(casErr *CASServerError) int {
+	if casErr.HttpCode < 400 {
+		return http.StatusInternalServerError
+	}
+	return casErr.HttpCode
+}
+
+// Render an API error response
+func (api *FrontendAPI) renderError(w http.ResponseWriter, casErr *CASServerError) {
+	api.casServer.renderAPIJSON(w, apiErrorStatus(casErr), apiErrorResponse(casErr))
+}
+
+// Render a successful API response
+func (api *FrontendAPI) renderSuccess(w http.ResponseWriter, data interface{}) {
+	api.casServer.renderAPIJSON(w, http.StatusOK, map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// Respond to a successful request that has nothing to return (ie: a removal)
+func (api *FrontendAPI) renderNoContent(w http.ResponseWriter) {
+	api.casServer.render.NoContent(w)
+}
+
+// Render the error for a password refused by the password policy, listing the rules it failed
+func (api *FrontendAPI) renderPasswordPolicyError(w http.ResponseWriter, failures []PasswordRuleFailure) {
+	response := apiErrorResponse(&WeakPasswordError)
+	response["failedRules"] = failures
+	api.casServer.renderAPIJSON(w, apiErrorStatus(&WeakPasswordError), response)
+}
+
+// Utility function to authenticate an API user, whether user is using a web-session, an API token or passed an API key
 func authenticateAPIUser(api *FrontendAPI, req *http.Request) (*User, *CASServerError) {
 
 	// Attempt to authenticate with HTTP session
@@ -24,6 +86,11 @@ func authenticateAPIUser(api *FrontendAPI, req *http.Request) (*User, *CASServer
 		return user, nil
 	}
 
+	// Attempt to authenticate with an API token if present (an invalid token is never ignored)
+	if _, ok := bearerToken(req); ok {
+		return api.authenticateWithAPIToken(req)
+	}
+
 	// Attempt to authenticate with API key and secret if present
 	user, casErr = api.authenticateWithAPIKey(req)
 	if user != nil && casErr == nil {
@@ -37,22 +104,22 @@ func authenticateAPIUser(api *FrontendAPI, req *http.Request) (*User, *CASServer
 // Authenticate user with session
 func authenticateWithSession(api *FrontendAPI, req *http.Request) (*User, *CASServerError) {
 	// Get the current session
-	session, err := api.casServer.cookieStore.Get(req, "casgo-session")
+	session, err := api.casServer.sessionStore.Get(req, "casgo-session")
 	if err != nil {
 		casErr := &FailedToRetrieveServicesError
 		casErr.err = &err
 		return nil, casErr
 	}
 
-	// Retrive current user from session
-	user, ok := session.Values["currentUser"].(User)
+	// Retrive current user from session (only if the session's ticket-granting ticket is still valid)
+	user, ok := api.casServer.sessionUser(api.casServer.backendFor(req), session)
 	if !ok {
 		casErr := &FailedToRetrieveInformationFromSessionError
 		casErr.err = &err
 		return nil, casErr
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 func (api *FrontendAPI) authenticateWithAPIKey(req *http.Request) (*User, *CASServerError) {
@@ -63,83 +130,203 @@ func (api *FrontendAPI) authenticateWithAPIKey(req *http.Request) (*User, *CASSe
 		return nil, &FailedToAuthenticateUserError
 	}
 
-	user, casErr := api.casServer.Db.FindUserByApiKeyAndSecret(apiKey, apiSecret)
+	// The key (never the secret) stands in for the actor when it matches no user
+	user, casErr := api.casServer.backendFor(req).FindUserByApiKeyAndSecret(apiKey, apiSecret)
+	event := withAuditDetails(NewAuditEvent(AUDIT_API_KEY_USED, apiKey, "", casErr), "apiKey", apiKey)
 	if casErr != nil {
+		api.casServer.audit(req, event)
 		return nil, casErr
 	}
+	event.Actor = user.Email
+	api.casServer.audit(req, event)
 
 	return user, nil
 }
 
+// Get the bearer token from a request's Authorization header
+func bearerToken(req *http.Request) (string, bool) {
+	authorization := strings.TrimSpace(req.Header.Get("Authorization"))
+	if len(authorization) < 7 || !strings.EqualFold(authorization[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(authorization[7:]), true
+}
+
+// Authenticate user with an API token (issued by the token endpoint)
+// Tokens only grant admin access while the user is still an admin
+func (api *FrontendAPI) authenticateWithAPIToken(req *http.Request) (*User, *CASServerError) {
+	token, ok := bearerToken(req)
+	secret := api.casServer.Config["apiTokenSecret"]
+	if !ok || len(secret) == 0 {
+		return nil, &InvalidAPITokenError
+	}
+
+	claims, err := ParseAPIToken(token, []byte(secret), api.casServer.now())
+	if err == ErrExpiredAPIToken {
+		casErr := &ExpiredAPITokenError
+		casErr.err = &err
+		return nil, casErr
+	} else if err != nil {
+		casErr := &InvalidAPITokenError
+		casErr.err = &err
+		return nil, casErr
+	}
+
+	user, casErr := api.casServer.backendFor(req).FindUserByEmail(claims.Subject)
+	if casErr != nil {
+		return nil, &InvalidAPITokenError
+	}
+	user.Password = ""
+	user.IsAdmin = user.IsAdmin && claims.Role == API_TOKEN_ROLE_ADMIN
+
+	return user, nil
+}
+
+// Exchange an API key and secret (X-Api-Key/X-Api-Secret headers) for a signed, short-lived API token
+func (api *FrontendAPI) CreateAPIToken(w http.ResponseWriter, req *http.Request) {
+	secret := api.casServer.Config["apiTokenSecret"]
+	if len(secret) == 0 {
+		api.renderError(w, &UnsupportedFeatureError)
+		return
+	}
+
+	user, casErr := api.authenticateWithAPIKey(req)
+	if casErr != nil {
+		api.renderError(w, &FailedToAuthenticateUserError)
+		return
+	}
+
+	ttl := configSecondsAsDuration(api.casServer.Config, "apiTokenTTL")
+	token, err := NewAPIToken(user, []byte(secret), api.casServer.now(), ttl)
+	if err != nil {
+		casErr := &FailedToCreateAPITokenError
+		casErr.err = &err
+		api.casServer.audit(req, NewAuditEvent(AUDIT_API_TOKEN_ISSUED, user.Email, "", casErr))
+		api.renderError(w, casErr)
+		return
+	}
+	api.casServer.audit(req, NewAuditEvent(AUDIT_API_TOKEN_ISSUED, user.Email, "", nil))
+
+	api.renderSuccess(w, map[string]interface{}{
+		"token":     token,
+		"tokenType": "Bearer",
+		"expiresIn": int(ttl.Seconds()),
+	})
+}
+
 // Middleware for routes that require admin access
 func (api *FrontendAPI) WrapAdminOnlyEndpoint(handler func(http.ResponseWriter, *http.Request)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, req *http.Request) {
 		// Get session and user
 		requestingUser, casErr := authenticateAPIUser(api, req)
 		if casErr != nil {
-			api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-				"status":  "error",
-				"message": casErr.Msg,
-			})
+			api.renderError(w, casErr)
 			return
 		}
 
 		// Ensure user is admin
 		if !requestingUser.IsAdmin {
-			api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-				"status":  "error",
-				"message": InsufficientPermissionsError.Msg,
-			})
+			api.renderError(w, &InsufficientPermissionsError)
 			return
 		}
 
-		// Run the actual handler
+		// Run the actual handler, letting it know who is acting
+		// (kept alongside the route variables, which mux clears once the request is served)
+		context.Set(req, apiUserKey, requestingUser)
 		handler(w, req)
 	}
 }
 
+// Refuse API requests with bodies larger than MaxBodySize (before they reach handlers, which read bodies whole)
+// Bodies are read through http.MaxBytesReader, so requests without a Content-Length are cut off at the limit too
+func (api *FrontendAPI) limitRequestBodies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if api.MaxBodySize <= 0 || req.Body == nil || req.Body == http.NoBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+		if req.ContentLength > api.MaxBodySize {
+			api.renderError(w, &RequestBodyTooLargeError)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, api.MaxBodySize))
+		if err != nil {
+			api.renderError(w, &RequestBodyTooLargeError)
+			return
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, req)
+	})
+}
+
+type apiContextKey int
+
+const apiUserKey apiContextKey = 0
+
+// Email of the user authenticated by WrapAdminOnlyEndpoint for a request (if any)
+func apiUserEmail(req *http.Request) string {
+	if user, ok := context.Get(req, apiUserKey).(*User); ok {
+		return user.Email
+	}
+	return ""
+}
+
 // Hook up API endpoints to given mux
-func (api *FrontendAPI) HookupAPIEndpoints(m *mux.Router) {
+// API endpoints are served by their own router, so that cross-origin requests (if allowed) can be handled for all of them
+func (api *FrontendAPI) HookupAPIEndpoints(parent *mux.Router) {
+	m := mux.NewRouter()
+	parent.PathPrefix("/api/").Handler(api.casServer.CORS.Handler(api.limitRequestBodies(m)))
+
+	// API token endpoint
+	m.HandleFunc("/api/token", api.CreateAPIToken).Methods("POST")
+
 	// Session information endpoints
 	m.HandleFunc("/api/sessions/{userEmail}/services", api.listSessionUserServices).Methods("GET")
 	m.HandleFunc("/api/sessions", api.SessionsHandler).Methods("GET")
 
 	// Service endpoints
+	m.HandleFunc("/api/me", api.GetProfile).Methods("GET")
+	m.HandleFunc("/api/me", api.UpdateProfile).Methods("PATCH")
 	m.HandleFunc("/api/users", api.GetUsers).Methods("GET")
 	m.HandleFunc("/api/users", api.CreateUser).Methods("POST")
 	m.HandleFunc("/api/users/{userEmail}", api.UpdateUser).Methods("PUT")
 	m.HandleFunc("/api/users/{userEmail}", api.RemoveUser).Methods("DELETE")
+	m.HandleFunc("/api/users/{userEmail}/sessions", api.GetUserSessions).Methods("GET")
+	m.HandleFunc("/api/users/{userEmail}/sessions/{sessionId}", api.RevokeUserSession).Methods("DELETE")
+	m.HandleFunc("/api/users/{userEmail}/totp", api.StartTOTPEnrollment).Methods("POST")
+	m.HandleFunc("/api/users/{userEmail}/totp/verify", api.ConfirmTOTPEnrollment).Methods("POST")
+	m.HandleFunc("/api/users/{userEmail}/totp", api.DisableTOTP).Methods("DELETE")
 	m.HandleFunc("/api/services", api.GetServices).Methods("GET")
 	m.HandleFunc("/api/services", api.WrapAdminOnlyEndpoint(api.CreateService)).Methods("POST")
+	m.HandleFunc("/api/services/import", api.WrapAdminOnlyEndpoint(api.ImportServices)).Methods("POST")
 	m.HandleFunc("/api/services/{serviceName}", api.WrapAdminOnlyEndpoint(api.UpdateService)).Methods("PUT")
 	m.HandleFunc("/api/services/{serviceName}", api.RemoveService).Methods("DELETE")
+
+	// Backup endpoints
+	m.HandleFunc("/api/export", api.WrapAdminOnlyEndpoint(api.ExportBackup)).Methods("GET")
+	m.HandleFunc("/api/import", api.WrapAdminOnlyEndpoint(api.RestoreBackup)).Methods("POST")
+
+	// Audit trail endpoint
+	m.HandleFunc("/api/audit", api.WrapAdminOnlyEndpoint(api.GetAuditEvents)).Methods("GET")
 }
 
 // Handle sessions endpoint
 func (api *FrontendAPI) SessionsHandler(w http.ResponseWriter, req *http.Request) {
 	user, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   user,
-	})
+	api.renderSuccess(w, user)
 }
 
 // Get the services for a logged in user
 func (api *FrontendAPI) listSessionUserServices(w http.ResponseWriter, req *http.Request) {
 	user, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
@@ -149,18 +336,12 @@ func (api *FrontendAPI) listSessionUserServices(w http.ResponseWriter, req *http
 
 	// Ensure non-admin user is not trying to lookup another users session information
 	if !user.IsAdmin && user.Email != routeUserEmail {
-		api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InsufficientPermissionsError.Msg,
-		})
+		api.renderError(w, &InsufficientPermissionsError)
 		return
 	}
 
 	// Return the user's services
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   user.Services,
-	})
+	api.renderSuccess(w, user.Services)
 }
 
 ///////////
@@ -172,36 +353,81 @@ func (api *FrontendAPI) GetUsers(w http.ResponseWriter, req *http.Request) {
 	// Get the current session and user
 	user, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
 	// Ensure user is admin
 	if !user.IsAdmin {
-		api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InsufficientPermissionsError.Msg,
-		})
+		api.renderError(w, &InsufficientPermissionsError)
 		return
 	}
 
-	// Grab list of all users
-	users, casErr := api.casServer.Db.GetAllUsers()
+	// Grab list of all users, unless a page of users was requested
+	query, paged, casErr := userQueryFromRequest(req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   users,
-	})
+	if !paged {
+		users, casErr := api.casServer.backendFor(req).GetAllUsers()
+		if casErr != nil {
+			api.renderError(w, casErr)
+			return
+		}
+		for i := range users {
+			users[i].Password = ""
+		}
+
+		api.renderSuccess(w, users)
+		return
+	}
+
+	users, total, casErr := api.casServer.backendFor(req).FindUsers(query)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+	for i := range users {
+		users[i].Password = ""
+	}
+
+	api.renderSuccess(w, &APIPage{ItemsKey: "users", Items: users, Total: total, Offset: query.Offset, Limit: query.Limit, HasMore: query.Offset+len(users) < total})
+}
+
+// Build a user query from the limit, offset, email and role query parameters
+func userQueryFromRequest(req *http.Request) (CASUserQuery, bool, *CASServerError) {
+	values := req.URL.Query()
+	query := CASUserQuery{
+		Email: strings.TrimSpace(values.Get("email")),
+		Role:  strings.ToLower(strings.TrimSpace(values.Get("role"))),
+	}
+	paged := false
+
+	for param, dest := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if _, ok := values[param]; !ok {
+			continue
+		}
+		paged = true
+
+		n, err := strconv.Atoi(strings.TrimSpace(values.Get(param)))
+		if err != nil || n < 0 {
+			return query, false, &InvalidPaginationParametersError
+		}
+		*dest = n
+	}
+	for _, param := range []string{"email", "role"} {
+		if _, ok := values[param]; ok {
+			paged = true
+		}
+	}
+
+	if len(query.Role) > 0 && query.Role != USER_ROLE_ADMIN && query.Role != USER_ROLE_REGULAR {
+		return query, false, &InvalidUserRoleError
+	}
+
+	return query, paged, nil
 }
 
 // Create a new user
@@ -209,10 +435,7 @@ func (api *FrontendAPI) CreateUser(w http.ResponseWriter, req *http.Request) {
 	// Get session and user
 	requestingUser, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
@@ -220,76 +443,56 @@ func (api *FrontendAPI) CreateUser(w http.ResponseWriter, req *http.Request) {
 	var user User
 	reqBody, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		api.casServer.render.JSON(w, InvalidUserError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidUserError.Msg,
-		})
+		api.renderError(w, &InvalidUserError)
 		return
 	}
 
 	// Unmarshal JSON & build user from passed in data
 	err = json.Unmarshal(reqBody, &user)
 	if err != nil {
-		api.casServer.render.JSON(w, FailedToParseJSONError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": FailedToParseJSONError.Msg,
-		})
+		api.renderError(w, &FailedToParseJSONError)
 		return
 	}
 
 	// Ensure user is valid
 	if !user.IsValid() {
-		api.casServer.render.JSON(w, InvalidUserError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidUserError.Msg,
-		})
+		api.renderError(w, &InvalidUserError)
 		return
 	}
 
 	// Ensure user is admin before adding user
 	if !requestingUser.IsAdmin {
-		api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InsufficientPermissionsError.Msg,
-		})
+		api.renderError(w, &InsufficientPermissionsError)
 		return
 	}
 
-	// Attempt to add user
-	newUser, casErr := api.casServer.Db.AddNewUser(user.Email, user.Password)
+	// Attempt to add user (if the password complies with the password policy)
+	newUser, failures, casErr := api.casServer.createUser(api.casServer.backendFor(req), user.Email, user.Password)
+	if len(failures) > 0 {
+		api.renderPasswordPolicyError(w, failures)
+		return
+	}
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   newUser,
-	})
+	api.renderSuccess(w, newUser)
 }
 
 // Remove a user
-// Returns the removed user's email
+// Responds with 204 No Content
 func (api *FrontendAPI) RemoveUser(w http.ResponseWriter, req *http.Request) {
 	// Get session and user
 	requestingUser, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
 	// Ensure user is admin
 	if !requestingUser.IsAdmin {
-		api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InsufficientPermissionsError.Msg,
-		})
+		api.renderError(w, &InsufficientPermissionsError)
 		return
 	}
 
@@ -297,39 +500,165 @@ func (api *FrontendAPI) RemoveUser(w http.ResponseWriter, req *http.Request) {
 	routeVars := mux.Vars(req)
 	userEmail := routeVars["userEmail"]
 
-	casErr = api.casServer.Db.RemoveUserByEmail(userEmail)
+	casErr = api.casServer.backendFor(req).RemoveUserByEmail(userEmail)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
+		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]string{
-		"status": "success",
-		"data":   userEmail,
+	api.renderNoContent(w)
+}
+
+// Get the active login sessions of a user (admins may see any user's sessions)
+func (api *FrontendAPI) GetUserSessions(w http.ResponseWriter, req *http.Request) {
+	requestingUser, casErr := authenticateAPIUser(api, req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Ensure non-admin user is not trying to lookup another user's sessions
+	userEmail := mux.Vars(req)["userEmail"]
+	if !requestingUser.IsAdmin && requestingUser.Email != userEmail {
+		api.renderError(w, &InsufficientPermissionsError)
+		return
+	}
+
+	sessions, casErr := api.casServer.activeSessionsForUser(api.casServer.backendFor(req), userEmail)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	api.renderSuccess(w, sessions)
+}
+
+// Revoke a login session of a user (admins may revoke any user's sessions)
+// Services the session was used to log in to are sent logout requests, responds with 204 No Content
+func (api *FrontendAPI) RevokeUserSession(w http.ResponseWriter, req *http.Request) {
+	requestingUser, casErr := authenticateAPIUser(api, req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Ensure non-admin user is not trying to revoke another user's sessions
+	routeVars := mux.Vars(req)
+	userEmail, sessionId := routeVars["userEmail"], routeVars["sessionId"]
+	if !requestingUser.IsAdmin && requestingUser.Email != userEmail {
+		api.renderError(w, &InsufficientPermissionsError)
+		return
+	}
+
+	casErr = api.casServer.revokeSession(api.casServer.backendFor(req), userEmail, sessionId)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	api.renderNoContent(w)
+}
+
+// Start two-factor authentication enrollment for the requesting user
+// Returns a new secret and its otpauth:// URI, the secret is enabled once a code generated from it has been verified
+func (api *FrontendAPI) StartTOTPEnrollment(w http.ResponseWriter, req *http.Request) {
+	requestingUser, casErr := authenticateAPIUser(api, req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Users may only enroll themselves (secrets are only ever shown to their owner)
+	userEmail := mux.Vars(req)["userEmail"]
+	if requestingUser.Email != userEmail {
+		api.renderError(w, &InsufficientPermissionsError)
+		return
+	}
+
+	secret, uri, casErr := api.casServer.startTOTPEnrollment(api.casServer.backendFor(req), userEmail)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	api.renderSuccess(w, map[string]string{
+		"secret": secret,
+		"uri":    uri,
 	})
 }
 
+// Verify a code (JSON body {"code": "123456"}) generated from a pending secret, enabling two-factor authentication
+func (api *FrontendAPI) ConfirmTOTPEnrollment(w http.ResponseWriter, req *http.Request) {
+	requestingUser, casErr := authenticateAPIUser(api, req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	userEmail := mux.Vars(req)["userEmail"]
+	if requestingUser.Email != userEmail {
+		api.renderError(w, &InsufficientPermissionsError)
+		return
+	}
+
+	var body struct {
+		Code string `json:"code"`
+	}
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err == nil {
+		err = json.Unmarshal(reqBody, &body)
+	}
+	if err != nil {
+		api.renderError(w, &FailedToParseJSONError)
+		return
+	}
+
+	casErr = api.casServer.confirmTOTPEnrollment(api.casServer.backendFor(req), userEmail, body.Code)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	api.renderSuccess(w, userEmail)
+}
+
+// Disable two-factor authentication for a user (admins may disable it for any user)
+// Responds with 204 No Content
+func (api *FrontendAPI) DisableTOTP(w http.ResponseWriter, req *http.Request) {
+	requestingUser, casErr := authenticateAPIUser(api, req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	userEmail := mux.Vars(req)["userEmail"]
+	if !requestingUser.IsAdmin && requestingUser.Email != userEmail {
+		api.renderError(w, &InsufficientPermissionsError)
+		return
+	}
+
+	casErr = api.casServer.disableTOTP(api.casServer.backendFor(req), userEmail)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	api.renderNoContent(w)
+}
+
 // Update an existing user
 // Returns the modified user
 func (api *FrontendAPI) UpdateUser(w http.ResponseWriter, req *http.Request) {
 	// Get session and user
 	requestingUser, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
 	// Ensure user is admin
 	if !requestingUser.IsAdmin {
-		api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InsufficientPermissionsError.Msg,
-		})
+		api.renderError(w, &InsufficientPermissionsError)
 		return
 	}
 
@@ -341,46 +670,151 @@ func (api *FrontendAPI) UpdateUser(w http.ResponseWriter, req *http.Request) {
 	var user User
 	reqBody, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		api.casServer.render.JSON(w, InvalidUserError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidUserError.Msg,
-		})
+		api.renderError(w, &InvalidUserError)
 		return
 	}
 
 	// Unmarshal JSON & build user from passed in data
 	err = json.Unmarshal(reqBody, &user)
 	if err != nil {
-		api.casServer.render.JSON(w, FailedToParseJSONError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": FailedToParseJSONError.Msg,
-		})
+		api.renderError(w, &FailedToParseJSONError)
 		return
 	}
 
 	// Ensure user is valid
 	if !user.IsValidUpdate() || userEmail != user.Email {
-		api.casServer.render.JSON(w, InvalidUserError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidUserError.Msg,
-		})
+		api.renderError(w, &InvalidUserError)
 		return
 	}
 
+	// Two-factor authentication is only managed through its own endpoints
+	existingUser, findErr := api.casServer.backendFor(req).FindUserByEmail(userEmail)
+	if findErr == nil {
+		user.TOTP = existingUser.TOTP
+	}
+
+	// Passwords are changed by passing a new one, which must comply with the password policy
+	// (the stored hash is kept when the password is left out or passed back unchanged)
+	if findErr == nil && (len(user.Password) == 0 || user.Password == existingUser.Password) {
+		user.Password = existingUser.Password
+	} else if len(user.Password) > 0 {
+		if failures := api.casServer.PasswordPolicy.Check(user.Password); len(failures) > 0 {
+			api.renderPasswordPolicyError(w, failures)
+			return
+		}
+		hashedPassword, err := api.casServer.PasswordHasher.Hash(user.Password)
+		if err != nil {
+			api.renderError(w, &FailedToUpdateUserError)
+			return
+		}
+		user.Password = hashedPassword
+	}
+
 	// Attempt to update the user
-	casErr = api.casServer.Db.UpdateUser(&user)
+	casErr = api.casServer.backendFor(req).UpdateUser(&user)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   user,
-	})
+	api.renderSuccess(w, user)
+}
+
+// Changes users may make to their own profile (fields left out are unchanged)
+type profileUpdate struct {
+	Email                  *string            `json:"email"`
+	Name                   *string            `json:"name"`
+	Attributes             *map[string]string `json:"attributes"`
+	Password               *string            `json:"password"`
+	IsAdmin                *bool              `json:"isAdmin"`
+	WarnBeforeServiceLogin *bool              `json:"warnBeforeServiceLogin"`
+}
+
+// Get the requesting user's own profile
+func (api *FrontendAPI) GetProfile(w http.ResponseWriter, req *http.Request) {
+	requestingUser, casErr := authenticateAPIUser(api, req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	user, casErr := api.casServer.backendFor(req).FindUserByEmail(requestingUser.Email)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+	user.Password = ""
+
+	api.renderSuccess(w, user)
+}
+
+// Update the requesting user's own profile (name, attributes, password and login preferences)
+// Users may not change their email or role, which only admins can do (see UpdateUser)
+// Returns the modified user
+func (api *FrontendAPI) UpdateProfile(w http.ResponseWriter, req *http.Request) {
+	requestingUser, casErr := authenticateAPIUser(api, req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Read JSON from request body
+	var update profileUpdate
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err == nil {
+		err = json.Unmarshal(reqBody, &update)
+	}
+	if err != nil {
+		api.renderError(w, &FailedToParseJSONError)
+		return
+	}
+
+	// Changes are made to the stored user (the API key's or token's copy of the user may be out of date)
+	user, casErr := api.casServer.backendFor(req).FindUserByEmail(requestingUser.Email)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Ensure the user is not trying to edit another user, or change their role
+	if (update.Email != nil && *update.Email != user.Email) || (update.IsAdmin != nil && *update.IsAdmin != user.IsAdmin) {
+		api.renderError(w, &InsufficientPermissionsError)
+		return
+	}
+
+	if update.Name != nil {
+		user.Name = *update.Name
+	}
+	if update.Attributes != nil {
+		user.Attributes = *update.Attributes
+	}
+	if update.WarnBeforeServiceLogin != nil {
+		user.WarnBeforeServiceLogin = *update.WarnBeforeServiceLogin
+	}
+
+	// New passwords must comply with the password policy
+	if update.Password != nil {
+		if failures := api.casServer.PasswordPolicy.Check(*update.Password); len(failures) > 0 {
+			api.renderPasswordPolicyError(w, failures)
+			return
+		}
+		hashedPassword, err := api.casServer.PasswordHasher.Hash(*update.Password)
+		if err != nil {
+			api.renderError(w, &FailedToUpdateUserError)
+			return
+		}
+		user.Password = hashedPassword
+	}
+
+	// Attempt to update the user
+	casErr = api.casServer.backendFor(req).UpdateUser(user)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+	user.Password = ""
+
+	api.renderSuccess(w, user)
 }
 
 //////////////
@@ -392,36 +826,67 @@ func (api *FrontendAPI) GetServices(w http.ResponseWriter, req *http.Request) {
 	// Get the current session and user
 	user, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
 	// Ensure user is admin
 	if !user.IsAdmin {
-		api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InsufficientPermissionsError.Msg,
-		})
+		api.renderError(w, &InsufficientPermissionsError)
 		return
 	}
 
-	// Grab list of all services
-	services, casErr := api.casServer.Db.GetAllServices()
+	// Grab list of all services, unless a page of services was requested
+	query, paged, casErr := serviceQueryFromRequest(req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   services,
-	})
+	if !paged {
+		services, casErr := api.casServer.backendFor(req).GetAllServices()
+		if casErr != nil {
+			api.renderError(w, casErr)
+			return
+		}
+
+		api.renderSuccess(w, services)
+		return
+	}
+
+	services, total, casErr := api.casServer.backendFor(req).FindServices(query)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	api.renderSuccess(w, &APIPage{ItemsKey: "services", Items: services, Total: total, Offset: query.Offset, Limit: query.Limit, HasMore: query.Offset+len(services) < total})
+}
+
+// Build a service query from the limit, offset and name query parameters
+// Returns whether any of the parameters were given (otherwise all services should be listed)
+func serviceQueryFromRequest(req *http.Request) (CASServiceQuery, bool, *CASServerError) {
+	values := req.URL.Query()
+	query := CASServiceQuery{Name: strings.TrimSpace(values.Get("name"))}
+	paged := false
+
+	for param, dest := range map[string]*int{"limit": &query.Limit, "offset": &query.Offset} {
+		if _, ok := values[param]; !ok {
+			continue
+		}
+		paged = true
+
+		n, err := strconv.Atoi(strings.TrimSpace(values.Get(param)))
+		if err != nil || n < 0 {
+			return query, false, &InvalidPaginationParametersError
+		}
+		*dest = n
+	}
+	if _, ok := values["name"]; ok {
+		paged = true
+	}
+
+	return query, paged, nil
 }
 
 // Create a new service
@@ -429,10 +894,7 @@ func (api *FrontendAPI) CreateService(w http.ResponseWriter, req *http.Request)
 	// Read JSON from request body
 	reqBody, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		api.casServer.render.JSON(w, FailedToParseJSONError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": FailedToParseJSONError.Msg,
-		})
+		api.renderError(w, &FailedToParseJSONError)
 		return
 	}
 
@@ -440,76 +902,169 @@ func (api *FrontendAPI) CreateService(w http.ResponseWriter, req *http.Request)
 	var service CASService
 	err = json.Unmarshal(reqBody, &service)
 	if err != nil {
-		api.casServer.render.JSON(w, InvalidServiceError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidServiceError.Msg,
-		})
+		api.renderError(w, &InvalidServiceError)
+		return
+	}
+
+	// Service IDs are assigned by the backend, and new services start at the first version
+	service.Id, service.Version = "", 1
+	if casErr := setServiceSecretFromJSON(reqBody, &service, &FailedToCreateServiceError); casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Ensure the service's URL can be matched (regular expressions are checked when the service is created)
+	if casErr := api.casServer.validateServiceUrl(&service); casErr != nil {
+		api.renderError(w, casErr)
 		return
 	}
 
 	// Ensure service is valid
 	if !service.IsValid() {
-		api.casServer.render.JSON(w, InvalidServiceError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidServiceError.Msg,
-		})
+		api.renderError(w, &InvalidServiceError)
 		return
 	}
 
 	// Attempt to add service
-	casErr := api.casServer.Db.AddNewService(&service)
+	casErr := api.casServer.backendFor(req).AddNewService(&service)
+	api.casServer.audit(req, NewAuditEvent(AUDIT_SERVICE_CREATED, apiUserEmail(req), service.Name, casErr))
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   service,
-	})
+	w.Header().Set("ETag", serviceETag(&service))
+	api.renderSuccess(w, service)
+}
+
+// Create or update services in bulk, from a JSON array of services
+// All services are imported or none are, unless partial=true is given
+// Existing services are only replaced when overwrite=true is given
+// Returns a per-service report (along with the error, if the import failed)
+func (api *FrontendAPI) ImportServices(w http.ResponseWriter, req *http.Request) {
+	opts, casErr := importOptionsFromRequest(req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Read JSON from request body
+	var services []CASService
+	reqBody, err := ioutil.ReadAll(req.Body)
+	if err == nil {
+		err = json.Unmarshal(reqBody, &services)
+	}
+	if err != nil || services == nil {
+		api.renderError(w, &FailedToParseJSONError)
+		return
+	}
+
+	report, casErr := api.casServer.ImportServices(services, opts)
+	api.auditImportedServices(req, report)
+	if casErr != nil {
+		response := apiErrorResponse(casErr)
+		if report != nil {
+			response["data"] = report
+		}
+		api.casServer.renderAPIJSON(w, apiErrorStatus(casErr), response)
+		return
+	}
+
+	api.renderSuccess(w, report)
+}
+
+// Record the services an import created or updated (nothing is written for the others)
+func (api *FrontendAPI) auditImportedServices(req *http.Request, report *ImportReport) {
+	if report == nil {
+		return
+	}
+	for _, result := range report.Results {
+		switch result.Status {
+		case IMPORT_CREATED:
+			api.casServer.audit(req, withAuditDetails(NewAuditEvent(AUDIT_SERVICE_CREATED, apiUserEmail(req), result.Name, nil), "import", "true"))
+		case IMPORT_UPDATED:
+			api.casServer.audit(req, withAuditDetails(NewAuditEvent(AUDIT_SERVICE_UPDATED, apiUserEmail(req), result.Name, nil), "import", "true"))
+		}
+	}
+}
+
+// Build import options from the partial and overwrite query parameters
+func importOptionsFromRequest(req *http.Request) (ImportOptions, *CASServerError) {
+	values := req.URL.Query()
+	opts := ImportOptions{}
+
+	for param, dest := range map[string]*bool{"partial": &opts.Partial, "overwrite": &opts.Overwrite} {
+		if _, ok := values[param]; !ok {
+			continue
+		}
+		flag, err := strconv.ParseBool(strings.TrimSpace(values.Get(param)))
+		if err != nil {
+			return opts, &InvalidServiceImportParametersError
+		}
+		*dest = flag
+	}
+
+	return opts, nil
+}
+
+// Check a service's URL against its match mode and the service URL policy, normalizing it
+// (services without a URL are left to the other validation)
+func (c *CAS) validateServiceUrl(service *CASService) *CASServerError {
+	if len(service.Url) == 0 {
+		return nil
+	}
+	if err := service.ValidateUrlPattern(); err != nil {
+		casErr := &InvalidServiceUrlPatternError
+		casErr.err = &err
+		return casErr
+	}
+	if casErr := c.normalizeServiceUrl(service); casErr != nil {
+		return casErr
+	}
+	if !c.ServiceUrlPolicy.AllowsService(service) {
+		return &InsecureServiceUrlError
+	}
+	return nil
 }
 
 // Remove a service
-// Returns the removed service's name
+// Responds with 204 No Content
 func (api *FrontendAPI) RemoveService(w http.ResponseWriter, req *http.Request) {
 	// Get session and user
 	user, casErr := authenticateAPIUser(api, req)
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
 	// Ensure user is admin
 	if !user.IsAdmin {
-		api.casServer.render.JSON(w, InsufficientPermissionsError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InsufficientPermissionsError.Msg,
-		})
+		api.renderError(w, &InsufficientPermissionsError)
 		return
 	}
 
-	// Get passed in service name
+	// Get passed in service name, or the ID of the service (service names can't look like IDs)
 	routeVars := mux.Vars(req)
 	serviceName := routeVars["serviceName"]
+	if IsServiceId(serviceName) {
+		service, casErr := api.casServer.backendFor(req).FindServiceById(serviceName)
+		if casErr != nil {
+			api.casServer.audit(req, NewAuditEvent(AUDIT_SERVICE_DELETED, user.Email, serviceName, casErr))
+			api.renderError(w, casErr)
+			return
+		}
+		serviceName = service.Name
+	}
 
-	casErr = api.casServer.Db.RemoveServiceByName(serviceName)
+	// The service's attribute release policy and logout URL are removed along with it
+	casErr = api.casServer.backendFor(req).RemoveServiceByName(serviceName)
+	api.casServer.audit(req, NewAuditEvent(AUDIT_SERVICE_DELETED, user.Email, serviceName, casErr))
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
+		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]string{
-		"status": "success",
-		"data":   serviceName,
-	})
+	api.renderNoContent(w)
 }
 
 // Update an existing service
@@ -523,20 +1078,14 @@ func (api *FrontendAPI) UpdateService(w http.ResponseWriter, req *http.Request)
 	var service CASService
 	reqBody, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		api.casServer.render.JSON(w, InvalidServiceError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidServiceError.Msg,
-		})
+		api.renderError(w, &InvalidServiceError)
 		return
 	}
 
 	// Unmarshal JSON & build service from passed in data
 	err = json.Unmarshal(reqBody, &service)
 	if err != nil {
-		api.casServer.render.JSON(w, FailedToParseJSONError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": FailedToParseJSONError.Msg,
-		})
+		api.renderError(w, &FailedToParseJSONError)
 		return
 	}
 
@@ -544,28 +1093,170 @@ func (api *FrontendAPI) UpdateService(w http.ResponseWriter, req *http.Request)
 	if len(service.Name) == 0 {
 		service.Name = serviceName
 	}
+	if casErr := setServiceSecretFromJSON(reqBody, &service, &FailedToUpdateServiceError); casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Ensure the service's URL can be matched
+	if casErr := api.casServer.validateServiceUrl(&service); casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
 
 	// Ensure service is valid
 	if !service.IsValidUpdate() || serviceName != service.Name {
-		api.casServer.render.JSON(w, InvalidServiceError.HttpCode, map[string]string{
-			"status":  "error",
-			"message": InvalidServiceError.Msg,
-		})
+		api.renderError(w, &InvalidServiceError)
+		return
+	}
+
+	// Only update the version of the service the update was made to, if the update says which it was
+	version, preconditionGiven := serviceUpdatePrecondition(req, &service)
+	if !preconditionGiven && api.RequireIfMatch {
+		api.renderError(w, &ServiceVersionRequiredError)
 		return
 	}
 
 	// Attempt to update the service
-	casErr := api.casServer.Db.UpdateService(&service)
+	db := api.casServer.backendFor(req)
+	var casErr *CASServerError
+	if version != nil {
+		casErr = db.UpdateServiceIfVersion(&service, *version)
+	} else {
+		casErr = db.UpdateService(&service)
+	}
+	api.casServer.audit(req, NewAuditEvent(AUDIT_SERVICE_UPDATED, apiUserEmail(req), service.Name, casErr))
 	if casErr != nil {
-		api.casServer.render.JSON(w, casErr.HttpCode, map[string]string{
-			"status":  "error",
-			"message": casErr.Msg,
-		})
+		api.renderError(w, casErr)
 		return
 	}
 
-	api.casServer.render.JSON(w, http.StatusOK, map[string]interface{}{
-		"status": "success",
-		"data":   service,
-	})
+	w.Header().Set("ETag", serviceETag(&service))
+	api.renderSuccess(w, service)
+}
+
+// Entity tag of a service's current version
+func serviceETag(service *CASService) string {
+	return `"` + strconv.Itoa(service.Version) + `"`
+}
+
+// Version of a service an update was made to, from the If-Match header (or the version in the update, if there is none)
+// Returns nil for updates of any version ("If-Match: *", or none given), and whether a precondition was given at all
+func serviceUpdatePrecondition(req *http.Request, service *CASService) (*int, bool) {
+	ifMatch := strings.TrimSpace(req.Header.Get("If-Match"))
+	if len(ifMatch) == 0 {
+		if version := service.Version; version > 0 {
+			return &version, true
+		}
+		return nil, false
+	}
+	if ifMatch == "*" {
+		return nil, true
+	}
+
+	// Weak, malformed or lists of entity tags match no version
+	version := -1
+	if len(ifMatch) > 2 && strings.HasPrefix(ifMatch, `"`) && strings.HasSuffix(ifMatch, `"`) {
+		if parsed, err := strconv.Atoi(ifMatch[1 : len(ifMatch)-1]); err == nil {
+			version = parsed
+		}
+	}
+	return &version, true
+}
+
+/////////////
+// Backups //
+/////////////
+
+// Stream a backup of all services and users
+func (api *FrontendAPI) ExportBackup(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="casgo-backup.json"`)
+
+	// Errors can only be reported if nothing has been written yet (a truncated backup is never valid JSON)
+	recorder := &writeRecorder{ResponseWriter: w}
+	if casErr := api.casServer.WriteBackup(recorder); casErr != nil && !recorder.written {
+		w.Header().Del("Content-Disposition")
+		api.renderError(w, casErr)
+	}
+}
+
+// Response writer that records whether anything has been written
+type writeRecorder struct {
+	http.ResponseWriter
+	written bool
+}
+
+func (w *writeRecorder) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *writeRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Restore a backup made by ExportBackup
+// Accepts the same partial and overwrite parameters as service imports
+// Returns per-service and per-user reports (along with the error, if the restore failed)
+func (api *FrontendAPI) RestoreBackup(w http.ResponseWriter, req *http.Request) {
+	opts, casErr := importOptionsFromRequest(req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	backup, casErr := ReadBackup(req.Body)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	report, casErr := api.casServer.RestoreBackup(backup, opts)
+	if report != nil {
+		api.auditImportedServices(req, report.Services)
+	}
+	if casErr != nil {
+		response := apiErrorResponse(casErr)
+		if report != nil {
+			response["data"] = report
+		}
+		api.casServer.renderAPIJSON(w, apiErrorStatus(casErr), response)
+		return
+	}
+
+	api.renderSuccess(w, report)
+}
+
+///////////
+// Audit //
+///////////
+
+// Find recorded audit events, most recent first
+// Accepts since & until (RFC 3339 times), actor, type and limit (at most AUDIT_MAX_QUERY_LIMIT) query parameters
+func (api *FrontendAPI) GetAuditEvents(w http.ResponseWriter, req *http.Request) {
+	db, ok := api.casServer.backendFor(req).(AuditableBackend)
+	if !ok || !api.casServer.AuditLog.Enabled() {
+		api.renderError(w, &UnsupportedFeatureError)
+		return
+	}
+
+	query, casErr := auditQueryFromRequest(req)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	// Include events recorded by requests that have already finished
+	api.casServer.AuditLog.Flush()
+
+	events, casErr := db.FindAuditEvents(query)
+	if casErr != nil {
+		api.renderError(w, casErr)
+		return
+	}
+
+	api.renderSuccess(w, events)
 }
@@ -0,0 +1,97 @@
+package cas
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+/*
+ * Envelopes of API responses
+ *
+ * Handlers build the raw response body ({"status", "data"} on success, {"status", "code", "message"} on errors),
+ * which the deployment's APIEnvelope wraps (or reshapes) before it is rendered
+ */
+
+const (
+	API_ENVELOPE_RAW  = "raw"  // Bodies are rendered as built by handlers
+	API_ENVELOPE_DATA = "data" // Bodies are rendered as {"data", "meta"} (see DataAPIEnvelope)
+)
+
+// Function building the API response rendered (as JSON) from the raw response body and its HTTP status
+type APIEnvelope func(status int, body map[string]interface{}) interface{}
+
+// Get the API envelope specified by server configuration (apiResponseEnvelope)
+func NewAPIEnvelopeFromConfig(config map[string]string) (APIEnvelope, error) {
+	switch configValueOrDefault(config, "apiResponseEnvelope") {
+	case "", API_ENVELOPE_RAW:
+		return RawAPIEnvelope, nil
+	case API_ENVELOPE_DATA:
+		return DataAPIEnvelope, nil
+	default:
+		return nil, fmt.Errorf("Invalid apiResponseEnvelope [%s], must be %s or %s", config["apiResponseEnvelope"], API_ENVELOPE_RAW, API_ENVELOPE_DATA)
+	}
+}
+
+// Render API response bodies as they are
+func RawAPIEnvelope(status int, body map[string]interface{}) interface{} {
+	return body
+}
+
+// Render API response bodies as {"data": ..., "meta": {"status": ...}}
+// Pages of lists have their items as data, and their total, offset, limit and hasMore in meta
+// Error responses have an "error" member holding the code and message (along with other details, ex. failedRules)
+func DataAPIEnvelope(status int, body map[string]interface{}) interface{} {
+	data := body["data"]
+	meta := map[string]interface{}{"status": body["status"]}
+	if page, ok := data.(*APIPage); ok {
+		data = page.Items
+		meta["total"], meta["offset"], meta["limit"], meta["hasMore"] = page.Total, page.Offset, page.Limit, page.HasMore
+	}
+
+	details := map[string]interface{}{}
+	for key, value := range body {
+		if key != "data" && key != "status" {
+			details[key] = value
+		}
+	}
+
+	envelope := map[string]interface{}{"data": data, "meta": meta}
+	if body["status"] == "error" {
+		envelope["error"] = details
+	} else {
+		for key, value := range details {
+			meta[key] = value
+		}
+	}
+	return envelope
+}
+
+// Page of a list returned by the API (rendered as {"<ItemsKey>", "total", "offset", "limit", "hasMore"} in raw bodies)
+type APIPage struct {
+	ItemsKey string
+	Items    interface{}
+	Total    int
+	Offset   int
+	Limit    int
+	HasMore  bool
+}
+
+func (p *APIPage) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		p.ItemsKey: p.Items,
+		"total":    p.Total,
+		"offset":   p.Offset,
+		"limit":    p.Limit,
+		"hasMore":  p.HasMore,
+	})
+}
+
+// Render an API response body, wrapped by the server's API envelope
+func (c *CAS) renderAPIJSON(w http.ResponseWriter, status int, body map[string]interface{}) {
+	envelope := c.APIEnvelope
+	if envelope == nil {
+		envelope = RawAPIEnvelope
+	}
+	c.render.JSON(w, status, envelope(status, body))
+}
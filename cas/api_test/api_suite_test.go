@@ -12,6 +12,7 @@ import (
 var testHTTPServer *httptest.Server
 var testCASConfig map[string]string
 var testCASServer *cas.CAS
+var testDb cas.CASDBAdapter
 
 func TestCasgoAPI(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -27,24 +28,25 @@ var _ = BeforeSuite(func() {
 
 	testCASServer, _ = cas.NewCASServer(testCASConfig)
 	testCASServer.SetupDb()
+	testDb = testCASServer.Db.(cas.CASDBAdapter)
 
 	// Setup http test server
 	testHTTPServer = httptest.NewTLSServer(testCASServer.ServeMux)
 
 	// Load database fixtures
-	testCASServer.Db.LoadJSONFixture(
-		testCASServer.Db.GetDbName(),
-		testCASServer.Db.GetServicesTableName(),
+	testDb.LoadJSONFixture(
+		testDb.GetDbName(),
+		testDb.GetServicesTableName(),
 		"../../fixtures/services.json",
 	)
-	testCASServer.Db.LoadJSONFixture(
-		testCASServer.Db.GetDbName(),
-		testCASServer.Db.GetUsersTableName(),
+	testDb.LoadJSONFixture(
+		testDb.GetDbName(),
+		testDb.GetUsersTableName(),
 		"../../fixtures/users.json",
 	)
-	testCASServer.Db.LoadJSONFixture(
-		testCASServer.Db.GetDbName(),
-		testCASServer.Db.GetApiKeysTableName(),
+	testDb.LoadJSONFixture(
+		testDb.GetDbName(),
+		testDb.GetApiKeysTableName(),
 		"../../fixtures/api_keys.json",
 	)
 
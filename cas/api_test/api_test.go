@@ -39,11 +39,18 @@ var EXPECTED_API_ENDPOINTS map[string][]StringTuple = map[string][]StringTuple{
 
 // Helper that checks to ensure unauthorized error response from performing an API request
 func expectInsufficientPermissionsFromAPIRequest(req *http.Request) {
+	expectErrorFromAPIRequest(req, InsufficientPermissionsError)
+}
+
+// Helper that checks the error response (HTTP status, code and message) from performing an API request
+func expectErrorFromAPIRequest(req *http.Request, casErr CASServerError) {
 	// Perform request
-	_, _, respJSON := jsonAPIRequestWithCustomHeaders(req)
+	_, resp, respJSON := jsonAPIResponse(req)
 	Expect(respJSON).NotTo(BeNil())
+	Expect(resp.StatusCode).To(Equal(casErr.HttpCode))
 	Expect(respJSON["status"]).To(Equal("error"))
-	Expect(respJSON["message"]).To(Equal(InsufficientPermissionsError.Msg))
+	Expect(respJSON["code"]).To(Equal(casErr.Code))
+	Expect(respJSON["message"]).To(Equal(casErr.Msg))
 }
 
 // Function to be used with client creation to disallow redirects from API
@@ -54,6 +61,24 @@ func failRedirect(req *http.Request, via []*http.Request) error {
 
 // Utility function for performing JSON API requests
 func jsonAPIRequestWithCustomHeaders(req *http.Request) (*http.Client, *http.Request, map[string]interface{}) {
+	client, _, respJSON := jsonAPIResponse(req)
+	return client, req, respJSON
+}
+
+// Utility function for performing JSON API requests, keeping the response (for its status and headers)
+func jsonAPIResponse(req *http.Request) (*http.Client, *http.Response, map[string]interface{}) {
+	client, resp, rawBody := apiResponse(req)
+
+	// Parse response body into a map
+	var respJSON map[string]interface{}
+	err := json.Unmarshal(rawBody, &respJSON)
+	Expect(err).To(BeNil())
+
+	return client, resp, respJSON
+}
+
+// Utility function for performing API requests, returning the raw body of the response (ie: for empty responses)
+func apiResponse(req *http.Request) (*http.Client, *http.Response, []byte) {
 	// Create TLS configuration that ignores SSL
 	tlsConfig := &tls.Config{InsecureSkipVerify: true}
 	tr := &http.Transport{TLSClientConfig: tlsConfig}
@@ -70,13 +95,9 @@ func jsonAPIRequestWithCustomHeaders(req *http.Request) (*http.Client, *http.Req
 	// Read response body
 	rawBody, err := ioutil.ReadAll(resp.Body)
 	Expect(err).To(BeNil())
+	resp.Body.Close()
 
-	// Parse response body into a map
-	var respJSON map[string]interface{}
-	err = json.Unmarshal(rawBody, &respJSON)
-	Expect(err).To(BeNil())
-
-	return client, req, respJSON
+	return client, resp, rawBody
 }
 
 var _ = Describe("CasGo API", func() {
@@ -88,9 +109,25 @@ var _ = Describe("CasGo API", func() {
 			Expect(err).To(BeNil())
 
 			// Perform request
-			_, _, respJSON := jsonAPIRequestWithCustomHeaders(req)
-			Expect(respJSON["status"]).To(Equal("error"))
-			Expect(respJSON["message"]).To(Equal(FailedToAuthenticateUserError.Msg))
+			expectErrorFromAPIRequest(req, FailedToAuthenticateUserError)
+		})
+
+		It("Should fail for users with invalid API credentials", func() {
+			// Craft request with the wrong secret for the regular user's API key
+			req, err := http.NewRequest("GET", testHTTPServer.URL+API_TEST_DATA["exampleRegularUserURI"], nil)
+			Expect(err).To(BeNil())
+			req.Header.Add("X-Api-Key", API_TEST_DATA["userApiKey"])
+			req.Header.Add("X-Api-Secret", "wrongsecret")
+
+			// Perform request
+			expectErrorFromAPIRequest(req, FailedToAuthenticateUserError)
+		})
+
+		It("Should report authentication failures with a stable code and a 401 status", func() {
+			Expect(FailedToAuthenticateUserError.Code).To(Equal("AUTH_FAILED"))
+			Expect(FailedToAuthenticateUserError.HttpCode).To(Equal(http.StatusUnauthorized))
+			Expect(InsufficientPermissionsError.Code).To(Equal("INSUFFICIENT_PERMISSIONS"))
+			Expect(InsufficientPermissionsError.HttpCode).To(Equal(http.StatusForbidden))
 		})
 
 		It("Should properly authenticate a valid regular user's API key and secret to a non-admin-only endpoint", func() {
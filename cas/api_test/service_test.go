@@ -158,11 +158,11 @@ var _ = Describe("CasGo /api/services API", func() {
       req.Header.Add("X-Api-Key", API_TEST_DATA["adminApiKey"])
       req.Header.Add("X-Api-Secret", API_TEST_DATA["adminApiSecret"])
 
-      // Perform request
-      _, _, respJSON := jsonAPIRequestWithCustomHeaders(req)
-      Expect(respJSON).NotTo(BeNil())
-      Expect(respJSON["status"]).To(Equal("success"))
-      Expect(respJSON["data"]).To(Equal(API_SERVICE_TEST_DATA["nameOfFixtureServiceToDelete"]))
+      // Perform request, the removal has nothing to respond with
+      _, resp, body := apiResponse(req)
+      Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+      Expect(resp.Header.Get("Content-Type")).To(BeEmpty())
+      Expect(body).To(BeEmpty())
     })
 
     It("Should display an error for non-admin users", func() {
@@ -0,0 +1,12 @@
+package apienvelope_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoAPIEnvelope(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo API Envelope Suite")
+}
@@ -0,0 +1,142 @@
+package apienvelope_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("API response envelopes", func() {
+	var server *CAS
+
+	newServer := func(envelope string) {
+		server, _ = castest.NewTestServer(map[string]string{"apiResponseEnvelope": envelope})
+	}
+
+	// Make an API request with an API key, returning the parsed response
+	do := func(method, path, apiKey, body string) (int, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set("X-Api-Secret", "badsecret")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		return w.Code, response
+	}
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	Context("In raw mode (the default)", func() {
+		BeforeEach(func() {
+			newServer("")
+		})
+
+		It("Should render bodies as built by the API", func() {
+			code, response := do("GET", "/api/services?limit=1", "adminapikey", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response).To(HaveKeyWithValue("status", "success"))
+			Expect(response).NotTo(HaveKey("meta"))
+			page := response["data"].(map[string]interface{})
+			Expect(page["services"]).To(HaveLen(1))
+			Expect(page["total"]).To(BeNumerically("==", 3))
+			Expect(page["hasMore"]).To(BeTrue())
+
+			code, response = do("GET", "/api/users", "userapikey", "")
+			Expect(code).To(Equal(http.StatusForbidden))
+			Expect(response).To(Equal(map[string]interface{}{
+				"status":  "error",
+				"code":    InsufficientPermissionsError.Code,
+				"message": InsufficientPermissionsError.Msg,
+			}))
+		})
+	})
+
+	Context("In data mode", func() {
+		BeforeEach(func() {
+			newServer("data")
+		})
+
+		It("Should wrap details in data, with the status in meta", func() {
+			code, response := do("GET", "/api/me", "userapikey", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response).To(HaveLen(2))
+			Expect(response["meta"]).To(Equal(map[string]interface{}{"status": "success"}))
+			Expect(response["data"]).To(HaveKeyWithValue("email", "test@test.com"))
+		})
+
+		It("Should wrap lists in data", func() {
+			code, response := do("GET", "/api/services", "adminapikey", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response["data"]).To(HaveLen(3))
+			Expect(response["meta"]).To(Equal(map[string]interface{}{"status": "success"}))
+		})
+
+		It("Should render the items of pages as data, with the paging in meta", func() {
+			code, response := do("GET", "/api/services?limit=2&offset=1", "adminapikey", "")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response["data"]).To(HaveLen(2))
+			Expect(response["meta"]).To(Equal(map[string]interface{}{
+				"status":  "success",
+				"total":   float64(3),
+				"offset":  float64(1),
+				"limit":   float64(2),
+				"hasMore": false,
+			}))
+		})
+
+		It("Should render errors with their code and message in error", func() {
+			code, response := do("GET", "/api/users", "userapikey", "")
+			Expect(code).To(Equal(http.StatusForbidden))
+			Expect(response).To(Equal(map[string]interface{}{
+				"data": nil,
+				"meta": map[string]interface{}{"status": "error"},
+				"error": map[string]interface{}{
+					"code":    InsufficientPermissionsError.Code,
+					"message": InsufficientPermissionsError.Msg,
+				},
+			}))
+
+			// Details of errors are kept alongside the code
+			code, response = do("PATCH", "/api/me", "userapikey", `{"password": "short"}`)
+			Expect(code).To(Equal(WeakPasswordError.HttpCode))
+			details := response["error"].(map[string]interface{})
+			Expect(details["code"]).To(Equal(WeakPasswordError.Code))
+			Expect(details["failedRules"]).NotTo(BeEmpty())
+
+			// Including authentication failures
+			code, response = do("GET", "/api/services", "nosuchkey", "")
+			Expect(code).To(Equal(FailedToAuthenticateUserError.HttpCode))
+			Expect(response["error"]).To(HaveKeyWithValue("code", FailedToAuthenticateUserError.Code))
+		})
+	})
+
+	It("Should use the envelope set by embedding applications", func() {
+		newServer("")
+		server.APIEnvelope = func(status int, body map[string]interface{}) interface{} {
+			return map[string]interface{}{"result": body["data"], "ok": status < 400, "httpStatus": status}
+		}
+
+		code, response := do("GET", "/api/me", "userapikey", "")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(response["ok"]).To(BeTrue())
+		Expect(response["result"]).To(HaveKeyWithValue("email", "test@test.com"))
+
+		_, response = do("GET", "/api/users", "userapikey", "")
+		Expect(response).To(Equal(map[string]interface{}{"result": nil, "ok": false, "httpStatus": float64(http.StatusForbidden)}))
+	})
+
+	It("Should refuse unknown envelopes at startup", func() {
+		_, err := NewCASServer(castest.NewTestConfig(map[string]string{"apiResponseEnvelope": "jsonapi"}))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("apiResponseEnvelope"))
+	})
+})
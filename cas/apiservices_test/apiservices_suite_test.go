@@ -0,0 +1,12 @@
+package apiservices_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoAPIServices(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo API Services Suite")
+}
@@ -0,0 +1,142 @@
+package apiservices_test
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+)
+
+type servicePage struct {
+	Services []CASService `json:"services"`
+	Total    int          `json:"total"`
+	Offset   int          `json:"offset"`
+	Limit    int          `json:"limit"`
+	HasMore  bool         `json:"hasMore"`
+}
+
+var _ = Describe("GET /api/services", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		client := castest.NewClient(server)
+		client.Header.Set("X-Api-Key", "adminapikey")
+		client.Header.Set("X-Api-Secret", "badsecret")
+		return client.Get("/api/services" + query)
+	}
+
+	getPage := func(query string) servicePage {
+		w := get(query)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var body struct {
+			Data servicePage `json:"data"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		return body.Data
+	}
+
+	names := func(services []CASService) []string {
+		result := []string{}
+		for _, service := range services {
+			result = append(result, service.Name)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+
+		// Only the services added here are paged through
+		fixtures, casErr := db.GetAllServices()
+		Expect(casErr).To(BeNil())
+		for _, service := range fixtures {
+			Expect(db.RemoveServiceByName(service.Name)).To(BeNil())
+		}
+
+		for i := 0; i < 5; i++ {
+			Expect(db.AddNewService(&CASService{
+				Name:       fmt.Sprintf("service_%d", i),
+				Url:        fmt.Sprintf("localhost:%d/validateCASLogin", 3000+i),
+				AdminEmail: "admin@test.com",
+			})).To(BeNil())
+		}
+		Expect(db.AddNewService(&CASService{Name: "Other_App", Url: "localhost:4000", AdminEmail: "admin@test.com"})).To(BeNil())
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should return all services as an array when no paging parameters are given", func() {
+		w := get("")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var body struct {
+			Data []CASService `json:"data"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		Expect(body.Data).To(HaveLen(6))
+	})
+
+	It("Should return a page of services ordered by name", func() {
+		page := getPage("?limit=2&offset=1")
+		Expect(names(page.Services)).To(Equal([]string{"service_0", "service_1"}))
+		Expect(page.Total).To(Equal(6))
+		Expect(page.Offset).To(Equal(1))
+		Expect(page.Limit).To(Equal(2))
+		Expect(page.HasMore).To(BeTrue())
+	})
+
+	It("Should return the remaining services on the last page", func() {
+		page := getPage("?limit=4&offset=4")
+		Expect(names(page.Services)).To(Equal([]string{"service_3", "service_4"}))
+		Expect(page.HasMore).To(BeFalse())
+	})
+
+	It("Should return all remaining services without a limit", func() {
+		page := getPage("?offset=3")
+		Expect(page.Services).To(HaveLen(3))
+		Expect(page.Total).To(Equal(6))
+	})
+
+	It("Should return no services for offsets at or past the end", func() {
+		for _, offset := range []int{6, 7, 100} {
+			page := getPage(fmt.Sprintf("?offset=%d&limit=10", offset))
+			Expect(page.Services).To(BeEmpty())
+			Expect(page.Total).To(Equal(6))
+			Expect(page.HasMore).To(BeFalse())
+		}
+	})
+
+	It("Should filter by case-insensitive name substring", func() {
+		page := getPage("?name=APP")
+		Expect(names(page.Services)).To(Equal([]string{"Other_App"}))
+		Expect(page.Total).To(Equal(1))
+
+		page = getPage("?name=service_&limit=2")
+		Expect(names(page.Services)).To(Equal([]string{"service_0", "service_1"}))
+		Expect(page.Total).To(Equal(5))
+
+		page = getPage("?name=missing")
+		Expect(page.Services).To(BeEmpty())
+		Expect(page.Total).To(Equal(0))
+	})
+
+	It("Should treat filter characters literally", func() {
+		page := getPage("?name=.*")
+		Expect(page.Services).To(BeEmpty())
+	})
+
+	It("Should reject invalid paging parameters", func() {
+		for _, query := range []string{"?limit=-1", "?offset=-5", "?limit=ten", "?offset="} {
+			w := get(query)
+			Expect(w.Code).To(Equal(InvalidPaginationParametersError.HttpCode))
+		}
+	})
+})
@@ -0,0 +1,240 @@
+package apiservices_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+)
+
+// Backend that fails to add or update a particular service
+type failingServiceBackend struct {
+	*MemoryBackend
+	failName string
+}
+
+func (db *failingServiceBackend) AddNewService(service *CASService) *CASServerError {
+	if service.Name == db.failName {
+		return &FailedToCreateServiceError
+	}
+	return db.MemoryBackend.AddNewService(service)
+}
+
+func (db *failingServiceBackend) UpdateService(service *CASService) *CASServerError {
+	if service.Name == db.failName {
+		return &FailedToUpdateServiceError
+	}
+	return db.MemoryBackend.UpdateService(service)
+}
+
+type importResponse struct {
+	Status  string       `json:"status"`
+	Message string       `json:"message"`
+	Data    ImportReport `json:"data"`
+}
+
+var _ = Describe("POST /api/services/import", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	existing := CASService{Name: "existing", Url: "localhost:3000/validateCASLogin", AdminEmail: "admin@test.com"}
+
+	post := func(query, apiKey string, services interface{}) (*httptest.ResponseRecorder, importResponse) {
+		body, _ := json.Marshal(services)
+		req, _ := http.NewRequest("POST", "/api/services/import"+query, bytes.NewReader(body))
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set("X-Api-Secret", "badsecret")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+
+		var resp importResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	statuses := func(report ImportReport) []string {
+		result := []string{}
+		for _, r := range report.Results {
+			result = append(result, r.Status)
+		}
+		return result
+	}
+
+	serviceNames := func() []string {
+		services, casErr := db.GetAllServices()
+		Expect(casErr).To(BeNil())
+		names := []string{}
+		for _, service := range services {
+			names = append(names, service.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	service := func(name, url string) CASService {
+		return CASService{Name: name, Url: url, AdminEmail: "admin@test.com"}
+	}
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+		Expect(db.AddNewService(&existing)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		db.Close()
+	})
+
+	It("Should only be available to admins", func() {
+		w, _ := post("", "userapikey", []CASService{service("new", "localhost:3001")})
+		Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+		Expect(serviceNames()).To(Equal([]string{"existing"}))
+	})
+
+	It("Should create all services when every service is valid", func() {
+		w, resp := post("", "adminapikey", []CASService{service("one", "localhost:3001"), service("two", "https://two.example.com/cas")})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(resp.Status).To(Equal("success"))
+		Expect(statuses(resp.Data)).To(Equal([]string{IMPORT_CREATED, IMPORT_CREATED}))
+		Expect(resp.Data.Created).To(Equal(2))
+		Expect(serviceNames()).To(Equal([]string{"existing", "one", "two"}))
+	})
+
+	It("Should reject bodies that are not an array of services", func() {
+		for _, body := range []interface{}{map[string]string{"name": "one"}, nil, "services"} {
+			w, _ := post("", "adminapikey", body)
+			Expect(w.Code).To(Equal(FailedToParseJSONError.HttpCode))
+		}
+	})
+
+	It("Should reject invalid import parameters", func() {
+		w, _ := post("?partial=maybe", "adminapikey", []CASService{})
+		Expect(w.Code).To(Equal(InvalidServiceImportParametersError.HttpCode))
+	})
+
+	Describe("All or nothing imports", func() {
+		It("Should import nothing if any service is invalid", func() {
+			w, resp := post("", "adminapikey", []CASService{
+				service("one", "localhost:3001"),
+				{Name: "incomplete", Url: "localhost:3002"},
+				service(" padded", "localhost:3003"),
+				service("bad_url", "http://[::1"),
+			})
+			Expect(w.Code).To(Equal(InvalidServiceImportError.HttpCode))
+			Expect(resp.Message).To(Equal(InvalidServiceImportError.Msg))
+			Expect(statuses(resp.Data)).To(Equal([]string{
+				IMPORT_SKIPPED,
+				IMPORT_FAILED,
+				IMPORT_FAILED,
+				IMPORT_FAILED,
+			}))
+			Expect(resp.Data.Results[1].Message).To(Equal(InvalidServiceError.Msg))
+			Expect(resp.Data.Failed).To(Equal(3))
+			Expect(serviceNames()).To(Equal([]string{"existing"}))
+		})
+
+		It("Should revert services already written if the backend fails", func() {
+			server.Db = &failingServiceBackend{MemoryBackend: db, failName: "two"}
+			updated := existing
+			updated.AdminEmail = "other@test.com"
+
+			w, resp := post("?overwrite=true", "adminapikey", []CASService{
+				service("one", "localhost:3001"),
+				updated,
+				service("two", "localhost:3002"),
+				service("three", "localhost:3003"),
+			})
+			Expect(w.Code).To(Equal(FailedToImportServicesError.HttpCode))
+			Expect(statuses(resp.Data)).To(Equal([]string{
+				IMPORT_SKIPPED,
+				IMPORT_SKIPPED,
+				IMPORT_FAILED,
+				IMPORT_SKIPPED,
+			}))
+			Expect(serviceNames()).To(Equal([]string{"existing"}))
+
+			restored, casErr := db.FindServiceByUrl(existing.Url)
+			Expect(casErr).To(BeNil())
+			Expect(*restored).To(Equal(existing))
+		})
+	})
+
+	Describe("Partial imports", func() {
+		It("Should import valid services and report the ones that failed", func() {
+			w, resp := post("?partial=true", "adminapikey", []CASService{
+				service("one", "localhost:3001"),
+				{Name: "incomplete"},
+				service("two", "localhost:3002"),
+			})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(statuses(resp.Data)).To(Equal([]string{IMPORT_CREATED, IMPORT_FAILED, IMPORT_CREATED}))
+			Expect(resp.Data.Created).To(Equal(2))
+			Expect(resp.Data.Failed).To(Equal(1))
+			Expect(serviceNames()).To(Equal([]string{"existing", "one", "two"}))
+		})
+
+		It("Should keep going when the backend fails for a service", func() {
+			server.Db = &failingServiceBackend{MemoryBackend: db, failName: "one"}
+			w, resp := post("?partial=true", "adminapikey", []CASService{service("one", "localhost:3001"), service("two", "localhost:3002")})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(statuses(resp.Data)).To(Equal([]string{IMPORT_FAILED, IMPORT_CREATED}))
+			Expect(resp.Data.Results[0].Message).To(Equal(FailedToCreateServiceError.Msg))
+			Expect(serviceNames()).To(Equal([]string{"existing", "two"}))
+		})
+	})
+
+	Describe("Duplicates", func() {
+		It("Should reject services that already exist unless overwrite is given", func() {
+			updated := existing
+			updated.LogoutUrl = "https://localhost:3000/logout"
+
+			w, resp := post("", "adminapikey", []CASService{updated})
+			Expect(w.Code).To(Equal(InvalidServiceImportError.HttpCode))
+			Expect(resp.Data.Results[0].Message).To(Equal(ServiceNameAlreadyTakenError.Msg))
+
+			w, resp = post("?overwrite=true", "adminapikey", []CASService{updated, existing})
+			Expect(w.Code).To(Equal(InvalidServiceImportError.HttpCode))
+			Expect(resp.Data.Results[1].Message).To(Equal(DuplicateImportedServiceError.Msg))
+
+			w, resp = post("?overwrite=true", "adminapikey", []CASService{updated})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(statuses(resp.Data)).To(Equal([]string{IMPORT_UPDATED}))
+			found, _ := db.FindServiceByUrl(existing.Url)
+			Expect(found.LogoutUrl).To(Equal(updated.LogoutUrl))
+
+			w, resp = post("?overwrite=true", "adminapikey", []CASService{updated})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(statuses(resp.Data)).To(Equal([]string{IMPORT_UNCHANGED}))
+		})
+
+		It("Should reject services sharing a name or URL within the import", func() {
+			w, resp := post("?partial=true", "adminapikey", []CASService{
+				service("one", "localhost:3001"),
+				service("one", "localhost:3002"),
+				service("two", "localhost:3001"),
+			})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(statuses(resp.Data)).To(Equal([]string{IMPORT_CREATED, IMPORT_FAILED, IMPORT_FAILED}))
+			Expect(resp.Data.Results[2].Message).To(Equal(DuplicateImportedServiceError.Msg))
+		})
+
+		It("Should reject services using another service's URL, even with overwrite", func() {
+			w, resp := post("?overwrite=true", "adminapikey", []CASService{service("renamed", existing.Url)})
+			Expect(w.Code).To(Equal(InvalidServiceImportError.HttpCode))
+			Expect(resp.Data.Results[0].Message).To(Equal(ServiceUrlAlreadyTakenError.Msg))
+		})
+	})
+})
@@ -0,0 +1,109 @@
+package cas
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+/*
+ * API access tokens (JSON Web Tokens, signed with HS256)
+ */
+
+// Errors returned when an API token cannot be verified
+var (
+	ErrInvalidAPIToken = errors.New("Invalid API token")
+	ErrExpiredAPIToken = errors.New("API token has expired")
+)
+
+// Roles included in API tokens
+const (
+	API_TOKEN_ROLE_ADMIN = "admin"
+	API_TOKEN_ROLE_USER  = "user"
+)
+
+type apiTokenHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+}
+
+// Claims carried by an API token
+type APITokenClaims struct {
+	Subject   string `json:"sub"`  // Email of the user the token was issued to
+	Role      string `json:"role"` // API_TOKEN_ROLE_ADMIN or API_TOKEN_ROLE_USER
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Create a signed API token for a user, expiring after the given lifetime
+func NewAPIToken(user *User, secret []byte, issuedAt time.Time, ttl time.Duration) (string, error) {
+	if len(secret) == 0 {
+		return "", errors.New("API token secret must not be empty")
+	}
+
+	role := API_TOKEN_ROLE_USER
+	if user.IsAdmin {
+		role = API_TOKEN_ROLE_ADMIN
+	}
+
+	header, err := json.Marshal(apiTokenHeader{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(APITokenClaims{
+		Subject:   user.Email,
+		Role:      role,
+		IssuedAt:  issuedAt.Unix(),
+		ExpiresAt: issuedAt.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signAPIToken(signingInput, secret)), nil
+}
+
+// Verify an API token's signature and expiry, returning its claims
+func ParseAPIToken(token string, secret []byte, now time.Time) (*APITokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || len(secret) == 0 {
+		return nil, ErrInvalidAPIToken
+	}
+
+	// Only HS256 is accepted, regardless of what the token claims to use
+	var header apiTokenHeader
+	if !decodeAPITokenPart(parts[0], &header) || header.Alg != "HS256" {
+		return nil, ErrInvalidAPIToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, signAPIToken(parts[0]+"."+parts[1], secret)) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	var claims APITokenClaims
+	if !decodeAPITokenPart(parts[1], &claims) || len(claims.Subject) == 0 {
+		return nil, ErrInvalidAPIToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredAPIToken
+	}
+
+	return &claims, nil
+}
+
+func signAPIToken(signingInput string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func decodeAPITokenPart(part string, v interface{}) bool {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	return err == nil && json.Unmarshal(data, v) == nil
+}
@@ -0,0 +1,12 @@
+package apitoken_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoAPIToken(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo API Token Suite")
+}
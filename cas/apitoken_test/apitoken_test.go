@@ -0,0 +1,212 @@
+package apitoken_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+var _ = Describe("API tokens", func() {
+	var (
+		secret []byte
+		now    time.Time
+		user   *User
+	)
+
+	BeforeEach(func() {
+		secret = []byte("supersecret")
+		now = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+		user = &User{Email: "admin@test.com", IsAdmin: true}
+	})
+
+	It("Should round trip the user's identity and role", func() {
+		token, err := NewAPIToken(user, secret, now, time.Minute)
+		Expect(err).To(BeNil())
+		Expect(strings.Count(token, ".")).To(Equal(2))
+
+		claims, err := ParseAPIToken(token, secret, now.Add(30*time.Second))
+		Expect(err).To(BeNil())
+		Expect(claims.Subject).To(Equal("admin@test.com"))
+		Expect(claims.Role).To(Equal(API_TOKEN_ROLE_ADMIN))
+		Expect(claims.IssuedAt).To(Equal(now.Unix()))
+		Expect(claims.ExpiresAt).To(Equal(now.Add(time.Minute).Unix()))
+	})
+
+	It("Should give regular users the user role", func() {
+		user.IsAdmin = false
+		token, _ := NewAPIToken(user, secret, now, time.Minute)
+		claims, err := ParseAPIToken(token, secret, now)
+		Expect(err).To(BeNil())
+		Expect(claims.Role).To(Equal(API_TOKEN_ROLE_USER))
+	})
+
+	It("Should reject expired tokens", func() {
+		token, _ := NewAPIToken(user, secret, now, time.Minute)
+		_, err := ParseAPIToken(token, secret, now.Add(time.Minute))
+		Expect(err).To(Equal(ErrExpiredAPIToken))
+	})
+
+	It("Should reject tokens signed with a different secret", func() {
+		token, _ := NewAPIToken(user, []byte("othersecret"), now, time.Minute)
+		_, err := ParseAPIToken(token, secret, now)
+		Expect(err).To(Equal(ErrInvalidAPIToken))
+	})
+
+	It("Should reject tokens with tampered claims", func() {
+		user.IsAdmin = false
+		token, _ := NewAPIToken(user, secret, now, time.Minute)
+		parts := strings.Split(token, ".")
+
+		claims, _ := json.Marshal(APITokenClaims{
+			Subject:   "test@test.com",
+			Role:      API_TOKEN_ROLE_ADMIN,
+			ExpiresAt: now.Add(time.Hour).Unix(),
+		})
+		parts[1] = base64.RawURLEncoding.EncodeToString(claims)
+
+		_, err := ParseAPIToken(strings.Join(parts, "."), secret, now)
+		Expect(err).To(Equal(ErrInvalidAPIToken))
+	})
+
+	It("Should reject tampered signatures", func() {
+		token, _ := NewAPIToken(user, secret, now, time.Minute)
+		parts := strings.Split(token, ".")
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		signature[0] ^= 0xff
+		parts[2] = base64.RawURLEncoding.EncodeToString(signature)
+
+		_, err := ParseAPIToken(strings.Join(parts, "."), secret, now)
+		Expect(err).To(Equal(ErrInvalidAPIToken))
+	})
+
+	It("Should reject unsigned tokens", func() {
+		token, _ := NewAPIToken(user, secret, now, time.Minute)
+		parts := strings.Split(token, ".")
+		parts[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`))
+
+		_, err := ParseAPIToken(parts[0]+"."+parts[1]+".", secret, now)
+		Expect(err).To(Equal(ErrInvalidAPIToken))
+	})
+
+	It("Should reject malformed tokens", func() {
+		for _, token := range []string{"", "abc", "a.b", "a.b.c", "..."} {
+			_, err := ParseAPIToken(token, secret, now)
+			Expect(err).To(Equal(ErrInvalidAPIToken))
+		}
+	})
+
+	It("Should not create tokens without a secret", func() {
+		_, err := NewAPIToken(user, nil, now, time.Minute)
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("API token authentication", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	do := func(method, path string, headers map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		req, _ := http.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+
+		var body map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body
+	}
+
+	requestToken := func(key, secret string) string {
+		w, body := do("POST", "/api/token", map[string]string{"X-Api-Key": key, "X-Api-Secret": secret})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		data := body["data"].(map[string]interface{})
+		Expect(data["tokenType"]).To(Equal("Bearer"))
+		Expect(data["expiresIn"]).To(BeNumerically("==", 900))
+		return data["token"].(string)
+	}
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+		config["apiTokenSecret"] = "supersecret"
+
+		server, err = NewCASServer(config)
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		db.Close()
+	})
+
+	It("Should issue tokens for valid API keys", func() {
+		token := requestToken("userapikey", "badsecret")
+		Expect(token).NotTo(BeEmpty())
+	})
+
+	It("Should refuse to issue tokens for invalid API keys", func() {
+		w, body := do("POST", "/api/token", map[string]string{"X-Api-Key": "userapikey", "X-Api-Secret": "wrong"})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(body["status"]).To(Equal("error"))
+	})
+
+	It("Should refuse to issue tokens when no secret is configured", func() {
+		server.Config["apiTokenSecret"] = ""
+		w, _ := do("POST", "/api/token", map[string]string{"X-Api-Key": "userapikey", "X-Api-Secret": "badsecret"})
+		Expect(w.Code).To(Equal(UnsupportedFeatureError.HttpCode))
+	})
+
+	It("Should authenticate API requests with bearer tokens", func() {
+		token := requestToken("userapikey", "badsecret")
+
+		w, body := do("GET", "/api/sessions", map[string]string{"Authorization": "Bearer " + token})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		data := body["data"].(map[string]interface{})
+		Expect(data["email"]).To(Equal("test@test.com"))
+		Expect(data["password"]).To(BeEmpty())
+	})
+
+	It("Should grant admin access to admin tokens only", func() {
+		userToken := requestToken("userapikey", "badsecret")
+		w, _ := do("GET", "/api/users", map[string]string{"Authorization": "Bearer " + userToken})
+		Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+
+		adminToken := requestToken("adminapikey", "badsecret")
+		w, _ = do("GET", "/api/users", map[string]string{"Authorization": "Bearer " + adminToken})
+		Expect(w.Code).To(Equal(http.StatusOK))
+	})
+
+	It("Should reject expired tokens", func() {
+		token, err := NewAPIToken(&User{Email: "test@test.com"}, []byte("supersecret"), time.Now().Add(-time.Hour), time.Minute)
+		Expect(err).To(BeNil())
+
+		w, body := do("GET", "/api/sessions", map[string]string{"Authorization": "Bearer " + token})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(body["message"]).To(Equal(ExpiredAPITokenError.Msg))
+	})
+
+	It("Should reject tampered tokens, even if an API key is also given", func() {
+		token := requestToken("userapikey", "badsecret")
+		w, body := do("GET", "/api/sessions", map[string]string{
+			"Authorization": "Bearer " + token + "x",
+			"X-Api-Key":     "userapikey",
+			"X-Api-Secret":  "badsecret",
+		})
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(body["message"]).To(Equal(InvalidAPITokenError.Msg))
+	})
+})
@@ -0,0 +1,12 @@
+package apiusers_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoAPIUsers(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo API Users Suite")
+}
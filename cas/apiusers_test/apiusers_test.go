@@ -0,0 +1,159 @@
+package apiusers_test
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+)
+
+type userPage struct {
+	Users   []User `json:"users"`
+	Total   int    `json:"total"`
+	Offset  int    `json:"offset"`
+	Limit   int    `json:"limit"`
+	HasMore bool   `json:"hasMore"`
+}
+
+var _ = Describe("GET /api/users", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	getAs := func(apiKey, query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/api/users"+query, nil)
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set("X-Api-Secret", "badsecret")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		return getAs("adminapikey", query)
+	}
+
+	getPage := func(query string) userPage {
+		w := get(query)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).NotTo(ContainSubstring("$2a$"))
+		var body struct {
+			Data userPage `json:"data"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		return body.Data
+	}
+
+	emails := func(users []User) []string {
+		result := []string{}
+		for _, user := range users {
+			result = append(result, user.Email)
+		}
+		return result
+	}
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+
+		server, err = NewCASServer(config)
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+
+		// admin@test.com & test@test.com, along with 4 more regular users and an admin
+		for i := 0; i < 4; i++ {
+			_, casErr := db.AddNewUser(fmt.Sprintf("user_%d@example.com", i), "$2a$10$notarealhash")
+			Expect(casErr).To(BeNil())
+		}
+		admin, casErr := db.AddNewUser("Root@Example.com", "$2a$10$notarealhash")
+		Expect(casErr).To(BeNil())
+		admin.IsAdmin = true
+		Expect(db.UpdateUser(admin)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.AuditLog.Stop()
+		db.Close()
+	})
+
+	It("Should return all users as an array (without passwords) when no query parameters are given", func() {
+		w := get("")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).NotTo(ContainSubstring("$2a$"))
+		var body struct {
+			Data []User `json:"data"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		Expect(body.Data).To(HaveLen(7))
+	})
+
+	It("Should return a page of users ordered by email", func() {
+		page := getPage("?limit=2&offset=1")
+		Expect(emails(page.Users)).To(Equal([]string{"admin@test.com", "test@test.com"}))
+		Expect(page.Total).To(Equal(7))
+		Expect(page.Offset).To(Equal(1))
+		Expect(page.Limit).To(Equal(2))
+		Expect(page.HasMore).To(BeTrue())
+
+		page = getPage("?offset=5&limit=5")
+		Expect(emails(page.Users)).To(Equal([]string{"user_2@example.com", "user_3@example.com"}))
+		Expect(page.HasMore).To(BeFalse())
+
+		page = getPage("?offset=100")
+		Expect(page.Users).To(BeEmpty())
+		Expect(page.Total).To(Equal(7))
+	})
+
+	It("Should filter by role", func() {
+		page := getPage("?role=admin")
+		Expect(emails(page.Users)).To(Equal([]string{"Root@Example.com", "admin@test.com"}))
+		Expect(page.Total).To(Equal(2))
+
+		page = getPage("?role=Regular&limit=2")
+		Expect(emails(page.Users)).To(Equal([]string{"test@test.com", "user_0@example.com"}))
+		Expect(page.Total).To(Equal(5))
+		Expect(page.HasMore).To(BeTrue())
+	})
+
+	It("Should filter by case-insensitive email substring, alongside the role", func() {
+		page := getPage("?email=EXAMPLE.com")
+		Expect(page.Total).To(Equal(5))
+
+		page = getPage("?email=example&role=admin")
+		Expect(emails(page.Users)).To(Equal([]string{"Root@Example.com"}))
+
+		page = getPage("?email=test.com&role=regular")
+		Expect(emails(page.Users)).To(Equal([]string{"test@test.com"}))
+
+		page = getPage("?email=.*")
+		Expect(page.Users).To(BeEmpty())
+	})
+
+	It("Should reject invalid roles and paging parameters", func() {
+		for _, query := range []string{"?role=superuser", "?role=admins&limit=1"} {
+			w := get(query)
+			Expect(w.Code).To(Equal(InvalidUserRoleError.HttpCode))
+			Expect(w.Body.String()).To(ContainSubstring(InvalidUserRoleError.Code))
+		}
+		for _, query := range []string{"?limit=-1", "?offset=ten&role=admin"} {
+			w := get(query)
+			Expect(w.Code).To(Equal(InvalidPaginationParametersError.HttpCode))
+		}
+	})
+
+	It("Should refuse to list users for regular users", func() {
+		for _, query := range []string{"", "?role=admin", "?limit=1"} {
+			w := getAs("userapikey", query)
+			Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+			Expect(w.Body.String()).NotTo(ContainSubstring("admin@test.com"))
+		}
+	})
+})
@@ -0,0 +1,345 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Audit trail of security-relevant events, kept by the storage backend (apart from application logs)
+ */
+
+// Types of audited events
+const (
+	AUDIT_LOGIN            = "login"
+	AUDIT_LOGOUT           = "logout"
+	AUDIT_TICKET_ISSUED    = "ticket_issued"
+	AUDIT_TICKET_VALIDATED = "ticket_validated"
+	AUDIT_SERVICE_CREATED  = "service_created"
+	AUDIT_SERVICE_UPDATED  = "service_updated"
+	AUDIT_SERVICE_DELETED  = "service_deleted"
+	AUDIT_API_KEY_USED     = "api_key_authentication"
+	AUDIT_API_TOKEN_ISSUED = "api_token_issued"
+)
+
+var AUDIT_EVENT_TYPES = []string{
+	AUDIT_LOGIN,
+	AUDIT_LOGOUT,
+	AUDIT_TICKET_ISSUED,
+	AUDIT_TICKET_VALIDATED,
+	AUDIT_SERVICE_CREATED,
+	AUDIT_SERVICE_UPDATED,
+	AUDIT_SERVICE_DELETED,
+	AUDIT_API_KEY_USED,
+	AUDIT_API_TOKEN_ISSUED,
+}
+
+// Outcomes of audited events
+const (
+	AUDIT_SUCCESS = "success"
+	AUDIT_FAILURE = "failure"
+)
+
+// Number of audit events returned by queries that don't specify a limit (and the most that may be asked for)
+const (
+	AUDIT_DEFAULT_QUERY_LIMIT = 100
+	AUDIT_MAX_QUERY_LIMIT     = 1000
+)
+
+// Security-relevant event, which is never changed once recorded
+type AuditEvent struct {
+	Id        string            `gorethink:"id,omitempty" json:"id"`
+	Time      time.Time         `gorethink:"time" json:"time"`
+	Type      string            `gorethink:"type" json:"type"`
+	Actor     string            `gorethink:"actor" json:"actor"`                       // Email of the user acting (or the API key used), if known
+	SourceIP  string            `gorethink:"sourceIp" json:"sourceIp"`                 // IP of the client the request came from
+	Outcome   string            `gorethink:"outcome" json:"outcome"`                   // AUDIT_SUCCESS or AUDIT_FAILURE
+	Target    string            `gorethink:"target,omitempty" json:"target,omitempty"` // Service acted on (its URL or name)
+	RequestId string            `gorethink:"requestId,omitempty" json:"requestId,omitempty"`
+	Details   map[string]string `gorethink:"details,omitempty" json:"details,omitempty"` // Ex. the code of the error that caused a failure
+}
+
+// Filters for finding audit events (zero values match every event)
+type AuditEventQuery struct {
+	Since time.Time // Events at or after this time
+	Until time.Time // Events before this time
+	Actor string
+	Type  string
+	Limit int // Most events to return (0 for all)
+}
+
+// Whether an event matches the query's filters (the limit is left to the caller)
+func (q AuditEventQuery) Matches(event AuditEvent) bool {
+	return (q.Since.IsZero() || !event.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || event.Time.Before(q.Until)) &&
+		(len(q.Actor) == 0 || event.Actor == q.Actor) &&
+		(len(q.Type) == 0 || event.Type == q.Type)
+}
+
+// Create an audit event with the outcome of an operation that failed with casErr (if not nil)
+func NewAuditEvent(eventType, actor, target string, casErr *CASServerError) AuditEvent {
+	event := AuditEvent{
+		Type:    eventType,
+		Actor:   actor,
+		Target:  target,
+		Outcome: AUDIT_SUCCESS,
+	}
+	if casErr != nil {
+		event.Outcome = AUDIT_FAILURE
+		event.Details = map[string]string{"errorCode": casErr.Code}
+	}
+	return event
+}
+
+// Add details (alternating keys and values) to an audit event
+func withAuditDetails(event AuditEvent, keyValues ...string) AuditEvent {
+	if event.Details == nil {
+		event.Details = map[string]string{}
+	}
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		event.Details[keyValues[i]] = keyValues[i+1]
+	}
+	return event
+}
+
+// Writes audit events to the storage backend in the background, so requests are never held up by it
+// Events are queued (up to a bound) and written in batches, events recorded while the queue is full are dropped
+type AuditLog struct {
+	db      AuditableBackend // nil if events are not kept
+	queue   chan AuditEvent
+	flushes chan chan struct{}
+	metrics *CASMetrics
+	logger  Logger
+	clock   Clock
+	health  *WorkerHealth
+
+	mu       sync.RWMutex // Held (for writing) while stopping, so no event is queued once the writer is gone
+	closed   bool
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// Create an audit log queueing up to queueSize events, writing them to a backend until stopped
+// Backends that do not implement AuditableBackend keep no audit trail (events are discarded)
+func NewAuditLog(db Backend, queueSize int, metrics *CASMetrics, logger Logger) *AuditLog {
+	if metrics == nil {
+		metrics = NewCASMetrics()
+	}
+	auditLog := &AuditLog{
+		queue:   make(chan AuditEvent, queueSize),
+		flushes: make(chan chan struct{}),
+		metrics: metrics,
+		logger:  loggerOrDefault(logger),
+		clock:   RealClock,
+		stop:    make(chan struct{}),
+	}
+	auditLog.health = newWorkerHealth(WORKER_AUDIT_LOG, 0, false, func() int { return len(auditLog.queue) }, queueSize)
+
+	if auditable, ok := db.(AuditableBackend); ok {
+		auditLog.db = auditable
+		auditLog.stopped.Add(1)
+		go auditLog.writeLoop()
+	}
+	return auditLog
+}
+
+// Create the audit log specified by server configuration (auditEnabled & auditQueueSize)
+func NewAuditLogFromConfig(config map[string]string, db Backend, metrics *CASMetrics, logger Logger) (*AuditLog, error) {
+	enabled, err := configBool(config, "auditEnabled")
+	if err != nil {
+		return nil, err
+	}
+	queueSize, err := configInt(config, "auditQueueSize")
+	if err != nil {
+		return nil, err
+	}
+	if queueSize < 1 {
+		return nil, fmt.Errorf("Invalid auditQueueSize [%d], must be at least 1", queueSize)
+	}
+
+	if !enabled {
+		db = nil
+	}
+	return NewAuditLog(db, queueSize, metrics, logger), nil
+}
+
+// Replace the clock events are timestamped with
+func (a *AuditLog) SetClock(clock Clock) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.clock = clock
+}
+
+// Whether recorded events are kept
+func (a *AuditLog) Enabled() bool {
+	return a != nil && a.db != nil
+}
+
+// State the writer publishes as it runs (nil if events are not kept, so nothing is started)
+func (a *AuditLog) Health() *WorkerHealth {
+	if !a.Enabled() {
+		return nil
+	}
+	return a.health
+}
+
+// Queue an event to be written (events without a time are timestamped now)
+func (a *AuditLog) Record(event AuditEvent) {
+	if !a.Enabled() {
+		return
+	}
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if event.Time.IsZero() {
+		event.Time = a.clock.Now()
+	}
+	if a.closed {
+		a.drop("stopped", event)
+		return
+	}
+
+	select {
+	case a.queue <- event:
+		a.health.Queued(1)
+	default:
+		a.drop("queue_full", event)
+	}
+}
+
+func (a *AuditLog) drop(reason string, event AuditEvent) {
+	a.metrics.AuditEventsDropped.Inc(reason)
+	a.logger.Error("Dropped audit event", "reason", reason, "type", event.Type, "actor", event.Actor, "outcome", event.Outcome)
+}
+
+// Wait for the events recorded so far to be written
+func (a *AuditLog) Flush() {
+	if !a.Enabled() {
+		return
+	}
+
+	done := make(chan struct{})
+	select {
+	case a.flushes <- done:
+		<-done
+	case <-a.stop:
+	}
+}
+
+// Write the events still queued, then stop writing (events recorded afterwards are dropped)
+func (a *AuditLog) Stop() {
+	if !a.Enabled() {
+		return
+	}
+
+	a.mu.Lock()
+	a.closed = true
+	a.mu.Unlock()
+
+	a.stopOnce.Do(func() { close(a.stop) })
+	a.stopped.Wait()
+}
+
+func (a *AuditLog) writeLoop() {
+	defer a.stopped.Done()
+
+	for {
+		select {
+		case event := <-a.queue:
+			a.write(append([]AuditEvent{event}, a.drain()...))
+		case done := <-a.flushes:
+			a.write(a.drain())
+			close(done)
+		case <-a.stop:
+			a.write(a.drain())
+			return
+		}
+	}
+}
+
+// Take every event currently queued
+func (a *AuditLog) drain() []AuditEvent {
+	events := []AuditEvent{}
+	for {
+		select {
+		case event := <-a.queue:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func (a *AuditLog) write(events []AuditEvent) {
+	if len(events) == 0 {
+		return
+	}
+	if casErr := a.db.AddAuditEvents(events); casErr != nil {
+		a.health.Ran(casErr)
+		a.metrics.AuditEventsDropped.Add(float64(len(events)), "write_failed")
+		a.logger.Error("Failed to write audit events", "count", len(events), "error", casErr)
+		return
+	}
+	a.health.Ran(nil)
+}
+
+// Record an audit event for a request, from the client's IP and with the request's ID, and notify webhooks of it
+func (c *CAS) audit(req *http.Request, event AuditEvent) {
+	event.SourceIP = c.clientIP(req)
+	if id, ok := RequestIDFromContext(req.Context()); ok {
+		event.RequestId = id
+	}
+	if event.Time.IsZero() {
+		event.Time = c.now()
+	}
+	c.AuditLog.Record(event)
+	c.Webhooks.Notify(event)
+}
+
+// Record the outcome of a service ticket validation (the ticket is nil if it could not be found)
+func (c *CAS) auditValidation(req *http.Request, endpoint string, ticket *CASTicket, serviceUrl string, casErr *CASServerError) {
+	event := NewAuditEvent(AUDIT_TICKET_VALIDATED, "", serviceUrl, casErr)
+	if ticket != nil {
+		event.Actor = ticket.UserEmail
+	}
+	c.audit(req, withAuditDetails(event, "endpoint", endpoint, "ticketType", "service"))
+}
+
+// Build an audit event query from the since & until (RFC 3339 times), actor, type and limit query parameters
+func auditQueryFromRequest(req *http.Request) (AuditEventQuery, *CASServerError) {
+	values := req.URL.Query()
+	query := AuditEventQuery{
+		Actor: strings.TrimSpace(values.Get("actor")),
+		Type:  strings.TrimSpace(values.Get("type")),
+		Limit: AUDIT_DEFAULT_QUERY_LIMIT,
+	}
+
+	for param, dest := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		value := strings.TrimSpace(values.Get(param))
+		if len(value) == 0 {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			casErr := &InvalidAuditQueryParametersError
+			casErr.err = &err
+			return query, casErr
+		}
+		*dest = parsed
+	}
+
+	if value := strings.TrimSpace(values.Get("limit")); len(value) > 0 {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > AUDIT_MAX_QUERY_LIMIT {
+			return query, &InvalidAuditQueryParametersError
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
@@ -0,0 +1,12 @@
+package audit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoAudit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Audit Suite")
+}
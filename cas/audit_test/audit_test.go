@@ -0,0 +1,325 @@
+package audit_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+var _ = Describe("Audit log", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		now    time.Time
+		client *castest.Client
+	)
+
+	// Requests come from 192.0.2.1 (see httptest.NewRequest), with the given request ID
+	do := func(method, path string, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == "POST" && !strings.HasPrefix(path, "/api/") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return client.Do(req)
+	}
+
+	admin := map[string]string{"X-Api-Key": "adminapikey", "X-Api-Secret": "badsecret"}
+
+	login := func(password string) *httptest.ResponseRecorder {
+		client.Header.Set("X-Request-ID", "login-request")
+		defer client.Header.Del("X-Request-ID")
+		return client.Login(url.Values{"password": {password}, "serviceUrl": {testServiceUrl}})
+	}
+
+	// Events recorded so far, most recent first
+	events := func(query AuditEventQuery) []AuditEvent {
+		server.AuditLog.Flush()
+		found, casErr := db.FindAuditEvents(query)
+		Expect(casErr).To(BeNil())
+		return found
+	}
+
+	eventsOfType := func(eventType string) []AuditEvent {
+		return events(AuditEventQuery{Type: eventType})
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+		server, db = castest.NewTestServer(nil)
+		server.Clock = ClockFunc(func() time.Time { return now })
+		client = castest.NewClient(server)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should record failed and successful logins, with the client's IP and the request's ID", func() {
+		Expect(login("wrong").Code).To(Equal(http.StatusUnauthorized))
+		now = now.Add(time.Minute)
+		Expect(login("secret").Code).To(Equal(http.StatusFound))
+
+		logins := eventsOfType(AUDIT_LOGIN)
+		Expect(logins).To(HaveLen(2))
+
+		Expect(logins[0].Outcome).To(Equal(AUDIT_SUCCESS))
+		Expect(logins[0].Time).To(Equal(now))
+		Expect(logins[0].Actor).To(Equal("test@test.com"))
+		Expect(logins[0].SourceIP).To(Equal("192.0.2.1"))
+		Expect(logins[0].Target).To(Equal(testServiceUrl))
+		Expect(logins[0].RequestId).To(Equal("login-request"))
+		Expect(logins[0].Id).NotTo(BeEmpty())
+
+		Expect(logins[1].Outcome).To(Equal(AUDIT_FAILURE))
+		Expect(logins[1].Time).To(Equal(now.Add(-time.Minute)))
+		Expect(logins[1].Actor).To(Equal("test@test.com"))
+		Expect(logins[1].Details).To(HaveKeyWithValue("errorCode", InvalidCredentialsError.Code))
+	})
+
+	It("Should record issued and validated tickets, and logouts", func() {
+		w := login("secret")
+		location, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).To(BeNil())
+		ticket := location.Query().Get("ticket")
+
+		do("GET", "/validate?"+url.Values{"service": {testServiceUrl}, "ticket": {ticket}}.Encode(), "", nil)
+		do("GET", "/serviceValidate?"+url.Values{"service": {testServiceUrl}, "ticket": {"ST-unknown"}}.Encode(), "", nil)
+		Expect(do("GET", "/logout", "", nil).Code).To(Equal(http.StatusOK))
+
+		issued := eventsOfType(AUDIT_TICKET_ISSUED)
+		Expect(issued).To(HaveLen(1))
+		Expect(issued[0].Actor).To(Equal("test@test.com"))
+		Expect(issued[0].Target).To(Equal(testServiceUrl))
+		Expect(issued[0].Outcome).To(Equal(AUDIT_SUCCESS))
+		Expect(issued[0].Details).To(HaveKeyWithValue("ticketType", "service"))
+
+		validated := eventsOfType(AUDIT_TICKET_VALIDATED)
+		Expect(validated).To(HaveLen(2))
+		Expect(validated[0].Outcome).To(Equal(AUDIT_FAILURE))
+		Expect(validated[0].Details).To(HaveKeyWithValue("endpoint", "/serviceValidate"))
+		Expect(validated[0].Details).To(HaveKeyWithValue("errorCode", FailedToFindTicketError.Code))
+		Expect(validated[1].Outcome).To(Equal(AUDIT_SUCCESS))
+		Expect(validated[1].Actor).To(Equal("test@test.com"))
+		Expect(validated[1].Target).To(Equal(testServiceUrl))
+		Expect(validated[1].SourceIP).To(Equal("192.0.2.1"))
+		Expect(validated[1].Details).To(HaveKeyWithValue("endpoint", "/validate"))
+
+		logouts := eventsOfType(AUDIT_LOGOUT)
+		Expect(logouts).To(HaveLen(1))
+		Expect(logouts[0].Actor).To(Equal("test@test.com"))
+		Expect(logouts[0].Outcome).To(Equal(AUDIT_SUCCESS))
+	})
+
+	It("Should record services created, updated and deleted through the API, by the admin acting", func() {
+		Expect(do("POST", "/api/services", `{"name":"audited","url":"localhost:4000/cas","adminEmail":"admin@test.com"}`, admin).Code).To(Equal(http.StatusOK))
+		Expect(do("PUT", "/api/services/audited", `{"name":"audited","url":"localhost:4001/cas","adminEmail":"admin@test.com"}`, admin).Code).To(Equal(http.StatusOK))
+		Expect(do("DELETE", "/api/services/audited", "", admin).Code).To(Equal(http.StatusNoContent))
+		Expect(do("POST", "/api/services", `{"name":"test_service","url":"localhost:4002/cas","adminEmail":"admin@test.com"}`, admin).Code).NotTo(Equal(http.StatusOK))
+
+		for _, eventType := range []string{AUDIT_SERVICE_CREATED, AUDIT_SERVICE_UPDATED, AUDIT_SERVICE_DELETED} {
+			recorded := eventsOfType(eventType)
+			Expect(recorded).NotTo(BeEmpty())
+			Expect(recorded[len(recorded)-1].Actor).To(Equal("admin@test.com"))
+			Expect(recorded[len(recorded)-1].Target).To(Equal("audited"))
+			Expect(recorded[len(recorded)-1].Outcome).To(Equal(AUDIT_SUCCESS))
+			Expect(recorded[len(recorded)-1].SourceIP).To(Equal("192.0.2.1"))
+		}
+
+		created := eventsOfType(AUDIT_SERVICE_CREATED)
+		Expect(created).To(HaveLen(2))
+		Expect(created[0].Target).To(Equal("test_service"))
+		Expect(created[0].Outcome).To(Equal(AUDIT_FAILURE))
+		Expect(created[0].Details).To(HaveKeyWithValue("errorCode", ServiceNameAlreadyTakenError.Code))
+	})
+
+	It("Should record API key authentication, naming the key (but never the secret) when it matches no user", func() {
+		do("GET", "/api/sessions", "", map[string]string{"X-Api-Key": "userapikey", "X-Api-Secret": "badsecret"})
+		do("GET", "/api/sessions", "", map[string]string{"X-Api-Key": "userapikey", "X-Api-Secret": "guessed"})
+
+		used := eventsOfType(AUDIT_API_KEY_USED)
+		Expect(used).To(HaveLen(2))
+		Expect(used[0].Outcome).To(Equal(AUDIT_FAILURE))
+		Expect(used[0].Actor).To(Equal("userapikey"))
+		Expect(used[1].Outcome).To(Equal(AUDIT_SUCCESS))
+		Expect(used[1].Actor).To(Equal("test@test.com"))
+		for _, event := range used {
+			Expect(event.Details).To(HaveKeyWithValue("apiKey", "userapikey"))
+			encoded, err := json.Marshal(event)
+			Expect(err).To(BeNil())
+			Expect(string(encoded)).NotTo(ContainSubstring("badsecret"))
+			Expect(string(encoded)).NotTo(ContainSubstring("guessed"))
+		}
+	})
+
+	It("Should record API tokens issued", func() {
+		server.Config["apiTokenSecret"] = "token-signing-secret"
+		Expect(do("POST", "/api/token", "", admin).Code).To(Equal(http.StatusOK))
+
+		issued := eventsOfType(AUDIT_API_TOKEN_ISSUED)
+		Expect(issued).To(HaveLen(1))
+		Expect(issued[0].Actor).To(Equal("admin@test.com"))
+		Expect(issued[0].Outcome).To(Equal(AUDIT_SUCCESS))
+	})
+
+	Describe("GET /api/audit", func() {
+		getAudit := func(query url.Values, headers map[string]string) (int, map[string]interface{}) {
+			w := do("GET", "/api/audit?"+query.Encode(), "", headers)
+			var response map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+			return w.Code, response
+		}
+
+		BeforeEach(func() {
+			login("wrong")
+			now = now.Add(time.Hour)
+			login("secret")
+			now = now.Add(time.Hour)
+
+			// Requests are authenticated with API keys, rather than the session logged in
+			client = castest.NewClient(server)
+		})
+
+		It("Should list events most recent first, filtered by time range, actor and type", func() {
+			code, response := getAudit(url.Values{"type": {AUDIT_LOGIN}}, admin)
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(response["status"]).To(Equal("success"))
+			data := response["data"].([]interface{})
+			Expect(data).To(HaveLen(2))
+			Expect(data[0].(map[string]interface{})["outcome"]).To(Equal(AUDIT_SUCCESS))
+			Expect(data[0].(map[string]interface{})["sourceIp"]).To(Equal("192.0.2.1"))
+
+			start := now.Add(-2 * time.Hour)
+			_, response = getAudit(url.Values{
+				"type":  {AUDIT_LOGIN},
+				"since": {start.Format(time.RFC3339)},
+				"until": {start.Add(time.Hour).Format(time.RFC3339)},
+			}, admin)
+			data = response["data"].([]interface{})
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].(map[string]interface{})["outcome"]).To(Equal(AUDIT_FAILURE))
+
+			_, response = getAudit(url.Values{"actor": {"test@test.com"}, "limit": {"1"}}, admin)
+			data = response["data"].([]interface{})
+			Expect(data).To(HaveLen(1))
+			Expect(data[0].(map[string]interface{})["actor"]).To(Equal("test@test.com"))
+
+			_, response = getAudit(url.Values{"actor": {"nobody@test.com"}}, admin)
+			Expect(response["data"]).To(BeEmpty())
+		})
+
+		It("Should be limited to admins", func() {
+			code, response := getAudit(nil, map[string]string{"X-Api-Key": "userapikey", "X-Api-Secret": "badsecret"})
+			Expect(code).To(Equal(http.StatusForbidden))
+			Expect(response["code"]).To(Equal(InsufficientPermissionsError.Code))
+
+			code, _ = getAudit(nil, nil)
+			Expect(code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("Should refuse invalid query parameters", func() {
+			for _, query := range []url.Values{{"since": {"yesterday"}}, {"limit": {"0"}}, {"limit": {"100000"}}} {
+				code, response := getAudit(query, admin)
+				Expect(code).To(Equal(http.StatusBadRequest))
+				Expect(response["code"]).To(Equal(InvalidAuditQueryParametersError.Code))
+			}
+		})
+	})
+})
+
+// Backend whose audit writes block until released (unless release is nil)
+type blockingBackend struct {
+	*MemoryBackend
+	writing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) AddAuditEvents(events []AuditEvent) *CASServerError {
+	if b.release != nil {
+		b.writing <- struct{}{}
+		<-b.release
+	}
+	return b.MemoryBackend.AddAuditEvents(events)
+}
+
+var _ = Describe("AuditLog", func() {
+	var (
+		backend *blockingBackend
+		metrics *CASMetrics
+		log     *AuditLog
+	)
+
+	BeforeEach(func() {
+		backend = &blockingBackend{MemoryBackend: NewMemoryBackend(0), writing: make(chan struct{}, 10), release: make(chan struct{})}
+		metrics = NewCASMetrics()
+		log = NewAuditLog(backend, 1, metrics, NoopLogger{})
+	})
+
+	AfterEach(func() {
+		if backend.release != nil {
+			close(backend.release)
+		}
+		log.Stop()
+	})
+
+	It("Should drop events without blocking when the queue is full", func() {
+		log.Record(AuditEvent{Type: AUDIT_LOGIN, Actor: "first"})
+		Eventually(backend.writing).Should(Receive())
+
+		// The first event is being written, the second fills the queue
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for _, actor := range []string{"second", "third", "fourth"} {
+				log.Record(AuditEvent{Type: AUDIT_LOGIN, Actor: actor})
+			}
+		}()
+		Eventually(done).Should(BeClosed())
+		Expect(metrics.AuditEventsDropped.Value("queue_full")).To(Equal(2.0))
+
+		backend.release <- struct{}{}
+		Eventually(backend.writing).Should(Receive())
+		backend.release <- struct{}{}
+
+		log.Flush()
+		recorded, casErr := backend.FindAuditEvents(AuditEventQuery{})
+		Expect(casErr).To(BeNil())
+		Expect(recorded).To(HaveLen(2))
+		Expect(recorded[0].Time).NotTo(BeZero())
+	})
+
+	It("Should write queued events when stopped, and drop events recorded afterwards", func() {
+		backend.release = nil
+
+		log.Record(AuditEvent{Type: AUDIT_LOGOUT, Actor: "before"})
+		log.Stop()
+		log.Record(AuditEvent{Type: AUDIT_LOGOUT, Actor: "after"})
+
+		recorded, _ := backend.FindAuditEvents(AuditEventQuery{})
+		Expect(recorded).To(HaveLen(1))
+		Expect(recorded[0].Actor).To(Equal("before"))
+		Expect(metrics.AuditEventsDropped.Value("stopped")).To(Equal(1.0))
+	})
+
+	It("Should keep no events for backends that can't store them", func() {
+		disabled := NewAuditLog(nil, 1, metrics, NoopLogger{})
+		Expect(disabled.Enabled()).To(BeFalse())
+		disabled.Record(AuditEvent{Type: AUDIT_LOGIN})
+		disabled.Flush()
+		disabled.Stop()
+
+		var unset *AuditLog
+		unset.Record(AuditEvent{Type: AUDIT_LOGIN})
+	})
+})
@@ -0,0 +1,213 @@
+package cas
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+ * User authentication
+ */
+
+// Authentication methods authMethod can list
+var AUTH_METHODS = []string{"password", "ldap"}
+
+// Create the chain of user authenticators specified by the authMethod configuration value, a comma separated list of
+// methods tried in order (ex. "ldap,password"), which defaults to a chain of the password authenticator alone
+// Authenticators log with the given logger (or the default logger if nil)
+func NewAuthenticatorFromConfig(config map[string]string, db Backend, logger Logger) (CASAuthenticator, error) {
+	logger = loggerOrDefault(logger)
+
+	methods, err := ParseAuthMethods(config["authMethod"])
+	if err != nil {
+		return nil, err
+	}
+
+	chain := &AuthenticatorChain{Logger: logger}
+	for _, method := range methods {
+		authenticator, err := newAuthenticator(method, config, db, logger)
+		if err != nil {
+			return nil, err
+		}
+		chain.Methods = append(chain.Methods, AuthMethod{Name: method, Authenticator: authenticator})
+	}
+	return chain, nil
+}
+
+// Parse the authMethod configuration value (the password method if empty), refusing unknown and repeated methods
+func ParseAuthMethods(list string) ([]string, error) {
+	methods := []string{}
+	for _, method := range splitConfigList(list) {
+		method = strings.ToLower(method)
+		if !containsString(AUTH_METHODS, method, false) {
+			return nil, fmt.Errorf("Unsupported authMethod [%s], expected a list of %v", method, AUTH_METHODS)
+		}
+		if containsString(methods, method, false) {
+			return nil, fmt.Errorf("Invalid authMethod [%s], the %s method is listed twice", list, method)
+		}
+		methods = append(methods, method)
+	}
+	if len(methods) == 0 {
+		methods = append(methods, "password")
+	}
+	return methods, nil
+}
+
+// Create the authenticator of a single authentication method
+func newAuthenticator(method string, config map[string]string, db Backend, logger Logger) (CASAuthenticator, error) {
+	switch method {
+	case "password":
+		hasher, err := NewPasswordHasherFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		return &PasswordAuthenticator{Db: db, Hasher: hasher, Logger: logger}, nil
+	case "ldap":
+		ldapConfig, err := NewLDAPConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		authenticator := NewLDAPAuthenticator(ldapConfig, db)
+		authenticator.Logger = logger
+		return authenticator, nil
+	default:
+		return nil, fmt.Errorf("Unsupported authMethod [%s]", method)
+	}
+}
+
+// Authentication method of a chain
+type AuthMethod struct {
+	Name          string // Name of the method (ex. ldap), as listed in authMethod
+	Authenticator CASAuthenticator
+}
+
+// Authenticator trying the authenticators of several methods in order, the first to accept the credentials wins
+// When every method fails, the failure reported is the most telling one (a method refusing the credentials, then a
+// method failing to check them, then no method knowing the user), carrying every method's failure (see AuthFailures)
+type AuthenticatorChain struct {
+	Methods []AuthMethod
+	Logger  Logger // Optional, the default logger is used if nil
+}
+
+// Failures of every method of a chain, in order (the cause of the error returned when none accepted the credentials)
+type AuthFailures []AuthFailure
+
+// Failure of one method of a chain
+type AuthFailure struct {
+	Method string
+	Err    *CASServerError
+}
+
+func (f AuthFailures) Error() string {
+	messages := make([]string, 0, len(f))
+	for _, failure := range f {
+		messages = append(messages, fmt.Sprintf("%s: %s", failure.Method, failure.Err.Msg))
+	}
+	return strings.Join(messages, "; ")
+}
+
+func (a *AuthenticatorChain) Authenticate(email, password string) (*User, *CASServerError) {
+	return a.AuthenticateWithFields(email, password, map[string]string{})
+}
+
+// Authenticate with each method in turn, passing the extra login fields to authenticators that use them
+func (a *AuthenticatorChain) AuthenticateWithFields(email, password string, fields map[string]string) (*User, *CASServerError) {
+	if len(a.Methods) == 0 {
+		return nil, &AuthMethodNotSupportedError
+	}
+
+	failures := make(AuthFailures, 0, len(a.Methods))
+	for _, method := range a.Methods {
+		var user *User
+		var casErr *CASServerError
+		if authenticator, ok := method.Authenticator.(CASFieldsAuthenticator); ok {
+			user, casErr = authenticator.AuthenticateWithFields(email, password, fields)
+		} else {
+			user, casErr = method.Authenticator.Authenticate(email, password)
+		}
+		if casErr == nil {
+			return user, nil
+		}
+
+		if len(a.Methods) > 1 {
+			loggerOrDefault(a.Logger).Debug("Authentication method failed, trying the next one", "method", method.Name, "username", email, "error", casErr)
+		}
+		failures = append(failures, AuthFailure{Method: method.Name, Err: casErr})
+	}
+
+	// Failures are copied, as authenticators return shared error values
+	reported := *failures.mostTelling()
+	var cause error = failures
+	reported.err = &cause
+	return nil, &reported
+}
+
+// Failure reported for the whole chain
+func (f AuthFailures) mostTelling() *CASServerError {
+	rank := func(casErr *CASServerError) int {
+		switch {
+		case casErr.CasgoErrCode == FailedToFindUserError.CasgoErrCode:
+			return 0
+		case casErr.CasgoErrCode >= 200:
+			return 1
+		default:
+			return 2
+		}
+	}
+
+	mostTelling := f[0].Err
+	for _, failure := range f[1:] {
+		if rank(failure.Err) > rank(mostTelling) {
+			mostTelling = failure.Err
+		}
+	}
+	return mostTelling
+}
+
+// Release the resources held by the authenticators of the chain (ex. pooled LDAP connections)
+func (a *AuthenticatorChain) Close() {
+	for _, method := range a.Methods {
+		if authenticator, ok := method.Authenticator.(closer); ok {
+			authenticator.Close()
+		}
+	}
+}
+
+// Authenticator that checks passwords against hashes in the local user store
+// Passwords stored with an outdated hash are re-hashed with the configured hasher after a successful login
+type PasswordAuthenticator struct {
+	Db     Backend
+	Hasher PasswordHasher
+	Logger Logger // Optional, the default logger is used if nil
+}
+
+func (a *PasswordAuthenticator) Authenticate(email, password string) (*User, *CASServerError) {
+	returnedUser, err := a.Db.FindUserByEmail(email)
+	if err != nil {
+		return nil, &FailedToFindUserError
+	}
+
+	// Check hash
+	if !VerifyPassword(returnedUser.Password, password) {
+		return nil, &InvalidCredentialsError
+	}
+
+	// Upgrade the stored hash (failing to do so does not prevent the login)
+	if a.Hasher != nil && !a.Hasher.IsCurrent(returnedUser.Password) {
+		hash, err := a.Hasher.Hash(password)
+		if err != nil {
+			loggerOrDefault(a.Logger).Warn("Failed to re-hash password", "username", email, "error", err)
+			return returnedUser, nil
+		}
+
+		upgradedUser := *returnedUser
+		upgradedUser.Password = hash
+		if casErr := a.Db.UpdateUser(&upgradedUser); casErr != nil {
+			loggerOrDefault(a.Logger).Warn("Failed to store re-hashed password", "username", email, "error", casErr)
+			return returnedUser, nil
+		}
+		returnedUser = &upgradedUser
+	}
+
+	return returnedUser, nil
+}
@@ -0,0 +1,12 @@
+package auth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoAuth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Auth Suite")
+}
@@ -0,0 +1,335 @@
+package auth_test
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// In-memory user store, only implementing the user methods used by the password authenticator
+type fakeUserStore struct {
+	CASDBAdapter
+	users   map[string]*User
+	updates int
+}
+
+func (s *fakeUserStore) FindUserByEmail(email string) (*User, *CASServerError) {
+	if user, ok := s.users[email]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, &FailedToFindUserError
+}
+
+func (s *fakeUserStore) UpdateUser(user *User) *CASServerError {
+	copied := *user
+	s.users[user.Email] = &copied
+	s.updates++
+	return nil
+}
+
+var _ = Describe("Password hashing", func() {
+
+	It("Should verify bcrypt hashes", func() {
+		hasher, err := NewBcryptPasswordHasher(4)
+		Expect(err).To(BeNil())
+		hash, err := hasher.Hash("hunter2")
+		Expect(err).To(BeNil())
+		Expect(hash).To(HavePrefix("$2a$04$"))
+		Expect(VerifyPassword(hash, "hunter2")).To(BeTrue())
+		Expect(VerifyPassword(hash, "hunter3")).To(BeFalse())
+	})
+
+	It("Should verify salted SHA-256 hashes", func() {
+		hasher := &SHA256PasswordHasher{}
+		hash, err := hasher.Hash("hunter2")
+		Expect(err).To(BeNil())
+		Expect(hash).To(HavePrefix(SHA256_PASSWORD_PREFIX))
+		Expect(VerifyPassword(hash, "hunter2")).To(BeTrue())
+		Expect(VerifyPassword(hash, "hunter3")).To(BeFalse())
+
+		other, _ := hasher.Hash("hunter2")
+		Expect(other).ToNot(Equal(hash))
+	})
+
+	It("Should verify legacy plaintext passwords, but never empty ones", func() {
+		Expect(VerifyPassword("hunter2", "hunter2")).To(BeTrue())
+		Expect(VerifyPassword("hunter2", "hunter3")).To(BeFalse())
+		Expect(VerifyPassword("", "")).To(BeFalse())
+	})
+
+	It("Should consider hashes with a different algorithm or cost outdated", func() {
+		hasher, _ := NewBcryptPasswordHasher(5)
+		cheaper, _ := NewBcryptPasswordHasher(4)
+		hash, _ := cheaper.Hash("hunter2")
+		Expect(cheaper.IsCurrent(hash)).To(BeTrue())
+		Expect(hasher.IsCurrent(hash)).To(BeFalse())
+		Expect(hasher.IsCurrent("hunter2")).To(BeFalse())
+		Expect((&SHA256PasswordHasher{}).IsCurrent(hash)).To(BeFalse())
+	})
+
+	It("Should create the configured hasher", func() {
+		hasher, err := NewPasswordHasherFromConfig(map[string]string{"passwordHashAlgorithm": "bcrypt", "bcryptCost": "12"})
+		Expect(err).To(BeNil())
+		Expect(hasher).To(Equal(&BcryptPasswordHasher{Cost: 12}))
+
+		hasher, err = NewPasswordHasherFromConfig(map[string]string{"passwordHashAlgorithm": "sha256"})
+		Expect(err).To(BeNil())
+		Expect(hasher).To(BeAssignableToTypeOf(&SHA256PasswordHasher{}))
+	})
+
+	It("Should reject invalid costs and unknown algorithms", func() {
+		_, err := NewPasswordHasherFromConfig(map[string]string{"passwordHashAlgorithm": "bcrypt", "bcryptCost": "99"})
+		Expect(err).ToNot(BeNil())
+		_, err = NewPasswordHasherFromConfig(map[string]string{"passwordHashAlgorithm": "md5"})
+		Expect(err).ToNot(BeNil())
+	})
+
+})
+
+var _ = Describe("PasswordAuthenticator", func() {
+	var (
+		store         *fakeUserStore
+		authenticator *PasswordAuthenticator
+	)
+
+	BeforeEach(func() {
+		store = &fakeUserStore{users: make(map[string]*User)}
+		hasher, _ := NewBcryptPasswordHasher(4)
+		authenticator = &PasswordAuthenticator{Db: store, Hasher: hasher}
+	})
+
+	It("Should upgrade legacy hashes to bcrypt on login", func() {
+		legacyHash, _ := (&SHA256PasswordHasher{}).Hash("hunter2")
+		store.users["test@test.com"] = &User{Email: "test@test.com", Password: legacyHash, IsAdmin: true}
+
+		user, casErr := authenticator.Authenticate("test@test.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(store.updates).To(Equal(1))
+
+		stored := store.users["test@test.com"]
+		Expect(strings.HasPrefix(stored.Password, "$2a$04$")).To(BeTrue())
+		Expect(VerifyPassword(stored.Password, "hunter2")).To(BeTrue())
+		Expect(stored.IsAdmin).To(BeTrue())
+		Expect(user.Password).To(Equal(stored.Password))
+	})
+
+	It("Should upgrade plaintext passwords on login", func() {
+		store.users["test@test.com"] = &User{Email: "test@test.com", Password: "hunter2"}
+
+		_, casErr := authenticator.Authenticate("test@test.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(VerifyPassword(store.users["test@test.com"].Password, "hunter2")).To(BeTrue())
+		Expect(store.users["test@test.com"].Password).ToNot(Equal("hunter2"))
+	})
+
+	It("Should not re-hash current hashes", func() {
+		hash, _ := authenticator.Hasher.Hash("hunter2")
+		store.users["test@test.com"] = &User{Email: "test@test.com", Password: hash}
+
+		_, casErr := authenticator.Authenticate("test@test.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(store.updates).To(Equal(0))
+	})
+
+	It("Should not upgrade hashes on failed logins", func() {
+		store.users["test@test.com"] = &User{Email: "test@test.com", Password: "hunter2"}
+
+		_, casErr := authenticator.Authenticate("test@test.com", "wrong")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(InvalidCredentialsError.CasgoErrCode))
+		Expect(store.updates).To(Equal(0))
+		Expect(store.users["test@test.com"].Password).To(Equal("hunter2"))
+	})
+
+})
+
+// Authenticator returning a fixed outcome, counting the attempts it was asked to check
+type fakeAuthenticator struct {
+	user     *User
+	failure  *CASServerError
+	attempts int
+	fields   map[string]string
+	closed   bool
+}
+
+func (a *fakeAuthenticator) Authenticate(email, password string) (*User, *CASServerError) {
+	a.attempts++
+	return a.user, a.failure
+}
+
+func (a *fakeAuthenticator) Close() {
+	a.closed = true
+}
+
+// Authenticator also receiving the extra login fields
+type fakeFieldsAuthenticator struct {
+	fakeAuthenticator
+}
+
+func (a *fakeFieldsAuthenticator) AuthenticateWithFields(email, password string, fields map[string]string) (*User, *CASServerError) {
+	a.fields = fields
+	return a.Authenticate(email, password)
+}
+
+var _ = Describe("AuthenticatorChain", func() {
+	var (
+		ldapUser, localUser *User
+	)
+
+	BeforeEach(func() {
+		ldapUser = &User{Email: "test@test.com", Name: "From LDAP"}
+		localUser = &User{Email: "test@test.com", Name: "From the local store"}
+	})
+
+	chainOf := func(authenticators ...CASAuthenticator) *AuthenticatorChain {
+		chain := &AuthenticatorChain{Logger: NoopLogger{}}
+		for i, authenticator := range authenticators {
+			chain.Methods = append(chain.Methods, AuthMethod{Name: []string{"ldap", "password", "other"}[i], Authenticator: authenticator})
+		}
+		return chain
+	}
+
+	It("Should fall through to the next method when a method fails", func() {
+		ldap := &fakeAuthenticator{failure: &FailedToConnectToLDAPError}
+		local := &fakeAuthenticator{user: localUser}
+
+		user, casErr := chainOf(ldap, local).Authenticate("test@test.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(user).To(Equal(localUser))
+		Expect(ldap.attempts).To(Equal(1))
+		Expect(local.attempts).To(Equal(1))
+	})
+
+	It("Should stop at the first method that accepts the credentials", func() {
+		ldap := &fakeAuthenticator{user: ldapUser}
+		local := &fakeAuthenticator{user: localUser}
+
+		user, casErr := chainOf(ldap, local).Authenticate("test@test.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(user).To(Equal(ldapUser))
+		Expect(local.attempts).To(Equal(0))
+	})
+
+	It("Should report the most telling failure, carrying every method's failure, when all methods fail", func() {
+		ldap := &fakeAuthenticator{failure: &FailedToConnectToLDAPError}
+		local := &fakeAuthenticator{failure: &InvalidCredentialsError}
+		other := &fakeAuthenticator{failure: &FailedToFindUserError}
+
+		_, casErr := chainOf(ldap, local, other).Authenticate("test@test.com", "hunter2")
+		Expect(casErr).NotTo(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(InvalidCredentialsError.CasgoErrCode))
+		Expect(casErr.HttpCode).To(Equal(InvalidCredentialsError.HttpCode))
+
+		var failures AuthFailures
+		Expect(errors.As(casErr, &failures)).To(BeTrue())
+		Expect(failures).To(Equal(AuthFailures{
+			{Method: "ldap", Err: &FailedToConnectToLDAPError},
+			{Method: "password", Err: &InvalidCredentialsError},
+			{Method: "other", Err: &FailedToFindUserError},
+		}))
+		Expect(failures.Error()).To(Equal("ldap: Failed to connect to LDAP server; password: Invalid email/password combination; other: Failed to find matching email/password combination"))
+
+		// The shared error values aren't modified
+		Expect(errors.Unwrap(&InvalidCredentialsError)).To(BeNil())
+	})
+
+	It("Should prefer internal failures to unknown users, and report unknown users when no method knows them", func() {
+		_, casErr := chainOf(&fakeAuthenticator{failure: &FailedToFindUserError}, &fakeAuthenticator{failure: &FailedToSearchLDAPError}).Authenticate("test@test.com", "hunter2")
+		Expect(casErr.CasgoErrCode).To(Equal(FailedToSearchLDAPError.CasgoErrCode))
+
+		_, casErr = chainOf(&fakeAuthenticator{failure: &FailedToFindUserError}, &fakeAuthenticator{failure: &FailedToFindUserError}).Authenticate("test@test.com", "hunter2")
+		Expect(casErr.CasgoErrCode).To(Equal(FailedToFindUserError.CasgoErrCode))
+	})
+
+	It("Should refuse credentials without any method", func() {
+		_, casErr := chainOf().Authenticate("test@test.com", "hunter2")
+		Expect(casErr).To(Equal(&AuthMethodNotSupportedError))
+	})
+
+	It("Should pass the extra login fields to the methods that use them, and close every method", func() {
+		ldap := &fakeFieldsAuthenticator{fakeAuthenticator{failure: &FailedToFindUserError}}
+		local := &fakeAuthenticator{user: localUser}
+		chain := chainOf(ldap, local)
+
+		_, casErr := chain.AuthenticateWithFields("test@test.com", "hunter2", map[string]string{"tenant": "acme"})
+		Expect(casErr).To(BeNil())
+		Expect(ldap.fields).To(Equal(map[string]string{"tenant": "acme"}))
+
+		chain.Close()
+		Expect(ldap.closed).To(BeTrue())
+		Expect(local.closed).To(BeTrue())
+	})
+
+	It("Should build the chain listed in authMethod, defaulting to the password method alone", func() {
+		configWith := func(authMethod string) map[string]string {
+			config := map[string]string{}
+			for key, value := range CONFIG_DEFAULTS {
+				config[key] = value
+			}
+			config["authMethod"] = authMethod
+			return config
+		}
+
+		for list, expected := range map[string][]string{"": {"password"}, "password": {"password"}, "ldap, Password": {"ldap", "password"}} {
+			authenticator, err := NewAuthenticatorFromConfig(configWith(list), nil, NoopLogger{})
+			Expect(err).To(BeNil(), list)
+
+			names := []string{}
+			for _, method := range authenticator.(*AuthenticatorChain).Methods {
+				names = append(names, method.Name)
+			}
+			Expect(names).To(Equal(expected), list)
+		}
+		authenticator, _ := NewAuthenticatorFromConfig(configWith(CONFIG_DEFAULTS["authMethod"]), nil, NoopLogger{})
+		Expect(authenticator.(*AuthenticatorChain).Methods[0].Authenticator).To(BeAssignableToTypeOf(&PasswordAuthenticator{}))
+
+		for _, list := range []string{"kerberos", "password,ldap,password", "password,trustedHeader"} {
+			_, err := ParseAuthMethods(list)
+			Expect(err).To(HaveOccurred(), list)
+		}
+	})
+})
+
+var _ = Describe("Secret comparison", func() {
+	It("Should only consider identical secrets equal", func() {
+		Expect(SecretsEqual("badsecret", "badsecret")).To(BeTrue())
+		Expect(SecretsEqual("badsecreT", "badsecret")).To(BeFalse())
+		Expect(SecretsEqual("badsecret", "badsecret2")).To(BeFalse())
+		Expect(SecretsEqual("", "badsecret")).To(BeFalse())
+	})
+
+	It("Should authenticate API keys only with their exact secret", func() {
+		db := NewMemoryBackend(0)
+		defer db.Close()
+		Expect(db.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+
+		user, casErr := db.FindUserByApiKeyAndSecret("adminapikey", "badsecret")
+		Expect(casErr).To(BeNil())
+		Expect(user.IsAdmin).To(BeTrue())
+
+		for _, secret := range []string{"badsecreX", "badsecret ", "", "BADSECRET"} {
+			_, casErr = db.FindUserByApiKeyAndSecret("adminapikey", secret)
+			Expect(casErr).To(Equal(&FailedToFindUserByApiKeyAndSecretError))
+		}
+	})
+})
+
+// Comparing a secret with guesses that share longer and longer prefixes with it should take the same time
+// (compare the ns/op of the sub-benchmarks with go test -bench SecretsEqual)
+func BenchmarkSecretsEqual(b *testing.B) {
+	secret := strings.Repeat("s", 64)
+	for _, matching := range []int{0, 16, 32, 63} {
+		guess := secret[:matching] + strings.Repeat("x", len(secret)-matching)
+		b.Run("matchingPrefix"+strconv.Itoa(matching), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				SecretsEqual(guess, secret)
+			}
+		})
+	}
+}
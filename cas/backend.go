@@ -0,0 +1,170 @@
+package cas
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+/*
+ * Pluggable storage backends
+ */
+
+// Storage operations required by casgo
+// Implementations must be safe for concurrent use
+type Backend interface {
+	// Storage setup & teardown logic
+	Setup() *CASServerError
+	Teardown() *CASServerError
+
+	// Users
+	FindUserByEmail(string) (*User, *CASServerError)
+	FindUserByApiKeyAndSecret(string, string) (*User, *CASServerError)
+	AddNewUser(string, string) (*User, *CASServerError)
+	GetAllUsers() ([]User, *CASServerError)
+	// Find a page of users (without their passwords) ordered by email, along with the total number of matching users
+	FindUsers(CASUserQuery) ([]User, int, *CASServerError)
+	UpdateUser(*User) *CASServerError
+	RemoveUserByEmail(string) *CASServerError
+
+	// Services
+	FindServiceByUrl(string) (*CASService, *CASServerError)
+	GetAllServices() ([]CASService, *CASServerError)
+	// Find a page of services ordered by name, along with the total number of matching services
+	FindServices(CASServiceQuery) ([]CASService, int, *CASServerError)
+	// Find a service by its ID (ServiceNotFoundError if there is none)
+	FindServiceById(string) (*CASService, *CASServerError)
+	AddNewService(*CASService) *CASServerError
+	RemoveServiceByName(string) *CASServerError
+	UpdateService(*CASService) *CASServerError
+	// Update a service only if its stored version is the given one (ServiceVersionConflictError otherwise)
+	UpdateServiceIfVersion(*CASService, int) *CASServerError
+
+	// Service tickets
+	AddTicketForService(ticket *CASTicket, service *CASService) (*CASTicket, *CASServerError)
+	RemoveTicketsForUserWithService(string, *CASService) *CASServerError
+	FindTicketByIdForService(string, *CASService) (*CASTicket, *CASServerError)
+	// Mark a ticket validated (at the given time), atomically, unless it already was (TicketAlreadyValidatedError), so
+	// it's only validated once; tickets first validated less than the given reuse window ago can be validated again
+	ConsumeTicket(ticketId string, now time.Time, reuseWindow time.Duration) *CASServerError
+	FindValidatedTicketsForTGT(string) ([]CASTicket, *CASServerError)
+	FindTicketsForTGT(string) ([]CASTicket, *CASServerError)
+
+	// Proxy-granting & proxy tickets
+	AddProxyGrantingTicket(*CASProxyGrantingTicket) *CASServerError
+	FindProxyGrantingTicketById(string) (*CASProxyGrantingTicket, *CASServerError)
+	AddProxyTicket(*CASProxyTicket) *CASServerError
+	FindProxyTicketById(string) (*CASProxyTicket, *CASServerError)
+	// Remove a proxy ticket, atomically, unless it already was (FailedToFindProxyTicketError), so it's only validated once
+	ConsumeProxyTicket(ptId string) *CASServerError
+
+	// Sessions (ticket-granting tickets)
+	AddTicketGrantingTicket(*CASTicketGrantingTicket) *CASServerError
+	FindTicketGrantingTicketById(string) (*CASTicketGrantingTicket, *CASServerError)
+	FindTicketGrantingTicketsForUser(string) ([]CASTicketGrantingTicket, *CASServerError)
+	UpdateTicketGrantingTicket(*CASTicketGrantingTicket) *CASServerError
+	RemoveTicketGrantingTicketById(string) *CASServerError
+}
+
+// Storage backends that can use information about the request they are serving (ex. to log its ID)
+// The request context carries the request ID (see RequestIDFromContext), and is done once the client disconnects
+// Operations run through a view should give up (with DbOperationCancelledError) once its context is done
+type ContextualBackend interface {
+	Backend
+	// Get a view of the backend for operations run on behalf of a request
+	WithContext(ctx context.Context) Backend
+}
+
+// Storage backends that can remove expired tickets in bulk (used by the TicketSweeper)
+type SweepableBackend interface {
+	Backend
+	// Remove tickets that expired at or before the given time
+	// Service tickets are removed along with the expired ticket-granting ticket they were issued under
+	RemoveExpiredTickets(now time.Time) (SweepResult, *CASServerError)
+}
+
+// Storage backends that can keep OpenID Connect authorization codes (used by the OIDC bridge), so a code issued by
+// one casgo node can be redeemed on another; the OIDC provider keeps codes in memory for other backends (which only
+// works with a single node)
+type OIDCCodeBackend interface {
+	Backend
+	AddOIDCAuthorizationCode(*OIDCAuthorizationCode) *CASServerError
+	// Remove a code, returning it (nil if there was none), atomically so that it can only be redeemed once
+	ConsumeOIDCAuthorizationCode(code string) (*OIDCAuthorizationCode, *CASServerError)
+}
+
+// Storage backends that can keep an audit trail of security-relevant events (used by the AuditLog)
+// Events are only ever added, so the trail can't be altered through casgo
+type AuditableBackend interface {
+	Backend
+	AddAuditEvents([]AuditEvent) *CASServerError
+	// Find events matching a query, most recent first
+	FindAuditEvents(AuditEventQuery) ([]AuditEvent, *CASServerError)
+}
+
+// Storage backends that can report whether they are reachable (used by the readiness probe)
+type PingableBackend interface {
+	Backend
+	// Check that the backend can serve requests, giving up when the context is done
+	Ping(ctx context.Context) *CASServerError
+}
+
+// Storage backends whose storage changes as casgo gains features, keeping track of the migrations applied to them
+// (used by RunMigrations)
+type MigratableBackend interface {
+	Backend
+	// All migrations of the backend, ordered by version
+	Migrations() []Migration
+	// Version of the latest migration applied to the storage (0 if none were)
+	SchemaVersion() (int, *CASServerError)
+	// Record that a migration was applied (at the given time)
+	RecordMigration(migration Migration, appliedAt time.Time) *CASServerError
+}
+
+// Creates a storage backend for a CAS server
+type BackendFactory func(c *CAS) (Backend, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]BackendFactory{}
+)
+
+// Make a storage backend available under a name (selected with the dbBackend config option)
+// Registering a name twice replaces the previous factory
+func RegisterBackend(name string, factory BackendFactory) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[name] = factory
+}
+
+// Names of all registered storage backends, sorted
+func RegisteredBackends() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+
+	names := []string{}
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Create the storage backend specified by server configuration
+func NewBackendFromConfig(c *CAS) (Backend, error) {
+	name := c.Config["dbBackend"]
+	if len(name) == 0 {
+		name = CONFIG_DEFAULTS["dbBackend"]
+	}
+
+	backendsMu.RLock()
+	factory, ok := backends[name]
+	backendsMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("Unsupported dbBackend [%s], expected one of %v", name, RegisteredBackends())
+	}
+
+	return factory(c)
+}
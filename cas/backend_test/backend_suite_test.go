@@ -0,0 +1,12 @@
+package backend_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoBackend(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Backend Suite")
+}
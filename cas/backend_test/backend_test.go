@@ -0,0 +1,404 @@
+package backend_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Minimal map based backend, standing in for a database
+type mockBackend struct {
+	mu       sync.Mutex
+	setup    bool
+	users    map[string]User
+	services map[string]CASService
+	tickets  map[string]CASTicket
+	pgts     map[string]CASProxyGrantingTicket
+	pts      map[string]CASProxyTicket
+	tgts     map[string]CASTicketGrantingTicket
+}
+
+var _ Backend = (*mockBackend)(nil)
+
+func newMockBackend() *mockBackend {
+	return &mockBackend{
+		users:    map[string]User{},
+		services: map[string]CASService{},
+		tickets:  map[string]CASTicket{},
+		pgts:     map[string]CASProxyGrantingTicket{},
+		pts:      map[string]CASProxyTicket{},
+		tgts:     map[string]CASTicketGrantingTicket{},
+	}
+}
+
+func (m *mockBackend) Setup() *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setup = true
+	return nil
+}
+
+func (m *mockBackend) Teardown() *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.setup = false
+	return nil
+}
+
+func (m *mockBackend) FindUserByEmail(email string) (*User, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	user, ok := m.users[email]
+	if !ok {
+		return nil, &FailedToFindUserError
+	}
+	return &user, nil
+}
+
+func (m *mockBackend) FindUserByApiKeyAndSecret(key, secret string) (*User, *CASServerError) {
+	return nil, &FailedToFindUserError
+}
+
+func (m *mockBackend) AddNewUser(email, password string) (*User, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.users[email]; ok {
+		return nil, &EmailAlreadyTakenError
+	}
+	user := User{Email: email, Password: password}
+	m.users[email] = user
+	return &user, nil
+}
+
+func (m *mockBackend) GetAllUsers() ([]User, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	users := []User{}
+	for _, user := range m.users {
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+func (m *mockBackend) FindUsers(query CASUserQuery) ([]User, int, *CASServerError) {
+	users, _ := m.GetAllUsers()
+	return users, len(users), nil
+}
+
+func (m *mockBackend) UpdateUser(user *User) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.users[user.Email] = *user
+	return nil
+}
+
+func (m *mockBackend) RemoveUserByEmail(email string) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.users, email)
+	return nil
+}
+
+func (m *mockBackend) FindServiceByUrl(serviceUrl string) (*CASService, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, service := range m.services {
+		if service.Url == serviceUrl {
+			return &service, nil
+		}
+	}
+	return nil, &FailedToFindServiceError
+}
+
+func (m *mockBackend) FindServiceById(id string) (*CASService, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, service := range m.services {
+		if service.Id == id {
+			return &service, nil
+		}
+	}
+	return nil, &ServiceNotFoundError
+}
+
+func (m *mockBackend) GetAllServices() ([]CASService, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	services := []CASService{}
+	for _, service := range m.services {
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func (m *mockBackend) FindServices(query CASServiceQuery) ([]CASService, int, *CASServerError) {
+	services, _ := m.GetAllServices()
+	return services, len(services), nil
+}
+
+func (m *mockBackend) AddNewService(service *CASService) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.services[service.Name] = *service
+	return nil
+}
+
+func (m *mockBackend) RemoveServiceByName(name string) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.services, name)
+	return nil
+}
+
+func (m *mockBackend) UpdateService(service *CASService) *CASServerError {
+	return m.AddNewService(service)
+}
+
+func (m *mockBackend) UpdateServiceIfVersion(service *CASService, version int) *CASServerError {
+	return m.AddNewService(service)
+}
+
+func (m *mockBackend) AddTicketForService(ticket *CASTicket, service *CASService) (*CASTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tickets[ticket.Id] = *ticket
+	return ticket, nil
+}
+
+func (m *mockBackend) RemoveTicketsForUserWithService(email string, service *CASService) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for id, ticket := range m.tickets {
+		if ticket.UserEmail == email && ticket.ServiceUrl == service.Url {
+			delete(m.tickets, id)
+		}
+	}
+	return nil
+}
+
+func (m *mockBackend) FindTicketByIdForService(ticketId string, service *CASService) (*CASTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ticket, ok := m.tickets[ticketId]
+	if !ok {
+		return nil, &FailedToFindTicketError
+	}
+	return &ticket, nil
+}
+
+func (m *mockBackend) ConsumeTicket(ticketId string, now time.Time, reuseWindow time.Duration) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ticket := m.tickets[ticketId]
+	if ticket.Validated {
+		return &TicketAlreadyValidatedError
+	}
+	ticket.Validated = true
+	m.tickets[ticketId] = ticket
+	return nil
+}
+
+func (m *mockBackend) FindValidatedTicketsForTGT(tgtId string) ([]CASTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tickets := []CASTicket{}
+	for _, ticket := range m.tickets {
+		if ticket.TGTId == tgtId && ticket.Validated {
+			tickets = append(tickets, ticket)
+		}
+	}
+	return tickets, nil
+}
+
+func (m *mockBackend) FindTicketsForTGT(tgtId string) ([]CASTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tickets := []CASTicket{}
+	for _, ticket := range m.tickets {
+		if ticket.TGTId == tgtId {
+			tickets = append(tickets, ticket)
+		}
+	}
+	return tickets, nil
+}
+
+func (m *mockBackend) AddProxyGrantingTicket(pgt *CASProxyGrantingTicket) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pgts[pgt.Id] = *pgt
+	return nil
+}
+
+func (m *mockBackend) FindProxyGrantingTicketById(id string) (*CASProxyGrantingTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pgt, ok := m.pgts[id]
+	if !ok {
+		return nil, &FailedToFindProxyGrantingTicketError
+	}
+	return &pgt, nil
+}
+
+func (m *mockBackend) AddProxyTicket(pt *CASProxyTicket) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pts[pt.Id] = *pt
+	return nil
+}
+
+func (m *mockBackend) FindProxyTicketById(id string) (*CASProxyTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pt, ok := m.pts[id]
+	if !ok {
+		return nil, &FailedToFindProxyTicketError
+	}
+	return &pt, nil
+}
+
+func (m *mockBackend) ConsumeProxyTicket(id string) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.pts[id]; !ok {
+		return &FailedToFindProxyTicketError
+	}
+	delete(m.pts, id)
+	return nil
+}
+
+func (m *mockBackend) AddTicketGrantingTicket(tgt *CASTicketGrantingTicket) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tgts[tgt.Id] = *tgt
+	return nil
+}
+
+func (m *mockBackend) FindTicketGrantingTicketById(id string) (*CASTicketGrantingTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tgt, ok := m.tgts[id]
+	if !ok {
+		return nil, &FailedToFindTicketGrantingTicketError
+	}
+	return &tgt, nil
+}
+
+func (m *mockBackend) FindTicketGrantingTicketsForUser(email string) ([]CASTicketGrantingTicket, *CASServerError) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tgts := []CASTicketGrantingTicket{}
+	for _, tgt := range m.tgts {
+		if tgt.UserEmail == email {
+			tgts = append(tgts, tgt)
+		}
+	}
+	return tgts, nil
+}
+
+func (m *mockBackend) UpdateTicketGrantingTicket(tgt *CASTicketGrantingTicket) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.tgts[tgt.Id] = *tgt
+	return nil
+}
+
+func (m *mockBackend) RemoveTicketGrantingTicketById(id string) *CASServerError {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.tgts, id)
+	return nil
+}
+
+var _ = Describe("Storage backends", func() {
+	var (
+		backend *mockBackend
+		config  map[string]string
+	)
+
+	BeforeEach(func() {
+		backend = newMockBackend()
+		RegisterBackend("mock", func(c *CAS) (Backend, error) {
+			return backend, nil
+		})
+
+		config = castest.NewTestConfig(map[string]string{"dbBackend": "mock"})
+	})
+
+	Describe("Registration", func() {
+		It("Should register RethinkDB as the default backend", func() {
+			Expect(CONFIG_DEFAULTS["dbBackend"]).To(Equal("rethinkdb"))
+			Expect(RegisteredBackends()).To(ContainElement("rethinkdb"))
+			Expect(RegisteredBackends()).To(ContainElement("mock"))
+		})
+
+		It("Should create the backend selected by configuration", func() {
+			created, err := NewBackendFromConfig(&CAS{Config: config})
+			Expect(err).To(BeNil())
+			Expect(created).To(Equal(Backend(backend)))
+		})
+
+		It("Should fail for unregistered backends", func() {
+			config["dbBackend"] = "postgres"
+			created, err := NewBackendFromConfig(&CAS{Config: config})
+			Expect(err).NotTo(BeNil())
+			Expect(created).To(BeNil())
+			Expect(err.Error()).To(ContainSubstring("postgres"))
+		})
+	})
+
+	Describe("CAS server", func() {
+		var server *CAS
+
+		BeforeEach(func() {
+			var err error
+			server, err = NewCASServer(config)
+			Expect(err).To(BeNil())
+
+			Expect(server.SetupDb()).To(BeNil())
+			Expect(backend.setup).To(BeTrue())
+
+			hash, err := server.PasswordHasher.Hash("secret")
+			Expect(err).To(BeNil())
+			_, casErr := backend.AddNewUser("user@test.com", hash)
+			Expect(casErr).To(BeNil())
+			Expect(backend.AddNewService(&CASService{
+				Url:        "http://localhost:3000/validateCASLogin",
+				Name:       "test_service",
+				AdminEmail: "admin@test.com",
+			})).To(BeNil())
+		})
+
+		It("Should use the configured backend", func() {
+			Expect(server.Db).To(Equal(Backend(backend)))
+		})
+
+		It("Should authenticate users stored in the backend", func() {
+			user, casErr := server.Authenticator.Authenticate("user@test.com", "secret")
+			Expect(casErr).To(BeNil())
+			Expect(user.Email).To(Equal("user@test.com"))
+
+			_, casErr = server.Authenticator.Authenticate("user@test.com", "wrong")
+			Expect(casErr).NotTo(BeNil())
+		})
+
+		It("Should store tickets in the backend on login", func() {
+			w := castest.Login(server, url.Values{
+				"email":      {"user@test.com"},
+				"serviceUrl": {"http://localhost:3000/validateCASLogin"},
+			})
+
+			Expect(w.Code).To(Equal(http.StatusFound))
+			ticketId := castest.Ticket(w)
+			Expect(ticketId).NotTo(BeEmpty())
+
+			Expect(backend.tickets).To(HaveKey(ticketId))
+			Expect(backend.tickets[ticketId].UserEmail).To(Equal("user@test.com"))
+			Expect(backend.tgts).To(HaveKey(backend.tickets[ticketId].TGTId))
+		})
+	})
+})
@@ -0,0 +1,12 @@
+package backendcontext_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoBackendContext(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo BackendContext Suite")
+}
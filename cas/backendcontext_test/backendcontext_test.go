@@ -0,0 +1,140 @@
+package backendcontext_test
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+type testContextKey struct{}
+
+// Memory backend whose ticket lookups (once slowed down) only return when the context of their view is done
+type slowBackend struct {
+	*MemoryBackend
+	slow     *bool
+	contexts chan context.Context // Contexts of the slowed down lookups
+}
+
+type slowView struct {
+	slowBackend
+	ctx context.Context
+}
+
+func (b slowBackend) WithContext(ctx context.Context) Backend {
+	return slowView{slowBackend: b, ctx: ctx}
+}
+
+func (v slowView) FindTicketByIdForService(ticketId string, service *CASService) (*CASTicket, *CASServerError) {
+	if !*v.slow {
+		return v.MemoryBackend.FindTicketByIdForService(ticketId, service)
+	}
+
+	v.contexts <- v.ctx
+	select {
+	case <-v.ctx.Done():
+		return nil, &DbOperationCancelledError
+	case <-time.After(10 * time.Second):
+		return v.MemoryBackend.FindTicketByIdForService(ticketId, service)
+	}
+}
+
+var _ = Describe("Request-scoped backend operations", func() {
+	var (
+		server  *CAS
+		backend slowBackend
+	)
+
+	BeforeEach(func() {
+		backend = slowBackend{
+			MemoryBackend: NewMemoryBackend(time.Minute),
+			slow:          new(bool),
+			contexts:      make(chan context.Context, 1),
+		}
+		RegisterBackend("slow-test", func(c *CAS) (Backend, error) {
+			return backend, nil
+		})
+
+		server = castest.NewTestServerWithOptions(map[string]string{"dbBackend": "slow-test"}, CASServerOptions{})
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	// Log in with credentials, returning the service ticket issued for the test service
+	login := func() string {
+		w := castest.Login(server, url.Values{"serviceUrl": {testServiceUrl}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	// Validate a ticket with a request made with the given context, returning the response and how long it took
+	validate := func(ctx context.Context, path, ticket string) (*httptest.ResponseRecorder, time.Duration) {
+		req := httptest.NewRequest("GET", path+"?"+url.Values{"service": {testServiceUrl}, "ticket": {ticket}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		start := time.Now()
+		server.Handler().ServeHTTP(w, req.WithContext(ctx))
+		return w, time.Since(start)
+	}
+
+	It("Should give up on operations once the client disconnects", func() {
+		ticket := login()
+		*backend.slow = true
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			defer GinkgoRecover()
+			defer cancel()
+			Expect((<-backend.contexts).Err()).To(BeNil())
+		}()
+
+		w, elapsed := validate(ctx, "/validate", ticket)
+		Expect(elapsed).To(BeNumerically("<", 5*time.Second))
+
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("error"))
+		Expect(response["failureCode"]).To(Equal(CAS_INTERNAL_ERROR))
+	})
+
+	It("Should give up on operations once the request's deadline passes", func() {
+		ticket := login()
+		*backend.slow = true
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		w, elapsed := validate(ctx, "/serviceValidate", ticket)
+		Expect(elapsed).To(BeNumerically("<", 5*time.Second))
+		Expect(w.Body.String()).To(ContainSubstring(`code="` + CAS_INTERNAL_ERROR + `"`))
+
+		// The ticket was not consumed, so it can still be validated
+		*backend.slow = false
+		w, _ = validate(context.Background(), "/serviceValidate", ticket)
+		Expect(w.Body.String()).To(ContainSubstring("<cas:authenticationSuccess>"))
+	})
+
+	It("Should run operations with the context of the request they are made for", func() {
+		ticket := login()
+		*backend.slow = true
+
+		ctx, cancel := context.WithCancel(context.WithValue(context.Background(), testContextKey{}, "value"))
+		go func() {
+			defer GinkgoRecover()
+			defer cancel()
+			lookupCtx := <-backend.contexts
+			Expect(lookupCtx.Value(testContextKey{})).To(Equal("value"))
+			_, hasId := RequestIDFromContext(lookupCtx)
+			Expect(hasId).To(BeTrue())
+		}()
+		validate(ctx, "/validate", ticket)
+	})
+})
@@ -0,0 +1,297 @@
+package cas
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+/*
+ * Backups (exports of all services and users, which can be restored later)
+ */
+
+// Identifies backup documents, and the version of their format
+// The version is increased whenever a change would cause older servers to restore a backup incorrectly
+const (
+	BACKUP_SCHEMA         = "casgo-backup"
+	BACKUP_SCHEMA_VERSION = 1
+)
+
+// Backup document (written field by field when exporting, see WriteBackup)
+type Backup struct {
+	Schema     string       `json:"schema"`
+	Version    int          `json:"version"`
+	ExportedAt time.Time    `json:"exportedAt"`
+	Services   []CASService `json:"services"`
+	Users      []BackupUser `json:"users"`
+}
+
+// Fields of a backup written before its services and users
+type backupHeader struct {
+	Schema     string    `json:"schema"`
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exportedAt"`
+}
+
+// User in a backup
+// Only password hashes are included, two-factor authentication secrets are never exported
+type BackupUser struct {
+	Email        string            `json:"email"`
+	Name         string            `json:"name"`
+	Attributes   map[string]string `json:"attributes"`
+	PasswordHash string            `json:"passwordHash"`
+	Services     []CASService      `json:"services"`
+	IsAdmin      bool              `json:"isAdmin"`
+
+	WarnBeforeServiceLogin bool `json:"warnBeforeServiceLogin,omitempty"`
+}
+
+func newBackupUser(user *User) BackupUser {
+	return BackupUser{
+		Email:        user.Email,
+		Name:         user.Name,
+		Attributes:   user.Attributes,
+		PasswordHash: user.Password,
+		Services:     user.Services,
+		IsAdmin:      user.IsAdmin,
+
+		WarnBeforeServiceLogin: user.WarnBeforeServiceLogin,
+	}
+}
+
+func (u *BackupUser) user() User {
+	return User{
+		Email:      u.Email,
+		Name:       u.Name,
+		Attributes: u.Attributes,
+		Password:   u.PasswordHash,
+		Services:   u.Services,
+		IsAdmin:    u.IsAdmin,
+
+		WarnBeforeServiceLogin: u.WarnBeforeServiceLogin,
+	}
+}
+
+// Results of restoring a backup
+type BackupRestoreReport struct {
+	Services *ImportReport `json:"services"`
+	Users    *ImportReport `json:"users"`
+}
+
+// Write a backup of all services and users to w as JSON
+// Each service and user is encoded as soon as it has been read (and flushed every so often, if w is an
+// http.Flusher), rather than building the whole document first
+// Errors after the document has been started leave it truncated (and invalid)
+func (c *CAS) WriteBackup(w io.Writer) *CASServerError {
+	services, casErr := c.Db.GetAllServices()
+	if casErr != nil {
+		return casErr
+	}
+	// Users are listed without their passwords, so each user is looked up on its own as it is written
+	users, casErr := c.Db.GetAllUsers()
+	if casErr != nil {
+		return casErr
+	}
+
+	out := &backupWriter{w: bufio.NewWriter(w), dest: w}
+	header, _ := json.Marshal(backupHeader{
+		Schema:     BACKUP_SCHEMA,
+		Version:    BACKUP_SCHEMA_VERSION,
+		ExportedAt: c.now().UTC(),
+	})
+	// The header object is left open, for the services and users to follow
+	out.write(string(header[:len(header)-1]) + `,"services":[`)
+	for i := range services {
+		out.item(i, services[i])
+	}
+
+	out.write(`],"users":[`)
+	for i := range users {
+		user, casErr := c.Db.FindUserByEmail(users[i].Email)
+		if casErr != nil {
+			out.err = casErr
+			break
+		}
+		out.item(i, newBackupUser(user))
+	}
+	out.write("]}\n")
+
+	if out.err == nil {
+		out.flush()
+	}
+	if out.err != nil {
+		c.Logger.Error("Failed to write backup", "error", out.err)
+		casErr := &FailedToExportBackupError
+		casErr.err = &out.err
+		return casErr
+	}
+	return nil
+}
+
+// Number of items written between flushes
+const backupFlushInterval = 100
+
+// Writes a backup document, remembering the first error
+type backupWriter struct {
+	w     *bufio.Writer
+	dest  io.Writer
+	items int
+	err   error
+}
+
+func (b *backupWriter) write(s string) {
+	if b.err == nil {
+		_, b.err = b.w.WriteString(s)
+	}
+}
+
+func (b *backupWriter) item(i int, v interface{}) {
+	if b.err != nil {
+		return
+	}
+	if i > 0 {
+		b.write(",")
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		b.err = err
+		return
+	}
+	b.write(string(encoded))
+
+	if b.items++; b.items%backupFlushInterval == 0 {
+		b.flush()
+	}
+}
+
+func (b *backupWriter) flush() {
+	if b.err == nil {
+		b.err = b.w.Flush()
+	}
+	if flusher, ok := b.dest.(http.Flusher); ok && b.err == nil {
+		flusher.Flush()
+	}
+}
+
+// Read a backup document, checking that it can be restored by this server
+func ReadBackup(r io.Reader) (*Backup, *CASServerError) {
+	var backup Backup
+	if err := json.NewDecoder(r).Decode(&backup); err != nil {
+		casErr := &FailedToParseJSONError
+		casErr.err = &err
+		return nil, casErr
+	}
+	if backup.Schema != BACKUP_SCHEMA || backup.Version < 1 || backup.Version > BACKUP_SCHEMA_VERSION {
+		return nil, &UnsupportedBackupError
+	}
+	return &backup, nil
+}
+
+// Restore the services and users in a backup, as ImportServices does (all or nothing, unless opts.Partial is set)
+// Services are written before users
+// Existing users keep their two-factor authentication secrets when they are overwritten
+func (c *CAS) RestoreBackup(backup *Backup, opts ImportOptions) (*BackupRestoreReport, *CASServerError) {
+	c.importMu.Lock()
+	defer c.importMu.Unlock()
+
+	existingServices, casErr := c.Db.GetAllServices()
+	if casErr != nil {
+		return nil, casErr
+	}
+	existingUsers, casErr := c.Db.GetAllUsers()
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	report := &BackupRestoreReport{
+		Services: newImportReport(len(backup.Services)),
+		Users:    newImportReport(len(backup.Users)),
+	}
+	steps := c.serviceImportSteps(backup.Services, existingServices, opts.Overwrite, report.Services)
+	userSteps, casErr := c.userRestoreSteps(backup.Users, existingUsers, opts.Overwrite, report.Users)
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	casErr = c.runImport(append(steps, userSteps...), opts.Partial, &InvalidBackupRestoreError, &FailedToRestoreBackupError)
+	report.Services.count()
+	report.Users.count()
+	return report, casErr
+}
+
+// Check each user to be restored against the existing users and the rest of the backup
+// Results for the users are recorded in report
+func (c *CAS) userRestoreSteps(users []BackupUser, existing []User, overwrite bool, report *ImportReport) ([]importStep, *CASServerError) {
+	exists := map[string]bool{}
+	for _, user := range existing {
+		exists[user.Email] = true
+	}
+
+	steps := make([]importStep, len(users))
+	seen := map[string]bool{}
+	for i := range users {
+		user := users[i].user()
+		report.Results[i] = ImportResult{Email: user.Email}
+
+		// Existing users are looked up in full, as they are listed without their passwords
+		var previous *User
+		if exists[user.Email] {
+			found, casErr := c.Db.FindUserByEmail(user.Email)
+			if casErr != nil {
+				return nil, casErr
+			}
+			previous = found
+			user.TOTP = previous.TOTP
+		}
+
+		step := c.userRestoreStep(user, previous)
+		step.result = &report.Results[i]
+		switch {
+		case !user.IsValid() || strings.TrimSpace(user.Email) != user.Email:
+			step.casErr = &InvalidUserError
+		case seen[user.Email]:
+			step.casErr = &DuplicateRestoredUserError
+		case previous != nil && !overwrite:
+			step.casErr = &EmailAlreadyTakenError
+		}
+
+		seen[user.Email] = true
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+func (c *CAS) userRestoreStep(user User, previous *User) importStep {
+	return importStep{
+		write: func() (string, *CASServerError) {
+			if previous == nil {
+				if _, casErr := c.Db.AddNewUser(user.Email, user.Password); casErr != nil {
+					return IMPORT_CREATED, casErr
+				}
+				// Users are created with only their email and password, the rest is filled in afterwards
+				if casErr := c.Db.UpdateUser(&user); casErr != nil {
+					c.Db.RemoveUserByEmail(user.Email)
+					return IMPORT_CREATED, casErr
+				}
+				return IMPORT_CREATED, nil
+			}
+			if reflect.DeepEqual(*previous, user) {
+				return IMPORT_UNCHANGED, nil
+			}
+			return IMPORT_UPDATED, c.Db.UpdateUser(&user)
+		},
+		revert: func() *CASServerError {
+			if previous == nil {
+				return c.Db.RemoveUserByEmail(user.Email)
+			}
+			if reflect.DeepEqual(*previous, user) {
+				return nil
+			}
+			return c.Db.UpdateUser(previous)
+		},
+	}
+}
@@ -0,0 +1,12 @@
+package backup_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoBackup(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Backup Suite")
+}
@@ -0,0 +1,211 @@
+package backup_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"time"
+)
+
+type restoreResponse struct {
+	Status  string              `json:"status"`
+	Message string              `json:"message"`
+	Data    BackupRestoreReport `json:"data"`
+}
+
+var _ = Describe("Backups", func() {
+	var (
+		source *CAS
+		target *CAS
+	)
+
+	exportedAt := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	// Create the (empty) server backups are restored to, with only the API keys fixture loaded
+	newTarget := func() *CAS {
+		server, err := NewCASServerWithLogger(castest.NewTestConfig(nil), NoopLogger{})
+		Expect(err).To(BeNil())
+		Expect(server.Db.(*MemoryBackend).LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+		return server
+	}
+
+	request := func(server *CAS, method, path, apiKey string, body []byte) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set("X-Api-Secret", "badsecret")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	export := func(server *CAS) []byte {
+		w := request(server, "GET", "/api/export", "adminapikey", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("application/json"))
+		return w.Body.Bytes()
+	}
+
+	restore := func(server *CAS, query string, backup []byte) (*httptest.ResponseRecorder, restoreResponse) {
+		w := request(server, "POST", "/api/import"+query, "adminapikey", backup)
+		var resp restoreResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return w, resp
+	}
+
+	allServices := func(server *CAS) []CASService {
+		services, casErr := server.Db.GetAllServices()
+		Expect(casErr).To(BeNil())
+		sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+		return services
+	}
+
+	findUser := func(server *CAS, email string) *User {
+		user, casErr := server.Db.FindUserByEmail(email)
+		Expect(casErr).To(BeNil())
+		return user
+	}
+
+	BeforeEach(func() {
+		var db *MemoryBackend
+		source, db = castest.NewTestServer(nil)
+
+		user := findUser(source, "test@test.com")
+		user.Name = "Test User"
+		user.Attributes = map[string]string{"department": "engineering"}
+		user.TOTP = &UserTOTP{Secret: "JBSWY3DPEHPK3PXP"}
+		Expect(db.UpdateUser(user)).To(BeNil())
+
+		target = newTarget()
+		for _, server := range []*CAS{source, target} {
+			server.Clock = ClockFunc(func() time.Time { return exportedAt })
+		}
+	})
+
+	AfterEach(func() {
+		castest.Close(source)
+		castest.Close(target)
+	})
+
+	It("Should only be available to admins", func() {
+		Expect(request(source, "GET", "/api/export", "userapikey", nil).Code).To(Equal(InsufficientPermissionsError.HttpCode))
+		Expect(request(target, "POST", "/api/import", "userapikey", export(source)).Code).To(Equal(InsufficientPermissionsError.HttpCode))
+	})
+
+	It("Should export a versioned document with password hashes but no two-factor secrets", func() {
+		backup := export(source)
+
+		var doc Backup
+		Expect(json.Unmarshal(backup, &doc)).To(Succeed())
+		Expect(doc.Schema).To(Equal(BACKUP_SCHEMA))
+		Expect(doc.Version).To(Equal(BACKUP_SCHEMA_VERSION))
+		Expect(doc.ExportedAt.Equal(exportedAt)).To(BeTrue())
+		Expect(doc.Services).To(HaveLen(len(allServices(source))))
+		Expect(doc.Users).To(HaveLen(2))
+
+		for _, user := range doc.Users {
+			Expect(user.PasswordHash).To(HavePrefix("$2a$"))
+		}
+		Expect(string(backup)).NotTo(ContainSubstring("JBSWY3DPEHPK3PXP"))
+		Expect(string(backup)).To(HavePrefix(`{"schema":"casgo-backup","version":1,`))
+	})
+
+	It("Should restore an exported backup", func() {
+		w, resp := restore(target, "", export(source))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(resp.Status).To(Equal("success"))
+		Expect(resp.Data.Services.Created).To(Equal(len(allServices(source))))
+		Expect(resp.Data.Users.Created).To(Equal(2))
+
+		Expect(allServices(target)).To(Equal(allServices(source)))
+		for _, email := range []string{"test@test.com", "admin@test.com"} {
+			restored := findUser(target, email)
+			original := findUser(source, email)
+			original.TOTP = nil
+			Expect(restored).To(Equal(original))
+		}
+
+		// Restoring the same backup again changes nothing
+		w, resp = restore(target, "?overwrite=true", export(source))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(resp.Data.Services.Unchanged).To(Equal(len(allServices(source))))
+		Expect(resp.Data.Users.Unchanged).To(Equal(2))
+	})
+
+	It("Should stream large exports", func() {
+		db := source.Db.(*MemoryBackend)
+		for i := 0; i < 250; i++ {
+			Expect(db.AddNewService(&CASService{
+				Name:       fmt.Sprintf("service_%d", i),
+				Url:        fmt.Sprintf("localhost:%d", 4000+i),
+				AdminEmail: "admin@test.com",
+			})).To(BeNil())
+		}
+
+		w := request(source, "GET", "/api/export", "adminapikey", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Flushed).To(BeTrue())
+
+		var doc Backup
+		Expect(json.Unmarshal(w.Body.Bytes(), &doc)).To(Succeed())
+		Expect(len(doc.Services)).To(BeNumerically(">", 250))
+	})
+
+	It("Should reject backups it does not understand", func() {
+		for _, backup := range []string{
+			`{"schema":"casgo-backup","version":2,"services":[],"users":[]}`,
+			`{"schema":"casgo-backup","services":[],"users":[]}`,
+			`{"schema":"other","version":1}`,
+		} {
+			w, _ := restore(target, "", []byte(backup))
+			Expect(w.Code).To(Equal(UnsupportedBackupError.HttpCode))
+		}
+
+		w, _ := restore(target, "", []byte(`{"schema":`))
+		Expect(w.Code).To(Equal(FailedToParseJSONError.HttpCode))
+	})
+
+	It("Should restore nothing if any user cannot be restored", func() {
+		backup := strings.Replace(string(export(source)), `"email":"test@test.com"`, `"email":""`, 1)
+		w, resp := restore(target, "", []byte(backup))
+		Expect(w.Code).To(Equal(InvalidBackupRestoreError.HttpCode))
+		Expect(resp.Data.Users.Failed).To(Equal(1))
+		Expect(resp.Data.Services.Created).To(Equal(0))
+		Expect(allServices(target)).To(BeEmpty())
+	})
+
+	It("Should only overwrite existing users when asked to, keeping their two-factor secrets", func() {
+		backup := export(source)
+		w, resp := restore(source, "", backup)
+		Expect(w.Code).To(Equal(InvalidBackupRestoreError.HttpCode))
+		Expect(resp.Data.Users.Results[0].Message).To(Equal(EmailAlreadyTakenError.Msg))
+
+		user := findUser(source, "test@test.com")
+		user.Name = "Renamed"
+		Expect(source.Db.UpdateUser(user)).To(BeNil())
+
+		w, resp = restore(source, "?overwrite=true", backup)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(resp.Data.Users.Updated).To(Equal(1))
+
+		restored := findUser(source, "test@test.com")
+		Expect(restored.Name).To(Equal("Test User"))
+		Expect(restored.TOTPEnabled()).To(BeTrue())
+	})
+
+	It("Should restore valid items in partial mode", func() {
+		backup := strings.Replace(string(export(source)), `"email":"test@test.com"`, `"email":""`, 1)
+		w, resp := restore(target, "?partial=true", []byte(backup))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(resp.Data.Users.Created).To(Equal(1))
+		Expect(resp.Data.Users.Failed).To(Equal(1))
+		Expect(allServices(target)).To(Equal(allServices(source)))
+	})
+})
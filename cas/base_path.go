@@ -0,0 +1,66 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+ * Mounting casgo under a base path (ex. https://sso.example.com/cas/, behind a reverse proxy)
+ */
+
+// Parse a base path (basePath), normalized to start with / and not end with one ("" when casgo is mounted at the root)
+func ParseBasePath(value string) (string, error) {
+	basePath := strings.TrimRight(strings.TrimSpace(value), "/")
+	if len(basePath) == 0 {
+		return "", nil
+	}
+	parsed, err := url.Parse(basePath)
+	if err != nil || !strings.HasPrefix(basePath, "/") || strings.HasPrefix(basePath, "//") || parsed.Path != basePath {
+		return "", fmt.Errorf("Invalid basePath [%s], expected a path starting with / (ex. /cas)", value)
+	}
+	return basePath, nil
+}
+
+// Path of the cookies set by casgo (the base path, so they aren't sent to other applications on the host)
+func cookiePath(basePath string) string {
+	if len(basePath) == 0 {
+		return "/"
+	}
+	return basePath
+}
+
+// Path (or URL) of one of casgo's endpoints as seen by browsers and clients, under the base path (ex. /cas/login)
+// Templates build links with the url function (ex. {{url "/login"}})
+func (c *CAS) URLPath(path string) string {
+	return c.BasePath + path
+}
+
+// Serve requests under the base path with the base path stripped, so routes are matched (and handlers see paths)
+// as if casgo was mounted at the root; requests outside of it are not found
+func (c *CAS) withBasePath(h http.Handler) http.Handler {
+	if len(c.BasePath) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := req.URL.Path
+		if path == c.BasePath {
+			http.Redirect(w, req, c.BasePath+"/", http.StatusMovedPermanently)
+			return
+		}
+		if !strings.HasPrefix(path, c.BasePath+"/") {
+			http.NotFound(w, req)
+			return
+		}
+
+		stripped := new(http.Request)
+		*stripped = *req
+		stripped.URL = new(url.URL)
+		*stripped.URL = *req.URL
+		stripped.URL.Path = strings.TrimPrefix(path, c.BasePath)
+		stripped.URL.RawPath = strings.TrimPrefix(req.URL.RawPath, c.BasePath)
+		h.ServeHTTP(w, stripped)
+	})
+}
@@ -0,0 +1,12 @@
+package basepath_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoBasePath(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Base Path Suite")
+}
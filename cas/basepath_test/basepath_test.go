@@ -0,0 +1,138 @@
+package basepath_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/url"
+)
+
+const serviceUrl = "https://app.example.com/"
+
+var _ = Describe("Base path", func() {
+	var (
+		server *CAS
+		client *castest.Client
+	)
+
+	newServer := func(overrides map[string]string) {
+		config := map[string]string{"basePath": "/cas/"}
+		for key, value := range overrides {
+			config[key] = value
+		}
+
+		var db *MemoryBackend
+		server, db = castest.NewTestServer(config)
+		client = castest.NewClient(server)
+		Expect(db.AddNewService(&CASService{Name: "app", Url: serviceUrl, AdminEmail: "admin@test.com"})).To(BeNil())
+	}
+
+	AfterEach(func() {
+		if server != nil {
+			castest.Close(server)
+			server = nil
+		}
+	})
+
+	login := func() {
+		Expect(client.Login(nil).Code).To(Equal(http.StatusOK))
+	}
+
+	It("Should normalize base paths", func() {
+		for value, expected := range map[string]string{"": "", "/": "", "/cas": "/cas", " /cas/ ": "/cas", "/sso/cas/": "/sso/cas"} {
+			basePath, err := ParseBasePath(value)
+			Expect(err).To(BeNil())
+			Expect(basePath).To(Equal(expected))
+		}
+		for _, value := range []string{"cas", "//cas.example.com", "https://cas.example.com/cas", "/cas?x=1", "/cas#login"} {
+			_, err := ParseBasePath(value)
+			Expect(err).To(HaveOccurred(), value)
+		}
+	})
+
+	It("Should only match routes under the base path", func() {
+		newServer(nil)
+		Expect(server.BasePath).To(Equal("/cas"))
+		Expect(server.URLPath("/login")).To(Equal("/cas/login"))
+
+		Expect(client.Get("/cas/login").Code).To(Equal(http.StatusOK))
+		Expect(client.Get("/cas/healthz").Code).To(Equal(http.StatusOK))
+		Expect(client.Get("/cas/public/js/casgo.js").Code).To(Equal(http.StatusOK))
+		Expect(client.Get("/cas/validate?service=x&ticket=y").Code).To(Equal(http.StatusOK))
+
+		Expect(client.Get("/login").Code).To(Equal(http.StatusNotFound))
+		Expect(client.Get("/public/js/casgo.js").Code).To(Equal(http.StatusNotFound))
+		Expect(client.Get("/cassette/login").Code).To(Equal(http.StatusNotFound))
+
+		w := client.Get("/cas")
+		Expect(w.Code).To(Equal(http.StatusMovedPermanently))
+		Expect(w.Header().Get("Location")).To(Equal("/cas/"))
+	})
+
+	It("Should link to pages and assets under the base path", func() {
+		newServer(nil)
+		page := client.Get("/cas/login").Body.String()
+		Expect(page).To(ContainSubstring(`action="/cas/login"`))
+		Expect(page).To(ContainSubstring(`href="/cas/register"`))
+		Expect(page).To(ContainSubstring(`href="/cas/public/style/css/casgo.css"`))
+		Expect(page).To(ContainSubstring(`<meta name="base-path" content="/cas">`))
+		Expect(page).ToNot(ContainSubstring(`"../public/`))
+
+		login()
+		index := client.Get("/cas/").Body.String()
+		Expect(index).To(ContainSubstring(`href="/cas/logout"`))
+		Expect(index).To(ContainSubstring(`src="/cas/public/js/casgo.js"`))
+	})
+
+	It("Should scope cookies to the base path", func() {
+		newServer(nil)
+		login()
+		Expect(client.Cookies).To(HaveKey("casgo-session"))
+		Expect(client.Cookies["casgo-session"].Path).To(Equal("/cas"))
+
+		client.Get("/cas/login?lang=fr")
+		Expect(client.Cookies[LOCALE_COOKIE_NAME].Path).To(Equal("/cas"))
+	})
+
+	It("Should redirect to services with tickets, and to the login page under the base path", func() {
+		newServer(nil)
+		login()
+		w := client.Get("/cas/login?service=" + url.QueryEscape(serviceUrl))
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(HavePrefix(serviceUrl + "?ticket="))
+
+		client.Cookies = map[string]*http.Cookie{}
+		w = client.Get("/cas/logout")
+		Expect(w.Header().Get("Location")).To(Equal("/cas/login"))
+	})
+
+	It("Should serve OpenID Connect discovery with endpoints under the base path", func() {
+		newServer(map[string]string{"oidcEnabled": "true", "host": "sso.example.com", "port": "443"})
+		w := client.Get("/cas" + OIDC_DISCOVERY_PATH)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var document map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &document)).To(Succeed())
+		Expect(document["issuer"]).To(Equal("https://sso.example.com:443/cas"))
+		Expect(document["authorization_endpoint"]).To(Equal("https://sso.example.com:443/cas" + OIDC_AUTHORIZE_PATH))
+
+		query := url.Values{"response_type": {"code"}, "client_id": {"app"}, "redirect_uri": {serviceUrl}, "scope": {"openid"}}
+		w = client.Get("/cas" + OIDC_AUTHORIZE_PATH + "?" + query.Encode())
+		Expect(w.Code).To(Equal(http.StatusFound))
+		location, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).To(BeNil())
+		Expect(location.Path).To(Equal("/cas/login"))
+		Expect(location.Query().Get("service")).To(HavePrefix("https://sso.example.com:443/cas" + OIDC_AUTHORIZE_PATH + "?"))
+	})
+
+	It("Should refuse invalid base paths at startup", func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["basePath"] = "cas"
+		_, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(MatchError(ContainSubstring("basePath [cas] must be a path starting with /")))
+	})
+})
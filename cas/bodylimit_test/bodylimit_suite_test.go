@@ -0,0 +1,12 @@
+package bodylimit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoBodyLimit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Body Limit Suite")
+}
@@ -0,0 +1,86 @@
+package bodylimit_test
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("API request body limits", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	post := func(body io.Reader) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/services", body)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "adminapikey")
+		req.Header.Set("X-Api-Secret", "badsecret")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	// A valid service, padded with an unknown field to the given size
+	serviceOfSize := func(size int) string {
+		service := `{"name": "big_service", "url": "localhost:5000/validateCASLogin", "adminEmail": "admin@test.com", "padding": "%s"}`
+		return fmt.Sprintf(service, strings.Repeat("x", size-len(service)+2))
+	}
+
+	expectTooLarge := func(w *httptest.ResponseRecorder) {
+		Expect(w.Code).To(Equal(http.StatusRequestEntityTooLarge))
+		var body map[string]string
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		Expect(body["status"]).To(Equal("error"))
+		Expect(body["code"]).To(Equal(RequestBodyTooLargeError.Code))
+
+		_, casErr := db.FindServiceByUrl("localhost:5000/validateCASLogin")
+		Expect(casErr).NotTo(BeNil())
+	}
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should refuse bodies over 1MB by default", func() {
+		server, db = castest.NewTestServer(nil)
+		expectTooLarge(post(strings.NewReader(serviceOfSize(1<<20 + 1))))
+	})
+
+	It("Should accept bodies within the limit", func() {
+		server, db = castest.NewTestServer(map[string]string{"apiMaxBodySize": "1024"})
+		body := serviceOfSize(1024)
+		Expect(body).To(HaveLen(1024))
+
+		w := post(strings.NewReader(body))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		_, casErr := db.FindServiceByUrl("localhost:5000/validateCASLogin")
+		Expect(casErr).To(BeNil())
+	})
+
+	It("Should refuse bodies over the configured limit", func() {
+		server, db = castest.NewTestServer(map[string]string{"apiMaxBodySize": "1024"})
+		expectTooLarge(post(strings.NewReader(serviceOfSize(1025))))
+	})
+
+	It("Should cut off bodies sent without a length at the limit", func() {
+		server, db = castest.NewTestServer(map[string]string{"apiMaxBodySize": "1024"})
+
+		// Readers other than strings, bytes & bytes.Buffer readers leave the request's ContentLength unknown
+		w := post(io.MultiReader(strings.NewReader(serviceOfSize(4096))))
+		expectTooLarge(w)
+	})
+
+	It("Should not limit bodies when the limit is 0", func() {
+		server, db = castest.NewTestServer(map[string]string{"apiMaxBodySize": "0"})
+		Expect(post(strings.NewReader(serviceOfSize(2 << 20))).Code).To(Equal(http.StatusOK))
+	})
+})
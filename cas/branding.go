@@ -0,0 +1,88 @@
+package cas
+
+import (
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/unrolled/render"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+/*
+ * Branding of the HTML pages (login, logout & error pages), without modifying the templates
+ */
+
+// Primary colors must be hex colors (ex. "#336699" or "#369")
+var brandingColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// Values templates are rendered with, available to every page as .Branding
+type Branding struct {
+	AppName      string // Name shown in page titles
+	LogoURL      string // Image shown on the login page (none if empty)
+	PrimaryColor string // Background color of the page theme (the default theme if empty)
+	CustomCSSURL string // Stylesheet included after the default ones (none if empty)
+}
+
+// Create the branding specified by server configuration
+func NewBrandingFromConfig(config map[string]string) (*Branding, error) {
+	branding := &Branding{
+		AppName:      configValueOrDefault(config, "brandingAppName"),
+		LogoURL:      strings.TrimSpace(config["brandingLogoUrl"]),
+		PrimaryColor: strings.TrimSpace(config["brandingPrimaryColor"]),
+		CustomCSSURL: strings.TrimSpace(config["brandingCustomCssUrl"]),
+	}
+
+	if err := validateBrandingUrl("brandingLogoUrl", branding.LogoURL); err != nil {
+		return nil, err
+	}
+	if err := validateBrandingUrl("brandingCustomCssUrl", branding.CustomCSSURL); err != nil {
+		return nil, err
+	}
+	if len(branding.PrimaryColor) > 0 && !brandingColorPattern.MatchString(branding.PrimaryColor) {
+		return nil, fmt.Errorf("Invalid brandingPrimaryColor [%s], expected a hex color (ex. #336699)", branding.PrimaryColor)
+	}
+	return branding, nil
+}
+
+// Branding URLs must be absolute http(s) URLs or paths on the server (ex. "/public/logo.png")
+func validateBrandingUrl(key, value string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	parsed, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("Invalid %s [%s], %v", key, value, err)
+	}
+
+	isAbsolute := (parsed.Scheme == "http" || parsed.Scheme == "https") && len(parsed.Host) > 0
+	isPath := len(parsed.Scheme) == 0 && len(parsed.Host) == 0 && strings.HasPrefix(value, "/") && !strings.HasPrefix(value, "//")
+	if !isAbsolute && !isPath {
+		return fmt.Errorf("Invalid %s [%s], expected an http(s) URL or a path starting with /", key, value)
+	}
+	return nil
+}
+
+// Base context for rendering HTML pages, in the locale of the request
+// Pages rendered for a request carry the browser's CSRF token (see csrfField)
+func (c *CAS) templateContext(w http.ResponseWriter, req *http.Request) map[string]interface{} {
+	context := c.localeTemplateContext(c.requestLocale(w, req))
+	context["CSRFToken"] = c.csrfToken(w, req)
+	return context
+}
+
+// Base context for rendering HTML pages in a locale
+func (c *CAS) localeTemplateContext(locale string) map[string]interface{} {
+	return map[string]interface{}{
+		"CompanyName": c.Config["companyName"],
+		"Branding":    c.Branding,
+		"Locale":      locale,
+	}
+}
+
+// Binding for the page rendered when rendering another page fails
+func (c *CAS) errorTemplateContext(data render.ErrorData) interface{} {
+	context := c.localeTemplateContext(c.Translator.DefaultLocale)
+	context["Status"] = data.Status
+	return context
+}
@@ -0,0 +1,12 @@
+package branding_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoBranding(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Branding Suite")
+}
@@ -0,0 +1,67 @@
+package branding_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Branding", func() {
+	var server *CAS
+
+	AfterEach(func() {
+		if server != nil {
+			castest.Close(server)
+			server = nil
+		}
+	})
+
+	It("Should render the login and logout pages with the configured branding", func() {
+		server, _ = castest.NewTestServer(map[string]string{
+			"brandingAppName":      "Acme Sign In",
+			"brandingLogoUrl":      "https://cdn.acme.test/logo.png",
+			"brandingPrimaryColor": "#336699",
+			"brandingCustomCssUrl": "/public/style/css/acme.css",
+		})
+		client := castest.NewClient(server)
+		loginPage := client.Get("/login")
+		Expect(client.Login(nil).Code).To(Equal(http.StatusOK))
+
+		for _, w := range []*httptest.ResponseRecorder{loginPage, client.Get("/logout")} {
+			Expect(w.Code).To(Equal(http.StatusOK))
+			body := w.Body.String()
+			Expect(body).To(ContainSubstring("<title>Acme Sign In</title>"))
+			Expect(body).To(ContainSubstring(`<img class="brand-logo" src="https://cdn.acme.test/logo.png" alt="Acme Sign In"/>`))
+			Expect(body).To(ContainSubstring(".theme-background { background: #336699; }"))
+			Expect(body).To(ContainSubstring(`<link rel="stylesheet" href="/public/style/css/acme.css"/>`))
+		}
+	})
+
+	It("Should render the default branding when none is configured", func() {
+		server, _ = castest.NewTestServer(nil)
+		Expect(server.Branding.AppName).To(Equal("CasGo"))
+
+		body := castest.NewClient(server).Get("/login").Body.String()
+		Expect(body).To(ContainSubstring("<title>CasGo</title>"))
+		Expect(body).NotTo(ContainSubstring("brand-logo"))
+		Expect(body).NotTo(ContainSubstring("<style>"))
+	})
+
+	It("Should refuse invalid logo and stylesheet URLs and colors at startup", func() {
+		for key, value := range map[string]string{
+			"brandingLogoUrl":      "javascript:alert(1)",
+			"brandingCustomCssUrl": "//evil.test/style.css",
+			"brandingPrimaryColor": "red; background: url(https://evil.test)",
+		} {
+			_, err := NewCASServerWithLogger(castest.NewTestConfig(map[string]string{key: value}), NoopLogger{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(key))
+		}
+
+		_, err := NewCASServerWithLogger(castest.NewTestConfig(map[string]string{"brandingLogoUrl": "logo.png"}), NoopLogger{})
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,16 +1,22 @@
 package cas
 
 import (
-	"encoding/gob"
+	"context"
+	"fmt"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/GeertJohan/go.rice"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/mux"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/unrolled/render"
-	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/golang.org/x/crypto/bcrypt"
+	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 /*
@@ -18,13 +24,85 @@ import (
  */
 
 func NewCASServer(config map[string]string) (*CAS, error) {
+	return NewCASServerWithLogger(config, nil)
+}
+
+// Create a CAS server that logs with the given logger (if nil, a logger is created from configuration)
+func NewCASServerWithLogger(config map[string]string, logger Logger) (*CAS, error) {
+	return NewCASServerWithOptions(config, CASServerOptions{Logger: logger})
+}
+
+type CASServerOptions struct {
+	Logger          Logger          // Logger to log with (a logger is created from configuration if nil)
+	TicketGenerator TicketGenerator // Generates ticket IDs (the configured ticketGenerator is used if nil)
+}
+
+// Create a CAS server, using the given options in place of those created from configuration
+func NewCASServerWithOptions(config map[string]string, options CASServerOptions) (*CAS, error) {
+	if err := ValidateConfig(config); err != nil {
+		return nil, err
+	}
+
+	logger := options.Logger
+	if logger == nil {
+		configLogger, err := NewLoggerFromConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		logger = configLogger
+	}
+
 	// Create and initialize the CAS server
 	cas := &CAS{
-		Config:      config,
-		render:      nil,
-		cookieStore: nil,
-		ServeMux:    nil,
+		Logger:       logger,
+		Config:       config,
+		render:       nil,
+		sessionStore: nil,
+		ServeMux:     nil,
+		ProxyCallbackClient: &http.Client{
+			Timeout: PROXY_CALLBACK_TIMEOUT,
+		},
+		Metrics: NewCASMetrics(),
+		Clock:   RealClock,
+	}
+	cas.SingleLogoutClient = &http.Client{
+		Timeout: configSecondsAsDuration(config, "sloTimeout"),
+	}
+
+	// Base path casgo is mounted under (prefixing routes, and the links and redirects to them)
+	basePath, err := ParseBasePath(config["basePath"])
+	if err != nil {
+		return nil, err
+	}
+	cas.BasePath = basePath
+
+	// Branding setup (checked before templates are rendered with it)
+	branding, err := NewBrandingFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	cas.Branding = branding
+
+	// Security headers setup (the default content security policy allows the branding's assets)
+	securityHeaders, err := NewSecurityHeadersFromConfig(config, branding)
+	if err != nil {
+		return nil, err
+	}
+	cas.SecurityHeaders = securityHeaders
+
+	// Message catalog setup (templates are translated with the t function, ex. {{t .Locale "login.title"}})
+	translator, err := NewTranslatorFromConfig(config)
+	if err != nil {
+		return nil, err
 	}
+	cas.Translator = translator
+
+	// Static assets setup (served from the public directory embedded with go.rice, unless staticDirectory is set)
+	static, err := NewStaticHandlerFromConfig(config, rice.MustFindBox("../public").HTTPBox())
+	if err != nil {
+		return nil, err
+	}
+	cas.Static = static
 
 	// Setup go.rice box
 	box, err := rice.FindBox("../templates")
@@ -38,12 +116,14 @@ func NewCASServer(config map[string]string) (*CAS, error) {
 
 	// Setup rendering function
 	// Asset, AssetNames, and Extensions are specified to enable integration with go.rice
-	render := render.New(render.Options{
-		Layout:    "layout",
-		Directory: boxPrefix,
+	render, err := render.NewWithErr(render.Options{
+		Layout:       "layout",
+		ErrorHTML:    "error",
+		ErrorBinding: cas.errorTemplateContext,
+		Funcs:        []template.FuncMap{{"t": translator.Translate, "csrfField": csrfField, "url": cas.URLPath, "asset": cas.AssetURLPath}},
+		Directory:    boxPrefix,
 		Asset: func(name string) ([]byte, error) {
-			name = strings.TrimPrefix(name, boxPrefix)
-			return box.MustBytes(name), nil
+			return box.Bytes(strings.TrimPrefix(name, boxPrefix))
 		},
 		AssetNames: func() []string {
 			files, err := ListFilesInBox(box, boxPrefix+"/")
@@ -53,50 +133,200 @@ func NewCASServer(config map[string]string) (*CAS, error) {
 			return files
 		},
 	})
+	if err != nil {
+		return nil, err
+	}
 	cas.render = render
 
-	// Cookie store setup
-	cookieStore := sessions.NewCookieStore([]byte(cas.Config["cookieSecret"]))
-	cookieStore.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   86400 * 7,
-		HttpOnly: true,
+	// Ticket ID generation setup
+	ticketGenerator := options.TicketGenerator
+	if ticketGenerator == nil {
+		if ticketGenerator, err = NewTicketGeneratorFromConfig(cas.Config); err != nil {
+			return nil, err
+		}
+	}
+	cas.TicketGenerator = ticketGenerator
+
+	// Password hashing setup
+	passwordHasher, err := NewPasswordHasherFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.PasswordHasher = passwordHasher
+
+	// Password policy setup
+	passwordPolicy, err := NewPasswordPolicyFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.PasswordPolicy = passwordPolicy
+
+	// Login rate limiting setup
+	loginRateLimiter, err := NewLoginRateLimiterFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.LoginRateLimiter = loginRateLimiter
+
+	trustedProxies, err := ParseTrustedProxies(cas.Config["trustedProxies"])
+	if err != nil {
+		return nil, err
+	}
+	cas.TrustedProxies = trustedProxies
+	cas.ClientIPHeader, err = ParseClientIPHeader(configValueOrDefault(cas.Config, "clientIpHeader"))
+	if err != nil {
+		return nil, err
+	}
+	cas.TrustedHeaderAuth, err = NewTrustedHeaderAuthFromConfig(cas.Config, trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extra login form fields setup
+	cas.LoginFields, err = ParseLoginFields(cas.Config["loginExtraFields"])
+	if err != nil {
+		return nil, err
+	}
+
+	// CORS setup (for API endpoints)
+	corsPolicy, err := NewCORSPolicyFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.CORS = corsPolicy
+
+	// Shape of API responses
+	apiEnvelope, err := NewAPIEnvelopeFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.APIEnvelope = apiEnvelope
+
+	// Service URL restrictions (HTTPS-only services)
+	serviceUrlPolicy, err := NewServiceUrlPolicyFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.ServiceUrlPolicy = serviceUrlPolicy
+
+	// Service ticket quotas of ticket-granting tickets
+	serviceTicketQuota, err := NewServiceTicketQuotaFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.ServiceTicketQuota = serviceTicketQuota
+
+	// SAML assertion signing (when enabled)
+	samlSigner, err := NewSAMLSignerFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.SAMLSigner = samlSigner
+
+	// OpenID Connect bridge (when enabled)
+	oidc, err := NewOIDCProviderFromConfig(cas.Config, cas.GetAddr()+cas.BasePath, cas.Logger)
+	if err != nil {
+		return nil, err
+	}
+	cas.OIDC = oidc
+
+	// Delegated authentication to an upstream CAS server (when enabled)
+	delegation, err := NewCASDelegationFromConfig(cas.Config, cas.GetAddr()+cas.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	cas.Delegation = delegation
+
+	// Readiness probe setup
+	readiness, err := NewReadinessCheckerFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.Readiness = readiness
+
+	// Two-factor authentication setup
+	totpSkew, err := configInt(cas.Config, "totpSkew")
+	if err != nil {
+		return nil, err
+	}
+	if totpSkew < 0 {
+		return nil, fmt.Errorf("Invalid totpSkew [%d], must not be negative", totpSkew)
 	}
-	cas.cookieStore = cookieStore
 
-	// Register types for encoding/decoding
-	gob.Register([]CASService{})
-	gob.Register(User{})
+	// Session store setup
+	sessionOptions, err := NewSessionCookieOptions(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	sessionStore, err := NewSessionStoreFromConfig(cas.Config)
+	if err != nil {
+		return nil, err
+	}
+	cas.sessionOptions = sessionOptions
+	cas.sessionStore = sessionStore
 
 	cas.init()
-	cas.setLogLevel(cas.Config["logLevel"])
-	return cas, nil
-}
 
-func (c *CAS) setLogLevel(lvl string) {
-	switch lvl {
-	case "WARN":
-		c.LogLevel = WARN
-	case "DEBUG":
-		c.LogLevel = DEBUG
-	case "INFO":
-		c.LogLevel = INFO
-	default:
-		c.LogLevel = WARN
+	// Bring the storage backend's schema up to date
+	autoMigrate, err := configBool(cas.Config, "dbAutoMigrate")
+	if err != nil {
+		return nil, err
+	}
+	if autoMigrate {
+		if _, casErr := cas.Migrate(); casErr != nil {
+			return nil, casErr
+		}
 	}
+
+	return cas, nil
 }
 
 func (c *CAS) init() {
-	// Override config with ENV variables
-	c.Config = overrideConfigWithEnv(c.Config)
-
-	// Setup database adapter
-	db, err := NewRethinkDBAdapter(c)
+	// Setup storage backend
+	db, err := NewBackendFromConfig(c)
 	if err != nil {
-		log.Fatal("Failed to setup database adapter", err)
+		log.Fatal("Failed to setup storage backend", err)
 	}
 	c.Db = db
 
+	// Setup user authentication
+	authenticator, err := NewAuthenticatorFromConfig(c.Config, c.Db, c.Logger)
+	if err != nil {
+		log.Fatal("Failed to setup user authentication", err)
+	}
+	c.Authenticator = authenticator
+
+	// Setup removal of expired tickets
+	c.TicketSweeper = NewTicketSweeper(c.Db, configSecondsAsDuration(c.Config, "ticketSweepInterval"), c.Metrics, c.Logger)
+	c.TicketSweeper.SetClock(ClockFunc(c.now))
+
+	// Setup the audit trail of security-relevant events
+	auditLog, err := NewAuditLogFromConfig(c.Config, c.Db, c.Metrics, c.Logger)
+	if err != nil {
+		log.Fatal("Failed to setup audit log", err)
+	}
+	auditLog.SetClock(ClockFunc(c.now))
+	c.AuditLog = auditLog
+
+	// Setup webhook notifications
+	webhooks, err := NewWebhookNotifierFromConfig(c.Config, c.Metrics, c.Logger)
+	if err != nil {
+		log.Fatal("Failed to setup webhooks", err)
+	}
+	c.Webhooks = webhooks
+
+	// Setup health reporting of background workers
+	c.sloHealth = newWorkerHealth(WORKER_SINGLE_LOGOUT, 0, false, nil, 0)
+	c.Workers = NewWorkerRegistry(ClockFunc(c.now), configSecondsAsDuration(c.Config, "workerStallTimeout"))
+	c.Workers.Register(c.sloHealth)
+	c.Workers.Register(c.TicketSweeper.Health())
+	if health := c.AuditLog.Health(); health != nil {
+		c.Workers.Register(health)
+	}
+	if health := c.Webhooks.Health(); health != nil {
+		c.Workers.Register(health)
+	}
+
 	// Setup the internal HTTP Server
 	c.server = &http.Server{
 		Addr: c.GetAddr(),
@@ -104,15 +334,25 @@ func (c *CAS) init() {
 
 	// Setup front-end API
 	api, err := NewCasgoFrontendAPI(c)
+	if err != nil {
+		log.Fatal("Failed to setup API", err)
+	}
 	c.Api = api
 
 	// Setup handlers
 	serveMux := mux.NewRouter()
 
+	// Liveness & readiness probes (which require no authentication, and are not rate limited)
+	serveMux.HandleFunc(configValueOrDefault(c.Config, "healthPath"), c.HandleHealth).Methods("GET", "HEAD")
+	serveMux.HandleFunc(configValueOrDefault(c.Config, "readyPath"), c.HandleReady).Methods("GET", "HEAD")
+
 	// Front end endpoints
 	serveMux.HandleFunc("/login", c.HandleLogin)
 	serveMux.HandleFunc("/logout", c.HandleLogout)
 	serveMux.HandleFunc("/register", c.HandleRegister)
+	if c.Delegation != nil {
+		serveMux.HandleFunc(DELEGATION_CALLBACK_PATH, c.HandleDelegatedLogin).Methods("GET")
+	}
 
 	// Hook up API endpoints
 	c.Api.HookupAPIEndpoints(serveMux)
@@ -122,15 +362,34 @@ func (c *CAS) init() {
 	serveMux.HandleFunc("/serviceValidate", c.HandleServiceValidate)
 	serveMux.HandleFunc("/proxyValidate", c.HandleProxyValidate)
 	serveMux.HandleFunc("/proxy", c.HandleProxy)
+	serveMux.HandleFunc("/p3/serviceValidate", c.HandleServiceValidateV3)
+	serveMux.HandleFunc("/samlValidate", c.HandleSAMLValidate).Methods("POST")
+
+	// OpenID Connect endpoints (when enabled)
+	if c.OIDC != nil {
+		serveMux.HandleFunc(OIDC_DISCOVERY_PATH, c.HandleOIDCDiscovery).Methods("GET", "HEAD")
+		serveMux.HandleFunc(OIDC_JWKS_PATH, c.HandleOIDCJWKS).Methods("GET", "HEAD")
+		serveMux.HandleFunc(OIDC_AUTHORIZE_PATH, c.HandleOIDCAuthorize).Methods("GET", "POST")
+		serveMux.HandleFunc(OIDC_TOKEN_PATH, c.HandleOIDCToken).Methods("POST")
+	}
+
+	// Metrics endpoint (when enabled, and not served on a separate address)
+	if c.metricsEnabled() && len(c.Config["metricsAddr"]) == 0 {
+		serveMux.Handle("/metrics", c.metricsHandler())
+	}
 
-	// Static file serving
-	box := rice.MustFindBox("../public")
-	publicFileServer := http.StripPrefix("/public/", http.FileServer(box.HTTPBox()))
-	serveMux.PathPrefix("/public/").Handler(publicFileServer)
+	// Static assets
+	serveMux.PathPrefix(c.Static.Path).Handler(c.Static)
 	serveMux.HandleFunc("/", c.HandleIndex)
 
 	c.ServeMux = serveMux
-	c.server.Handler = c.ServeMux
+	c.server.Handler = c.withRequestId(c.withBasePath(c.withSecurityHeaders(c.withCSRFProtection(c.ServeMux))))
+}
+
+// Handler serving all casgo endpoints (the server mux under the base path, with request IDs assigned, security
+// headers and CSRF protection)
+func (c *CAS) Handler() http.Handler {
+	return c.server.Handler
 }
 
 // Set up the underlying database
@@ -143,11 +402,69 @@ func (c *CAS) TeardownDb() *CASServerError {
 	return c.Db.Teardown()
 }
 
-// Start the CAS server
+// Start the CAS server, running until it receives SIGINT or SIGTERM
+// On a signal, in-flight requests are given up to shutdownTimeout seconds to finish before the server stops
 func (c *CAS) Start() {
+	if err := c.setupTLS(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Start metrics server, if metrics are to be served on a separate address
+	if c.metricsEnabled() && len(c.Config["metricsAddr"]) > 0 {
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", c.metricsHandler())
+		c.metricsServer = &http.Server{Addr: c.Config["metricsAddr"], Handler: metricsMux}
+		go func() {
+			if err := c.metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	c.TicketSweeper.Start()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
 	// Start server
-	cert, key := c.Config["tlsCertFile"], c.Config["tlsKeyFile"]
-	log.Fatal(c.server.ListenAndServeTLS(cert, key))
+	serveErrs := make(chan error, 1)
+	go func() {
+		serveErrs <- c.server.ListenAndServeTLS("", "")
+	}()
+
+	select {
+	case err := <-serveErrs:
+		log.Fatal(err)
+	case sig := <-signals:
+		timeout := configSecondsAsDuration(c.Config, "shutdownTimeout")
+		c.Logger.Info("Shutting down", "signal", sig, "timeout", timeout)
+
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := c.Shutdown(ctx); err != nil {
+			c.Logger.Error("Shutdown did not complete cleanly", "error", err)
+			return
+		}
+		c.Logger.Info("Shutdown complete")
+	}
+}
+
+// Whether the metrics endpoint has been enabled
+func (c *CAS) metricsEnabled() bool {
+	enabled, err := configBool(c.Config, "metricsEnabled")
+	if err != nil {
+		c.Logger.Warn("Invalid configuration value, metrics endpoint disabled", "key", "metricsEnabled", "error", err)
+	}
+	return enabled
+}
+
+// Handler serving the metrics, with the state of background workers as of the request
+func (c *CAS) metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.Metrics.ObserveWorkers(c.Workers.Statuses())
+		c.Metrics.ServeHTTP(w, req)
+	})
 }
 
 // Get the address of the server based on server configuration
@@ -159,8 +476,8 @@ func (c *CAS) GetAddr() string {
 func (c *CAS) HandleIndex(w http.ResponseWriter, req *http.Request) {
 
 	// Attempt to retrieve user session and populate template context
-	session, _ := c.cookieStore.Get(req, "casgo-session")
-	templateContext := c.augmentTemplateContext(map[string]interface{}{}, session)
+	session, _ := c.sessionStore.Get(req, "casgo-session")
+	templateContext := c.augmentTemplateContext(c.backendFor(req), c.templateContext(w, req), session)
 
 	// Exit early (and show landing page) if not user not logged in (in session)
 	if _, ok := templateContext["currentUser"]; !ok {
@@ -173,14 +490,12 @@ func (c *CAS) HandleIndex(w http.ResponseWriter, req *http.Request) {
 
 // Augment information in given context with information from given session
 // Will overwrite any fields that are already filled
-func (c *CAS) augmentTemplateContext(context map[string]interface{}, session *sessions.Session) map[string]interface{} {
+func (c *CAS) augmentTemplateContext(db Backend, context map[string]interface{}, session *sessions.Session) map[string]interface{} {
 	context["CompanyName"] = c.Config["companyName"]
 
-	// Add information from session
-	if session != nil {
-		if currentUser, ok := session.Values["currentUser"]; ok {
-			context["currentUser"] = currentUser.(User)
-		}
+	// Add information from session (only if the session's ticket-granting ticket is still valid)
+	if currentUser, ok := c.sessionUser(db, session); ok {
+		context["currentUser"] = *currentUser
 	}
 
 	return context
@@ -188,8 +503,13 @@ func (c *CAS) augmentTemplateContext(context map[string]interface{}, session *se
 
 // Handle logins (functions as both a credential acceptor and requestor)
 func (c *CAS) HandleLogin(w http.ResponseWriter, req *http.Request) {
+	defer c.Metrics.ObserveRequest("/login", time.Now())
+
 	// Generate context
-	context := map[string]interface{}{"CompanyName": c.Config["companyName"]}
+	context := c.templateContext(w, req)
+	context["RememberMeEnabled"] = c.rememberMeEnabled()
+	context["WarnEnabled"] = c.serviceWarningEnabled()
+	context["Warn"] = warnRequested(req)
 
 	// Trim and lightly pre-process/validate service
 	serviceUrl := strings.TrimSpace(req.FormValue("service"))
@@ -199,7 +519,13 @@ func (c *CAS) HandleLogin(w http.ResponseWriter, req *http.Request) {
 
 	// In the case login is being used as an acceptor
 	email := strings.TrimSpace(strings.ToLower(req.FormValue("email")))
-	password := strings.TrimSpace(strings.ToLower(req.FormValue("password")))
+	password := strings.TrimSpace(req.FormValue("password"))
+	rememberMe := c.rememberMeRequested(req)
+	loginFields := c.loginFieldValues(req)
+	context["LoginFields"] = c.loginFieldsContext(context, loginFields)
+
+	// Second login step, for users with two-factor authentication enabled
+	totpCode := strings.TrimSpace(req.FormValue("totpCode"))
 
 	// Service URL will come in as form parameter if POST
 	if req.Method == "POST" {
@@ -208,13 +534,44 @@ func (c *CAS) HandleLogin(w http.ResponseWriter, req *http.Request) {
 
 	// Add serviceUrl to context if it was specified
 	context["serviceUrl"] = serviceUrl
+	context["Renew"] = renew == "true"
+	if c.Delegation != nil {
+		context["DelegationName"] = c.Delegation.Name
+		context["DelegatedLoginUrl"] = c.delegatedLoginPath(serviceUrl, renew == "true")
+	}
+	logger := c.requestLogger(req).With("username", email, "service", serviceUrl)
+
+	// Refuse credentials from clients that have recently failed to log in too many times
+	clientIP := c.clientIP(req)
+	if (len(email) > 0 || len(password) > 0 || len(totpCode) > 0) && !c.LoginRateLimiter.Allow(clientIP) {
+		c.Metrics.LoginAttempts.Inc("rate_limited")
+		logger.Warn("Login refused, too many failed attempts")
+		c.audit(req, NewAuditEvent(AUDIT_LOGIN, email, serviceUrl, &TooManyLoginAttemptsError))
+		context["Error"] = c.localizeError(context, &TooManyLoginAttemptsError)
+		c.render.HTML(w, TooManyLoginAttemptsError.HttpCode, "login", context)
+		return
+	}
 
 	// Handle service being not set early
 	var casService *CASService
 	if len(serviceUrl) > 0 {
-		foundService, err := c.Db.FindServiceByUrl(serviceUrl)
+		if casErr := c.checkServiceUrlAllowed(serviceUrl); casErr != nil {
+			logger.Warn("Login refused, insecure service URL")
+			context["Error"] = c.localizeError(context, casErr)
+			c.render.HTML(w, casErr.HttpCode, "login", context)
+			return
+		}
+
+		// Logins for the OIDC authorization endpoint return to it (it checks the client itself)
+		foundService := c.oidcLoginService(serviceUrl)
+		var err *CASServerError
+		if foundService == nil {
+			foundService, err = c.findServiceForTicket(c.backendFor(req), serviceUrl)
+		}
 		if err != nil {
-			context["Error"] = "Failed to find matching service with URL [" + serviceUrl + "]."
+			logger.Warn("Login refused, unregistered service URL")
+			context["Error"] = c.localize(context, "login.serviceNotFound", serviceUrl)
+			context["ServiceRefused"] = true
 			c.render.HTML(w, http.StatusNotFound, "login", context)
 			return
 		}
@@ -226,86 +583,112 @@ func (c *CAS) HandleLogin(w http.ResponseWriter, req *http.Request) {
 		context["Method"] = method
 	}
 
-	// Both gateway and  cannot be set -- Croak here? Maybe also take renew over gateway (as docs suggest?)
-	if gateway == "true" && renew == "true" {
-		context["Error"] = "Invalid Request: Both gateway and renew options specified"
-		c.render.HTML(w, http.StatusBadRequest, "login", context)
-		return
+	// Renew takes priority over gateway (as the CAS protocol specifies)
+	// If renew is set, automatic sign on is disabled, user must present credentials regardless of whether a sign on session exists
+	if renew == "true" {
+		gateway = ""
 	}
 
-	if renew == "true" {
+	// Single sign on: users with a (valid) session are issued a ticket for the service without being prompted
+	// Requests carrying credentials are handled as regular logins (ex. to log in as another user)
+	session, _ := c.sessionStore.Get(req, "casgo-session")
 
-		// If renew is set, automatic sign on is disabled, user must present credentials regardless of whether a sign on session exists
-		// Renew takes priority over gateway
-		c.render.HTML(w, http.StatusOK, "login", context)
-		return
+	// Users pre-authenticated by a trusted reverse proxy are logged in without being prompted
+	if len(email) == 0 && len(password) == 0 && len(totpCode) == 0 {
+		if c.loginWithTrustedHeader(w, req, context, session, casService, serviceUrl, clientIP, renew == "true", gateway == "true") {
+			return
+		}
+	}
 
-	} else if gateway == "true" {
+	if renew != "true" && len(email) == 0 && len(password) == 0 && len(totpCode) == 0 {
+		if sessionUser, ok := c.sessionUser(c.backendFor(req), session); ok {
+			if casService != nil {
+				c.makeNewTicketAndRedirect(w, req, context, sessionUser, casService, true, tgtIdFromSession(session))
+				return
+			}
+
+			// If service is not set and gateway is set, behavior is undefined, let user know they are logged in
+			if gateway == "true" {
+				context["Success"] = c.localize(context, "login.alreadyLoggedIn")
+				c.render.HTML(w, http.StatusOK, "login", context)
+				return
+			}
+		}
+	}
 
-		// If gateway is set, CAS will try to use previous session or authenticate with non-interactive means (ex. LDAP)
-		// If no CAS session and no non-interactive means, then redirect with no ticket parameter to service URL
+	// Logins asking for delegation are redirected to the upstream CAS server instead of being prompted
+	if c.Delegation != nil && delegationRequested(req) && len(email) == 0 && len(password) == 0 && len(totpCode) == 0 {
+		c.beginDelegatedLogin(w, req, context, session, serviceUrl, renew == "true", gateway == "true")
+		return
+	}
 
-		// Finish early if the user is already logged in (has session)
-		session, _ := c.cookieStore.Get(req, "casgo-session")
-		if _, ok := session.Values["currentUser"]; ok {
+	if gateway == "true" {
 
-			// If session is not set and gateway is set, behavior is undefined, act as if nothing was given, let user know they are logged in
-			// Otherwiser make new ticket and properly redirect to service
+		// If gateway is set, CAS will try to authenticate with non-interactive means (ex. credentials passed along to LDAP)
+		// If there are no non-interactive means, then redirect with no ticket parameter to service URL (the user is never prompted)
+		if email == "" || password == "" {
 			if casService == nil {
-				context["Success"] = "User already logged in..."
 				c.render.HTML(w, http.StatusOK, "login", context)
 			} else {
-				_, err := c.makeNewTicketAndRedirect(w, req, casService)
-				if err != nil {
-					http.Error(w, "Failed to create new authentication ticket. Please contact administrator if problem persists.", 500)
-				}
+				http.Redirect(w, req, casService.Url, http.StatusFound)
 			}
-
 			return
 		}
 
 		// Attempt non-interactive authentication
-		returnedUser, casErr := c.validateUserCredentials(email, password)
+		returnedUser, casErr := c.validateUserCredentials(email, password, loginFields)
+
+		// Two-factor authentication cannot be completed non-interactively, the service gets no ticket
+		if casErr == nil && returnedUser.TOTPEnabled() {
+			if casService == nil {
+				c.beginTOTPLogin(w, req, logger, context, returnedUser, false)
+			} else {
+				http.Redirect(w, req, casService.Url, http.StatusFound)
+			}
+			return
+		}
+
+		c.recordLoginAttempt(req, logger, email, serviceUrl, clientIP, casErr)
 		if casErr != nil {
 			// In the case of an error, redirect to the service with no ticket
 			if casService == nil {
-				context["Error"] = casErr.Msg
+				context["Error"] = c.localizeError(context, casErr)
 				c.render.HTML(w, casErr.HttpCode, "login", context)
 			} else {
-				http.Redirect(w, req, casService.Url, 401)
+				http.Redirect(w, req, casService.Url, http.StatusFound)
 			}
 			return
 		}
 
 		// Save session since non-interactive auth succeeded
-		_, err := c.saveCurrentUserInSession(w, req, "casgo-session", returnedUser)
-		if err != nil {
-			log.Fatal("Failed to save session!")
+		session, casErr := c.saveCurrentUserInSession(w, req, "casgo-session", returnedUser, false)
+		if casErr != nil {
+			logger.Error("Failed to save session", "error", casErr)
+			if casService == nil {
+				context["Error"] = c.localizeError(context, casErr)
+				c.render.HTML(w, casErr.HttpCode, "login", context)
+			} else {
+				http.Redirect(w, req, casService.Url, http.StatusFound)
+			}
+			return
 		}
 
 		if casService == nil {
 			// If service is not set, render login with context
 			c.render.HTML(w, http.StatusBadRequest, "login", context)
 		} else {
-			// Create a new ticket
-			ticket := &CASTicket{
-				UserEmail:      returnedUser.Email,
-				UserAttributes: returnedUser.Attributes,
-				WasSSO:         false,
-			}
-
-			// If service is set, redirect
-			ticket, err := c.Db.AddTicketForService(ticket, casService)
-			if err != nil {
-				http.Error(w, "Failed to create new authentication ticket. Please contact administrator if problem persists.", 500)
-				return
-			}
-			http.Redirect(w, req, serviceUrl+"?ticket="+ticket.Id, 302)
-			return
+			// Create a new ticket, if service is set, redirect
+			c.makeNewTicketAndRedirect(w, req, context, returnedUser, casService, false, tgtIdFromSession(session))
 		}
+		return
 
 	} // /if gateway == true
 
+	if req.Method == "POST" && len(totpCode) > 0 {
+		c.finishTOTPLogin(w, req, context, casService, totpCode, clientIP)
+		return
+	}
+
 	// Trim and lightly pre-process/validate email/password
 	if email == "" || password == "" {
 		c.render.HTML(w, http.StatusOK, "login", context)
@@ -313,121 +696,214 @@ func (c *CAS) HandleLogin(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Find user, and attempt to validate provided credentials
-	returnedUser, casErr := c.validateUserCredentials(email, password)
+	returnedUser, casErr := c.validateUserCredentials(email, password, loginFields)
+
+	// Users with two-factor authentication enabled must provide a code before they are logged in
+	if casErr == nil && returnedUser.TOTPEnabled() {
+		c.beginTOTPLogin(w, req, logger, context, returnedUser, rememberMe)
+		return
+	}
+
+	c.recordLoginAttempt(req, logger, email, serviceUrl, clientIP, casErr)
 	if casErr != nil {
-		context["Error"] = casErr.Msg
+		context["Error"] = c.localizeError(context, casErr)
 		c.render.HTML(w, casErr.HttpCode, "login", context)
 		return
 	}
 
+	c.completeLogin(w, req, context, returnedUser, casService, rememberMe)
+}
+
+// Log in a user whose credentials have been verified, issuing a ticket-granting ticket
+// Redirects to the service with a new ticket if one was specified
+func (c *CAS) completeLogin(w http.ResponseWriter, req *http.Request, context map[string]interface{}, returnedUser *User, casService *CASService, rememberMe bool) {
 	// Save session in cookies
-	session, err := c.saveCurrentUserInSession(w, req, "casgo-session", returnedUser)
-	if err != nil {
-		log.Fatal("Failed to save session, err:", err)
+	session, casErr := c.saveCurrentUserInSession(w, req, "casgo-session", returnedUser, rememberMe)
+	if casErr != nil {
+		c.requestLogger(req).Error("Failed to save session", "username", returnedUser.Email, "error", casErr)
+		context["Error"] = c.localizeError(context, casErr)
+		c.render.HTML(w, casErr.HttpCode, "login", context)
+		return
 	}
 
 	// Update context with session
-	if err == nil {
-		c.augmentTemplateContext(context, session)
-	}
+	c.augmentTemplateContext(c.backendFor(req), context, session)
 
-	// If the user has sucessfully logged in, create a new ticket (with SSO set to true) and redirect
+	// If the user has sucessfully logged in, create a new ticket and redirect
+	// The user just presented credentials, so the ticket was not issued through single sign on (and passes renew validation)
 	// Otherwise render login page
 	if casService != nil {
 
-		ssoTicket := &CASTicket{
-			UserEmail:      returnedUser.Email,
-			UserAttributes: returnedUser.Attributes,
-			WasSSO:         true,
-		}
-
 		// Get ticket for the service
-		ticket, err := c.Db.AddTicketForService(ssoTicket, casService)
-		if err != nil {
-			http.Error(w, "Failed to create new authentication ticket. Please contact administrator if problem persists.", 500)
-			return
-		}
-
-		// TODO: Enforce service url starts with appropriate scheme (http/https)
-		http.Redirect(w, req, serviceUrl+"?ticket="+ticket.Id, 302)
+		c.makeNewTicketAndRedirect(w, req, context, returnedUser, casService, false, tgtIdFromSession(session))
 		return
 
 	} else {
 
-		context["Success"] = "Successful log in! Redirecting to services page..."
+		context["Success"] = c.localize(context, "login.success")
 		c.render.HTML(w, http.StatusOK, "login", context)
 
 	}
 }
 
 // Make a new ticket for a service
-func (c *CAS) makeNewTicketForService(service *CASService) (string, *CASServerError) {
-	return "123456", nil
+// Tickets issued under a ticket-granting ticket count against its quota, and are refused once it is exhausted
+func (c *CAS) makeNewTicketForService(db Backend, user *User, service *CASService, wasSSO, renewed bool, tgtId string) (*CASTicket, *CASServerError) {
+	if casErr := c.consumeServiceTicketQuota(db, tgtId); casErr != nil {
+		return nil, casErr
+	}
+
+	ticketId, err := c.TicketGenerator.GenerateServiceTicket()
+	if err != nil {
+		return nil, &FailedToCreateNewAuthTicketError
+	}
+
+	ticket := &CASTicket{
+		Id:             ticketId,
+		UserEmail:      user.Email,
+		UserAttributes: user.Attributes,
+		WasSSO:         wasSSO,
+		Renewed:        renewed,
+		TGTId:          tgtId,
+		ServiceUrl:     service.Url,
+		ExpiresAt:      c.now().Add(c.serviceTicketTTL(service)),
+	}
+
+	ticket, casErr := db.AddTicketForService(ticket, service)
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	c.Metrics.TicketsIssued.Inc("service")
+	return ticket, nil
 }
 
-func (c *CAS) makeNewTicketAndRedirect(w http.ResponseWriter, req *http.Request, service *CASService) (bool, *CASServerError) {
+// Users refused a ticket (ex. because their session's quota is exhausted) are shown the login page, with the error
+func (c *CAS) makeNewTicketAndRedirect(w http.ResponseWriter, req *http.Request, context map[string]interface{}, user *User, service *CASService, wasSSO bool, tgtId string) (bool, *CASServerError) {
 	// If service is set, redirect
-	ticket, err := c.makeNewTicketForService(service)
+	logger := c.requestLogger(req).With("username", user.Email, "service", service.Url)
+
+	// The OIDC authorization endpoint issues its own codes, from the session
+	if service.oidcLogin {
+		http.Redirect(w, req, service.Url, http.StatusFound)
+		return true, nil
+	}
+	// Logins requested with renew carry it through the login form (tickets issued through single sign on never count)
+	renewed := !wasSSO && strings.TrimSpace(strings.ToLower(req.FormValue("renew"))) == "true"
+	ticket, err := c.makeNewTicketForService(c.backendFor(req), user, service, wasSSO, renewed, tgtId)
+	if err != nil && err.HttpCode < http.StatusInternalServerError {
+		logger.Warn("Service ticket refused", "error", err)
+		c.audit(req, withAuditDetails(NewAuditEvent(AUDIT_TICKET_ISSUED, user.Email, service.Url, err), "ticketType", "service", "sso", strconv.FormatBool(wasSSO), "renew", strconv.FormatBool(renewed)))
+		delete(context, "currentUser")
+		context["Error"] = c.localizeError(context, err)
+		c.render.HTML(w, err.HttpCode, "login", context)
+		return false, err
+	}
 	if err != nil {
+		logger.Error("Failed to issue service ticket", "error", err)
 		http.Error(w, "Failed to create new authentication ticket. Please contact administrator if problem persists.", 500)
 		return false, &FailedToCreateNewAuthTicketError
 	}
-	redirectUrl := service.Url + "?ticket=" + ticket
+	logger.Info("Issued service ticket", "sso", wasSSO, "renew", renewed)
+	c.audit(req, withAuditDetails(NewAuditEvent(AUDIT_TICKET_ISSUED, user.Email, service.Url, nil), "ticketType", "service", "sso", strconv.FormatBool(wasSSO), "renew", strconv.FormatBool(renewed)))
+	// Services matched by prefix (or regular expression) may have been requested with a query of their own
+	redirectUrl := urlWithParams(service.Url, url.Values{"ticket": {ticket.Id}})
+
+	// Users who asked to be warned confirm the redirect themselves
+	if c.shouldWarnBeforeRedirect(req, user) {
+		c.renderServiceWarning(w, req, service, redirectUrl)
+		return true, nil
+	}
+
 	http.Redirect(w, req, redirectUrl, 302)
 	return true, nil
 }
 
 // Save session in cookiestore
-func (c *CAS) saveCurrentUserInSession(w http.ResponseWriter, req *http.Request, sessionName string, user *User) (*sessions.Session, *CASServerError) {
+// A new ticket-granting ticket is issued for the session, replacing any it previously had
+// Remembered sessions are stored in a persistent cookie that lasts as long as the ticket-granting ticket
+func (c *CAS) saveCurrentUserInSession(w http.ResponseWriter, req *http.Request, sessionName string, user *User, rememberMe bool) (*sessions.Session, *CASServerError) {
 	// Save session in cookies
-	session, _ := c.cookieStore.Get(req, sessionName)
+	session, _ := c.sessionStore.Get(req, sessionName)
+
+	// Save user information onto session (two-factor secrets are never stored in cookies)
+	sessionUser := *user
+	sessionUser.TOTP = nil
+	session.Values["currentUser"] = sessionUser
+	clearPendingTOTPLogin(session)
+	setSessionWarning(session, req)
+
+	if previousTgtId := tgtIdFromSession(session); len(previousTgtId) > 0 {
+		if casErr := c.backendFor(req).RemoveTicketGrantingTicketById(previousTgtId); casErr != nil {
+			c.Logger.Error("Failed to remove previous ticket-granting ticket", "sessionId", sessionIdForTGT(previousTgtId), "error", casErr)
+		}
+	} else {
+		c.Metrics.ActiveSessions.Inc()
+	}
+
+	tgt, casErr := c.makeNewTicketGrantingTicket(c.backendFor(req), user, rememberMe)
+	if casErr != nil {
+		return nil, casErr
+	}
+	session.Values["tgtId"] = tgt.Id
+	session.Values["createdAt"] = tgt.CreatedAt
 
-	// Save user information onto session
-	session.Values["currentUser"] = *user
+	if rememberMe {
+		session.Options.MaxAge = int(tgt.ExpiresAt.Sub(tgt.CreatedAt).Seconds())
+	}
 
 	// Save the session
-	sessionSaveErr := session.Save(req, w)
-	if sessionSaveErr != nil {
-		log.Fatal("Failed to save logged in user to session:", sessionSaveErr)
+	// Session stores may be remote (ex. Redis), so saving can fail
+	if err := session.Save(req, w); err != nil {
+		c.Logger.Error("Failed to save logged in user to session", "username", user.Email, "error", err)
+		if casErr := c.backendFor(req).RemoveTicketGrantingTicketById(tgt.Id); casErr != nil {
+			c.Logger.Error("Failed to remove unsaved ticket-granting ticket", "sessionId", sessionIdForTGT(tgt.Id), "error", casErr)
+		}
 		return nil, &FailedToSaveSessionError
 	}
 
 	return session, nil
 }
 
-// Validate user credentials
-// Returns a valid user object if validation succeeds
-func (c *CAS) validateUserCredentials(email string, password string) (*User, *CASServerError) {
-	// TODO get the user from the current database adapter
-	returnedUser, err := c.Db.FindUserByEmail(email)
-	if err != nil {
-		return nil, &FailedToFindUserError
+// Get the ticket-granting ticket ID stored in a session (if any)
+func tgtIdFromSession(session *sessions.Session) string {
+	tgtId, _ := session.Values["tgtId"].(string)
+	return tgtId
+}
+
+// Record the outcome of a login attempt (only failures count towards rate limits)
+func (c *CAS) recordLoginAttempt(req *http.Request, logger Logger, email, serviceUrl, clientIP string, casErr *CASServerError) {
+	c.audit(req, NewAuditEvent(AUDIT_LOGIN, email, serviceUrl, casErr))
+
+	if casErr != nil {
+		c.LoginRateLimiter.RecordFailure(clientIP)
+		c.Metrics.LoginAttempts.Inc("failure")
+		logger.Warn("Login failed", "error", casErr, "errorCode", casErr.CasgoErrCode)
+	} else {
+		c.Metrics.LoginAttempts.Inc("success")
+		logger.Info("Login succeeded")
 	}
+}
 
-	// Use default authentication typeDepending on the authentication type
-	switch c.Config["authMethod"] {
-	case "password":
-		// Check hash
-		err := bcrypt.CompareHashAndPassword([]byte(returnedUser.Password), []byte(password))
-		if err != nil {
-			return nil, &InvalidCredentialsError
-		}
-		break
-	default:
+// Validate user credentials, along with the values of the extra login fields (for authenticators that use them)
+// Returns a valid user object if validation succeeds
+func (c *CAS) validateUserCredentials(email string, password string, fields map[string]string) (*User, *CASServerError) {
+	if c.Authenticator == nil {
 		return nil, &AuthMethodNotSupportedError
 	}
-
-	// Successful validation
-	return returnedUser, nil
+	if authenticator, ok := c.Authenticator.(CASFieldsAuthenticator); ok {
+		return authenticator.AuthenticateWithFields(email, password, fields)
+	}
+	return c.Authenticator.Authenticate(email, password)
 }
 
 // Endpoint for registering new users
 func (c *CAS) HandleRegister(w http.ResponseWriter, req *http.Request) {
-	context := map[string]interface{}{"CompanyName": c.Config["companyName"]}
+	context := c.templateContext(w, req)
 
 	// Show login page if credentials are not provided, attempt login otherwise
 	email := strings.TrimSpace(strings.ToLower(req.FormValue("email")))
-	password := strings.TrimSpace(strings.ToLower(req.FormValue("password")))
+	password := strings.TrimSpace(req.FormValue("password"))
 
 	// Exit early if email/password are empty
 	if email == "" || password == "" {
@@ -435,78 +911,135 @@ func (c *CAS) HandleRegister(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Refuse passwords that don't comply with the password policy
+	if failures := c.PasswordPolicy.Check(password); len(failures) > 0 {
+		context["Error"] = c.localizePasswordFailures(context, failures)
+		c.render.HTML(w, http.StatusBadRequest, "register", context)
+		return
+	}
+
 	// Generate hashed password
-	encryptedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 10) // Default cost
+	encryptedPassword, err := c.PasswordHasher.Hash(password)
 	if err != nil {
-		context["Error"] = "Registration failed... Please contact server administrator"
+		context["Error"] = c.localize(context, "register.failed")
 		c.render.HTML(w, http.StatusInternalServerError, "register", context)
 		return
 	}
 
 	// Create new user object
-	_, casErr := c.Db.AddNewUser(email, string(encryptedPassword))
+	_, casErr := c.backendFor(req).AddNewUser(email, encryptedPassword)
 	if casErr != nil {
-		context["Error"] = casErr.Msg
+		context["Error"] = c.localizeError(context, casErr)
 		c.render.HTML(w, http.StatusBadRequest, "register", context)
 		return
 	}
 
-	context["Success"] = "Registration successful!"
+	context["Success"] = c.localize(context, "register.success")
 	c.render.HTML(w, http.StatusOK, "register", context)
 }
 
 // Endpoint for destroying CAS sessions (logging out)
 func (c *CAS) HandleLogout(w http.ResponseWriter, req *http.Request) {
-	context := map[string]interface{}{"CompanyName": c.Config["companyName"]}
+	defer c.Metrics.ObserveRequest("/logout", time.Now())
 
-	// Get the user's session
-	session, _ := c.cookieStore.Get(req, "casgo-session")
+	context := c.templateContext(w, req)
 
-	serviceUrl := strings.TrimSpace(strings.ToLower(req.FormValue("service")))
+	// Get the user's session
+	session, _ := c.sessionStore.Get(req, "casgo-session")
 
-	// Get the CASService for this service URL
-	var casService *CASService
-	if len(serviceUrl) > 0 {
-		returnedService, err := c.Db.FindServiceByUrl(serviceUrl)
-		if err != nil {
-			context["Error"] = "Failed to find matching service with URL [" + serviceUrl + "]."
-			c.render.HTML(w, http.StatusNotFound, "login", context)
-			return
-		}
-		casService = returnedService
-	}
+	// Get the CASService the user asked to return to (only registered services are returned to)
+	serviceUrl := strings.TrimSpace(req.FormValue("service"))
+	casService := c.logoutReturnService(req, serviceUrl)
 
 	// Exit early if the user is not already logged in (in session), otherwise get their email
 	currentUserRef, ok := session.Values["currentUser"]
 	if !ok {
-		// Redirect if the person was never logged in
-		http.Redirect(w, req, "/login", 401)
+		// Send the person back to the service they came from, or to the login page if there is none
+		if casService != nil {
+			c.finishLogout(w, req, context, casService)
+			return
+		}
+		http.Redirect(w, req, c.URLPath("/login"), 401)
 		return
 	}
 	currentUser := currentUserRef.(User)
 
+	// Find services to notify of the logout before the user's tickets are removed
+	logoutNotifications := c.logoutNotificationsForTGT(c.backendFor(req), tgtIdFromSession(session))
+
 	// If service was specified, Delete any ticket granting tickets that belong to the user
-	err := c.Db.RemoveTicketsForUserWithService(currentUser.Email, casService)
+	logger := c.requestLogger(req).With("username", currentUser.Email, "service", serviceUrl)
+	err := c.backendFor(req).RemoveTicketsForUserWithService(currentUser.Email, casService)
 	if err != nil {
-		log.Printf("Failed to remove ticket for user %s", currentUser.Email)
+		logger.Error("Failed to remove tickets on logout", "error", err)
 	}
 
 	// Remove current user information from session
 	casErr := c.removeCurrentUserFromSession(w, req, session)
+	c.audit(req, NewAuditEvent(AUDIT_LOGOUT, currentUser.Email, serviceUrl, casErr))
 	if casErr != nil {
-		context["Error"] = "Failed to log out... Please contact your IT administrator"
+		context["Error"] = c.localize(context, "logout.failed")
 		c.render.HTML(w, casErr.HttpCode, "login", context)
 		return
 	}
 
-	context["Success"] = "Successfully logged out"
+	c.notifyLogout(logoutNotifications)
+
+	logger.Info("Logged out", "servicesNotified", len(logoutNotifications))
+	c.finishLogout(w, req, context, casService)
+}
+
+// Whether users are redirected to the service they asked to return to after logging out (rather than shown a link)
+func (c *CAS) logoutRedirectEnabled() bool {
+	enabled, err := configBool(c.Config, "logoutRedirect")
+	if err != nil {
+		c.Logger.Warn("Invalid configuration value, redirects after logout disabled", "key", "logoutRedirect", "error", err)
+	}
+	return enabled
+}
+
+// Find the service a user asked to return to after logging out (nil if none was asked for, or it isn't registered
+// and allowed, so logging out can't be used to redirect users to arbitrary sites)
+func (c *CAS) logoutReturnService(req *http.Request, serviceUrl string) *CASService {
+	if len(serviceUrl) == 0 {
+		return nil
+	}
+	if c.checkServiceUrlAllowed(serviceUrl) == nil {
+		if service, casErr := c.findServiceForUrl(c.backendFor(req), serviceUrl); casErr == nil {
+			return service
+		}
+	}
+	c.requestLogger(req).Warn("Not returning to unregistered service after logout", "service", serviceUrl)
+	return nil
+}
+
+// Show the logout page, redirecting to (or linking to, if logoutRedirect is disabled) the service returned to if any
+func (c *CAS) finishLogout(w http.ResponseWriter, req *http.Request, context map[string]interface{}, casService *CASService) {
+	if casService != nil {
+		if c.logoutRedirectEnabled() {
+			http.Redirect(w, req, casService.Url, http.StatusFound)
+			return
+		}
+		context["ReturnServiceUrl"] = casService.Url
+		context["ReturnServiceName"] = casService.Name
+	}
+
+	context["Success"] = c.localize(context, "logout.success")
 	c.render.HTML(w, http.StatusOK, "login", context)
 }
 
 // Remove all current user information from the session object
 func (c *CAS) removeCurrentUserFromSession(w http.ResponseWriter, req *http.Request, session *sessions.Session) *CASServerError {
-	// Delete current user from session
+	// Delete current user (and their ticket-granting ticket) from session
+	if tgtId := tgtIdFromSession(session); len(tgtId) > 0 {
+		if casErr := c.backendFor(req).RemoveTicketGrantingTicketById(tgtId); casErr != nil {
+			c.Logger.Error("Failed to remove ticket-granting ticket", "sessionId", sessionIdForTGT(tgtId), "error", casErr)
+		}
+		c.Metrics.ActiveSessions.Dec()
+	}
 	delete(session.Values, "currentUser")
+	delete(session.Values, "tgtId")
+	delete(session.Values, "createdAt")
 
 	// Save the modified session
 	err := session.Save(req, w)
@@ -517,71 +1050,230 @@ func (c *CAS) removeCurrentUserFromSession(w http.ResponseWriter, req *http.Requ
 	return nil
 }
 
-// Endpoint for validating service tickets
-func (c *CAS) HandleValidate(w http.ResponseWriter, req *http.Request) {
-
-	// Grab important request parameters
-	serviceUrl := strings.TrimSpace(req.FormValue("service"))
-	ticket := strings.TrimSpace(strings.ToLower(req.FormValue("ticket")))
-	renew := strings.TrimSpace(strings.ToLower(req.FormValue("renew")))
+// Validate a service ticket for a given service URL
+// Proxy tickets are refused (endpoints accepting them validate them with validateProxyTicket instead)
+// Returns the validated ticket, or the CAS failure code and error that caused validation to fail
+func (c *CAS) validateServiceTicket(db Backend, serviceUrl, ticket, renew, serviceSecret string) (*CASTicket, string, *CASServerError) {
+	// Both service and ticket are required
+	if len(serviceUrl) == 0 || len(ticket) == 0 {
+		return nil, CAS_INVALID_REQUEST, &MissingValidationParametersError
+	}
+	if strings.HasPrefix(ticket, PROXY_TICKET_PREFIX+"-") {
+		return nil, CAS_INVALID_TICKET_SPEC, &ProxyTicketNotAcceptedError
+	}
 
 	// Get the CASService for the given service URL
-	casService, casErr := c.Db.FindServiceByUrl(serviceUrl)
+	if casErr := c.checkServiceUrlAllowed(serviceUrl); casErr != nil {
+		return nil, CAS_INVALID_SERVICE, casErr
+	}
+	casService, casErr := c.findServiceForTicket(db, serviceUrl)
 	if casErr != nil {
-		log.Printf("Failed to find matching service with URL [%s]", serviceUrl)
-		c.render.JSON(w, http.StatusOK, map[string]string{
-			"status":  "error",
-			"code":    strconv.Itoa(*&FailedToFindServiceError.CasgoErrCode),
-			"message": *&FailedToFindServiceError.Msg,
-		})
-		return
+		if casErr.Code != FailedToFindServiceByUrlError.Code {
+			return nil, CAS_INTERNAL_ERROR, casErr
+		}
+		return nil, CAS_INVALID_SERVICE, &FailedToFindServiceError
+	}
+
+	// Services with a secret must present it, so a leaked ticket can't be validated by anyone else (nor consumed)
+	if !casService.SecretMatches(serviceSecret) {
+		return nil, CAS_INVALID_REQUEST, &InvalidServiceSecretError
 	}
 
-	// Look up ticket
-	casTicket, casErr := c.Db.FindTicketByIdForService(ticket, casService)
+	// Look up ticket (which must have been issued for the same service)
+	casTicket, casErr := db.FindTicketByIdForService(ticket, casService)
 	if casErr != nil {
-		log.Print("Failed to find matching ticket", casService.Url)
-		c.render.JSON(w, http.StatusOK, map[string]string{
-			"status":  "error",
-			"code":    strconv.Itoa(*&FailedToFindTicketError.CasgoErrCode),
-			"message": *&FailedToFindTicketError.Msg,
-		})
-		return
+		if casErr.Code != FailedToFindTicketError.Code {
+			return nil, CAS_INTERNAL_ERROR, casErr
+		}
+		return nil, CAS_INVALID_TICKET, &FailedToFindTicketError
+	}
+	if len(casTicket.ServiceUrl) > 0 && !c.sameServiceUrl(casTicket.ServiceUrl, casService.Url) {
+		return nil, CAS_INVALID_SERVICE, &TicketServiceMismatchError
+	}
+
+	// Tickets must be validated (for the first time) before they expire
+	now := c.now()
+	if !casTicket.Validated && casTicket.IsExpired(now) {
+		return nil, CAS_INVALID_TICKET, &ExpiredServiceTicketError
 	}
 
 	// If renew is specified, validation only works if the login is fresh (not from a single sign on session)
 	if renew == "true" && casTicket.WasSSO {
+		return nil, CAS_INVALID_TICKET_SPEC, &SSOAuthenticatedUserRenewError
+	}
+
+	// Consume the ticket, which can only be validated once (of concurrent validations, only one succeeds), unless the
+	// service's ticket policy lets it be validated again for a while
+	// The validation is recorded, so the service can be notified on (single) logout
+	if casErr := db.ConsumeTicket(casTicket.Id, now, serviceTicketReuseWindow(casService)); casErr != nil {
+		if casErr.Code != TicketAlreadyValidatedError.Code {
+			c.Logger.Error("Failed to mark ticket as validated", "service", serviceUrl, "username", casTicket.UserEmail, "error", casErr)
+			return nil, CAS_INTERNAL_ERROR, casErr
+		}
+		return nil, CAS_INVALID_TICKET, casErr
+	}
+
+	return casTicket, "", nil
+}
+
+// Endpoint for validating service tickets
+func (c *CAS) HandleValidate(w http.ResponseWriter, req *http.Request) {
+	defer c.Metrics.ObserveRequest("/validate", time.Now())
+
+	// Grab important request parameters
+	serviceUrl := strings.TrimSpace(req.FormValue("service"))
+	ticket := strings.TrimSpace(req.FormValue("ticket"))
+	renew := strings.TrimSpace(strings.ToLower(req.FormValue("renew")))
+
+	db := c.backendFor(req)
+	logger := c.requestLogger(req).With("route", "/validate", "service", serviceUrl)
+	casTicket, failureCode, casErr := c.validateServiceTicket(db, serviceUrl, ticket, renew, serviceSecretFromRequest(req))
+	c.Metrics.ObserveValidation("/validate", casErr == nil)
+	c.auditValidation(req, "/validate", casTicket, serviceUrl, casErr)
+	if casErr != nil {
+		logger.Warn("Ticket validation failed", "error", casErr, "errorCode", casErr.CasgoErrCode, "failureCode", failureCode)
 		c.render.JSON(w, http.StatusOK, map[string]string{
-			"status":  "error",
-			"code":    strconv.Itoa(*&SSOAuthenticatedUserRenewError.CasgoErrCode),
-			"message": *&SSOAuthenticatedUserRenewError.Msg,
+			"status":      "error",
+			"code":        strconv.Itoa(casErr.CasgoErrCode),
+			"failureCode": failureCode,
+			"message":     casErr.Msg,
 		})
 		return
 	}
 
-	// Successfully validated user send user information along
+	// Successfully validated user send user information (permitted by the service's release policy) along
+	logger.Info("Validated service ticket", "username", casTicket.UserEmail)
+	policy := c.attributeReleasePolicyForService(db, serviceUrl)
 	c.render.JSON(w, http.StatusOK, map[string]interface{}{
 		"status":         "success",
 		"message":        "Successfully authenticated user",
 		"userEmail":      casTicket.UserEmail,
-		"userAttributes": casTicket.UserAttributes,
+		"userAttributes": policy.Apply(casTicket.UserAttributes, true),
 	})
 }
 
-// Endpoint for validating service tickets for possible proxies (CAS 2.0)
-func (c *CAS) HandleServiceValidate(w http.ResponseWriter, req *http.Request) {
-	log.Print("Attempt to use /serviceValidate, feature not supported yet")
-	c.render.JSON(w, UnsupportedFeatureError.HttpCode, map[string]string{"error": UnsupportedFeatureError.Msg})
+// Endpoint for validating service tickets, releasing user attributes (CAS 3.0)
+func (c *CAS) HandleServiceValidateV3(w http.ResponseWriter, req *http.Request) {
+	c.handleTicketValidation(w, req, "/p3/serviceValidate", false, true)
 }
 
-// Endpoint for validating proxy tickets (CAS 2.0)
-func (c *CAS) HandleProxyValidate(w http.ResponseWriter, req *http.Request) {
-	log.Print("Attempt to use /proxyValidate, feature not supported yet")
-	c.render.JSON(w, UnsupportedFeatureError.HttpCode, map[string]string{"error": UnsupportedFeatureError.Msg})
+// Get the attribute release policy of the service with the given URL (nil if it has none)
+func (c *CAS) attributeReleasePolicyForService(db Backend, serviceUrl string) *CASAttributeReleasePolicy {
+	casService, casErr := c.findServiceForUrl(db, serviceUrl)
+	if casErr != nil {
+		return nil
+	}
+	return casService.AttributeReleasePolicy
 }
 
-// Endpoint for handling proxy tickets (CAS 2.0)
-func (c *CAS) HandleProxy(w http.ResponseWriter, req *http.Request) {
-	log.Print("Attempt to use /proxy, feature not supported yet")
-	c.render.JSON(w, UnsupportedFeatureError.HttpCode, map[string]string{"error": UnsupportedFeatureError.Msg})
+// Attributes of a user released to a service, as permitted by its release policy (or, if it has none and
+// releaseByDefault is set, all of them)
+// The attributes currently stored on the user are preferred, falling back to those saved with the ticket
+func (c *CAS) releasedAttributes(db Backend, serviceUrl, userEmail string, ticketAttributes map[string]string, releaseByDefault bool) CASAttributes {
+	policy := c.attributeReleasePolicyForService(db, serviceUrl)
+	if policy == nil && !releaseByDefault {
+		return nil
+	}
+	user, casErr := db.FindUserByEmail(userEmail)
+	if casErr != nil {
+		user = &User{Email: userEmail, Attributes: ticketAttributes}
+	}
+	return policy.Apply(userAttributesForRelease(user), releaseByDefault)
+}
+
+// Validate a service (or proxy) ticket and render the resulting CAS service response
+// Issues a proxy-granting ticket if a proxy callback URL (pgtUrl) was specified
+// Attributes are released as permitted by the service's release policy, or (if it has none) only if releaseAttributes is set
+func (c *CAS) handleTicketValidation(w http.ResponseWriter, req *http.Request, route string, allowProxyTickets, releaseAttributes bool) {
+	defer c.Metrics.ObserveRequest(route, time.Now())
+
+	serviceUrl := strings.TrimSpace(req.FormValue("service"))
+	ticket := strings.TrimSpace(req.FormValue("ticket"))
+	renew := strings.TrimSpace(strings.ToLower(req.FormValue("renew")))
+	pgtUrl := strings.TrimSpace(req.FormValue("pgtUrl"))
+	format := strings.TrimSpace(strings.ToUpper(req.FormValue("format")))
+	serviceSecret := serviceSecretFromRequest(req)
+
+	var userEmail string
+	var userAttributes map[string]string
+	var proxies []string
+	db := c.backendFor(req)
+	logger := c.requestLogger(req).With("route", route, "service", serviceUrl)
+
+	if allowProxyTickets && strings.HasPrefix(ticket, PROXY_TICKET_PREFIX+"-") {
+		// Validate proxy ticket
+		proxyTicket, failureCode, casErr := c.validateProxyTicket(db, serviceUrl, ticket, renew, serviceSecret)
+		c.Metrics.ObserveValidation(route, casErr == nil)
+		event := NewAuditEvent(AUDIT_TICKET_VALIDATED, "", serviceUrl, casErr)
+		if proxyTicket != nil {
+			event.Actor = proxyTicket.UserEmail
+		}
+		c.audit(req, withAuditDetails(event, "endpoint", route, "ticketType", "proxy"))
+		if casErr != nil {
+			logger.Warn("Proxy ticket validation failed", "error", casErr, "errorCode", casErr.CasgoErrCode)
+			c.renderServiceResponse(w, format, NewCASFailureResponse(failureCode, casErr.Msg))
+			return
+		}
+		userEmail, userAttributes, proxies = proxyTicket.UserEmail, proxyTicket.UserAttributes, proxyTicket.Proxies
+	} else {
+		// Validate service ticket
+		casTicket, failureCode, casErr := c.validateServiceTicket(db, serviceUrl, ticket, renew, serviceSecret)
+		c.Metrics.ObserveValidation(route, casErr == nil)
+		c.auditValidation(req, route, casTicket, serviceUrl, casErr)
+		if casErr != nil {
+			logger.Warn("Ticket validation failed", "error", casErr, "errorCode", casErr.CasgoErrCode)
+			c.renderServiceResponse(w, format, NewCASFailureResponse(failureCode, casErr.Msg))
+			return
+		}
+		userEmail, userAttributes = casTicket.UserEmail, casTicket.UserAttributes
+	}
+
+	logger = logger.With("username", userEmail)
+	logger.Info("Validated ticket", "proxies", len(proxies))
+	response := NewCASSuccessResponse(userEmail, nil)
+	response.Success.Proxies = proxies
+
+	response.Success.Attributes = c.releasedAttributes(db, serviceUrl, userEmail, userAttributes, releaseAttributes)
+
+	// Issue a proxy-granting ticket if a callback was specified
+	// Validation still succeeds (without a PGT IOU) if the callback could not be reached
+	if len(pgtUrl) > 0 {
+		pgtIou, casErr := c.issueProxyGrantingTicket(db, pgtUrl, userEmail, userAttributes, proxies)
+		if casErr != nil {
+			logger.Warn("Failed to issue proxy-granting ticket", "pgtUrl", pgtUrl, "error", casErr)
+		} else {
+			response.Success.ProxyGrantingTicket = pgtIou
+		}
+	}
+
+	c.renderServiceResponse(w, format, response)
+}
+
+// Render a CAS service response in the requested format (XML unless JSON is specified, unknown formats included)
+// Both formats are built from the same response, so they always agree
+func (c *CAS) renderServiceResponse(w http.ResponseWriter, format string, response *CASServiceResponse) {
+	contentType, document := "text/xml; charset=UTF-8", response.XMLDocument
+	if format == "JSON" {
+		contentType, document = "application/json; charset=UTF-8", response.JSONDocument
+	}
+
+	body, err := document()
+	if err != nil {
+		c.Logger.Error("Failed to build CAS service response", "error", err, "format", format)
+		http.Error(w, "Failed to build CAS service response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
+
+// Endpoint for validating service tickets (CAS 2.0)
+func (c *CAS) HandleServiceValidate(w http.ResponseWriter, req *http.Request) {
+	c.handleTicketValidation(w, req, "/serviceValidate", false, false)
+}
+
+// Endpoint for validating service and proxy tickets (CAS 2.0)
+func (c *CAS) HandleProxyValidate(w http.ResponseWriter, req *http.Request) {
+	c.handleTicketValidation(w, req, "/proxyValidate", true, false)
 }
@@ -0,0 +1,165 @@
+// Package castest creates CAS servers for tests, and logs in to them like a browser would
+//
+// Servers are created from paths relative to a test package directory under cas (ex. cas/warn_test), so this package
+// can only be used by the tests in those directories
+package castest
+
+import (
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+const (
+	// User (from the users fixture) that logs in, and its password (set by NewTestServer)
+	TestUserEmail = "test@test.com"
+	TestPassword  = "secret"
+
+	// URL of a service in the services fixture
+	TestServiceUrl = "localhost:3000/validateCASLogin"
+)
+
+// Build the configuration of a test server: the default configuration, with a memory backend, the repo's templates and
+// CSRF protection disabled, overridden by the given values
+func NewTestConfig(overrides map[string]string) map[string]string {
+	config, err := NewCASServerConfig("")
+	ExpectWithOffset(1, err).To(BeNil())
+	config["dbBackend"] = "memory"
+	config["templatesDirectory"] = "../templates"
+	config["csrfEnabled"] = "false"
+	for key, value := range overrides {
+		config[key] = value
+	}
+	return config
+}
+
+// Backend that fixtures can be loaded into (a MemoryBackend, or a backend wrapping one)
+type FixtureBackend interface {
+	Backend
+	LoadJSONFixture(tableName, path string) *CASServerError
+}
+
+// Create a test server (configured by NewTestConfig) with the users, services and API keys fixtures loaded, and the
+// password of every user set to TestPassword
+func NewTestServer(overrides map[string]string) (*CAS, *MemoryBackend) {
+	server := newTestServer(overrides, CASServerOptions{})
+	db, ok := server.Db.(*MemoryBackend)
+	ExpectWithOffset(1, ok).To(BeTrue(), "test server backend is not a MemoryBackend")
+	return server, db
+}
+
+// Create a test server (see NewTestServer) with the given options, logging nothing unless a logger is given
+func NewTestServerWithOptions(overrides map[string]string, options CASServerOptions) *CAS {
+	return newTestServer(overrides, options)
+}
+
+func newTestServer(overrides map[string]string, options CASServerOptions) *CAS {
+	if options.Logger == nil {
+		options.Logger = NoopLogger{}
+	}
+	server, err := NewCASServerWithOptions(NewTestConfig(overrides), options)
+	ExpectWithOffset(2, err).To(BeNil())
+
+	db, ok := server.Db.(FixtureBackend)
+	ExpectWithOffset(2, ok).To(BeTrue(), "fixtures can't be loaded into the test server backend")
+	LoadFixtures(db)
+	SetTestPasswords(db, server.PasswordHasher)
+	return server
+}
+
+// Load the users, services and API keys fixtures into a backend
+func LoadFixtures(db FixtureBackend) {
+	for _, table := range []string{"users", "services", "api_keys"} {
+		ExpectWithOffset(1, db.LoadJSONFixture(table, "../../fixtures/"+table+".json")).To(BeNil())
+	}
+}
+
+// Set the password of every user in a backend to TestPassword
+func SetTestPasswords(db Backend, hasher PasswordHasher) {
+	hash, err := hasher.Hash(TestPassword)
+	ExpectWithOffset(1, err).To(BeNil())
+	users, casErr := db.GetAllUsers()
+	ExpectWithOffset(1, casErr).To(BeNil())
+	for _, user := range users {
+		user.Password = hash
+		ExpectWithOffset(1, db.UpdateUser(&user)).To(BeNil())
+	}
+}
+
+// Stop a test server's audit log, and close its backend (if it can be closed)
+func Close(server *CAS) {
+	server.AuditLog.Stop()
+	if db, ok := server.Db.(interface{ Close() }); ok {
+		db.Close()
+	}
+}
+
+// Client that makes requests through a server's handler, carrying the cookies its responses set (like a browser would)
+type Client struct {
+	Server  *CAS
+	Header  http.Header // Sent with every request
+	Cookies map[string]*http.Cookie
+}
+
+func NewClient(server *CAS) *Client {
+	return &Client{Server: server, Header: http.Header{}, Cookies: map[string]*http.Cookie{}}
+}
+
+// Make a request, collecting the cookies set by the response (cookies that are deleted are no longer sent)
+func (c *Client) Do(req *http.Request) *httptest.ResponseRecorder {
+	for name, values := range c.Header {
+		if len(req.Header[name]) == 0 {
+			req.Header[name] = values
+		}
+	}
+	for _, cookie := range c.Cookies {
+		req.AddCookie(cookie)
+	}
+
+	w := httptest.NewRecorder()
+	c.Server.Handler().ServeHTTP(w, req)
+
+	for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+		if cookie.MaxAge < 0 {
+			delete(c.Cookies, cookie.Name)
+		} else {
+			c.Cookies[cookie.Name] = cookie
+		}
+	}
+	return w
+}
+
+func (c *Client) Get(path string) *httptest.ResponseRecorder {
+	return c.Do(httptest.NewRequest("GET", path, nil))
+}
+
+func (c *Client) PostForm(path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.Do(req)
+}
+
+// Log in (through the login form, under the server's base path) as the test user, unless the form gives other
+// credentials
+func (c *Client) Login(form url.Values) *httptest.ResponseRecorder {
+	login := url.Values{"email": {TestUserEmail}, "password": {TestPassword}}
+	for name, values := range form {
+		login[name] = values
+	}
+	return c.PostForm(c.Server.URLPath("/login"), login)
+}
+
+// Log in as the test user (see Client.Login) with a new client
+func Login(server *CAS, form url.Values) *httptest.ResponseRecorder {
+	return NewClient(server).Login(form)
+}
+
+// Get the ticket a login redirected to its service with (empty if there was none)
+func Ticket(w *httptest.ResponseRecorder) string {
+	location, err := url.Parse(w.Header().Get("Location"))
+	ExpectWithOffset(1, err).To(BeNil())
+	return location.Query().Get("ticket")
+}
@@ -0,0 +1,35 @@
+package cas
+
+import (
+	"time"
+)
+
+/*
+ * Time sources
+ */
+
+// Source of the current time, used wherever ticket (and token) issuance and expiry are computed
+// Tests can replace the server's clock (CAS.Clock) to simulate expiry without sleeping
+type Clock interface {
+	Now() time.Time
+}
+
+// Clock reading the system time
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// Adapts a function to the Clock interface
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time { return f() }
+
+// Current time according to the server's clock
+func (c *CAS) now() time.Time {
+	if c.Clock == nil {
+		return time.Now()
+	}
+	return c.Clock.Now()
+}
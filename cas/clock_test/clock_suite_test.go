@@ -0,0 +1,12 @@
+package clock_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoClock(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Clock Suite")
+}
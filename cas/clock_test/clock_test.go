@@ -0,0 +1,181 @@
+package clock_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+// Clock that only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// JSON service response (format=JSON)
+type serviceResponse struct {
+	ServiceResponse struct {
+		AuthenticationSuccess *struct {
+			User string `json:"user"`
+		} `json:"authenticationSuccess"`
+		AuthenticationFailure *struct {
+			Code string `json:"code"`
+		} `json:"authenticationFailure"`
+		ProxySuccess *struct {
+			ProxyTicket string `json:"proxyTicket"`
+		} `json:"proxySuccess"`
+		ProxyFailure *struct {
+			Code string `json:"code"`
+		} `json:"proxyFailure"`
+	} `json:"serviceResponse"`
+}
+
+var _ = Describe("Injectable clock", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		clock  *fakeClock
+		client *castest.Client
+	)
+
+	// Perform a request against the server, passing along (and collecting) the client's cookies
+	do := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		var req *http.Request
+		if method == "POST" {
+			req, _ = http.NewRequest(method, path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(method, path+"?"+form.Encode(), nil)
+		}
+		return client.Do(req)
+	}
+
+	decode := func(w *httptest.ResponseRecorder) serviceResponse {
+		var response serviceResponse
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(BeNil())
+		return response
+	}
+
+	requestProxyTicket := func(pgtId string) serviceResponse {
+		return decode(do("GET", "/proxy", url.Values{"pgt": {pgtId}, "targetService": {testServiceUrl}, "format": {"JSON"}}))
+	}
+
+	validateProxyTicket := func(ticket string) serviceResponse {
+		return decode(do("GET", "/proxyValidate", url.Values{"service": {testServiceUrl}, "ticket": {ticket}, "format": {"JSON"}}))
+	}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+		clock = &fakeClock{now: time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)}
+		server.Clock = clock
+		client = castest.NewClient(server)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should default to the real clock", func() {
+		defaultServer, _ := castest.NewTestServer(nil)
+		defer castest.Close(defaultServer)
+		Expect(defaultServer.Clock).To(Equal(RealClock))
+		Expect(RealClock.Now()).To(BeTemporally("~", time.Now(), time.Second))
+	})
+
+	It("Should issue tickets that expire according to the server's clock", func() {
+		client.Login(nil)
+
+		tgts, casErr := db.FindTicketGrantingTicketsForUser("test@test.com")
+		Expect(casErr).To(BeNil())
+		Expect(tgts).To(HaveLen(1))
+		Expect(tgts[0].CreatedAt).To(Equal(clock.Now()))
+		Expect(tgts[0].ExpiresAt).To(Equal(clock.Now().Add(8 * time.Hour)))
+	})
+
+	It("Should stop single sign on once the clock passes the ticket-granting ticket's lifetime", func() {
+		w := client.Login(nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		// Gateway logins only succeed with a valid single sign on session
+		gateway := url.Values{"service": {testServiceUrl}, "gateway": {"true"}}
+		clock.Advance(8*time.Hour - time.Second)
+		w = do("GET", "/login", gateway)
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+
+		clock.Advance(time.Second)
+		w = do("GET", "/login", gateway)
+		Expect(w.Header().Get("Location")).NotTo(ContainSubstring("ticket="))
+	})
+
+	It("Should fail to validate a proxy ticket once the clock passes its lifetime", func() {
+		Expect(db.AddProxyGrantingTicket(&CASProxyGrantingTicket{
+			Id:        "PGT-1",
+			UserEmail: "test@test.com",
+			ExpiresAt: clock.Now().Add(2 * time.Hour),
+		})).To(BeNil())
+
+		response := requestProxyTicket("PGT-1")
+		Expect(response.ServiceResponse.ProxySuccess).NotTo(BeNil())
+		validated := validateProxyTicket(response.ServiceResponse.ProxySuccess.ProxyTicket)
+		Expect(validated.ServiceResponse.AuthenticationSuccess).NotTo(BeNil())
+		Expect(validated.ServiceResponse.AuthenticationSuccess.User).To(Equal("test@test.com"))
+
+		// Proxy tickets last ptTTL (10) seconds
+		response = requestProxyTicket("PGT-1")
+		Expect(response.ServiceResponse.ProxySuccess).NotTo(BeNil())
+		clock.Advance(10 * time.Second)
+		validated = validateProxyTicket(response.ServiceResponse.ProxySuccess.ProxyTicket)
+		Expect(validated.ServiceResponse.AuthenticationSuccess).To(BeNil())
+		Expect(validated.ServiceResponse.AuthenticationFailure).NotTo(BeNil())
+		Expect(validated.ServiceResponse.AuthenticationFailure.Code).To(Equal(CAS_INVALID_TICKET))
+	})
+
+	It("Should refuse to issue proxy tickets once the clock passes the proxy-granting ticket's lifetime", func() {
+		Expect(db.AddProxyGrantingTicket(&CASProxyGrantingTicket{
+			Id:        "PGT-1",
+			UserEmail: "test@test.com",
+			ExpiresAt: clock.Now().Add(2 * time.Hour),
+		})).To(BeNil())
+
+		clock.Advance(2 * time.Hour)
+		response := requestProxyTicket("PGT-1")
+		Expect(response.ServiceResponse.ProxySuccess).To(BeNil())
+		Expect(response.ServiceResponse.ProxyFailure).NotTo(BeNil())
+		Expect(response.ServiceResponse.ProxyFailure.Code).To(Equal(CAS_BAD_PGT))
+	})
+
+	It("Should sweep tickets that have expired according to the server's clock", func() {
+		client.Login(nil)
+
+		result, casErr := server.TicketSweeper.Sweep()
+		Expect(casErr).To(BeNil())
+		Expect(result.TicketGrantingTickets).To(Equal(0))
+
+		clock.Advance(8 * time.Hour)
+		result, casErr = server.TicketSweeper.Sweep()
+		Expect(casErr).To(BeNil())
+		Expect(result.TicketGrantingTickets).To(Equal(1))
+	})
+})
@@ -0,0 +1,12 @@
+package cluster_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoCluster(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Cluster Suite")
+}
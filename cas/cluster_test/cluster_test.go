@@ -0,0 +1,236 @@
+package cluster_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	serviceUrl  = "https://app.example.com/"
+	clientId    = "app"
+	redirectUri = serviceUrl
+	issuer      = "https://sso.example.com"
+)
+
+// Service response, as read by services
+type serviceResponse struct {
+	Success *struct {
+		User string `xml:"user"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code string `xml:"code,attr"`
+	} `xml:"authenticationFailure"`
+}
+
+// Backend that only exposes the Backend interface (hiding optional capabilities, like keeping OIDC codes)
+type plainBackend struct {
+	Backend
+}
+
+var _ = Describe("Clustered nodes", func() {
+	var (
+		shared  *MemoryBackend
+		backend Backend
+		nodes   []*CAS
+		client  *castest.Client
+	)
+
+	// Start a node using the shared backend, as each casgo instance behind a load balancer would
+	newNode := func(overrides map[string]string) *CAS {
+		config := castest.NewTestConfig(map[string]string{"dbBackend": "cluster-shared"})
+		for key, value := range overrides {
+			config[key] = value
+		}
+		node, err := NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		nodes = append(nodes, node)
+		return node
+	}
+
+	BeforeEach(func() {
+		nodes = nil
+		client = castest.NewClient(nil)
+		shared = NewMemoryBackend(0)
+		backend = shared
+		RegisterBackend("cluster-shared", func(c *CAS) (Backend, error) { return backend, nil })
+
+		castest.LoadFixtures(shared)
+		Expect(shared.AddNewService(&CASService{Name: clientId, Url: serviceUrl, AdminEmail: "admin@test.com"})).To(BeNil())
+		hasher, err := NewPasswordHasherFromConfig(CONFIG_DEFAULTS)
+		Expect(err).To(BeNil())
+		castest.SetTestPasswords(shared, hasher)
+	})
+
+	AfterEach(func() {
+		for _, node := range nodes {
+			node.AuditLog.Stop()
+		}
+		shared.Close()
+	})
+
+	// Send a request to a node, with the cookies set by earlier responses of any node (like a browser would)
+	do := func(node *CAS, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == "POST" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		client.Server = node
+		return client.Do(req)
+	}
+
+	// Get a service ticket from a node, logging in unless the browser has a login session
+	ticket := func(node *CAS) string {
+		var w *httptest.ResponseRecorder
+		if _, loggedIn := client.Cookies["casgo-session"]; loggedIn {
+			w = do(node, "GET", "/login?"+url.Values{"service": {serviceUrl}}.Encode(), "")
+		} else {
+			client.Server = node
+			w = client.Login(url.Values{"serviceUrl": {serviceUrl}})
+		}
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(castest.Ticket(w)).ToNot(BeEmpty())
+		return castest.Ticket(w)
+	}
+
+	// Validate a ticket on a node, like a service would (without cookies)
+	validate := func(node *CAS, path, ticket string) serviceResponse {
+		w := castest.NewClient(node).Get(path + "?" + url.Values{"service": {serviceUrl}, "ticket": {ticket}}.Encode())
+
+		var response serviceResponse
+		Expect(xml.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		return response
+	}
+
+	It("Should validate service tickets on another node than the one that issued them, once", func() {
+		a, b := newNode(nil), newNode(nil)
+		for _, path := range []string{"/serviceValidate", "/p3/serviceValidate", "/proxyValidate"} {
+			client.Cookies = map[string]*http.Cookie{}
+			st := ticket(a)
+			response := validate(b, path, st)
+			Expect(response.Success).ToNot(BeNil(), path)
+			Expect(response.Success.User).To(Equal("test@test.com"))
+
+			// Neither node accepts it again
+			for _, node := range []*CAS{a, b} {
+				response = validate(node, path, st)
+				Expect(response.Failure).ToNot(BeNil(), path)
+				Expect(response.Failure.Code).To(Equal(CAS_INVALID_TICKET), path)
+			}
+		}
+	})
+
+	It("Should only let one node validate a ticket when two try at once", func() {
+		a, b := newNode(nil), newNode(nil)
+		for round := 0; round < 25; round++ {
+			st := ticket(a)
+
+			start := make(chan struct{})
+			responses := make([]serviceResponse, 2)
+			var wg sync.WaitGroup
+			for i, node := range []*CAS{a, b} {
+				wg.Add(1)
+				go func(i int, node *CAS) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					<-start
+					responses[i] = validate(node, "/serviceValidate", st)
+				}(i, node)
+			}
+			close(start)
+			wg.Wait()
+
+			successes := 0
+			for _, response := range responses {
+				if response.Success != nil {
+					successes++
+				}
+			}
+			Expect(successes).To(Equal(1), "round %d", round)
+		}
+	})
+
+	It("Should log users in with single sign on through any node", func() {
+		a, b := newNode(nil), newNode(nil)
+		Expect(validate(a, "/serviceValidate", ticket(a)).Success).ToNot(BeNil())
+
+		// The login session (ticket-granting ticket) started on one node issues tickets on the other
+		st := ticket(b)
+		Expect(validate(a, "/serviceValidate", st).Success).ToNot(BeNil())
+	})
+
+	It("Should end single sign on on every node when the user logs out of one", func() {
+		a, b := newNode(nil), newNode(nil)
+		ticket(a)
+		session := client.Cookies["casgo-session"]
+
+		Expect(do(b, "GET", "/logout", "").Code).To(Equal(http.StatusOK))
+
+		// A browser still holding the old session cookie isn't logged in on the other node
+		client.Cookies = map[string]*http.Cookie{"casgo-session": session}
+		w := do(a, "GET", "/login?"+url.Values{"service": {serviceUrl}}.Encode(), "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Location")).To(BeEmpty())
+	})
+
+	Describe("OpenID Connect", func() {
+		var a, b *CAS
+
+		authorize := func(node *CAS) string {
+			query := url.Values{"response_type": {"code"}, "client_id": {clientId}, "redirect_uri": {redirectUri}, "scope": {"openid"}}
+			w := do(node, "GET", OIDC_AUTHORIZE_PATH+"?"+query.Encode(), "")
+			Expect(w.Code).To(Equal(http.StatusFound))
+			location, err := url.Parse(w.Header().Get("Location"))
+			Expect(err).To(BeNil())
+			Expect(location.Query().Get("code")).ToNot(BeEmpty())
+			return location.Query().Get("code")
+		}
+
+		exchange := func(node *CAS, code string) (int, map[string]interface{}) {
+			form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "client_id": {clientId}, "redirect_uri": {redirectUri}}
+			w := do(node, "POST", OIDC_TOKEN_PATH, form.Encode())
+			var response map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+			return w.Code, response
+		}
+
+		startNodes := func() {
+			oidc := map[string]string{"oidcEnabled": "true", "oidcIssuer": issuer}
+			a, b = newNode(oidc), newNode(oidc)
+			ticket(a)
+		}
+
+		It("Should redeem authorization codes on another node than the one that issued them, once", func() {
+			startNodes()
+			code := authorize(a)
+
+			status, response := exchange(b, code)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response["id_token"]).ToNot(BeEmpty())
+
+			for _, node := range []*CAS{a, b} {
+				status, response = exchange(node, code)
+				Expect(status).To(Equal(http.StatusBadRequest))
+				Expect(response["error"]).To(Equal("invalid_grant"))
+			}
+		})
+
+		It("Should keep codes on the node that issued them with backends that can't store them", func() {
+			backend = plainBackend{shared}
+			startNodes()
+
+			status, _ := exchange(b, authorize(a))
+			Expect(status).To(Equal(http.StatusBadRequest))
+			status, _ = exchange(a, authorize(a))
+			Expect(status).To(Equal(http.StatusOK))
+		})
+	})
+})
@@ -1,44 +1,315 @@
 package cas
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
 var CONFIG_ENV_OVERRIDE_MAP map[string]string = map[string]string{
-	"host":               "CASGO_HOST",
-	"port":               "CASGO_PORT",
-	"dbHost":             "CASGO_DBHOST",
-	"dbName":             "CASGO_DBNAME",
-	"cookieSecret":       "CASGO_SECRET",
-	"templatesDirectory": "CASGO_TEMPLATES",
-	"companyName":        "CASGO_COMPNAME",
-	"authMethod":         "CASGO_DEFAULT_AUTH",
-	"logLevel":           "CASGO_LOG_LVL",
-	"tlsCertFile":        "CASGO_TLS_CERT",
-	"tlsKeyFile":         "CASGO_TLS_KEY",
+	"host":                   "CASGO_HOST",
+	"port":                   "CASGO_PORT",
+	"basePath":               "CASGO_BASE_PATH",
+	"staticPath":             "CASGO_STATIC_PATH",
+	"staticDirectory":        "CASGO_STATIC_DIRECTORY",
+	"staticMaxAge":           "CASGO_STATIC_MAX_AGE",
+	"dbHost":                 "CASGO_DBHOST",
+	"dbName":                 "CASGO_DBNAME",
+	"dbBackend":              "CASGO_DB_BACKEND",
+	"ticketSweepInterval":    "CASGO_TICKET_SWEEP_INTERVAL",
+	"cookieSecret":           "CASGO_SECRET",
+	"templatesDirectory":     "CASGO_TEMPLATES",
+	"companyName":            "CASGO_COMPNAME",
+	"authMethod":             "CASGO_DEFAULT_AUTH",
+	"logLevel":               "CASGO_LOG_LVL",
+	"logFormat":              "CASGO_LOG_FORMAT",
+	"tlsCertFile":            "CASGO_TLS_CERT",
+	"tlsKeyFile":             "CASGO_TLS_KEY",
+	"tlsMinVersion":          "CASGO_TLS_MIN_VERSION",
+	"tlsCipherSuites":        "CASGO_TLS_CIPHER_SUITES",
+	"pgtTTL":                 "CASGO_PGT_TTL",
+	"ptTTL":                  "CASGO_PT_TTL",
+	"stTTL":                  "CASGO_ST_TTL",
+	"ticketGenerator":        "CASGO_TICKET_GENERATOR",
+	"ticketNodePrefix":       "CASGO_TICKET_NODE_PREFIX",
+	"cookieSecure":           "CASGO_COOKIE_SECURE",
+	"cookieHttpOnly":         "CASGO_COOKIE_HTTPONLY",
+	"cookieSameSite":         "CASGO_COOKIE_SAMESITE",
+	"cookieDomain":           "CASGO_COOKIE_DOMAIN",
+	"cookieMaxAge":           "CASGO_COOKIE_MAX_AGE",
+	"ldapUrl":                "CASGO_LDAP_URL",
+	"ldapStartTLS":           "CASGO_LDAP_STARTTLS",
+	"ldapInsecureSkipVerify": "CASGO_LDAP_INSECURE_SKIP_VERIFY",
+	"ldapCACertFile":         "CASGO_LDAP_CA_CERT",
+	"ldapBindDN":             "CASGO_LDAP_BIND_DN",
+	"ldapBindPassword":       "CASGO_LDAP_BIND_PASSWORD",
+	"ldapBaseDN":             "CASGO_LDAP_BASE_DN",
+	"ldapSearchFilter":       "CASGO_LDAP_SEARCH_FILTER",
+	"ldapAttributeMap":       "CASGO_LDAP_ATTRIBUTE_MAP",
+	"ldapPoolSize":           "CASGO_LDAP_POOL_SIZE",
+	"ldapTimeout":            "CASGO_LDAP_TIMEOUT",
+	"metricsEnabled":         "CASGO_METRICS_ENABLED",
+	"metricsAddr":            "CASGO_METRICS_ADDR",
+	"loginRateLimit":         "CASGO_LOGIN_RATE_LIMIT",
+	"loginRateWindow":        "CASGO_LOGIN_RATE_WINDOW",
+	"trustedProxies":         "CASGO_TRUSTED_PROXIES",
+	"clientIpHeader":         "CASGO_CLIENT_IP_HEADER",
+	"trustedHeaderAuth":      "CASGO_TRUSTED_HEADER_AUTH",
+	"trustedHeaderName":      "CASGO_TRUSTED_HEADER_NAME",
+	"trustedHeaderProvision": "CASGO_TRUSTED_HEADER_PROVISION",
+	"loginExtraFields":       "CASGO_LOGIN_EXTRA_FIELDS",
+	"delegationEnabled":      "CASGO_DELEGATION_ENABLED",
+	"delegationCasUrl":       "CASGO_DELEGATION_CAS_URL",
+	"delegationName":         "CASGO_DELEGATION_NAME",
+	"delegationCallbackUrl":  "CASGO_DELEGATION_CALLBACK_URL",
+	"delegationAttributeMap": "CASGO_DELEGATION_ATTRIBUTE_MAP",
+	"delegationProvision":    "CASGO_DELEGATION_PROVISION",
+	"delegationTimeout":      "CASGO_DELEGATION_TIMEOUT",
+	"sloWorkers":             "CASGO_SLO_WORKERS",
+	"sloTimeout":             "CASGO_SLO_TIMEOUT",
+	"workerStallTimeout":     "CASGO_WORKER_STALL_TIMEOUT",
+	"sessionSerializer":      "CASGO_SESSION_SERIALIZER",
+	"sessionStore":           "CASGO_SESSION_STORE",
+	"sessionRedisPrefix":     "CASGO_SESSION_REDIS_PREFIX",
+	"redisAddr":              "CASGO_REDIS_ADDR",
+	"redisPassword":          "CASGO_REDIS_PASSWORD",
+	"redisDB":                "CASGO_REDIS_DB",
+	"redisPoolSize":          "CASGO_REDIS_POOL_SIZE",
+	"redisTimeout":           "CASGO_REDIS_TIMEOUT",
+	"tgtTTL":                 "CASGO_TGT_TTL",
+	"tgtMaxServiceTickets":   "CASGO_TGT_MAX_SERVICE_TICKETS",
+	"tgtTicketRateLimit":     "CASGO_TGT_TICKET_RATE_LIMIT",
+	"tgtTicketRateWindow":    "CASGO_TGT_TICKET_RATE_WINDOW",
+	"rememberMeEnabled":      "CASGO_REMEMBER_ME_ENABLED",
+	"rememberMeTTL":          "CASGO_REMEMBER_ME_TTL",
+	"passwordHashAlgorithm":  "CASGO_PASSWORD_HASH_ALGORITHM",
+	"passwordMinLength":      "CASGO_PASSWORD_MIN_LENGTH",
+	"passwordCharClasses":    "CASGO_PASSWORD_CHAR_CLASSES",
+	"passwordDenylistCommon": "CASGO_PASSWORD_DENYLIST_COMMON",
+	"passwordDenylistFile":   "CASGO_PASSWORD_DENYLIST_FILE",
+	"bcryptCost":             "CASGO_BCRYPT_COST",
+	"dbPoolMinSize":          "CASGO_DB_POOL_MIN_SIZE",
+	"dbPoolMaxSize":          "CASGO_DB_POOL_MAX_SIZE",
+	"dbPoolTimeout":          "CASGO_DB_POOL_TIMEOUT",
+	"dbHealthCheckInterval":  "CASGO_DB_HEALTH_CHECK_INTERVAL",
+	"dbReconnectMaxBackoff":  "CASGO_DB_RECONNECT_MAX_BACKOFF",
+	"dbQueryTimeout":         "CASGO_DB_QUERY_TIMEOUT",
+	"dbAutoMigrate":          "CASGO_DB_AUTO_MIGRATE",
+	"apiTokenSecret":         "CASGO_API_TOKEN_SECRET",
+	"apiTokenTTL":            "CASGO_API_TOKEN_TTL",
+	"corsAllowedOrigins":     "CASGO_CORS_ALLOWED_ORIGINS",
+	"corsAllowedMethods":     "CASGO_CORS_ALLOWED_METHODS",
+	"corsAllowedHeaders":     "CASGO_CORS_ALLOWED_HEADERS",
+	"corsAllowCredentials":   "CASGO_CORS_ALLOW_CREDENTIALS",
+	"corsMaxAge":             "CASGO_CORS_MAX_AGE",
+	"totpIssuer":             "CASGO_TOTP_ISSUER",
+	"totpSkew":               "CASGO_TOTP_SKEW",
+	"totpLoginTTL":           "CASGO_TOTP_LOGIN_TTL",
+	"shutdownTimeout":        "CASGO_SHUTDOWN_TIMEOUT",
+	"requireHTTPSServices":   "CASGO_REQUIRE_HTTPS_SERVICES",
+	"httpsServiceExceptions": "CASGO_HTTPS_SERVICE_EXCEPTIONS",
+	"registeredServicesOnly": "CASGO_REGISTERED_SERVICES_ONLY",
+	"normalizeServiceUrls":   "CASGO_NORMALIZE_SERVICE_URLS",
+	"serviceUrlKeepQuery":    "CASGO_SERVICE_URL_KEEP_QUERY",
+	"serviceUrlKeepFragment": "CASGO_SERVICE_URL_KEEP_FRAGMENT",
+	"healthPath":             "CASGO_HEALTH_PATH",
+	"readyPath":              "CASGO_READY_PATH",
+	"readyTimeout":           "CASGO_READY_TIMEOUT",
+	"readyFailureThreshold":  "CASGO_READY_FAILURE_THRESHOLD",
+	"serviceWarningEnabled":  "CASGO_SERVICE_WARNING_ENABLED",
+	"brandingAppName":        "CASGO_BRANDING_APP_NAME",
+	"brandingLogoUrl":        "CASGO_BRANDING_LOGO_URL",
+	"brandingPrimaryColor":   "CASGO_BRANDING_PRIMARY_COLOR",
+	"brandingCustomCssUrl":   "CASGO_BRANDING_CUSTOM_CSS_URL",
+	"defaultLocale":          "CASGO_DEFAULT_LOCALE",
+	"auditEnabled":           "CASGO_AUDIT_ENABLED",
+	"auditQueueSize":         "CASGO_AUDIT_QUEUE_SIZE",
+	"webhooksFile":           "CASGO_WEBHOOKS_FILE",
+	"webhookWorkers":         "CASGO_WEBHOOK_WORKERS",
+	"webhookQueueSize":       "CASGO_WEBHOOK_QUEUE_SIZE",
+	"webhookTimeout":         "CASGO_WEBHOOK_TIMEOUT",
+	"webhookMaxAttempts":     "CASGO_WEBHOOK_MAX_ATTEMPTS",
+	"webhookMaxBackoff":      "CASGO_WEBHOOK_MAX_BACKOFF",
+	"csrfEnabled":            "CASGO_CSRF_ENABLED",
+	"logoutRedirect":         "CASGO_LOGOUT_REDIRECT",
+	"securityHeadersEnabled": "CASGO_SECURITY_HEADERS_ENABLED",
+	"securityHeadersOnApi":   "CASGO_SECURITY_HEADERS_ON_API",
+	"contentSecurityPolicy":  "CASGO_CONTENT_SECURITY_POLICY",
+	"frameOptions":           "CASGO_FRAME_OPTIONS",
+	"contentTypeOptions":     "CASGO_CONTENT_TYPE_OPTIONS",
+	"hstsMaxAge":             "CASGO_HSTS_MAX_AGE",
+	"samlSigningEnabled":     "CASGO_SAML_SIGNING_ENABLED",
+	"samlSigningKeyFile":     "CASGO_SAML_SIGNING_KEY_FILE",
+	"samlSigningCertFile":    "CASGO_SAML_SIGNING_CERT_FILE",
+	"samlAssertionTTL":       "CASGO_SAML_ASSERTION_TTL",
+	"oidcEnabled":            "CASGO_OIDC_ENABLED",
+	"oidcIssuer":             "CASGO_OIDC_ISSUER",
+	"oidcSigningKeyFile":     "CASGO_OIDC_SIGNING_KEY_FILE",
+	"oidcTokenTTL":           "CASGO_OIDC_TOKEN_TTL",
+	"oidcCodeTTL":            "CASGO_OIDC_CODE_TTL",
+	"apiMaxBodySize":         "CASGO_API_MAX_BODY_SIZE",
+	"apiResponseEnvelope":    "CASGO_API_RESPONSE_ENVELOPE",
+	"requireServiceIfMatch":  "CASGO_REQUIRE_SERVICE_IF_MATCH",
 }
 
 var CONFIG_DEFAULTS map[string]string = map[string]string{
-	"host":               "0.0.0.0",
-	"port":               "9090",
-	"dbHost":             "localhost:28015",
-	"dbName":             "casgo",
-	"cookieSecret":       "secret-casgo-secret",
-	"templatesDirectory": "templates/",
-	"companyName":        "companyABC",
-	"authMethod":         "password",
-	"logLevel":           "WARN",
-	"tlsCertFile":        "fixtures/ssl/cert.pem",
-	"tlsKeyFile":         "fixtures/ssl/eckey.pem",
+	"host":                   "0.0.0.0",
+	"port":                   "9090",
+	"basePath":               "",
+	"staticPath":             "/public/",
+	"staticDirectory":        "",
+	"staticMaxAge":           "3600",
+	"dbHost":                 "localhost:28015",
+	"dbName":                 "casgo",
+	"dbBackend":              "rethinkdb",
+	"ticketSweepInterval":    "60",
+	"cookieSecret":           "secret-casgo-secret",
+	"templatesDirectory":     "templates/",
+	"companyName":            "companyABC",
+	"authMethod":             "password",
+	"logLevel":               "info",
+	"logFormat":              "text",
+	"tlsCertFile":            "fixtures/ssl/cert.pem",
+	"tlsKeyFile":             "fixtures/ssl/eckey.pem",
+	"tlsMinVersion":          "1.2",
+	"tlsCipherSuites":        "",
+	"pgtTTL":                 "7200",
+	"ptTTL":                  "10",
+	"stTTL":                  "10",
+	"ticketGenerator":        "default",
+	"ticketNodePrefix":       "",
+	"cookieSecure":           "false",
+	"cookieHttpOnly":         "true",
+	"cookieSameSite":         "lax",
+	"cookieDomain":           "",
+	"cookieMaxAge":           "0",
+	"ldapUrl":                "ldap://localhost:389",
+	"ldapStartTLS":           "false",
+	"ldapInsecureSkipVerify": "false",
+	"ldapCACertFile":         "",
+	"ldapBindDN":             "",
+	"ldapBindPassword":       "",
+	"ldapBaseDN":             "",
+	"ldapSearchFilter":       "(mail=%s)",
+	"ldapAttributeMap":       "mail:email,displayName:name,memberOf:memberOf",
+	"ldapPoolSize":           "5",
+	"ldapTimeout":            "10",
+	"metricsEnabled":         "false",
+	"metricsAddr":            "",
+	"loginRateLimit":         "10",
+	"loginRateWindow":        "300",
+	"trustedProxies":         "",
+	"clientIpHeader":         "X-Forwarded-For",
+	"trustedHeaderAuth":      "false",
+	"trustedHeaderName":      "X-Remote-User",
+	"trustedHeaderProvision": "false",
+	"loginExtraFields":       "",
+	"delegationEnabled":      "false",
+	"delegationCasUrl":       "",
+	"delegationName":         "",
+	"delegationCallbackUrl":  "",
+	"delegationAttributeMap": "mail:email,displayName:name",
+	"delegationProvision":    "false",
+	"delegationTimeout":      "10",
+	"sloWorkers":             "5",
+	"sloTimeout":             "5",
+	"workerStallTimeout":     "120",
+	"sessionSerializer":      "json",
+	"sessionStore":           "cookie",
+	"sessionRedisPrefix":     "casgo:session:",
+	"redisAddr":              "localhost:6379",
+	"redisPassword":          "",
+	"redisDB":                "0",
+	"redisPoolSize":          "10",
+	"redisTimeout":           "5",
+	"tgtTTL":                 "28800",
+	"tgtMaxServiceTickets":   "0",
+	"tgtTicketRateLimit":     "0",
+	"tgtTicketRateWindow":    "60",
+	"rememberMeEnabled":      "true",
+	"rememberMeTTL":          "2592000",
+	"passwordHashAlgorithm":  "bcrypt",
+	"passwordMinLength":      "8",
+	"passwordCharClasses":    "letter,digit",
+	"passwordDenylistCommon": "true",
+	"passwordDenylistFile":   "",
+	"bcryptCost":             "10",
+	"dbPoolMinSize":          "2",
+	"dbPoolMaxSize":          "10",
+	"dbPoolTimeout":          "5",
+	"dbHealthCheckInterval":  "10",
+	"dbReconnectMaxBackoff":  "30",
+	"dbQueryTimeout":         "10",
+	"dbAutoMigrate":          "true",
+	"apiTokenSecret":         "",
+	"apiTokenTTL":            "900",
+	"corsAllowedOrigins":     "",
+	"corsAllowedMethods":     "GET,POST,PUT,DELETE",
+	"corsAllowedHeaders":     "Content-Type,Authorization,X-Api-Key,X-Api-Secret",
+	"corsAllowCredentials":   "false",
+	"corsMaxAge":             "600",
+	"totpIssuer":             "",
+	"totpSkew":               "1",
+	"totpLoginTTL":           "300",
+	"shutdownTimeout":        "30",
+	"requireHTTPSServices":   "false",
+	"httpsServiceExceptions": "",
+	"registeredServicesOnly": "true",
+	"normalizeServiceUrls":   "true",
+	"serviceUrlKeepQuery":    "true",
+	"serviceUrlKeepFragment": "false",
+	"healthPath":             "/healthz",
+	"readyPath":              "/readyz",
+	"readyTimeout":           "2",
+	"readyFailureThreshold":  "3",
+	"serviceWarningEnabled":  "true",
+	"brandingAppName":        "CasGo",
+	"brandingLogoUrl":        "",
+	"brandingPrimaryColor":   "",
+	"brandingCustomCssUrl":   "",
+	"defaultLocale":          "en",
+	"auditEnabled":           "true",
+	"auditQueueSize":         "1024",
+	"webhooksFile":           "",
+	"webhookWorkers":         "4",
+	"webhookQueueSize":       "256",
+	"webhookTimeout":         "5",
+	"webhookMaxAttempts":     "5",
+	"webhookMaxBackoff":      "60",
+	"csrfEnabled":            "true",
+	"logoutRedirect":         "true",
+	"securityHeadersEnabled": "true",
+	"securityHeadersOnApi":   "true",
+	"contentSecurityPolicy":  "",
+	"frameOptions":           "DENY",
+	"contentTypeOptions":     "nosniff",
+	"hstsMaxAge":             "31536000",
+	"samlSigningEnabled":     "false",
+	"samlSigningKeyFile":     "",
+	"samlSigningCertFile":    "",
+	"samlAssertionTTL":       "30",
+	"oidcEnabled":            "false",
+	"oidcIssuer":             "",
+	"oidcSigningKeyFile":     "",
+	"oidcTokenTTL":           "300",
+	"oidcCodeTTL":            "60",
+	"apiMaxBodySize":         "1048576",
+	"apiResponseEnvelope":    "raw",
+	"requireServiceIfMatch":  "false",
 }
 
 // Create default casgo configuration, with user overrides if any
 func NewCASServerConfig(configFilePath string) (map[string]string, error) {
+	return NewCASServerConfigWithOverrides(configFilePath, nil)
+}
+
+// Create casgo configuration from the defaults, overridden by the file (if any), then ENV, then the given overrides
+// (ex. from flags, see ConfigFlags)
+func NewCASServerConfigWithOverrides(configFilePath string, overrides map[string]string) (map[string]string, error) {
 	// Set default config values
 	serverConfig := make(map[string]string)
 	for k, v := range CONFIG_DEFAULTS {
@@ -57,11 +328,9 @@ func NewCASServerConfig(configFilePath string) (map[string]string, error) {
 			log.Printf("[WARNING] Loaded configuration file was empty")
 		}
 
-		// Load JSON
-		fileConfig := make(map[string]string)
-		err = json.Unmarshal(buf, &fileConfig)
+		fileConfig, err := parseConfigFile(configFilePath, buf)
 		if err != nil {
-			return nil, fmt.Errorf("[ERROR] Failed to unmarshal JSON in configuration file [%s], %v", configFilePath, err)
+			return nil, err
 		}
 
 		// Copy values from loaded file config to actual server config
@@ -71,8 +340,11 @@ func NewCASServerConfig(configFilePath string) (map[string]string, error) {
 
 	}
 
-	// Override config with what is stored in env
+	// Override config with what is stored in env, then with explicit overrides
 	serverConfig = overrideConfigWithEnv(serverConfig)
+	for k, v := range overrides {
+		serverConfig[k] = v
+	}
 
 	// Update filepath with absolute path
 	absDirPath, err := filepath.Abs(serverConfig["templatesDirectory"])
@@ -94,3 +366,14 @@ func overrideConfigWithEnv(config map[string]string) map[string]string {
 	}
 	return config
 }
+
+// Get a configuration value specified in seconds as a duration
+// Falls back to the default value if the configured value is not a valid number of seconds
+func configSecondsAsDuration(config map[string]string, key string) time.Duration {
+	seconds, err := strconv.Atoi(config[key])
+	if err != nil || seconds < 0 {
+		log.Printf("[WARNING] Invalid value [%s] for configuration key [%s], using default", config[key], key)
+		seconds, _ = strconv.Atoi(CONFIG_DEFAULTS[key])
+	}
+	return time.Duration(seconds) * time.Second
+}
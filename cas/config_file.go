@@ -0,0 +1,207 @@
+package cas
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Configuration files (JSON, YAML or TOML) and flags
+ */
+
+// Parse a configuration file, in the format given by its extension (.yaml/.yml or .toml, JSON otherwise)
+// Configuration is flat (every option is a top-level key), so only the subset of YAML and TOML needed to
+// write key/value pairs is supported
+func parseConfigFile(configFilePath string, buf []byte) (map[string]string, error) {
+	var (
+		fileConfig map[string]string
+		err        error
+		format     string
+	)
+	switch strings.ToLower(filepath.Ext(configFilePath)) {
+	case ".yaml", ".yml":
+		format = "YAML"
+		fileConfig, err = parseKeyValueConfig(buf, ":", parseYAMLValue)
+	case ".toml":
+		format = "TOML"
+		fileConfig, err = parseKeyValueConfig(buf, "=", parseTOMLValue)
+	default:
+		format = "JSON"
+		fileConfig = make(map[string]string)
+		err = json.Unmarshal(buf, &fileConfig)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Failed to unmarshal %s in configuration file [%s], %v", format, configFilePath, err)
+	}
+
+	// Catch misspelled options, which would otherwise silently be left at their defaults
+	unknownKeys := []string{}
+	for key := range fileConfig {
+		if _, known := CONFIG_DEFAULTS[key]; !known {
+			unknownKeys = append(unknownKeys, key)
+		}
+	}
+	if len(unknownKeys) > 0 {
+		sort.Strings(unknownKeys)
+		return nil, fmt.Errorf("[ERROR] Unknown configuration keys [%s] in configuration file [%s]", strings.Join(unknownKeys, ", "), configFilePath)
+	}
+
+	return fileConfig, nil
+}
+
+// Parse "key<separator>value" lines, ignoring blank lines and comments (starting with #)
+func parseKeyValueConfig(buf []byte, separator string, parseValue func(string) (string, error)) (map[string]string, error) {
+	config := map[string]string{}
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimRight(line, "\r")
+		trimmed := strings.TrimSpace(line)
+		if len(trimmed) == 0 || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			return nil, fmt.Errorf("line %d: nested values are not supported", i+1)
+		}
+		if strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "- ") {
+			return nil, fmt.Errorf("line %d: tables and lists are not supported", i+1)
+		}
+
+		sep := strings.Index(trimmed, separator)
+		if sep <= 0 {
+			return nil, fmt.Errorf("line %d: expected key %s value", i+1, separator)
+		}
+		key := strings.TrimSpace(trimmed[:sep])
+		value, err := parseValue(strings.TrimSpace(trimmed[sep+len(separator):]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		if _, duplicate := config[key]; duplicate {
+			return nil, fmt.Errorf("line %d: duplicate key [%s]", i+1, key)
+		}
+		config[key] = value
+	}
+	return config, nil
+}
+
+// Parse a YAML scalar: a double-quoted string (with escapes), a single-quoted string (quotes doubled) or plain text
+func parseYAMLValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"`):
+		return parseDoubleQuoted(raw)
+	case strings.HasPrefix(raw, "'"):
+		end := 1
+		for ; end < len(raw); end++ {
+			if raw[end] == '\'' {
+				if end+1 < len(raw) && raw[end+1] == '\'' {
+					end++
+					continue
+				}
+				break
+			}
+		}
+		if end >= len(raw) {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if err := checkTrailingComment(raw[end+1:]); err != nil {
+			return "", err
+		}
+		return strings.Replace(raw[1:end], "''", "'", -1), nil
+	case strings.HasPrefix(raw, "{") || strings.HasPrefix(raw, "[") || strings.HasPrefix(raw, "|") || strings.HasPrefix(raw, ">"):
+		return "", fmt.Errorf("mappings, lists and block scalars are not supported")
+	}
+
+	if comment := strings.Index(raw, " #"); comment >= 0 {
+		raw = raw[:comment]
+	}
+	return strings.TrimSpace(raw), nil
+}
+
+// Parse a TOML value: a basic (double-quoted) or literal (single-quoted) string, a boolean or a number
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case strings.HasPrefix(raw, `"""`) || strings.HasPrefix(raw, "'''"):
+		return "", fmt.Errorf("multi-line strings are not supported")
+	case strings.HasPrefix(raw, `"`):
+		return parseDoubleQuoted(raw)
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string")
+		}
+		if err := checkTrailingComment(raw[end+2:]); err != nil {
+			return "", err
+		}
+		return raw[1 : end+1], nil
+	case strings.HasPrefix(raw, "[") || strings.HasPrefix(raw, "{"):
+		return "", fmt.Errorf("arrays and inline tables are not supported")
+	}
+
+	if comment := strings.Index(raw, "#"); comment >= 0 {
+		raw = strings.TrimSpace(raw[:comment])
+	}
+	if raw == "true" || raw == "false" {
+		return raw, nil
+	}
+	if _, err := strconv.ParseFloat(strings.Replace(raw, "_", "", -1), 64); err == nil {
+		return strings.Replace(raw, "_", "", -1), nil
+	}
+	return "", fmt.Errorf("invalid value [%s], strings must be quoted", raw)
+}
+
+// Parse a double-quoted string with escapes, followed by nothing but a comment
+func parseDoubleQuoted(raw string) (string, error) {
+	quoted, err := strconv.QuotedPrefix(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted string %s", raw)
+	}
+	if err := checkTrailingComment(raw[len(quoted):]); err != nil {
+		return "", err
+	}
+	return strconv.Unquote(quoted)
+}
+
+func checkTrailingComment(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if len(rest) > 0 && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected [%s] after value", rest)
+	}
+	return nil
+}
+
+// Flags of the configuration: -config (the file to read) and one per option (ex. -dbHost), which override both the
+// file and ENV
+type ConfigFlags struct {
+	FilePath string
+	values   map[string]*string
+	flags    *flag.FlagSet
+}
+
+// Define the configuration flags on a flag set
+func NewConfigFlags(flags *flag.FlagSet) *ConfigFlags {
+	configFlags := &ConfigFlags{values: map[string]*string{}, flags: flags}
+	flags.StringVar(&configFlags.FilePath, "config", "", "Read configuration from specified (JSON, YAML or TOML) file")
+	for key, defaultValue := range CONFIG_DEFAULTS {
+		configFlags.values[key] = flags.String(key, "", fmt.Sprintf("Override the %s option (default %q)", key, defaultValue))
+	}
+	return configFlags
+}
+
+// Values of the options set with flags (once the flag set was parsed)
+func (f *ConfigFlags) Overrides() map[string]string {
+	overrides := map[string]string{}
+	f.flags.Visit(func(set *flag.Flag) {
+		if value, ok := f.values[set.Name]; ok {
+			overrides[set.Name] = *value
+		}
+	})
+	return overrides
+}
+
+// Create the configuration given by the parsed flags
+func (f *ConfigFlags) Config() (map[string]string, error) {
+	return NewCASServerConfigWithOverrides(f.FilePath, f.Overrides())
+}
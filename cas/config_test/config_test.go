@@ -1,9 +1,11 @@
 package config_test
 
 import (
+	"flag"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/t3hmrman/casgo/cas"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 )
@@ -15,6 +17,13 @@ var _ = Describe("CasGo Config", func() {
 			Expect(err).To(BeNil())
 			Expect(config).ToNot(BeNil())
 		})
+
+		It("Should create servers from a configuration missing options, using their defaults (as with nil)", func() {
+			server, err := NewCASServerWithLogger(map[string]string{"dbBackend": "memory", "templatesDirectory": "../templates"}, NoopLogger{})
+			Expect(err).To(BeNil())
+			Expect(server.ClientIPHeader).To(Equal(CONFIG_DEFAULTS["clientIpHeader"]))
+			server.AuditLog.Stop()
+		})
 	})
 
 	Describe("Config defaults", func() {
@@ -73,6 +82,225 @@ var _ = Describe("CasGo Config", func() {
 			Expect(config["dbName"]).To(Equal("TEST_DB_NAME"))
 		})
 
+		It("Should read YAML files", func() {
+			config, err := NewCASServerConfig("../../fixtures/valid-config.yaml")
+			Expect(err).To(BeNil())
+			Expect(config["dbName"]).To(Equal("TEST_DB_NAME"))
+			Expect(config["companyName"]).To(Equal(`Casgo "Testing" Company`))
+			Expect(config["host"]).To(Equal("cas.example.com"))
+			Expect(config["port"]).To(Equal("8443"))
+		})
+
+		It("Should read TOML files", func() {
+			config, err := NewCASServerConfig("../../fixtures/valid-config.toml")
+			Expect(err).To(BeNil())
+			Expect(config["dbName"]).To(Equal("TEST_DB_NAME"))
+			Expect(config["companyName"]).To(Equal("Casgo Testing Company"))
+			Expect(config["port"]).To(Equal("8443"))
+			Expect(config["csrfEnabled"]).To(Equal("false"))
+		})
+
+		It("Should fail on files with unsupported syntax", func() {
+			dir, err := ioutil.TempDir("", "casgo-config")
+			Expect(err).To(BeNil())
+			defer os.RemoveAll(dir)
+
+			for name, contents := range map[string]string{
+				"nested.yaml":   "db:\n  name: casgo\n",
+				"list.yaml":     "- dbName\n",
+				"unquoted.toml": "dbName = casgo\n",
+				"table.toml":    "[db]\nname = \"casgo\"\n",
+				"trailing.toml": "dbName = \"casgo\" extra\n",
+			} {
+				path := filepath.Join(dir, name)
+				Expect(ioutil.WriteFile(path, []byte(contents), 0600)).To(Succeed())
+				config, err := NewCASServerConfig(path)
+				Expect(err).To(HaveOccurred(), name)
+				Expect(config).To(BeNil())
+			}
+		})
+
+		It("Should report unknown keys", func() {
+			config, err := NewCASServerConfig("../../fixtures/unknown-key-config.yaml")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("[dbHots]"))
+			Expect(config).To(BeNil())
+		})
+
+		It("Should reject invalid values at startup", func() {
+			config, err := NewCASServerConfig("../../fixtures/invalid-value-config.toml")
+			Expect(err).To(BeNil())
+			config["dbBackend"] = "memory"
+			_, err = NewCASServerWithLogger(config, NoopLogger{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("loginRateLimit"))
+		})
+	})
+
+	Describe("Config precedence", func() {
+		var previousEnvValue string
+
+		BeforeEach(func() {
+			previousEnvValue = os.Getenv("CASGO_HOST")
+		})
+
+		AfterEach(func() {
+			os.Setenv("CASGO_HOST", previousEnvValue)
+		})
+
+		configFromFlags := func(args ...string) map[string]string {
+			flags := flag.NewFlagSet("casgo", flag.ContinueOnError)
+			configFlags := NewConfigFlags(flags)
+			Expect(flags.Parse(append([]string{"-config", "../../fixtures/valid-config.yaml"}, args...))).To(Succeed())
+			config, err := configFlags.Config()
+			Expect(err).To(BeNil())
+			return config
+		}
+
+		It("Should override the file with ENV, and both with flags", func() {
+			os.Setenv("CASGO_HOST", "")
+			Expect(configFromFlags()["host"]).To(Equal("cas.example.com"))
+
+			os.Setenv("CASGO_HOST", "env.example.com")
+			Expect(configFromFlags()["host"]).To(Equal("env.example.com"))
+
+			config := configFromFlags("-host", "flag.example.com", "-dbName", "FLAG_DB_NAME")
+			Expect(config["host"]).To(Equal("flag.example.com"))
+			Expect(config["dbName"]).To(Equal("FLAG_DB_NAME"))
+			Expect(config["port"]).To(Equal("8443"))
+		})
+
+		It("Should keep explicit overrides once the server is created", func() {
+			os.Setenv("CASGO_HOST", "env.example.com")
+			config := configFromFlags("-host", "flag.example.com", "-dbBackend", "memory")
+			server, err := NewCASServerWithLogger(config, NoopLogger{})
+			Expect(err).To(BeNil())
+			server.AuditLog.Stop()
+			Expect(server.Config["host"]).To(Equal("flag.example.com"))
+		})
+
+		It("Should refuse flags for unknown options", func() {
+			flags := flag.NewFlagSet("casgo", flag.ContinueOnError)
+			flags.SetOutput(ioutil.Discard)
+			NewConfigFlags(flags)
+			Expect(flags.Parse([]string{"-dbHots", "localhost"})).ToNot(Succeed())
+		})
+	})
+
+	Describe("Config validation", func() {
+		validConfig := func(overrides map[string]string) map[string]string {
+			config, err := NewCASServerConfig("")
+			Expect(err).To(BeNil())
+			config["dbBackend"] = "memory"
+			config["templatesDirectory"] = "../templates"
+			for key, value := range overrides {
+				config[key] = value
+			}
+			return config
+		}
+
+		// Problems reported for a configuration
+		problems := func(config map[string]string) ConfigErrors {
+			err := ValidateConfig(config)
+			Expect(err).To(HaveOccurred())
+			Expect(err).To(BeAssignableToTypeOf(ConfigErrors{}))
+			return err.(ConfigErrors)
+		}
+
+		It("Should pass a fully valid configuration", func() {
+			Expect(ValidateConfig(validConfig(nil))).To(Succeed())
+			Expect(ValidateConfig(validConfig(map[string]string{
+				"authMethod":           "ldap",
+				"ldapUrl":              "ldaps://ldap.example.com:636",
+				"corsAllowedOrigins":   "https://admin.example.com, http://localhost:3000",
+				"corsAllowCredentials": "true",
+				"oidcEnabled":          "true",
+				"oidcIssuer":           "https://cas.example.com",
+				"metricsEnabled":       "true",
+				"metricsAddr":          "127.0.0.1:9100",
+				"cookieSameSite":       "None",
+				"cookieSecure":         "true",
+				"brandingLogoUrl":      "/static/logo.png",
+				"tlsCertFile":          "../../fixtures/ssl/cert.pem",
+				"tlsKeyFile":           "../../fixtures/ssl/eckey.pem",
+			}))).To(Succeed())
+			Expect(ValidateTLSFiles(validConfig(map[string]string{
+				"tlsCertFile": "../../fixtures/ssl/cert.pem",
+				"tlsKeyFile":  "../../fixtures/ssl/eckey.pem",
+			}))).To(Succeed())
+		})
+
+		It("Should report missing required options", func() {
+			Expect(problems(validConfig(map[string]string{"cookieSecret": ""}))).To(ConsistOf("cookieSecret is required"))
+			Expect(problems(validConfig(map[string]string{"host": " ", "dbBackend": "rethinkdb", "dbName": ""}))).To(ConsistOf(
+				"host is required",
+				"dbName is required",
+			))
+			Expect(problems(validConfig(map[string]string{"samlSigningEnabled": "true"}))).To(ConsistOf("samlSigningKeyFile is required"))
+			Expect(ValidateTLSFiles(validConfig(map[string]string{"tlsCertFile": "", "tlsKeyFile": ""}))).To(ConsistOf(
+				"tlsCertFile is required",
+				"tlsKeyFile is required",
+			))
+		})
+
+		It("Should report malformed URLs and addresses", func() {
+			Expect(problems(validConfig(map[string]string{
+				"authMethod":         "ldap",
+				"ldapUrl":            "ldap.example.com",
+				"corsAllowedOrigins": "https://admin.example.com/login",
+				"oidcEnabled":        "true",
+				"oidcIssuer":         "http://cas.example.com",
+				"brandingLogoUrl":    "javascript:alert(1)",
+				"dbBackend":          "rethinkdb",
+				"dbHost":             "localhost",
+			}))).To(ConsistOf(
+				"ldapUrl [ldap.example.com] must be an ldap:// or ldaps:// URL",
+				"corsAllowedOrigins entry [https://admin.example.com/login] must be an origin (ex. https://admin.example.com)",
+				"oidcIssuer [http://cas.example.com] must be an https URL without a query",
+				"brandingLogoUrl [javascript:alert(1)] must be an http(s) URL or a path starting with /",
+				"dbHost [localhost] must be a host:port address",
+			))
+		})
+
+		It("Should report invalid values, out of range ports, missing files and options that can't be used together", func() {
+			Expect(problems(validConfig(map[string]string{
+				"port":                 "70000",
+				"cookieSecure":         "yes",
+				"logLevel":             "verbose",
+				"passwordDenylistFile": "../../fixtures/missing-denylist.txt",
+				"authMethod":           "ldap",
+				"ldapUrl":              "ldaps://ldap.example.com",
+				"ldapStartTLS":         "true",
+				"corsAllowedOrigins":   "*",
+				"corsAllowCredentials": "true",
+				"dbPoolMinSize":        "20",
+				"dbPoolMaxSize":        "10",
+			}))).To(ConsistOf(
+				"cookieSecure [yes] must be true or false",
+				"logLevel [verbose] must be one of debug, info, warn, warning, error",
+				"port [70000] must be between 0 and 65535",
+				ContainSubstring("passwordDenylistFile [../../fixtures/missing-denylist.txt] can't be read"),
+				"ldapStartTLS cannot be used with an ldaps:// ldapUrl",
+				"corsAllowCredentials cannot be used when corsAllowedOrigins contains \"*\"",
+				"dbPoolMinSize [20] must not be greater than dbPoolMaxSize [10]",
+			))
+			Expect(problems(validConfig(map[string]string{"cookieSameSite": "none", "cookieSecure": "false"}))).To(HaveLen(1))
+			Expect(ValidateTLSFiles(validConfig(map[string]string{"tlsCertFile": "../../fixtures/ssl/missing.pem", "tlsKeyFile": "../../fixtures/ssl/eckey.pem"}))).To(ConsistOf(
+				ContainSubstring("tlsCertFile [../../fixtures/ssl/missing.pem] can't be read"),
+			))
+		})
+
+		It("Should refuse to create a server, listing every problem in one error", func() {
+			server, err := NewCASServerWithLogger(validConfig(map[string]string{
+				"port":       "-1",
+				"healthPath": "healthz",
+			}), NoopLogger{})
+			Expect(server).To(BeNil())
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("Invalid configuration (2 problems):\n" +
+				"  - port [-1] must be between 0 and 65535\n" +
+				"  - healthPath [healthz] must be a path starting with /"))
+		})
 	})
 
 })
@@ -0,0 +1,299 @@
+package cas
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Validation of the whole configuration at startup
+ */
+
+// Every problem found with a configuration (see ValidateConfig)
+type ConfigErrors []string
+
+func (e ConfigErrors) Error() string {
+	return fmt.Sprintf("Invalid configuration (%d problems):\n  - %s", len(e), strings.Join(e, "\n  - "))
+}
+
+// Check a (merged) configuration, returning ConfigErrors listing every problem found (nil if there are none)
+// Values are checked against their defaults' types (booleans and integers), enumerations, URL and address formats,
+// port ranges, the existence of referenced files and options that can't be used together. The TLS certificate and
+// key are only checked by Start and Serve (see ValidateTLSFiles), as servers that aren't started don't need them
+func ValidateConfig(config map[string]string) error {
+	v := &configValidator{config: config}
+
+	// Types of the options, given by their defaults
+	for _, key := range sortedConfigKeys(CONFIG_DEFAULTS) {
+		value := v.value(key)
+		switch defaultValue := CONFIG_DEFAULTS[key]; {
+		case defaultValue == "true" || defaultValue == "false":
+			if _, err := strconv.ParseBool(value); err != nil {
+				v.problem("%s [%s] must be true or false", key, value)
+			}
+		case isInteger(defaultValue):
+			if !isInteger(value) {
+				v.problem("%s [%s] must be an integer", key, value)
+			}
+		}
+	}
+	v.oneOf("logLevel", true, "debug", "info", "warn", "warning", "error")
+	v.oneOf("logFormat", true, LOG_FORMAT_TEXT, LOG_FORMAT_JSON)
+	if _, err := ParseAuthMethods(v.value("authMethod")); err != nil {
+		v.problem("%v", err)
+	}
+	v.oneOf("ticketGenerator", false, "default", "prefixed")
+	v.oneOf("cookieSameSite", true, "lax", "strict", "none")
+	v.oneOf("sessionSerializer", true, "json", "gob")
+	v.oneOf("sessionStore", true, "cookie", "redis")
+	v.oneOf("passwordHashAlgorithm", false, "bcrypt", "sha256")
+	v.oneOf("apiResponseEnvelope", false, API_ENVELOPE_RAW, API_ENVELOPE_DATA)
+	v.oneOf("clientIpHeader", true, CLIENT_IP_HEADER_X_FORWARDED_FOR, CLIENT_IP_HEADER_FORWARDED)
+	if _, err := ParseTLSVersion(v.value("tlsMinVersion")); err != nil {
+		v.problem("tlsMinVersion [%s] must be one of 1.0, 1.1, 1.2, 1.3", v.value("tlsMinVersion"))
+	}
+	if _, err := ParseTLSCipherSuites(v.value("tlsCipherSuites")); err != nil {
+		v.problem("%v", err)
+	}
+
+	// Required options
+	v.required("host", "port", "cookieSecret", "templatesDirectory")
+	if port, err := strconv.Atoi(v.value("port")); err == nil && (port < 0 || port > 65535) {
+		v.problem("port [%d] must be between 0 and 65535", port)
+	}
+
+	// Storage backend
+	backend := v.value("dbBackend")
+	if !containsString(RegisteredBackends(), backend, false) {
+		v.problem("dbBackend [%s] is not supported, expected one of %v", backend, RegisteredBackends())
+	}
+	if backend == "rethinkdb" {
+		v.required("dbHost", "dbName")
+		v.hostPort("dbHost")
+	}
+	if min, max := v.int("dbPoolMinSize"), v.int("dbPoolMaxSize"); min > max {
+		v.problem("dbPoolMinSize [%d] must not be greater than dbPoolMaxSize [%d]", min, max)
+	}
+
+	// Session store
+	if strings.ToLower(v.value("sessionStore")) == "redis" {
+		v.required("redisAddr")
+		v.hostPort("redisAddr")
+		if db := v.int("redisDB"); db < 0 {
+			v.problem("redisDB [%d] must not be negative", db)
+		}
+		if size := v.int("redisPoolSize"); size < 1 {
+			v.problem("redisPoolSize [%d] must be at least 1", size)
+		}
+	}
+
+	// LDAP authentication
+	if methods, _ := ParseAuthMethods(v.value("authMethod")); containsString(methods, "ldap", false) {
+		ldapUrl, err := url.Parse(v.value("ldapUrl"))
+		if err != nil || (ldapUrl.Scheme != "ldap" && ldapUrl.Scheme != "ldaps") || len(ldapUrl.Host) == 0 {
+			v.problem("ldapUrl [%s] must be an ldap:// or ldaps:// URL", v.value("ldapUrl"))
+		} else if ldapUrl.Scheme == "ldaps" && v.bool("ldapStartTLS") {
+			v.problem("ldapStartTLS cannot be used with an ldaps:// ldapUrl")
+		}
+		v.file("ldapCACertFile")
+	}
+
+	// Endpoints and URLs
+	if _, err := ParseBasePath(v.value("basePath")); err != nil {
+		v.problem("basePath [%s] must be a path starting with / (ex. /cas)", v.value("basePath"))
+	}
+	if _, err := ParseStaticPath(v.value("staticPath")); err != nil {
+		v.problem("staticPath [%s] must be a path starting with / other than the root (ex. /public/)", v.value("staticPath"))
+	}
+	if directory := strings.TrimSpace(v.value("staticDirectory")); len(directory) > 0 {
+		if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+			v.problem("staticDirectory [%s] must be a directory", directory)
+		}
+	}
+	if v.bool("metricsEnabled") && len(v.value("metricsAddr")) > 0 {
+		v.hostPort("metricsAddr")
+	}
+	for _, key := range []string{"healthPath", "readyPath"} {
+		if !strings.HasPrefix(v.value(key), "/") {
+			v.problem("%s [%s] must be a path starting with /", key, v.value(key))
+		}
+	}
+	if v.value("healthPath") == v.value("readyPath") {
+		v.problem("healthPath and readyPath must differ (both are [%s])", v.value("healthPath"))
+	}
+	for _, key := range []string{"brandingLogoUrl", "brandingCustomCssUrl"} {
+		if err := validateBrandingUrl(key, strings.TrimSpace(v.value(key))); err != nil {
+			v.problem("%s [%s] must be an http(s) URL or a path starting with /", key, v.value(key))
+		}
+	}
+	for _, origin := range splitConfigList(v.value("corsAllowedOrigins")) {
+		if origin == "*" {
+			if v.bool("corsAllowCredentials") {
+				v.problem("corsAllowCredentials cannot be used when corsAllowedOrigins contains \"*\"")
+			}
+			continue
+		}
+		if parsed, err := url.Parse(origin); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 || strings.TrimPrefix(parsed.Path, "/") != "" {
+			v.problem("corsAllowedOrigins entry [%s] must be an origin (ex. https://admin.example.com)", origin)
+		}
+	}
+	if v.bool("oidcEnabled") {
+		if issuer := strings.TrimSpace(v.value("oidcIssuer")); len(issuer) > 0 {
+			if parsed, err := url.Parse(issuer); err != nil || parsed.Scheme != "https" || len(parsed.Host) == 0 || len(parsed.RawQuery) > 0 {
+				v.problem("oidcIssuer [%s] must be an https URL without a query", issuer)
+			}
+		}
+		v.file("oidcSigningKeyFile")
+	}
+	if v.bool("delegationEnabled") {
+		if upstream, err := url.Parse(strings.TrimSpace(v.value("delegationCasUrl"))); err != nil || upstream.Scheme != "https" || len(upstream.Host) == 0 || len(upstream.RawQuery) > 0 {
+			v.problem("delegationCasUrl [%s] must be the https URL of the upstream CAS server (ex. https://cas.example.edu/cas)", v.value("delegationCasUrl"))
+		}
+		if callback := strings.TrimSpace(v.value("delegationCallbackUrl")); len(callback) > 0 {
+			if parsed, err := url.Parse(callback); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 || len(parsed.RawQuery) > 0 {
+				v.problem("delegationCallbackUrl [%s] must be the http(s) URL of casgo's %s callback, without a query", callback, DELEGATION_CALLBACK_PATH)
+			}
+		}
+		if _, err := parseAttributeMap("delegationAttributeMap", "upstreamAttribute", v.value("delegationAttributeMap")); err != nil {
+			v.problem("%v", err)
+		}
+	}
+
+	// Options that require others
+	if strings.ToLower(v.value("cookieSameSite")) == "none" && !v.bool("cookieSecure") {
+		v.problem("cookieSameSite [none] requires cookieSecure (browsers reject SameSite=None cookies that aren't secure)")
+	}
+	if v.bool("samlSigningEnabled") {
+		v.required("samlSigningKeyFile")
+		v.file("samlSigningKeyFile")
+		v.file("samlSigningCertFile")
+	}
+	if v.bool("trustedHeaderAuth") {
+		if proxies, err := ParseTrustedProxies(v.value("trustedProxies")); err == nil && len(proxies) == 0 {
+			v.problem("trustedHeaderAuth requires trustedProxies (the proxies allowed to set trustedHeaderName)")
+		}
+		if header := strings.TrimSpace(v.value("trustedHeaderName")); !validHeaderName(header) {
+			v.problem("trustedHeaderName [%s] must be a header name (ex. X-Remote-User)", header)
+		}
+	}
+	if _, err := ParseLoginFields(v.value("loginExtraFields")); err != nil {
+		v.problem("%v", err)
+	}
+	v.file("passwordDenylistFile")
+	v.file("webhooksFile")
+
+	if len(v.problems) > 0 {
+		return v.problems
+	}
+	return nil
+}
+
+// Check that the TLS certificate and key the server is served with exist
+func ValidateTLSFiles(config map[string]string) error {
+	v := &configValidator{config: config}
+	v.required("tlsCertFile", "tlsKeyFile")
+	v.file("tlsCertFile")
+	v.file("tlsKeyFile")
+	if len(v.problems) > 0 {
+		return v.problems
+	}
+	return nil
+}
+
+// Collects the problems found with a configuration
+type configValidator struct {
+	config   map[string]string
+	problems ConfigErrors
+}
+
+func (v *configValidator) problem(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// Value of an option, the default if it is unset (as components read it)
+func (v *configValidator) value(key string) string {
+	return configValueOrDefault(v.config, key)
+}
+
+// Boolean value of an option (false if invalid, which is reported separately)
+func (v *configValidator) bool(key string) bool {
+	value, _ := strconv.ParseBool(v.value(key))
+	return value
+}
+
+// Integer value of an option (0 if invalid, which is reported separately)
+func (v *configValidator) int(key string) int {
+	value, _ := strconv.Atoi(v.value(key))
+	return value
+}
+
+// Value of an option read as is, where an explicitly blank value isn't replaced by the default
+func (v *configValidator) rawValue(key string) string {
+	if value, set := v.config[key]; set {
+		return value
+	}
+	return CONFIG_DEFAULTS[key]
+}
+
+func (v *configValidator) required(keys ...string) {
+	for _, key := range keys {
+		if len(strings.TrimSpace(v.rawValue(key))) == 0 {
+			v.problem("%s is required", key)
+		}
+	}
+}
+
+// Check that an option is one of the given values (ignoring case if the component it configures does)
+func (v *configValidator) oneOf(key string, ignoreCase bool, values ...string) {
+	value := v.value(key)
+	if ignoreCase {
+		value = strings.TrimSpace(value)
+	}
+	if len(value) > 0 && !containsString(values, value, ignoreCase) {
+		v.problem("%s [%s] must be one of %s", key, value, strings.Join(values, ", "))
+	}
+}
+
+// Check that an option is a host:port address, with a valid port
+func (v *configValidator) hostPort(key string) {
+	_, port, err := net.SplitHostPort(v.value(key))
+	if err != nil {
+		v.problem("%s [%s] must be a host:port address", key, v.value(key))
+		return
+	}
+	if number, err := strconv.Atoi(port); err != nil || number < 1 || number > 65535 {
+		v.problem("%s [%s] must have a port between 1 and 65535", key, v.value(key))
+	}
+}
+
+// Check that the file an option refers to (if any) exists
+func (v *configValidator) file(key string) {
+	path := strings.TrimSpace(v.rawValue(key))
+	if len(path) == 0 {
+		return
+	}
+	if info, err := os.Stat(path); err != nil {
+		v.problem("%s [%s] can't be read, %v", key, path, err)
+	} else if info.IsDir() {
+		v.problem("%s [%s] is a directory", key, path)
+	}
+}
+
+func isInteger(value string) bool {
+	_, err := strconv.Atoi(value)
+	return err == nil
+}
+
+func sortedConfigKeys(config map[string]string) []string {
+	keys := []string{}
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,139 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Cross-origin resource sharing (CORS) for API endpoints
+ */
+
+// Origins, methods and headers allowed to make cross-origin requests
+type CORSPolicy struct {
+	AllowedOrigins   []string // Allowed origins ("*" allows any origin), none disables CORS
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool // Whether cookies (sessions) may be sent with cross-origin requests
+	MaxAge           int  // Seconds that preflight responses may be cached for
+}
+
+// Create the CORS policy specified by server configuration
+func NewCORSPolicyFromConfig(config map[string]string) (*CORSPolicy, error) {
+	allowCredentials, err := configBool(config, "corsAllowCredentials")
+	if err != nil {
+		return nil, err
+	}
+	maxAge, err := configInt(config, "corsMaxAge")
+	if err != nil {
+		return nil, err
+	}
+	if maxAge < 0 {
+		return nil, fmt.Errorf("Invalid corsMaxAge [%d], must not be negative", maxAge)
+	}
+
+	policy := &CORSPolicy{
+		AllowedOrigins:   splitConfigList(config["corsAllowedOrigins"]),
+		AllowedMethods:   splitConfigList(strings.ToUpper(config["corsAllowedMethods"])),
+		AllowedHeaders:   splitConfigList(config["corsAllowedHeaders"]),
+		AllowCredentials: allowCredentials,
+		MaxAge:           maxAge,
+	}
+
+	// Credentials must not be shared with arbitrary origins
+	if policy.AllowCredentials && policy.allowsAnyOrigin() {
+		return nil, fmt.Errorf("corsAllowCredentials cannot be used when corsAllowedOrigins contains \"*\"")
+	}
+	return policy, nil
+}
+
+// Split a comma separated configuration value, dropping empty entries
+func splitConfigList(list string) []string {
+	entries := []string{}
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); len(entry) > 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Whether cross-origin requests are allowed at all
+func (p *CORSPolicy) Enabled() bool {
+	return p != nil && len(p.AllowedOrigins) > 0
+}
+
+func (p *CORSPolicy) allowsAnyOrigin() bool {
+	return containsString(p.AllowedOrigins, "*", false)
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	return p.allowsAnyOrigin() || containsString(p.AllowedOrigins, origin, true)
+}
+
+func containsString(list []string, s string, ignoreCase bool) bool {
+	for _, entry := range list {
+		if entry == s || (ignoreCase && strings.EqualFold(entry, s)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Wrap a handler, adding CORS headers for allowed origins and answering preflight requests
+// Handlers are returned unchanged if CORS is not enabled
+func (p *CORSPolicy) Handler(h http.Handler) http.Handler {
+	if !p.Enabled() {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		isPreflight := req.Method == "OPTIONS" && len(req.Header.Get("Access-Control-Request-Method")) > 0
+
+		// Requests from other origins are handled as usual (the browser will refuse to expose the response)
+		if len(origin) == 0 || !p.allowsOrigin(origin) {
+			if isPreflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if p.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !isPreflight {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		// Preflight requests must only ask for allowed methods and headers
+		method := strings.ToUpper(strings.TrimSpace(req.Header.Get("Access-Control-Request-Method")))
+		if !containsString(p.AllowedMethods, method, false) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		for _, header := range splitConfigList(req.Header.Get("Access-Control-Request-Headers")) {
+			if !containsString(p.AllowedHeaders, header, true) {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(p.AllowedMethods, ", "))
+		if len(p.AllowedHeaders) > 0 {
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(p.AllowedHeaders, ", "))
+		}
+		if p.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(p.MaxAge))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,12 @@
+package cors_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoCORS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo CORS Suite")
+}
@@ -0,0 +1,202 @@
+package cors_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+)
+
+func defaultConfig() map[string]string {
+	config := map[string]string{}
+	for k, v := range CONFIG_DEFAULTS {
+		config[k] = v
+	}
+	return config
+}
+
+var _ = Describe("CORSPolicy", func() {
+	var (
+		config  map[string]string
+		handled bool
+	)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		handled = true
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serve := func(method, origin string, headers map[string]string) *httptest.ResponseRecorder {
+		policy, err := NewCORSPolicyFromConfig(config)
+		Expect(err).To(BeNil())
+
+		req, _ := http.NewRequest(method, "/api/services", nil)
+		if len(origin) > 0 {
+			req.Header.Set("Origin", origin)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		policy.Handler(handler).ServeHTTP(w, req)
+		return w
+	}
+
+	preflight := func(origin, method, headers string) *httptest.ResponseRecorder {
+		return serve("OPTIONS", origin, map[string]string{
+			"Access-Control-Request-Method":  method,
+			"Access-Control-Request-Headers": headers,
+		})
+	}
+
+	BeforeEach(func() {
+		handled = false
+		config = defaultConfig()
+		config["corsAllowedOrigins"] = "https://admin.example.com, https://other.example.com"
+	})
+
+	It("Should be disabled by default", func() {
+		config = defaultConfig()
+		policy, err := NewCORSPolicyFromConfig(config)
+		Expect(err).To(BeNil())
+		Expect(policy.Enabled()).To(BeFalse())
+
+		w := serve("GET", "https://admin.example.com", nil)
+		Expect(handled).To(BeTrue())
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+		Expect(w.Header().Get("Vary")).To(BeEmpty())
+	})
+
+	It("Should answer preflight requests from allowed origins", func() {
+		w := preflight("https://admin.example.com", "PUT", "X-Api-Key, x-api-secret")
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(handled).To(BeFalse())
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://admin.example.com"))
+		Expect(w.Header().Get("Access-Control-Allow-Methods")).To(Equal("GET, POST, PUT, DELETE"))
+		Expect(w.Header().Get("Access-Control-Allow-Headers")).To(ContainSubstring("X-Api-Key"))
+		Expect(w.Header().Get("Access-Control-Max-Age")).To(Equal("600"))
+		Expect(w.Header().Get("Access-Control-Allow-Credentials")).To(BeEmpty())
+	})
+
+	It("Should refuse preflight requests from other origins", func() {
+		w := preflight("https://evil.example.com", "GET", "")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("Should refuse preflight requests for methods or headers that are not allowed", func() {
+		w := preflight("https://admin.example.com", "PATCH", "")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = preflight("https://admin.example.com", "GET", "X-Custom-Header")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("Should only echo allowed origins on actual requests", func() {
+		w := serve("GET", "https://other.example.com", nil)
+		Expect(handled).To(BeTrue())
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://other.example.com"))
+		Expect(w.Header().Get("Vary")).To(Equal("Origin"))
+
+		handled = false
+		w = serve("GET", "https://evil.example.com", nil)
+		Expect(handled).To(BeTrue())
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+
+	It("Should pass OPTIONS requests that are not preflight requests through", func() {
+		serve("OPTIONS", "https://admin.example.com", nil)
+		Expect(handled).To(BeTrue())
+	})
+
+	It("Should allow credentials when configured", func() {
+		config["corsAllowCredentials"] = "true"
+		w := serve("GET", "https://admin.example.com", nil)
+		Expect(w.Header().Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+	})
+
+	It("Should allow any origin with a wildcard", func() {
+		config["corsAllowedOrigins"] = "*"
+		w := serve("GET", "https://anywhere.example.com", nil)
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://anywhere.example.com"))
+	})
+
+	It("Should not allow credentials to be shared with any origin", func() {
+		config["corsAllowedOrigins"] = "*"
+		config["corsAllowCredentials"] = "true"
+		_, err := NewCORSPolicyFromConfig(config)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Should reject invalid configuration", func() {
+		config["corsMaxAge"] = "-1"
+		_, err := NewCORSPolicyFromConfig(config)
+		Expect(err).NotTo(BeNil())
+
+		config["corsMaxAge"] = "600"
+		config["corsAllowCredentials"] = "maybe"
+		_, err = NewCORSPolicyFromConfig(config)
+		Expect(err).NotTo(BeNil())
+	})
+})
+
+var _ = Describe("CORS for API endpoints", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	serve := func(method, path string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+		config["corsAllowedOrigins"] = "https://admin.example.com"
+
+		server, err = NewCASServer(config)
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		db.Close()
+	})
+
+	It("Should answer preflight requests for API routes", func() {
+		w := serve("OPTIONS", "/api/services", map[string]string{
+			"Origin":                         "https://admin.example.com",
+			"Access-Control-Request-Method":  "GET",
+			"Access-Control-Request-Headers": "X-Api-Key, X-Api-Secret",
+		})
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://admin.example.com"))
+	})
+
+	It("Should add CORS headers to API responses", func() {
+		w := serve("GET", "/api/services", map[string]string{
+			"Origin":       "https://admin.example.com",
+			"X-Api-Key":    "adminapikey",
+			"X-Api-Secret": "badsecret",
+		})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(Equal("https://admin.example.com"))
+	})
+
+	It("Should not apply to non-API routes", func() {
+		w := serve("GET", "/login", map[string]string{"Origin": "https://admin.example.com"})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Access-Control-Allow-Origin")).To(BeEmpty())
+	})
+})
@@ -0,0 +1,127 @@
+package cas
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+/*
+ * Bootstrapping the first admin user (casgo createadmin), without starting the HTTP server
+ */
+
+// Options of the createadmin command
+type CreateAdminOptions struct {
+	Email    string
+	Password string
+	Force    bool // Create the admin even if one already exists, making an existing user with the email an admin
+}
+
+// Create an admin user directly in the storage backend
+// Refused with AdminAlreadyExistsError if there already is an admin, unless opts.Force is set (in which case an
+// existing user with the email is made an admin, with the given password)
+// Returns the rules the password failed (along with WeakPasswordError) if it was refused
+func (c *CAS) CreateAdmin(opts CreateAdminOptions) (*User, []PasswordRuleFailure, *CASServerError) {
+	// Emails are lowercased, as they are when logging in
+	email := strings.TrimSpace(strings.ToLower(opts.Email))
+	if len(email) == 0 || len(opts.Password) == 0 {
+		return nil, nil, &InvalidUserError
+	}
+
+	if !opts.Force {
+		_, admins, casErr := c.Db.FindUsers(CASUserQuery{Role: USER_ROLE_ADMIN, Limit: 1})
+		if casErr != nil {
+			return nil, nil, casErr
+		}
+		if admins > 0 {
+			return nil, nil, &AdminAlreadyExistsError
+		}
+	}
+
+	existing, findErr := c.Db.FindUserByEmail(email)
+	if findErr == nil && existing != nil {
+		if !opts.Force {
+			return nil, nil, &EmailAlreadyTakenError
+		}
+		return c.promoteToAdmin(existing, opts.Password)
+	}
+
+	user, failures, casErr := c.createUser(c.Db, email, opts.Password)
+	if casErr != nil {
+		return nil, failures, casErr
+	}
+
+	// Users are created with only their email and password, so they are made admins afterwards
+	user.IsAdmin = true
+	if casErr := c.Db.UpdateUser(user); casErr != nil {
+		c.Db.RemoveUserByEmail(user.Email)
+		return nil, nil, casErr
+	}
+	return user, nil, nil
+}
+
+// Make an existing user an admin, replacing their password
+func (c *CAS) promoteToAdmin(user *User, password string) (*User, []PasswordRuleFailure, *CASServerError) {
+	hashedPassword, failures, casErr := c.hashNewPassword(password)
+	if casErr != nil {
+		return nil, failures, casErr
+	}
+
+	user.Password = hashedPassword
+	user.IsAdmin = true
+	if casErr := c.Db.UpdateUser(user); casErr != nil {
+		return nil, nil, casErr
+	}
+	return user, nil, nil
+}
+
+// Run the createadmin command with the given arguments (those following the command's name), writing its output
+// to out
+// The server is created from the configuration (--config, the environment and option flags) to reach its storage
+// backend, and shut down once the admin has been created
+// Returns the command's exit code (2 if the arguments are invalid)
+func RunCreateAdminCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("createadmin", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configFlags := NewConfigFlags(flags)
+	email := flags.String("email", "", "Email of the admin user")
+	password := flags.String("password", "", "Password of the admin user (must comply with the password policy)")
+	force := flags.Bool("force", false, "Create the admin user even if an admin already exists")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*email) == 0 || len(*password) == 0 {
+		fmt.Fprintln(out, "Both --email and --password are required")
+		flags.PrintDefaults()
+		return 2
+	}
+
+	config, err := configFlags.Config()
+	if err != nil {
+		fmt.Fprintf(out, "Failed to create new CAS server configuration, err: %v\n", err)
+		return 1
+	}
+	casServer, err := NewCASServer(config)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to create new CAS Server instance, err: %v\n", err)
+		return 1
+	}
+	defer casServer.Shutdown(context.Background())
+
+	user, failures, casErr := casServer.CreateAdmin(CreateAdminOptions{Email: *email, Password: *password, Force: *force})
+	if casErr != nil {
+		fmt.Fprintf(out, "Failed to create admin user: %s\n", casErr.Msg)
+		for _, failure := range failures {
+			fmt.Fprintf(out, "  - %s\n", failure.Message)
+		}
+		if casErr.Code == AdminAlreadyExistsError.Code || casErr.Code == EmailAlreadyTakenError.Code {
+			fmt.Fprintln(out, "Use --force to create the admin user anyway (making an existing user with the email an admin)")
+		}
+		return 1
+	}
+
+	fmt.Fprintf(out, "Created admin user [%s]\n", user.Email)
+	return 0
+}
@@ -0,0 +1,12 @@
+package createadmin_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoCreateAdmin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo CreateAdmin Suite")
+}
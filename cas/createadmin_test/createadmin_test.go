@@ -0,0 +1,150 @@
+package createadmin_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var _ = Describe("createadmin command", func() {
+	var (
+		backend    *MemoryBackend
+		tempDir    string
+		configFile string
+		output     *bytes.Buffer
+	)
+
+	BeforeEach(func() {
+		backend = NewMemoryBackend(time.Minute)
+		RegisterBackend("createadmin-test", func(c *CAS) (Backend, error) {
+			return backend, nil
+		})
+		Expect(backend.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "casgo-createadmin")
+		Expect(err).To(BeNil())
+		configFile = filepath.Join(tempDir, "config.json")
+		config, _ := json.Marshal(map[string]string{
+			"dbBackend":          "createadmin-test",
+			"templatesDirectory": "../templates",
+			"logLevel":           "error",
+			"bcryptCost":         "4",
+		})
+		Expect(ioutil.WriteFile(configFile, config, 0600)).To(Succeed())
+		output = &bytes.Buffer{}
+	})
+
+	AfterEach(func() {
+		backend.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	run := func(args ...string) int {
+		return RunCreateAdminCommand(append([]string{"--config", configFile}, args...), output)
+	}
+
+	// Remove the admins loaded from the fixtures, as on a new deployment
+	removeAdmins := func() {
+		users, casErr := backend.GetAllUsers()
+		Expect(casErr).To(BeNil())
+		for _, user := range users {
+			if user.IsAdmin {
+				Expect(backend.RemoveUserByEmail(user.Email)).To(BeNil())
+			}
+		}
+	}
+
+	It("Should create an admin user with a hashed password", func() {
+		removeAdmins()
+		Expect(run("--email", "Root@Example.com", "--password", "correct horse battery 9")).To(Equal(0), output.String())
+		Expect(output.String()).To(ContainSubstring("Created admin user [root@example.com]"))
+
+		user, casErr := backend.FindUserByEmail("root@example.com")
+		Expect(casErr).To(BeNil())
+		Expect(user.IsAdmin).To(BeTrue())
+		Expect(user.Password).ToNot(Equal("correct horse battery 9"))
+		Expect(user.Password).To(HavePrefix("$2a$04$"))
+		Expect(VerifyPassword(user.Password, "correct horse battery 9")).To(BeTrue())
+	})
+
+	It("Should create admins that can log in with their password as given", func() {
+		removeAdmins()
+		Expect(run("--email", "root@example.com", "--password", "Correct Horse 9")).To(Equal(0), output.String())
+		Expect(backend.LoadJSONFixture("services", "../../fixtures/services.json")).To(BeNil())
+
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "createadmin-test"
+		config["templatesDirectory"] = "../templates"
+		config["csrfEnabled"] = "false"
+		server, err := NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		defer server.AuditLog.Stop()
+
+		login := func(password string) *httptest.ResponseRecorder {
+			form := url.Values{"email": {"root@example.com"}, "password": {password}, "serviceUrl": {"localhost:3000/validateCASLogin"}}
+			req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			server.ServeMux.ServeHTTP(w, req)
+			return w
+		}
+		w := login("Correct Horse 9")
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+		Expect(login("correct horse 9").Code).To(Equal(InvalidCredentialsError.HttpCode))
+	})
+
+	It("Should refuse to create an admin when one already exists, unless forced", func() {
+		Expect(run("--email", "root@example.com", "--password", "correct horse battery 9")).To(Equal(1))
+		Expect(output.String()).To(ContainSubstring(AdminAlreadyExistsError.Msg))
+		Expect(output.String()).To(ContainSubstring("--force"))
+		_, casErr := backend.FindUserByEmail("root@example.com")
+		Expect(casErr).ToNot(BeNil())
+
+		Expect(run("--email", "root@example.com", "--password", "correct horse battery 9", "--force")).To(Equal(0), output.String())
+		user, casErr := backend.FindUserByEmail("root@example.com")
+		Expect(casErr).To(BeNil())
+		Expect(user.IsAdmin).To(BeTrue())
+	})
+
+	It("Should only make an existing user an admin when forced", func() {
+		removeAdmins()
+		Expect(run("--email", "test@test.com", "--password", "correct horse battery 9")).To(Equal(1))
+		Expect(output.String()).To(ContainSubstring(EmailAlreadyTakenError.Msg))
+		user, _ := backend.FindUserByEmail("test@test.com")
+		Expect(user.IsAdmin).To(BeFalse())
+
+		Expect(run("--email", "test@test.com", "--password", "correct horse battery 9", "--force")).To(Equal(0), output.String())
+		user, _ = backend.FindUserByEmail("test@test.com")
+		Expect(user.IsAdmin).To(BeTrue())
+		Expect(VerifyPassword(user.Password, "correct horse battery 9")).To(BeTrue())
+	})
+
+	It("Should enforce the password policy", func() {
+		removeAdmins()
+		Expect(run("--email", "root@example.com", "--password", "short")).To(Equal(1))
+		Expect(output.String()).To(ContainSubstring(WeakPasswordError.Msg))
+		Expect(output.String()).To(ContainSubstring("Must be at least 8 characters long"))
+
+		_, casErr := backend.FindUserByEmail("root@example.com")
+		Expect(casErr).ToNot(BeNil())
+	})
+
+	It("Should require an email and a password", func() {
+		Expect(run("--email", "root@example.com")).To(Equal(2))
+		Expect(output.String()).To(ContainSubstring("Both --email and --password are required"))
+		Expect(run("--password", "correct horse battery 9", "--unknown")).To(Equal(2))
+	})
+})
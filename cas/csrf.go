@@ -0,0 +1,137 @@
+package cas
+
+import (
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/context"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Cross-site request forgery protection, for forms and API requests authenticated by the session cookie
+ */
+
+const (
+	CSRF_SESSION_NAME = "casgo-csrf"   // Signed cookie holding the token issued to a browser
+	CSRF_FORM_FIELD   = "csrf_token"   // Form field carrying the token (see csrfField)
+	CSRF_HEADER       = "X-CSRF-Token" // Header carrying the token (for API requests)
+)
+
+// Whether state-changing requests must carry the CSRF token issued to the browser
+func (c *CAS) csrfEnabled() bool {
+	enabled, err := configBool(c.Config, "csrfEnabled")
+	if err != nil {
+		c.Logger.Warn("Invalid configuration value, CSRF protection enabled", "key", "csrfEnabled", "error", err)
+		return true
+	}
+	return enabled
+}
+
+// Get the CSRF token of the browser making a request, issuing one (in a cookie) if it has none yet
+// Returns an empty token if CSRF protection is disabled
+func (c *CAS) csrfToken(w http.ResponseWriter, req *http.Request) string {
+	if !c.csrfEnabled() {
+		return ""
+	}
+
+	session, _ := c.sessionStore.Get(req, CSRF_SESSION_NAME)
+	if token, ok := session.Values["token"].(string); ok && len(token) > 0 {
+		return token
+	}
+
+	token, err := newTicketId("CSRF")
+	if err != nil {
+		c.requestLogger(req).Error("Failed to generate CSRF token", "error", err)
+		return ""
+	}
+	session.Values["token"] = token
+	if err := session.Save(req, w); err != nil {
+		c.requestLogger(req).Error("Failed to save CSRF token", "error", err)
+	}
+	return token
+}
+
+// Hidden form input carrying a CSRF token (nothing if there is no token)
+func csrfField(token interface{}) template.HTML {
+	value, _ := token.(string)
+	if len(value) == 0 {
+		return ""
+	}
+	return template.HTML(`<input type="hidden" name="` + CSRF_FORM_FIELD + `" value="` + template.HTMLEscapeString(value) + `"/>`)
+}
+
+// Whether a request method may change state
+func isStateChangingMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE":
+		return false
+	}
+	return true
+}
+
+// Whether a request is authenticated by headers alone (an API key & secret or an API token, and no session cookie)
+// Browsers never add these headers to cross-site requests by themselves, so such requests need no CSRF token
+func usesHeaderAuthentication(req *http.Request) bool {
+	if _, err := req.Cookie("casgo-session"); err == nil {
+		return false
+	}
+	_, hasToken := bearerToken(req)
+	hasKey := len(req.Header.Get("X-Api-Key")) > 0 && len(req.Header.Get("X-Api-Secret")) > 0
+	return hasToken || hasKey
+}
+
+// Whether a request is made to an endpoint services post to themselves, rather than through users' browsers
+// (SAML validation and the OIDC token endpoint, authenticated by the ticket or code they redeem), or which may be
+// posted to cross-site (the OIDC authorization endpoint)
+func (c *CAS) isServiceEndpoint(req *http.Request) bool {
+	if req.URL.Path == "/samlValidate" {
+		return true
+	}
+	return c.OIDC != nil && (req.URL.Path == OIDC_AUTHORIZE_PATH || req.URL.Path == OIDC_TOKEN_PATH)
+}
+
+// Wrap a handler, refusing state-changing requests that do not carry (in the CSRF_HEADER or CSRF_FORM_FIELD)
+// the token issued to the browser making them
+func (c *CAS) withCSRFProtection(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !isStateChangingMethod(req.Method) || !c.csrfEnabled() || usesHeaderAuthentication(req) || c.isServiceEndpoint(req) {
+			h.ServeHTTP(w, req)
+			return
+		}
+
+		// Sessions are kept (per request) in gorilla's context, which the router only clears for requests it serves
+		defer context.Clear(req)
+
+		session, _ := c.sessionStore.Get(req, CSRF_SESSION_NAME)
+		expected, _ := session.Values["token"].(string)
+		given := req.Header.Get(CSRF_HEADER)
+		if len(given) == 0 {
+			given = req.PostFormValue(CSRF_FORM_FIELD)
+		}
+
+		if len(expected) == 0 || !SecretsEqual(given, expected) {
+			c.requestLogger(req).Warn("Request refused, missing or invalid CSRF token", "method", req.Method, "path", req.URL.Path)
+			c.renderCSRFError(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+// Render the response to a request refused for lack of a valid CSRF token
+// API requests get an API error, forms are shown again (carrying a valid token) so they can be resubmitted
+func (c *CAS) renderCSRFError(w http.ResponseWriter, req *http.Request) {
+	casErr := &InvalidCSRFTokenError
+	if strings.HasPrefix(req.URL.Path, "/api/") {
+		c.renderAPIJSON(w, apiErrorStatus(casErr), apiErrorResponse(casErr))
+		return
+	}
+
+	page := "login"
+	if req.URL.Path == "/register" {
+		page = "register"
+	}
+	pageContext := c.templateContext(w, req)
+	pageContext["Error"] = c.localizeError(pageContext, casErr)
+	c.render.HTML(w, casErr.HttpCode, page, pageContext)
+}
@@ -0,0 +1,12 @@
+package csrf_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoCSRF(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo CSRF Suite")
+}
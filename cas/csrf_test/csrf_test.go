@@ -0,0 +1,151 @@
+package csrf_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+// Token embedded in forms (by csrfField)
+var csrfInput = regexp.MustCompile(`<input type="hidden" name="csrf_token" value="([^"]+)"/>`)
+
+var _ = Describe("CSRF protection", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	newServer := func(csrfEnabled string) {
+		server, db = castest.NewTestServer(map[string]string{"csrfEnabled": csrfEnabled})
+		client = castest.NewClient(server)
+	}
+
+	do := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == "POST" && !strings.HasPrefix(path, "/api/") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return client.Do(req)
+	}
+
+	// Get the login page, returning the token embedded in its form
+	loginPageToken := func() string {
+		w := do("GET", "/login", "", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		matches := csrfInput.FindStringSubmatch(w.Body.String())
+		Expect(matches).To(HaveLen(2))
+		return matches[1]
+	}
+
+	login := func(token string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {"admin@test.com"}, "serviceUrl": {testServiceUrl}}
+		if len(token) > 0 {
+			form.Set(CSRF_FORM_FIELD, token)
+		}
+		return client.Login(form)
+	}
+
+	apiErrorCode := func(w *httptest.ResponseRecorder) string {
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		code, _ := response["code"].(string)
+		return code
+	}
+
+	updatedService := `{"name":"test_service","url":"localhost:3000/validateCASLogin","adminEmail":"test@test.com"}`
+
+	BeforeEach(func() {
+		newServer("true")
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should issue a token in a cookie and the login form, accepting logins that carry it", func() {
+		token := loginPageToken()
+		Expect(client.Cookies).To(HaveKey(CSRF_SESSION_NAME))
+		Expect(client.Cookies[CSRF_SESSION_NAME].HttpOnly).To(BeTrue())
+
+		// The token stays the same for later pages
+		Expect(loginPageToken()).To(Equal(token))
+
+		w := login(token)
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(HavePrefix(testServiceUrl))
+	})
+
+	It("Should refuse forms with a missing or invalid token, showing the form again", func() {
+		token := loginPageToken()
+
+		w := login("")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Body.String()).To(ContainSubstring(InvalidCSRFTokenError.Msg))
+		Expect(w.Body.String()).To(ContainSubstring(`value="` + token + `"`))
+		Expect(w.Header().Get("Location")).To(BeEmpty())
+
+		Expect(login("CSRF-forged").Code).To(Equal(http.StatusForbidden))
+
+		// Tokens are only valid with the cookie they were issued in
+		client.Cookies = map[string]*http.Cookie{}
+		Expect(login(token).Code).To(Equal(http.StatusForbidden))
+
+		form := url.Values{"email": {"new@test.com"}, "password": {"secret"}}
+		Expect(do("POST", "/register", form.Encode(), nil).Code).To(Equal(http.StatusForbidden))
+		_, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).NotTo(BeNil())
+	})
+
+	It("Should require the token in a header for API requests authenticated by the session cookie", func() {
+		token := loginPageToken()
+		Expect(login(token).Code).To(Equal(http.StatusFound))
+
+		w := do("PUT", "/api/services/test_service", updatedService, nil)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(apiErrorCode(w)).To(Equal(InvalidCSRFTokenError.Code))
+
+		w = do("PUT", "/api/services/test_service", updatedService, map[string]string{CSRF_HEADER: "CSRF-forged"})
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+
+		w = do("PUT", "/api/services/test_service", updatedService, map[string]string{CSRF_HEADER: token})
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		// Reading needs no token
+		Expect(do("GET", "/api/services", "", nil).Code).To(Equal(http.StatusOK))
+	})
+
+	It("Should not require a token from requests authenticated by API key headers alone", func() {
+		admin := map[string]string{"X-Api-Key": "adminapikey", "X-Api-Secret": "badsecret"}
+		w := do("PUT", "/api/services/test_service", updatedService, admin)
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		// A session cookie would authenticate the request instead, so the token is required again
+		Expect(login(loginPageToken()).Code).To(Equal(http.StatusFound))
+		w = do("PUT", "/api/services/test_service", updatedService, admin)
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(apiErrorCode(w)).To(Equal(InvalidCSRFTokenError.Code))
+	})
+
+	It("Should not check tokens when disabled", func() {
+		castest.Close(server)
+		newServer("false")
+
+		w := do("GET", "/login", "", nil)
+		Expect(csrfInput.MatchString(w.Body.String())).To(BeFalse())
+		Expect(client.Cookies).NotTo(HaveKey(CSRF_SESSION_NAME))
+		Expect(login("").Code).To(Equal(http.StatusFound))
+	})
+})
@@ -11,6 +11,7 @@ import (
 // Testing globals for HTTP tests
 var testCASConfig map[string]string
 var testCASServer *cas.CAS
+var testDb cas.CASDBAdapter
 
 func TestCas(t *testing.T) {
 	RegisterFailHandler(Fail)
@@ -30,16 +31,17 @@ var _ = BeforeSuite(func() {
 
 	err = testCASServer.SetupDb()
 	Expect(err).To(BeNil())
+	testDb = testCASServer.Db.(cas.CASDBAdapter)
 
 	// Load database fixtures
-	testCASServer.Db.LoadJSONFixture(
-		testCASServer.Db.GetDbName(),
-		testCASServer.Db.GetServicesTableName(),
+	testDb.LoadJSONFixture(
+		testDb.GetDbName(),
+		testDb.GetServicesTableName(),
 		"../../fixtures/services.json",
 	)
-	testCASServer.Db.LoadJSONFixture(
-		testCASServer.Db.GetDbName(),
-		testCASServer.Db.GetUsersTableName(),
+	testDb.LoadJSONFixture(
+		testDb.GetDbName(),
+		testDb.GetUsersTableName(),
 		"../../fixtures/users.json",
 	)
 
@@ -1,6 +1,7 @@
 package db_test
 
 import (
+	"context"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	. "github.com/t3hmrman/casgo/cas"
@@ -16,7 +17,7 @@ var _ = Describe("Cas DB adapter", func() {
 
 	Describe("DbExists function", func() {
 		It("should return whether the database exists or not", func() {
-			exists, casErr := testCASServer.Db.DbExists()
+			exists, casErr := testDb.DbExists()
 			Expect(casErr).To(BeNil())
 			Expect(exists).To(Equal(true))
 		})
@@ -24,44 +25,44 @@ var _ = Describe("Cas DB adapter", func() {
 
 	Describe("GetDbName function", func() {
 		It("should return the name of the server", func() {
-			actual, expected := testCASServer.Db.GetDbName(), testCASServer.Config["dbName"]
+			actual, expected := testDb.GetDbName(), testCASServer.Config["dbName"]
 			Expect(actual).To(Equal(expected))
 		})
 	})
 
 	Describe("GetUsersTableName function", func() {
 		It("should return the default if not set differently", func() {
-			actual, expected := testCASServer.Db.GetUsersTableName(), "users"
+			actual, expected := testDb.GetUsersTableName(), "users"
 			Expect(actual).To(Equal(expected))
 		})
 	})
 
 	Describe("GetServicesTableName function", func() {
 		It("should return the default if not set differently", func() {
-			actual, expected := testCASServer.Db.GetServicesTableName(), "services"
+			actual, expected := testDb.GetServicesTableName(), "services"
 			Expect(actual).To(Equal(expected))
 		})
 	})
 
 	Describe("GetTicketsTableName function", func() {
 		It("should return the default if not set differently", func() {
-			actual, expected := testCASServer.Db.GetTicketsTableName(), "tickets"
+			actual, expected := testDb.GetTicketsTableName(), "tickets"
 			Expect(actual).To(Equal(expected))
 		})
 	})
 
 	Describe("LoadJSONFixture function", func() {
 		It("should not error when loading JSON into the database", func() {
-			err := testCASServer.Db.LoadJSONFixture(
-				testCASServer.Db.GetDbName(),
-				testCASServer.Db.GetServicesTableName(),
+			err := testDb.LoadJSONFixture(
+				testDb.GetDbName(),
+				testDb.GetServicesTableName(),
 				"../../fixtures/services.json",
 			)
 			Expect(err).To(BeNil())
 		})
 
 		It("Should increase the number of items in the given table", func() {
-			err := testCASServer.Db.TeardownTable("services")
+			err := testDb.TeardownTable("services")
 			Expect(err).To(BeNil())
 
 			// Attempt to find a service in the fixture should fail
@@ -70,9 +71,9 @@ var _ = Describe("Cas DB adapter", func() {
 			Expect(service).To(BeNil())
 
 			// Load the fixture
-			err = testCASServer.Db.LoadJSONFixture(
-				testCASServer.Db.GetDbName(),
-				testCASServer.Db.GetServicesTableName(),
+			err = testDb.LoadJSONFixture(
+				testDb.GetDbName(),
+				testDb.GetServicesTableName(),
 				"../../fixtures/services.json")
 			Expect(err).To(BeNil())
 
@@ -225,4 +226,51 @@ var _ = Describe("Cas DB adapter", func() {
 		})
 	})
 
+	Describe("WithContext function", func() {
+		It("Should give up on operations once the context is done", func() {
+			contextual, ok := testCASServer.Db.(ContextualBackend)
+			Expect(ok).To(BeTrue())
+
+			ctx, cancel := context.WithCancel(context.Background())
+			view := contextual.WithContext(ctx)
+			_, casErr := view.FindServiceByUrl(DB_TEST_DATA["fixtureServiceUrl"])
+			Expect(casErr).To(BeNil())
+
+			cancel()
+			_, casErr = view.FindServiceByUrl(DB_TEST_DATA["fixtureServiceUrl"])
+			Expect(casErr).ToNot(BeNil())
+			Expect(casErr.Code).To(Equal(DbOperationCancelledError.Code))
+
+			// The adapter itself (and other views) are unaffected
+			_, casErr = testCASServer.Db.FindServiceByUrl(DB_TEST_DATA["fixtureServiceUrl"])
+			Expect(casErr).To(BeNil())
+		})
+	})
+
+	Describe("Migrations", func() {
+		It("Should have been applied at startup, and do nothing when re-run", func() {
+			migratable, ok := testCASServer.Db.(MigratableBackend)
+			Expect(ok).To(BeTrue())
+			migrations := migratable.Migrations()
+
+			version, casErr := migratable.SchemaVersion()
+			Expect(casErr).To(BeNil())
+			Expect(version).To(Equal(migrations[len(migrations)-1].Version))
+
+			result, casErr := testCASServer.Migrate()
+			Expect(casErr).To(BeNil())
+			Expect(result.Applied).To(BeEmpty())
+		})
+
+		It("Should refuse a schema version newer than the latest migration", func() {
+			migratable := testCASServer.Db.(MigratableBackend)
+			future := Migration{Version: 1000, Name: "from_the_future"}
+			Expect(migratable.RecordMigration(future, RealClock.Now())).To(BeNil())
+
+			_, casErr := testCASServer.Migrate()
+			Expect(casErr).ToNot(BeNil())
+			Expect(casErr.Code).To(Equal(SchemaVersionTooNewError.Code))
+		})
+	})
+
 })
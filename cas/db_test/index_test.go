@@ -0,0 +1,137 @@
+package db_test
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	r "github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/dancannon/gorethink"
+	"time"
+)
+
+const (
+	indexedTGTs          = 100
+	indexedTicketsPerTGT = 20
+)
+
+var _ = Describe("Lookup indexes", func() {
+	var (
+		session *r.Session
+		loaded  bool
+	)
+
+	// Connect to the database directly, to compare indexed lookups with the filters they replaced
+	BeforeEach(func() {
+		var err error
+		session, err = r.Connect(r.ConnectOpts{Address: testCASServer.Config["dbHost"]})
+		Expect(err).To(BeNil())
+
+		if loaded {
+			return
+		}
+		tickets := []CASTicket{}
+		for tgt := 0; tgt < indexedTGTs; tgt++ {
+			for ticket := 0; ticket < indexedTicketsPerTGT; ticket++ {
+				tickets = append(tickets, CASTicket{
+					Id:        fmt.Sprintf("ST-index-%d-%d", tgt, ticket),
+					UserEmail: fmt.Sprintf("user%d@index.test", tgt),
+					TGTId:     fmt.Sprintf("TGT-index-%d", tgt),
+					Validated: ticket%2 == 0,
+				})
+			}
+		}
+		_, err = r.DB(testCASServer.Config["dbName"]).Table("tickets").Insert(tickets).RunWrite(session)
+		Expect(err).To(BeNil())
+		loaded = true
+	})
+
+	AfterEach(func() {
+		session.Close()
+	})
+
+	It("Should find the tickets of a ticket-granting ticket", func() {
+		tickets, casErr := testCASServer.Db.FindTicketsForTGT("TGT-index-7")
+		Expect(casErr).To(BeNil())
+		Expect(tickets).To(HaveLen(indexedTicketsPerTGT))
+		for _, ticket := range tickets {
+			Expect(ticket.TGTId).To(Equal("TGT-index-7"))
+		}
+
+		validated, casErr := testCASServer.Db.FindValidatedTicketsForTGT("TGT-index-7")
+		Expect(casErr).To(BeNil())
+		Expect(validated).To(HaveLen(indexedTicketsPerTGT / 2))
+		for _, ticket := range validated {
+			Expect(ticket.TGTId).To(Equal("TGT-index-7"))
+			Expect(ticket.Validated).To(BeTrue())
+		}
+
+		none, casErr := testCASServer.Db.FindTicketsForTGT("TGT-index-missing")
+		Expect(casErr).To(BeNil())
+		Expect(none).To(BeEmpty())
+	})
+
+	It("Should only remove the tickets of the given user", func() {
+		mockService := &CASService{Url: "localhost:8080", Name: "mock_service", AdminEmail: "noone@nowhere.com"}
+		Expect(testCASServer.Db.RemoveTicketsForUserWithService("user9@index.test", mockService)).To(BeNil())
+
+		removed, casErr := testCASServer.Db.FindTicketsForTGT("TGT-index-9")
+		Expect(casErr).To(BeNil())
+		Expect(removed).To(BeEmpty())
+		kept, casErr := testCASServer.Db.FindTicketsForTGT("TGT-index-8")
+		Expect(casErr).To(BeNil())
+		Expect(kept).To(HaveLen(indexedTicketsPerTGT))
+	})
+
+	It("Should find the sessions of a user", func() {
+		for _, id := range []string{"TGT-index-session-1", "TGT-index-session-2"} {
+			tgt := &CASTicketGrantingTicket{Id: id, UserEmail: "sessions@index.test", ExpiresAt: time.Now().Add(time.Hour)}
+			Expect(testCASServer.Db.AddTicketGrantingTicket(tgt)).To(BeNil())
+		}
+		other := &CASTicketGrantingTicket{Id: "TGT-index-session-3", UserEmail: "other@index.test", ExpiresAt: time.Now().Add(time.Hour)}
+		Expect(testCASServer.Db.AddTicketGrantingTicket(other)).To(BeNil())
+
+		tgts, casErr := testCASServer.Db.FindTicketGrantingTicketsForUser("sessions@index.test")
+		Expect(casErr).To(BeNil())
+		Expect(tgts).To(HaveLen(2))
+		for _, tgt := range tgts {
+			Expect(tgt.UserEmail).To(Equal("sessions@index.test"))
+		}
+	})
+
+	It("Should find services by URL and by ID", func() {
+		service := &CASService{Name: "indexed_service", Url: "https://indexed.example.com/cas", AdminEmail: "admin@test.com"}
+		Expect(testCASServer.Db.AddNewService(service)).To(BeNil())
+		Expect(service.Id).ToNot(BeEmpty())
+
+		byUrl, casErr := testCASServer.Db.FindServiceByUrl("https://indexed.example.com/cas")
+		Expect(casErr).To(BeNil())
+		Expect(byUrl.Name).To(Equal("indexed_service"))
+
+		byId, casErr := testCASServer.Db.FindServiceById(service.Id)
+		Expect(casErr).To(BeNil())
+		Expect(byId.Name).To(Equal("indexed_service"))
+
+		_, casErr = testCASServer.Db.FindServiceById("missing")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.Code).To(Equal(ServiceNotFoundError.Code))
+	})
+
+	Measure("Looking tickets up by ticket-granting ticket, with the index and with a filter", func(b Benchmarker) {
+		b.Time("index", func() {
+			tickets, casErr := testCASServer.Db.FindTicketsForTGT("TGT-index-42")
+			Expect(casErr).To(BeNil())
+			Expect(tickets).To(HaveLen(indexedTicketsPerTGT))
+		})
+
+		b.Time("filter", func() {
+			cursor, err := r.DB(testCASServer.Config["dbName"]).
+				Table("tickets").
+				Filter(map[string]interface{}{"tgtId": "TGT-index-42"}).
+				Run(session)
+			Expect(err).To(BeNil())
+			var tickets []CASTicket
+			Expect(cursor.All(&tickets)).To(Succeed())
+			Expect(tickets).To(HaveLen(indexedTicketsPerTGT))
+		})
+	}, 20)
+})
@@ -0,0 +1,371 @@
+package cas
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * Delegated authentication to an upstream CAS server (ex. an institutional CAS), services being managed locally
+ *
+ * Logins asking for delegation (with the DELEGATION_QUERY_PARAM hint) are redirected to the upstream's login, with
+ * casgo's callback as service. The callback validates the ticket the upstream issued (CAS 3.0 /p3/serviceValidate)
+ * and logs the upstream's principal in as a local user, starting a single sign on session like a password login.
+ */
+
+// Query parameter asking /login to delegate authentication to the upstream CAS server (ex. /login?delegate=true)
+const DELEGATION_QUERY_PARAM = "delegate"
+
+// Path of the callback the upstream CAS server redirects users to with a ticket
+const DELEGATION_CALLBACK_PATH = "/login/delegated"
+
+// Time users have to log in with the upstream CAS server
+const DELEGATION_PENDING_TTL = 10 * time.Minute
+
+// Maximum size of the upstream's validation responses
+const DELEGATION_MAX_RESPONSE_SIZE = 64 * 1024
+
+// Session keys of a pending delegated login
+const (
+	DELEGATION_PENDING_STATE_KEY      = "delegationPendingState"
+	DELEGATION_PENDING_SERVICE_KEY    = "delegationPendingService"
+	DELEGATION_PENDING_GATEWAY_KEY    = "delegationPendingGateway"
+	DELEGATION_PENDING_EXPIRES_AT_KEY = "delegationPendingExpiresAt"
+)
+
+// Upstream CAS server users may log in with
+type CASDelegation struct {
+	UpstreamUrl  string            // Base URL of the upstream CAS server (ex. https://cas.example.edu/cas)
+	CallbackUrl  string            // URL of casgo's callback, given to the upstream as service
+	Name         string            // Name of the upstream shown on the login page
+	AttributeMap map[string]string // Upstream attribute -> casgo field (email, name, or a custom attribute)
+	Provision    bool              // Create users that don't exist yet, without a password (as directory users are)
+	Client       *http.Client      // Client tickets are validated with
+}
+
+// Principal authenticated by the upstream CAS server, as released on validation
+type upstreamServiceResponse struct {
+	Success *struct {
+		User       string `xml:"user"`
+		Attributes struct {
+			Values []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:"attributes"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code        string `xml:"code,attr"`
+		Description string `xml:",chardata"`
+	} `xml:"authenticationFailure"`
+}
+
+// Set up delegation from configuration (nil if delegationEnabled is disabled)
+// The callback URL defaults to the callback at addr (over https), like the OIDC issuer
+func NewCASDelegationFromConfig(config map[string]string, addr string) (*CASDelegation, error) {
+	enabled, err := configBool(config, "delegationEnabled")
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	upstreamUrl := strings.TrimSuffix(strings.TrimSpace(config["delegationCasUrl"]), "/")
+	if parsed, err := url.Parse(upstreamUrl); err != nil || parsed.Scheme != "https" || len(parsed.Host) == 0 || len(parsed.RawQuery) > 0 {
+		return nil, fmt.Errorf("Invalid delegationCasUrl [%s], expected the https URL of the upstream CAS server (ex. https://cas.example.edu/cas)", upstreamUrl)
+	}
+
+	callbackUrl := strings.TrimSpace(config["delegationCallbackUrl"])
+	if len(callbackUrl) == 0 {
+		callbackUrl = "https://" + addr + DELEGATION_CALLBACK_PATH
+	}
+	if parsed, err := url.Parse(callbackUrl); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || len(parsed.Host) == 0 || len(parsed.RawQuery) > 0 {
+		return nil, fmt.Errorf("Invalid delegationCallbackUrl [%s], expected the URL casgo's %s callback is reached at", callbackUrl, DELEGATION_CALLBACK_PATH)
+	}
+
+	attributeMap, err := parseAttributeMap("delegationAttributeMap", "upstreamAttribute", config["delegationAttributeMap"])
+	if err != nil {
+		return nil, err
+	}
+
+	provision, err := configBool(config, "delegationProvision")
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSpace(config["delegationName"])
+	if len(name) == 0 {
+		parsed, _ := url.Parse(upstreamUrl)
+		name = parsed.Host
+	}
+
+	return &CASDelegation{
+		UpstreamUrl:  upstreamUrl,
+		CallbackUrl:  callbackUrl,
+		Name:         name,
+		AttributeMap: attributeMap,
+		Provision:    provision,
+		Client:       &http.Client{Timeout: configSecondsAsDuration(config, "delegationTimeout")},
+	}, nil
+}
+
+// Whether a login asks for delegation
+func delegationRequested(req *http.Request) bool {
+	value := strings.TrimSpace(strings.ToLower(req.URL.Query().Get(DELEGATION_QUERY_PARAM)))
+	return value == "true" || value == "1"
+}
+
+// Service URL given to the upstream for a pending login (the state binds the ticket to the session that asked for it)
+// The warn & renew options of the login are carried through it, as the login handler reads them from the request
+func (d *CASDelegation) serviceUrl(state string, warn, renew bool) string {
+	params := url.Values{"state": {state}}
+	if warn {
+		params.Set("warn", "true")
+	}
+	if renew {
+		params.Set("renew", "true")
+	}
+	return urlWithParams(d.CallbackUrl, params)
+}
+
+// URL of the upstream's login page, forwarding renew & gateway (so the upstream prompts, or doesn't, as asked)
+func (d *CASDelegation) loginUrl(serviceUrl string, renew, gateway bool) string {
+	params := url.Values{"service": {serviceUrl}}
+	if renew {
+		params.Set("renew", "true")
+	} else if gateway {
+		params.Set("gateway", "true")
+	}
+	return urlWithParams(d.UpstreamUrl+"/login", params)
+}
+
+// Validate a ticket issued by the upstream, returning the (unmapped) principal and the attributes released with it
+func (d *CASDelegation) validateTicket(serviceUrl, ticket string) (string, map[string][]string, *CASServerError) {
+	validateUrl := urlWithParams(d.UpstreamUrl+"/p3/serviceValidate", url.Values{"service": {serviceUrl}, "ticket": {ticket}})
+	resp, err := d.Client.Get(validateUrl)
+	if err != nil {
+		return "", nil, &FailedToValidateUpstreamTicketError
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, &FailedToValidateUpstreamTicketError
+	}
+
+	var response upstreamServiceResponse
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, DELEGATION_MAX_RESPONSE_SIZE)).Decode(&response); err != nil {
+		return "", nil, &FailedToValidateUpstreamTicketError
+	}
+	if response.Success == nil || len(strings.TrimSpace(response.Success.User)) == 0 {
+		return "", nil, &InvalidUpstreamTicketError
+	}
+
+	attributes := make(map[string][]string)
+	for _, value := range response.Success.Attributes.Values {
+		attributes[value.XMLName.Local] = append(attributes[value.XMLName.Local], strings.TrimSpace(value.Value))
+	}
+	return strings.TrimSpace(response.Success.User), attributes, nil
+}
+
+// Map the upstream's principal into a casgo user (identified by the attribute mapped to email, or the principal)
+func (d *CASDelegation) userFromPrincipal(principal string, attributes map[string][]string) *User {
+	user := &User{
+		Email:      principal,
+		Attributes: make(map[string]string),
+	}
+
+	for upstreamAttr, field := range d.AttributeMap {
+		values := attributes[upstreamAttr]
+		if len(values) == 0 {
+			continue
+		}
+
+		switch field {
+		case "email":
+			user.Email = values[0]
+		case "name":
+			user.Name = values[0]
+		default:
+			user.Attributes[field] = strings.Join(values, LDAP_MULTI_VALUE_SEPARATOR)
+		}
+	}
+
+	user.Email = strings.TrimSpace(strings.ToLower(user.Email))
+	return user
+}
+
+// Find (or provision) the local user the upstream's principal maps to, updated with the attributes released
+func (d *CASDelegation) localUser(db Backend, upstreamUser *User) (*User, *CASServerError) {
+	localUser, casErr := db.FindUserByEmail(upstreamUser.Email)
+	if casErr != nil {
+		if !d.Provision {
+			return nil, &UnknownDelegatedUserError
+		}
+
+		// Provisioned users have no local password, so they can only log in through the upstream
+		if localUser, casErr = db.AddNewUser(upstreamUser.Email, ""); casErr != nil {
+			return nil, casErr
+		}
+	}
+
+	// Names set locally are kept when the upstream doesn't release one
+	if len(upstreamUser.Name) == 0 {
+		upstreamUser.Name = localUser.Name
+	}
+	return updateLocalUser(db, localUser, upstreamUser)
+}
+
+// Redirect a login to the upstream CAS server, remembering (in the session) the service it is for
+func (c *CAS) beginDelegatedLogin(w http.ResponseWriter, req *http.Request, context map[string]interface{}, session *sessions.Session, serviceUrl string, renew, gateway bool) {
+	logger := c.requestLogger(req).With("service", serviceUrl, "upstream", c.Delegation.UpstreamUrl)
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		logger.Error("Failed to generate delegated login state", "error", err)
+		context["Error"] = c.localizeError(context, &FailedToSaveSessionError)
+		c.render.HTML(w, FailedToSaveSessionError.HttpCode, "login", context)
+		return
+	}
+	state := hex.EncodeToString(buf)
+
+	// Expiry is stored as a string, as it must survive both session serializers
+	session.Values[DELEGATION_PENDING_STATE_KEY] = state
+	session.Values[DELEGATION_PENDING_SERVICE_KEY] = serviceUrl
+	session.Values[DELEGATION_PENDING_GATEWAY_KEY] = gateway
+	session.Values[DELEGATION_PENDING_EXPIRES_AT_KEY] = c.now().Add(DELEGATION_PENDING_TTL).UTC().Format(time.RFC3339)
+	if err := session.Save(req, w); err != nil {
+		logger.Error("Failed to save pending delegated login", "error", err)
+		context["Error"] = c.localizeError(context, &FailedToSaveSessionError)
+		c.render.HTML(w, FailedToSaveSessionError.HttpCode, "login", context)
+		return
+	}
+
+	logger.Info("Delegating login to the upstream CAS server")
+	http.Redirect(w, req, c.Delegation.loginUrl(c.Delegation.serviceUrl(state, warnRequested(req), renew), renew, gateway), http.StatusFound)
+}
+
+// Pending delegated login of a session, if its state matches and it hasn't expired (service URL, gateway)
+func pendingDelegatedLogin(session *sessions.Session, state string, now time.Time) (string, bool, bool) {
+	pendingState, _ := session.Values[DELEGATION_PENDING_STATE_KEY].(string)
+	serviceUrl, _ := session.Values[DELEGATION_PENDING_SERVICE_KEY].(string)
+	gateway, _ := session.Values[DELEGATION_PENDING_GATEWAY_KEY].(bool)
+	rawExpiresAt, _ := session.Values[DELEGATION_PENDING_EXPIRES_AT_KEY].(string)
+
+	expiresAt, err := time.Parse(time.RFC3339, rawExpiresAt)
+	if len(pendingState) == 0 || !SecretsEqual(state, pendingState) || err != nil || !now.Before(expiresAt) {
+		return "", false, false
+	}
+	return serviceUrl, gateway, true
+}
+
+func clearPendingDelegatedLogin(session *sessions.Session) {
+	delete(session.Values, DELEGATION_PENDING_STATE_KEY)
+	delete(session.Values, DELEGATION_PENDING_SERVICE_KEY)
+	delete(session.Values, DELEGATION_PENDING_GATEWAY_KEY)
+	delete(session.Values, DELEGATION_PENDING_EXPIRES_AT_KEY)
+}
+
+// Callback the upstream CAS server redirects users to, logging in the user the ticket it issued was for
+func (c *CAS) HandleDelegatedLogin(w http.ResponseWriter, req *http.Request) {
+	defer c.Metrics.ObserveRequest(DELEGATION_CALLBACK_PATH, time.Now())
+
+	context := c.templateContext(w, req)
+	context["RememberMeEnabled"] = c.rememberMeEnabled()
+	context["WarnEnabled"] = c.serviceWarningEnabled()
+	context["Warn"] = warnRequested(req)
+	context["LoginFields"] = c.loginFieldsContext(context, map[string]string{})
+	context["Renew"] = req.URL.Query().Get("renew") == "true"
+
+	state := strings.TrimSpace(req.URL.Query().Get("state"))
+	ticket := strings.TrimSpace(req.URL.Query().Get("ticket"))
+	clientIP := c.clientIP(req)
+	logger := c.requestLogger(req).With("upstream", c.Delegation.UpstreamUrl)
+
+	session, _ := c.sessionStore.Get(req, "casgo-session")
+	serviceUrl, gateway, ok := pendingDelegatedLogin(session, state, c.now())
+	clearPendingDelegatedLogin(session)
+	if !ok {
+		session.Save(req, w)
+		logger.Warn("Delegated login callback without a pending login")
+		context["Error"] = c.localizeError(context, &DelegatedLoginExpiredError)
+		c.render.HTML(w, DelegatedLoginExpiredError.HttpCode, "login", context)
+		return
+	}
+	context["serviceUrl"] = serviceUrl
+	logger = logger.With("service", serviceUrl)
+
+	// The service may have been removed since the login started
+	var casService *CASService
+	if len(serviceUrl) > 0 {
+		casService = c.oidcLoginService(serviceUrl)
+		if casService == nil {
+			var casErr *CASServerError
+			if casService, casErr = c.findServiceForTicket(c.backendFor(req), serviceUrl); casErr != nil {
+				session.Save(req, w)
+				logger.Warn("Delegated login refused, unregistered service URL")
+				context["Error"] = c.localize(context, "login.serviceNotFound", serviceUrl)
+				context["ServiceRefused"] = true
+				c.render.HTML(w, http.StatusNotFound, "login", context)
+				return
+			}
+		}
+	}
+
+	// Upstream gateway logins of users that aren't logged in upstream come back without a ticket
+	if len(ticket) == 0 {
+		session.Save(req, w)
+		if gateway && casService != nil {
+			http.Redirect(w, req, casService.Url, http.StatusFound)
+		} else {
+			c.render.HTML(w, http.StatusOK, "login", context)
+		}
+		return
+	}
+
+	principal, attributes, casErr := c.Delegation.validateTicket(c.Delegation.serviceUrl(state, warnRequested(req), req.URL.Query().Get("renew") == "true"), ticket)
+	var user *User
+	email := principal
+	if casErr == nil {
+		upstreamUser := c.Delegation.userFromPrincipal(principal, attributes)
+		email = upstreamUser.Email
+		user, casErr = c.Delegation.localUser(c.backendFor(req), upstreamUser)
+	}
+	logger = logger.With("username", email)
+
+	// Two-factor authentication is still required, the upstream only stands in for the password
+	if casErr == nil && user.TOTPEnabled() {
+		c.beginTOTPLogin(w, req, logger, context, user, false)
+		return
+	}
+
+	c.recordLoginAttempt(req, logger, email, serviceUrl, clientIP, casErr)
+	if casErr != nil {
+		session.Save(req, w)
+		if gateway && casService != nil {
+			http.Redirect(w, req, casService.Url, http.StatusFound)
+		} else {
+			context["Error"] = c.localizeError(context, casErr)
+			c.render.HTML(w, casErr.HttpCode, "login", context)
+		}
+		return
+	}
+
+	c.completeLogin(w, req, context, user, casService, false)
+}
+
+// URL of the login page delegating to the upstream, for the service being logged in to
+func (c *CAS) delegatedLoginPath(serviceUrl string, renew bool) string {
+	params := url.Values{DELEGATION_QUERY_PARAM: {"true"}}
+	if len(serviceUrl) > 0 {
+		params.Set("service", serviceUrl)
+	}
+	if renew {
+		params.Set("renew", "true")
+	}
+	return c.URLPath("/login") + "?" + params.Encode()
+}
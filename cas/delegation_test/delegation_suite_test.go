@@ -0,0 +1,12 @@
+package delegation_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoDelegation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Delegation Suite")
+}
@@ -0,0 +1,262 @@
+package delegation_test
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+const (
+	testServiceUrl = "localhost:3000/validateCASLogin"
+	callbackUrl    = "https://sso.example.com" + DELEGATION_CALLBACK_PATH
+)
+
+// Upstream CAS server, issuing tickets for a principal and validating them (once) for the service they were issued for
+type upstreamCAS struct {
+	server      *httptest.Server
+	principal   string
+	attributes  string
+	tickets     map[string]string
+	validations int
+}
+
+func newUpstreamCAS() *upstreamCAS {
+	upstream := &upstreamCAS{tickets: map[string]string{}}
+	upstream.server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		Expect(req.URL.Path).To(Equal("/cas/p3/serviceValidate"))
+		upstream.validations++
+
+		service, ticket := req.URL.Query().Get("service"), req.URL.Query().Get("ticket")
+		w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+		if issuedFor, ok := upstream.tickets[ticket]; !ok || issuedFor != service {
+			fmt.Fprintf(w, `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas"><cas:authenticationFailure code="INVALID_TICKET">Ticket %s not recognized</cas:authenticationFailure></cas:serviceResponse>`, ticket)
+			return
+		}
+		delete(upstream.tickets, ticket)
+		fmt.Fprintf(w, `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">
+  <cas:authenticationSuccess>
+    <cas:user>%s</cas:user>
+    <cas:attributes>%s</cas:attributes>
+  </cas:authenticationSuccess>
+</cas:serviceResponse>`, upstream.principal, upstream.attributes)
+	}))
+	return upstream
+}
+
+func (u *upstreamCAS) url() string {
+	return u.server.URL + "/cas"
+}
+
+// Log the user in upstream, as the upstream's login page would, returning the URL the user is sent back to
+func (u *upstreamCAS) login(loginUrl string) *url.URL {
+	parsed, err := url.Parse(loginUrl)
+	Expect(err).To(BeNil())
+	Expect(parsed.Path).To(Equal("/cas/login"))
+
+	service := parsed.Query().Get("service")
+	ticket := fmt.Sprintf("ST-upstream-%d", len(u.tickets)+u.validations+1)
+	u.tickets[ticket] = service
+
+	callback, err := url.Parse(service)
+	Expect(err).To(BeNil())
+	query := callback.Query()
+	query.Set("ticket", ticket)
+	callback.RawQuery = query.Encode()
+	return callback
+}
+
+var _ = Describe("Delegated authentication", func() {
+	var (
+		server   *CAS
+		db       *MemoryBackend
+		upstream *upstreamCAS
+		client   *castest.Client
+	)
+
+	// Start a delegated login, returning the upstream login URL it redirected to
+	beginLogin := func(params url.Values) string {
+		params.Set(DELEGATION_QUERY_PARAM, "true")
+		w := client.Get("/login?" + params.Encode())
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(HavePrefix(upstream.url() + "/login?"))
+		return w.Header().Get("Location")
+	}
+
+	// Come back from the upstream to the callback
+	callback := func(returnUrl *url.URL) *httptest.ResponseRecorder {
+		Expect(returnUrl.Scheme + "://" + returnUrl.Host + returnUrl.Path).To(Equal(callbackUrl))
+		return client.Get(returnUrl.Path + "?" + returnUrl.RawQuery)
+	}
+
+	// User a (local) ticket from a login redirect was issued for, as validated by the service
+	ticketUser := func(w *httptest.ResponseRecorder) string {
+		Expect(w.Code).To(Equal(http.StatusFound), w.Body.String())
+		location, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).To(BeNil())
+		Expect(location.Query().Get("ticket")).NotTo(BeEmpty())
+
+		validation := castest.NewClient(server).Get("/validate?" + url.Values{"service": {testServiceUrl}, "ticket": {location.Query().Get("ticket")}}.Encode())
+		var response map[string]interface{}
+		Expect(json.Unmarshal(validation.Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("success"))
+		return response["userEmail"].(string)
+	}
+
+	newServer := func(overrides map[string]string) {
+		config := map[string]string{
+			"delegationEnabled":      "true",
+			"delegationCasUrl":       upstream.url(),
+			"delegationName":         "Example University",
+			"delegationCallbackUrl":  callbackUrl,
+			"delegationAttributeMap": "mail:email,displayName:name,eduPersonAffiliation:affiliation",
+		}
+		for key, value := range overrides {
+			config[key] = value
+		}
+
+		server, db = castest.NewTestServer(config)
+		if server.Delegation != nil {
+			server.Delegation.Client = upstream.server.Client()
+		}
+		client = castest.NewClient(server)
+	}
+
+	BeforeEach(func() {
+		upstream = newUpstreamCAS()
+		upstream.principal = "jdoe"
+		upstream.attributes = `<cas:mail>Test@Test.com</cas:mail><cas:displayName>Jane Doe</cas:displayName><cas:eduPersonAffiliation>staff</cas:eduPersonAffiliation><cas:eduPersonAffiliation>member</cas:eduPersonAffiliation>`
+		newServer(nil)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+		upstream.server.Close()
+	})
+
+	It("Should log in the local user mapped from the upstream principal, starting a single sign on session", func() {
+		loginUrl := beginLogin(url.Values{"service": {testServiceUrl}})
+		Expect(ticketUser(callback(upstream.login(loginUrl)))).To(Equal("test@test.com"))
+		Expect(upstream.validations).To(Equal(1))
+
+		tgts, casErr := db.FindTicketGrantingTicketsForUser("test@test.com")
+		Expect(casErr).To(BeNil())
+		Expect(tgts).To(HaveLen(1))
+
+		user, _ := db.FindUserByEmail("test@test.com")
+		Expect(user.Name).To(Equal("Jane Doe"))
+		Expect(user.Attributes["affiliation"]).To(Equal("staff;member"))
+
+		// Later logins use the local session, without going back upstream
+		Expect(ticketUser(client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode()))).To(Equal("test@test.com"))
+	})
+
+	It("Should forward renew to the upstream, and issue tickets that pass renew validation", func() {
+		loginUrl := beginLogin(url.Values{"service": {testServiceUrl}, "renew": {"true"}})
+		parsed, _ := url.Parse(loginUrl)
+		Expect(parsed.Query().Get("renew")).To(Equal("true"))
+
+		w := callback(upstream.login(loginUrl))
+		Expect(w.Code).To(Equal(http.StatusFound))
+		location, _ := url.Parse(w.Header().Get("Location"))
+
+		req := httptest.NewRequest("GET", "/serviceValidate?"+url.Values{"service": {testServiceUrl}, "ticket": {location.Query().Get("ticket")}, "renew": {"true"}}.Encode(), nil)
+		validation := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(validation, req)
+		Expect(validation.Body.String()).To(ContainSubstring("authenticationSuccess"))
+	})
+
+	It("Should use the principal when no attribute is mapped to email", func() {
+		upstream.principal = "Admin@Test.com"
+		upstream.attributes = ""
+		loginUrl := beginLogin(url.Values{"service": {testServiceUrl}})
+		Expect(ticketUser(callback(upstream.login(loginUrl)))).To(Equal("admin@test.com"))
+	})
+
+	It("Should refuse principals mapping to unknown users unless provisioning is enabled", func() {
+		upstream.attributes = `<cas:mail>new@test.com</cas:mail><cas:displayName>New User</cas:displayName>`
+		w := callback(upstream.login(beginLogin(url.Values{"service": {testServiceUrl}})))
+		Expect(w.Code).To(Equal(UnknownDelegatedUserError.HttpCode))
+		_, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).NotTo(BeNil())
+
+		newServer(map[string]string{"delegationProvision": "true"})
+		Expect(ticketUser(callback(upstream.login(beginLogin(url.Values{"service": {testServiceUrl}}))))).To(Equal("new@test.com"))
+		user, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).To(BeNil())
+		Expect(user.Name).To(Equal("New User"))
+		Expect(user.Password).To(BeEmpty())
+	})
+
+	It("Should refuse tickets the upstream doesn't validate, and callbacks of logins that weren't started in the session", func() {
+		returnUrl := upstream.login(beginLogin(url.Values{"service": {testServiceUrl}}))
+		query := returnUrl.Query()
+		query.Set("ticket", "ST-forged")
+		returnUrl.RawQuery = query.Encode()
+		w := callback(returnUrl)
+		Expect(w.Code).To(Equal(InvalidUpstreamTicketError.HttpCode))
+		Expect(upstream.validations).To(Equal(1))
+
+		// The pending login is used up, even by a failed callback
+		Expect(callback(returnUrl).Code).To(Equal(DelegatedLoginExpiredError.HttpCode))
+
+		// Another browser can't complete the login with a ticket issued for this one
+		returnUrl = upstream.login(beginLogin(url.Values{"service": {testServiceUrl}}))
+		client = castest.NewClient(server)
+		Expect(callback(returnUrl).Code).To(Equal(DelegatedLoginExpiredError.HttpCode))
+		Expect(upstream.validations).To(Equal(1))
+	})
+
+	It("Should send gateway logins the upstream doesn't authenticate back to the service without a ticket", func() {
+		loginUrl := beginLogin(url.Values{"service": {testServiceUrl}, "gateway": {"true"}})
+		parsed, _ := url.Parse(loginUrl)
+		Expect(parsed.Query().Get("gateway")).To(Equal("true"))
+
+		returnUrl, _ := url.Parse(parsed.Query().Get("service"))
+		w := callback(returnUrl)
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal(testServiceUrl))
+		Expect(upstream.validations).To(Equal(0))
+	})
+
+	It("Should only delegate logins asking for it, offering delegation on the login form", func() {
+		w := client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`name="password"`))
+		Expect(w.Body.String()).To(ContainSubstring("Log in with Example University"))
+		Expect(w.Body.String()).To(ContainSubstring(`href="/login?delegate=true&amp;service=` + url.QueryEscape(testServiceUrl)))
+
+		newServer(map[string]string{"delegationEnabled": "false"})
+		w = client.Get("/login?" + url.Values{"service": {testServiceUrl}, DELEGATION_QUERY_PARAM: {"true"}}.Encode())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).NotTo(ContainSubstring("delegated-login"))
+		Expect(client.Get(DELEGATION_CALLBACK_PATH + "?state=x&ticket=y").Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should refuse to enable delegation without an https upstream", func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["delegationEnabled"] = "true"
+		config["delegationCasUrl"] = "http://cas.example.edu/cas"
+		config["delegationAttributeMap"] = "mail"
+		Expect(ValidateConfig(config)).To(ConsistOf(
+			"delegationCasUrl [http://cas.example.edu/cas] must be the https URL of the upstream CAS server (ex. https://cas.example.edu/cas)",
+			"Invalid delegationAttributeMap entry [mail], expected upstreamAttribute:casgoField",
+		))
+
+		_, err = NewCASDelegationFromConfig(config, "localhost:9090")
+		Expect(err).To(HaveOccurred())
+		config["delegationCasUrl"] = "https://cas.example.edu/cas/"
+		config["delegationAttributeMap"] = "mail:email"
+		delegation, err := NewCASDelegationFromConfig(config, "localhost:9090/cas")
+		Expect(err).To(BeNil())
+		Expect(delegation.UpstreamUrl).To(Equal("https://cas.example.edu/cas"))
+		Expect(delegation.CallbackUrl).To(Equal("https://localhost:9090/cas" + DELEGATION_CALLBACK_PATH))
+		Expect(delegation.Name).To(Equal("cas.example.edu"))
+	})
+})
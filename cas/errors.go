@@ -6,221 +6,874 @@ import (
 
 func (err *CASServerError) Error() string { return err.Msg }
 
+// Actual error that was thrown (if any), ex. the AuthFailures of an AuthenticatorChain
+func (err *CASServerError) Unwrap() error {
+	if err.err == nil {
+		return nil
+	}
+	return *err.err
+}
+
 // Error declarations
 var (
 	// Input errors (error codes 100-199)
 	InvalidEmailAddressError = CASServerError{
 		Msg:          "An error occurred finding a user with that email address.. Please wait a while and try again",
+		MsgKey:       "error.invalidEmailAddress",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 100,
+		Code:         "INVALID_EMAIL_ADDRESS",
 	}
 	InvalidCredentialsError = CASServerError{
 		Msg:          "Invalid email/password combination",
+		MsgKey:       "error.invalidCredentials",
 		HttpCode:     http.StatusUnauthorized,
 		CasgoErrCode: 101,
+		Code:         "INVALID_CREDENTIALS",
 	}
 	FailedToFindServiceError = CASServerError{
 		Msg:          "Failed to find matching service",
+		MsgKey:       "error.failedToFindService",
 		HttpCode:     http.StatusNotImplemented,
 		CasgoErrCode: 102,
+		Code:         "FAILED_TO_FIND_SERVICE",
 	}
 	FailedToFindTicketError = CASServerError{
 		Msg:          "Failed to find matching ticket",
+		MsgKey:       "error.failedToFindTicket",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 103,
+		Code:         "FAILED_TO_FIND_TICKET",
 	}
 	SSOAuthenticatedUserRenewError = CASServerError{
 		Msg:          "Failed to validate ticket, renew option specified and user was SSO authenticated",
+		MsgKey:       "error.ssoAuthenticatedUserRenew",
 		HttpCode:     http.StatusNotImplemented,
 		CasgoErrCode: 103,
+		Code:         "SSO_AUTHENTICATED_USER_RENEW",
 	}
 	EmailAlreadyTakenError = CASServerError{
 		Msg:          "Looks like that email address is already taken. If you've forgotten your password, please contact the administrator",
+		MsgKey:       "error.emailAlreadyTaken",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 104,
+		Code:         "EMAIL_ALREADY_TAKEN",
 	}
 	FailedToFindUserError = CASServerError{
 		Msg:          "Failed to find matching email/password combination",
+		MsgKey:       "error.failedToFindUser",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 105,
+		Code:         "FAILED_TO_FIND_USER",
 	}
 	FailedToRetrieveServicesError = CASServerError{
 		Msg:          "Failed to retrieve services for logged in user. Please ensure you are logged in.",
+		MsgKey:       "error.failedToRetrieveServices",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 106,
+		Code:         "FAILED_TO_RETRIEVE_SERVICES",
 	}
 	ServiceNameAlreadyTakenError = CASServerError{
 		Msg:          "Looks like that service name is already taken. Please use a different service name.",
+		MsgKey:       "error.serviceNameAlreadyTaken",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 107,
+		Code:         "SERVICE_NAME_ALREADY_TAKEN",
 	}
 	InvalidServiceNameError = CASServerError{
 		Msg:          "Invalid service name provided.",
+		MsgKey:       "error.invalidServiceName",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 108,
+		Code:         "INVALID_SERVICE_NAME",
 	}
 	FailedToAuthenticateUserError = CASServerError{
 		Msg:          "Failed to authenticate API user. Please ensure that you have provided sufficient credentials (whether through relevant headers or session information)..",
+		MsgKey:       "error.failedToAuthenticateUser",
 		HttpCode:     http.StatusUnauthorized,
 		CasgoErrCode: 109,
+		Code:         "AUTH_FAILED",
 	}
 	FailedToFindUserByApiKeyAndSecretError = CASServerError{
 		Msg:          "Failed to find user with given API credentials. Please ensure credentials are valid and try again.",
+		MsgKey:       "error.failedToFindUserByApiKeyAndSecret",
 		HttpCode:     http.StatusUnauthorized,
 		CasgoErrCode: 110,
+		Code:         "FAILED_TO_FIND_USER_BY_API_KEY_AND_SECRET",
 	}
 	InsufficientPermissionsError = CASServerError{
 		Msg:          "Authenticated user has insufficient permissions to perform this action.",
+		MsgKey:       "error.insufficientPermissions",
 		HttpCode:     http.StatusForbidden,
 		CasgoErrCode: 111,
+		Code:         "INSUFFICIENT_PERMISSIONS",
 	}
 	InvalidServiceError = CASServerError{
 		Msg:          "Incomplete/Invalid service object provided. Please ensure all appropriate service fields are filled and re-submit.",
+		MsgKey:       "error.invalidService",
 		HttpCode:     http.StatusForbidden,
 		CasgoErrCode: 112,
+		Code:         "INVALID_SERVICE",
 	}
 	FailedToParseJSONError = CASServerError{
 		Msg:          "Incomplete/Invalid JSON. Please ensure request body is properly formatted and retry.",
+		MsgKey:       "error.failedToParseJSON",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 113,
+		Code:         "FAILED_TO_PARSE_JSON",
 	}
 	InvalidUserError = CASServerError{
 		Msg:          "Incomplete/Invalid user object provided. Please ensure all appropriate user fields are filled and re-submit.",
+		MsgKey:       "error.invalidUser",
 		HttpCode:     http.StatusForbidden,
 		CasgoErrCode: 114,
+		Code:         "INVALID_USER",
 	}
 	InvalidUserEmailError = CASServerError{
 		Msg:          "Invalid user email provided.",
+		MsgKey:       "error.invalidUserEmail",
 		HttpCode:     http.StatusBadRequest,
 		CasgoErrCode: 115,
+		Code:         "INVALID_USER_EMAIL",
+	}
+	MissingValidationParametersError = CASServerError{
+		Msg:          "Both service and ticket parameters are required for validation",
+		MsgKey:       "error.missingValidationParameters",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 116,
+		Code:         "MISSING_VALIDATION_PARAMETERS",
+	}
+	InvalidProxyCallbackUrlError = CASServerError{
+		Msg:          "Invalid proxy callback URL provided. Proxy callback URLs must use HTTPS.",
+		MsgKey:       "error.invalidProxyCallbackUrl",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 117,
+		Code:         "INVALID_PROXY_CALLBACK_URL",
+	}
+	MissingProxyParametersError = CASServerError{
+		Msg:          "Both pgt and targetService parameters are required for proxy ticket requests",
+		MsgKey:       "error.missingProxyParameters",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 118,
+		Code:         "MISSING_PROXY_PARAMETERS",
+	}
+	FailedToFindProxyGrantingTicketError = CASServerError{
+		Msg:          "Failed to find matching proxy-granting ticket",
+		MsgKey:       "error.failedToFindProxyGrantingTicket",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 119,
+		Code:         "FAILED_TO_FIND_PROXY_GRANTING_TICKET",
+	}
+	ExpiredProxyGrantingTicketError = CASServerError{
+		Msg:          "Proxy-granting ticket has expired",
+		MsgKey:       "error.expiredProxyGrantingTicket",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 120,
+		Code:         "EXPIRED_PROXY_GRANTING_TICKET",
+	}
+	FailedToFindProxyTicketError = CASServerError{
+		Msg:          "Failed to find matching proxy ticket",
+		MsgKey:       "error.failedToFindProxyTicket",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 121,
+		Code:         "FAILED_TO_FIND_PROXY_TICKET",
+	}
+	ExpiredProxyTicketError = CASServerError{
+		Msg:          "Proxy ticket has expired",
+		MsgKey:       "error.expiredProxyTicket",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 122,
+		Code:         "EXPIRED_PROXY_TICKET",
+	}
+	ProxyTicketServiceMismatchError = CASServerError{
+		Msg:          "Proxy ticket was not issued for the given service",
+		MsgKey:       "error.proxyTicketServiceMismatch",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 123,
+		Code:         "PROXY_TICKET_SERVICE_MISMATCH",
+	}
+	TooManyLoginAttemptsError = CASServerError{
+		Msg:          "Too many failed login attempts, please wait a while and try again",
+		MsgKey:       "error.tooManyLoginAttempts",
+		HttpCode:     http.StatusTooManyRequests,
+		CasgoErrCode: 124,
+		Code:         "TOO_MANY_LOGIN_ATTEMPTS",
+	}
+	FailedToFindTicketGrantingTicketError = CASServerError{
+		Msg:          "Failed to find login session",
+		MsgKey:       "error.failedToFindTicketGrantingTicket",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 125,
+		Code:         "FAILED_TO_FIND_TICKET_GRANTING_TICKET",
+	}
+	ExpiredTicketGrantingTicketError = CASServerError{
+		Msg:          "Login session has expired, please log in again",
+		MsgKey:       "error.expiredTicketGrantingTicket",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 126,
+		Code:         "EXPIRED_TICKET_GRANTING_TICKET",
+	}
+	InvalidAPITokenError = CASServerError{
+		Msg:          "Invalid API token",
+		MsgKey:       "error.invalidAPIToken",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 127,
+		Code:         "INVALID_API_TOKEN",
+	}
+	ExpiredAPITokenError = CASServerError{
+		Msg:          "API token has expired, please request a new one",
+		MsgKey:       "error.expiredAPIToken",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 128,
+		Code:         "EXPIRED_API_TOKEN",
+	}
+	FailedToFindSessionError = CASServerError{
+		Msg:          "Failed to find session",
+		MsgKey:       "error.failedToFindSession",
+		HttpCode:     http.StatusNotFound,
+		CasgoErrCode: 129,
+		Code:         "FAILED_TO_FIND_SESSION",
+	}
+	InvalidPaginationParametersError = CASServerError{
+		Msg:          "Invalid pagination parameters, limit and offset must be non-negative integers",
+		MsgKey:       "error.invalidPaginationParameters",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 130,
+		Code:         "INVALID_PAGINATION_PARAMETERS",
+	}
+	InvalidTOTPCodeError = CASServerError{
+		Msg:          "Invalid two-factor authentication code",
+		MsgKey:       "error.invalidTOTPCode",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 131,
+		Code:         "INVALID_TOTP_CODE",
+	}
+	TOTPNotEnrolledError = CASServerError{
+		Msg:          "Two-factor authentication has not been set up for this user",
+		MsgKey:       "error.totpNotEnrolled",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 132,
+		Code:         "TOTP_NOT_ENROLLED",
+	}
+	TOTPLoginExpiredError = CASServerError{
+		Msg:          "Two-factor login has expired, please log in again",
+		MsgKey:       "error.totpLoginExpired",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 133,
+		Code:         "TOTP_LOGIN_EXPIRED",
+	}
+	InvalidServiceImportError = CASServerError{
+		Msg:          "One or more services could not be imported, no services were changed",
+		MsgKey:       "error.invalidServiceImport",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 134,
+		Code:         "INVALID_SERVICE_IMPORT",
+	}
+	DuplicateImportedServiceError = CASServerError{
+		Msg:          "A service with the same name or URL appears earlier in the import",
+		MsgKey:       "error.duplicateImportedService",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 135,
+		Code:         "DUPLICATE_IMPORTED_SERVICE",
+	}
+	ServiceUrlAlreadyTakenError = CASServerError{
+		Msg:          "Looks like that service URL is already used by another service.",
+		MsgKey:       "error.serviceUrlAlreadyTaken",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 136,
+		Code:         "SERVICE_URL_ALREADY_TAKEN",
+	}
+	InvalidServiceImportParametersError = CASServerError{
+		Msg:          "Invalid import parameters, partial and overwrite must be true or false",
+		MsgKey:       "error.invalidServiceImportParameters",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 137,
+		Code:         "INVALID_SERVICE_IMPORT_PARAMETERS",
+	}
+	UnsupportedBackupError = CASServerError{
+		Msg:          "Backup is not a casgo backup, or was made by a newer version of casgo",
+		MsgKey:       "error.unsupportedBackup",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 138,
+		Code:         "UNSUPPORTED_BACKUP",
+	}
+	InvalidBackupRestoreError = CASServerError{
+		Msg:          "One or more services or users could not be restored, nothing was changed",
+		MsgKey:       "error.invalidBackupRestore",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 139,
+		Code:         "INVALID_BACKUP_RESTORE",
+	}
+	DuplicateRestoredUserError = CASServerError{
+		Msg:          "A user with the same email appears earlier in the backup",
+		MsgKey:       "error.duplicateRestoredUser",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 140,
+		Code:         "DUPLICATE_RESTORED_USER",
+	}
+	InvalidServiceUrlPatternError = CASServerError{
+		Msg:          "Invalid service URL pattern. Please ensure the URL is valid for the service's matchMode (regular expressions must compile and not be overly complex).",
+		MsgKey:       "error.invalidServiceUrlPattern",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 141,
+		Code:         "INVALID_SERVICE_URL_PATTERN",
+	}
+	InsecureServiceUrlError = CASServerError{
+		Msg:          "Service URL must use HTTPS",
+		MsgKey:       "error.insecureServiceUrl",
+		HttpCode:     http.StatusForbidden,
+		CasgoErrCode: 142,
+		Code:         "INSECURE_SERVICE_URL",
+	}
+	InvalidAuditQueryParametersError = CASServerError{
+		Msg:          "Invalid audit query parameters, since and until must be RFC 3339 times and limit a number between 1 and 1000",
+		MsgKey:       "error.invalidAuditQueryParameters",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 143,
+		Code:         "INVALID_AUDIT_QUERY_PARAMETERS",
+	}
+	InvalidCSRFTokenError = CASServerError{
+		Msg:          "Your form has expired or is invalid, please try again",
+		MsgKey:       "error.invalidCSRFToken",
+		HttpCode:     http.StatusForbidden,
+		CasgoErrCode: 144,
+		Code:         "INVALID_CSRF_TOKEN",
+	}
+	WeakPasswordError = CASServerError{
+		Msg:          "Password does not comply with the password policy",
+		MsgKey:       "error.weakPassword",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 145,
+		Code:         "WEAK_PASSWORD",
+	}
+	InvalidOIDCClientError = CASServerError{
+		Msg:          "Unknown OpenID Connect client, or redirect URI not registered for it",
+		MsgKey:       "error.invalidOIDCClient",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 146,
+		Code:         "INVALID_OIDC_CLIENT",
+	}
+	ServiceTicketLimitReachedError = CASServerError{
+		Msg:          "Too many service tickets were issued for this login session, please log in again",
+		MsgKey:       "error.serviceTicketLimitReached",
+		HttpCode:     http.StatusForbidden,
+		CasgoErrCode: 147,
+		Code:         "SERVICE_TICKET_LIMIT_REACHED",
+	}
+	ServiceTicketRateExceededError = CASServerError{
+		Msg:          "Service tickets are being requested too quickly for this login session, please try again later",
+		MsgKey:       "error.serviceTicketRateExceeded",
+		HttpCode:     http.StatusTooManyRequests,
+		CasgoErrCode: 148,
+		Code:         "SERVICE_TICKET_RATE_EXCEEDED",
+	}
+
+	InvalidUserRoleError = CASServerError{
+		Msg:          "Invalid user role, role must be either admin or regular",
+		MsgKey:       "error.invalidUserRole",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 149,
+		Code:         "INVALID_USER_ROLE",
+	}
+
+	RequestBodyTooLargeError = CASServerError{
+		Msg:          "Request body is too large",
+		MsgKey:       "error.requestBodyTooLarge",
+		HttpCode:     http.StatusRequestEntityTooLarge,
+		CasgoErrCode: 150,
+		Code:         "REQUEST_BODY_TOO_LARGE",
+	}
+
+	ServiceNotFoundError = CASServerError{
+		Msg:          "Failed to find service",
+		MsgKey:       "error.serviceNotFound",
+		HttpCode:     http.StatusNotFound,
+		CasgoErrCode: 151,
+		Code:         "SERVICE_NOT_FOUND",
+	}
+
+	ServiceVersionConflictError = CASServerError{
+		Msg:          "Service has been changed since the given version, please retrieve it and try again",
+		MsgKey:       "error.serviceVersionConflict",
+		HttpCode:     http.StatusConflict,
+		CasgoErrCode: 152,
+		Code:         "SERVICE_VERSION_CONFLICT",
+	}
+
+	ServiceVersionRequiredError = CASServerError{
+		Msg:          "Service updates must give the version they replace, with an If-Match header",
+		MsgKey:       "error.serviceVersionRequired",
+		HttpCode:     http.StatusPreconditionRequired,
+		CasgoErrCode: 153,
+		Code:         "SERVICE_VERSION_REQUIRED",
+	}
+
+	InvalidServiceUrlError = CASServerError{
+		Msg:          "Invalid service URL, http(s) URLs must have a host and URLs may not contain whitespace",
+		MsgKey:       "error.invalidServiceUrl",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 154,
+		Code:         "INVALID_SERVICE_URL",
+	}
+
+	ProxyTicketRenewError = CASServerError{
+		Msg:          "Failed to validate ticket, renew option specified and proxy tickets are not issued from a fresh login",
+		MsgKey:       "error.proxyTicketRenew",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 155,
+		Code:         "PROXY_TICKET_RENEW",
+	}
+
+	ProxyTicketNotAcceptedError = CASServerError{
+		Msg:          "Failed to validate ticket, proxy tickets can only be validated with proxyValidate",
+		MsgKey:       "error.proxyTicketNotAccepted",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 156,
+		Code:         "PROXY_TICKET_NOT_ACCEPTED",
+	}
+
+	TicketServiceMismatchError = CASServerError{
+		Msg:          "Ticket was not issued for the given service",
+		MsgKey:       "error.ticketServiceMismatch",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 157,
+		Code:         "TICKET_SERVICE_MISMATCH",
+	}
+
+	TicketAlreadyValidatedError = CASServerError{
+		Msg:          "Ticket has already been validated",
+		MsgKey:       "error.ticketAlreadyValidated",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 158,
+		Code:         "TICKET_ALREADY_VALIDATED",
+	}
+
+	ExpiredServiceTicketError = CASServerError{
+		Msg:          "Service ticket has expired",
+		MsgKey:       "error.expiredServiceTicket",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 159,
+		Code:         "EXPIRED_SERVICE_TICKET",
+	}
+
+	AdminAlreadyExistsError = CASServerError{
+		Msg:          "An admin user already exists",
+		MsgKey:       "error.adminAlreadyExists",
+		HttpCode:     http.StatusConflict,
+		CasgoErrCode: 160,
+		Code:         "ADMIN_ALREADY_EXISTS",
+	}
+
+	UnknownTrustedHeaderUserError = CASServerError{
+		Msg:          "The user authenticated by the proxy is not known",
+		MsgKey:       "error.unknownTrustedHeaderUser",
+		HttpCode:     http.StatusForbidden,
+		CasgoErrCode: 161,
+		Code:         "UNKNOWN_TRUSTED_HEADER_USER",
+	}
+
+	InvalidServiceSecretError = CASServerError{
+		Msg:          "The service did not present its secret",
+		MsgKey:       "error.invalidServiceSecret",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 162,
+		Code:         "INVALID_SERVICE_SECRET",
+	}
+
+	WeakServiceSecretError = CASServerError{
+		Msg:          "Service secrets must be at least 16 characters long",
+		MsgKey:       "error.weakServiceSecret",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 163,
+		Code:         "WEAK_SERVICE_SECRET",
+	}
+	DelegatedLoginExpiredError = CASServerError{
+		Msg:          "The login with the upstream CAS server expired or was not started here, please log in again",
+		MsgKey:       "error.delegatedLoginExpired",
+		HttpCode:     http.StatusBadRequest,
+		CasgoErrCode: 164,
+		Code:         "DELEGATED_LOGIN_EXPIRED",
+	}
+	InvalidUpstreamTicketError = CASServerError{
+		Msg:          "The upstream CAS server refused the ticket it issued",
+		MsgKey:       "error.invalidUpstreamTicket",
+		HttpCode:     http.StatusUnauthorized,
+		CasgoErrCode: 165,
+		Code:         "INVALID_UPSTREAM_TICKET",
+	}
+	UnknownDelegatedUserError = CASServerError{
+		Msg:          "The user authenticated by the upstream CAS server is not known",
+		MsgKey:       "error.unknownDelegatedUser",
+		HttpCode:     http.StatusForbidden,
+		CasgoErrCode: 166,
+		Code:         "UNKNOWN_DELEGATED_USER",
 	}
 
 	// Internal Server errors (error codes 200 - 299)
 	FailedToSaveSessionError = CASServerError{
 		Msg:          "Failed to save session",
+		MsgKey:       "error.failedToSaveSession",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 200,
+		Code:         "FAILED_TO_SAVE_SESSION",
 	}
 	FailedToDeleteSessionError = CASServerError{
 		Msg:          "Failed to delete session",
+		MsgKey:       "error.failedToDeleteSession",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 201,
+		Code:         "FAILED_TO_DELETE_SESSION",
 	}
 	FailedToCreateNewAuthTicketError = CASServerError{
 		Msg:          "Failed to create new authentication ticket",
+		MsgKey:       "error.failedToCreateNewAuthTicket",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 202,
+		Code:         "FAILED_TO_CREATE_NEW_AUTH_TICKET",
 	}
 	AuthMethodNotSupportedError = CASServerError{
 		Msg:          "Failed to create new authentication ticket",
+		MsgKey:       "error.authMethodNotSupported",
 		HttpCode:     http.StatusMethodNotAllowed,
 		CasgoErrCode: 203,
+		Code:         "AUTH_METHOD_NOT_SUPPORTED",
 	}
 	FailedToCreateUserError = CASServerError{
 		Msg:          "An error occurred while creating your account.. Please verify fields and try again",
+		MsgKey:       "error.failedToCreateUser",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 204,
+		Code:         "FAILED_TO_CREATE_USER",
 	}
 	FailedToTeardownDatabaseError = CASServerError{
 		Msg:          "Failed to tear down database",
+		MsgKey:       "error.failedToTeardownDatabase",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 205,
+		Code:         "FAILED_TO_TEARDOWN_DATABASE",
 	}
 	FailedToSetupDatabaseError = CASServerError{
 		Msg:          "Failed to setup database",
+		MsgKey:       "error.failedToSetupDatabase",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 206,
+		Code:         "FAILED_TO_SETUP_DATABASE",
 	}
 	FailedToLoadJSONFixtureError = CASServerError{
 		Msg:          "Failed to import database information from file",
+		MsgKey:       "error.failedToLoadJSONFixture",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 207,
+		Code:         "FAILED_TO_LOAD_JSON_FIXTURE",
 	}
 	FailedToLookupServiceByUrlError = CASServerError{
 		Msg:          "An error occurred while searching for service with given URL",
+		MsgKey:       "error.failedToLookupServiceByUrl",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 208,
+		Code:         "FAILED_TO_LOOKUP_SERVICE_BY_URL",
 	}
 	FailedToCreateTicketError = CASServerError{
 		Msg:          "Failed to create ticket",
+		MsgKey:       "error.failedToCreateTicket",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 209,
+		Code:         "FAILED_TO_CREATE_TICKET",
 	}
 	FailedToDeleteTicketsForUserError = CASServerError{
 		Msg:          "Failed to delete tickets for user",
+		MsgKey:       "error.failedToDeleteTicketsForUser",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 210,
+		Code:         "FAILED_TO_DELETE_TICKETS_FOR_USER",
 	}
 	FailedToSetupTableError = CASServerError{
 		Msg:          "Failed to setup table",
+		MsgKey:       "error.failedToSetupTable",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 211,
+		Code:         "FAILED_TO_SETUP_TABLE",
 	}
 	FailedToCreateTableError = CASServerError{
 		Msg:          "Failed to setup database",
+		MsgKey:       "error.failedToCreateTable",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 212,
+		Code:         "FAILED_TO_CREATE_TABLE",
 	}
 	DbExistsCheckFailedError = CASServerError{
 		Msg:          "Failed to check whether database existed",
+		MsgKey:       "error.dbExistsCheckFailed",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 213,
+		Code:         "DB_EXISTS_CHECK_FAILED",
 	}
 	FailedToFindServiceByUrlError = CASServerError{
 		Msg:          "Failed to find service with given URL",
+		MsgKey:       "error.failedToFindServiceByUrl",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 214,
+		Code:         "FAILED_TO_FIND_SERVICE_BY_URL",
 	}
 	FailedToFindUserByEmailError = CASServerError{
 		Msg:          "Failed to find user with given email address",
+		MsgKey:       "error.failedToFindUserByEmail",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 215,
+		Code:         "FAILED_TO_FIND_USER_BY_EMAIL",
 	}
 	FailedToRetrieveInformationFromSessionError = CASServerError{
 		Msg:          "Failed to retrieve information from session",
+		MsgKey:       "error.failedToRetrieveInformationFromSession",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 216,
+		Code:         "FAILED_TO_RETRIEVE_INFORMATION_FROM_SESSION",
 	}
 	FailedToCreateServiceError = CASServerError{
 		Msg:          "An error occurred while creating the service... Please verify fields and try again",
+		MsgKey:       "error.failedToCreateService",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 217,
+		Code:         "FAILED_TO_CREATE_SERVICE",
 	}
 	FailedToDeleteServiceError = CASServerError{
 		Msg:          "Failed to delete service.",
+		MsgKey:       "error.failedToDeleteService",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 218,
+		Code:         "FAILED_TO_DELETE_SERVICE",
 	}
 	FailedToListServicesError = CASServerError{
 		Msg:          "Failed to list services.",
+		MsgKey:       "error.failedToListServices",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 219,
+		Code:         "FAILED_TO_LIST_SERVICES",
 	}
 	FailedToUpdateServiceError = CASServerError{
 		Msg:          "Failed to update service.",
+		MsgKey:       "error.failedToUpdateService",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 220,
+		Code:         "FAILED_TO_UPDATE_SERVICE",
 	}
 	FailedToListUsersError = CASServerError{
 		Msg:          "Failed to list users.",
+		MsgKey:       "error.failedToListUsers",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 221,
+		Code:         "FAILED_TO_LIST_USERS",
 	}
 	FailedToDeleteUserError = CASServerError{
 		Msg:          "Failed to delete user.",
+		MsgKey:       "error.failedToDeleteUser",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 222,
+		Code:         "FAILED_TO_DELETE_USER",
 	}
 	FailedToUpdateUserError = CASServerError{
 		Msg:          "Failed to update user.",
+		MsgKey:       "error.failedToUpdateUser",
 		HttpCode:     http.StatusInternalServerError,
 		CasgoErrCode: 220,
+		Code:         "FAILED_TO_UPDATE_USER",
+	}
+	FailedToCreateProxyGrantingTicketError = CASServerError{
+		Msg:          "Failed to create proxy-granting ticket",
+		MsgKey:       "error.failedToCreateProxyGrantingTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 223,
+		Code:         "FAILED_TO_CREATE_PROXY_GRANTING_TICKET",
+	}
+	FailedToCreateProxyTicketError = CASServerError{
+		Msg:          "Failed to create proxy ticket",
+		MsgKey:       "error.failedToCreateProxyTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 224,
+		Code:         "FAILED_TO_CREATE_PROXY_TICKET",
+	}
+	FailedToDeliverProxyGrantingTicketError = CASServerError{
+		Msg:          "Failed to deliver proxy-granting ticket to proxy callback URL",
+		MsgKey:       "error.failedToDeliverProxyGrantingTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 225,
+		Code:         "FAILED_TO_DELIVER_PROXY_GRANTING_TICKET",
+	}
+	FailedToConnectToLDAPError = CASServerError{
+		Msg:          "Failed to connect to LDAP server",
+		MsgKey:       "error.failedToConnectToLDAP",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 226,
+		Code:         "FAILED_TO_CONNECT_TO_LDAP",
+	}
+	FailedToSearchLDAPError = CASServerError{
+		Msg:          "Failed to search LDAP directory",
+		MsgKey:       "error.failedToSearchLDAP",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 227,
+		Code:         "FAILED_TO_SEARCH_LDAP",
+	}
+	FailedToUpdateTicketError = CASServerError{
+		Msg:          "Failed to update ticket",
+		MsgKey:       "error.failedToUpdateTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 228,
+		Code:         "FAILED_TO_UPDATE_TICKET",
+	}
+	FailedToCreateTicketGrantingTicketError = CASServerError{
+		Msg:          "Failed to create login session",
+		MsgKey:       "error.failedToCreateTicketGrantingTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 229,
+		Code:         "FAILED_TO_CREATE_TICKET_GRANTING_TICKET",
+	}
+	FailedToDeleteTicketGrantingTicketError = CASServerError{
+		Msg:          "Failed to delete login session",
+		MsgKey:       "error.failedToDeleteTicketGrantingTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 230,
+		Code:         "FAILED_TO_DELETE_TICKET_GRANTING_TICKET",
+	}
+	FailedToAcquireDbConnectionError = CASServerError{
+		Msg:          "Database is unavailable, please try again later",
+		MsgKey:       "error.failedToAcquireDbConnection",
+		HttpCode:     http.StatusServiceUnavailable,
+		CasgoErrCode: 231,
+		Code:         "FAILED_TO_ACQUIRE_DB_CONNECTION",
+	}
+	FailedToCreateAPITokenError = CASServerError{
+		Msg:          "Failed to create API token",
+		MsgKey:       "error.failedToCreateAPIToken",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 232,
+		Code:         "FAILED_TO_CREATE_API_TOKEN",
+	}
+	FailedToUpdateTicketGrantingTicketError = CASServerError{
+		Msg:          "Failed to update login session",
+		MsgKey:       "error.failedToUpdateTicketGrantingTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 233,
+		Code:         "FAILED_TO_UPDATE_TICKET_GRANTING_TICKET",
+	}
+	FailedToListTicketGrantingTicketsError = CASServerError{
+		Msg:          "Failed to list login sessions",
+		MsgKey:       "error.failedToListTicketGrantingTickets",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 234,
+		Code:         "FAILED_TO_LIST_TICKET_GRANTING_TICKETS",
+	}
+	FailedToCreateTOTPSecretError = CASServerError{
+		Msg:          "Failed to create two-factor authentication secret",
+		MsgKey:       "error.failedToCreateTOTPSecret",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 235,
+		Code:         "FAILED_TO_CREATE_TOTP_SECRET",
+	}
+	FailedToRemoveExpiredTicketsError = CASServerError{
+		Msg:          "Failed to remove expired tickets",
+		MsgKey:       "error.failedToRemoveExpiredTickets",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 236,
+		Code:         "FAILED_TO_REMOVE_EXPIRED_TICKETS",
+	}
+	FailedToImportServicesError = CASServerError{
+		Msg:          "Failed to import services, no services were changed",
+		MsgKey:       "error.failedToImportServices",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 237,
+		Code:         "FAILED_TO_IMPORT_SERVICES",
+	}
+	FailedToExportBackupError = CASServerError{
+		Msg:          "Failed to export backup",
+		MsgKey:       "error.failedToExportBackup",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 238,
+		Code:         "FAILED_TO_EXPORT_BACKUP",
+	}
+	FailedToRestoreBackupError = CASServerError{
+		Msg:          "Failed to restore backup, nothing was changed",
+		MsgKey:       "error.failedToRestoreBackup",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 239,
+		Code:         "FAILED_TO_RESTORE_BACKUP",
+	}
+	FailedToRecordAuditEventsError = CASServerError{
+		Msg:          "Failed to record audit events",
+		MsgKey:       "error.failedToRecordAuditEvents",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 240,
+		Code:         "FAILED_TO_RECORD_AUDIT_EVENTS",
+	}
+	FailedToFindAuditEventsError = CASServerError{
+		Msg:          "Failed to find audit events",
+		MsgKey:       "error.failedToFindAuditEvents",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 241,
+		Code:         "FAILED_TO_FIND_AUDIT_EVENTS",
+	}
+	DbOperationCancelledError = CASServerError{
+		Msg:          "Database operation timed out or was cancelled",
+		MsgKey:       "error.dbOperationCancelled",
+		HttpCode:     http.StatusServiceUnavailable,
+		CasgoErrCode: 242,
+		Code:         "DB_OPERATION_CANCELLED",
+	}
+	FailedToMigrateDatabaseError = CASServerError{
+		Msg:          "Failed to apply database migrations",
+		MsgKey:       "error.failedToMigrateDatabase",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 243,
+		Code:         "FAILED_TO_MIGRATE_DATABASE",
+	}
+	SchemaVersionTooNewError = CASServerError{
+		Msg:          "Database was migrated by a newer version of casgo",
+		MsgKey:       "error.schemaVersionTooNew",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 244,
+		Code:         "SCHEMA_VERSION_TOO_NEW",
+	}
+	FailedToCreateIndexError = CASServerError{
+		Msg:          "Failed to create index",
+		MsgKey:       "error.failedToCreateIndex",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 245,
+		Code:         "FAILED_TO_CREATE_INDEX",
+	}
+	FailedToCreateOIDCCodeError = CASServerError{
+		Msg:          "Failed to create authorization code",
+		MsgKey:       "error.failedToCreateOIDCCode",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 246,
+		Code:         "FAILED_TO_CREATE_OIDC_CODE",
+	}
+	FailedToConsumeOIDCCodeError = CASServerError{
+		Msg:          "Failed to redeem authorization code",
+		MsgKey:       "error.failedToConsumeOIDCCode",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 247,
+		Code:         "FAILED_TO_CONSUME_OIDC_CODE",
+	}
+	FailedToValidateUpstreamTicketError = CASServerError{
+		Msg:          "Failed to validate the ticket with the upstream CAS server",
+		MsgKey:       "error.failedToValidateUpstreamTicket",
+		HttpCode:     http.StatusBadGateway,
+		CasgoErrCode: 248,
+		Code:         "FAILED_TO_VALIDATE_UPSTREAM_TICKET",
+	}
+	FailedToConsumeProxyTicketError = CASServerError{
+		Msg:          "Failed to consume proxy ticket",
+		MsgKey:       "error.failedToConsumeProxyTicket",
+		HttpCode:     http.StatusInternalServerError,
+		CasgoErrCode: 249,
+		Code:         "FAILED_TO_CONSUME_PROXY_TICKET",
 	}
 
 	// Other (error codes 300 - 399)
 	UnsupportedFeatureError = CASServerError{
 		Msg:          "Feature not supported by CASGO",
+		MsgKey:       "error.unsupportedFeature",
 		HttpCode:     http.StatusNotImplemented,
 		CasgoErrCode: 300,
+		Code:         "UNSUPPORTED_FEATURE",
 	}
 )
@@ -0,0 +1,12 @@
+package failurecode_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoFailureCode(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Failure Code Suite")
+}
@@ -0,0 +1,187 @@
+package failurecode_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+)
+
+const (
+	testServiceUrl  = "localhost:3000/validateCASLogin"
+	otherServiceUrl = "localhost:3001/validateCASLogin"
+)
+
+// Backend that fails to look up service tickets (as if the database were unavailable)
+type failingTicketBackend struct {
+	*MemoryBackend
+}
+
+func (b failingTicketBackend) FindTicketByIdForService(string, *CASService) (*CASTicket, *CASServerError) {
+	return nil, &FailedToAcquireDbConnectionError
+}
+
+var _ = Describe("Validation failure codes", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	ticketIn := func(w *httptest.ResponseRecorder) string {
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	// Log in with credentials, returning the service ticket issued for the given service
+	login := func(serviceUrl string) string {
+		return ticketIn(client.Login(url.Values{"serviceUrl": {serviceUrl}}))
+	}
+
+	// Failure code of a validation, which must be the same in XML and JSON responses
+	failureCode := func(path string, params url.Values) string {
+		var xmlResponse struct {
+			Failure *struct {
+				Code string `xml:"code,attr"`
+			} `xml:"http://www.yale.edu/tp/cas authenticationFailure"`
+		}
+		Expect(xml.Unmarshal(client.Get(path+"?"+params.Encode()).Body.Bytes(), &xmlResponse)).To(Succeed())
+		Expect(xmlResponse.Failure).NotTo(BeNil(), path)
+
+		var jsonResponse struct {
+			ServiceResponse struct {
+				Failure *struct {
+					Code string `json:"code"`
+				} `json:"authenticationFailure"`
+			} `json:"serviceResponse"`
+		}
+		jsonParams := url.Values{"format": {"JSON"}}
+		for key, values := range params {
+			jsonParams[key] = values
+		}
+		Expect(json.Unmarshal(client.Get(path+"?"+jsonParams.Encode()).Body.Bytes(), &jsonResponse)).To(Succeed())
+		Expect(jsonResponse.ServiceResponse.Failure).NotTo(BeNil(), path)
+		Expect(jsonResponse.ServiceResponse.Failure.Code).To(Equal(xmlResponse.Failure.Code), path)
+
+		return xmlResponse.Failure.Code
+	}
+
+	// Failure code of a validation with /validate
+	validateFailureCode := func(params url.Values) string {
+		var response map[string]string
+		Expect(json.Unmarshal(client.Get("/validate?"+params.Encode()).Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("error"))
+		return response["failureCode"]
+	}
+
+	validation := func(serviceUrl, ticket string) url.Values {
+		return url.Values{"service": {serviceUrl}, "ticket": {ticket}}
+	}
+
+	addProxyTicket := func(targetService string, expiresAt time.Time) string {
+		Expect(db.AddProxyTicket(&CASProxyTicket{
+			Id:            "PT-1234",
+			UserEmail:     "test@test.com",
+			TargetService: targetService,
+			Proxies:       []string{"https://proxy.example.com/callback"},
+			ExpiresAt:     expiresAt,
+		})).To(BeNil())
+		return "PT-1234"
+	}
+
+	serviceEndpoints := []string{"/serviceValidate", "/p3/serviceValidate", "/proxyValidate"}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+		client = castest.NewClient(server)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should respond INVALID_REQUEST when the service or ticket is missing", func() {
+		for _, params := range []url.Values{{}, {"service": {testServiceUrl}}, {"ticket": {"ST-1234"}}} {
+			for _, path := range serviceEndpoints {
+				Expect(failureCode(path, params)).To(Equal(CAS_INVALID_REQUEST), path)
+			}
+			Expect(validateFailureCode(params)).To(Equal(CAS_INVALID_REQUEST))
+		}
+	})
+
+	It("Should respond INVALID_TICKET for unknown tickets", func() {
+		for _, path := range serviceEndpoints {
+			Expect(failureCode(path, validation(testServiceUrl, "ST-unknown"))).To(Equal(CAS_INVALID_TICKET), path)
+		}
+		Expect(failureCode("/proxyValidate", validation(testServiceUrl, "PT-unknown"))).To(Equal(CAS_INVALID_TICKET))
+		Expect(validateFailureCode(validation(testServiceUrl, "ST-unknown"))).To(Equal(CAS_INVALID_TICKET))
+	})
+
+	It("Should respond INVALID_TICKET for expired proxy tickets", func() {
+		ticket := addProxyTicket(testServiceUrl, time.Now().Add(-time.Second))
+		Expect(failureCode("/proxyValidate", validation(testServiceUrl, ticket))).To(Equal(CAS_INVALID_TICKET))
+	})
+
+	It("Should respond INVALID_SERVICE for services that aren't registered", func() {
+		ticket := login(testServiceUrl)
+		for _, path := range serviceEndpoints {
+			Expect(failureCode(path, validation("localhost:9999/unknown", ticket))).To(Equal(CAS_INVALID_SERVICE), path)
+		}
+		Expect(validateFailureCode(validation("localhost:9999/unknown", ticket))).To(Equal(CAS_INVALID_SERVICE))
+	})
+
+	It("Should respond INVALID_SERVICE for tickets issued for another service", func() {
+		ticket := login(testServiceUrl)
+		for _, path := range serviceEndpoints {
+			Expect(failureCode(path, validation(otherServiceUrl, ticket))).To(Equal(CAS_INVALID_SERVICE), path)
+		}
+		Expect(validateFailureCode(validation(otherServiceUrl, ticket))).To(Equal(CAS_INVALID_SERVICE))
+
+		proxyTicket := addProxyTicket(testServiceUrl, time.Now().Add(time.Minute))
+		Expect(failureCode("/proxyValidate", validation(otherServiceUrl, proxyTicket))).To(Equal(CAS_INVALID_SERVICE))
+
+		// The ticket is still valid for the service it was issued for
+		Expect(client.Get("/serviceValidate?" + validation(testServiceUrl, ticket).Encode()).Body.String()).To(ContainSubstring("authenticationSuccess"))
+	})
+
+	It("Should respond INVALID_TICKET_SPEC for proxy tickets given to endpoints that only accept service tickets", func() {
+		ticket := addProxyTicket(testServiceUrl, time.Now().Add(time.Minute))
+		for _, path := range []string{"/serviceValidate", "/p3/serviceValidate"} {
+			Expect(failureCode(path, validation(testServiceUrl, ticket))).To(Equal(CAS_INVALID_TICKET_SPEC), path)
+		}
+		Expect(validateFailureCode(validation(testServiceUrl, ticket))).To(Equal(CAS_INVALID_TICKET_SPEC))
+		Expect(client.Get("/proxyValidate?" + validation(testServiceUrl, ticket).Encode()).Body.String()).To(ContainSubstring("authenticationSuccess"))
+	})
+
+	It("Should respond INVALID_TICKET_SPEC for tickets that don't satisfy renew", func() {
+		login(testServiceUrl)
+		ticket := ticketIn(client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode()))
+
+		params := validation(testServiceUrl, ticket)
+		params.Set("renew", "true")
+		for _, path := range serviceEndpoints {
+			Expect(failureCode(path, params)).To(Equal(CAS_INVALID_TICKET_SPEC), path)
+		}
+		Expect(validateFailureCode(params)).To(Equal(CAS_INVALID_TICKET_SPEC))
+
+		proxyParams := validation(testServiceUrl, addProxyTicket(testServiceUrl, time.Now().Add(time.Minute)))
+		proxyParams.Set("renew", "true")
+		Expect(failureCode("/proxyValidate", proxyParams)).To(Equal(CAS_INVALID_TICKET_SPEC))
+	})
+
+	It("Should respond INTERNAL_ERROR when tickets can't be looked up", func() {
+		ticket := login(testServiceUrl)
+		server.Db = failingTicketBackend{db}
+
+		for _, path := range serviceEndpoints {
+			Expect(failureCode(path, validation(testServiceUrl, ticket))).To(Equal(CAS_INTERNAL_ERROR), path)
+		}
+		Expect(validateFailureCode(validation(testServiceUrl, ticket))).To(Equal(CAS_INTERNAL_ERROR))
+	})
+})
@@ -0,0 +1,159 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+/*
+ * Liveness & readiness probes
+ */
+
+// Status of the server (or one of its components) reported by the probe endpoints
+const (
+	HEALTH_OK       = "ok"
+	HEALTH_DEGRADED = "degraded" // Failing, but not (yet) for long enough to be taken out of service
+	HEALTH_DOWN     = "down"
+)
+
+// Status of a single component checked by the readiness probe
+type ComponentHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Body returned by the probe endpoints
+type HealthReport struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentHealth `json:"components,omitempty"`
+	Workers    []WorkerStatus             `json:"workers,omitempty"` // State of each background worker (readiness probe only)
+}
+
+// Checks whether the storage backend is reachable, for the readiness probe
+// The backend is only reported down after FailureThreshold consecutive failed pings, and only reported up again
+// after as many consecutive successful ones, so that a single slow or failed ping does not flap readiness
+type ReadinessChecker struct {
+	Timeout          time.Duration // Time a ping may take before it counts as failed
+	FailureThreshold int           // Consecutive results needed to change the reported state
+
+	mu        sync.Mutex
+	down      bool
+	streak    int // Consecutive results disagreeing with the reported state
+	lastError string
+}
+
+// Create the readiness checker specified by server configuration
+func NewReadinessCheckerFromConfig(config map[string]string) (*ReadinessChecker, error) {
+	threshold, err := configInt(config, "readyFailureThreshold")
+	if err != nil {
+		return nil, err
+	}
+	if threshold < 1 {
+		return nil, fmt.Errorf("Invalid readyFailureThreshold [%d], must be at least 1", threshold)
+	}
+	return &ReadinessChecker{
+		Timeout:          configSecondsAsDuration(config, "readyTimeout"),
+		FailureThreshold: threshold,
+	}, nil
+}
+
+// Ping a backend (if it supports pinging) and record the result
+// Backends that do not implement PingableBackend are always reported as reachable
+func (r *ReadinessChecker) CheckBackend(db Backend) ComponentHealth {
+	pingable, ok := db.(PingableBackend)
+	if !ok {
+		return ComponentHealth{Status: HEALTH_OK}
+	}
+	return r.record(r.ping(pingable))
+}
+
+// Ping a backend, giving up after the timeout
+func (r *ReadinessChecker) ping(db PingableBackend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.Timeout)
+	defer cancel()
+
+	// The ping is abandoned (rather than waited for) when it times out
+	result := make(chan *CASServerError, 1)
+	go func() { result <- db.Ping(ctx) }()
+
+	select {
+	case casErr := <-result:
+		if casErr != nil {
+			return casErr
+		}
+		return nil
+	case <-ctx.Done():
+		return errors.New("Backend ping timed out")
+	}
+}
+
+// Record the result of a ping, returning the status to report
+func (r *ReadinessChecker) record(err error) ComponentHealth {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failed := err != nil
+	if failed {
+		r.lastError = err.Error()
+	}
+	if failed == r.down {
+		r.streak = 0
+	} else if r.streak++; r.streak >= r.FailureThreshold {
+		r.down = failed
+		r.streak = 0
+	}
+
+	switch {
+	case r.down:
+		return ComponentHealth{Status: HEALTH_DOWN, Message: r.lastError}
+	case r.streak > 0:
+		return ComponentHealth{Status: HEALTH_DEGRADED, Message: r.lastError}
+	default:
+		return ComponentHealth{Status: HEALTH_OK}
+	}
+}
+
+// Liveness probe: the process is up and serving requests
+func (c *CAS) HandleHealth(w http.ResponseWriter, req *http.Request) {
+	c.render.JSON(w, http.StatusOK, HealthReport{Status: HEALTH_OK})
+}
+
+// Readiness probe: the storage backend is reachable, templates are compiled and background workers keep up
+// Responds with 503 Service Unavailable if any component is down (workers that fall behind are only reported degraded)
+func (c *CAS) HandleReady(w http.ResponseWriter, req *http.Request) {
+	templates := ComponentHealth{Status: HEALTH_OK}
+	if c.render.TemplateLookup("login") == nil {
+		templates = ComponentHealth{Status: HEALTH_DOWN, Message: "Login template is not compiled"}
+	}
+
+	workers := c.Workers.Statuses()
+	report := HealthReport{
+		Status: HEALTH_OK,
+		Components: map[string]ComponentHealth{
+			"backend":   c.Readiness.CheckBackend(c.Db),
+			"templates": templates,
+			"workers":   workersHealth(workers),
+		},
+		Workers: workers,
+	}
+	for _, component := range report.Components {
+		if component.Status == HEALTH_DOWN {
+			report.Status = HEALTH_DOWN
+			break
+		}
+		if component.Status == HEALTH_DEGRADED {
+			report.Status = HEALTH_DEGRADED
+		}
+	}
+
+	status := http.StatusOK
+	if report.Status == HEALTH_DOWN {
+		status = http.StatusServiceUnavailable
+		c.Logger.Warn("Readiness check failed", "backend", report.Components["backend"].Status, "templates", templates.Status)
+	}
+	c.render.JSON(w, status, report)
+}
@@ -0,0 +1,12 @@
+package health_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoHealth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Health Suite")
+}
@@ -0,0 +1,156 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// Memory backend that can be made unreachable
+type pingableBackend struct {
+	*MemoryBackend
+	mu    sync.Mutex
+	down  bool
+	block chan struct{} // Set to make pings hang until it is closed
+}
+
+func (b *pingableBackend) Ping(ctx context.Context) *CASServerError {
+	b.mu.Lock()
+	down, block := b.down, b.block
+	b.mu.Unlock()
+
+	if block != nil {
+		<-block
+	}
+	if down {
+		return &FailedToAcquireDbConnectionError
+	}
+	return nil
+}
+
+func (b *pingableBackend) setDown(down bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.down = down
+}
+
+var _ = Describe("Health probes", func() {
+	var (
+		server  *CAS
+		backend *pingableBackend
+	)
+
+	newServer := func(config map[string]string) {
+		var db *MemoryBackend
+		server, db = castest.NewTestServer(config)
+		backend = &pingableBackend{MemoryBackend: db}
+		server.Db = backend
+	}
+
+	probe := func(path string) (int, HealthReport) {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+
+		var report HealthReport
+		Expect(json.Unmarshal(w.Body.Bytes(), &report)).To(Succeed())
+		return w.Code, report
+	}
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should report ready when the backend is reachable", func() {
+		newServer(nil)
+
+		code, report := probe("/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.Status).To(Equal(HEALTH_OK))
+		Expect(report.Components["backend"].Status).To(Equal(HEALTH_OK))
+		Expect(report.Components["templates"].Status).To(Equal(HEALTH_OK))
+
+		code, report = probe("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.Status).To(Equal(HEALTH_OK))
+	})
+
+	It("Should report not ready (while staying live) when the backend is down", func() {
+		newServer(map[string]string{"readyFailureThreshold": "1"})
+		backend.setDown(true)
+
+		code, report := probe("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Status).To(Equal(HEALTH_DOWN))
+		Expect(report.Components["backend"].Status).To(Equal(HEALTH_DOWN))
+		Expect(report.Components["backend"].Message).To(Equal(FailedToAcquireDbConnectionError.Msg))
+		Expect(report.Components["templates"].Status).To(Equal(HEALTH_OK))
+
+		code, _ = probe("/healthz")
+		Expect(code).To(Equal(http.StatusOK))
+	})
+
+	It("Should only change readiness after consecutive failures (or successes)", func() {
+		newServer(nil)
+		backend.setDown(true)
+
+		for i := 0; i < 2; i++ {
+			code, report := probe("/readyz")
+			Expect(code).To(Equal(http.StatusOK))
+			Expect(report.Status).To(Equal(HEALTH_DEGRADED))
+		}
+		code, _ := probe("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+
+		// A single successful ping does not bring the backend back
+		backend.setDown(false)
+		code, _ = probe("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		backend.setDown(true)
+		code, _ = probe("/readyz")
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+
+		backend.setDown(false)
+		for i := 0; i < 2; i++ {
+			code, _ = probe("/readyz")
+			Expect(code).To(Equal(http.StatusServiceUnavailable))
+		}
+		code, report := probe("/readyz")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(report.Status).To(Equal(HEALTH_OK))
+	})
+
+	It("Should count pings that time out as failures", func() {
+		newServer(map[string]string{"readyFailureThreshold": "1"})
+		server.Readiness.Timeout = 50 * time.Millisecond
+		backend.block = make(chan struct{})
+		defer close(backend.block)
+
+		start := time.Now()
+		code, report := probe("/readyz")
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(code).To(Equal(http.StatusServiceUnavailable))
+		Expect(report.Components["backend"].Message).To(ContainSubstring("timed out"))
+	})
+
+	It("Should serve the probes at configured paths, without authentication", func() {
+		newServer(map[string]string{"healthPath": "/live", "readyPath": "/ready"})
+
+		code, _ := probe("/live")
+		Expect(code).To(Equal(http.StatusOK))
+		code, _ = probe("/ready")
+		Expect(code).To(Equal(http.StatusOK))
+
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		Expect(w.Code).NotTo(Equal(http.StatusOK))
+	})
+})
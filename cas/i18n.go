@@ -0,0 +1,223 @@
+package cas
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/GeertJohan/go.rice"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Localization of the HTML pages (and of the error messages shown on them)
+ */
+
+const (
+	FALLBACK_LOCALE       = "en"         // Locale used when a message has no translation (its catalog must exist)
+	LOCALE_QUERY_PARAM    = "lang"       // Query parameter users pick a locale with
+	LOCALE_COOKIE_NAME    = "casgo-lang" // Cookie the picked locale is remembered in
+	LOCALE_COOKIE_MAX_AGE = 365 * 24 * 60 * 60
+)
+
+// Message catalogs, mapping locales (ex. "en", "fr-ca") to message keys and their (fmt) formats
+type Translator struct {
+	DefaultLocale string // Locale used when requests ask for no supported locale
+	catalogs      map[string]map[string]string
+}
+
+// Create a translator for the given catalogs, which must include the fallback locale and the default locale
+func NewTranslator(catalogs map[string]map[string]string, defaultLocale string) (*Translator, error) {
+	translator := &Translator{catalogs: make(map[string]map[string]string)}
+	for locale, messages := range catalogs {
+		translator.catalogs[normalizeLocale(locale)] = messages
+	}
+
+	if _, ok := translator.catalogs[FALLBACK_LOCALE]; !ok {
+		return nil, fmt.Errorf("Missing message catalog for the fallback locale [%s]", FALLBACK_LOCALE)
+	}
+	if _, ok := translator.catalogs[normalizeLocale(defaultLocale)]; !ok {
+		return nil, fmt.Errorf("Invalid defaultLocale [%s], no message catalog for it", defaultLocale)
+	}
+	translator.DefaultLocale = normalizeLocale(defaultLocale)
+
+	return translator, nil
+}
+
+// Create a translator with the catalogs in the locales directory (one <locale>.json file per locale)
+func NewTranslatorFromConfig(config map[string]string) (*Translator, error) {
+	box, err := rice.FindBox("../locales")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to find message catalogs: %v", err)
+	}
+
+	files, err := ListFilesInBox(box, "")
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list message catalogs: %v", err)
+	}
+
+	catalogs := make(map[string]map[string]string)
+	for _, file := range files {
+		if path.Ext(file) != ".json" {
+			continue
+		}
+
+		contents, err := box.Bytes(file)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read message catalog [%s]: %v", file, err)
+		}
+
+		var messages map[string]string
+		if err := json.Unmarshal(contents, &messages); err != nil {
+			return nil, fmt.Errorf("Invalid message catalog [%s]: %v", file, err)
+		}
+		catalogs[strings.TrimSuffix(path.Base(file), ".json")] = messages
+	}
+
+	return NewTranslator(catalogs, configValueOrDefault(config, "defaultLocale"))
+}
+
+// Locales are compared case-insensitively, with either separator (ex. "fr_CA" is "fr-ca")
+func normalizeLocale(locale string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSpace(locale)), "_", "-", -1)
+}
+
+// Supported locale best matching the given one, either exactly or by language (ex. "fr" for "fr-CA")
+func (t *Translator) Match(locale string) (string, bool) {
+	locale = normalizeLocale(locale)
+	if _, ok := t.catalogs[locale]; ok {
+		return locale, true
+	}
+
+	if i := strings.Index(locale, "-"); i > 0 {
+		if _, ok := t.catalogs[locale[:i]]; ok {
+			return locale[:i], true
+		}
+	}
+
+	return "", false
+}
+
+// Locale a request should be answered in, from the locale cookie, then the Accept-Language header
+func (t *Translator) RequestLocale(req *http.Request) string {
+	if cookie, err := req.Cookie(LOCALE_COOKIE_NAME); err == nil {
+		if locale, ok := t.Match(cookie.Value); ok {
+			return locale
+		}
+	}
+
+	for _, tag := range parseAcceptLanguage(req.Header.Get("Accept-Language")) {
+		if locale, ok := t.Match(tag); ok {
+			return locale
+		}
+	}
+
+	return t.DefaultLocale
+}
+
+// Language tags of an Accept-Language header, most preferred first (ex. "fr-CH, fr;q=0.9, en;q=0.8")
+// Tags with a quality of 0 are not acceptable and are left out, as is the wildcard
+func parseAcceptLanguage(header string) []string {
+	type weightedTag struct {
+		tag     string
+		quality float64
+	}
+
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.TrimSpace(fields[0])
+		if len(tag) == 0 || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		tags = append(tags, weightedTag{tag, quality})
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].quality > tags[j].quality })
+
+	preferred := make([]string, len(tags))
+	for i, tag := range tags {
+		preferred[i] = tag.tag
+	}
+	return preferred
+}
+
+// Format of a message, from the catalog of the locale, the default locale or the fallback locale (in that order)
+func (t *Translator) lookup(locale, key string) (string, bool) {
+	for _, candidate := range []string{locale, t.DefaultLocale, FALLBACK_LOCALE} {
+		if format, ok := t.catalogs[candidate][key]; ok {
+			return format, true
+		}
+	}
+	return "", false
+}
+
+// Translate a message into a locale (the key itself is returned for messages missing from every catalog)
+func (t *Translator) Translate(locale, key string, args ...interface{}) string {
+	format, ok := t.lookup(locale, key)
+	if !ok {
+		format = key
+	}
+
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// Message of an error in a locale, which is the (English) message of the error when it has no translation
+func (t *Translator) ErrorMessage(locale string, casErr *CASServerError) string {
+	if len(casErr.MsgKey) > 0 {
+		if format, ok := t.lookup(locale, casErr.MsgKey); ok {
+			return format
+		}
+	}
+	return casErr.Msg
+}
+
+// Locale an HTML page should be rendered in, a locale picked with the lang query parameter taking priority over the
+// request's locale (and being remembered for later requests)
+func (c *CAS) requestLocale(w http.ResponseWriter, req *http.Request) string {
+	if locale, ok := c.Translator.Match(req.URL.Query().Get(LOCALE_QUERY_PARAM)); ok {
+		http.SetCookie(w, &http.Cookie{
+			Name:     LOCALE_COOKIE_NAME,
+			Value:    locale,
+			Path:     c.sessionOptions.Path,
+			Domain:   c.sessionOptions.Domain,
+			MaxAge:   LOCALE_COOKIE_MAX_AGE,
+			Secure:   c.sessionOptions.Secure,
+			HttpOnly: true,
+			SameSite: c.sessionOptions.SameSite,
+		})
+		return locale
+	}
+	return c.Translator.RequestLocale(req)
+}
+
+// Translate a message into the locale of the page being rendered with context
+func (c *CAS) localize(context map[string]interface{}, key string, args ...interface{}) string {
+	locale, _ := context["Locale"].(string)
+	return c.Translator.Translate(locale, key, args...)
+}
+
+// Message of an error in the locale of the page being rendered with context
+func (c *CAS) localizeError(context map[string]interface{}, casErr *CASServerError) string {
+	locale, _ := context["Locale"].(string)
+	return c.Translator.ErrorMessage(locale, casErr)
+}
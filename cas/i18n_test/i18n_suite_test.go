@@ -0,0 +1,12 @@
+package i18n_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoI18n(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo I18n Suite")
+}
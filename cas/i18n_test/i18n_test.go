@@ -0,0 +1,142 @@
+package i18n_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+var _ = Describe("Localized pages", func() {
+	var server *CAS
+
+	do := func(req *http.Request, acceptLanguage string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		if len(acceptLanguage) > 0 {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	getLogin := func(path, acceptLanguage string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		return do(req, acceptLanguage, cookies...)
+	}
+
+	BeforeEach(func() {
+		server, _ = castest.NewTestServer(nil)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should render the login page in the language preferred by the Accept-Language header", func() {
+		body := getLogin("/login", "de-DE, fr-CA;q=0.9, en;q=0.8").Body.String()
+		Expect(body).To(ContainSubstring(`<html lang="fr">`))
+		Expect(body).To(ContainSubstring("Connexion"))
+		Expect(body).To(ContainSubstring("Mot de passe"))
+
+		body = getLogin("/login", "fr;q=0.4, en;q=0.8").Body.String()
+		Expect(body).To(ContainSubstring(`<html lang="en">`))
+		Expect(body).To(ContainSubstring("Password"))
+	})
+
+	It("Should skip languages that are not acceptable", func() {
+		body := getLogin("/login", "fr;q=0, *").Body.String()
+		Expect(body).To(ContainSubstring(`<html lang="en">`))
+		Expect(body).NotTo(ContainSubstring("Connexion"))
+	})
+
+	It("Should let users pick a language, remembering it for later pages", func() {
+		w := getLogin("/login?lang=fr", "en")
+		Expect(w.Body.String()).To(ContainSubstring("Connexion"))
+
+		cookies := (&http.Response{Header: w.Header()}).Cookies()
+		Expect(cookies).To(HaveLen(1))
+		Expect(cookies[0].Name).To(Equal(LOCALE_COOKIE_NAME))
+		Expect(cookies[0].Value).To(Equal("fr"))
+
+		Expect(getLogin("/register", "en", cookies...).Body.String()).To(ContainSubstring("Inscription"))
+
+		// Unsupported languages are ignored
+		w = getLogin("/login?lang=xx", "en", cookies...)
+		Expect(w.Header().Get("Set-Cookie")).To(BeEmpty())
+		Expect(w.Body.String()).To(ContainSubstring("Connexion"))
+	})
+
+	It("Should translate error messages, falling back to English", func() {
+		form := url.Values{"email": {"test@test.com"}, "password": {"wrong"}}
+		req, _ := http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := do(req, "fr")
+		Expect(w.Code).To(Equal(http.StatusUnauthorized))
+		Expect(w.Body.String()).To(ContainSubstring("Combinaison e-mail/mot de passe invalide"))
+
+		req, _ = http.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		Expect(do(req, "en").Body.String()).To(ContainSubstring(InvalidCredentialsError.Msg))
+
+		Expect(server.Translator.ErrorMessage("fr", &InvalidPaginationParametersError)).To(Equal(InvalidPaginationParametersError.Msg))
+	})
+
+	It("Should render pages in the configured default locale when none is asked for", func() {
+		castest.Close(server)
+		server, _ = castest.NewTestServer(map[string]string{"defaultLocale": "fr"})
+
+		Expect(getLogin("/login", "").Body.String()).To(ContainSubstring("Connexion"))
+		Expect(getLogin("/login", "de").Body.String()).To(ContainSubstring("Connexion"))
+		Expect(getLogin("/login", "en-US").Body.String()).To(ContainSubstring("Password"))
+	})
+
+	It("Should refuse default locales without a message catalog at startup", func() {
+		_, err := NewCASServerWithLogger(castest.NewTestConfig(map[string]string{"defaultLocale": "xx"}), NoopLogger{})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("defaultLocale"))
+	})
+})
+
+var _ = Describe("Translator", func() {
+	var translator *Translator
+
+	BeforeEach(func() {
+		var err error
+		translator, err = NewTranslator(map[string]map[string]string{
+			"en":    {"greeting": "Hello %s", "farewell": "Goodbye"},
+			"fr":    {"greeting": "Bonjour %s"},
+			"pt_BR": {"greeting": "Olá %s"},
+		}, "en")
+		Expect(err).To(BeNil())
+	})
+
+	It("Should match locales exactly, then by language", func() {
+		for requested, expected := range map[string]string{"fr": "fr", "FR-ca": "fr", "pt-BR": "pt-br", "pt_br": "pt-br"} {
+			locale, ok := translator.Match(requested)
+			Expect(ok).To(BeTrue())
+			Expect(locale).To(Equal(expected))
+		}
+
+		_, ok := translator.Match("pt")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Should fall back to English, then to the message key", func() {
+		Expect(translator.Translate("fr", "greeting", "Marie")).To(Equal("Bonjour Marie"))
+		Expect(translator.Translate("fr", "farewell")).To(Equal("Goodbye"))
+		Expect(translator.Translate("de", "greeting", "Max")).To(Equal("Hello Max"))
+		Expect(translator.Translate("fr", "missing.key")).To(Equal("missing.key"))
+	})
+
+	It("Should require an English catalog", func() {
+		_, err := NewTranslator(map[string]map[string]string{"fr": {}}, "fr")
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -14,6 +14,7 @@ import (
 var testHTTPServer *httptest.Server
 var testCASConfig map[string]string
 var testCASServer *cas.CAS
+var testDb cas.CASDBAdapter
 var agoutiDriver *agouti.WebDriver
 
 func TestCasgoEndToEnd(t *testing.T) {
@@ -39,19 +40,20 @@ var _ = BeforeSuite(func() {
 		log.Fatalf("Failed to generate setup cas server, err: %v", err)
 	}
 	testCASServer.SetupDb()
+	testDb = testDb.(cas.CASDBAdapter)
 
 	// Setup http test server
 	testHTTPServer = httptest.NewTLSServer(testCASServer.ServeMux)
 
 	// Load database fixtures
-	testCASServer.Db.LoadJSONFixture(
-		testCASServer.Db.GetDbName(),
-		testCASServer.Db.GetServicesTableName(),
+	testDb.LoadJSONFixture(
+		testDb.GetDbName(),
+		testDb.GetServicesTableName(),
 		"../../fixtures/services.json",
 	)
-	testCASServer.Db.LoadJSONFixture(
-		testCASServer.Db.GetDbName(),
-		testCASServer.Db.GetUsersTableName(),
+	testDb.LoadJSONFixture(
+		testDb.GetDbName(),
+		testDb.GetUsersTableName(),
 		"../../fixtures/users.json",
 	)
 
@@ -9,7 +9,7 @@ import (
 
 var INTEGRATION_TEST_DATA map[string]string = map[string]string{
 	"newUserEmail":         "testuser@testemail.com",
-	"newUserPassword":      "testpassword",
+	"newUserPassword":      "testpassword1",
 	"fixtureUserEmail":     "test@test.com",
 	"fixtureUserPassword":  "test",
 	"fixtureAdminEmail":    "admin@test.com",
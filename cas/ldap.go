@@ -0,0 +1,356 @@
+package cas
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * LDAP authentication
+ */
+
+// Separator used when a multi-valued LDAP attribute is stored as a single user attribute
+const LDAP_MULTI_VALUE_SEPARATOR = ";"
+
+// Configuration for LDAP authentication
+type LDAPConfig struct {
+	Url          string // ldap:// or ldaps:// URL of the directory server
+	StartTLS     bool   // Upgrade ldap:// connections with StartTLS
+	TLSConfig    *tls.Config
+	BindDN       string // DN used to search for users (empty for anonymous search)
+	BindPassword string
+	BaseDN       string
+	SearchFilter string            // Filter used to find users, "%s" is replaced with the (escaped) login
+	AttributeMap map[string]string // LDAP attribute -> casgo field ("email", "name", or a user attribute name)
+	PoolSize     int
+	Timeout      time.Duration
+}
+
+// Build LDAP configuration from server configuration
+func NewLDAPConfig(config map[string]string) (*LDAPConfig, error) {
+	ldapUrl, err := url.Parse(config["ldapUrl"])
+	if err != nil || (ldapUrl.Scheme != "ldap" && ldapUrl.Scheme != "ldaps") || len(ldapUrl.Host) == 0 {
+		return nil, fmt.Errorf("Invalid ldapUrl [%s], expected ldap://host[:port] or ldaps://host[:port]", config["ldapUrl"])
+	}
+
+	startTLS, err := configBool(config, "ldapStartTLS")
+	if err != nil {
+		return nil, err
+	}
+	if startTLS && ldapUrl.Scheme == "ldaps" {
+		return nil, fmt.Errorf("ldapStartTLS cannot be used with an ldaps:// URL")
+	}
+
+	insecureSkipVerify, err := configBool(config, "ldapInsecureSkipVerify")
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if caCertFile := config["ldapCACertFile"]; len(caCertFile) > 0 {
+		pem, err := ioutil.ReadFile(caCertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read ldapCACertFile [%s], %v", caCertFile, err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in ldapCACertFile [%s]", caCertFile)
+		}
+	}
+
+	searchFilter := config["ldapSearchFilter"]
+	if len(searchFilter) == 0 {
+		searchFilter = CONFIG_DEFAULTS["ldapSearchFilter"]
+	}
+	if !strings.Contains(searchFilter, "%s") {
+		return nil, fmt.Errorf("Invalid ldapSearchFilter [%s], filter must contain %%s", searchFilter)
+	}
+	if _, err := compileLDAPFilter(strings.Replace(searchFilter, "%s", "x", -1)); err != nil {
+		return nil, fmt.Errorf("Invalid ldapSearchFilter [%s], %v", searchFilter, err)
+	}
+
+	attributeMap, err := ParseLDAPAttributeMap(config["ldapAttributeMap"])
+	if err != nil {
+		return nil, err
+	}
+
+	poolSize, err := configInt(config, "ldapPoolSize")
+	if err != nil {
+		return nil, err
+	}
+
+	return &LDAPConfig{
+		Url:          config["ldapUrl"],
+		StartTLS:     startTLS,
+		TLSConfig:    tlsConfig,
+		BindDN:       config["ldapBindDN"],
+		BindPassword: config["ldapBindPassword"],
+		BaseDN:       config["ldapBaseDN"],
+		SearchFilter: searchFilter,
+		AttributeMap: attributeMap,
+		PoolSize:     poolSize,
+		Timeout:      configSecondsAsDuration(config, "ldapTimeout"),
+	}, nil
+}
+
+// Parse an attribute mapping of the form "ldapAttr:casgoField,ldapAttr:casgoField"
+func ParseLDAPAttributeMap(mapping string) (map[string]string, error) {
+	return parseAttributeMap("ldapAttributeMap", "ldapAttribute", mapping)
+}
+
+// Parse an attribute mapping of the form "attr:casgoField,attr:casgoField", given by the configKey option
+func parseAttributeMap(configKey, attributeKind, mapping string) (map[string]string, error) {
+	attributeMap := make(map[string]string)
+	for _, pair := range strings.Split(mapping, ",") {
+		pair = strings.TrimSpace(pair)
+		if len(pair) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 || len(strings.TrimSpace(parts[1])) == 0 {
+			return nil, fmt.Errorf("Invalid %s entry [%s], expected %s:casgoField", configKey, pair, attributeKind)
+		}
+		attributeMap[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return attributeMap, nil
+}
+
+// Authenticator that binds against an LDAP directory, syncing directory users into the local user store
+type LDAPAuthenticator struct {
+	Config *LDAPConfig
+	Db     Backend
+	Logger Logger // Optional, the default logger is used if nil
+	pool   *ldapConnPool
+}
+
+func NewLDAPAuthenticator(config *LDAPConfig, db Backend) *LDAPAuthenticator {
+	authenticator := &LDAPAuthenticator{
+		Config: config,
+		Db:     db,
+	}
+	authenticator.pool = &ldapConnPool{
+		size: config.PoolSize,
+		dial: authenticator.dial,
+	}
+	return authenticator
+}
+
+func (a *LDAPAuthenticator) Authenticate(email, password string) (*User, *CASServerError) {
+	// Empty passwords would result in an (unauthenticated) anonymous bind
+	if len(email) == 0 || len(password) == 0 {
+		return nil, &InvalidCredentialsError
+	}
+
+	conn, err := a.pool.Get()
+	if err != nil {
+		a.logger().Warn("Failed to connect to LDAP server", "url", a.Config.Url, "error", err)
+		casErr := &FailedToConnectToLDAPError
+		casErr.err = &err
+		return nil, casErr
+	}
+	defer a.pool.Put(conn)
+
+	entry, casErr := a.findUserEntry(conn, email)
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	// Bind as the user to check their password
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if resultErr, ok := err.(*LDAPResultError); ok && resultErr.ResultCode == LDAP_RESULT_INVALID_CREDENTIALS {
+			return nil, &InvalidCredentialsError
+		}
+		a.logger().Warn("LDAP bind failed", "dn", entry.DN, "error", err)
+		casErr := &FailedToConnectToLDAPError
+		casErr.err = &err
+		return nil, casErr
+	}
+
+	return a.syncLocalUser(a.userFromEntry(email, entry))
+}
+
+func (a *LDAPAuthenticator) logger() Logger {
+	return loggerOrDefault(a.Logger)
+}
+
+// Close idle pooled connections
+func (a *LDAPAuthenticator) Close() {
+	a.pool.Close()
+}
+
+// Find the directory entry for a login, searching as the configured bind DN
+func (a *LDAPAuthenticator) findUserEntry(conn *ldapConn, email string) (*LDAPEntry, *CASServerError) {
+	// Pooled connections may still be bound as the last authenticated user
+	if err := conn.Bind(a.Config.BindDN, a.Config.BindPassword); err != nil {
+		a.logger().Warn("LDAP bind failed", "dn", a.Config.BindDN, "error", err)
+		casErr := &FailedToConnectToLDAPError
+		casErr.err = &err
+		return nil, casErr
+	}
+
+	attributes := make([]string, 0, len(a.Config.AttributeMap))
+	for ldapAttr := range a.Config.AttributeMap {
+		attributes = append(attributes, ldapAttr)
+	}
+
+	filter := strings.Replace(a.Config.SearchFilter, "%s", EscapeLDAPFilterValue(email), -1)
+	entries, err := conn.Search(a.Config.BaseDN, filter, attributes, 2)
+	if err != nil {
+		a.logger().Warn("LDAP search failed", "filter", filter, "error", err)
+		casErr := &FailedToSearchLDAPError
+		casErr.err = &err
+		return nil, casErr
+	}
+
+	switch len(entries) {
+	case 0:
+		return nil, &FailedToFindUserError
+	case 1:
+		return entries[0], nil
+	default:
+		a.logger().Warn("LDAP search matched multiple entries, refusing to authenticate", "filter", filter)
+		return nil, &InvalidCredentialsError
+	}
+}
+
+// Map a directory entry into a casgo user
+func (a *LDAPAuthenticator) userFromEntry(email string, entry *LDAPEntry) *User {
+	user := &User{
+		Email:      email,
+		Attributes: make(map[string]string),
+	}
+
+	for ldapAttr, field := range a.Config.AttributeMap {
+		values := entry.GetAttributeValues(ldapAttr)
+		if len(values) == 0 {
+			continue
+		}
+
+		switch field {
+		case "email":
+			user.Email = values[0]
+		case "name":
+			user.Name = values[0]
+		default:
+			user.Attributes[field] = strings.Join(values, LDAP_MULTI_VALUE_SEPARATOR)
+		}
+	}
+
+	return user
+}
+
+// Create or update the local record for a directory user
+func (a *LDAPAuthenticator) syncLocalUser(ldapUser *User) (*User, *CASServerError) {
+	localUser, casErr := a.Db.FindUserByEmail(ldapUser.Email)
+	if casErr != nil {
+		// Directory users do not have a local password, so they can only log in via LDAP
+		localUser, casErr = a.Db.AddNewUser(ldapUser.Email, "")
+		if casErr != nil {
+			return nil, casErr
+		}
+	}
+	return updateLocalUser(a.Db, localUser, ldapUser)
+}
+
+// Update the name & attributes of a local user with those of the user authenticated elsewhere (ex. in a directory)
+func updateLocalUser(db Backend, localUser, remoteUser *User) (*User, *CASServerError) {
+	changed := false
+	if localUser.Name != remoteUser.Name {
+		localUser.Name = remoteUser.Name
+		changed = true
+	}
+	if localUser.Attributes == nil {
+		localUser.Attributes = make(map[string]string)
+	}
+	for k, v := range remoteUser.Attributes {
+		if localUser.Attributes[k] != v {
+			localUser.Attributes[k] = v
+			changed = true
+		}
+	}
+
+	if changed {
+		if casErr := db.UpdateUser(localUser); casErr != nil {
+			return nil, casErr
+		}
+	}
+
+	return localUser, nil
+}
+
+// Dial a new connection to the directory server
+func (a *LDAPAuthenticator) dial() (*ldapConn, error) {
+	conn, err := dialLDAP(a.Config.Url, a.Config.TLSConfig, a.Config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.Config.StartTLS {
+		ldapUrl, _ := url.Parse(a.Config.Url)
+		if err := conn.StartTLS(a.Config.TLSConfig, ldapUrl.Hostname()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+// Pool of idle LDAP connections
+type ldapConnPool struct {
+	mu   sync.Mutex
+	idle []*ldapConn
+	size int
+	dial func() (*ldapConn, error)
+}
+
+// Get an idle connection, or dial a new one
+func (p *ldapConnPool) Get() (*ldapConn, error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return conn, nil
+	}
+	p.mu.Unlock()
+
+	return p.dial()
+}
+
+// Return a connection to the pool, closing it if it is broken or the pool is full
+func (p *ldapConnPool) Put(conn *ldapConn) {
+	if conn.broken {
+		conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	if len(p.idle) < p.size {
+		p.idle = append(p.idle, conn)
+		conn = nil
+	}
+	p.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Close all idle connections
+func (p *ldapConnPool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
@@ -0,0 +1,677 @@
+package cas
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * Minimal LDAPv3 client (RFC 4511), supporting simple bind, search and StartTLS
+ */
+
+// LDAP result codes used by casgo
+const (
+	LDAP_RESULT_SUCCESS             = 0
+	LDAP_RESULT_INVALID_CREDENTIALS = 49
+)
+
+// OID of the StartTLS extended operation
+const LDAP_STARTTLS_OID = "1.3.6.1.4.1.1466.20037"
+
+// BER identifiers
+const (
+	berTagBoolean     = 0x01
+	berTagInteger     = 0x02
+	berTagOctetString = 0x04
+	berTagEnumerated  = 0x0a
+	berTagSequence    = 0x30
+	berTagSet         = 0x31
+
+	ldapTagBindRequest         = 0x60
+	ldapTagBindResponse        = 0x61
+	ldapTagUnbindRequest       = 0x42
+	ldapTagSearchRequest       = 0x63
+	ldapTagSearchResultEntry   = 0x64
+	ldapTagSearchResultDone    = 0x65
+	ldapTagSearchResultRef     = 0x73
+	ldapTagExtendedRequest     = 0x77
+	ldapTagExtendedResponse    = 0x78
+	ldapTagSimpleAuth          = 0x80
+	ldapTagExtendedRequestName = 0x80
+
+	ldapFilterAnd        = 0xa0
+	ldapFilterOr         = 0xa1
+	ldapFilterNot        = 0xa2
+	ldapFilterEquality   = 0xa3
+	ldapFilterSubstrings = 0xa4
+	ldapFilterGreaterEq  = 0xa5
+	ldapFilterLessEq     = 0xa6
+	ldapFilterPresent    = 0x87
+	ldapFilterApprox     = 0xa8
+)
+
+// Maximum size of a single LDAP message accepted from a server
+const ldapMaxMessageSize = 10 * 1024 * 1024
+
+// Error returned by an LDAP server in an LDAPResult
+type LDAPResultError struct {
+	ResultCode int
+	Message    string
+}
+
+func (err *LDAPResultError) Error() string {
+	return fmt.Sprintf("LDAP result code %d: %s", err.ResultCode, err.Message)
+}
+
+// Single entry returned from an LDAP search
+type LDAPEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Get the first value of an attribute (attribute names are case-insensitive)
+func (e *LDAPEntry) GetAttributeValue(name string) string {
+	values := e.GetAttributeValues(name)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// Get all values of an attribute (attribute names are case-insensitive)
+func (e *LDAPEntry) GetAttributeValues(name string) []string {
+	for attr, values := range e.Attributes {
+		if strings.EqualFold(attr, name) {
+			return values
+		}
+	}
+	return nil
+}
+
+// Connection to an LDAP server
+type ldapConn struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageId int64
+	timeout   time.Duration
+	broken    bool
+}
+
+// Dial an LDAP server given an ldap:// or ldaps:// URL
+func dialLDAP(ldapUrl string, tlsConfig *tls.Config, timeout time.Duration) (*ldapConn, error) {
+	parsed, err := url.Parse(ldapUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	host := parsed.Host
+	dialer := &net.Dialer{Timeout: timeout}
+
+	var conn net.Conn
+	switch parsed.Scheme {
+	case "ldap":
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "389")
+		}
+		conn, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "636")
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfigForHost(tlsConfig, parsed.Hostname()))
+	default:
+		return nil, fmt.Errorf("Unsupported LDAP URL scheme [%s]", parsed.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newLDAPConn(conn, timeout), nil
+}
+
+func newLDAPConn(conn net.Conn, timeout time.Duration) *ldapConn {
+	return &ldapConn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: timeout,
+	}
+}
+
+// Copy a TLS config, filling in the server name if one was not specified
+func tlsConfigForHost(tlsConfig *tls.Config, hostname string) *tls.Config {
+	config := &tls.Config{}
+	if tlsConfig != nil {
+		config = tlsConfig.Clone()
+	}
+	if len(config.ServerName) == 0 {
+		config.ServerName = hostname
+	}
+	return config
+}
+
+// Upgrade the connection to TLS using the StartTLS extended operation
+func (c *ldapConn) StartTLS(tlsConfig *tls.Config, hostname string) error {
+	request := berConstructed(ldapTagExtendedRequest,
+		berPrimitive(ldapTagExtendedRequestName, []byte(LDAP_STARTTLS_OID)),
+	)
+
+	response, err := c.roundTrip(request, ldapTagExtendedResponse)
+	if err != nil {
+		return err
+	}
+	if err := ldapResultError(response); err != nil {
+		return err
+	}
+
+	tlsConn := tls.Client(c.conn, tlsConfigForHost(tlsConfig, hostname))
+	if err := c.withDeadline(tlsConn.Handshake); err != nil {
+		c.broken = true
+		return err
+	}
+
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// Perform a simple bind
+func (c *ldapConn) Bind(dn, password string) error {
+	request := berConstructed(ldapTagBindRequest,
+		berInteger(berTagInteger, 3),
+		berOctetString(dn),
+		berPrimitive(ldapTagSimpleAuth, []byte(password)),
+	)
+
+	response, err := c.roundTrip(request, ldapTagBindResponse)
+	if err != nil {
+		return err
+	}
+	return ldapResultError(response)
+}
+
+// Search the whole subtree under a base DN
+func (c *ldapConn) Search(baseDN, filter string, attributes []string, sizeLimit int) ([]*LDAPEntry, error) {
+	compiledFilter, err := compileLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	requestedAttributes := make([]*berPacket, 0, len(attributes))
+	for _, attr := range attributes {
+		requestedAttributes = append(requestedAttributes, berOctetString(attr))
+	}
+
+	request := berConstructed(ldapTagSearchRequest,
+		berOctetString(baseDN),
+		berInteger(berTagEnumerated, 2), // scope: wholeSubtree
+		berInteger(berTagEnumerated, 0), // derefAliases: never
+		berInteger(berTagInteger, int64(sizeLimit)),
+		berInteger(berTagInteger, int64(c.timeout/time.Second)),
+		berBoolean(false),
+		compiledFilter,
+		berConstructed(berTagSequence, requestedAttributes...),
+	)
+
+	messageId, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []*LDAPEntry{}
+	for {
+		response, err := c.receive(messageId)
+		if err != nil {
+			return nil, err
+		}
+
+		switch response.tag {
+		case ldapTagSearchResultEntry:
+			entry, err := parseLDAPEntry(response)
+			if err != nil {
+				c.broken = true
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case ldapTagSearchResultRef:
+			// Referrals are not followed
+			continue
+		case ldapTagSearchResultDone:
+			if err := ldapResultError(response); err != nil {
+				return nil, err
+			}
+			return entries, nil
+		default:
+			c.broken = true
+			return nil, fmt.Errorf("Unexpected LDAP response tag [0x%x] during search", response.tag)
+		}
+	}
+}
+
+// Send an unbind request and close the connection
+func (c *ldapConn) Close() error {
+	if !c.broken {
+		c.send(berPrimitive(ldapTagUnbindRequest, nil))
+	}
+	c.broken = true
+	return c.conn.Close()
+}
+
+// Send a request and wait for the response to it
+func (c *ldapConn) roundTrip(request *berPacket, expectedTag byte) (*berPacket, error) {
+	messageId, err := c.send(request)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := c.receive(messageId)
+	if err != nil {
+		return nil, err
+	}
+	if response.tag != expectedTag {
+		c.broken = true
+		return nil, fmt.Errorf("Unexpected LDAP response tag [0x%x], expected [0x%x]", response.tag, expectedTag)
+	}
+	return response, nil
+}
+
+// Wrap a protocol operation in an LDAPMessage and send it
+func (c *ldapConn) send(op *berPacket) (int64, error) {
+	c.messageId++
+	message := berConstructed(berTagSequence, berInteger(berTagInteger, c.messageId), op)
+
+	err := c.withDeadline(func() error {
+		_, err := c.conn.Write(message.encode())
+		return err
+	})
+	if err != nil {
+		c.broken = true
+		return 0, err
+	}
+	return c.messageId, nil
+}
+
+// Read the next LDAPMessage (which must be for the given message ID) and return its protocol operation
+func (c *ldapConn) receive(messageId int64) (*berPacket, error) {
+	var message *berPacket
+	err := c.withDeadline(func() error {
+		var err error
+		message, err = readBERPacket(c.reader)
+		return err
+	})
+	if err != nil {
+		c.broken = true
+		return nil, err
+	}
+
+	if message.tag != berTagSequence || len(message.children) < 2 {
+		c.broken = true
+		return nil, errors.New("Malformed LDAP message")
+	}
+	if id := message.children[0].intValue(); id != messageId {
+		c.broken = true
+		return nil, fmt.Errorf("Unexpected LDAP message ID [%d], expected [%d]", id, messageId)
+	}
+	return message.children[1], nil
+}
+
+func (c *ldapConn) withDeadline(fn func() error) error {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+		defer c.conn.SetDeadline(time.Time{})
+	}
+	return fn()
+}
+
+// Convert the LDAPResult in a response into an error (if the result code is not success)
+func ldapResultError(response *berPacket) error {
+	if len(response.children) < 3 {
+		return errors.New("Malformed LDAP result")
+	}
+	resultCode := int(response.children[0].intValue())
+	if resultCode == LDAP_RESULT_SUCCESS {
+		return nil
+	}
+	return &LDAPResultError{
+		ResultCode: resultCode,
+		Message:    string(response.children[2].value),
+	}
+}
+
+// Parse a SearchResultEntry
+func parseLDAPEntry(response *berPacket) (*LDAPEntry, error) {
+	if len(response.children) < 2 {
+		return nil, errors.New("Malformed LDAP search result entry")
+	}
+
+	entry := &LDAPEntry{
+		DN:         string(response.children[0].value),
+		Attributes: make(map[string][]string),
+	}
+	for _, attr := range response.children[1].children {
+		if len(attr.children) < 2 {
+			return nil, errors.New("Malformed LDAP search result attribute")
+		}
+		name := string(attr.children[0].value)
+		for _, value := range attr.children[1].children {
+			entry.Attributes[name] = append(entry.Attributes[name], string(value.value))
+		}
+	}
+	return entry, nil
+}
+
+/*
+ * LDAP search filters (RFC 4515)
+ */
+
+// Escape a value for safe inclusion in an LDAP search filter
+func EscapeLDAPFilterValue(value string) string {
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; ch {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&buf, "\\%02x", ch)
+		default:
+			buf.WriteByte(ch)
+		}
+	}
+	return buf.String()
+}
+
+// Compile a string search filter into its BER representation
+func compileLDAPFilter(filter string) (*berPacket, error) {
+	filter = strings.TrimSpace(filter)
+	if len(filter) == 0 {
+		return nil, errors.New("Empty LDAP filter")
+	}
+	if filter[0] != '(' {
+		filter = "(" + filter + ")"
+	}
+
+	packet, rest, err := parseLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("Unexpected trailing characters in LDAP filter [%s]", rest)
+	}
+	return packet, nil
+}
+
+// Parse a single parenthesized filter, returning the unparsed remainder
+func parseLDAPFilter(filter string) (*berPacket, string, error) {
+	if len(filter) < 2 || filter[0] != '(' {
+		return nil, "", fmt.Errorf("Invalid LDAP filter [%s]", filter)
+	}
+
+	switch filter[1] {
+	case '&', '|':
+		tag := byte(ldapFilterAnd)
+		if filter[1] == '|' {
+			tag = ldapFilterOr
+		}
+		rest := filter[2:]
+		children := []*berPacket{}
+		for len(rest) > 0 && rest[0] == '(' {
+			child, remainder, err := parseLDAPFilter(rest)
+			if err != nil {
+				return nil, "", err
+			}
+			children = append(children, child)
+			rest = remainder
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", fmt.Errorf("Unterminated LDAP filter [%s]", filter)
+		}
+		return berConstructed(tag, children...), rest[1:], nil
+
+	case '!':
+		child, rest, err := parseLDAPFilter(filter[2:])
+		if err != nil {
+			return nil, "", err
+		}
+		if len(rest) == 0 || rest[0] != ')' {
+			return nil, "", fmt.Errorf("Unterminated LDAP filter [%s]", filter)
+		}
+		return berConstructed(ldapFilterNot, child), rest[1:], nil
+	}
+
+	end := strings.IndexByte(filter, ')')
+	if end < 0 {
+		return nil, "", fmt.Errorf("Unterminated LDAP filter [%s]", filter)
+	}
+	packet, err := parseLDAPItemFilter(filter[1:end])
+	if err != nil {
+		return nil, "", err
+	}
+	return packet, filter[end+1:], nil
+}
+
+// Parse a simple item filter (ex. "mail=user@example.com", "cn=*", "cn=jo*n")
+func parseLDAPItemFilter(item string) (*berPacket, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("Invalid LDAP filter item [%s]", item)
+	}
+
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(ldapFilterEquality)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = ldapFilterGreaterEq, attr[:len(attr)-1]
+	case '<':
+		tag, attr = ldapFilterLessEq, attr[:len(attr)-1]
+	case '~':
+		tag, attr = ldapFilterApprox, attr[:len(attr)-1]
+	}
+	if len(attr) == 0 {
+		return nil, fmt.Errorf("Invalid LDAP filter item [%s]", item)
+	}
+
+	if tag == ldapFilterEquality && value == "*" {
+		return berPrimitive(ldapFilterPresent, []byte(attr)), nil
+	}
+
+	if tag == ldapFilterEquality && strings.Contains(value, "*") {
+		parts := strings.Split(value, "*")
+		substrings := []*berPacket{}
+		for i, part := range parts {
+			if len(part) == 0 {
+				continue
+			}
+			decoded, err := unescapeLDAPFilterValue(part)
+			if err != nil {
+				return nil, err
+			}
+			partTag := byte(0x81) // any
+			if i == 0 {
+				partTag = 0x80 // initial
+			} else if i == len(parts)-1 {
+				partTag = 0x82 // final
+			}
+			substrings = append(substrings, berPrimitive(partTag, []byte(decoded)))
+		}
+		return berConstructed(ldapFilterSubstrings,
+			berOctetString(attr),
+			berConstructed(berTagSequence, substrings...),
+		), nil
+	}
+
+	decoded, err := unescapeLDAPFilterValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return berConstructed(tag, berOctetString(attr), berOctetString(decoded)), nil
+}
+
+// Decode \XX escapes in a filter value
+func unescapeLDAPFilterValue(value string) (string, error) {
+	if !strings.Contains(value, "\\") {
+		return value, nil
+	}
+
+	var buf strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			buf.WriteByte(value[i])
+			continue
+		}
+		if i+2 >= len(value) {
+			return "", fmt.Errorf("Invalid escape in LDAP filter value [%s]", value)
+		}
+		decoded, err := hex.DecodeString(value[i+1 : i+3])
+		if err != nil {
+			return "", fmt.Errorf("Invalid escape in LDAP filter value [%s]", value)
+		}
+		buf.Write(decoded)
+		i += 2
+	}
+	return buf.String(), nil
+}
+
+/*
+ * BER encoding (the subset used by LDAP)
+ */
+
+type berPacket struct {
+	tag      byte
+	value    []byte
+	children []*berPacket
+}
+
+func berPrimitive(tag byte, value []byte) *berPacket {
+	return &berPacket{tag: tag, value: value}
+}
+
+func berConstructed(tag byte, children ...*berPacket) *berPacket {
+	return &berPacket{tag: tag, children: children}
+}
+
+func berOctetString(value string) *berPacket {
+	return berPrimitive(berTagOctetString, []byte(value))
+}
+
+func berBoolean(value bool) *berPacket {
+	if value {
+		return berPrimitive(berTagBoolean, []byte{0xff})
+	}
+	return berPrimitive(berTagBoolean, []byte{0x00})
+}
+
+func berInteger(tag byte, value int64) *berPacket {
+	// Minimal two's complement big-endian encoding
+	buf := []byte{}
+	for {
+		buf = append([]byte{byte(value)}, buf...)
+		value >>= 8
+		if (value == 0 && buf[0]&0x80 == 0) || (value == -1 && buf[0]&0x80 != 0) {
+			break
+		}
+	}
+	return berPrimitive(tag, buf)
+}
+
+func (p *berPacket) isConstructed() bool {
+	return p.tag&0x20 != 0
+}
+
+func (p *berPacket) intValue() int64 {
+	var value int64
+	for i, b := range p.value {
+		if i == 0 && b&0x80 != 0 {
+			value = -1
+		}
+		value = value<<8 | int64(b)
+	}
+	return value
+}
+
+func (p *berPacket) encode() []byte {
+	content := p.value
+	if p.isConstructed() {
+		content = []byte{}
+		for _, child := range p.children {
+			content = append(content, child.encode()...)
+		}
+	}
+
+	out := []byte{p.tag}
+	out = append(out, berLength(len(content))...)
+	return append(out, content...)
+}
+
+func berLength(length int) []byte {
+	if length < 0x80 {
+		return []byte{byte(length)}
+	}
+	buf := []byte{}
+	for ; length > 0; length >>= 8 {
+		buf = append([]byte{byte(length)}, buf...)
+	}
+	return append([]byte{0x80 | byte(len(buf))}, buf...)
+}
+
+// Read a single BER packet (and its children) from a stream
+func readBERPacket(reader io.Reader) (*berPacket, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	tag, length := header[0], int(header[1])
+	if tag&0x1f == 0x1f {
+		return nil, errors.New("BER multi-byte tags are not supported")
+	}
+	if length&0x80 != 0 {
+		numBytes := length & 0x7f
+		if numBytes == 0 || numBytes > 4 {
+			return nil, errors.New("Unsupported BER length encoding")
+		}
+		lengthBytes := make([]byte, numBytes)
+		if _, err := io.ReadFull(reader, lengthBytes); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+	if length > ldapMaxMessageSize {
+		return nil, fmt.Errorf("BER packet too large (%d bytes)", length)
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, err
+	}
+
+	packet := &berPacket{tag: tag, value: content}
+	if packet.isConstructed() {
+		children, err := decodeBERChildren(content)
+		if err != nil {
+			return nil, err
+		}
+		packet.children = children
+	}
+	return packet, nil
+}
+
+func decodeBERChildren(content []byte) ([]*berPacket, error) {
+	children := []*berPacket{}
+	reader := strings.NewReader(string(content))
+	for reader.Len() > 0 {
+		child, err := readBERPacket(reader)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+	}
+	return children, nil
+}
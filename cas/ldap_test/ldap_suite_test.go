@@ -0,0 +1,12 @@
+package ldap_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoLDAP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo LDAP Suite")
+}
@@ -0,0 +1,347 @@
+package ldap_test
+
+import (
+	"crypto/tls"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// In-memory user store, only implementing the user methods used by the LDAP authenticator
+type fakeUserStore struct {
+	CASDBAdapter
+	users   map[string]*User
+	updates int
+}
+
+func (s *fakeUserStore) FindUserByEmail(email string) (*User, *CASServerError) {
+	if user, ok := s.users[email]; ok {
+		copied := *user
+		return &copied, nil
+	}
+	return nil, &FailedToFindUserError
+}
+
+func (s *fakeUserStore) AddNewUser(email, password string) (*User, *CASServerError) {
+	s.users[email] = &User{Email: email, Password: password}
+	return &User{Email: email, Password: password}, nil
+}
+
+func (s *fakeUserStore) UpdateUser(user *User) *CASServerError {
+	copied := *user
+	s.users[user.Email] = &copied
+	s.updates++
+	return nil
+}
+
+var directoryEntries = []mockLDAPEntry{
+	{
+		DN:       "uid=jdoe,ou=people,dc=example,dc=com",
+		Password: "hunter2",
+		Attributes: map[string][]string{
+			"mail":        []string{"jdoe@example.com"},
+			"displayName": []string{"Jane Doe"},
+			"memberOf":    []string{"cn=admins,dc=example,dc=com", "cn=staff,dc=example,dc=com"},
+		},
+	},
+	{
+		DN:       "uid=svc,ou=system,dc=example,dc=com",
+		Password: "service-secret",
+		Attributes: map[string][]string{
+			"uid": []string{"svc"},
+		},
+	},
+}
+
+func testLDAPConfig(url string) *LDAPConfig {
+	return &LDAPConfig{
+		Url:          url,
+		TLSConfig:    &tls.Config{InsecureSkipVerify: true},
+		BindDN:       "uid=svc,ou=system,dc=example,dc=com",
+		BindPassword: "service-secret",
+		BaseDN:       "ou=people,dc=example,dc=com",
+		SearchFilter: "(mail=%s)",
+		AttributeMap: map[string]string{
+			"mail":        "email",
+			"displayName": "name",
+			"memberOf":    "groups",
+		},
+		PoolSize: 2,
+		Timeout:  5 * time.Second,
+	}
+}
+
+var _ = Describe("LDAPAuthenticator", func() {
+	var (
+		server *mockLDAPServer
+		store  *fakeUserStore
+	)
+
+	BeforeEach(func() {
+		server = newMockLDAPServer(directoryEntries, false)
+		store = &fakeUserStore{users: make(map[string]*User)}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Should authenticate a directory user and create a local record", func() {
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+		defer authenticator.Close()
+
+		user, casErr := authenticator.Authenticate("jdoe@example.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(user.Email).To(Equal("jdoe@example.com"))
+		Expect(user.Name).To(Equal("Jane Doe"))
+		Expect(user.Attributes["groups"]).To(Equal("cn=admins,dc=example,dc=com;cn=staff,dc=example,dc=com"))
+
+		Expect(store.users).To(HaveKey("jdoe@example.com"))
+		Expect(store.users["jdoe@example.com"].Name).To(Equal("Jane Doe"))
+		Expect(server.Binds()).To(Equal([]string{
+			"uid=svc,ou=system,dc=example,dc=com",
+			"uid=jdoe,ou=people,dc=example,dc=com",
+		}))
+	})
+
+	It("Should update an existing local record only when directory attributes change", func() {
+		store.users["jdoe@example.com"] = &User{Email: "jdoe@example.com", Name: "Old Name", IsAdmin: true}
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+		defer authenticator.Close()
+
+		user, casErr := authenticator.Authenticate("jdoe@example.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(user.Name).To(Equal("Jane Doe"))
+		Expect(user.IsAdmin).To(BeTrue())
+		Expect(store.updates).To(Equal(1))
+
+		_, casErr = authenticator.Authenticate("jdoe@example.com", "hunter2")
+		Expect(casErr).To(BeNil())
+		Expect(store.updates).To(Equal(1))
+	})
+
+	It("Should reject invalid passwords", func() {
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+		defer authenticator.Close()
+
+		_, casErr := authenticator.Authenticate("jdoe@example.com", "wrong")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(InvalidCredentialsError.CasgoErrCode))
+		Expect(store.users).To(BeEmpty())
+	})
+
+	It("Should reject empty passwords without binding", func() {
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+		defer authenticator.Close()
+
+		_, casErr := authenticator.Authenticate("jdoe@example.com", "")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(InvalidCredentialsError.CasgoErrCode))
+		Expect(server.Binds()).To(BeEmpty())
+	})
+
+	It("Should fail for users not in the directory", func() {
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+		defer authenticator.Close()
+
+		_, casErr := authenticator.Authenticate("nobody@example.com", "hunter2")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(FailedToFindUserError.CasgoErrCode))
+	})
+
+	It("Should escape logins used in the search filter", func() {
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+		defer authenticator.Close()
+
+		_, casErr := authenticator.Authenticate("*", "hunter2")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(FailedToFindUserError.CasgoErrCode))
+	})
+
+	It("Should reuse pooled connections", func() {
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+		defer authenticator.Close()
+
+		for i := 0; i < 3; i++ {
+			_, casErr := authenticator.Authenticate("jdoe@example.com", "hunter2")
+			Expect(casErr).To(BeNil())
+		}
+		Expect(server.Dials()).To(Equal(1))
+	})
+
+	It("Should support StartTLS", func() {
+		config := testLDAPConfig("ldap://" + server.Addr())
+		config.StartTLS = true
+		authenticator := NewLDAPAuthenticator(config, store)
+		defer authenticator.Close()
+
+		_, casErr := authenticator.Authenticate("jdoe@example.com", "hunter2")
+		Expect(casErr).To(BeNil())
+	})
+
+	It("Should support LDAPS", func() {
+		tlsServer := newMockLDAPServer(directoryEntries, true)
+		defer tlsServer.Close()
+
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldaps://"+tlsServer.Addr()), store)
+		defer authenticator.Close()
+
+		_, casErr := authenticator.Authenticate("jdoe@example.com", "hunter2")
+		Expect(casErr).To(BeNil())
+	})
+
+	It("Should fail when the certificate cannot be verified", func() {
+		tlsServer := newMockLDAPServer(directoryEntries, true)
+		defer tlsServer.Close()
+
+		config := testLDAPConfig("ldaps://" + tlsServer.Addr())
+		config.TLSConfig = &tls.Config{}
+		authenticator := NewLDAPAuthenticator(config, store)
+		defer authenticator.Close()
+
+		_, casErr := authenticator.Authenticate("jdoe@example.com", "hunter2")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(FailedToConnectToLDAPError.CasgoErrCode))
+	})
+
+	It("Should fail when the server is unreachable", func() {
+		server.Close()
+		authenticator := NewLDAPAuthenticator(testLDAPConfig("ldap://"+server.Addr()), store)
+
+		_, casErr := authenticator.Authenticate("jdoe@example.com", "hunter2")
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.CasgoErrCode).To(Equal(FailedToConnectToLDAPError.CasgoErrCode))
+	})
+
+})
+
+var _ = Describe("LDAP configuration", func() {
+
+	configWith := func(overrides map[string]string) map[string]string {
+		config := make(map[string]string)
+		for k, v := range CONFIG_DEFAULTS {
+			config[k] = v
+		}
+		for k, v := range overrides {
+			config[k] = v
+		}
+		return config
+	}
+
+	It("Should build LDAP configuration from server configuration", func() {
+		ldapConfig, err := NewLDAPConfig(configWith(map[string]string{
+			"ldapUrl":          "ldap://ldap.example.com",
+			"ldapStartTLS":     "true",
+			"ldapBaseDN":       "dc=example,dc=com",
+			"ldapAttributeMap": "mail:email, cn:name, department:dept",
+		}))
+		Expect(err).To(BeNil())
+		Expect(ldapConfig.StartTLS).To(BeTrue())
+		Expect(ldapConfig.SearchFilter).To(Equal("(mail=%s)"))
+		Expect(ldapConfig.PoolSize).To(Equal(5))
+		Expect(ldapConfig.AttributeMap).To(Equal(map[string]string{"mail": "email", "cn": "name", "department": "dept"}))
+	})
+
+	It("Should reject invalid URLs", func() {
+		_, err := NewLDAPConfig(configWith(map[string]string{"ldapUrl": "http://ldap.example.com"}))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Should reject StartTLS with LDAPS", func() {
+		_, err := NewLDAPConfig(configWith(map[string]string{"ldapUrl": "ldaps://ldap.example.com", "ldapStartTLS": "true"}))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Should reject search filters without a login placeholder or with invalid syntax", func() {
+		_, err := NewLDAPConfig(configWith(map[string]string{"ldapSearchFilter": "(mail=admin)"}))
+		Expect(err).ToNot(BeNil())
+		_, err = NewLDAPConfig(configWith(map[string]string{"ldapSearchFilter": "(&(mail=%s)"}))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Should reject malformed attribute mappings", func() {
+		_, err := ParseLDAPAttributeMap("mail:email,displayName")
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Should create an LDAP authenticator when authMethod is ldap", func() {
+		authenticator, err := NewAuthenticatorFromConfig(configWith(map[string]string{"authMethod": "ldap"}), nil, nil)
+		Expect(err).To(BeNil())
+		Expect(authenticator).To(BeAssignableToTypeOf(&AuthenticatorChain{}))
+		Expect(authenticator.(*AuthenticatorChain).Methods).To(HaveLen(1))
+		Expect(authenticator.(*AuthenticatorChain).Methods[0].Authenticator).To(BeAssignableToTypeOf(&LDAPAuthenticator{}))
+	})
+
+	It("Should reject unknown authentication methods", func() {
+		_, err := NewAuthenticatorFromConfig(configWith(map[string]string{"authMethod": "kerberos"}), nil, nil)
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Should escape filter values", func() {
+		Expect(EscapeLDAPFilterValue(`a*b(c)d\e`)).To(Equal(`a\2ab\28c\29d\5ce`))
+	})
+
+})
+
+var _ = Describe("LDAP logins", func() {
+	var (
+		directory *mockLDAPServer
+		server    *CAS
+		db        *MemoryBackend
+	)
+
+	BeforeEach(func() {
+		directory = newMockLDAPServer(append(directoryEntries, mockLDAPEntry{
+			DN:       "uid=mixed,ou=people,dc=example,dc=com",
+			Password: "Hunter2 In Caps",
+			Attributes: map[string][]string{
+				"mail": []string{"mixed@example.com"},
+			},
+		}), false)
+
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+		config["csrfEnabled"] = "false"
+		config["authMethod"] = "ldap"
+		config["ldapUrl"] = "ldap://" + directory.Addr()
+		config["ldapBindDN"] = "uid=svc,ou=system,dc=example,dc=com"
+		config["ldapBindPassword"] = "service-secret"
+		config["ldapBaseDN"] = "ou=people,dc=example,dc=com"
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("services", "../../fixtures/services.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.AuditLog.Stop()
+		db.Close()
+		directory.Close()
+	})
+
+	login := func(email, password string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {email}, "password": {password}, "serviceUrl": {"localhost:3000/validateCASLogin"}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	It("Should bind with the password as it was typed, keeping its case", func() {
+		w := login("Mixed@Example.com", " Hunter2 In Caps ")
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+		Expect(directory.Binds()).To(ContainElement("uid=mixed,ou=people,dc=example,dc=com"))
+
+		Expect(login("mixed@example.com", "hunter2 in caps").Code).To(Equal(InvalidCredentialsError.HttpCode))
+	})
+})
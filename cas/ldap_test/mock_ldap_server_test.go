@@ -0,0 +1,253 @@
+package ldap_test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+/*
+ * Mock LDAP server supporting simple bind, equality-filter search, StartTLS and unbind
+ */
+
+type mockLDAPEntry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+type mockLDAPServer struct {
+	listener   net.Listener
+	tlsConfig  *tls.Config
+	entries    []mockLDAPEntry
+	mu         sync.Mutex
+	dials      int
+	binds      []string
+	startTLSOk bool
+}
+
+// Start a mock LDAP server, serving TLS on every connection if useTLS is set (ldaps://)
+func newMockLDAPServer(entries []mockLDAPEntry, useTLS bool) *mockLDAPServer {
+	// Borrow the self-signed certificate used by httptest
+	certServer := httptest.NewUnstartedServer(nil)
+	certServer.StartTLS()
+	tlsConfig := &tls.Config{Certificates: certServer.TLS.Certificates}
+	certServer.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	if useTLS {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+
+	server := &mockLDAPServer{
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		entries:   entries,
+	}
+	go server.serve()
+	return server
+}
+
+func (s *mockLDAPServer) Addr() string { return s.listener.Addr().String() }
+func (s *mockLDAPServer) Close()       { s.listener.Close() }
+
+func (s *mockLDAPServer) Dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+func (s *mockLDAPServer) Binds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.binds...)
+}
+
+func (s *mockLDAPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.dials++
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *mockLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	for {
+		message, err := readPacket(reader)
+		if err != nil || len(message.children) < 2 {
+			return
+		}
+		messageId := message.children[0].value
+		op := message.children[1]
+
+		switch op.tag {
+		case 0x60: // BindRequest
+			dn, password := string(op.children[1].value), string(op.children[2].value)
+			s.mu.Lock()
+			s.binds = append(s.binds, dn)
+			s.mu.Unlock()
+			code := byte(49)
+			if s.checkBind(dn, password) {
+				code = 0
+			}
+			conn.Write(reply(messageId, result(0x61, code)))
+
+		case 0x63: // SearchRequest
+			baseDN, filter := string(op.children[0].value), op.children[6]
+			for _, entry := range s.entries {
+				if strings.HasSuffix(entry.DN, baseDN) && matchesFilter(entry, filter) {
+					conn.Write(reply(messageId, entryPacket(entry)))
+				}
+			}
+			conn.Write(reply(messageId, result(0x65, 0)))
+
+		case 0x77: // ExtendedRequest (StartTLS)
+			conn.Write(reply(messageId, result(0x78, 0)))
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.startTLSOk = true
+			s.mu.Unlock()
+			conn = tlsConn
+			reader = bufio.NewReader(tlsConn)
+
+		case 0x42: // UnbindRequest
+			return
+		}
+	}
+}
+
+func (s *mockLDAPServer) checkBind(dn, password string) bool {
+	if dn == "" && password == "" {
+		return true
+	}
+	for _, entry := range s.entries {
+		if entry.DN == dn && entry.Password == password && len(password) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Only equality (and present) filters are supported
+func matchesFilter(entry mockLDAPEntry, filter *packet) bool {
+	switch filter.tag {
+	case 0xa3:
+		attr, value := string(filter.children[0].value), string(filter.children[1].value)
+		for name, values := range entry.Attributes {
+			if strings.EqualFold(name, attr) {
+				for _, v := range values {
+					if strings.EqualFold(v, value) {
+						return true
+					}
+				}
+			}
+		}
+	case 0x87:
+		_, ok := entry.Attributes[string(filter.value)]
+		return ok
+	}
+	return false
+}
+
+/*
+ * BER helpers
+ */
+
+type packet struct {
+	tag      byte
+	value    []byte
+	children []*packet
+}
+
+func readPacket(reader io.Reader) (*packet, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	length := int(header[1])
+	if length&0x80 != 0 {
+		lengthBytes := make([]byte, length&0x7f)
+		if _, err := io.ReadFull(reader, lengthBytes); err != nil {
+			return nil, err
+		}
+		length = 0
+		for _, b := range lengthBytes {
+			length = length<<8 | int(b)
+		}
+	}
+
+	content := make([]byte, length)
+	if _, err := io.ReadFull(reader, content); err != nil {
+		return nil, err
+	}
+
+	p := &packet{tag: header[0], value: content}
+	if p.tag&0x20 != 0 {
+		r := strings.NewReader(string(content))
+		for r.Len() > 0 {
+			child, err := readPacket(r)
+			if err != nil {
+				return nil, errors.New("malformed packet")
+			}
+			p.children = append(p.children, child)
+		}
+	}
+	return p, nil
+}
+
+func encode(tag byte, content []byte) []byte {
+	out := []byte{tag}
+	if len(content) < 0x80 {
+		out = append(out, byte(len(content)))
+	} else {
+		out = append(out, 0x82, byte(len(content)>>8), byte(len(content)))
+	}
+	return append(out, content...)
+}
+
+func concat(parts ...[]byte) []byte {
+	out := []byte{}
+	for _, part := range parts {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func reply(messageId []byte, op []byte) []byte {
+	return encode(0x30, concat(encode(0x02, messageId), op))
+}
+
+func result(tag byte, code byte) []byte {
+	return encode(tag, concat(encode(0x0a, []byte{code}), encode(0x04, nil), encode(0x04, nil)))
+}
+
+func entryPacket(entry mockLDAPEntry) []byte {
+	attributes := []byte{}
+	for name, values := range entry.Attributes {
+		encodedValues := []byte{}
+		for _, v := range values {
+			encodedValues = append(encodedValues, encode(0x04, []byte(v))...)
+		}
+		attributes = append(attributes, encode(0x30, concat(encode(0x04, []byte(name)), encode(0x31, encodedValues)))...)
+	}
+	return encode(0x64, concat(encode(0x04, []byte(entry.DN)), encode(0x30, attributes)))
+}
@@ -0,0 +1,224 @@
+package cas
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Structured, leveled logging
+ */
+
+// Severity of a log message, messages below a logger's level are discarded
+type LogLevel int
+
+const (
+	_ LogLevel = iota
+	DEBUG
+	INFO
+	WARN
+	ERROR
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case DEBUG:
+		return "debug"
+	case INFO:
+		return "info"
+	case WARN:
+		return "warn"
+	case ERROR:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// Parse a log level name (debug, info, warn or error, case insensitive)
+func ParseLogLevel(name string) (LogLevel, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return DEBUG, nil
+	case "info":
+		return INFO, nil
+	case "warn", "warning":
+		return WARN, nil
+	case "error":
+		return ERROR, nil
+	default:
+		return 0, fmt.Errorf("Invalid logLevel [%s], expected one of debug, info, warn, error", name)
+	}
+}
+
+// Formats supported by StdLogger
+const (
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+)
+
+// Leveled logger with structured fields, given as alternating keys and values
+// (ex. logger.Info("Login succeeded", "username", email))
+// Implementations must be safe for concurrent use
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Info(msg string, keyvals ...interface{})
+	Warn(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+	// Create a logger that adds the given fields to every message
+	With(keyvals ...interface{}) Logger
+}
+
+// Logger that discards all messages
+type NoopLogger struct{}
+
+func (l NoopLogger) Debug(msg string, keyvals ...interface{}) {}
+func (l NoopLogger) Info(msg string, keyvals ...interface{})  {}
+func (l NoopLogger) Warn(msg string, keyvals ...interface{})  {}
+func (l NoopLogger) Error(msg string, keyvals ...interface{}) {}
+func (l NoopLogger) With(keyvals ...interface{}) Logger       { return l }
+
+// Logger writing one line per message, as logfmt-style text (key=value) or JSON objects
+type StdLogger struct {
+	Level  LogLevel
+	Format string
+	out    io.Writer
+	mu     *sync.Mutex // Shared with loggers created by With, so lines are never interleaved
+	fields []interface{}
+	now    func() time.Time
+}
+
+func NewStdLogger(out io.Writer, level LogLevel, format string) (*StdLogger, error) {
+	if format != LOG_FORMAT_TEXT && format != LOG_FORMAT_JSON {
+		return nil, fmt.Errorf("Invalid logFormat [%s], expected text or json", format)
+	}
+	return &StdLogger{
+		Level:  level,
+		Format: format,
+		out:    out,
+		mu:     &sync.Mutex{},
+		now:    time.Now,
+	}, nil
+}
+
+// Logger used by components that have not been given one (info level text on stderr)
+func DefaultLogger() Logger {
+	logger, _ := NewStdLogger(os.Stderr, INFO, LOG_FORMAT_TEXT)
+	return logger
+}
+
+func loggerOrDefault(logger Logger) Logger {
+	if logger == nil {
+		return DefaultLogger()
+	}
+	return logger
+}
+
+// Create the logger specified by server configuration (logLevel & logFormat), writing to stderr
+func NewLoggerFromConfig(config map[string]string) (Logger, error) {
+	level, err := ParseLogLevel(configValueOrDefault(config, "logLevel"))
+	if err != nil {
+		return nil, err
+	}
+	return NewStdLogger(os.Stderr, level, strings.ToLower(configValueOrDefault(config, "logFormat")))
+}
+
+func configValueOrDefault(config map[string]string, key string) string {
+	if value := config[key]; len(value) > 0 {
+		return value
+	}
+	return CONFIG_DEFAULTS[key]
+}
+
+// Override the clock used for message timestamps (for testing)
+func (l *StdLogger) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+func (l *StdLogger) Debug(msg string, keyvals ...interface{}) { l.log(DEBUG, msg, keyvals) }
+func (l *StdLogger) Info(msg string, keyvals ...interface{})  { l.log(INFO, msg, keyvals) }
+func (l *StdLogger) Warn(msg string, keyvals ...interface{})  { l.log(WARN, msg, keyvals) }
+func (l *StdLogger) Error(msg string, keyvals ...interface{}) { l.log(ERROR, msg, keyvals) }
+
+func (l *StdLogger) With(keyvals ...interface{}) Logger {
+	child := *l
+	child.fields = append(append([]interface{}{}, l.fields...), keyvals...)
+	return &child
+}
+
+func (l *StdLogger) log(level LogLevel, msg string, keyvals []interface{}) {
+	if level < l.Level {
+		return
+	}
+
+	fields := []interface{}{"time", l.now().UTC().Format(time.RFC3339), "level", level.String(), "msg", msg}
+	fields = append(append(fields, l.fields...), keyvals...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "(MISSING)")
+	}
+
+	var line []byte
+	if l.Format == LOG_FORMAT_JSON {
+		line = formatJSONLogLine(fields)
+	} else {
+		line = formatTextLogLine(fields)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(line)
+}
+
+// Convert a field value into something that can be printed (errors and Stringers use their text)
+func logFieldValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	default:
+		return v
+	}
+}
+
+func formatTextLogLine(fields []interface{}) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(fmt.Sprint(fields[i]))
+		buf.WriteByte('=')
+
+		value := fmt.Sprint(logFieldValue(fields[i+1]))
+		if len(value) == 0 || strings.ContainsAny(value, " =\"\t\r\n") {
+			value = fmt.Sprintf("%q", value)
+		}
+		buf.WriteString(value)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func formatJSONLogLine(fields []interface{}) []byte {
+	entry := make(map[string]interface{}, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		entry[fmt.Sprint(fields[i])] = logFieldValue(fields[i+1])
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		// Fall back to printing values that cannot be encoded as JSON
+		for key, value := range entry {
+			entry[key] = fmt.Sprint(value)
+		}
+		line, _ = json.Marshal(entry)
+	}
+	return append(line, '\n')
+}
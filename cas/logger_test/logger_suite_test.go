@@ -0,0 +1,12 @@
+package logger_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoLogger(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Logger Suite")
+}
@@ -0,0 +1,246 @@
+package logger_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Logger recording messages, as a user supplied logger would receive them
+type recordedMessage struct {
+	Level  string
+	Msg    string
+	Fields map[string]interface{}
+}
+
+type recordingLogger struct {
+	mu       *sync.Mutex
+	messages *[]recordedMessage
+	fields   []interface{}
+}
+
+func newRecordingLogger() *recordingLogger {
+	return &recordingLogger{mu: &sync.Mutex{}, messages: &[]recordedMessage{}}
+}
+
+func (l *recordingLogger) record(level, msg string, keyvals []interface{}) {
+	fields := map[string]interface{}{}
+	all := append(append([]interface{}{}, l.fields...), keyvals...)
+	for i := 0; i+1 < len(all); i += 2 {
+		fields[all[i].(string)] = all[i+1]
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.messages = append(*l.messages, recordedMessage{level, msg, fields})
+}
+
+func (l *recordingLogger) Debug(msg string, keyvals ...interface{}) { l.record("debug", msg, keyvals) }
+func (l *recordingLogger) Info(msg string, keyvals ...interface{})  { l.record("info", msg, keyvals) }
+func (l *recordingLogger) Warn(msg string, keyvals ...interface{})  { l.record("warn", msg, keyvals) }
+func (l *recordingLogger) Error(msg string, keyvals ...interface{}) { l.record("error", msg, keyvals) }
+func (l *recordingLogger) With(keyvals ...interface{}) Logger {
+	return &recordingLogger{l.mu, l.messages, append(append([]interface{}{}, l.fields...), keyvals...)}
+}
+
+func (l *recordingLogger) find(msg string) []recordedMessage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	found := []recordedMessage{}
+	for _, message := range *l.messages {
+		if message.Msg == msg {
+			found = append(found, message)
+		}
+	}
+	return found
+}
+
+var _ = Describe("StdLogger", func() {
+	var (
+		buf    *bytes.Buffer
+		logger *StdLogger
+	)
+
+	newLogger := func(level LogLevel, format string) {
+		var err error
+		buf = &bytes.Buffer{}
+		logger, err = NewStdLogger(buf, level, format)
+		Expect(err).To(BeNil())
+		logger.SetClock(func() time.Time { return time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC) })
+	}
+
+	It("Should write key=value lines", func() {
+		newLogger(INFO, LOG_FORMAT_TEXT)
+		logger.Info("Login failed", "username", "test@test.com", "error", errors.New("Invalid credentials"), "attempts", 3)
+		Expect(buf.String()).To(Equal(`time=2015-06-01T12:00:00Z level=info msg="Login failed" username=test@test.com error="Invalid credentials" attempts=3` + "\n"))
+	})
+
+	It("Should write JSON lines", func() {
+		newLogger(INFO, LOG_FORMAT_JSON)
+		logger.Warn("Ticket validation failed", "service", "localhost:3000", "errorCode", 110)
+
+		var entry map[string]interface{}
+		Expect(json.Unmarshal(buf.Bytes(), &entry)).To(BeNil())
+		Expect(entry).To(Equal(map[string]interface{}{
+			"time":      "2015-06-01T12:00:00Z",
+			"level":     "warn",
+			"msg":       "Ticket validation failed",
+			"service":   "localhost:3000",
+			"errorCode": float64(110),
+		}))
+	})
+
+	It("Should discard messages below its level", func() {
+		newLogger(WARN, LOG_FORMAT_TEXT)
+		logger.Debug("debug")
+		logger.Info("info")
+		Expect(buf.Len()).To(Equal(0))
+
+		logger.Error("error")
+		Expect(buf.String()).To(ContainSubstring("level=error"))
+	})
+
+	It("Should add fields from With to every message, without changing the parent", func() {
+		newLogger(INFO, LOG_FORMAT_TEXT)
+		child := logger.With("requestId", "abc")
+		child.Info("first")
+		logger.Info("second")
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+		Expect(lines[0]).To(HaveSuffix("requestId=abc"))
+		Expect(lines[1]).NotTo(ContainSubstring("requestId"))
+	})
+
+	It("Should mark values missing from odd field lists", func() {
+		newLogger(INFO, LOG_FORMAT_TEXT)
+		logger.Info("odd", "key")
+		Expect(buf.String()).To(ContainSubstring("key=(MISSING)"))
+	})
+
+	It("Should parse log levels", func() {
+		for name, level := range map[string]LogLevel{"debug": DEBUG, "INFO": INFO, "warn": WARN, "WARNING": WARN, "error": ERROR} {
+			parsed, err := ParseLogLevel(name)
+			Expect(err).To(BeNil())
+			Expect(parsed).To(Equal(level))
+		}
+		_, err := ParseLogLevel("verbose")
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Should reject invalid configuration", func() {
+		_, err := NewLoggerFromConfig(map[string]string{"logLevel": "loud"})
+		Expect(err).NotTo(BeNil())
+		_, err = NewLoggerFromConfig(map[string]string{"logFormat": "xml"})
+		Expect(err).NotTo(BeNil())
+
+		logger, err := NewLoggerFromConfig(map[string]string{})
+		Expect(err).To(BeNil())
+		Expect(logger.(*StdLogger).Level).To(Equal(INFO))
+		Expect(logger.(*StdLogger).Format).To(Equal(LOG_FORMAT_TEXT))
+	})
+})
+
+var _ = Describe("Server logging", func() {
+	var (
+		server *CAS
+		logger *recordingLogger
+	)
+
+	// Client sending the given headers with its requests
+	clientWith := func(headers map[string]string) *castest.Client {
+		client := castest.NewClient(server)
+		for k, v := range headers {
+			client.Header.Set(k, v)
+		}
+		return client
+	}
+
+	BeforeEach(func() {
+		logger = newRecordingLogger()
+		server = castest.NewTestServerWithOptions(nil, CASServerOptions{Logger: logger})
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should fail to create a server with an invalid log level", func() {
+		_, err := NewCASServer(castest.NewTestConfig(map[string]string{"logLevel": "loud"}))
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Should assign request IDs, reusing well-formed incoming IDs", func() {
+		w := clientWith(nil).Get("/login")
+		Expect(w.Header().Get(REQUEST_ID_HEADER)).NotTo(BeEmpty())
+
+		w = clientWith(map[string]string{REQUEST_ID_HEADER: "upstream-id.1"}).Get("/login")
+		Expect(w.Header().Get(REQUEST_ID_HEADER)).To(Equal("upstream-id.1"))
+
+		w = clientWith(map[string]string{REQUEST_ID_HEADER: "bad id\n"}).Get("/login")
+		Expect(w.Header().Get(REQUEST_ID_HEADER)).NotTo(Equal("bad id\n"))
+	})
+
+	It("Should log login attempts with correlation fields", func() {
+		clientWith(map[string]string{REQUEST_ID_HEADER: "req-1"}).Login(url.Values{
+			"password":   {"wrong"},
+			"serviceUrl": {"localhost:3000/validateCASLogin"},
+		})
+
+		messages := logger.find("Login failed")
+		Expect(messages).To(HaveLen(1))
+		Expect(messages[0].Level).To(Equal("warn"))
+		Expect(messages[0].Fields).To(HaveKeyWithValue("requestId", "req-1"))
+		Expect(messages[0].Fields).To(HaveKeyWithValue("username", "test@test.com"))
+		Expect(messages[0].Fields).To(HaveKeyWithValue("service", "localhost:3000/validateCASLogin"))
+		Expect(messages[0].Fields).To(HaveKey("clientIp"))
+	})
+
+	It("Should log ticket issuance and validation", func() {
+		w := castest.Login(server, url.Values{"serviceUrl": {"localhost:3000/validateCASLogin"}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(logger.find("Login succeeded")).To(HaveLen(1))
+
+		issued := logger.find("Issued service ticket")
+		Expect(issued).To(HaveLen(1))
+		Expect(issued[0].Fields).To(HaveKeyWithValue("username", "test@test.com"))
+
+		ticket := castest.Ticket(w)
+		clientWith(nil).Get("/serviceValidate?" + url.Values{
+			"service": {"localhost:3000/validateCASLogin"},
+			"ticket":  {ticket},
+		}.Encode())
+
+		validated := logger.find("Validated ticket")
+		Expect(validated).To(HaveLen(1))
+		Expect(validated[0].Fields).To(HaveKeyWithValue("route", "/serviceValidate"))
+		Expect(validated[0].Fields).To(HaveKeyWithValue("username", "test@test.com"))
+
+		// Tickets are never logged
+		for _, message := range *logger.messages {
+			for _, value := range message.Fields {
+				Expect(value).NotTo(Equal(ticket))
+			}
+		}
+	})
+
+	It("Should log failed validations", func() {
+		clientWith(nil).Get("/validate?" + url.Values{
+			"service": {"localhost:3000/validateCASLogin"},
+			"ticket":  {"ST-unknown"},
+		}.Encode())
+
+		failed := logger.find("Ticket validation failed")
+		Expect(failed).To(HaveLen(1))
+		Expect(failed[0].Level).To(Equal("warn"))
+	})
+})
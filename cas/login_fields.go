@@ -0,0 +1,109 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Extra login form fields (ex. a tenant or domain selector), passed to authenticators
+ */
+
+// Form fields of the login page casgo reads itself, which extra fields can't replace
+var RESERVED_LOGIN_FIELDS = []string{"email", "password", "service", "serviceUrl", "renew", "gateway", "method", "warn", "rememberMe", "totpCode", "lang", CSRF_FORM_FIELD}
+
+// Extra field shown on the login form, whose submitted value is passed to the authenticator
+type LoginField struct {
+	Name  string // Name of the form field (ex. tenant)
+	Label string // Label shown when the locale has no login.field.<name> translation (the name if not given)
+}
+
+// Field of the login form, as rendered by the login template
+type loginFieldContext struct {
+	Name  string
+	Label string
+	Value string
+}
+
+// Authenticators that also receive the values of the extra login fields (see LoginField)
+// Authenticators that don't implement it are only given the email & password, ignoring the extra fields
+type CASFieldsAuthenticator interface {
+	CASAuthenticator
+	AuthenticateWithFields(email, password string, fields map[string]string) (*User, *CASServerError)
+}
+
+// Function used as an authenticator, receiving the values of the extra login fields (ex. to pick the directory of a tenant)
+type CASAuthenticatorFunc func(email, password string, fields map[string]string) (*User, *CASServerError)
+
+func (f CASAuthenticatorFunc) Authenticate(email, password string) (*User, *CASServerError) {
+	return f(email, password, map[string]string{})
+}
+
+func (f CASAuthenticatorFunc) AuthenticateWithFields(email, password string, fields map[string]string) (*User, *CASServerError) {
+	return f(email, password, fields)
+}
+
+// Parse the loginExtraFields configuration value, of the form "name:Label,name" (labels are optional)
+func ParseLoginFields(list string) ([]LoginField, error) {
+	fields := []LoginField{}
+	for _, entry := range splitConfigList(list) {
+		parts := strings.SplitN(entry, ":", 2)
+		field := LoginField{Name: strings.TrimSpace(parts[0])}
+		if len(parts) == 2 {
+			field.Label = strings.TrimSpace(parts[1])
+		}
+		if len(field.Label) == 0 {
+			field.Label = field.Name
+		}
+
+		if !validLoginFieldName(field.Name) {
+			return nil, fmt.Errorf("Invalid loginExtraFields entry [%s], expected a field name made of letters, digits, - and _ (ex. tenant:Tenant)", entry)
+		}
+		if containsString(RESERVED_LOGIN_FIELDS, field.Name, true) {
+			return nil, fmt.Errorf("Invalid loginExtraFields entry [%s], the %s field is already on the login form", entry, field.Name)
+		}
+		for _, other := range fields {
+			if strings.EqualFold(other.Name, field.Name) {
+				return nil, fmt.Errorf("Invalid loginExtraFields entry [%s], the %s field is declared twice", entry, field.Name)
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+func validLoginFieldName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, char := range name {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || char == '-' || char == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Values submitted for the extra login fields (trimmed, fields that weren't submitted have empty values)
+func (c *CAS) loginFieldValues(req *http.Request) map[string]string {
+	values := make(map[string]string, len(c.LoginFields))
+	for _, field := range c.LoginFields {
+		values[field.Name] = strings.TrimSpace(req.FormValue(field.Name))
+	}
+	return values
+}
+
+// Extra fields of the login form, labelled in the locale of the page (and keeping the values submitted)
+func (c *CAS) loginFieldsContext(context map[string]interface{}, values map[string]string) []loginFieldContext {
+	locale, _ := context["Locale"].(string)
+	fields := make([]loginFieldContext, 0, len(c.LoginFields))
+	for _, field := range c.LoginFields {
+		label, ok := c.Translator.lookup(locale, "login.field."+field.Name)
+		if !ok {
+			label = field.Label
+		}
+		fields = append(fields, loginFieldContext{Name: field.Name, Label: label, Value: values[field.Name]})
+	}
+	return fields
+}
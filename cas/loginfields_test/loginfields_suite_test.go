@@ -0,0 +1,12 @@
+package loginfields_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoLoginFields(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Login Fields Suite")
+}
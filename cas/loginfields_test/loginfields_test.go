@@ -0,0 +1,115 @@
+package loginfields_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+var _ = Describe("Extra login fields", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(map[string]string{"loginExtraFields": "tenant:Organization,region"})
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	login := func(form url.Values) *httptest.ResponseRecorder {
+		return castest.Login(server, form)
+	}
+
+	// Login form (for the test user) for a service, with values for extra fields
+	credentials := func(extra map[string]string) url.Values {
+		form := url.Values{"serviceUrl": {castest.TestServiceUrl}}
+		for name, value := range extra {
+			form.Set(name, value)
+		}
+		return form
+	}
+
+	It("Should show the extra fields on the login form", func() {
+		w := castest.NewClient(server).Get("/login")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`name="tenant"`))
+		Expect(w.Body.String()).To(ContainSubstring(">Organization</label>"))
+		Expect(w.Body.String()).To(ContainSubstring(`name="region"`))
+		Expect(w.Body.String()).To(ContainSubstring(">region</label>"))
+	})
+
+	It("Should pass the submitted values of the extra fields to authenticators that use them", func() {
+		var received map[string]string
+		server.Authenticator = CASAuthenticatorFunc(func(email, password string, fields map[string]string) (*User, *CASServerError) {
+			received = fields
+			if fields["tenant"] != "acme" {
+				return nil, &InvalidCredentialsError
+			}
+			return db.FindUserByEmail(email)
+		})
+
+		w := login(credentials(map[string]string{"tenant": " acme ", "other": "ignored"}))
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+		Expect(received).To(Equal(map[string]string{"tenant": "acme", "region": ""}))
+
+		// The values are kept on the form when the login fails
+		w = login(credentials(map[string]string{"tenant": "initech"}))
+		Expect(w.Code).To(Equal(InvalidCredentialsError.HttpCode))
+		Expect(w.Body.String()).To(ContainSubstring(`value="initech"`))
+	})
+
+	It("Should pass the extra fields on non-interactive (gateway) logins", func() {
+		var received map[string]string
+		server.Authenticator = CASAuthenticatorFunc(func(email, password string, fields map[string]string) (*User, *CASServerError) {
+			received = fields
+			return db.FindUserByEmail(email)
+		})
+
+		form := credentials(map[string]string{"region": "eu", "gateway": "true"})
+		Expect(login(form).Code).To(Equal(http.StatusFound))
+		Expect(received["region"]).To(Equal("eu"))
+	})
+
+	It("Should ignore the extra fields with the default authenticator", func() {
+		w := login(credentials(map[string]string{"tenant": "acme"}))
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+
+		// Function authenticators can also be used where only the email & password are known
+		Expect(CASAuthenticatorFunc(func(email, password string, fields map[string]string) (*User, *CASServerError) {
+			Expect(fields).To(BeEmpty())
+			return db.FindUserByEmail(email)
+		}).Authenticate("test@test.com", "secret")).NotTo(BeNil())
+	})
+
+	It("Should parse extra fields from configuration", func() {
+		fields, err := ParseLoginFields(" tenant : Organization , region,")
+		Expect(err).To(BeNil())
+		Expect(fields).To(Equal([]LoginField{{Name: "tenant", Label: "Organization"}, {Name: "region", Label: "region"}}))
+
+		fields, err = ParseLoginFields("")
+		Expect(err).To(BeNil())
+		Expect(fields).To(BeEmpty())
+	})
+
+	It("Should refuse extra fields that aren't field names, replace fields of the form or are declared twice", func() {
+		for _, list := range []string{"ten ant", "tenant<", ":Label", "password", "serviceurl", "csrf_token", "tenant,Tenant:Organization"} {
+			_, err := ParseLoginFields(list)
+			Expect(err).To(HaveOccurred(), list)
+
+			config, err := NewCASServerConfig("")
+			Expect(err).To(BeNil())
+			config["loginExtraFields"] = list
+			Expect(ValidateConfig(config)).To(HaveOccurred(), list)
+		}
+	})
+})
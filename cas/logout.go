@@ -0,0 +1,198 @@
+package cas
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Single Logout (SLO) back-channel notifications
+ */
+
+// XML namespaces used by SAML logout requests
+const (
+	SAML_PROTOCOL_NAMESPACE  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	SAML_ASSERTION_NAMESPACE = "urn:oasis:names:tc:SAML:2.0:assertion"
+)
+
+// Identifier placed in the NameID of logout requests (services identify the session by SessionIndex)
+const SAML_LOGOUT_NAME_ID = "@NOT_USED@"
+
+// SAML 2.0 logout request, as sent by CAS servers to notify services of a logout
+type SAMLLogoutRequest struct {
+	XMLName      xml.Name `xml:"samlp:LogoutRequest"`
+	XMLNSSAMLP   string   `xml:"xmlns:samlp,attr"`
+	XMLNSSAML    string   `xml:"xmlns:saml,attr"`
+	ID           string   `xml:"ID,attr"`
+	Version      string   `xml:"Version,attr"`
+	IssueInstant string   `xml:"IssueInstant,attr"`
+	NameID       string   `xml:"saml:NameID"`
+	SessionIndex string   `xml:"samlp:SessionIndex"`
+}
+
+// Create a new logout request for the session identified by a service ticket
+func NewSAMLLogoutRequest(serviceTicketId string, issueInstant time.Time) (*SAMLLogoutRequest, error) {
+	requestId, err := newTicketId("LR")
+	if err != nil {
+		return nil, err
+	}
+
+	return &SAMLLogoutRequest{
+		XMLNSSAMLP:   SAML_PROTOCOL_NAMESPACE,
+		XMLNSSAML:    SAML_ASSERTION_NAMESPACE,
+		ID:           requestId,
+		Version:      "2.0",
+		IssueInstant: issueInstant.UTC().Format(time.RFC3339),
+		NameID:       SAML_LOGOUT_NAME_ID,
+		SessionIndex: serviceTicketId,
+	}, nil
+}
+
+// Logout request to be delivered to a service's logout URL
+type LogoutNotification struct {
+	LogoutUrl       string
+	ServiceTicketId string
+}
+
+// Deliver a logout request to a service, as the "logoutRequest" form parameter of a POST
+func SendLogoutRequest(client *http.Client, notification LogoutNotification) error {
+	logoutRequest, err := NewSAMLLogoutRequest(notification.ServiceTicketId, time.Now())
+	if err != nil {
+		return err
+	}
+
+	body, err := xml.Marshal(logoutRequest)
+	if err != nil {
+		return err
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: SLO_DEFAULT_TIMEOUT}
+	}
+
+	resp, err := client.PostForm(notification.LogoutUrl, url.Values{"logoutRequest": {string(body)}})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Logout URL [%s] responded with status %d", notification.LogoutUrl, resp.StatusCode)
+	}
+	return nil
+}
+
+// Default timeout for delivering a single logout request
+const SLO_DEFAULT_TIMEOUT = 5 * time.Second
+
+// Deliver logout requests concurrently, using at most the given number of workers
+// Returns the errors (if any) for each notification, in the same order as the notifications
+func SendLogoutRequests(client *http.Client, notifications []LogoutNotification, workers int) []error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	errs := make([]error, len(notifications))
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers && i < len(notifications); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				errs[idx] = SendLogoutRequest(client, notifications[idx])
+			}
+		}()
+	}
+
+	for idx := range notifications {
+		indices <- idx
+	}
+	close(indices)
+	wg.Wait()
+
+	return errs
+}
+
+// Build the logout notifications for all services that validated a ticket issued under a ticket-granting ticket
+func (c *CAS) logoutNotificationsForTGT(db Backend, tgtId string) []LogoutNotification {
+	notifications := []LogoutNotification{}
+	if len(tgtId) == 0 {
+		return notifications
+	}
+
+	tickets, casErr := db.FindValidatedTicketsForTGT(tgtId)
+	if casErr != nil {
+		c.Logger.Error("Failed to find validated tickets for single logout", "sessionId", sessionIdForTGT(tgtId), "error", casErr)
+		return notifications
+	}
+
+	services := map[string]*CASService{}
+	for _, ticket := range tickets {
+		service, ok := services[ticket.ServiceUrl]
+		if !ok {
+			service, casErr = c.findServiceForUrl(db, ticket.ServiceUrl)
+			if casErr != nil {
+				c.Logger.Warn("Failed to find service for single logout", "service", ticket.ServiceUrl, "error", casErr)
+			}
+			services[ticket.ServiceUrl] = service
+		}
+
+		// Only services with a registered logout URL are notified
+		if service == nil || len(service.LogoutUrl) == 0 {
+			continue
+		}
+
+		notifications = append(notifications, LogoutNotification{
+			LogoutUrl:       service.LogoutUrl,
+			ServiceTicketId: ticket.Id,
+		})
+	}
+
+	return notifications
+}
+
+// Notify services of a logout in the background, so slow or unreachable services don't delay it
+func (c *CAS) notifyLogout(notifications []LogoutNotification) {
+	if len(notifications) == 0 {
+		return
+	}
+	c.sloHealth.Queued(len(notifications))
+	c.background.Go(func() { c.sendLogoutNotifications(notifications) })
+}
+
+// Notify services of a logout, logging (but otherwise ignoring) services that could not be notified
+func (c *CAS) sendLogoutNotifications(notifications []LogoutNotification) {
+	workers, err := configInt(c.Config, "sloWorkers")
+	if err != nil {
+		c.Logger.Warn("Invalid configuration value, using default", "key", "sloWorkers", "error", err)
+		workers, _ = configInt(CONFIG_DEFAULTS, "sloWorkers")
+	}
+
+	errs := SendLogoutRequests(c.SingleLogoutClient, notifications, workers)
+	for i, err := range errs {
+		c.sloHealth.Ran(err)
+		if err != nil {
+			c.Logger.Warn("Failed to notify service of logout", "logoutUrl", notifications[i].LogoutUrl, "error", err)
+		}
+	}
+}
+
+// Check that a service logout URL (if specified) is an absolute HTTP(S) URL
+func isValidLogoutUrl(logoutUrl string) bool {
+	if len(logoutUrl) == 0 {
+		return true
+	}
+	parsed, err := url.Parse(logoutUrl)
+	if err != nil {
+		return false
+	}
+	scheme := strings.ToLower(parsed.Scheme)
+	return (scheme == "http" || scheme == "https") && len(parsed.Host) > 0
+}
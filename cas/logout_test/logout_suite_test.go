@@ -0,0 +1,12 @@
+package logout_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoLogout(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Logout Suite")
+}
@@ -0,0 +1,137 @@
+package logout_test
+
+import (
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ = Describe("SAMLLogoutRequest", func() {
+
+	It("Should marshal to a SAML LogoutRequest with the ticket as session index", func() {
+		issueInstant := time.Date(2015, 6, 1, 12, 30, 0, 0, time.UTC)
+		logoutRequest, err := NewSAMLLogoutRequest("ST-123", issueInstant)
+		Expect(err).To(BeNil())
+
+		body, err := xml.Marshal(logoutRequest)
+		Expect(err).To(BeNil())
+		Expect(string(body)).To(HavePrefix(`<samlp:LogoutRequest xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="LR-`))
+		Expect(string(body)).To(ContainSubstring(`Version="2.0" IssueInstant="2015-06-01T12:30:00Z">`))
+		Expect(string(body)).To(ContainSubstring(`<saml:NameID>@NOT_USED@</saml:NameID>`))
+		Expect(string(body)).To(HaveSuffix(`<samlp:SessionIndex>ST-123</samlp:SessionIndex></samlp:LogoutRequest>`))
+	})
+
+	It("Should generate unique request IDs", func() {
+		first, _ := NewSAMLLogoutRequest("ST-123", time.Now())
+		second, _ := NewSAMLLogoutRequest("ST-123", time.Now())
+		Expect(first.ID).ToNot(Equal(second.ID))
+	})
+
+})
+
+var _ = Describe("SendLogoutRequests", func() {
+
+	It("Should POST the logout request as a form parameter", func() {
+		received := make(chan string, 1)
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			Expect(req.Method).To(Equal("POST"))
+			received <- req.FormValue("logoutRequest")
+		}))
+		defer server.Close()
+
+		err := SendLogoutRequest(nil, LogoutNotification{LogoutUrl: server.URL, ServiceTicketId: "ST-abc"})
+		Expect(err).To(BeNil())
+		Expect(<-received).To(ContainSubstring("<samlp:SessionIndex>ST-abc</samlp:SessionIndex>"))
+	})
+
+	It("Should notify remaining services when one fails", func() {
+		var delivered int32
+		okServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			atomic.AddInt32(&delivered, 1)
+		}))
+		defer okServer.Close()
+		failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			http.Error(w, "nope", http.StatusInternalServerError)
+		}))
+		defer failingServer.Close()
+
+		errs := SendLogoutRequests(nil, []LogoutNotification{
+			{LogoutUrl: okServer.URL, ServiceTicketId: "ST-1"},
+			{LogoutUrl: failingServer.URL, ServiceTicketId: "ST-2"},
+			{LogoutUrl: "http://127.0.0.1:1/unreachable", ServiceTicketId: "ST-3"},
+			{LogoutUrl: okServer.URL, ServiceTicketId: "ST-4"},
+		}, 2)
+
+		Expect(errs).To(HaveLen(4))
+		Expect(errs[0]).To(BeNil())
+		Expect(errs[1]).ToNot(BeNil())
+		Expect(errs[2]).ToNot(BeNil())
+		Expect(errs[3]).To(BeNil())
+		Expect(atomic.LoadInt32(&delivered)).To(Equal(int32(2)))
+	})
+
+	It("Should deliver with at most the given number of concurrent workers", func() {
+		var mu sync.Mutex
+		inFlight, maxInFlight := 0, 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mu.Unlock()
+
+			time.Sleep(20 * time.Millisecond)
+
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}))
+		defer server.Close()
+
+		notifications := []LogoutNotification{}
+		for i := 0; i < 10; i++ {
+			notifications = append(notifications, LogoutNotification{LogoutUrl: server.URL, ServiceTicketId: "ST"})
+		}
+
+		errs := SendLogoutRequests(nil, notifications, 3)
+		for _, err := range errs {
+			Expect(err).To(BeNil())
+		}
+		Expect(maxInFlight).To(BeNumerically("<=", 3))
+		Expect(maxInFlight).To(BeNumerically(">", 1))
+	})
+
+	It("Should handle no notifications", func() {
+		Expect(SendLogoutRequests(nil, []LogoutNotification{}, 5)).To(BeEmpty())
+	})
+
+})
+
+var _ = Describe("CASService logout URLs", func() {
+
+	It("Should accept services without a logout URL", func() {
+		service := &CASService{Name: "svc", Url: "http://localhost/app", AdminEmail: "admin@test.com"}
+		Expect(service.IsValid()).To(BeTrue())
+	})
+
+	It("Should accept absolute HTTP(S) logout URLs", func() {
+		service := &CASService{Name: "svc", Url: "http://localhost/app", AdminEmail: "admin@test.com", LogoutUrl: "https://localhost/app/logout"}
+		Expect(service.IsValid()).To(BeTrue())
+		Expect(service.IsValidUpdate()).To(BeTrue())
+	})
+
+	It("Should reject invalid logout URLs", func() {
+		service := &CASService{Name: "svc", Url: "http://localhost/app", AdminEmail: "admin@test.com", LogoutUrl: "/relative/logout"}
+		Expect(service.IsValid()).To(BeFalse())
+		service.LogoutUrl = "ftp://localhost/logout"
+		Expect(service.IsValidUpdate()).To(BeFalse())
+	})
+
+})
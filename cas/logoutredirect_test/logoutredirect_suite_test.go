@@ -0,0 +1,12 @@
+package logoutredirect_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoLogoutRedirect(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo LogoutRedirect Suite")
+}
@@ -0,0 +1,100 @@
+package logoutredirect_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+var _ = Describe("Logout redirect", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	newServer := func(logoutRedirect string) {
+		server, db = castest.NewTestServer(map[string]string{"logoutRedirect": logoutRedirect})
+		client = castest.NewClient(server)
+		client.Header.Set("Accept-Language", "en")
+	}
+
+	login := func() {
+		Expect(client.Login(url.Values{"serviceUrl": {testServiceUrl}}).Code).To(Equal(http.StatusFound))
+	}
+
+	logout := func(service string) *httptest.ResponseRecorder {
+		return client.Get("/logout?" + url.Values{"service": {service}}.Encode())
+	}
+
+	// Whether the session still has a user logged in (the login page redirects them straight to the service)
+	loggedIn := func() bool {
+		w := client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode())
+		return w.Code == http.StatusFound
+	}
+
+	BeforeEach(func() {
+		newServer("true")
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should redirect to a registered service after logging out", func() {
+		login()
+		Expect(loggedIn()).To(BeTrue())
+
+		w := logout(testServiceUrl)
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring(testServiceUrl))
+		Expect(loggedIn()).To(BeFalse())
+
+		// Users that are already logged out are sent back too
+		w = logout(testServiceUrl)
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring(testServiceUrl))
+	})
+
+	It("Should log out without redirecting to unregistered service URLs", func() {
+		login()
+
+		w := logout("https://evil.example.com/phish")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Location")).To(BeEmpty())
+		Expect(w.Body.String()).To(ContainSubstring("Successfully logged out"))
+		Expect(w.Body.String()).NotTo(ContainSubstring("evil.example.com"))
+		Expect(loggedIn()).To(BeFalse())
+	})
+
+	It("Should show the logout page when no service is given", func() {
+		login()
+
+		w := client.Get("/logout")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("Successfully logged out"))
+		Expect(w.Body.String()).NotTo(ContainSubstring(`id="return-to-service"`))
+		Expect(loggedIn()).To(BeFalse())
+	})
+
+	It("Should link to the service instead of redirecting when logoutRedirect is disabled", func() {
+		castest.Close(server)
+		newServer("false")
+		service := &CASService{Name: "portal", Url: "https://portal.example.com/", AdminEmail: "admin@test.com"}
+		Expect(db.AddNewService(service)).To(BeNil())
+		login()
+
+		w := logout(service.Url)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("Successfully logged out"))
+		Expect(w.Body.String()).To(ContainSubstring(`id="return-to-service" href="https://portal.example.com/"`))
+		Expect(w.Body.String()).To(ContainSubstring("Return to portal"))
+		Expect(loggedIn()).To(BeFalse())
+	})
+})
@@ -0,0 +1,691 @@
+package cas
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * In-memory storage backend (for tests, demos and local development)
+ */
+
+var _ SweepableBackend = (*MemoryBackend)(nil)
+var _ AuditableBackend = (*MemoryBackend)(nil)
+
+func init() {
+	RegisterBackend("memory", func(c *CAS) (Backend, error) {
+		// Expired tickets are removed by the server's TicketSweeper
+		return NewMemoryBackend(0), nil
+	})
+}
+
+// Storage backend keeping all data in maps, which is lost when the server stops
+// Expired tickets are periodically removed by a background sweeper
+type MemoryBackend struct {
+	mu       sync.RWMutex
+	now      func() time.Time
+	users    map[string]User            // By email
+	services map[string]CASService      // By name
+	apiKeys  map[string]CasgoAPIKeyPair // By key
+	tickets  map[string]CASTicket
+	pgts     map[string]CASProxyGrantingTicket
+	pts      map[string]CASProxyTicket
+	tgts     map[string]CASTicketGrantingTicket
+	codes    map[string]OIDCAuthorizationCode
+	audit    []AuditEvent // In the order they were added
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// Create an empty in-memory backend, sweeping expired tickets at the given interval (0 disables sweeping)
+func NewMemoryBackend(sweepInterval time.Duration) *MemoryBackend {
+	db := &MemoryBackend{
+		now:  time.Now,
+		stop: make(chan struct{}),
+	}
+	db.reset()
+
+	if sweepInterval > 0 {
+		db.stopped.Add(1)
+		go db.sweepLoop(sweepInterval)
+	}
+	return db
+}
+
+// Override the clock used to determine ticket expiry (for testing)
+func (db *MemoryBackend) SetClock(now func() time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.now = now
+}
+
+// Stop the background sweeper
+func (db *MemoryBackend) Close() {
+	db.stopOnce.Do(func() { close(db.stop) })
+	db.stopped.Wait()
+}
+
+func (db *MemoryBackend) reset() {
+	db.users = map[string]User{}
+	db.services = map[string]CASService{}
+	db.apiKeys = map[string]CasgoAPIKeyPair{}
+	db.tickets = map[string]CASTicket{}
+	db.pgts = map[string]CASProxyGrantingTicket{}
+	db.pts = map[string]CASProxyTicket{}
+	db.tgts = map[string]CASTicketGrantingTicket{}
+	db.codes = map[string]OIDCAuthorizationCode{}
+	db.audit = []AuditEvent{}
+}
+
+func (db *MemoryBackend) sweepLoop(interval time.Duration) {
+	defer db.stopped.Done()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			db.Sweep()
+		case <-db.stop:
+			return
+		}
+	}
+}
+
+// Remove expired ticket-granting, proxy-granting & proxy tickets and authorization codes (as of the backend's clock)
+// Service tickets issued under an expired ticket-granting ticket are removed along with it
+func (db *MemoryBackend) Sweep() {
+	db.mu.RLock()
+	now := db.now()
+	db.mu.RUnlock()
+	db.RemoveExpiredTickets(now)
+}
+
+// Remove tickets that expired at or before the given time
+func (db *MemoryBackend) RemoveExpiredTickets(now time.Time) (SweepResult, *CASServerError) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	result := SweepResult{}
+	expiredTgts := map[string]bool{}
+	for id, tgt := range db.tgts {
+		if tgt.IsExpired(now) {
+			expiredTgts[id] = true
+			delete(db.tgts, id)
+			result.TicketGrantingTickets++
+		}
+	}
+	for id, ticket := range db.tickets {
+		if expiredTgts[ticket.TGTId] {
+			delete(db.tickets, id)
+			result.ServiceTickets++
+		}
+	}
+	for id, pgt := range db.pgts {
+		if pgt.IsExpired(now) {
+			delete(db.pgts, id)
+			result.ProxyGrantingTickets++
+		}
+	}
+	for id, pt := range db.pts {
+		if pt.IsExpired(now) {
+			delete(db.pts, id)
+			result.ProxyTickets++
+		}
+	}
+	for id, code := range db.codes {
+		if code.IsExpired(now) {
+			delete(db.codes, id)
+			result.OIDCCodes++
+		}
+	}
+	return result, nil
+}
+
+// Load a JSON array of users, services or API key pairs (with the same format as fixtures used for RethinkDB)
+func (db *MemoryBackend) LoadJSONFixture(tableName, path string) *CASServerError {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		casErr := &FailedToLoadJSONFixtureError
+		casErr.err = &err
+		return casErr
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	switch tableName {
+	case "users":
+		var users []User
+		if err = json.Unmarshal(data, &users); err == nil {
+			for _, user := range users {
+				db.users[user.Email] = user
+			}
+		}
+	case "services":
+		var services []CASService
+		if err = json.Unmarshal(data, &services); err == nil {
+			for _, service := range services {
+				if casErr := service.ensureId(); casErr != nil {
+					return casErr
+				}
+				db.services[service.Name] = service
+			}
+		}
+	case "api_keys":
+		var apiKeys []CasgoAPIKeyPair
+		if err = json.Unmarshal(data, &apiKeys); err == nil {
+			for _, apiKey := range apiKeys {
+				db.apiKeys[apiKey.Key] = apiKey
+			}
+		}
+	default:
+		return &FailedToLoadJSONFixtureError
+	}
+
+	if err != nil {
+		casErr := &FailedToLoadJSONFixtureError
+		casErr.err = &err
+		return casErr
+	}
+	return nil
+}
+
+// Nothing needs to be created for in-memory storage
+func (db *MemoryBackend) Setup() *CASServerError {
+	return nil
+}
+
+// Remove all stored data
+func (db *MemoryBackend) Teardown() *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.reset()
+	return nil
+}
+
+func (db *MemoryBackend) FindUserByEmail(email string) (*User, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	user, ok := db.users[email]
+	if !ok {
+		return nil, &FailedToFindUserByEmailError
+	}
+	return &user, nil
+}
+
+func (db *MemoryBackend) FindUserByApiKeyAndSecret(key, secret string) (*User, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	apiKeyPair, ok := db.apiKeys[key]
+	if !ok || !SecretsEqual(secret, apiKeyPair.Secret) {
+		return nil, &FailedToFindUserByApiKeyAndSecretError
+	}
+	return apiKeyPair.User, nil
+}
+
+func (db *MemoryBackend) AddNewUser(username, password string) (*User, *CASServerError) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.users[username]; ok {
+		return nil, &EmailAlreadyTakenError
+	}
+
+	user := User{
+		Email:    username,
+		Password: password,
+	}
+	db.users[username] = user
+	return &user, nil
+}
+
+// Get all users (without their passwords)
+func (db *MemoryBackend) GetAllUsers() ([]User, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	users := []User{}
+	for _, user := range db.users {
+		user.Password = ""
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// Find a page of users (without their passwords)
+func (db *MemoryBackend) FindUsers(query CASUserQuery) ([]User, int, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	matching := []User{}
+	for _, user := range db.users {
+		if query.Matches(&user) {
+			user.Password = ""
+			matching = append(matching, user)
+		}
+	}
+	sort.Sort(usersByEmail(matching))
+
+	total := len(matching)
+	start := query.Offset
+	if start > total {
+		start = total
+	}
+	end := total
+	if query.Limit > 0 && start+query.Limit < end {
+		end = start + query.Limit
+	}
+	return matching[start:end], total, nil
+}
+
+type usersByEmail []User
+
+func (u usersByEmail) Len() int           { return len(u) }
+func (u usersByEmail) Less(i, j int) bool { return u[i].Email < u[j].Email }
+func (u usersByEmail) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+func (db *MemoryBackend) UpdateUser(user *User) *CASServerError {
+	if len(user.Email) == 0 {
+		return &InvalidUserEmailError
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.users[user.Email]; !ok {
+		return &FailedToUpdateUserError
+	}
+	db.users[user.Email] = *user
+	return nil
+}
+
+func (db *MemoryBackend) RemoveUserByEmail(email string) *CASServerError {
+	if len(email) == 0 {
+		return &InvalidUserEmailError
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.users, email)
+	return nil
+}
+
+func (db *MemoryBackend) FindServiceByUrl(serviceUrl string) (*CASService, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, service := range db.services {
+		if service.Url == serviceUrl {
+			return &service, nil
+		}
+	}
+	return nil, &FailedToLookupServiceByUrlError
+}
+
+func (db *MemoryBackend) FindServiceById(id string) (*CASService, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, service := range db.services {
+		if len(id) > 0 && service.Id == id {
+			return &service, nil
+		}
+	}
+	return nil, &ServiceNotFoundError
+}
+
+func (db *MemoryBackend) GetAllServices() ([]CASService, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	services := []CASService{}
+	for _, service := range db.services {
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func (db *MemoryBackend) FindServices(query CASServiceQuery) ([]CASService, int, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	name := strings.ToLower(query.Name)
+	matching := []CASService{}
+	for _, service := range db.services {
+		if strings.Contains(strings.ToLower(service.Name), name) {
+			matching = append(matching, service)
+		}
+	}
+	sort.Sort(servicesByName(matching))
+
+	total := len(matching)
+	start := query.Offset
+	if start > total {
+		start = total
+	}
+	end := total
+	if query.Limit > 0 && start+query.Limit < end {
+		end = start + query.Limit
+	}
+	return matching[start:end], total, nil
+}
+
+type servicesByName []CASService
+
+func (s servicesByName) Len() int           { return len(s) }
+func (s servicesByName) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s servicesByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (db *MemoryBackend) AddNewService(service *CASService) *CASServerError {
+	if len(service.Name) == 0 {
+		return &InvalidServiceNameError
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.services[service.Name]; ok {
+		return &ServiceNameAlreadyTakenError
+	}
+	if casErr := service.ensureId(); casErr != nil {
+		return casErr
+	}
+	db.services[service.Name] = *service
+	return nil
+}
+
+func (db *MemoryBackend) RemoveServiceByName(name string) *CASServerError {
+	if len(name) == 0 {
+		return &InvalidServiceNameError
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.services, name)
+	return nil
+}
+
+func (db *MemoryBackend) UpdateService(service *CASService) *CASServerError {
+	return db.updateService(service, nil)
+}
+
+func (db *MemoryBackend) UpdateServiceIfVersion(service *CASService, version int) *CASServerError {
+	return db.updateService(service, &version)
+}
+
+// Update a service, if it is at the expected version (any version if nil)
+func (db *MemoryBackend) updateService(service *CASService, version *int) *CASServerError {
+	if len(service.Name) == 0 {
+		return &InvalidServiceNameError
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	existing, ok := db.services[service.Name]
+	if !ok {
+		return &FailedToUpdateServiceError
+	}
+	if version != nil && existing.Version != *version {
+		return &ServiceVersionConflictError
+	}
+
+	// Service IDs never change (services created before they had IDs are given one)
+	service.Id = existing.Id
+	service.Version = existing.Version + 1
+	if casErr := service.ensureId(); casErr != nil {
+		return casErr
+	}
+	db.services[service.Name] = *service
+	return nil
+}
+
+func (db *MemoryBackend) AddTicketForService(ticket *CASTicket, service *CASService) (*CASTicket, *CASServerError) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.tickets[ticket.Id]; ok || len(ticket.Id) == 0 {
+		return nil, &FailedToCreateTicketError
+	}
+	db.tickets[ticket.Id] = *ticket
+	return ticket, nil
+}
+
+// Remove all tickets for a user (regardless of service, matching the RethinkDB adapter)
+func (db *MemoryBackend) RemoveTicketsForUserWithService(email string, service *CASService) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for id, ticket := range db.tickets {
+		if ticket.UserEmail == email {
+			delete(db.tickets, id)
+		}
+	}
+	return nil
+}
+
+func (db *MemoryBackend) FindTicketByIdForService(ticketId string, service *CASService) (*CASTicket, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	ticket, ok := db.tickets[ticketId]
+	if !ok {
+		return nil, &FailedToFindTicketError
+	}
+	return &ticket, nil
+}
+
+func (db *MemoryBackend) ConsumeTicket(ticketId string, now time.Time, reuseWindow time.Duration) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	ticket, ok := db.tickets[ticketId]
+	if !ok {
+		return &FailedToUpdateTicketError
+	}
+	if ticket.Validated {
+		if !now.Before(ticket.ValidatedAt.Add(reuseWindow)) {
+			return &TicketAlreadyValidatedError
+		}
+		return nil
+	}
+	ticket.Validated, ticket.ValidatedAt = true, now
+	db.tickets[ticketId] = ticket
+	return nil
+}
+
+func (db *MemoryBackend) FindValidatedTicketsForTGT(tgtId string) ([]CASTicket, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tickets := []CASTicket{}
+	for _, ticket := range db.tickets {
+		if ticket.TGTId == tgtId && ticket.Validated {
+			tickets = append(tickets, ticket)
+		}
+	}
+	return tickets, nil
+}
+
+func (db *MemoryBackend) FindTicketsForTGT(tgtId string) ([]CASTicket, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tickets := []CASTicket{}
+	for _, ticket := range db.tickets {
+		if ticket.TGTId == tgtId {
+			tickets = append(tickets, ticket)
+		}
+	}
+	return tickets, nil
+}
+
+func (db *MemoryBackend) AddProxyGrantingTicket(pgt *CASProxyGrantingTicket) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.pgts[pgt.Id]; ok {
+		return &FailedToCreateProxyGrantingTicketError
+	}
+	db.pgts[pgt.Id] = *pgt
+	return nil
+}
+
+func (db *MemoryBackend) FindProxyGrantingTicketById(pgtId string) (*CASProxyGrantingTicket, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pgt, ok := db.pgts[pgtId]
+	if !ok {
+		return nil, &FailedToFindProxyGrantingTicketError
+	}
+	return &pgt, nil
+}
+
+func (db *MemoryBackend) AddProxyTicket(pt *CASProxyTicket) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.pts[pt.Id]; ok {
+		return &FailedToCreateProxyTicketError
+	}
+	db.pts[pt.Id] = *pt
+	return nil
+}
+
+func (db *MemoryBackend) FindProxyTicketById(ptId string) (*CASProxyTicket, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	pt, ok := db.pts[ptId]
+	if !ok {
+		return nil, &FailedToFindProxyTicketError
+	}
+	return &pt, nil
+}
+
+func (db *MemoryBackend) ConsumeProxyTicket(ptId string) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.pts[ptId]; !ok {
+		return &FailedToFindProxyTicketError
+	}
+	delete(db.pts, ptId)
+	return nil
+}
+
+func (db *MemoryBackend) AddOIDCAuthorizationCode(code *OIDCAuthorizationCode) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.codes[code.Code]; ok {
+		return &FailedToCreateOIDCCodeError
+	}
+	db.codes[code.Code] = *code
+	return nil
+}
+
+func (db *MemoryBackend) ConsumeOIDCAuthorizationCode(code string) (*OIDCAuthorizationCode, *CASServerError) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	authorization, ok := db.codes[code]
+	if !ok {
+		return nil, nil
+	}
+	delete(db.codes, code)
+	return &authorization, nil
+}
+
+func (db *MemoryBackend) AddTicketGrantingTicket(tgt *CASTicketGrantingTicket) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.tgts[tgt.Id]; ok {
+		return &FailedToCreateTicketGrantingTicketError
+	}
+	db.tgts[tgt.Id] = *tgt
+	return nil
+}
+
+func (db *MemoryBackend) FindTicketGrantingTicketById(tgtId string) (*CASTicketGrantingTicket, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tgt, ok := db.tgts[tgtId]
+	if !ok {
+		return nil, &FailedToFindTicketGrantingTicketError
+	}
+	return &tgt, nil
+}
+
+func (db *MemoryBackend) FindTicketGrantingTicketsForUser(email string) ([]CASTicketGrantingTicket, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	tgts := []CASTicketGrantingTicket{}
+	for _, tgt := range db.tgts {
+		if tgt.UserEmail == email {
+			tgts = append(tgts, tgt)
+		}
+	}
+	return tgts, nil
+}
+
+func (db *MemoryBackend) UpdateTicketGrantingTicket(tgt *CASTicketGrantingTicket) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if _, ok := db.tgts[tgt.Id]; !ok {
+		return &FailedToUpdateTicketGrantingTicketError
+	}
+	db.tgts[tgt.Id] = *tgt
+	return nil
+}
+
+func (db *MemoryBackend) RemoveTicketGrantingTicketById(tgtId string) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.tgts, tgtId)
+	return nil
+}
+
+func (db *MemoryBackend) AddAuditEvents(events []AuditEvent) *CASServerError {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	for _, event := range events {
+		event.Id = strconv.Itoa(len(db.audit) + 1)
+		db.audit = append(db.audit, event)
+	}
+	return nil
+}
+
+func (db *MemoryBackend) FindAuditEvents(query AuditEventQuery) ([]AuditEvent, *CASServerError) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	// Most recent first (events recorded at the same time stay in the reverse of the order they were added)
+	matching := []AuditEvent{}
+	for i := len(db.audit) - 1; i >= 0; i-- {
+		if query.Matches(db.audit[i]) {
+			matching = append(matching, db.audit[i])
+		}
+	}
+	sort.SliceStable(matching, func(i, j int) bool { return matching[i].Time.After(matching[j].Time) })
+
+	if query.Limit > 0 && len(matching) > query.Limit {
+		matching = matching[:query.Limit]
+	}
+	return matching, nil
+}
@@ -0,0 +1,12 @@
+package memory_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoMemoryBackend(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Memory Backend Suite")
+}
@@ -0,0 +1,389 @@
+package memory_test
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+var _ = Describe("MemoryBackend", func() {
+	var db *MemoryBackend
+
+	BeforeEach(func() {
+		db = NewMemoryBackend(0)
+	})
+
+	AfterEach(func() {
+		db.Close()
+	})
+
+	Describe("Users", func() {
+		It("Should add, find, update and remove users", func() {
+			_, casErr := db.AddNewUser("user@test.com", "hash")
+			Expect(casErr).To(BeNil())
+
+			_, casErr = db.AddNewUser("user@test.com", "hash")
+			Expect(casErr).To(Equal(&EmailAlreadyTakenError))
+
+			user, casErr := db.FindUserByEmail("user@test.com")
+			Expect(casErr).To(BeNil())
+			Expect(user.Password).To(Equal("hash"))
+
+			user.Name = "Test User"
+			Expect(db.UpdateUser(user)).To(BeNil())
+			user, _ = db.FindUserByEmail("user@test.com")
+			Expect(user.Name).To(Equal("Test User"))
+
+			Expect(db.RemoveUserByEmail("user@test.com")).To(BeNil())
+			_, casErr = db.FindUserByEmail("user@test.com")
+			Expect(casErr).NotTo(BeNil())
+		})
+
+		It("Should not update users that do not exist", func() {
+			Expect(db.UpdateUser(&User{Email: "missing@test.com"})).To(Equal(&FailedToUpdateUserError))
+		})
+
+		It("Should list users without their passwords", func() {
+			db.AddNewUser("user@test.com", "hash")
+			users, casErr := db.GetAllUsers()
+			Expect(casErr).To(BeNil())
+			Expect(users).To(HaveLen(1))
+			Expect(users[0].Password).To(BeEmpty())
+		})
+
+		It("Should find users by API key and secret", func() {
+			Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+
+			user, casErr := db.FindUserByApiKeyAndSecret("adminapikey", "badsecret")
+			Expect(casErr).To(BeNil())
+			Expect(user.Email).To(Equal("admin@test.com"))
+
+			_, casErr = db.FindUserByApiKeyAndSecret("adminapikey", "wrong")
+			Expect(casErr).NotTo(BeNil())
+		})
+	})
+
+	Describe("Services", func() {
+		BeforeEach(func() {
+			Expect(db.LoadJSONFixture("services", "../../fixtures/services.json")).To(BeNil())
+		})
+
+		It("Should load services from fixtures", func() {
+			services, casErr := db.GetAllServices()
+			Expect(casErr).To(BeNil())
+			Expect(services).To(HaveLen(3))
+
+			service, casErr := db.FindServiceByUrl(testServiceUrl)
+			Expect(casErr).To(BeNil())
+			Expect(service.Name).To(Equal("test_service"))
+		})
+
+		It("Should reject services with names that are taken", func() {
+			casErr := db.AddNewService(&CASService{Name: "test_service", Url: "localhost:4000", AdminEmail: "admin@test.com"})
+			Expect(casErr).To(Equal(&ServiceNameAlreadyTakenError))
+		})
+
+		It("Should update and remove services", func() {
+			service, _ := db.FindServiceByUrl(testServiceUrl)
+			service.LogoutUrl = "https://localhost:3000/logout"
+			Expect(db.UpdateService(service)).To(BeNil())
+
+			service, _ = db.FindServiceByUrl(testServiceUrl)
+			Expect(service.LogoutUrl).To(Equal("https://localhost:3000/logout"))
+
+			Expect(db.RemoveServiceByName("test_service")).To(BeNil())
+			_, casErr := db.FindServiceByUrl(testServiceUrl)
+			Expect(casErr).NotTo(BeNil())
+		})
+
+		It("Should fail to load unknown fixture tables", func() {
+			Expect(db.LoadJSONFixture("unknown", "../../fixtures/services.json")).NotTo(BeNil())
+		})
+	})
+
+	Describe("Tickets", func() {
+		var (
+			now     time.Time
+			service *CASService
+		)
+
+		BeforeEach(func() {
+			now = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+			db.SetClock(func() time.Time { return now })
+			service = &CASService{Name: "test_service", Url: testServiceUrl, AdminEmail: "admin@test.com"}
+		})
+
+		It("Should track which tickets were validated", func() {
+			db.AddTicketForService(&CASTicket{Id: "ST-1", UserEmail: "user@test.com", TGTId: "TGT-1"}, service)
+			db.AddTicketForService(&CASTicket{Id: "ST-2", UserEmail: "user@test.com", TGTId: "TGT-1"}, service)
+			Expect(db.ConsumeTicket("ST-1", now, 0)).To(BeNil())
+
+			tickets, casErr := db.FindValidatedTicketsForTGT("TGT-1")
+			Expect(casErr).To(BeNil())
+			Expect(tickets).To(HaveLen(1))
+			Expect(tickets[0].Id).To(Equal("ST-1"))
+
+			Expect(db.ConsumeTicket("ST-404", now, 0)).NotTo(BeNil())
+		})
+
+		It("Should only consume a ticket once, even concurrently", func() {
+			db.AddTicketForService(&CASTicket{Id: "ST-1", UserEmail: "user@test.com", TGTId: "TGT-1"}, service)
+
+			results := make(chan *CASServerError, 20)
+			var wg sync.WaitGroup
+			for i := 0; i < cap(results); i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results <- db.ConsumeTicket("ST-1", now, 0)
+				}()
+			}
+			wg.Wait()
+			close(results)
+
+			consumed := 0
+			for casErr := range results {
+				if casErr == nil {
+					consumed++
+				} else {
+					Expect(casErr).To(Equal(&TicketAlreadyValidatedError))
+				}
+			}
+			Expect(consumed).To(Equal(1))
+		})
+
+		It("Should sweep expired tickets, along with service tickets issued under expired ticket-granting tickets", func() {
+			db.AddTicketGrantingTicket(&CASTicketGrantingTicket{Id: "TGT-old", ExpiresAt: now.Add(time.Minute)})
+			db.AddTicketGrantingTicket(&CASTicketGrantingTicket{Id: "TGT-new", ExpiresAt: now.Add(time.Hour)})
+			db.AddTicketForService(&CASTicket{Id: "ST-old", TGTId: "TGT-old"}, service)
+			db.AddTicketForService(&CASTicket{Id: "ST-new", TGTId: "TGT-new"}, service)
+			db.AddProxyGrantingTicket(&CASProxyGrantingTicket{Id: "PGT-1", ExpiresAt: now.Add(time.Minute)})
+			db.AddProxyTicket(&CASProxyTicket{Id: "PT-1", ExpiresAt: now.Add(time.Second)})
+
+			db.Sweep()
+			_, casErr := db.FindTicketGrantingTicketById("TGT-old")
+			Expect(casErr).To(BeNil())
+
+			now = now.Add(2 * time.Minute)
+			db.Sweep()
+
+			_, casErr = db.FindTicketGrantingTicketById("TGT-old")
+			Expect(casErr).NotTo(BeNil())
+			_, casErr = db.FindTicketByIdForService("ST-old", service)
+			Expect(casErr).NotTo(BeNil())
+			_, casErr = db.FindProxyGrantingTicketById("PGT-1")
+			Expect(casErr).NotTo(BeNil())
+			_, casErr = db.FindProxyTicketById("PT-1")
+			Expect(casErr).NotTo(BeNil())
+
+			_, casErr = db.FindTicketGrantingTicketById("TGT-new")
+			Expect(casErr).To(BeNil())
+			_, casErr = db.FindTicketByIdForService("ST-new", service)
+			Expect(casErr).To(BeNil())
+		})
+
+		It("Should hand out OpenID Connect authorization codes only once, and sweep them when they expire", func() {
+			Expect(db.AddOIDCAuthorizationCode(&OIDCAuthorizationCode{Code: "code-1", ExpiresAt: now.Add(time.Minute)})).To(BeNil())
+			Expect(db.AddOIDCAuthorizationCode(&OIDCAuthorizationCode{Code: "code-1"})).NotTo(BeNil())
+			Expect(db.AddOIDCAuthorizationCode(&OIDCAuthorizationCode{Code: "code-2", ExpiresAt: now.Add(time.Minute)})).To(BeNil())
+
+			code, casErr := db.ConsumeOIDCAuthorizationCode("code-1")
+			Expect(casErr).To(BeNil())
+			Expect(code.Code).To(Equal("code-1"))
+			code, casErr = db.ConsumeOIDCAuthorizationCode("code-1")
+			Expect(casErr).To(BeNil())
+			Expect(code).To(BeNil())
+
+			now = now.Add(2 * time.Minute)
+			result, casErr := db.RemoveExpiredTickets(now)
+			Expect(casErr).To(BeNil())
+			Expect(result.OIDCCodes).To(Equal(1))
+			code, _ = db.ConsumeOIDCAuthorizationCode("code-2")
+			Expect(code).To(BeNil())
+		})
+
+		It("Should sweep expired tickets in the background", func() {
+			sweeping := NewMemoryBackend(10 * time.Millisecond)
+			defer sweeping.Close()
+
+			sweeping.AddProxyTicket(&CASProxyTicket{Id: "PT-1", ExpiresAt: time.Now().Add(-time.Second)})
+			Eventually(func() *CASServerError {
+				_, casErr := sweeping.FindProxyTicketById("PT-1")
+				return casErr
+			}).ShouldNot(BeNil())
+		})
+	})
+
+	It("Should be safe for concurrent use", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				email := fmt.Sprintf("user%d@test.com", i)
+				db.AddNewUser(email, "hash")
+				db.FindUserByEmail(email)
+				db.GetAllUsers()
+				db.AddTicketGrantingTicket(&CASTicketGrantingTicket{Id: fmt.Sprintf("TGT-%d", i), ExpiresAt: time.Now()})
+				db.Sweep()
+			}(i)
+		}
+		wg.Wait()
+
+		users, _ := db.GetAllUsers()
+		Expect(users).To(HaveLen(20))
+	})
+
+	It("Should remove all data on teardown", func() {
+		db.AddNewUser("user@test.com", "hash")
+		Expect(db.Teardown()).To(BeNil())
+		users, _ := db.GetAllUsers()
+		Expect(users).To(BeEmpty())
+	})
+})
+
+var _ = Describe("CAS server with the memory backend", func() {
+	var (
+		server *CAS
+		client *castest.Client
+	)
+
+	// Perform a request against the server, passing along (and collecting) cookies
+	do := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		if method == "POST" {
+			return client.PostForm(path, form)
+		}
+		return client.Get(path + "?" + form.Encode())
+	}
+
+	login := func() string {
+		w := client.Login(url.Values{"serviceUrl": {testServiceUrl}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	BeforeEach(func() {
+		server, _ = castest.NewTestServer(nil)
+		client = castest.NewClient(server)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should issue a service ticket on login that validates with /validate", func() {
+		ticket := login()
+		Expect(ticket).NotTo(BeEmpty())
+
+		w := do("GET", "/validate", url.Values{"service": {testServiceUrl}, "ticket": {ticket}})
+		Expect(w.Code).To(Equal(http.StatusOK))
+
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("success"))
+		Expect(response["userEmail"]).To(Equal(castest.TestUserEmail))
+	})
+
+	It("Should validate service tickets with /serviceValidate", func() {
+		ticket := login()
+
+		w := do("GET", "/serviceValidate", url.Values{"service": {testServiceUrl}, "ticket": {ticket}})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("authenticationSuccess"))
+		Expect(w.Body.String()).To(ContainSubstring(castest.TestUserEmail))
+	})
+
+	It("Should reject unknown tickets", func() {
+		w := do("GET", "/serviceValidate", url.Values{"service": {testServiceUrl}, "ticket": {"ST-unknown"}})
+		Expect(w.Body.String()).To(ContainSubstring("authenticationFailure"))
+		Expect(w.Body.String()).To(ContainSubstring(CAS_INVALID_TICKET))
+	})
+
+	It("Should reject bad credentials", func() {
+		w := client.Login(url.Values{"password": {"wrong"}, "serviceUrl": {testServiceUrl}})
+		Expect(w.Code).NotTo(Equal(http.StatusFound))
+	})
+
+	It("Should issue tickets from an existing session (single sign on), until logout", func() {
+		login()
+
+		w := do("GET", "/login", url.Values{"service": {testServiceUrl}, "gateway": {"true"}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		location, _ := url.Parse(w.Header().Get("Location"))
+		ssoTicket := location.Query().Get("ticket")
+		Expect(ssoTicket).NotTo(BeEmpty())
+
+		w = do("GET", "/validate", url.Values{"service": {testServiceUrl}, "ticket": {ssoTicket}})
+		Expect(w.Body.String()).To(ContainSubstring("success"))
+
+		do("GET", "/logout", url.Values{})
+		w = do("GET", "/login", url.Values{"service": {testServiceUrl}, "gateway": {"true"}})
+		Expect(w.Header().Get("Location")).NotTo(ContainSubstring("ticket="))
+	})
+
+	Describe("Gateway and renew logins", func() {
+		validate := func(ticket, renew string) string {
+			w := do("GET", "/validate", url.Values{"service": {testServiceUrl}, "ticket": {ticket}, "renew": {renew}})
+			var response map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+			return response["status"].(string)
+		}
+
+		It("Should issue a single sign on ticket to gateway logins with a session", func() {
+			login()
+
+			w := do("GET", "/login", url.Values{"service": {testServiceUrl}, "gateway": {"true"}})
+			Expect(w.Code).To(Equal(http.StatusFound))
+			ticket := castest.Ticket(w)
+			Expect(ticket).NotTo(BeEmpty())
+			Expect(validate(ticket, "true")).To(Equal("error"))
+		})
+
+		It("Should redirect gateway logins without a session back to the service, without prompting", func() {
+			w := do("GET", "/login", url.Values{"service": {testServiceUrl}, "gateway": {"true"}})
+			Expect(w.Code).To(Equal(http.StatusFound))
+			Expect(w.Header().Get("Location")).To(Equal(testServiceUrl))
+
+			// No credentials were presented, so no login attempt failed
+			Expect(server.Metrics.LoginAttempts.Value("failure")).To(BeZero())
+
+			w = do("GET", "/login", url.Values{"gateway": {"true"}})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).To(ContainSubstring(`name="password"`))
+		})
+
+		It("Should issue tickets from a session to regular logins", func() {
+			login()
+
+			w := do("GET", "/login", url.Values{"service": {testServiceUrl}})
+			Expect(w.Code).To(Equal(http.StatusFound))
+			Expect(validate(castest.Ticket(w), "")).To(Equal("success"))
+		})
+
+		It("Should make users with a session present credentials again when renew is set", func() {
+			login()
+
+			for _, params := range []url.Values{
+				{"service": {testServiceUrl}, "renew": {"true"}},
+				{"service": {testServiceUrl}, "renew": {"true"}, "gateway": {"true"}},
+			} {
+				w := do("GET", "/login", params)
+				Expect(w.Code).To(Equal(http.StatusOK))
+				Expect(w.Body.String()).To(ContainSubstring(`name="password"`))
+			}
+
+			// Tickets issued for fresh credentials pass renew validation
+			Expect(validate(login(), "true")).To(Equal("success"))
+		})
+	})
+})
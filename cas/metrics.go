@@ -0,0 +1,316 @@
+package cas
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Prometheus metrics (text exposition format)
+ */
+
+// Default request latency histogram buckets (in seconds)
+var DEFAULT_LATENCY_BUCKETS = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Metrics collected by casgo
+type CASMetrics struct {
+	LoginAttempts      *MetricVec    // casgo_login_attempts_total{result}
+	TicketsIssued      *MetricVec    // casgo_tickets_issued_total{type}
+	TicketValidations  *MetricVec    // casgo_ticket_validations_total{endpoint,result}
+	ActiveSessions     *MetricVec    // casgo_active_sessions
+	RequestDuration    *HistogramVec // casgo_request_duration_seconds{route}
+	TicketsSwept       *MetricVec    // casgo_tickets_swept_total{type}
+	LastSweepRemoved   *MetricVec    // casgo_ticket_sweep_last_removed{type}
+	AuditEventsDropped *MetricVec    // casgo_audit_events_dropped_total{reason}
+	WebhookDeliveries  *MetricVec    // casgo_webhook_deliveries_total{result}
+	WorkerLastRun      *MetricVec    // casgo_worker_last_run_timestamp_seconds{worker}
+	WorkerQueueLength  *MetricVec    // casgo_worker_queue_length{worker}
+	WorkerErrors       *MetricVec    // casgo_worker_errors_total{worker}
+	WorkerDegraded     *MetricVec    // casgo_worker_degraded{worker}
+}
+
+func NewCASMetrics() *CASMetrics {
+	return &CASMetrics{
+		LoginAttempts:      NewMetricVec("casgo_login_attempts_total", "counter", "Number of login attempts", "result"),
+		TicketsIssued:      NewMetricVec("casgo_tickets_issued_total", "counter", "Number of tickets issued", "type"),
+		TicketValidations:  NewMetricVec("casgo_ticket_validations_total", "counter", "Number of ticket validations", "endpoint", "result"),
+		ActiveSessions:     NewMetricVec("casgo_active_sessions", "gauge", "Number of active login sessions"),
+		RequestDuration:    NewHistogramVec("casgo_request_duration_seconds", "Request latency per route", DEFAULT_LATENCY_BUCKETS, "route"),
+		TicketsSwept:       NewMetricVec("casgo_tickets_swept_total", "counter", "Number of expired tickets removed by the ticket sweeper", "type"),
+		LastSweepRemoved:   NewMetricVec("casgo_ticket_sweep_last_removed", "gauge", "Number of expired tickets removed by the last sweep", "type"),
+		AuditEventsDropped: NewMetricVec("casgo_audit_events_dropped_total", "counter", "Number of audit events that could not be recorded", "reason"),
+		WebhookDeliveries:  NewMetricVec("casgo_webhook_deliveries_total", "counter", "Number of webhook deliveries, by result (delivered, failed, dropped, abandoned)", "result"),
+		WorkerLastRun:      NewMetricVec("casgo_worker_last_run_timestamp_seconds", "gauge", "Time background workers last ran (as a Unix timestamp)", "worker"),
+		WorkerQueueLength:  NewMetricVec("casgo_worker_queue_length", "gauge", "Work queued for background workers", "worker"),
+		WorkerErrors:       NewMetricVec("casgo_worker_errors_total", "counter", "Number of failed background worker runs", "worker"),
+		WorkerDegraded:     NewMetricVec("casgo_worker_degraded", "gauge", "Whether background workers have a full queue or have stalled (1) or not (0)", "worker"),
+	}
+}
+
+// Record the latency of a request that started at the given time (ex. defer c.Metrics.ObserveRequest("/login", time.Now()))
+func (m *CASMetrics) ObserveRequest(route string, start time.Time) {
+	m.RequestDuration.Observe(time.Since(start).Seconds(), route)
+}
+
+// Record the outcome of a ticket validation
+func (m *CASMetrics) ObserveValidation(endpoint string, valid bool) {
+	result := "invalid"
+	if valid {
+		result = "valid"
+	}
+	m.TicketValidations.Inc(endpoint, result)
+}
+
+// Record the state of background workers
+func (m *CASMetrics) ObserveWorkers(statuses []WorkerStatus) {
+	for _, status := range statuses {
+		if status.LastRun != nil {
+			m.WorkerLastRun.Set(float64(status.LastRun.UnixNano())/1e9, status.Name)
+		}
+		m.WorkerQueueLength.Set(float64(status.QueueLength), status.Name)
+		m.WorkerErrors.Set(float64(status.Errors), status.Name)
+		degraded := 0.0
+		if status.Status != HEALTH_OK {
+			degraded = 1
+		}
+		m.WorkerDegraded.Set(degraded, status.Name)
+	}
+}
+
+// Write all metrics in the Prometheus text format
+func (m *CASMetrics) WriteText(w io.Writer) {
+	m.LoginAttempts.writeTo(w)
+	m.TicketsIssued.writeTo(w)
+	m.TicketValidations.writeTo(w)
+	m.ActiveSessions.writeTo(w)
+	m.RequestDuration.writeTo(w)
+	m.TicketsSwept.writeTo(w)
+	m.LastSweepRemoved.writeTo(w)
+	m.AuditEventsDropped.writeTo(w)
+	m.WebhookDeliveries.writeTo(w)
+	m.WorkerLastRun.writeTo(w)
+	m.WorkerQueueLength.writeTo(w)
+	m.WorkerErrors.writeTo(w)
+	m.WorkerDegraded.writeTo(w)
+}
+
+func (m *CASMetrics) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.WriteText(w)
+}
+
+// Counter or gauge, partitioned by label values
+type MetricVec struct {
+	name       string
+	metricType string
+	help       string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]float64
+}
+
+func NewMetricVec(name, metricType, help string, labels ...string) *MetricVec {
+	return &MetricVec{
+		name:       name,
+		metricType: metricType,
+		help:       help,
+		labels:     labels,
+		values:     make(map[string]float64),
+	}
+}
+
+func (v *MetricVec) Inc(labelValues ...string) {
+	v.Add(1, labelValues...)
+}
+
+// Decrement a gauge, which will not go below zero
+// (sessions created before a restart may still be logged out of afterwards)
+func (v *MetricVec) Dec(labelValues ...string) {
+	key := labelKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] = math.Max(0, v.values[key]-1)
+}
+
+// Set the value of a gauge
+func (v *MetricVec) Set(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] = value
+}
+
+func (v *MetricVec) Add(delta float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.values[key] += delta
+}
+
+// Get the current value for the given label values
+func (v *MetricVec) Value(labelValues ...string) float64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.values[labelKey(labelValues)]
+}
+
+func (v *MetricVec) writeTo(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeMetricHeader(w, v.name, v.metricType, v.help)
+
+	// Unlabelled metrics are always reported, even before they are first updated
+	if len(v.labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", v.name, formatMetricValue(v.values[""]))
+		return
+	}
+
+	for _, key := range sortedKeys(v.values) {
+		fmt.Fprintf(w, "%s%s %s\n", v.name, formatLabels(v.labels, splitLabelKey(key), "", ""), formatMetricValue(v.values[key]))
+	}
+}
+
+// Histogram, partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // Per-bucket (non-cumulative) counts
+	count  uint64
+	sum    float64
+}
+
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogram),
+	}
+}
+
+func (v *HistogramVec) Observe(value float64, labelValues ...string) {
+	key := labelKey(labelValues)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	h, ok := v.series[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(v.buckets))}
+		v.series[key] = h
+	}
+
+	for i, upperBound := range v.buckets {
+		if value <= upperBound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Get the number of observations for the given label values
+func (v *HistogramVec) Count(labelValues ...string) uint64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if h, ok := v.series[labelKey(labelValues)]; ok {
+		return h.count
+	}
+	return 0
+}
+
+func (v *HistogramVec) writeTo(w io.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	writeMetricHeader(w, v.name, "histogram", v.help)
+
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h, labelValues := v.series[key], splitLabelKey(key)
+
+		var cumulative uint64
+		for i, upperBound := range v.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, labelValues, "le", formatMetricValue(upperBound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, labelValues, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labels, labelValues, "", ""), formatMetricValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labels, labelValues, "", ""), h.count)
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, metricType, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+// Label values are joined with a separator that cannot appear in valid UTF-8 text
+const labelKeySeparator = "\xff"
+
+func labelKey(labelValues []string) string {
+	return strings.Join(labelValues, labelKeySeparator)
+}
+
+func splitLabelKey(key string) []string {
+	return strings.Split(key, labelKeySeparator)
+}
+
+func sortedKeys(values map[string]float64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Format labels as {name="value",...}, optionally with an extra label (used for histogram buckets)
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	pairs := []string{}
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, name+"=\""+escapeLabelValue(value)+"\"")
+	}
+	if len(extraName) > 0 {
+		pairs = append(pairs, extraName+"=\""+extraValue+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, "\n", `\n`, -1)
+	return strings.Replace(value, `"`, `\"`, -1)
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
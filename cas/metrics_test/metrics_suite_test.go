@@ -0,0 +1,12 @@
+package metrics_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Metrics Suite")
+}
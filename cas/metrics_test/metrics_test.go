@@ -0,0 +1,97 @@
+package metrics_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+// Scrape the metrics endpoint, returning the response body
+func scrape(metrics *CASMetrics) (string, *httptest.ResponseRecorder) {
+	req, _ := http.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	metrics.ServeHTTP(w, req)
+	return w.Body.String(), w
+}
+
+var _ = Describe("CASMetrics", func() {
+
+	It("Should serve metrics in the Prometheus text format", func() {
+		metrics := NewCASMetrics()
+		_, w := scrape(metrics)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+	})
+
+	It("Should report HELP and TYPE lines for all metrics", func() {
+		body, _ := scrape(NewCASMetrics())
+		Expect(body).To(ContainSubstring("# TYPE casgo_login_attempts_total counter\n"))
+		Expect(body).To(ContainSubstring("# TYPE casgo_tickets_issued_total counter\n"))
+		Expect(body).To(ContainSubstring("# TYPE casgo_ticket_validations_total counter\n"))
+		Expect(body).To(ContainSubstring("# TYPE casgo_active_sessions gauge\n"))
+		Expect(body).To(ContainSubstring("# TYPE casgo_request_duration_seconds histogram\n"))
+		Expect(body).To(ContainSubstring("casgo_active_sessions 0\n"))
+	})
+
+	It("Should report labelled counters", func() {
+		metrics := NewCASMetrics()
+		metrics.LoginAttempts.Inc("success")
+		metrics.LoginAttempts.Inc("failure")
+		metrics.LoginAttempts.Inc("failure")
+		metrics.ObserveValidation("/serviceValidate", true)
+		metrics.ObserveValidation("/serviceValidate", false)
+		metrics.TicketsIssued.Inc("service")
+
+		body, _ := scrape(metrics)
+		Expect(body).To(ContainSubstring(`casgo_login_attempts_total{result="failure"} 2` + "\n"))
+		Expect(body).To(ContainSubstring(`casgo_login_attempts_total{result="success"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`casgo_ticket_validations_total{endpoint="/serviceValidate",result="valid"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`casgo_ticket_validations_total{endpoint="/serviceValidate",result="invalid"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`casgo_tickets_issued_total{type="service"} 1` + "\n"))
+	})
+
+	It("Should not let the active sessions gauge go negative", func() {
+		metrics := NewCASMetrics()
+		metrics.ActiveSessions.Inc()
+		metrics.ActiveSessions.Dec()
+		metrics.ActiveSessions.Dec()
+		Expect(metrics.ActiveSessions.Value()).To(Equal(0.0))
+	})
+
+	It("Should report cumulative histogram buckets per route", func() {
+		histogram := NewHistogramVec("test_duration_seconds", "Test durations", []float64{0.1, 1}, "route")
+		histogram.Observe(0.05, "/login")
+		histogram.Observe(0.5, "/login")
+		histogram.Observe(5, "/login")
+		Expect(histogram.Count("/login")).To(Equal(uint64(3)))
+
+		metrics := NewCASMetrics()
+		metrics.RequestDuration = histogram
+		body, _ := scrape(metrics)
+		Expect(body).To(ContainSubstring(`test_duration_seconds_bucket{route="/login",le="0.1"} 1` + "\n"))
+		Expect(body).To(ContainSubstring(`test_duration_seconds_bucket{route="/login",le="1"} 2` + "\n"))
+		Expect(body).To(ContainSubstring(`test_duration_seconds_bucket{route="/login",le="+Inf"} 3` + "\n"))
+		Expect(body).To(ContainSubstring(`test_duration_seconds_sum{route="/login"} 5.55` + "\n"))
+		Expect(body).To(ContainSubstring(`test_duration_seconds_count{route="/login"} 3` + "\n"))
+	})
+
+	It("Should observe request latency", func() {
+		metrics := NewCASMetrics()
+		metrics.ObserveRequest("/validate", time.Now().Add(-time.Second))
+		Expect(metrics.RequestDuration.Count("/validate")).To(Equal(uint64(1)))
+	})
+
+	It("Should escape label values", func() {
+		counter := NewMetricVec("test_total", "counter", "Test counter", "path")
+		counter.Inc("a\"b\\c\nd")
+
+		metrics := NewCASMetrics()
+		metrics.TicketsIssued = counter
+		body, _ := scrape(metrics)
+		Expect(body).To(ContainSubstring(`test_total{path="a\"b\\c\nd"} 1` + "\n"))
+	})
+
+})
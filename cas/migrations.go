@@ -0,0 +1,126 @@
+package cas
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+/*
+ * Storage schema migrations, applied at startup (dbAutoMigrate) or with the migrate command
+ */
+
+// A named change to a backend's storage (ex. creating a table or an index)
+// Migrations must be idempotent, as storage set up before migrations were recorded gets them applied again
+type Migration struct {
+	Version int // Unique, migrations are applied in increasing version order
+	Name    string
+	Apply   func() *CASServerError
+}
+
+// Outcome of RunMigrations
+type MigrationResult struct {
+	FromVersion   int         // Schema version before migrations were applied
+	Version       int         // Schema version the storage is at
+	LatestVersion int         // Version of the backend's latest migration
+	Applied       []Migration // Migrations that were applied, in order
+}
+
+// Apply the migrations of a backend that are newer than its schema version, recording each once it was applied
+// Backends that aren't MigratableBackends have nothing to migrate
+// Fails with SchemaVersionTooNewError (applying nothing) if the storage was migrated by a newer version of casgo, and
+// stops at the first migration that fails (the ones applied before it stay recorded)
+func RunMigrations(db Backend, clock Clock, logger Logger) (MigrationResult, *CASServerError) {
+	result := MigrationResult{}
+	migratable, ok := db.(MigratableBackend)
+	if !ok {
+		return result, nil
+	}
+
+	migrations := migratable.Migrations()
+	if len(migrations) > 0 {
+		result.LatestVersion = migrations[len(migrations)-1].Version
+	}
+
+	version, casErr := migratable.SchemaVersion()
+	if casErr != nil {
+		return result, casErr
+	}
+	result.FromVersion, result.Version = version, version
+
+	if version > result.LatestVersion {
+		logger.Error("Database schema is newer than the latest known migration", "schemaVersion", version, "latestVersion", result.LatestVersion)
+		return result, &SchemaVersionTooNewError
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		logger.Info("Applying database migration", "version", migration.Version, "migration", migration.Name)
+		if casErr := migration.Apply(); casErr != nil {
+			logger.Error("Failed to apply database migration", "version", migration.Version, "migration", migration.Name, "error", casErr)
+			return result, &FailedToMigrateDatabaseError
+		}
+		if casErr := migratable.RecordMigration(migration, clock.Now()); casErr != nil {
+			logger.Error("Failed to record database migration", "version", migration.Version, "migration", migration.Name, "error", casErr)
+			return result, &FailedToMigrateDatabaseError
+		}
+
+		result.Version = migration.Version
+		result.Applied = append(result.Applied, migration)
+	}
+
+	return result, nil
+}
+
+// Bring the storage backend's schema up to date
+func (c *CAS) Migrate() (MigrationResult, *CASServerError) {
+	return RunMigrations(c.Db, c.Clock, c.Logger)
+}
+
+// Run the migrate command with the given arguments (those following the command's name), writing its output to out
+// Migrations aren't applied at startup by the server the command creates, so that the ones it applies are reported
+// Returns the command's exit code (2 if the arguments are invalid)
+func RunMigrateCommand(args []string, out io.Writer) int {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	flags.SetOutput(out)
+	configFlags := NewConfigFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	config, err := configFlags.Config()
+	if err != nil {
+		fmt.Fprintf(out, "Failed to create new CAS server configuration, err: %v\n", err)
+		return 1
+	}
+	config["dbAutoMigrate"] = "false"
+	casServer, err := NewCASServer(config)
+	if err != nil {
+		fmt.Fprintf(out, "Failed to create new CAS Server instance, err: %v\n", err)
+		return 1
+	}
+	defer casServer.Shutdown(context.Background())
+
+	result, casErr := casServer.Migrate()
+	for _, migration := range result.Applied {
+		fmt.Fprintf(out, "Applied migration %d (%s)\n", migration.Version, migration.Name)
+	}
+	if casErr != nil {
+		fmt.Fprintf(out, "Failed to migrate database: %s\n", casErr.Msg)
+		if casErr.Code == SchemaVersionTooNewError.Code {
+			fmt.Fprintf(out, "Database is at schema version %d, the latest this version of casgo knows of is %d\n", result.Version, result.LatestVersion)
+		}
+		return 1
+	}
+
+	if len(result.Applied) == 0 {
+		fmt.Fprintf(out, "Database is up to date (schema version %d)\n", result.Version)
+		return 0
+	}
+	fmt.Fprintf(out, "Migrated database from schema version %d to %d\n", result.FromVersion, result.Version)
+	return 0
+}
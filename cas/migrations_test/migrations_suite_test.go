@@ -0,0 +1,12 @@
+package migrations_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoMigrations(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Migrations Suite")
+}
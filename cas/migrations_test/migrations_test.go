@@ -0,0 +1,242 @@
+package migrations_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var applyTime = time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+
+type fixedClock struct{}
+
+func (fixedClock) Now() time.Time { return applyTime }
+
+// Memory backend whose migrations create (pretend) tables, only if they don't exist yet
+type migratingBackend struct {
+	*MemoryBackend
+	tables  map[string]int // Times each table was created
+	applied []string       // Names of the migrations applied, in order
+	records map[int]time.Time
+	failing string // Name of a migration that fails
+}
+
+func newMigratingBackend() *migratingBackend {
+	return &migratingBackend{
+		MemoryBackend: NewMemoryBackend(time.Minute),
+		tables:        map[string]int{},
+		records:       map[int]time.Time{},
+	}
+}
+
+func (b *migratingBackend) createTable(migration, table string) func() *CASServerError {
+	return func() *CASServerError {
+		if migration == b.failing {
+			return &FailedToCreateTableError
+		}
+		b.applied = append(b.applied, migration)
+		if _, exists := b.tables[table]; !exists {
+			b.tables[table]++
+		}
+		return nil
+	}
+}
+
+func (b *migratingBackend) Migrations() []Migration {
+	return []Migration{
+		{Version: 1, Name: "create_widgets", Apply: b.createTable("create_widgets", "widgets")},
+		{Version: 2, Name: "create_gadgets", Apply: b.createTable("create_gadgets", "gadgets")},
+	}
+}
+
+func (b *migratingBackend) SchemaVersion() (int, *CASServerError) {
+	version := 0
+	for recorded := range b.records {
+		if recorded > version {
+			version = recorded
+		}
+	}
+	return version, nil
+}
+
+func (b *migratingBackend) RecordMigration(migration Migration, appliedAt time.Time) *CASServerError {
+	b.records[migration.Version] = appliedAt
+	return nil
+}
+
+var _ = Describe("Schema migrations", func() {
+	var backend *migratingBackend
+
+	BeforeEach(func() {
+		backend = newMigratingBackend()
+	})
+
+	AfterEach(func() {
+		backend.Close()
+	})
+
+	migrate := func() (MigrationResult, *CASServerError) {
+		return RunMigrations(backend, fixedClock{}, NoopLogger{})
+	}
+
+	It("Should apply all migrations to empty storage, in order", func() {
+		result, casErr := migrate()
+		Expect(casErr).To(BeNil())
+		Expect(result.FromVersion).To(Equal(0))
+		Expect(result.Version).To(Equal(2))
+		Expect(result.LatestVersion).To(Equal(2))
+		Expect(result.Applied).To(HaveLen(2))
+		Expect(backend.applied).To(Equal([]string{"create_widgets", "create_gadgets"}))
+		Expect(backend.tables).To(Equal(map[string]int{"widgets": 1, "gadgets": 1}))
+		Expect(backend.records).To(Equal(map[int]time.Time{1: applyTime, 2: applyTime}))
+	})
+
+	It("Should do nothing when re-run", func() {
+		_, casErr := migrate()
+		Expect(casErr).To(BeNil())
+
+		result, casErr := migrate()
+		Expect(casErr).To(BeNil())
+		Expect(result.Applied).To(BeEmpty())
+		Expect(result.FromVersion).To(Equal(2))
+		Expect(result.Version).To(Equal(2))
+		Expect(backend.applied).To(HaveLen(2))
+	})
+
+	It("Should only apply the migrations newer than the schema version", func() {
+		backend.records[1] = applyTime.Add(-time.Hour)
+		result, casErr := migrate()
+		Expect(casErr).To(BeNil())
+		Expect(result.FromVersion).To(Equal(1))
+		Expect(backend.applied).To(Equal([]string{"create_gadgets"}))
+		Expect(backend.records[1]).To(Equal(applyTime.Add(-time.Hour)))
+	})
+
+	It("Should refuse storage migrated by a newer version of casgo", func() {
+		backend.records[3] = applyTime
+		result, casErr := migrate()
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.Code).To(Equal(SchemaVersionTooNewError.Code))
+		Expect(result.Version).To(Equal(3))
+		Expect(result.LatestVersion).To(Equal(2))
+		Expect(backend.applied).To(BeEmpty())
+	})
+
+	It("Should stop at the first migration that fails, keeping the ones applied before it", func() {
+		backend.failing = "create_gadgets"
+		result, casErr := migrate()
+		Expect(casErr).ToNot(BeNil())
+		Expect(casErr.Code).To(Equal(FailedToMigrateDatabaseError.Code))
+		Expect(result.Version).To(Equal(1))
+		Expect(backend.records).To(HaveLen(1))
+
+		backend.failing = ""
+		result, casErr = migrate()
+		Expect(casErr).To(BeNil())
+		Expect(backend.applied).To(Equal([]string{"create_widgets", "create_gadgets"}))
+	})
+
+	It("Should leave backends without migrations alone", func() {
+		memory := NewMemoryBackend(time.Minute)
+		defer memory.Close()
+		result, casErr := RunMigrations(memory, fixedClock{}, NoopLogger{})
+		Expect(casErr).To(BeNil())
+		Expect(result).To(Equal(MigrationResult{}))
+	})
+
+	Describe("At startup", func() {
+		BeforeEach(func() {
+			RegisterBackend("migrations-test", func(c *CAS) (Backend, error) {
+				return backend, nil
+			})
+		})
+
+		newServer := func(overrides map[string]string) (*CAS, error) {
+			config := castest.NewTestConfig(map[string]string{"dbBackend": "migrations-test"})
+			for key, value := range overrides {
+				config[key] = value
+			}
+			server, err := NewCASServerWithLogger(config, NoopLogger{})
+			if server != nil {
+				server.AuditLog.Stop()
+			}
+			return server, err
+		}
+
+		It("Should apply pending migrations unless disabled", func() {
+			_, err := newServer(map[string]string{"dbAutoMigrate": "false"})
+			Expect(err).To(BeNil())
+			Expect(backend.applied).To(BeEmpty())
+
+			_, err = newServer(nil)
+			Expect(err).To(BeNil())
+			Expect(backend.applied).To(Equal([]string{"create_widgets", "create_gadgets"}))
+		})
+
+		It("Should refuse to start with storage migrated by a newer version of casgo", func() {
+			backend.records[3] = applyTime
+			_, err := newServer(nil)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(SchemaVersionTooNewError.Msg))
+		})
+	})
+
+	Describe("migrate command", func() {
+		var (
+			tempDir    string
+			configFile string
+			output     *bytes.Buffer
+		)
+
+		BeforeEach(func() {
+			RegisterBackend("migrations-test", func(c *CAS) (Backend, error) {
+				return backend, nil
+			})
+
+			var err error
+			tempDir, err = ioutil.TempDir("", "casgo-migrate")
+			Expect(err).To(BeNil())
+			configFile = filepath.Join(tempDir, "config.json")
+			config, _ := json.Marshal(map[string]string{
+				"dbBackend":          "migrations-test",
+				"templatesDirectory": "../templates",
+				"logLevel":           "error",
+			})
+			Expect(ioutil.WriteFile(configFile, config, 0600)).To(Succeed())
+			output = &bytes.Buffer{}
+		})
+
+		AfterEach(func() {
+			os.RemoveAll(tempDir)
+		})
+
+		run := func() int {
+			return RunMigrateCommand([]string{"--config", configFile}, output)
+		}
+
+		It("Should report the migrations it applies", func() {
+			Expect(run()).To(Equal(0), output.String())
+			Expect(output.String()).To(ContainSubstring("Applied migration 1 (create_widgets)\nApplied migration 2 (create_gadgets)\n"))
+			Expect(output.String()).To(ContainSubstring("Migrated database from schema version 0 to 2"))
+
+			output.Reset()
+			Expect(run()).To(Equal(0), output.String())
+			Expect(output.String()).To(Equal("Database is up to date (schema version 2)\n"))
+		})
+
+		It("Should fail on storage migrated by a newer version of casgo", func() {
+			backend.records[3] = applyTime
+			Expect(run()).To(Equal(1))
+			Expect(output.String()).To(ContainSubstring(SchemaVersionTooNewError.Msg))
+			Expect(output.String()).To(ContainSubstring("schema version 3, the latest this version of casgo knows of is 2"))
+			Expect(backend.applied).To(BeEmpty())
+		})
+	})
+})
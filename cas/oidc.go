@@ -0,0 +1,482 @@
+package cas
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * OpenID Connect bridge, letting OIDC relying parties log in with casgo (authorization code flow)
+ *
+ * Registered services are the relying parties: their name is the client ID and their URL matches the redirect URIs
+ * they may use. Clients are public (they have no secret), codes are bound to the client and redirect URI (and, with
+ * PKCE, to a code verifier) and can only be redeemed once, like service tickets are validated by URL alone.
+ */
+
+// Paths of the OIDC endpoints (the discovery document's path is fixed by the specification)
+const (
+	OIDC_DISCOVERY_PATH = "/.well-known/openid-configuration"
+	OIDC_AUTHORIZE_PATH = "/oidc/authorize"
+	OIDC_TOKEN_PATH     = "/oidc/token"
+	OIDC_JWKS_PATH      = "/oidc/jwks"
+)
+
+// Size of the signing key generated when no oidcSigningKeyFile is configured
+const OIDC_GENERATED_KEY_BITS = 2048
+
+// Claims of ID tokens that released attributes can't override
+var OIDC_RESERVED_CLAIMS = []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "azp", "scope"}
+
+// Issues OIDC authorization codes and signed (RS256) tokens
+type OIDCProvider struct {
+	Issuer   string          // URL casgo is reached at, which endpoint URLs are built from
+	Key      *rsa.PrivateKey // Key tokens are signed with
+	KeyId    string          // Identifies the key in the JWKS (the "kid" of token headers)
+	TokenTTL time.Duration   // Lifetime of ID (and access) tokens
+	CodeTTL  time.Duration   // Time clients have to redeem an authorization code
+
+	// Codes issued while serving with a backend that can't keep them (see OIDCCodeBackend), only redeemable on this node
+	mu    sync.Mutex
+	codes map[string]OIDCAuthorizationCode
+}
+
+// Create the OIDC provider specified by server configuration
+// (oidcEnabled, oidcIssuer, oidcSigningKeyFile, oidcTokenTTL & oidcCodeTTL)
+// Returns nil if the bridge is disabled, and generates a signing key (which lasts until the server stops) if none is configured
+func NewOIDCProviderFromConfig(config map[string]string, addr string, logger Logger) (*OIDCProvider, error) {
+	enabled, err := configBool(config, "oidcEnabled")
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	issuer := strings.TrimSuffix(strings.TrimSpace(config["oidcIssuer"]), "/")
+	if len(issuer) == 0 {
+		issuer = "https://" + addr
+	}
+	if parsed, err := url.Parse(issuer); err != nil || parsed.Scheme != "https" || len(parsed.Host) == 0 || len(parsed.RawQuery) > 0 {
+		return nil, fmt.Errorf("Invalid oidcIssuer [%s], expected an https URL without a query", issuer)
+	}
+
+	var key *rsa.PrivateKey
+	if path := strings.TrimSpace(config["oidcSigningKeyFile"]); len(path) > 0 {
+		if key, err = loadRSAPrivateKey("oidcSigningKeyFile", path); err != nil {
+			return nil, err
+		}
+	} else {
+		loggerOrDefault(logger).Warn("No oidcSigningKeyFile configured, tokens are signed with a generated key (they can't be verified once the server restarts)")
+		if key, err = rsa.GenerateKey(rand.Reader, OIDC_GENERATED_KEY_BITS); err != nil {
+			return nil, err
+		}
+	}
+
+	keyId, err := rsaKeyId(&key.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCProvider{
+		Issuer:   issuer,
+		Key:      key,
+		KeyId:    keyId,
+		TokenTTL: configSecondsAsDuration(config, "oidcTokenTTL"),
+		CodeTTL:  configSecondsAsDuration(config, "oidcCodeTTL"),
+		codes:    map[string]OIDCAuthorizationCode{},
+	}, nil
+}
+
+// Read an RSA private key from a PEM file (PKCS #1 or PKCS #8), configured with the given key
+func loadRSAPrivateKey(configKey, path string) (*rsa.PrivateKey, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s [%s], %v", configKey, path, err)
+	}
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, fmt.Errorf("Invalid %s [%s], expected a PEM encoded RSA private key", configKey, path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if key, ok := parsed.(*rsa.PrivateKey); err == nil && ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("Invalid %s [%s], expected a PEM encoded RSA private key", configKey, path)
+}
+
+// Key ID of a public key (a digest of it, so it only changes with the key)
+func rsaKeyId(key *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(der)
+	return base64.RawURLEncoding.EncodeToString(digest[:12]), nil
+}
+
+// URL of one of the provider's endpoints
+func (p *OIDCProvider) endpoint(path string) string {
+	return p.Issuer + path
+}
+
+// Sign claims as a JWT (RS256)
+func (p *OIDCProvider) SignToken(claims map[string]interface{}) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": p.KeyId})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// Grant an authorization, returning the code it can be redeemed with (until CodeTTL has passed)
+// Codes are stored in the backend when it can keep them, so they can be redeemed on any node
+func (p *OIDCProvider) issueCode(db Backend, authorization OIDCAuthorizationCode, now time.Time) (string, error) {
+	code, err := newTicketId("OC")
+	if err != nil {
+		return "", err
+	}
+	authorization.Code = code
+	authorization.ExpiresAt = now.Add(p.CodeTTL)
+
+	if codeDb, ok := db.(OIDCCodeBackend); ok {
+		if casErr := codeDb.AddOIDCAuthorizationCode(&authorization); casErr != nil {
+			return "", casErr
+		}
+		return code, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for existing, granted := range p.codes {
+		if granted.IsExpired(now) {
+			delete(p.codes, existing)
+		}
+	}
+	p.codes[code] = authorization
+	return code, nil
+}
+
+// Redeem an authorization code, which can't be redeemed again (whether or not it has expired)
+// Returns nil if the code is unknown, expired or was already redeemed
+func (p *OIDCProvider) redeemCode(db Backend, code string, now time.Time) (*OIDCAuthorizationCode, *CASServerError) {
+	var authorization *OIDCAuthorizationCode
+	if codeDb, ok := db.(OIDCCodeBackend); ok {
+		var casErr *CASServerError
+		if authorization, casErr = codeDb.ConsumeOIDCAuthorizationCode(code); casErr != nil {
+			return nil, casErr
+		}
+	} else {
+		p.mu.Lock()
+		if granted, ok := p.codes[code]; ok {
+			authorization = &granted
+		}
+		delete(p.codes, code)
+		p.mu.Unlock()
+	}
+
+	if authorization == nil || authorization.IsExpired(now) {
+		return nil, nil
+	}
+	return authorization, nil
+}
+
+// Whether a code verifier matches the challenge an authorization was requested with (PKCE, RFC 7636)
+func oidcVerifierMatches(authorization *OIDCAuthorizationCode, verifier string) bool {
+	if len(authorization.CodeChallenge) == 0 {
+		return true
+	}
+	if authorization.CodeChallengeMethod == "S256" {
+		digest := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(digest[:])
+	}
+	return len(verifier) > 0 && SecretsEqual(verifier, authorization.CodeChallenge)
+}
+
+// Find the registered service acting as a client, which the redirect URI must belong to
+func (c *CAS) oidcClient(db Backend, clientId, redirectUri string) (*CASService, *CASServerError) {
+	if len(clientId) == 0 || len(redirectUri) == 0 {
+		return nil, &InvalidOIDCClientError
+	}
+	if casErr := c.checkServiceUrlAllowed(redirectUri); casErr != nil {
+		return nil, casErr
+	}
+	service, casErr := c.findServiceForUrl(db, redirectUri)
+	if casErr != nil || service.Name != clientId {
+		return nil, &InvalidOIDCClientError
+	}
+	return service, nil
+}
+
+// Service the login page sends users back to when they log in for the authorization endpoint (nil for other URLs)
+// Users are sent back to the authorization endpoint without a ticket, it issues codes from their session
+func (c *CAS) oidcLoginService(serviceUrl string) *CASService {
+	if c.OIDC == nil {
+		return nil
+	}
+	authorizeUrl := c.OIDC.endpoint(OIDC_AUTHORIZE_PATH)
+	if serviceUrl != authorizeUrl && !strings.HasPrefix(serviceUrl, authorizeUrl+"?") {
+		return nil
+	}
+	return &CASService{Name: "OpenID Connect", Url: serviceUrl, oidcLogin: true}
+}
+
+// Build a URL with parameters added to its query
+func urlWithParams(base string, params url.Values) string {
+	if strings.Contains(base, "?") {
+		return base + "&" + params.Encode()
+	}
+	return base + "?" + params.Encode()
+}
+
+// Discovery document of the provider (OpenID Connect Discovery 1.0)
+func (c *CAS) HandleOIDCDiscovery(w http.ResponseWriter, req *http.Request) {
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                c.OIDC.Issuer,
+		"authorization_endpoint":                c.OIDC.endpoint(OIDC_AUTHORIZE_PATH),
+		"token_endpoint":                        c.OIDC.endpoint(OIDC_TOKEN_PATH),
+		"jwks_uri":                              c.OIDC.endpoint(OIDC_JWKS_PATH),
+		"response_types_supported":              []string{"code"},
+		"response_modes_supported":              []string{"query"},
+		"grant_types_supported":                 []string{"authorization_code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      []string{"openid"},
+		"token_endpoint_auth_methods_supported": []string{"none"},
+		"code_challenge_methods_supported":      []string{"S256", "plain"},
+		"claims_supported":                      []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "email", "name"},
+	})
+}
+
+// Public key tokens are signed with, as a JSON Web Key Set
+func (c *CAS) HandleOIDCJWKS(w http.ResponseWriter, req *http.Request) {
+	key := c.OIDC.Key.PublicKey
+	c.render.JSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": c.OIDC.KeyId,
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	})
+}
+
+// Authorization endpoint: users logged in to casgo are sent back to the client with a code, others are asked to log in
+// Requests from unknown clients (or for redirect URIs they don't own) are refused without redirecting
+func (c *CAS) HandleOIDCAuthorize(w http.ResponseWriter, req *http.Request) {
+	clientId := strings.TrimSpace(req.FormValue("client_id"))
+	redirectUri := strings.TrimSpace(req.FormValue("redirect_uri"))
+	logger := c.requestLogger(req).With("route", OIDC_AUTHORIZE_PATH, "clientId", clientId, "redirectUri", redirectUri)
+
+	if _, casErr := c.oidcClient(c.backendFor(req), clientId, redirectUri); casErr != nil {
+		logger.Warn("Authorization refused, unknown client or redirect URI", "error", casErr)
+		context := c.templateContext(w, req)
+		context["Error"] = c.localizeError(context, casErr)
+		c.render.HTML(w, http.StatusBadRequest, "login", context)
+		return
+	}
+
+	// Other errors are reported to the client
+	state := req.FormValue("state")
+	redirectError := func(code, description string) {
+		params := url.Values{"error": {code}, "error_description": {description}}
+		if len(state) > 0 {
+			params.Set("state", state)
+		}
+		http.Redirect(w, req, urlWithParams(redirectUri, params), http.StatusFound)
+	}
+
+	scope := strings.TrimSpace(req.FormValue("scope"))
+	challenge := req.FormValue("code_challenge")
+	challengeMethod := req.FormValue("code_challenge_method")
+	if len(challenge) > 0 && len(challengeMethod) == 0 {
+		challengeMethod = "plain"
+	}
+	switch {
+	case req.FormValue("response_type") != "code":
+		redirectError("unsupported_response_type", "Only the code response type is supported")
+		return
+	case !containsString(strings.Fields(scope), "openid", false):
+		redirectError("invalid_scope", "The openid scope is required")
+		return
+	case len(challenge) > 0 && challengeMethod != "S256" && challengeMethod != "plain":
+		redirectError("invalid_request", "Unsupported code_challenge_method")
+		return
+	}
+
+	session, _ := c.sessionStore.Get(req, "casgo-session")
+	user, loggedIn := c.sessionUser(c.backendFor(req), session)
+	prompt := strings.Fields(req.FormValue("prompt"))
+	if !loggedIn || containsString(prompt, "login", false) {
+		if containsString(prompt, "none", false) {
+			redirectError("login_required", "The user is not logged in")
+			return
+		}
+
+		// Once logged in, users come back without the prompt (so they aren't asked to log in again)
+		params := req.URL.Query()
+		params.Del("prompt")
+		login := url.Values{"service": {urlWithParams(c.OIDC.endpoint(OIDC_AUTHORIZE_PATH), params)}}
+		if loggedIn {
+			login.Set("renew", "true")
+		}
+		http.Redirect(w, req, c.URLPath("/login?"+login.Encode()), http.StatusFound)
+		return
+	}
+
+	authTime, _ := session.Values["createdAt"].(time.Time)
+	code, err := c.OIDC.issueCode(c.backendFor(req), OIDCAuthorizationCode{
+		ClientId:            clientId,
+		RedirectUri:         redirectUri,
+		UserEmail:           user.Email,
+		Scope:               scope,
+		Nonce:               req.FormValue("nonce"),
+		CodeChallenge:       challenge,
+		CodeChallengeMethod: challengeMethod,
+		AuthTime:            authTime,
+	}, c.now())
+	if err != nil {
+		logger.Error("Failed to issue authorization code", "error", err)
+		redirectError("server_error", "Failed to issue an authorization code")
+		return
+	}
+
+	c.Metrics.TicketsIssued.Inc("oidc_code")
+	c.audit(req, withAuditDetails(NewAuditEvent(AUDIT_TICKET_ISSUED, user.Email, redirectUri, nil), "ticketType", "oidc_code", "clientId", clientId))
+	logger.Info("Issued authorization code", "username", user.Email)
+
+	params := url.Values{"code": {code}}
+	if len(state) > 0 {
+		params.Set("state", state)
+	}
+	http.Redirect(w, req, urlWithParams(redirectUri, params), http.StatusFound)
+}
+
+// Render an error response of the token endpoint (RFC 6749, section 5.2)
+func (c *CAS) renderOIDCTokenError(w http.ResponseWriter, code, description string) {
+	c.render.JSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+// Token endpoint: exchange an authorization code for an ID token (carrying the attributes released to the client)
+// and an access token (which the client's own APIs can verify against the JWKS)
+func (c *CAS) HandleOIDCToken(w http.ResponseWriter, req *http.Request) {
+	defer c.Metrics.ObserveRequest(OIDC_TOKEN_PATH, time.Now())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+
+	if grantType := req.PostFormValue("grant_type"); grantType != "authorization_code" {
+		c.renderOIDCTokenError(w, "unsupported_grant_type", "Only the authorization_code grant type is supported")
+		return
+	}
+
+	clientId := strings.TrimSpace(req.PostFormValue("client_id"))
+	redirectUri := strings.TrimSpace(req.PostFormValue("redirect_uri"))
+	logger := c.requestLogger(req).With("route", OIDC_TOKEN_PATH, "clientId", clientId)
+	db := c.backendFor(req)
+	now := c.now()
+
+	user, service, authorization, description := c.redeemOIDCCode(db, req.PostFormValue("code"), clientId, redirectUri, req.PostFormValue("code_verifier"), now)
+	c.Metrics.ObserveValidation(OIDC_TOKEN_PATH, user != nil)
+	if user == nil {
+		logger.Warn("Authorization code refused", "reason", description)
+		c.audit(req, withAuditDetails(NewAuditEvent(AUDIT_TICKET_VALIDATED, "", redirectUri, &FailedToFindTicketError), "endpoint", OIDC_TOKEN_PATH, "ticketType", "oidc_code"))
+		c.renderOIDCTokenError(w, "invalid_grant", description)
+		return
+	}
+	c.audit(req, withAuditDetails(NewAuditEvent(AUDIT_TICKET_VALIDATED, user.Email, redirectUri, nil), "endpoint", OIDC_TOKEN_PATH, "ticketType", "oidc_code"))
+
+	idClaims := map[string]interface{}{}
+	for name, value := range service.AttributeReleasePolicy.Apply(userAttributesForRelease(user), true) {
+		if !containsString(OIDC_RESERVED_CLAIMS, name, false) {
+			idClaims[name] = value
+		}
+	}
+	for name, value := range map[string]interface{}{"iss": c.OIDC.Issuer, "sub": user.Email, "aud": clientId, "iat": now.Unix(), "exp": now.Add(c.OIDC.TokenTTL).Unix()} {
+		idClaims[name] = value
+	}
+	if !authorization.AuthTime.IsZero() {
+		idClaims["auth_time"] = authorization.AuthTime.Unix()
+	}
+	if len(authorization.Nonce) > 0 {
+		idClaims["nonce"] = authorization.Nonce
+	}
+
+	idToken, err := c.OIDC.SignToken(idClaims)
+	if err == nil {
+		var accessToken string
+		accessToken, err = c.OIDC.SignToken(map[string]interface{}{
+			"iss":   c.OIDC.Issuer,
+			"sub":   user.Email,
+			"aud":   clientId,
+			"scope": authorization.Scope,
+			"iat":   now.Unix(),
+			"exp":   now.Add(c.OIDC.TokenTTL).Unix(),
+		})
+		if err == nil {
+			logger.Info("Issued ID token", "username", user.Email)
+			c.render.JSON(w, http.StatusOK, map[string]interface{}{
+				"access_token": accessToken,
+				"token_type":   "Bearer",
+				"expires_in":   int(c.OIDC.TokenTTL.Seconds()),
+				"id_token":     idToken,
+				"scope":        authorization.Scope,
+			})
+			return
+		}
+	}
+
+	logger.Error("Failed to sign tokens", "error", err)
+	c.render.JSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error", "error_description": "Failed to sign tokens"})
+}
+
+// Redeem an authorization code for the client it was issued to, returning the user (with their current attributes)
+// and the service acting as the client, or (if the code can't be redeemed) a description of why
+func (c *CAS) redeemOIDCCode(db Backend, code, clientId, redirectUri, verifier string, now time.Time) (*User, *CASService, *OIDCAuthorizationCode, string) {
+	authorization, casErr := c.OIDC.redeemCode(db, code, now)
+	switch {
+	case casErr != nil:
+		return nil, nil, nil, casErr.Msg
+	case authorization == nil:
+		return nil, nil, nil, "Unknown, expired or already redeemed code"
+	case authorization.ClientId != clientId || authorization.RedirectUri != redirectUri:
+		return nil, nil, authorization, "The code was issued to another client or redirect URI"
+	case !oidcVerifierMatches(authorization, verifier):
+		return nil, nil, authorization, "Invalid code_verifier"
+	}
+
+	service, casErr := c.oidcClient(db, clientId, redirectUri)
+	if casErr != nil {
+		return nil, nil, authorization, casErr.Msg
+	}
+	user, casErr := db.FindUserByEmail(authorization.UserEmail)
+	if casErr != nil {
+		return nil, nil, authorization, "The user no longer exists"
+	}
+	return user, service, authorization, ""
+}
@@ -0,0 +1,12 @@
+package oidc_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoOIDC(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo OIDC Suite")
+}
@@ -0,0 +1,281 @@
+package oidc_test
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+const (
+	issuer      = "https://cas.example.com"
+	clientId    = "oidc_app"
+	redirectUri = "https://app.example.com/callback"
+)
+
+// Decode a part of a JWT
+func decodePart(part string, v interface{}) {
+	decoded, err := base64.RawURLEncoding.DecodeString(part)
+	Expect(err).To(BeNil())
+	Expect(json.Unmarshal(decoded, v)).To(Succeed())
+}
+
+var _ = Describe("OpenID Connect", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	newServer := func(overrides map[string]string) {
+		config := map[string]string{"oidcEnabled": "true", "oidcIssuer": issuer}
+		for key, value := range overrides {
+			config[key] = value
+		}
+		server, db = castest.NewTestServer(config)
+		client = castest.NewClient(server)
+		client.Header.Set("Accept-Language", "en")
+		Expect(db.AddNewService(&CASService{Name: clientId, Url: redirectUri, AdminEmail: "admin@test.com"})).To(BeNil())
+
+		user, _ := db.FindUserByEmail("test@test.com")
+		user.Name = "Test User"
+		Expect(db.UpdateUser(user)).To(BeNil())
+	}
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == "POST" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		return client.Do(req)
+	}
+
+	getJSON := func(path string) map[string]interface{} {
+		w := do("GET", path, "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var document map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &document)).To(Succeed())
+		return document
+	}
+
+	authorizePath := func(params url.Values) string {
+		query := url.Values{"response_type": {"code"}, "client_id": {clientId}, "redirect_uri": {redirectUri}, "scope": {"openid email"}, "state": {"xyz"}}
+		for name, values := range params {
+			query[name] = values
+		}
+		return OIDC_AUTHORIZE_PATH + "?" + query.Encode()
+	}
+
+	// Follow the authorization flow through the login page, returning the parameters the client is redirected with
+	authorize := func(params url.Values) url.Values {
+		w := do("GET", authorizePath(params), "")
+		Expect(w.Code).To(Equal(http.StatusFound))
+		location, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).To(BeNil())
+
+		if location.Path == "/login" {
+			service := location.Query().Get("service")
+			Expect(service).To(HavePrefix(issuer + OIDC_AUTHORIZE_PATH + "?"))
+			Expect(do("GET", w.Header().Get("Location"), "").Code).To(Equal(http.StatusOK))
+
+			w = client.Login(url.Values{"serviceUrl": {service}})
+			Expect(w.Code).To(Equal(http.StatusFound))
+			Expect(w.Header().Get("Location")).To(Equal(service))
+
+			back, err := url.Parse(service)
+			Expect(err).To(BeNil())
+			w = do("GET", back.RequestURI(), "")
+			Expect(w.Code).To(Equal(http.StatusFound))
+			location, err = url.Parse(w.Header().Get("Location"))
+			Expect(err).To(BeNil())
+		}
+
+		Expect(location.Scheme + "://" + location.Host + location.Path).To(Equal(redirectUri))
+		return location.Query()
+	}
+
+	exchange := func(form url.Values) (int, map[string]interface{}) {
+		w := do("POST", OIDC_TOKEN_PATH, form.Encode())
+		Expect(w.Header().Get("Cache-Control")).To(Equal("no-store"))
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		return w.Code, response
+	}
+
+	tokenForm := func(code string) url.Values {
+		return url.Values{"grant_type": {"authorization_code"}, "code": {code}, "client_id": {clientId}, "redirect_uri": {redirectUri}}
+	}
+
+	BeforeEach(func() {
+		newServer(nil)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should publish a discovery document and the signing key", func() {
+		document := getJSON(OIDC_DISCOVERY_PATH)
+		Expect(document["issuer"]).To(Equal(issuer))
+		Expect(document["authorization_endpoint"]).To(Equal(issuer + OIDC_AUTHORIZE_PATH))
+		Expect(document["token_endpoint"]).To(Equal(issuer + OIDC_TOKEN_PATH))
+		Expect(document["jwks_uri"]).To(Equal(issuer + OIDC_JWKS_PATH))
+		Expect(document["response_types_supported"]).To(Equal([]interface{}{"code"}))
+		Expect(document["subject_types_supported"]).To(Equal([]interface{}{"public"}))
+		Expect(document["id_token_signing_alg_values_supported"]).To(Equal([]interface{}{"RS256"}))
+		Expect(document["scopes_supported"]).To(ContainElement("openid"))
+		Expect(document["code_challenge_methods_supported"]).To(ContainElement("S256"))
+
+		keys := getJSON(OIDC_JWKS_PATH)["keys"].([]interface{})
+		Expect(keys).To(HaveLen(1))
+		key := keys[0].(map[string]interface{})
+		Expect(key["kty"]).To(Equal("RSA"))
+		Expect(key["alg"]).To(Equal("RS256"))
+		Expect(key["kid"]).To(Equal(server.OIDC.KeyId))
+		Expect(key["n"]).NotTo(BeEmpty())
+		Expect(key["e"]).To(Equal("AQAB"))
+	})
+
+	It("Should exchange an authorization code for an ID token signed with the published key", func() {
+		params := authorize(url.Values{"nonce": {"n-0S6"}})
+		Expect(params.Get("state")).To(Equal("xyz"))
+		code := params.Get("code")
+		Expect(code).NotTo(BeEmpty())
+
+		status, response := exchange(tokenForm(code))
+		Expect(status).To(Equal(http.StatusOK))
+		Expect(response["token_type"]).To(Equal("Bearer"))
+		Expect(response["access_token"]).NotTo(BeEmpty())
+		idToken := response["id_token"].(string)
+
+		// The signature checks out against the JWKS
+		jwk := getJSON(OIDC_JWKS_PATH)["keys"].([]interface{})[0].(map[string]interface{})
+		n, err := base64.RawURLEncoding.DecodeString(jwk["n"].(string))
+		Expect(err).To(BeNil())
+		e, err := base64.RawURLEncoding.DecodeString(jwk["e"].(string))
+		Expect(err).To(BeNil())
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+		parts := strings.Split(idToken, ".")
+		Expect(parts).To(HaveLen(3))
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		Expect(err).To(BeNil())
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		Expect(rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature)).To(Succeed())
+
+		var header map[string]string
+		decodePart(parts[0], &header)
+		Expect(header["alg"]).To(Equal("RS256"))
+		Expect(header["kid"]).To(Equal(jwk["kid"]))
+
+		var claims map[string]interface{}
+		decodePart(parts[1], &claims)
+		Expect(claims["iss"]).To(Equal(issuer))
+		Expect(claims["sub"]).To(Equal("test@test.com"))
+		Expect(claims["aud"]).To(Equal(clientId))
+		Expect(claims["nonce"]).To(Equal("n-0S6"))
+		Expect(claims["email"]).To(Equal("test@test.com"))
+		Expect(claims["name"]).To(Equal("Test User"))
+		Expect(claims["exp"].(float64) - claims["iat"].(float64)).To(Equal(float64(300)))
+		Expect(claims).To(HaveKey("auth_time"))
+
+		// Users already logged in get a code straight away, and codes can only be redeemed once
+		second := do("GET", authorizePath(nil), "")
+		Expect(second.Code).To(Equal(http.StatusFound))
+		Expect(second.Header().Get("Location")).To(HavePrefix(redirectUri + "?code="))
+
+		status, response = exchange(tokenForm(code))
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(response["error"]).To(Equal("invalid_grant"))
+	})
+
+	It("Should bind codes to their client, redirect URI and PKCE challenge", func() {
+		verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		digest := sha256.Sum256([]byte(verifier))
+		challenge := base64.RawURLEncoding.EncodeToString(digest[:])
+		Expect(db.AddNewService(&CASService{Name: "other_app", Url: "https://other.example.com/callback", AdminEmail: "admin@test.com"})).To(BeNil())
+
+		code := authorize(url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}}).Get("code")
+		form := tokenForm(code)
+		form.Set("code_verifier", "not the verifier")
+		status, response := exchange(form)
+		Expect(status).To(Equal(http.StatusBadRequest))
+		Expect(response["error"]).To(Equal("invalid_grant"))
+
+		code = authorize(url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}}).Get("code")
+		form = tokenForm(code)
+		form.Set("code_verifier", verifier)
+		form.Set("client_id", "other_app")
+		form.Set("redirect_uri", "https://other.example.com/callback")
+		status, response = exchange(form)
+		Expect(response["error"]).To(Equal("invalid_grant"))
+
+		code = authorize(url.Values{"code_challenge": {challenge}, "code_challenge_method": {"S256"}}).Get("code")
+		form = tokenForm(code)
+		form.Set("code_verifier", verifier)
+		status, _ = exchange(form)
+		Expect(status).To(Equal(http.StatusOK))
+
+		form.Set("grant_type", "password")
+		status, response = exchange(form)
+		Expect(response["error"]).To(Equal("unsupported_grant_type"))
+	})
+
+	It("Should refuse unknown clients and unregistered redirect URIs without redirecting", func() {
+		for _, params := range []url.Values{
+			{"client_id": {"unknown_app"}},
+			{"redirect_uri": {"https://evil.example.com/callback"}},
+			{"client_id": {""}},
+		} {
+			w := do("GET", authorizePath(params), "")
+			Expect(w.Code).To(Equal(http.StatusBadRequest))
+			Expect(w.Header()).NotTo(HaveKey("Location"))
+			Expect(w.Body.String()).To(ContainSubstring(InvalidOIDCClientError.Msg))
+		}
+	})
+
+	It("Should report invalid requests to the client", func() {
+		for params, expected := range map[string]string{
+			"response_type=token": "unsupported_response_type",
+			"scope=email":         "invalid_scope",
+			"code_challenge=abc&code_challenge_method=S512": "invalid_request",
+			"prompt=none": "login_required",
+		} {
+			values, err := url.ParseQuery(params)
+			Expect(err).To(BeNil())
+			w := do("GET", authorizePath(values), "")
+			Expect(w.Code).To(Equal(http.StatusFound))
+			location, err := url.Parse(w.Header().Get("Location"))
+			Expect(err).To(BeNil())
+			Expect(location.Query().Get("error")).To(Equal(expected))
+			Expect(location.Query().Get("state")).To(Equal("xyz"))
+		}
+	})
+
+	It("Should not serve the OIDC endpoints unless enabled", func() {
+		castest.Close(server)
+		newServer(map[string]string{"oidcEnabled": "false"})
+		Expect(server.OIDC).To(BeNil())
+		for _, path := range []string{OIDC_DISCOVERY_PATH, OIDC_JWKS_PATH, authorizePath(nil)} {
+			Expect(do("GET", path, "").Code).To(Equal(http.StatusNotFound))
+		}
+	})
+
+	It("Should refuse invalid issuers at startup", func() {
+		for _, value := range []string{"http://cas.example.com", "https://cas.example.com?x=1", "cas.example.com"} {
+			_, err := NewCASServerWithLogger(castest.NewTestConfig(map[string]string{"oidcEnabled": "true", "oidcIssuer": value}), NoopLogger{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("oidcIssuer"))
+		}
+	})
+})
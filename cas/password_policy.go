@@ -0,0 +1,177 @@
+package cas
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+ * Password policy, enforced when passwords are set (on registration, user creation and password changes)
+ */
+
+// Rules of the password policy (character classes double as the rules requiring them)
+const (
+	PASSWORD_RULE_MIN_LENGTH = "minLength"
+	PASSWORD_RULE_LOWERCASE  = "lowercase"
+	PASSWORD_RULE_UPPERCASE  = "uppercase"
+	PASSWORD_RULE_LETTER     = "letter"
+	PASSWORD_RULE_DIGIT      = "digit"
+	PASSWORD_RULE_SYMBOL     = "symbol" // Anything but letters, digits and spaces
+	PASSWORD_RULE_DENYLIST   = "denylist"
+)
+
+// Character classes passwords may be required to contain, and whether a character belongs to each
+var PASSWORD_CHARACTER_CLASSES = map[string]func(rune) bool{
+	PASSWORD_RULE_LOWERCASE: unicode.IsLower,
+	PASSWORD_RULE_UPPERCASE: unicode.IsUpper,
+	PASSWORD_RULE_LETTER:    unicode.IsLetter,
+	PASSWORD_RULE_DIGIT:     unicode.IsDigit,
+	PASSWORD_RULE_SYMBOL: func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r)
+	},
+}
+
+// Commonly used passwords, refused unless passwordDenylistCommon is disabled
+var COMMON_PASSWORDS = []string{
+	"123456", "123456789", "12345678", "1234567890", "12345", "1234567", "111111", "000000", "123123", "654321",
+	"password", "password1", "password12", "password123", "passw0rd", "p@ssw0rd", "qwerty", "qwerty123", "qwertyuiop",
+	"1q2w3e4r", "1qaz2wsx", "abc123", "abcd1234", "iloveyou", "letmein", "welcome", "welcome1", "monkey", "dragon",
+	"sunshine", "princess", "football", "baseball", "superman", "trustno1", "master", "shadow", "admin", "admin123",
+	"administrator", "changeme", "secret", "login", "starwars", "whatever", "zaq12wsx", "asdfghjkl", "test1234",
+}
+
+// Rule a password failed, with a message explaining it (in English, see CAS.passwordRuleMessage for translations)
+type PasswordRuleFailure struct {
+	Rule    string        `json:"rule"`
+	Message string        `json:"message"`
+	args    []interface{} // Arguments of the message (ex. the minimum length)
+}
+
+// Message key of the rule's translations
+func (f PasswordRuleFailure) MsgKey() string {
+	return "passwordPolicy." + f.Rule
+}
+
+type PasswordPolicy struct {
+	MinLength       int             // Characters required (not bytes)
+	RequiredClasses []string        // Character classes passwords must contain (see PASSWORD_CHARACTER_CLASSES)
+	Denylist        map[string]bool // Refused passwords (lowercase, passwords are compared case-insensitively)
+}
+
+// Create the password policy specified by server configuration
+// (passwordMinLength, passwordCharClasses, passwordDenylistCommon & passwordDenylistFile)
+func NewPasswordPolicyFromConfig(config map[string]string) (*PasswordPolicy, error) {
+	minLength, err := configInt(config, "passwordMinLength")
+	if err != nil {
+		return nil, err
+	}
+	if minLength < 1 {
+		return nil, fmt.Errorf("Invalid passwordMinLength [%d], must be at least 1", minLength)
+	}
+
+	policy := &PasswordPolicy{MinLength: minLength, Denylist: map[string]bool{}}
+	for _, class := range splitConfigList(config["passwordCharClasses"]) {
+		class = strings.ToLower(class)
+		if _, ok := PASSWORD_CHARACTER_CLASSES[class]; !ok {
+			return nil, fmt.Errorf("Invalid passwordCharClasses [%s], expected classes among lowercase, uppercase, letter, digit, symbol", config["passwordCharClasses"])
+		}
+		if !containsString(policy.RequiredClasses, class, false) {
+			policy.RequiredClasses = append(policy.RequiredClasses, class)
+		}
+	}
+
+	denyCommon, err := configBool(config, "passwordDenylistCommon")
+	if err != nil {
+		return nil, err
+	}
+	if denyCommon {
+		for _, password := range COMMON_PASSWORDS {
+			policy.Denylist[password] = true
+		}
+	}
+
+	if path := strings.TrimSpace(config["passwordDenylistFile"]); len(path) > 0 {
+		if err := policy.loadDenylistFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// Add the passwords listed in a file (one per line, blank lines and lines starting with # are ignored) to the denylist
+func (p *PasswordPolicy) loadDenylistFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read passwordDenylistFile [%s], %v", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) > 0 && !strings.HasPrefix(line, "#") {
+			p.Denylist[strings.ToLower(line)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Failed to read passwordDenylistFile [%s], %v", path, err)
+	}
+	return nil
+}
+
+// Rules a password fails (none if it complies with the policy)
+func (p *PasswordPolicy) Check(password string) []PasswordRuleFailure {
+	failures := []PasswordRuleFailure{}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		failures = append(failures, PasswordRuleFailure{
+			Rule:    PASSWORD_RULE_MIN_LENGTH,
+			Message: fmt.Sprintf("Must be at least %d characters long", p.MinLength),
+			args:    []interface{}{p.MinLength},
+		})
+	}
+
+	for _, class := range p.RequiredClasses {
+		if strings.IndexFunc(password, PASSWORD_CHARACTER_CLASSES[class]) < 0 {
+			failures = append(failures, PasswordRuleFailure{Rule: class, Message: "Must contain a " + passwordClassDescriptions[class]})
+		}
+	}
+
+	if p.Denylist[strings.ToLower(password)] {
+		failures = append(failures, PasswordRuleFailure{Rule: PASSWORD_RULE_DENYLIST, Message: "Must not be a commonly used password"})
+	}
+	return failures
+}
+
+var passwordClassDescriptions = map[string]string{
+	PASSWORD_RULE_LOWERCASE: "lowercase letter",
+	PASSWORD_RULE_UPPERCASE: "uppercase letter",
+	PASSWORD_RULE_LETTER:    "letter",
+	PASSWORD_RULE_DIGIT:     "digit",
+	PASSWORD_RULE_SYMBOL:    "symbol (a character other than a letter, digit or space)",
+}
+
+// Message of a failed password rule in a locale, which is its English message when it has no translation
+func (c *CAS) passwordRuleMessage(locale string, failure PasswordRuleFailure) string {
+	if format, ok := c.Translator.lookup(locale, failure.MsgKey()); ok {
+		if len(failure.args) == 0 {
+			return format
+		}
+		return fmt.Sprintf(format, failure.args...)
+	}
+	return failure.Message
+}
+
+// Error and explanation of why a password was refused, in the locale of the page being rendered with context
+func (c *CAS) localizePasswordFailures(context map[string]interface{}, failures []PasswordRuleFailure) string {
+	locale, _ := context["Locale"].(string)
+	messages := make([]string, len(failures))
+	for i, failure := range failures {
+		messages[i] = c.passwordRuleMessage(locale, failure)
+	}
+	return c.localizeError(context, &WeakPasswordError) + ": " + strings.Join(messages, "; ")
+}
@@ -0,0 +1,12 @@
+package passwordpolicy_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoPasswordPolicy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Password Policy Suite")
+}
@@ -0,0 +1,230 @@
+package passwordpolicy_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Rules in a list of failures
+func rules(failures []PasswordRuleFailure) []string {
+	names := []string{}
+	for _, failure := range failures {
+		names = append(names, failure.Rule)
+	}
+	return names
+}
+
+var _ = Describe("PasswordPolicy", func() {
+	newPolicy := func(overrides map[string]string) (*PasswordPolicy, error) {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		for key, value := range overrides {
+			config[key] = value
+		}
+		return NewPasswordPolicyFromConfig(config)
+	}
+
+	It("Should refuse passwords that are too short", func() {
+		policy, err := newPolicy(nil)
+		Expect(err).To(BeNil())
+
+		failures := policy.Check("abc12")
+		Expect(rules(failures)).To(Equal([]string{PASSWORD_RULE_MIN_LENGTH}))
+		Expect(failures[0].Message).To(ContainSubstring("8 characters"))
+
+		// Length is counted in characters, not bytes
+		Expect(policy.Check("éééééé12")).To(BeEmpty())
+	})
+
+	It("Should refuse passwords missing a required character class", func() {
+		policy, err := newPolicy(nil)
+		Expect(err).To(BeNil())
+		Expect(rules(policy.Check("correcthorse"))).To(Equal([]string{PASSWORD_RULE_DIGIT}))
+		Expect(rules(policy.Check("31415926535"))).To(Equal([]string{PASSWORD_RULE_LETTER}))
+
+		policy, err = newPolicy(map[string]string{"passwordCharClasses": "lowercase, uppercase, digit, symbol"})
+		Expect(err).To(BeNil())
+		Expect(rules(policy.Check("correcthorse"))).To(Equal([]string{PASSWORD_RULE_UPPERCASE, PASSWORD_RULE_DIGIT, PASSWORD_RULE_SYMBOL}))
+		Expect(policy.Check("Correct-horse-42")).To(BeEmpty())
+	})
+
+	It("Should refuse denylisted passwords, regardless of case", func() {
+		policy, err := newPolicy(nil)
+		Expect(err).To(BeNil())
+		Expect(rules(policy.Check("Password123"))).To(Equal([]string{PASSWORD_RULE_DENYLIST}))
+
+		// Every rule failed is listed
+		Expect(rules(policy.Check("secret"))).To(Equal([]string{PASSWORD_RULE_MIN_LENGTH, PASSWORD_RULE_DIGIT, PASSWORD_RULE_DENYLIST}))
+
+		tempDir, err := ioutil.TempDir("", "casgo-password-policy")
+		Expect(err).To(BeNil())
+		defer os.RemoveAll(tempDir)
+		denylistFile := filepath.Join(tempDir, "denylist.txt")
+		Expect(ioutil.WriteFile(denylistFile, []byte("# Company passwords\nCasgoRocks2024\n\n"), 0600)).To(Succeed())
+
+		policy, err = newPolicy(map[string]string{"passwordDenylistCommon": "false", "passwordDenylistFile": denylistFile})
+		Expect(err).To(BeNil())
+		Expect(rules(policy.Check("casgorocks2024"))).To(Equal([]string{PASSWORD_RULE_DENYLIST}))
+		Expect(policy.Check("password123")).To(BeEmpty())
+	})
+
+	It("Should accept compliant passwords", func() {
+		policy, err := newPolicy(nil)
+		Expect(err).To(BeNil())
+		Expect(policy.Check("correct horse battery 9")).To(BeEmpty())
+
+		policy, err = newPolicy(map[string]string{"passwordMinLength": "4", "passwordCharClasses": "", "passwordDenylistCommon": "false"})
+		Expect(err).To(BeNil())
+		Expect(policy.Check("abcd")).To(BeEmpty())
+	})
+
+	It("Should refuse invalid policies", func() {
+		for key, value := range map[string]string{
+			"passwordMinLength":      "0",
+			"passwordCharClasses":    "letter,emoji",
+			"passwordDenylistCommon": "maybe",
+			"passwordDenylistFile":   "/nonexistent/denylist.txt",
+		} {
+			_, err := newPolicy(map[string]string{key: value})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(key))
+		}
+	})
+})
+
+var _ = Describe("Password policy enforcement", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	admin := map[string]string{"X-Api-Key": "adminapikey", "X-Api-Secret": "badsecret"}
+
+	do := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if !strings.HasPrefix(path, "/api/") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Accept-Language", "en")
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	// Rules listed in an API error response
+	failedRules := func(w *httptest.ResponseRecorder) []string {
+		var response struct {
+			Code        string                `json:"code"`
+			FailedRules []PasswordRuleFailure `json:"failedRules"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response.Code).To(Equal(WeakPasswordError.Code))
+		return rules(response.FailedRules)
+	}
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+		config["csrfEnabled"] = "false"
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("services", "../../fixtures/services.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.AuditLog.Stop()
+		db.Close()
+	})
+
+	It("Should refuse to create users with weak passwords through the API, listing the rules failed", func() {
+		w := do("POST", "/api/users", `{"email":"new@test.com","password":"short"}`, admin)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(failedRules(w)).To(Equal([]string{PASSWORD_RULE_MIN_LENGTH, PASSWORD_RULE_DIGIT}))
+		_, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).NotTo(BeNil())
+
+		w = do("POST", "/api/users", `{"email":"new@test.com","password":"correct horse 9"}`, admin)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		user, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).To(BeNil())
+		Expect(VerifyPassword(user.Password, "correct horse 9")).To(BeTrue())
+	})
+
+	It("Should enforce the policy on password changes, keeping the password when it is not changed", func() {
+		existing, _ := db.FindUserByEmail("test@test.com")
+
+		w := do("PUT", "/api/users/test@test.com", `{"email":"test@test.com","password":"password123"}`, admin)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(failedRules(w)).To(Equal([]string{PASSWORD_RULE_DENYLIST}))
+
+		// Leaving the password out (or passing back the stored hash) keeps it
+		Expect(do("PUT", "/api/users/test@test.com", `{"email":"test@test.com","name":"Test"}`, admin).Code).To(Equal(http.StatusOK))
+		user, _ := db.FindUserByEmail("test@test.com")
+		Expect(user.Password).To(Equal(existing.Password))
+		Expect(user.Name).To(Equal("Test"))
+
+		body, _ := json.Marshal(map[string]string{"email": "test@test.com", "password": existing.Password})
+		Expect(do("PUT", "/api/users/test@test.com", string(body), admin).Code).To(Equal(http.StatusOK))
+		user, _ = db.FindUserByEmail("test@test.com")
+		Expect(user.Password).To(Equal(existing.Password))
+
+		// New passwords are hashed before being stored
+		Expect(do("PUT", "/api/users/test@test.com", `{"email":"test@test.com","password":"new password 2"}`, admin).Code).To(Equal(http.StatusOK))
+		user, _ = db.FindUserByEmail("test@test.com")
+		Expect(user.Password).NotTo(Equal("new password 2"))
+		Expect(VerifyPassword(user.Password, "new password 2")).To(BeTrue())
+	})
+
+	It("Should refuse registrations with weak passwords, explaining why", func() {
+		w := do("POST", "/register", url.Values{"email": {"new@test.com"}, "password": {"letmein"}}.Encode(), nil)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+		Expect(w.Body.String()).To(ContainSubstring(WeakPasswordError.Msg))
+		Expect(w.Body.String()).To(ContainSubstring("Must be at least 8 characters long"))
+		Expect(w.Body.String()).To(ContainSubstring("Must not be a commonly used password"))
+		_, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).NotTo(BeNil())
+
+		w = do("POST", "/register", url.Values{"email": {"new@test.com"}, "password": {"letmein"}}.Encode(), map[string]string{"Accept-Language": "fr"})
+		Expect(w.Body.String()).To(ContainSubstring("Doit comporter au moins 8 caractères"))
+
+		w = do("POST", "/register", url.Values{"email": {"new@test.com"}, "password": {"let me in 2day"}}.Encode(), nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		_, casErr = db.FindUserByEmail("new@test.com")
+		Expect(casErr).To(BeNil())
+	})
+
+	It("Should log users in with their passwords as they were set, keeping their case", func() {
+		login := func(email, password string) *httptest.ResponseRecorder {
+			return do("POST", "/login", url.Values{"email": {email}, "password": {password}, "serviceUrl": {"localhost:3000/validateCASLogin"}}.Encode(), nil)
+		}
+
+		Expect(do("POST", "/register", url.Values{"email": {"new@test.com"}, "password": {" Let Me In 2Day "}}.Encode(), nil).Code).To(Equal(http.StatusOK))
+		w := login("New@Test.com", "Let Me In 2Day")
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+		Expect(login("new@test.com", "let me in 2day").Code).To(Equal(InvalidCredentialsError.HttpCode))
+
+		// Passwords set through the API are checked the same way
+		Expect(do("PUT", "/api/users/test@test.com", `{"email":"test@test.com","password":"New Password 2"}`, admin).Code).To(Equal(http.StatusOK))
+		Expect(login("test@test.com", "New Password 2").Header().Get("Location")).To(ContainSubstring("ticket="))
+		Expect(login("test@test.com", "new password 2").Code).To(Equal(InvalidCredentialsError.HttpCode))
+	})
+})
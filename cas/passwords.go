@@ -0,0 +1,113 @@
+package cas
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/golang.org/x/crypto/bcrypt"
+	"strings"
+)
+
+/*
+ * Password hashing
+ */
+
+// Prefix of salted SHA-256 password hashes ("{SHA256}<hex salt>$<hex digest>")
+const SHA256_PASSWORD_PREFIX = "{SHA256}"
+
+// Creates password hashes for storage in the local user store
+type PasswordHasher interface {
+	// Hash a password
+	Hash(password string) (string, error)
+	// Whether a stored hash was created by this hasher (with its current settings)
+	// Hashes that are not current are replaced when the user next logs in
+	IsCurrent(hash string) bool
+}
+
+// Hashes passwords with bcrypt
+type BcryptPasswordHasher struct {
+	Cost int
+}
+
+func NewBcryptPasswordHasher(cost int) (*BcryptPasswordHasher, error) {
+	if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("Invalid bcryptCost [%d], must be between %d and %d", cost, bcrypt.MinCost, bcrypt.MaxCost)
+	}
+	return &BcryptPasswordHasher{Cost: cost}, nil
+}
+
+func (h *BcryptPasswordHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptPasswordHasher) IsCurrent(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost == h.Cost
+}
+
+// Hashes passwords with salted SHA-256 (for deployments that cannot use bcrypt)
+type SHA256PasswordHasher struct{}
+
+func (h *SHA256PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return SHA256_PASSWORD_PREFIX + hex.EncodeToString(salt) + "$" + sha256PasswordDigest(salt, password), nil
+}
+
+func (h *SHA256PasswordHasher) IsCurrent(hash string) bool {
+	return strings.HasPrefix(hash, SHA256_PASSWORD_PREFIX)
+}
+
+func sha256PasswordDigest(salt []byte, password string) string {
+	digest := sha256.Sum256(append(append([]byte{}, salt...), password...))
+	return hex.EncodeToString(digest[:])
+}
+
+// Create the password hasher specified by server configuration
+func NewPasswordHasherFromConfig(config map[string]string) (PasswordHasher, error) {
+	switch config["passwordHashAlgorithm"] {
+	case "", "bcrypt":
+		cost, err := configInt(config, "bcryptCost")
+		if err != nil {
+			return nil, err
+		}
+		hasher, err := NewBcryptPasswordHasher(cost)
+		if err != nil {
+			return nil, err
+		}
+		return hasher, nil
+	case "sha256":
+		return &SHA256PasswordHasher{}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported passwordHashAlgorithm [%s]", config["passwordHashAlgorithm"])
+	}
+}
+
+// Check a password against a stored hash, regardless of the algorithm that created it
+// Hashes that are neither bcrypt nor salted SHA-256 are treated as (legacy) plaintext passwords
+func VerifyPassword(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, SHA256_PASSWORD_PREFIX):
+		parts := strings.SplitN(strings.TrimPrefix(hash, SHA256_PASSWORD_PREFIX), "$", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		salt, err := hex.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(sha256PasswordDigest(salt, password)), []byte(parts[1])) == 1
+	default:
+		return len(hash) > 0 && subtle.ConstantTimeCompare([]byte(hash), []byte(password)) == 1
+	}
+}
@@ -0,0 +1,305 @@
+package cas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ * Database connection pooling
+ */
+
+// Errors returned when a connection cannot be acquired from a pool
+var (
+	ErrConnPoolExhausted = errors.New("Timed out waiting for a free database connection")
+	ErrConnPoolClosed    = errors.New("Database connection pool is closed")
+)
+
+// Connection managed by a ConnPool
+type PooledConn interface {
+	// Check that the connection is still usable
+	Ping() error
+	Close() error
+}
+
+// Opens new connections for a ConnPool
+type ConnDialer func() (PooledConn, error)
+
+type ConnPoolOptions struct {
+	MinSize             int           // Idle connections kept open (and re-established by the health check)
+	MaxSize             int           // Maximum number of open connections
+	AcquireTimeout      time.Duration // Maximum time to wait for a connection
+	HealthCheckInterval time.Duration // Interval at which idle connections are pinged (0 disables health checks)
+	MinBackoff          time.Duration // Initial delay between reconnection attempts
+	MaxBackoff          time.Duration // Maximum delay between reconnection attempts
+	Logger              Logger        // Receives reconnection warnings (the default logger is used if nil)
+}
+
+// Build (and validate) connection pool options from server configuration
+func NewConnPoolOptions(config map[string]string) (ConnPoolOptions, error) {
+	opts := ConnPoolOptions{}
+
+	minSize, err := configInt(config, "dbPoolMinSize")
+	if err != nil {
+		return opts, err
+	}
+	maxSize, err := configInt(config, "dbPoolMaxSize")
+	if err != nil {
+		return opts, err
+	}
+	if minSize < 0 || maxSize < 1 || minSize > maxSize {
+		return opts, fmt.Errorf("Invalid database pool size [%d-%d], expected 0 <= dbPoolMinSize <= dbPoolMaxSize and dbPoolMaxSize >= 1", minSize, maxSize)
+	}
+
+	opts.MinSize = minSize
+	opts.MaxSize = maxSize
+	opts.AcquireTimeout = configSecondsAsDuration(config, "dbPoolTimeout")
+	opts.HealthCheckInterval = configSecondsAsDuration(config, "dbHealthCheckInterval")
+	opts.MinBackoff = 100 * time.Millisecond
+	opts.MaxBackoff = configSecondsAsDuration(config, "dbReconnectMaxBackoff")
+	return opts, nil
+}
+
+// Bounded pool of connections, with background health checks and reconnection (with exponential backoff)
+type ConnPool struct {
+	dial    ConnDialer
+	opts    ConnPoolOptions
+	slots   chan struct{} // One token per connection that may be open
+	mu      sync.Mutex
+	idle    []PooledConn
+	closed  bool
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func NewConnPool(dial ConnDialer, opts ConnPoolOptions) *ConnPool {
+	if opts.MaxSize < 1 {
+		opts.MaxSize = 1
+	}
+	if opts.MinSize > opts.MaxSize {
+		opts.MinSize = opts.MaxSize
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = 100 * time.Millisecond
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	opts.Logger = loggerOrDefault(opts.Logger)
+
+	pool := &ConnPool{
+		dial:  dial,
+		opts:  opts,
+		slots: make(chan struct{}, opts.MaxSize),
+		stop:  make(chan struct{}),
+	}
+	for i := 0; i < opts.MaxSize; i++ {
+		pool.slots <- struct{}{}
+	}
+
+	pool.fill()
+	if opts.HealthCheckInterval > 0 {
+		pool.stopped.Add(1)
+		go pool.healthCheckLoop()
+	}
+	return pool
+}
+
+// Acquire a connection, waiting (at most AcquireTimeout) for one to become free
+// New connections are dialed (retrying with exponential backoff) if no idle connection is available
+// Connections must be returned with Release
+func (p *ConnPool) Acquire() (PooledConn, error) {
+	return p.AcquireContext(context.Background())
+}
+
+// Acquire a connection as Acquire does, giving up early (with the context's error) once ctx is done
+func (p *ConnPool) AcquireContext(ctx context.Context) (PooledConn, error) {
+	if p.isClosed() {
+		return nil, ErrConnPoolClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(p.opts.AcquireTimeout)
+
+	select {
+	case <-p.slots:
+	case <-time.After(p.opts.AcquireTimeout):
+		return nil, ErrConnPoolExhausted
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.stop:
+		return nil, ErrConnPoolClosed
+	}
+
+	if conn := p.popIdle(); conn != nil {
+		return conn, nil
+	}
+
+	backoff := p.opts.MinBackoff
+	for {
+		conn, err := p.dial()
+		if err == nil {
+			return conn, nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			p.slots <- struct{}{}
+			return nil, err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			p.slots <- struct{}{}
+			return nil, ctx.Err()
+		case <-p.stop:
+			p.slots <- struct{}{}
+			return nil, ErrConnPoolClosed
+		}
+		backoff = p.nextBackoff(backoff)
+	}
+}
+
+// Return a connection to the pool
+// If the connection was used unsuccessfully (failed is set) it is only kept if it still responds to a ping
+func (p *ConnPool) Release(conn PooledConn, failed bool) {
+	if conn == nil {
+		p.slots <- struct{}{}
+		return
+	}
+
+	if failed && conn.Ping() != nil {
+		conn.Close()
+	} else if !p.pushIdle(conn) {
+		conn.Close()
+	}
+	p.slots <- struct{}{}
+}
+
+// Close the pool and all idle connections
+// Connections in use are closed when they are released
+func (p *ConnPool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	close(p.stop)
+	p.mu.Unlock()
+
+	p.stopped.Wait()
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
+
+func (p *ConnPool) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// Number of idle connections currently held by the pool
+func (p *ConnPool) IdleCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+func (p *ConnPool) popIdle() PooledConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	conn := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return conn
+}
+
+func (p *ConnPool) pushIdle(conn PooledConn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.opts.MaxSize {
+		return false
+	}
+	p.idle = append(p.idle, conn)
+	return true
+}
+
+func (p *ConnPool) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > p.opts.MaxBackoff {
+		backoff = p.opts.MaxBackoff
+	}
+	return backoff
+}
+
+// Open connections until MinSize connections are idle, stopping at the first failure
+func (p *ConnPool) fill() error {
+	for p.IdleCount() < p.opts.MinSize {
+		// Only fill using free slots, connections in use count towards the maximum
+		select {
+		case <-p.slots:
+		default:
+			return nil
+		}
+
+		conn, err := p.dial()
+		if err != nil {
+			p.slots <- struct{}{}
+			return err
+		}
+		p.Release(conn, false)
+	}
+	return nil
+}
+
+// Ping idle connections, dropping those that fail
+func (p *ConnPool) checkIdle() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		if conn.Ping() != nil {
+			conn.Close()
+		} else if !p.pushIdle(conn) {
+			conn.Close()
+		}
+	}
+}
+
+// Periodically check idle connections, and re-establish MinSize connections
+// Reconnection attempts back off exponentially while the server is unreachable
+func (p *ConnPool) healthCheckLoop() {
+	defer p.stopped.Done()
+
+	delay := p.opts.HealthCheckInterval
+	backoff := p.opts.MinBackoff
+	for {
+		select {
+		case <-time.After(delay):
+		case <-p.stop:
+			return
+		}
+
+		p.checkIdle()
+		if err := p.fill(); err != nil {
+			p.opts.Logger.Warn("Failed to reconnect to database", "retryIn", backoff, "error", err)
+			delay = backoff
+			backoff = p.nextBackoff(backoff)
+			continue
+		}
+
+		delay = p.opts.HealthCheckInterval
+		backoff = p.opts.MinBackoff
+	}
+}
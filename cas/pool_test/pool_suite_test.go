@@ -0,0 +1,12 @@
+package pool_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoPool(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Pool Suite")
+}
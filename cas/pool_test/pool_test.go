@@ -0,0 +1,326 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"sync"
+	"time"
+)
+
+// Fake database server, which can be taken down and brought back up
+type fakeServer struct {
+	mu     sync.Mutex
+	down   bool
+	dials  int
+	opened []*fakeConn
+}
+
+func (s *fakeServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *fakeServer) isDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.down
+}
+
+func (s *fakeServer) dialCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+func (s *fakeServer) dial() (PooledConn, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.dials++
+	if s.down {
+		return nil, errors.New("connection refused")
+	}
+	conn := &fakeConn{server: s}
+	s.opened = append(s.opened, conn)
+	return conn, nil
+}
+
+type fakeConn struct {
+	server  *fakeServer
+	mu      sync.Mutex
+	dropped bool
+	closed  bool
+}
+
+func (c *fakeConn) drop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dropped = true
+}
+
+func (c *fakeConn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *fakeConn) Ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dropped || c.closed || c.server.isDown() {
+		return errors.New("connection reset")
+	}
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+var _ = Describe("ConnPool", func() {
+	var (
+		server *fakeServer
+		pool   *ConnPool
+		opts   ConnPoolOptions
+	)
+
+	BeforeEach(func() {
+		server = &fakeServer{}
+		opts = ConnPoolOptions{
+			MinSize:        2,
+			MaxSize:        3,
+			AcquireTimeout: 200 * time.Millisecond,
+			MinBackoff:     5 * time.Millisecond,
+			MaxBackoff:     20 * time.Millisecond,
+		}
+	})
+
+	AfterEach(func() {
+		if pool != nil {
+			pool.Close()
+		}
+	})
+
+	It("Should open MinSize connections up front", func() {
+		pool = NewConnPool(server.dial, opts)
+		Expect(pool.IdleCount()).To(Equal(2))
+		Expect(server.dialCount()).To(Equal(2))
+	})
+
+	It("Should reuse released connections", func() {
+		pool = NewConnPool(server.dial, opts)
+		conn, err := pool.Acquire()
+		Expect(err).To(BeNil())
+		pool.Release(conn, false)
+
+		again, err := pool.Acquire()
+		Expect(err).To(BeNil())
+		Expect(again).To(Equal(conn))
+		pool.Release(again, false)
+		Expect(server.dialCount()).To(Equal(2))
+	})
+
+	It("Should fail with ErrConnPoolExhausted once MaxSize connections are in use past the timeout", func() {
+		pool = NewConnPool(server.dial, opts)
+		for i := 0; i < opts.MaxSize; i++ {
+			_, err := pool.Acquire()
+			Expect(err).To(BeNil())
+		}
+
+		start := time.Now()
+		_, err := pool.Acquire()
+		Expect(err).To(Equal(ErrConnPoolExhausted))
+		Expect(time.Since(start)).To(BeNumerically(">=", opts.AcquireTimeout))
+	})
+
+	It("Should stop waiting for a free connection once the context is done", func() {
+		pool = NewConnPool(server.dial, opts)
+		for i := 0; i < opts.MaxSize; i++ {
+			_, err := pool.Acquire()
+			Expect(err).To(BeNil())
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := pool.AcquireContext(ctx)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(time.Since(start)).To(BeNumerically("<", opts.AcquireTimeout))
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = pool.AcquireContext(cancelled)
+		Expect(err).To(Equal(context.Canceled))
+	})
+
+	It("Should stop retrying to dial once the context is done, freeing the connection's slot", func() {
+		opts.MinSize = 0
+		opts.MaxSize = 1
+		opts.AcquireTimeout = time.Second
+		pool = NewConnPool(server.dial, opts)
+		server.setDown(true)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+		defer cancel()
+		_, err := pool.AcquireContext(ctx)
+		Expect(err).To(Equal(context.DeadlineExceeded))
+
+		server.setDown(false)
+		conn, err := pool.Acquire()
+		Expect(err).To(BeNil())
+		pool.Release(conn, false)
+	})
+
+	It("Should hand out a connection as soon as one is released", func() {
+		opts.MaxSize = 1
+		opts.MinSize = 1
+		pool = NewConnPool(server.dial, opts)
+		conn, err := pool.Acquire()
+		Expect(err).To(BeNil())
+
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			pool.Release(conn, false)
+		}()
+
+		again, err := pool.Acquire()
+		Expect(err).To(BeNil())
+		Expect(again).To(Equal(conn))
+	})
+
+	It("Should drop a connection that failed and no longer responds, and reconnect", func() {
+		pool = NewConnPool(server.dial, opts)
+		conn, err := pool.Acquire()
+		Expect(err).To(BeNil())
+
+		// Connection drops while in use
+		conn.(*fakeConn).drop()
+		pool.Release(conn, true)
+		Expect(conn.(*fakeConn).isClosed()).To(BeTrue())
+		Expect(pool.IdleCount()).To(Equal(1))
+
+		// Remaining idle connection is used, then a new one is dialed
+		first, err := pool.Acquire()
+		Expect(err).To(BeNil())
+		second, err := pool.Acquire()
+		Expect(err).To(BeNil())
+		Expect(second).NotTo(Equal(conn))
+		Expect(second.Ping()).To(BeNil())
+		Expect(server.dialCount()).To(Equal(3))
+
+		pool.Release(first, false)
+		pool.Release(second, false)
+	})
+
+	It("Should keep a connection that failed but still responds", func() {
+		pool = NewConnPool(server.dial, opts)
+		conn, err := pool.Acquire()
+		Expect(err).To(BeNil())
+
+		pool.Release(conn, true)
+		Expect(conn.(*fakeConn).isClosed()).To(BeFalse())
+		Expect(pool.IdleCount()).To(Equal(2))
+	})
+
+	It("Should retry dialing with backoff until the server recovers", func() {
+		server.setDown(true)
+		pool = NewConnPool(server.dial, opts)
+		Expect(pool.IdleCount()).To(Equal(0))
+
+		go func() {
+			time.Sleep(30 * time.Millisecond)
+			server.setDown(false)
+		}()
+
+		conn, err := pool.Acquire()
+		Expect(err).To(BeNil())
+		Expect(conn.Ping()).To(BeNil())
+		Expect(server.dialCount()).To(BeNumerically(">", 2))
+		pool.Release(conn, false)
+	})
+
+	It("Should return the dial error if the server does not recover before the timeout", func() {
+		server.setDown(true)
+		pool = NewConnPool(server.dial, opts)
+
+		_, err := pool.Acquire()
+		Expect(err).NotTo(BeNil())
+		Expect(err.Error()).To(Equal("connection refused"))
+
+		// The slot is returned to the pool
+		server.setDown(false)
+		for i := 0; i < opts.MaxSize; i++ {
+			_, err := pool.Acquire()
+			Expect(err).To(BeNil())
+		}
+	})
+
+	It("Should replace dropped idle connections during health checks", func() {
+		opts.HealthCheckInterval = 10 * time.Millisecond
+		pool = NewConnPool(server.dial, opts)
+		Expect(pool.IdleCount()).To(Equal(2))
+
+		// Server goes away, idle connections are dropped
+		server.setDown(true)
+		Eventually(pool.IdleCount).Should(Equal(0))
+		for _, conn := range server.opened[:2] {
+			Expect(conn.isClosed()).To(BeTrue())
+		}
+
+		// Server recovers, connections are re-established
+		server.setDown(false)
+		Eventually(pool.IdleCount).Should(Equal(2))
+		Expect(server.dialCount()).To(BeNumerically(">", 4))
+	})
+
+	It("Should refuse to hand out connections once closed", func() {
+		pool = NewConnPool(server.dial, opts)
+		pool.Close()
+		_, err := pool.Acquire()
+		Expect(err).To(Equal(ErrConnPoolClosed))
+
+		for _, conn := range server.opened {
+			Expect(conn.isClosed()).To(BeTrue())
+		}
+	})
+})
+
+var _ = Describe("NewConnPoolOptions", func() {
+	var config map[string]string
+
+	BeforeEach(func() {
+		config = map[string]string{}
+		for k, v := range CONFIG_DEFAULTS {
+			config[k] = v
+		}
+	})
+
+	It("Should build options from the default configuration", func() {
+		opts, err := NewConnPoolOptions(config)
+		Expect(err).To(BeNil())
+		Expect(opts.MinSize).To(Equal(2))
+		Expect(opts.MaxSize).To(Equal(10))
+		Expect(opts.AcquireTimeout).To(Equal(5 * time.Second))
+		Expect(opts.HealthCheckInterval).To(Equal(10 * time.Second))
+		Expect(opts.MaxBackoff).To(Equal(30 * time.Second))
+	})
+
+	It("Should reject a minimum size larger than the maximum", func() {
+		config["dbPoolMinSize"] = "5"
+		config["dbPoolMaxSize"] = "2"
+		_, err := NewConnPoolOptions(config)
+		Expect(err).NotTo(BeNil())
+	})
+
+	It("Should reject non-numeric sizes", func() {
+		config["dbPoolMaxSize"] = "lots"
+		_, err := NewConnPoolOptions(config)
+		Expect(err).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,12 @@
+package profile_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoProfile(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Profile Suite")
+}
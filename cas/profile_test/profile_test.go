@@ -0,0 +1,119 @@
+package profile_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("/api/me", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	// Requests are made as the (regular) test user, with its API key
+	do := func(method, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, "/api/me", strings.NewReader(body))
+		req.Header.Set("X-Api-Key", "userapikey")
+		req.Header.Set("X-Api-Secret", "badsecret")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	stored := func(email string) *User {
+		user, casErr := db.FindUserByEmail(email)
+		Expect(casErr).To(BeNil())
+		return user
+	}
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+
+		server, err = NewCASServer(config)
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.AuditLog.Stop()
+		db.Close()
+	})
+
+	It("Should return the requesting user's own profile, without their password", func() {
+		w := do("GET", "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var body struct {
+			Data User `json:"data"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		Expect(body.Data.Email).To(Equal("test@test.com"))
+		Expect(body.Data.Password).To(BeEmpty())
+	})
+
+	It("Should let users update their own name and attributes", func() {
+		password := stored("test@test.com").Password
+
+		w := do("PATCH", `{"name": "Tess Tester", "attributes": {"department": "QA"}}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring("Tess Tester"))
+		Expect(w.Body.String()).NotTo(ContainSubstring(password))
+
+		user := stored("test@test.com")
+		Expect(user.Name).To(Equal("Tess Tester"))
+		Expect(user.Attributes).To(Equal(map[string]string{"department": "QA"}))
+		Expect(user.Password).To(Equal(password))
+		Expect(user.IsAdmin).To(BeFalse())
+	})
+
+	It("Should hash new passwords, which must comply with the password policy", func() {
+		w := do("PATCH", `{"password": "short"}`)
+		Expect(w.Code).To(Equal(WeakPasswordError.HttpCode))
+		Expect(w.Body.String()).To(ContainSubstring(WeakPasswordError.Code))
+		Expect(VerifyPassword(stored("test@test.com").Password, "short")).To(BeFalse())
+
+		w = do("PATCH", `{"password": "a much longer passphrase 42"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		user := stored("test@test.com")
+		Expect(user.Password).To(HavePrefix("$2a$"))
+		Expect(VerifyPassword(user.Password, "a much longer passphrase 42")).To(BeTrue())
+	})
+
+	It("Should not let users elevate their role", func() {
+		w := do("PATCH", `{"name": "Sneaky", "isAdmin": true}`)
+		Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+
+		user := stored("test@test.com")
+		Expect(user.IsAdmin).To(BeFalse())
+		Expect(user.Name).NotTo(Equal("Sneaky"))
+
+		// Passing back the current role is allowed
+		Expect(do("PATCH", `{"name": "Honest", "isAdmin": false}`).Code).To(Equal(http.StatusOK))
+	})
+
+	It("Should not let users touch another user", func() {
+		admin := stored("admin@test.com")
+
+		w := do("PATCH", `{"email": "admin@test.com", "name": "Hijacked", "password": "a much longer passphrase 42"}`)
+		Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+		Expect(stored("admin@test.com")).To(Equal(admin))
+		Expect(stored("test@test.com").Name).NotTo(Equal("Hijacked"))
+	})
+
+	It("Should require authentication", func() {
+		req, _ := http.NewRequest("PATCH", "/api/me", strings.NewReader(`{"name": "Anonymous"}`))
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(FailedToAuthenticateUserError.HttpCode))
+	})
+})
@@ -0,0 +1,260 @@
+package cas
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// XML namespace used by CAS protocol responses
+const CAS_XML_NAMESPACE = "http://www.yale.edu/tp/cas"
+
+// CAS protocol failure codes
+const (
+	CAS_INVALID_REQUEST      = "INVALID_REQUEST"
+	CAS_INVALID_TICKET       = "INVALID_TICKET"
+	CAS_INVALID_TICKET_SPEC  = "INVALID_TICKET_SPEC"
+	CAS_INVALID_SERVICE      = "INVALID_SERVICE"
+	CAS_INTERNAL_ERROR       = "INTERNAL_ERROR"
+	CAS_BAD_PGT              = "BAD_PGT"
+	CAS_UNAUTHORIZED_SERVICE = "UNAUTHORIZED_SERVICE"
+)
+
+// Attribute names that can be represented as XML elements (others are not released)
+// Names starting with "xml" (in any case) are reserved by XML, and not released either
+var validXMLAttributeName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9._-]*$`)
+
+// CAS protocol (2.0/3.0) service response
+// Exactly one of the success or failure portions must be set
+type CASServiceResponse struct {
+	XMLName      xml.Name                  `xml:"cas:serviceResponse" json:"-"`
+	XMLNS        string                    `xml:"xmlns:cas,attr" json:"-"`
+	Success      *CASAuthenticationSuccess `xml:"cas:authenticationSuccess,omitempty" json:"authenticationSuccess,omitempty"`
+	Failure      *CASAuthenticationFailure `xml:"cas:authenticationFailure,omitempty" json:"authenticationFailure,omitempty"`
+	ProxySuccess *CASProxySuccess          `xml:"cas:proxySuccess,omitempty" json:"proxySuccess,omitempty"`
+	ProxyFailure *CASAuthenticationFailure `xml:"cas:proxyFailure,omitempty" json:"proxyFailure,omitempty"`
+}
+
+// Successful authentication portion of a CAS service response
+type CASAuthenticationSuccess struct {
+	User                string        `xml:"cas:user" json:"user"`
+	Attributes          CASAttributes `xml:"cas:attributes,omitempty" json:"attributes,omitempty"`
+	ProxyGrantingTicket string        `xml:"cas:proxyGrantingTicket,omitempty" json:"proxyGrantingTicket,omitempty"`
+	Proxies             CASProxies    `xml:"cas:proxies,omitempty" json:"proxies,omitempty"`
+}
+
+// Failed authentication portion of a CAS service response
+type CASAuthenticationFailure struct {
+	Code        string `xml:"code,attr" json:"code"`
+	Description string `xml:",chardata" json:"description"`
+}
+
+// Successful proxy ticket request portion of a CAS service response
+type CASProxySuccess struct {
+	ProxyTicket string `xml:"cas:proxyTicket" json:"proxyTicket"`
+}
+
+// User attributes released in a CAS 3.0 service response
+type CASAttributes map[string]string
+
+// Proxies (most recent first) through which a proxy ticket was obtained
+type CASProxies []string
+
+// Create a new successful CAS service response
+func NewCASSuccessResponse(user string, attributes CASAttributes) *CASServiceResponse {
+	return &CASServiceResponse{
+		XMLNS: CAS_XML_NAMESPACE,
+		Success: &CASAuthenticationSuccess{
+			User:       user,
+			Attributes: attributes,
+		},
+	}
+}
+
+// Create a new failed CAS service response
+func NewCASFailureResponse(code, description string) *CASServiceResponse {
+	return &CASServiceResponse{
+		XMLNS:   CAS_XML_NAMESPACE,
+		Failure: newCASAuthenticationFailure(code, description),
+	}
+}
+
+// Create a new successful CAS proxy response
+func NewCASProxySuccessResponse(proxyTicket string) *CASServiceResponse {
+	return &CASServiceResponse{
+		XMLNS:        CAS_XML_NAMESPACE,
+		ProxySuccess: &CASProxySuccess{ProxyTicket: proxyTicket},
+	}
+}
+
+// Create a new failed CAS proxy response
+func NewCASProxyFailureResponse(code, description string) *CASServiceResponse {
+	return &CASServiceResponse{
+		XMLNS:        CAS_XML_NAMESPACE,
+		ProxyFailure: newCASAuthenticationFailure(code, description),
+	}
+}
+
+// Failure portion of a CAS service response (the code attribute is required, failures without one are internal errors)
+func newCASAuthenticationFailure(code, description string) *CASAuthenticationFailure {
+	if len(code) == 0 {
+		code = CAS_INTERNAL_ERROR
+	}
+	return &CASAuthenticationFailure{Code: code, Description: description}
+}
+
+// Check that a service response contains exactly one success or failure portion, and that failures have a code, as
+// the CAS schema requires (of both XML and JSON responses)
+func (r *CASServiceResponse) check() error {
+	portions := 0
+	for _, set := range []bool{r.Success != nil, r.Failure != nil, r.ProxySuccess != nil, r.ProxyFailure != nil} {
+		if set {
+			portions++
+		}
+	}
+	if portions != 1 {
+		return errors.New("CAS service responses must contain exactly one success or failure")
+	}
+	for _, failure := range []*CASAuthenticationFailure{r.Failure, r.ProxyFailure} {
+		if failure != nil && len(failure.Code) == 0 {
+			return errors.New("CAS service response failures must have a code")
+		}
+	}
+	return nil
+}
+
+// Marshal a service response as a <cas:serviceResponse> root declaring the CAS namespace (even if XMLNS wasn't set),
+// refusing responses that don't pass check
+func (r CASServiceResponse) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if err := r.check(); err != nil {
+		return err
+	}
+
+	// Encoded as a type without this method (but with the same fields), so the encoder doesn't recurse
+	type serviceResponse CASServiceResponse
+	r.XMLNS = CAS_XML_NAMESPACE
+	return e.EncodeElement(serviceResponse(r), xml.StartElement{Name: xml.Name{Local: "cas:serviceResponse"}})
+}
+
+// Marshal a service response as the XML document (with its declaration) sent to services
+func (r *CASServiceResponse) XMLDocument() ([]byte, error) {
+	out, err := xml.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// Marshal a service response as the JSON document sent to services asking for format=JSON, in the CAS 3.0 shape:
+// {"serviceResponse": {"authenticationSuccess": {"user", "attributes", ...}}} (or authenticationFailure, with its
+// code and description), refusing responses that don't pass check
+func (r *CASServiceResponse) JSONDocument() ([]byte, error) {
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(map[string]*CASServiceResponse{"serviceResponse": r})
+}
+
+// Build the attributes that will be released for a given user
+func userAttributesForRelease(user *User) CASAttributes {
+	attributes := CASAttributes{}
+	for k, v := range user.Attributes {
+		attributes[k] = v
+	}
+
+	if len(user.Email) > 0 {
+		attributes["email"] = user.Email
+	}
+	if len(user.Name) > 0 {
+		attributes["name"] = user.Name
+	}
+
+	return attributes
+}
+
+// Filter (and rename) attributes according to an attribute release policy
+// Without a policy, attributes are released unchanged if releaseByDefault is set, and not at all otherwise
+func (p *CASAttributeReleasePolicy) Apply(attributes CASAttributes, releaseByDefault bool) CASAttributes {
+	released := CASAttributes{}
+	if p == nil {
+		if releaseByDefault {
+			for k, v := range attributes {
+				released[k] = v
+			}
+		}
+		return released
+	}
+
+	allowAll := false
+	allowed := map[string]bool{}
+	for _, key := range p.Allowed {
+		allowAll = allowAll || key == "*"
+		allowed[key] = true
+	}
+
+	// Renamed attributes are released after the others, so they take precedence over attributes with the same name
+	for k, v := range attributes {
+		if _, renamed := p.Mapping[k]; !renamed && (allowAll || allowed[k]) {
+			released[k] = v
+		}
+	}
+	for k, v := range attributes {
+		if renamed, ok := p.Mapping[k]; ok && (allowAll || allowed[k]) {
+			released[renamed] = v
+		}
+	}
+
+	return released
+}
+
+// Marshal attributes as <cas:attributes><cas:key>value</cas:key>...</cas:attributes>
+// Keys are sorted so output is stable, values are escaped by the encoder
+func (attrs CASAttributes) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		if validXMLAttributeName.MatchString(k) && !strings.HasPrefix(strings.ToLower(k), "xml") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		elem := xml.StartElement{Name: xml.Name{Local: "cas:" + k}}
+		if err := e.EncodeElement(attrs[k], elem); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+// Marshal proxies as <cas:proxies><cas:proxy>url</cas:proxy>...</cas:proxies>
+func (proxies CASProxies) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if len(proxies) == 0 {
+		return nil
+	}
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	for _, proxy := range proxies {
+		elem := xml.StartElement{Name: xml.Name{Local: "cas:proxy"}}
+		if err := e.EncodeElement(proxy, elem); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
@@ -0,0 +1,227 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+// Known-good CAS service responses (fixtures/protocol/<name>.xml)
+func golden(name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join("../../fixtures/protocol", name+".xml"))
+	Expect(err).To(BeNil())
+	return strings.TrimSuffix(string(contents), "\n")
+}
+
+func document(response *CASServiceResponse) string {
+	out, err := response.XMLDocument()
+	Expect(err).To(BeNil())
+	return string(out)
+}
+
+// Service response as read by a namespace-aware client
+type namespacedResponse struct {
+	XMLName xml.Name `xml:"http://www.yale.edu/tp/cas serviceResponse"`
+	Success *struct {
+		User string `xml:"http://www.yale.edu/tp/cas user"`
+	} `xml:"http://www.yale.edu/tp/cas authenticationSuccess"`
+	Failure *struct {
+		Code string `xml:"code,attr"`
+	} `xml:"http://www.yale.edu/tp/cas authenticationFailure"`
+	ProxyFailure *struct {
+		Code string `xml:"code,attr"`
+	} `xml:"http://www.yale.edu/tp/cas proxyFailure"`
+}
+
+var _ = Describe("CAS protocol XML documents", func() {
+
+	validationFailureCodes := []string{CAS_INVALID_REQUEST, CAS_INVALID_TICKET, CAS_INVALID_SERVICE, CAS_INTERNAL_ERROR}
+	proxyFailureCodes := []string{CAS_INVALID_REQUEST, CAS_BAD_PGT, CAS_UNAUTHORIZED_SERVICE, CAS_INTERNAL_ERROR}
+
+	It("Should match the known-good success response, escaping user content", func() {
+		response := NewCASSuccessResponse(`o'brien&sons@test.com`, CASAttributes{
+			"department": `R&D <"lab">`,
+			"name":       "Test User",
+		})
+		response.Success.ProxyGrantingTicket = "PGTIOU-1234"
+		response.Success.Proxies = CASProxies{"https://proxy.example.com/cb?a=1&b=2"}
+
+		Expect(document(response)).To(Equal(golden("success")))
+	})
+
+	It("Should match the known-good proxy success response", func() {
+		Expect(document(NewCASProxySuccessResponse("PT-1234"))).To(Equal(golden("proxy_success")))
+	})
+
+	It("Should match the known-good failure response for each failure code", func() {
+		for _, code := range validationFailureCodes {
+			response := NewCASFailureResponse(code, `Ticket "ST-1234" was refused`)
+			Expect(document(response)).To(Equal(golden("failure_"+code)), code)
+		}
+		for _, code := range proxyFailureCodes {
+			response := NewCASProxyFailureResponse(code, `Ticket "PGT-1234" was refused`)
+			Expect(document(response)).To(Equal(golden("proxy_failure_"+code)), code)
+		}
+	})
+
+	It("Should be read in the CAS namespace by namespace-aware clients", func() {
+		var success namespacedResponse
+		Expect(xml.Unmarshal([]byte(golden("success")), &success)).To(Succeed())
+		Expect(success.Success).NotTo(BeNil())
+		Expect(success.Success.User).To(Equal(`o'brien&sons@test.com`))
+
+		for _, code := range validationFailureCodes {
+			var failure namespacedResponse
+			Expect(xml.Unmarshal([]byte(golden("failure_"+code)), &failure)).To(Succeed())
+			Expect(failure.Failure).NotTo(BeNil())
+			Expect(failure.Failure.Code).To(Equal(code))
+		}
+		for _, code := range proxyFailureCodes {
+			var failure namespacedResponse
+			Expect(xml.Unmarshal([]byte(golden("proxy_failure_"+code)), &failure)).To(Succeed())
+			Expect(failure.ProxyFailure).NotTo(BeNil())
+			Expect(failure.ProxyFailure.Code).To(Equal(code))
+		}
+	})
+
+	It("Should declare the CAS namespace on responses that weren't made by a constructor", func() {
+		response := &CASServiceResponse{Success: &CASAuthenticationSuccess{User: "test@test.com"}}
+		Expect(document(response)).To(HavePrefix(xml.Header + `<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">`))
+	})
+
+	It("Should give failures without a code the INTERNAL_ERROR code", func() {
+		Expect(document(NewCASFailureResponse("", `Ticket "ST-1234" was refused`))).To(Equal(golden("failure_INTERNAL_ERROR")))
+	})
+
+	It("Should refuse to marshal responses without exactly one success or failure", func() {
+		_, err := (&CASServiceResponse{}).XMLDocument()
+		Expect(err).To(HaveOccurred())
+
+		both := NewCASSuccessResponse("test@test.com", nil)
+		both.Failure = &CASAuthenticationFailure{Code: CAS_INVALID_TICKET}
+		_, err = both.XMLDocument()
+		Expect(err).To(HaveOccurred())
+
+		_, err = (&CASServiceResponse{Failure: &CASAuthenticationFailure{Description: "No code"}}).XMLDocument()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should not release attributes with names reserved by XML", func() {
+		out := document(NewCASSuccessResponse("test@test.com", CASAttributes{"xmlns": "urn:example", "XMLData": "data", "name": "Test User"}))
+		Expect(out).To(ContainSubstring("<cas:name>Test User</cas:name>"))
+		Expect(strings.ToLower(out)).NotTo(ContainSubstring("<cas:xml"))
+	})
+
+	Describe("Validation endpoints", func() {
+		var server *CAS
+
+		BeforeEach(func() {
+			config, err := NewCASServerConfig("")
+			Expect(err).To(BeNil())
+			config["dbBackend"] = "memory"
+			config["templatesDirectory"] = "../templates"
+
+			server, err = NewCASServerWithLogger(config, NoopLogger{})
+			Expect(err).To(BeNil())
+			Expect(server.Db.(*MemoryBackend).LoadJSONFixture("services", "../../fixtures/services.json")).To(BeNil())
+		})
+
+		AfterEach(func() {
+			server.AuditLog.Stop()
+			server.Db.(*MemoryBackend).Close()
+		})
+
+		It("Should respond with the known-good documents", func() {
+			for path, name := range map[string]string{
+				"/serviceValidate":    "validate_invalid_request",
+				"/p3/serviceValidate": "validate_invalid_request",
+				"/proxyValidate?" + url.Values{"service": {"localhost:3000/validateCASLogin"}, "ticket": {"ST-unknown"}}.Encode(): "validate_invalid_ticket",
+				"/proxyValidate?" + url.Values{"service": {"localhost:3000/validateCASLogin"}, "ticket": {"PT-unknown"}}.Encode(): "validate_invalid_proxy_ticket",
+				"/serviceValidate?" + url.Values{"service": {"localhost:9999/unknown"}, "ticket": {"ST-unknown"}}.Encode():        "validate_invalid_service",
+			} {
+				w := httptest.NewRecorder()
+				server.ServeMux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+				Expect(w.Code).To(Equal(http.StatusOK), path)
+				Expect(w.Header().Get("Content-Type")).To(Equal("text/xml; charset=UTF-8"), path)
+				Expect(w.Body.String()).To(Equal(golden(name)), path)
+			}
+		})
+
+		get := func(path string, params url.Values) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			server.ServeMux.ServeHTTP(w, httptest.NewRequest("GET", path+"?"+params.Encode(), nil))
+			Expect(w.Code).To(Equal(http.StatusOK), path)
+			return w
+		}
+
+		// Issue a service ticket, as a login would
+		issueTicket := func(id string) string {
+			service, casErr := server.Db.FindServiceByUrl(testServiceUrl)
+			Expect(casErr).To(BeNil())
+			_, casErr = server.Db.AddTicketForService(&CASTicket{
+				Id:             id,
+				UserEmail:      "test@test.com",
+				UserAttributes: map[string]string{"department": "R&D"},
+				ServiceUrl:     testServiceUrl,
+				ExpiresAt:      time.Now().Add(time.Minute),
+			}, service)
+			Expect(casErr).To(BeNil())
+			return id
+		}
+
+		It("Should respond with the CAS 3.0 JSON shape when asked for format=JSON", func() {
+			w := get("/p3/serviceValidate", url.Values{"service": {testServiceUrl}, "ticket": {issueTicket("ST-1")}, "format": {"JSON"}})
+			Expect(w.Header().Get("Content-Type")).To(Equal("application/json; charset=UTF-8"))
+			Expect(w.Body.String()).To(MatchJSON(`{"serviceResponse": {"authenticationSuccess": {
+				"user": "test@test.com",
+				"attributes": {"department": "R&D", "email": "test@test.com"}
+			}}}`))
+
+			// Successes without released attributes (CAS 2.0) leave them out
+			w = get("/serviceValidate", url.Values{"service": {testServiceUrl}, "ticket": {issueTicket("ST-2")}, "format": {"json"}})
+			Expect(w.Body.String()).To(MatchJSON(`{"serviceResponse": {"authenticationSuccess": {"user": "test@test.com"}}}`))
+		})
+
+		It("Should respond with failures in the CAS 3.0 JSON shape, with the same code and description as in XML", func() {
+			for _, path := range []string{"/serviceValidate", "/p3/serviceValidate", "/proxyValidate"} {
+				params := url.Values{"service": {testServiceUrl}, "ticket": {"ST-unknown"}}
+				var failure namespacedResponse
+				Expect(xml.Unmarshal(get(path, params).Body.Bytes(), &failure)).To(Succeed())
+				Expect(failure.Failure).NotTo(BeNil(), path)
+
+				params.Set("format", "JSON")
+				var response struct {
+					ServiceResponse map[string]*CASAuthenticationFailure `json:"serviceResponse"`
+				}
+				Expect(json.Unmarshal(get(path, params).Body.Bytes(), &response)).To(Succeed())
+				Expect(response.ServiceResponse).To(HaveLen(1))
+				Expect(response.ServiceResponse).To(HaveKey("authenticationFailure"))
+				Expect(response.ServiceResponse["authenticationFailure"].Code).To(Equal(failure.Failure.Code), path)
+				Expect(response.ServiceResponse["authenticationFailure"].Description).NotTo(BeEmpty(), path)
+			}
+
+			w := get("/proxy", url.Values{"format": {"JSON"}})
+			Expect(w.Body.String()).To(MatchJSON(`{"serviceResponse": {"proxyFailure": {
+				"code": "INVALID_REQUEST",
+				"description": "` + MissingProxyParametersError.Msg + `"
+			}}}`))
+		})
+
+		It("Should respond with XML when the format is unrecognized", func() {
+			w := get("/serviceValidate", url.Values{"format": {"YAML"}})
+			Expect(w.Header().Get("Content-Type")).To(Equal("text/xml; charset=UTF-8"))
+			Expect(w.Body.String()).To(Equal(golden("validate_invalid_request")))
+		})
+	})
+})
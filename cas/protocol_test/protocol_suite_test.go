@@ -0,0 +1,12 @@
+package protocol_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoProtocol(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Protocol Suite")
+}
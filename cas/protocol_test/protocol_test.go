@@ -0,0 +1,182 @@
+package protocol_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+)
+
+var _ = Describe("CAS protocol responses", func() {
+
+	Describe("XML serialization", func() {
+		It("Should produce a namespaced CAS 3.0 success response with attributes", func() {
+			response := NewCASSuccessResponse("test@test.com", CASAttributes{
+				"email": "test@test.com",
+				"name":  "Test User",
+			})
+
+			out, err := xml.Marshal(response)
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(
+				`<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">` +
+					`<cas:authenticationSuccess>` +
+					`<cas:user>test@test.com</cas:user>` +
+					`<cas:attributes><cas:email>test@test.com</cas:email><cas:name>Test User</cas:name></cas:attributes>` +
+					`</cas:authenticationSuccess>` +
+					`</cas:serviceResponse>`))
+		})
+
+		It("Should escape XML special characters in attribute values", func() {
+			response := NewCASSuccessResponse("test@test.com", CASAttributes{
+				"department": `R&D <"lab">`,
+			})
+
+			out, err := xml.Marshal(response)
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(ContainSubstring("<cas:department>R&amp;D &lt;&#34;lab&#34;&gt;</cas:department>"))
+		})
+
+		It("Should omit the attributes block entirely when there are no attributes", func() {
+			out, err := xml.Marshal(NewCASSuccessResponse("test@test.com", CASAttributes{}))
+			Expect(err).To(BeNil())
+			Expect(string(out)).NotTo(ContainSubstring("cas:attributes"))
+
+			out, err = xml.Marshal(NewCASSuccessResponse("test@test.com", nil))
+			Expect(err).To(BeNil())
+			Expect(string(out)).NotTo(ContainSubstring("cas:attributes"))
+		})
+
+		It("Should produce a failure response with a code attribute", func() {
+			out, err := xml.Marshal(NewCASFailureResponse(CAS_INVALID_TICKET, "Ticket not recognized"))
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(
+				`<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">` +
+					`<cas:authenticationFailure code="INVALID_TICKET">Ticket not recognized</cas:authenticationFailure>` +
+					`</cas:serviceResponse>`))
+		})
+	})
+
+	Describe("Proxy responses", func() {
+		It("Should include the PGT IOU and proxy chain in a success response", func() {
+			response := NewCASSuccessResponse("test@test.com", nil)
+			response.Success.ProxyGrantingTicket = "PGTIOU-1234"
+			response.Success.Proxies = CASProxies{"https://proxy2.example.com/cb", "https://proxy1.example.com/cb"}
+
+			out, err := xml.Marshal(response)
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(
+				`<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">` +
+					`<cas:authenticationSuccess>` +
+					`<cas:user>test@test.com</cas:user>` +
+					`<cas:proxyGrantingTicket>PGTIOU-1234</cas:proxyGrantingTicket>` +
+					`<cas:proxies><cas:proxy>https://proxy2.example.com/cb</cas:proxy><cas:proxy>https://proxy1.example.com/cb</cas:proxy></cas:proxies>` +
+					`</cas:authenticationSuccess>` +
+					`</cas:serviceResponse>`))
+		})
+
+		It("Should produce a proxy success response containing the proxy ticket", func() {
+			out, err := xml.Marshal(NewCASProxySuccessResponse("PT-1234"))
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(
+				`<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">` +
+					`<cas:proxySuccess><cas:proxyTicket>PT-1234</cas:proxyTicket></cas:proxySuccess>` +
+					`</cas:serviceResponse>`))
+		})
+
+		It("Should produce a proxy failure response with a code attribute", func() {
+			out, err := xml.Marshal(NewCASProxyFailureResponse(CAS_BAD_PGT, "Proxy-granting ticket has expired"))
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(
+				`<cas:serviceResponse xmlns:cas="http://www.yale.edu/tp/cas">` +
+					`<cas:proxyFailure code="BAD_PGT">Proxy-granting ticket has expired</cas:proxyFailure>` +
+					`</cas:serviceResponse>`))
+		})
+	})
+
+	Describe("JSON serialization", func() {
+		It("Should produce the CAS 3.0 JSON success shape", func() {
+			response := NewCASSuccessResponse("test@test.com", CASAttributes{"email": "test@test.com"})
+			out, err := json.Marshal(map[string]interface{}{"serviceResponse": response})
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(`{"serviceResponse":{"authenticationSuccess":{"user":"test@test.com","attributes":{"email":"test@test.com"}}}}`))
+		})
+
+		It("Should omit attributes in the JSON shape when there are none", func() {
+			out, err := json.Marshal(map[string]interface{}{"serviceResponse": NewCASSuccessResponse("test@test.com", nil)})
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(`{"serviceResponse":{"authenticationSuccess":{"user":"test@test.com"}}}`))
+		})
+
+		It("Should produce the CAS 3.0 JSON failure shape", func() {
+			out, err := json.Marshal(map[string]interface{}{"serviceResponse": NewCASFailureResponse(CAS_INVALID_SERVICE, "Unknown service")})
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(`{"serviceResponse":{"authenticationFailure":{"code":"INVALID_SERVICE","description":"Unknown service"}}}`))
+		})
+
+		It("Should build JSON documents in the same shape, refusing responses the CAS schema doesn't allow", func() {
+			out, err := NewCASFailureResponse(CAS_INVALID_SERVICE, "Unknown service").JSONDocument()
+			Expect(err).To(BeNil())
+			Expect(string(out)).To(Equal(`{"serviceResponse":{"authenticationFailure":{"code":"INVALID_SERVICE","description":"Unknown service"}}}`))
+
+			_, err = (&CASServiceResponse{}).JSONDocument()
+			Expect(err).To(HaveOccurred())
+			_, err = (&CASServiceResponse{Failure: &CASAuthenticationFailure{Description: "No code"}}).JSONDocument()
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+})
+
+var _ = Describe("Attribute release policies", func() {
+
+	attributes := CASAttributes{"email": "test@test.com", "mail": "test@example.com", "name": "Test User", "groups": "admins"}
+
+	It("Should release all attributes without a policy only when releasing by default", func() {
+		var policy *CASAttributeReleasePolicy
+		Expect(policy.Apply(attributes, true)).To(Equal(attributes))
+		Expect(policy.Apply(attributes, false)).To(BeEmpty())
+	})
+
+	It("Should only release allowed attributes", func() {
+		policy := &CASAttributeReleasePolicy{Allowed: []string{"email", "name", "missing"}}
+		Expect(policy.Apply(attributes, false)).To(Equal(CASAttributes{"email": "test@test.com", "name": "Test User"}))
+	})
+
+	It("Should release all attributes with a wildcard", func() {
+		policy := &CASAttributeReleasePolicy{Allowed: []string{"*"}}
+		Expect(policy.Apply(attributes, false)).To(Equal(attributes))
+	})
+
+	It("Should release no attributes with an empty allow-list, even when releasing by default", func() {
+		policy := &CASAttributeReleasePolicy{Allowed: []string{}}
+		Expect(policy.Apply(attributes, true)).To(BeEmpty())
+	})
+
+	It("Should rename mapped attributes, taking precedence over attributes with the same name", func() {
+		policy := &CASAttributeReleasePolicy{
+			Allowed: []string{"*"},
+			Mapping: map[string]string{"mail": "email"},
+		}
+		Expect(policy.Apply(attributes, false)).To(Equal(CASAttributes{"email": "test@example.com", "name": "Test User", "groups": "admins"}))
+	})
+
+	It("Should not release mapped attributes that are not allowed", func() {
+		policy := &CASAttributeReleasePolicy{
+			Allowed: []string{"name"},
+			Mapping: map[string]string{"mail": "email"},
+		}
+		Expect(policy.Apply(attributes, false)).To(Equal(CASAttributes{"name": "Test User"}))
+	})
+
+	It("Should reject services with invalid policies", func() {
+		service := &CASService{Name: "test", Url: "http://localhost/validate", AdminEmail: "admin@test.com"}
+		Expect(service.IsValid()).To(BeTrue())
+
+		service.AttributeReleasePolicy = &CASAttributeReleasePolicy{Allowed: []string{"mail"}, Mapping: map[string]string{"mail": ""}}
+		Expect(service.IsValid()).To(BeFalse())
+		Expect(service.IsValidUpdate()).To(BeFalse())
+	})
+
+})
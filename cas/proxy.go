@@ -0,0 +1,204 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * CAS proxy authentication (CAS 2.0)
+ */
+
+// Ticket ID prefixes
+const (
+	PROXY_GRANTING_TICKET_PREFIX     = "PGT"
+	PROXY_GRANTING_TICKET_IOU_PREFIX = "PGTIOU"
+	PROXY_TICKET_PREFIX              = "PT"
+)
+
+// Maximum amount of time to wait on a proxy callback URL
+const PROXY_CALLBACK_TIMEOUT = 10 * time.Second
+
+// Endpoint for handling proxy tickets (CAS 2.0)
+func (c *CAS) HandleProxy(w http.ResponseWriter, req *http.Request) {
+	pgtId := strings.TrimSpace(req.FormValue("pgt"))
+	targetService := strings.TrimSpace(req.FormValue("targetService"))
+	format := strings.TrimSpace(strings.ToUpper(req.FormValue("format")))
+
+	// Both the PGT and target service are required
+	if len(pgtId) == 0 || len(targetService) == 0 {
+		c.renderServiceResponse(w, format, NewCASProxyFailureResponse(CAS_INVALID_REQUEST, MissingProxyParametersError.Msg))
+		return
+	}
+
+	// Look up the proxy-granting ticket
+	db := c.backendFor(req)
+	pgt, casErr := db.FindProxyGrantingTicketById(pgtId)
+	if casErr != nil {
+		c.renderServiceResponse(w, format, NewCASProxyFailureResponse(CAS_BAD_PGT, FailedToFindProxyGrantingTicketError.Msg))
+		return
+	}
+	if pgt.IsExpired(c.now()) {
+		c.renderServiceResponse(w, format, NewCASProxyFailureResponse(CAS_BAD_PGT, ExpiredProxyGrantingTicketError.Msg))
+		return
+	}
+
+	// Proxy tickets can only be issued for registered (unless registeredServicesOnly is disabled) and allowed services
+	if casErr := c.checkServiceUrlAllowed(targetService); casErr != nil {
+		c.renderServiceResponse(w, format, NewCASProxyFailureResponse(CAS_UNAUTHORIZED_SERVICE, casErr.Msg))
+		return
+	}
+	if _, casErr := c.findServiceForTicket(db, targetService); casErr != nil {
+		c.renderServiceResponse(w, format, NewCASProxyFailureResponse(CAS_UNAUTHORIZED_SERVICE, FailedToFindServiceError.Msg))
+		return
+	}
+
+	// Create the proxy ticket
+	ptId, err := newTicketId(PROXY_TICKET_PREFIX)
+	if err != nil {
+		c.renderServiceResponse(w, format, NewCASProxyFailureResponse(CAS_INTERNAL_ERROR, FailedToCreateProxyTicketError.Msg))
+		return
+	}
+	proxyTicket := &CASProxyTicket{
+		Id:             ptId,
+		UserEmail:      pgt.UserEmail,
+		UserAttributes: pgt.UserAttributes,
+		TargetService:  targetService,
+		Proxies:        pgt.Proxies,
+		ExpiresAt:      c.now().Add(configSecondsAsDuration(c.Config, "ptTTL")),
+	}
+	if casErr := db.AddProxyTicket(proxyTicket); casErr != nil {
+		c.renderServiceResponse(w, format, NewCASProxyFailureResponse(CAS_INTERNAL_ERROR, casErr.Msg))
+		return
+	}
+
+	c.Metrics.TicketsIssued.Inc("proxy")
+	c.audit(req, withAuditDetails(NewAuditEvent(AUDIT_TICKET_ISSUED, pgt.UserEmail, targetService, nil), "ticketType", "proxy"))
+	c.renderServiceResponse(w, format, NewCASProxySuccessResponse(proxyTicket.Id))
+}
+
+// Validate a proxy ticket for a given (target) service URL
+// Proxy tickets are never issued from a fresh login, so they always fail validation with renew
+// Returns the validated proxy ticket, or the CAS failure code and error that caused validation to fail
+func (c *CAS) validateProxyTicket(db Backend, serviceUrl, ticket, renew, serviceSecret string) (*CASProxyTicket, string, *CASServerError) {
+	if len(serviceUrl) == 0 || len(ticket) == 0 {
+		return nil, CAS_INVALID_REQUEST, &MissingValidationParametersError
+	}
+
+	// Registered services with a secret must present it, as they do to validate service tickets
+	if casService, casErr := c.findServiceForTicket(db, serviceUrl); casErr == nil && !casService.SecretMatches(serviceSecret) {
+		return nil, CAS_INVALID_REQUEST, &InvalidServiceSecretError
+	} else if casErr != nil && casErr.Code != FailedToFindServiceByUrlError.Code {
+		return nil, CAS_INTERNAL_ERROR, casErr
+	}
+
+	proxyTicket, casErr := db.FindProxyTicketById(ticket)
+	if casErr != nil {
+		if casErr.Code != FailedToFindProxyTicketError.Code {
+			return nil, CAS_INTERNAL_ERROR, casErr
+		}
+		return nil, CAS_INVALID_TICKET, &FailedToFindProxyTicketError
+	}
+
+	if renew == "true" {
+		return nil, CAS_INVALID_TICKET_SPEC, &ProxyTicketRenewError
+	}
+
+	if proxyTicket.IsExpired(c.now()) {
+		return nil, CAS_INVALID_TICKET, &ExpiredProxyTicketError
+	}
+
+	if proxyTicket.TargetService != serviceUrl {
+		return nil, CAS_INVALID_SERVICE, &ProxyTicketServiceMismatchError
+	}
+
+	// Consume the ticket, which can only be validated once (of concurrent validations, only one succeeds)
+	if casErr := db.ConsumeProxyTicket(proxyTicket.Id); casErr != nil {
+		if casErr.Code != FailedToFindProxyTicketError.Code {
+			c.Logger.Error("Failed to consume proxy ticket", "service", serviceUrl, "username", proxyTicket.UserEmail, "error", casErr)
+			return nil, CAS_INTERNAL_ERROR, casErr
+		}
+		return nil, CAS_INVALID_TICKET, casErr
+	}
+
+	return proxyTicket, "", nil
+}
+
+// Create a new proxy-granting ticket and deliver it to the given proxy callback URL
+// Returns the PGT IOU that should be included in the validation response
+func (c *CAS) issueProxyGrantingTicket(db Backend, pgtUrl, userEmail string, userAttributes map[string]string, proxies []string) (string, *CASServerError) {
+	// Proxy callback URLs must be HTTPS
+	parsedUrl, err := url.Parse(pgtUrl)
+	if err != nil || parsedUrl.Scheme != "https" || len(parsedUrl.Host) == 0 {
+		return "", &InvalidProxyCallbackUrlError
+	}
+
+	pgtId, err := newTicketId(PROXY_GRANTING_TICKET_PREFIX)
+	if err != nil {
+		return "", &FailedToCreateProxyGrantingTicketError
+	}
+	pgtIou, err := newTicketId(PROXY_GRANTING_TICKET_IOU_PREFIX)
+	if err != nil {
+		return "", &FailedToCreateProxyGrantingTicketError
+	}
+
+	// The callback URL becomes the most recent proxy in the chain
+	pgt := &CASProxyGrantingTicket{
+		Id:             pgtId,
+		Iou:            pgtIou,
+		UserEmail:      userEmail,
+		UserAttributes: userAttributes,
+		Proxies:        append([]string{pgtUrl}, proxies...),
+		ExpiresAt:      c.now().Add(configSecondsAsDuration(c.Config, "pgtTTL")),
+	}
+
+	// Save the ticket before delivery, so the proxy can use it as soon as it is received
+	if casErr := db.AddProxyGrantingTicket(pgt); casErr != nil {
+		return "", casErr
+	}
+
+	if err := DeliverProxyGrantingTicket(c.ProxyCallbackClient, pgtUrl, pgt.Id, pgt.Iou); err != nil {
+		c.Logger.Warn("Failed to deliver proxy-granting ticket", "pgtUrl", pgtUrl, "username", userEmail, "error", err)
+		return "", &FailedToDeliverProxyGrantingTicketError
+	}
+
+	c.Metrics.TicketsIssued.Inc("proxy_granting")
+	return pgt.Iou, nil
+}
+
+// Deliver a proxy-granting ticket and its IOU to a proxy callback URL
+// The callback must be served over HTTPS (with a certificate trusted by the client) and respond with 200 OK
+func DeliverProxyGrantingTicket(client *http.Client, pgtUrl, pgtId, pgtIou string) error {
+	callbackUrl, err := url.Parse(pgtUrl)
+	if err != nil {
+		return err
+	}
+	if callbackUrl.Scheme != "https" {
+		return fmt.Errorf("proxy callback URL [%s] is not HTTPS", pgtUrl)
+	}
+
+	// Add the ticket and IOU to any existing query parameters
+	query := callbackUrl.Query()
+	query.Set("pgtId", pgtId)
+	query.Set("pgtIou", pgtIou)
+	callbackUrl.RawQuery = query.Encode()
+
+	if client == nil {
+		client = &http.Client{Timeout: PROXY_CALLBACK_TIMEOUT}
+	}
+
+	resp, err := client.Get(callbackUrl.String())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("proxy callback URL [%s] responded with status %d", pgtUrl, resp.StatusCode)
+	}
+
+	return nil
+}
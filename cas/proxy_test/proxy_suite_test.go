@@ -0,0 +1,12 @@
+package proxy_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoProxy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Proxy Suite")
+}
@@ -0,0 +1,84 @@
+package proxy_test
+
+import (
+	"crypto/tls"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("CAS proxy authentication", func() {
+
+	Describe("#DeliverProxyGrantingTicket", func() {
+		var callbackServer *httptest.Server
+		var receivedPgtId, receivedPgtIou string
+		var callbackStatus int
+
+		// Client that trusts the callback server's self-signed certificate
+		trustingClient := &http.Client{
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+
+		BeforeEach(func() {
+			receivedPgtId, receivedPgtIou = "", ""
+			callbackStatus = http.StatusOK
+			callbackServer = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				receivedPgtId = req.FormValue("pgtId")
+				receivedPgtIou = req.FormValue("pgtIou")
+				w.WriteHeader(callbackStatus)
+			}))
+		})
+
+		AfterEach(func() {
+			callbackServer.Close()
+		})
+
+		It("Should deliver the PGT and PGTIOU to the callback URL", func() {
+			err := DeliverProxyGrantingTicket(trustingClient, callbackServer.URL+"/callback", "PGT-1234", "PGTIOU-5678")
+			Expect(err).To(BeNil())
+			Expect(receivedPgtId).To(Equal("PGT-1234"))
+			Expect(receivedPgtIou).To(Equal("PGTIOU-5678"))
+		})
+
+		It("Should fail if the callback does not respond with 200 OK", func() {
+			callbackStatus = http.StatusNotFound
+			err := DeliverProxyGrantingTicket(trustingClient, callbackServer.URL+"/callback", "PGT-1234", "PGTIOU-5678")
+			Expect(err).ToNot(BeNil())
+		})
+
+		It("Should verify the callback's certificate by default", func() {
+			err := DeliverProxyGrantingTicket(nil, callbackServer.URL+"/callback", "PGT-1234", "PGTIOU-5678")
+			Expect(err).ToNot(BeNil())
+			Expect(receivedPgtId).To(BeEmpty())
+		})
+
+		It("Should refuse to deliver to a non-HTTPS callback URL", func() {
+			err := DeliverProxyGrantingTicket(trustingClient, "http://localhost:9999/callback", "PGT-1234", "PGTIOU-5678")
+			Expect(err).ToNot(BeNil())
+		})
+	})
+
+	Describe("Ticket expiry", func() {
+		It("Should consider a proxy-granting ticket past its expiry time expired", func() {
+			now := time.Now()
+			pgt := &CASProxyGrantingTicket{Id: "PGT-1234", ExpiresAt: now.Add(-time.Second)}
+			Expect(pgt.IsExpired(now)).To(BeTrue())
+
+			pgt.ExpiresAt = now.Add(time.Hour)
+			Expect(pgt.IsExpired(now)).To(BeFalse())
+		})
+
+		It("Should consider a proxy ticket past its expiry time expired", func() {
+			now := time.Now()
+			pt := &CASProxyTicket{Id: "PT-1234", ExpiresAt: now}
+			Expect(pt.IsExpired(now)).To(BeTrue())
+
+			pt.ExpiresAt = now.Add(10 * time.Second)
+			Expect(pt.IsExpired(now)).To(BeFalse())
+		})
+	})
+
+})
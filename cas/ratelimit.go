@@ -0,0 +1,303 @@
+package cas
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Login rate limiting
+ */
+
+// Limits failed login attempts per key (client IP)
+// Implementations must be safe for concurrent use
+type LoginRateLimiter interface {
+	// Whether another login attempt is allowed for the key
+	Allow(key string) bool
+	// Record a failed login attempt for the key, consuming budget
+	RecordFailure(key string)
+}
+
+// Rate limiter that never limits (used when loginRateLimit is 0)
+type NoopLoginRateLimiter struct{}
+
+func (l *NoopLoginRateLimiter) Allow(key string) bool    { return true }
+func (l *NoopLoginRateLimiter) RecordFailure(key string) {}
+
+// In-memory token bucket rate limiter
+// Each key starts with Limit tokens; every failure consumes one, and tokens refill at Limit per Window
+type TokenBucketLoginRateLimiter struct {
+	Limit  int
+	Window time.Duration
+	now    func() time.Time
+	mu     sync.Mutex
+	bucket map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens     float64
+	lastRefill time.Time
+}
+
+func NewTokenBucketLoginRateLimiter(limit int, window time.Duration) *TokenBucketLoginRateLimiter {
+	return &TokenBucketLoginRateLimiter{
+		Limit:  limit,
+		Window: window,
+		now:    time.Now,
+		bucket: make(map[string]*tokenBucket),
+	}
+}
+
+// Override the clock used by the limiter (for testing)
+func (l *TokenBucketLoginRateLimiter) SetClock(now func() time.Time) {
+	l.now = now
+}
+
+func (l *TokenBucketLoginRateLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.bucket[key]
+	if !ok {
+		return l.Limit > 0
+	}
+	l.refill(b)
+	return b.tokens >= 1
+}
+
+func (l *TokenBucketLoginRateLimiter) RecordFailure(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.bucket[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.Limit), lastRefill: l.now()}
+		l.bucket[key] = b
+	}
+	l.refill(b)
+	if b.tokens >= 1 {
+		b.tokens--
+	}
+
+	l.evictFullBuckets()
+}
+
+func (l *TokenBucketLoginRateLimiter) refill(b *tokenBucket) {
+	now := l.now()
+	elapsed := now.Sub(b.lastRefill)
+	if elapsed <= 0 || l.Window <= 0 {
+		return
+	}
+	b.tokens += float64(l.Limit) * elapsed.Seconds() / l.Window.Seconds()
+	if b.tokens > float64(l.Limit) {
+		b.tokens = float64(l.Limit)
+	}
+	b.lastRefill = now
+}
+
+// Drop buckets that have completely refilled (they are equivalent to having no bucket)
+// Only done once the map grows large, to keep failures cheap
+func (l *TokenBucketLoginRateLimiter) evictFullBuckets() {
+	if len(l.bucket) < 10000 {
+		return
+	}
+	for key, b := range l.bucket {
+		l.refill(b)
+		if b.tokens >= float64(l.Limit) {
+			delete(l.bucket, key)
+		}
+	}
+}
+
+// Create the login rate limiter specified by server configuration
+func NewLoginRateLimiterFromConfig(config map[string]string) (LoginRateLimiter, error) {
+	limit, err := configInt(config, "loginRateLimit")
+	if err != nil {
+		return nil, err
+	}
+	if limit < 0 {
+		return nil, fmt.Errorf("Invalid loginRateLimit [%d], must not be negative", limit)
+	}
+	if limit == 0 {
+		return &NoopLoginRateLimiter{}, nil
+	}
+
+	window, err := configInt(config, "loginRateWindow")
+	if err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("Invalid loginRateWindow [%d], must be a positive number of seconds", window)
+	}
+
+	return NewTokenBucketLoginRateLimiter(limit, time.Duration(window)*time.Second), nil
+}
+
+/*
+ * Client IP resolution
+ */
+
+// Proxies whose X-Forwarded-For (or Forwarded) headers are trusted
+type TrustedProxies []*net.IPNet
+
+// Headers trusted proxies can report the addresses they forwarded requests for in (see clientIpHeader)
+const (
+	CLIENT_IP_HEADER_X_FORWARDED_FOR = "X-Forwarded-For"
+	CLIENT_IP_HEADER_FORWARDED       = "Forwarded" // RFC 7239
+)
+
+// Check the header client IPs are read from (clientIpHeader), returning its canonical name
+// Only one header is read, so clients can't pass a forged header through proxies that only set the other one
+func ParseClientIPHeader(header string) (string, error) {
+	for _, known := range []string{CLIENT_IP_HEADER_X_FORWARDED_FOR, CLIENT_IP_HEADER_FORWARDED} {
+		if strings.EqualFold(strings.TrimSpace(header), known) {
+			return known, nil
+		}
+	}
+	return "", fmt.Errorf("Invalid clientIpHeader [%s], expected %s or %s", header, CLIENT_IP_HEADER_X_FORWARDED_FOR, CLIENT_IP_HEADER_FORWARDED)
+}
+
+// Parse a comma separated list of trusted proxy IPs/CIDRs
+func ParseTrustedProxies(list string) (TrustedProxies, error) {
+	proxies := TrustedProxies{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid trusted proxy [%s]", entry)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			entry = fmt.Sprintf("%s/%d", entry, bits)
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid trusted proxy [%s]", entry)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (proxies TrustedProxies) Contains(ip net.IP) bool {
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the peer a request was received from (rather than the client it may have been forwarded for) is trusted
+func (proxies TrustedProxies) IsPeer(req *http.Request) bool {
+	remoteIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+	ip := net.ParseIP(remoteIP)
+	return ip != nil && proxies.Contains(ip)
+}
+
+// Determine the IP of the client making a request
+// X-Forwarded-For is only honored when the request came from a trusted proxy, in which case
+// the right-most address that is not itself a trusted proxy is used
+func (proxies TrustedProxies) ClientIP(req *http.Request) string {
+	return proxies.ClientIPFromHeader(req, CLIENT_IP_HEADER_X_FORWARDED_FOR)
+}
+
+// Determine the IP of the client making a request, as ClientIP does, with the addresses listed in the given header
+// (X-Forwarded-For, or the for= parameters of Forwarded)
+// The chain of proxies is walked back from the remote address for as long as each hop is trusted, stopping at hops
+// that don't give a usable address (ex. Forwarded's "unknown" and obfuscated identifiers)
+func (proxies TrustedProxies) ClientIPFromHeader(req *http.Request, header string) string {
+	remoteIP := req.RemoteAddr
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+
+	ip := net.ParseIP(remoteIP)
+	if ip == nil || !proxies.Contains(ip) {
+		return remoteIP
+	}
+
+	var forwarded []string
+	if header == CLIENT_IP_HEADER_FORWARDED {
+		forwarded = forwardedForAddresses(req.Header.Values(CLIENT_IP_HEADER_FORWARDED))
+	} else {
+		forwarded = strings.Split(strings.Join(req.Header.Values(CLIENT_IP_HEADER_X_FORWARDED_FOR), ","), ",")
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(forwarded[i])
+		hopIP := net.ParseIP(hop)
+		if hopIP == nil {
+			break
+		}
+		if !proxies.Contains(hopIP) {
+			return hop
+		}
+		remoteIP = hop
+	}
+	return remoteIP
+}
+
+// List the addresses given by the for= parameters of Forwarded headers, in order (one per forwarded element)
+// Ports and the brackets around IPv6 addresses are removed, elements without an address are listed as empty
+func forwardedForAddresses(headers []string) []string {
+	addresses := []string{}
+	for _, header := range headers {
+		for _, element := range splitUnquoted(header, ',') {
+			address := ""
+			for _, pair := range splitUnquoted(element, ';') {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(name, "for") {
+					continue
+				}
+				address = strings.Trim(value, `"`)
+				if host, _, err := net.SplitHostPort(address); err == nil {
+					address = host
+				}
+				address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+			}
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// Split a header value at each sep outside of quoted strings
+func splitUnquoted(value string, sep rune) []string {
+	parts := []string{}
+	start, quoted, escaped := 0, false, false
+	for i, char := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && char == '\\':
+			escaped = true
+		case char == '"':
+			quoted = !quoted
+		case char == sep && !quoted:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
+
+// Determine the IP of the client making a request, from the header configured with clientIpHeader
+// Used for rate limiting, audit events and logs alike, so they all agree on who made the request
+func (c *CAS) clientIP(req *http.Request) string {
+	return c.TrustedProxies.ClientIPFromHeader(req, c.ClientIPHeader)
+}
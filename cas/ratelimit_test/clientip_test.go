@@ -0,0 +1,149 @@
+package ratelimit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/url"
+)
+
+var _ = Describe("Forwarded client IPs", func() {
+
+	request := func(remoteAddr string, forwarded ...string) *http.Request {
+		req, _ := http.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = remoteAddr
+		for _, header := range forwarded {
+			req.Header.Add("Forwarded", header)
+		}
+		return req
+	}
+
+	clientIP := func(proxies TrustedProxies, req *http.Request) string {
+		return proxies.ClientIPFromHeader(req, CLIENT_IP_HEADER_FORWARDED)
+	}
+
+	It("Should honor the for= parameters of Forwarded headers from trusted proxies", func() {
+		proxies, err := ParseTrustedProxies("10.0.0.0/8, 2001:db8:cafe::/48")
+		Expect(err).To(BeNil())
+		Expect(clientIP(proxies, request("10.1.1.1:5555", "for=198.51.100.1"))).To(Equal("198.51.100.1"))
+		Expect(clientIP(proxies, request("10.1.1.1:5555", `for="198.51.100.1:4711";proto=https, For=10.2.2.2`))).To(Equal("198.51.100.1"))
+		Expect(clientIP(proxies, request("10.1.1.1:5555", `proto=https;for="[2001:db8::17]:4711"`))).To(Equal("2001:db8::17"))
+		Expect(clientIP(proxies, request("[2001:db8:cafe::1]:5555", `for="[2001:db8::17]", for=10.2.2.2`))).To(Equal("2001:db8::17"))
+		Expect(clientIP(proxies, request("10.1.1.1:5555", "for=198.51.100.1", "for=10.3.3.3;by=10.1.1.1"))).To(Equal("198.51.100.1"))
+	})
+
+	It("Should not split elements inside quoted values", func() {
+		proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+		Expect(clientIP(proxies, request("10.1.1.1:5555", `for=198.51.100.1;host="a,b;c", for=10.2.2.2`))).To(Equal("198.51.100.1"))
+	})
+
+	It("Should stop at hops without an address", func() {
+		proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+		Expect(clientIP(proxies, request("10.1.1.1:5555", "for=198.51.100.1, for=unknown, for=10.2.2.2"))).To(Equal("10.2.2.2"))
+		Expect(clientIP(proxies, request("10.1.1.1:5555", "for=198.51.100.1, for=_hidden"))).To(Equal("10.1.1.1"))
+		Expect(clientIP(proxies, request("10.1.1.1:5555", "for=198.51.100.1, proto=https"))).To(Equal("10.1.1.1"))
+	})
+
+	It("Should not let clients spoof addresses via Forwarded", func() {
+		proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+		Expect(clientIP(proxies, request("10.1.1.1:5555", "for=1.2.3.4, for=198.51.100.1"))).To(Equal("198.51.100.1"))
+		Expect(clientIP(proxies, request("203.0.113.9:5555", "for=1.2.3.4"))).To(Equal("203.0.113.9"))
+
+		none, _ := ParseTrustedProxies("")
+		Expect(clientIP(none, request("203.0.113.9:5555", "for=1.2.3.4"))).To(Equal("203.0.113.9"))
+	})
+
+	It("Should only read the configured header", func() {
+		proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+		req := request("10.1.1.1:5555", "for=198.51.100.1")
+		req.Header.Set("X-Forwarded-For", "1.2.3.4")
+		Expect(proxies.ClientIPFromHeader(req, CLIENT_IP_HEADER_FORWARDED)).To(Equal("198.51.100.1"))
+		Expect(proxies.ClientIPFromHeader(req, CLIENT_IP_HEADER_X_FORWARDED_FOR)).To(Equal("1.2.3.4"))
+	})
+
+	It("Should accept the known headers, in any case", func() {
+		header, err := ParseClientIPHeader("forwarded")
+		Expect(err).To(BeNil())
+		Expect(header).To(Equal(CLIENT_IP_HEADER_FORWARDED))
+		header, err = ParseClientIPHeader("X-Forwarded-For")
+		Expect(err).To(BeNil())
+		Expect(header).To(Equal(CLIENT_IP_HEADER_X_FORWARDED_FOR))
+
+		_, err = ParseClientIPHeader("X-Real-IP")
+		Expect(err).ToNot(BeNil())
+	})
+
+	Describe("Login rate limiting and audit events", func() {
+		var (
+			server *CAS
+			db     *MemoryBackend
+		)
+
+		newServer := func(overrides map[string]string) {
+			config := map[string]string{"loginRateLimit": "2"}
+			for key, value := range overrides {
+				config[key] = value
+			}
+			server, db = castest.NewTestServer(config)
+		}
+
+		// Failed logins come from 192.0.2.1 (see httptest.NewRequest), through the proxies in headers
+		failLogin := func(headers map[string]string) int {
+			client := castest.NewClient(server)
+			for name, value := range headers {
+				client.Header.Set(name, value)
+			}
+			return client.Login(url.Values{"password": {"wrong"}}).Code
+		}
+
+		sourceIPs := func() []string {
+			server.AuditLog.Flush()
+			events, casErr := db.FindAuditEvents(AuditEventQuery{Type: AUDIT_LOGIN})
+			Expect(casErr).To(BeNil())
+			ips := []string{}
+			for _, event := range events {
+				ips = append(ips, event.SourceIP)
+			}
+			return ips
+		}
+
+		AfterEach(func() {
+			if server != nil {
+				castest.Close(server)
+				server = nil
+			}
+		})
+
+		It("Should limit and record logins by the address trusted proxies forwarded them for", func() {
+			newServer(map[string]string{"trustedProxies": "192.0.2.0/24", "clientIpHeader": "Forwarded"})
+			first := map[string]string{"Forwarded": "for=198.51.100.1"}
+			second := map[string]string{"Forwarded": "for=198.51.100.2", "X-Forwarded-For": "198.51.100.1"}
+
+			Expect(failLogin(first)).To(Equal(http.StatusUnauthorized))
+			Expect(failLogin(first)).To(Equal(http.StatusUnauthorized))
+			Expect(failLogin(first)).To(Equal(TooManyLoginAttemptsError.HttpCode))
+			Expect(failLogin(second)).To(Equal(http.StatusUnauthorized))
+			Expect(sourceIPs()).To(Equal([]string{"198.51.100.2", "198.51.100.1", "198.51.100.1", "198.51.100.1"}))
+		})
+
+		It("Should ignore forwarding headers when no proxies are trusted", func() {
+			newServer(nil)
+			Expect(failLogin(map[string]string{"X-Forwarded-For": "198.51.100.1"})).To(Equal(http.StatusUnauthorized))
+			Expect(failLogin(map[string]string{"Forwarded": "for=198.51.100.2"})).To(Equal(http.StatusUnauthorized))
+			Expect(failLogin(map[string]string{"X-Forwarded-For": "198.51.100.3"})).To(Equal(TooManyLoginAttemptsError.HttpCode))
+			Expect(sourceIPs()).To(Equal([]string{"192.0.2.1", "192.0.2.1", "192.0.2.1"}))
+		})
+
+		It("Should refuse unknown client IP headers at startup", func() {
+			config, err := NewCASServerConfig("")
+			Expect(err).To(BeNil())
+			config["dbBackend"] = "memory"
+			config["clientIpHeader"] = "X-Real-IP"
+			_, err = NewCASServerWithLogger(config, NoopLogger{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("clientIpHeader"))
+		})
+	})
+})
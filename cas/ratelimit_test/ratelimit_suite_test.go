@@ -0,0 +1,12 @@
+package ratelimit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoRateLimit(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Rate Limit Suite")
+}
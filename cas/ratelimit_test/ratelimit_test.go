@@ -0,0 +1,132 @@
+package ratelimit_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"time"
+)
+
+var _ = Describe("TokenBucketLoginRateLimiter", func() {
+	var (
+		limiter *TokenBucketLoginRateLimiter
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		now = time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+		limiter = NewTokenBucketLoginRateLimiter(3, time.Minute)
+		limiter.SetClock(func() time.Time { return now })
+	})
+
+	It("Should allow attempts until the failure limit is reached", func() {
+		for i := 0; i < 3; i++ {
+			Expect(limiter.Allow("10.0.0.1")).To(BeTrue())
+			limiter.RecordFailure("10.0.0.1")
+		}
+		Expect(limiter.Allow("10.0.0.1")).To(BeFalse())
+	})
+
+	It("Should track clients separately", func() {
+		for i := 0; i < 3; i++ {
+			limiter.RecordFailure("10.0.0.1")
+		}
+		Expect(limiter.Allow("10.0.0.1")).To(BeFalse())
+		Expect(limiter.Allow("10.0.0.2")).To(BeTrue())
+	})
+
+	It("Should refill budget over the window", func() {
+		for i := 0; i < 3; i++ {
+			limiter.RecordFailure("10.0.0.1")
+		}
+		Expect(limiter.Allow("10.0.0.1")).To(BeFalse())
+
+		now = now.Add(20 * time.Second)
+		Expect(limiter.Allow("10.0.0.1")).To(BeTrue())
+		limiter.RecordFailure("10.0.0.1")
+		Expect(limiter.Allow("10.0.0.1")).To(BeFalse())
+
+		now = now.Add(time.Hour)
+		for i := 0; i < 3; i++ {
+			Expect(limiter.Allow("10.0.0.1")).To(BeTrue())
+			limiter.RecordFailure("10.0.0.1")
+		}
+		Expect(limiter.Allow("10.0.0.1")).To(BeFalse())
+	})
+
+	It("Should not consume budget when attempts are only checked (ex. successful logins)", func() {
+		for i := 0; i < 10; i++ {
+			Expect(limiter.Allow("10.0.0.1")).To(BeTrue())
+		}
+	})
+
+})
+
+var _ = Describe("NewLoginRateLimiterFromConfig", func() {
+
+	It("Should create a token bucket limiter", func() {
+		limiter, err := NewLoginRateLimiterFromConfig(map[string]string{"loginRateLimit": "5", "loginRateWindow": "60"})
+		Expect(err).To(BeNil())
+		Expect(limiter).To(BeAssignableToTypeOf(&TokenBucketLoginRateLimiter{}))
+	})
+
+	It("Should disable rate limiting when the limit is 0", func() {
+		limiter, err := NewLoginRateLimiterFromConfig(map[string]string{"loginRateLimit": "0"})
+		Expect(err).To(BeNil())
+		limiter.RecordFailure("10.0.0.1")
+		Expect(limiter.Allow("10.0.0.1")).To(BeTrue())
+	})
+
+	It("Should reject invalid limits and windows", func() {
+		_, err := NewLoginRateLimiterFromConfig(map[string]string{"loginRateLimit": "-1"})
+		Expect(err).ToNot(BeNil())
+		_, err = NewLoginRateLimiterFromConfig(map[string]string{"loginRateLimit": "5", "loginRateWindow": "0"})
+		Expect(err).ToNot(BeNil())
+		_, err = NewLoginRateLimiterFromConfig(map[string]string{"loginRateLimit": "lots"})
+		Expect(err).ToNot(BeNil())
+	})
+
+})
+
+var _ = Describe("TrustedProxies", func() {
+
+	request := func(remoteAddr string, forwardedFor ...string) *http.Request {
+		req, _ := http.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = remoteAddr
+		for _, header := range forwardedFor {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		return req
+	}
+
+	It("Should use the remote address when no proxies are trusted", func() {
+		proxies, err := ParseTrustedProxies("")
+		Expect(err).To(BeNil())
+		Expect(proxies.ClientIP(request("203.0.113.9:5555", "198.51.100.1"))).To(Equal("203.0.113.9"))
+	})
+
+	It("Should honor X-Forwarded-For from trusted proxies", func() {
+		proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.1")
+		Expect(err).To(BeNil())
+		Expect(proxies.ClientIP(request("10.1.1.1:5555", "198.51.100.1"))).To(Equal("198.51.100.1"))
+		Expect(proxies.ClientIP(request("192.168.1.1:5555", "198.51.100.1, 10.2.2.2"))).To(Equal("198.51.100.1"))
+	})
+
+	It("Should not let clients spoof addresses via X-Forwarded-For", func() {
+		proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+		Expect(proxies.ClientIP(request("10.1.1.1:5555", "1.2.3.4, 198.51.100.1"))).To(Equal("198.51.100.1"))
+		Expect(proxies.ClientIP(request("203.0.113.9:5555", "1.2.3.4"))).To(Equal("203.0.113.9"))
+	})
+
+	It("Should handle multiple X-Forwarded-For headers", func() {
+		proxies, _ := ParseTrustedProxies("10.0.0.0/8")
+		Expect(proxies.ClientIP(request("10.1.1.1:5555", "198.51.100.1", "10.3.3.3"))).To(Equal("198.51.100.1"))
+	})
+
+	It("Should reject invalid proxy entries", func() {
+		_, err := ParseTrustedProxies("10.0.0.0/8,not-an-ip")
+		Expect(err).ToNot(BeNil())
+	})
+
+})
@@ -0,0 +1,305 @@
+package cas
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Minimal Redis client (RESP2), supporting the commands used by the Redis session store
+ */
+
+// Maximum size of a single bulk reply accepted from a server
+const redisMaxBulkSize = 10 * 1024 * 1024
+
+// Error reply returned by a Redis server (ex. "WRONGPASS invalid username-password pair")
+type RedisError struct {
+	Message string
+}
+
+func (err *RedisError) Error() string {
+	return "Redis error: " + err.Message
+}
+
+// Redis connection settings
+type RedisConfig struct {
+	Addr     string // host:port
+	Password string // Sent with AUTH when set
+	DB       int    // Database selected with SELECT when not 0
+	PoolSize int    // Maximum number of idle pooled connections
+	Timeout  time.Duration
+}
+
+// Build (and validate) Redis connection settings from server configuration
+func NewRedisConfigFromConfig(config map[string]string) (*RedisConfig, error) {
+	addr := strings.TrimSpace(config["redisAddr"])
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("Invalid redisAddr [%s], expected a host:port address", config["redisAddr"])
+	}
+
+	db, err := configInt(config, "redisDB")
+	if err != nil {
+		return nil, err
+	}
+	if db < 0 {
+		return nil, fmt.Errorf("Invalid redisDB [%d], must not be negative", db)
+	}
+
+	poolSize, err := configInt(config, "redisPoolSize")
+	if err != nil {
+		return nil, err
+	}
+	if poolSize < 1 {
+		return nil, fmt.Errorf("Invalid redisPoolSize [%d], must be at least 1", poolSize)
+	}
+
+	return &RedisConfig{
+		Addr:     addr,
+		Password: config["redisPassword"],
+		DB:       db,
+		PoolSize: poolSize,
+		Timeout:  configSecondsAsDuration(config, "redisTimeout"),
+	}, nil
+}
+
+// Redis client, sending commands over pooled connections
+type RedisClient struct {
+	Config *RedisConfig
+	pool   *redisConnPool
+}
+
+func NewRedisClient(config *RedisConfig) *RedisClient {
+	client := &RedisClient{Config: config}
+	client.pool = &redisConnPool{size: config.PoolSize, dial: client.dial}
+	return client
+}
+
+// Send a command, returning its reply: a string (status), int64, []byte (bulk, nil if missing) or []interface{}
+func (c *RedisClient) Do(args ...string) (interface{}, error) {
+	conn, pooled, err := c.pool.Get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.do(c.Config.Timeout, args...)
+	broken := conn.broken
+	c.pool.Put(conn)
+
+	// Idle connections may have been closed by the server (ex. its timeout elapsed), retry on a new one
+	if err != nil && broken && pooled {
+		if conn, err = c.dial(); err != nil {
+			return nil, err
+		}
+		reply, err = conn.do(c.Config.Timeout, args...)
+		c.pool.Put(conn)
+	}
+	return reply, err
+}
+
+// Get the value of a key (nil if it doesn't exist)
+func (c *RedisClient) Get(key string) ([]byte, error) {
+	reply, err := c.Do("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	value, _ := reply.([]byte)
+	return value, nil
+}
+
+// Set the value of a key, expiring it after ttl (rounded up to the second)
+func (c *RedisClient) Set(key string, value []byte, ttl time.Duration) error {
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	_, err := c.Do("SET", key, string(value), "EX", strconv.FormatInt(seconds, 10))
+	return err
+}
+
+// Delete a key (deleting a key that doesn't exist is not an error)
+func (c *RedisClient) Del(key string) error {
+	_, err := c.Do("DEL", key)
+	return err
+}
+
+// Check that the server is reachable
+func (c *RedisClient) Ping() error {
+	_, err := c.Do("PING")
+	return err
+}
+
+// Close idle pooled connections
+func (c *RedisClient) Close() {
+	c.pool.Close()
+}
+
+// Dial a new connection, authenticating and selecting the database if configured
+func (c *RedisClient) dial() (*redisConn, error) {
+	netConn, err := net.DialTimeout("tcp", c.Config.Addr, c.Config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{conn: netConn, reader: bufio.NewReader(netConn)}
+
+	if len(c.Config.Password) > 0 {
+		if _, err := conn.do(c.Config.Timeout, "AUTH", c.Config.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.Config.DB != 0 {
+		if _, err := conn.do(c.Config.Timeout, "SELECT", strconv.Itoa(c.Config.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// Connection to a Redis server
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	broken bool // Set when the connection can't be reused (I/O or protocol error)
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// Send a command and read its reply
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	if timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(timeout))
+	}
+
+	command := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		command += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.conn, command); err != nil {
+		c.broken = true
+		return nil, err
+	}
+
+	reply, err := c.readReply()
+	if err != nil {
+		if _, ok := err.(*RedisError); !ok {
+			c.broken = true
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Read a reply (RESP2 simple string, error, integer, bulk string or array)
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("Malformed Redis reply [%q]", line)
+	}
+	kind, line := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, &RedisError{Message: line}
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line)
+		if err != nil || size > redisMaxBulkSize {
+			return nil, fmt.Errorf("Malformed Redis bulk reply length [%s]", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(c.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	case '*':
+		count, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("Malformed Redis array reply length [%s]", line)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			item, err := c.readReply()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("Unexpected Redis reply type [%c]", kind)
+	}
+}
+
+// Pool of idle Redis connections
+type redisConnPool struct {
+	mu   sync.Mutex
+	idle []*redisConn
+	size int
+	dial func() (*redisConn, error)
+}
+
+// Get an idle connection (pooled), or dial a new one
+func (p *redisConnPool) Get() (conn *redisConn, pooled bool, err error) {
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		conn = p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return conn, true, nil
+	}
+	p.mu.Unlock()
+
+	conn, err = p.dial()
+	return conn, false, err
+}
+
+// Return a connection to the pool, closing it if it is broken or the pool is full
+func (p *redisConnPool) Put(conn *redisConn) {
+	if conn.broken {
+		conn.Close()
+		return
+	}
+
+	p.mu.Lock()
+	if len(p.idle) < p.size {
+		p.idle = append(p.idle, conn)
+		conn = nil
+	}
+	p.mu.Unlock()
+
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Close all idle connections
+func (p *redisConnPool) Close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.mu.Unlock()
+
+	for _, conn := range idle {
+		conn.Close()
+	}
+}
@@ -0,0 +1,12 @@
+package renew_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoRenew(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Renew Suite")
+}
@@ -0,0 +1,90 @@
+package renew_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/url"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+var _ = Describe("renew", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	// Log in with credentials, returning the issued service ticket
+	login := func(extra url.Values) string {
+		form := url.Values{"serviceUrl": {testServiceUrl}}
+		for key, values := range extra {
+			form[key] = values
+		}
+		w := client.Login(form)
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	serviceValidate := func(ticket string, renew bool) string {
+		query := url.Values{"service": {testServiceUrl}, "ticket": {ticket}}
+		if renew {
+			query.Set("renew", "true")
+		}
+		return client.Get("/p3/serviceValidate?" + query.Encode()).Body.String()
+	}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+		client = castest.NewClient(server)
+		client.Header.Set("Accept-Language", "en")
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should ask for credentials again (keeping renew in the form) despite a login session", func() {
+		login(nil)
+
+		w := client.Get("/login?" + url.Values{"service": {testServiceUrl}, "renew": {"true"}}.Encode())
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`name="renew"`))
+
+		w = client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode())
+		Expect(w.Code).To(Equal(http.StatusFound))
+	})
+
+	It("Should validate tickets issued by renewed logins with renew", func() {
+		ticket := login(url.Values{"renew": {"true"}})
+
+		service, casErr := db.FindServiceByUrl(testServiceUrl)
+		Expect(casErr).To(BeNil())
+		casTicket, casErr := db.FindTicketByIdForService(ticket, service)
+		Expect(casErr).To(BeNil())
+		Expect(casTicket.Renewed).To(BeTrue())
+		Expect(casTicket.WasSSO).To(BeFalse())
+
+		Expect(serviceValidate(ticket, true)).To(ContainSubstring("authenticationSuccess"))
+	})
+
+	It("Should refuse tickets issued through single sign on when validated with renew", func() {
+		login(nil)
+		w := client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode())
+		Expect(w.Code).To(Equal(http.StatusFound))
+
+		response := serviceValidate(castest.Ticket(w), true)
+		Expect(response).To(ContainSubstring(`code="` + CAS_INVALID_TICKET_SPEC + `"`))
+		Expect(response).NotTo(ContainSubstring("authenticationSuccess"))
+	})
+
+	It("Should still validate tickets issued through single sign on without renew", func() {
+		login(nil)
+		w := client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode())
+
+		Expect(serviceValidate(castest.Ticket(w), false)).To(ContainSubstring("authenticationSuccess"))
+	})
+})
@@ -0,0 +1,74 @@
+package cas
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+/*
+ * Request IDs, used to correlate log messages (ex. across a login and the validation of its ticket)
+ */
+
+// Header carrying request IDs (accepted from clients/proxies, and returned in responses)
+const REQUEST_ID_HEADER = "X-Request-ID"
+
+// Incoming request IDs are only reused if they are short and URL-safe
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._~-]{1,64}$`)
+
+type requestIdContextKey struct{}
+
+// Store a request ID in a context
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey{}, id)
+}
+
+// Get the request ID stored in a context (if any)
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIdContextKey{}).(string)
+	return id, ok && len(id) > 0
+}
+
+// Wrap a handler, assigning every request an ID (reusing a well-formed incoming X-Request-ID, otherwise a new UUID)
+// The ID is stored in the request context and echoed back in the response header
+func (c *CAS) withRequestId(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestId.MatchString(id) {
+			id = newRequestId()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		h.ServeHTTP(w, req.WithContext(ContextWithRequestID(req.Context(), id)))
+	})
+}
+
+// Get the ID assigned to a request (requests that did not pass through withRequestId are given a new one)
+func requestId(req *http.Request) string {
+	if id, ok := RequestIDFromContext(req.Context()); ok {
+		return id
+	}
+	return newRequestId()
+}
+
+func newRequestId() string {
+	id, err := newUUID()
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return id
+}
+
+// Logger for messages about a request, with its ID and client IP
+func (c *CAS) requestLogger(req *http.Request) Logger {
+	return c.Logger.With("requestId", requestId(req), "clientIp", c.clientIP(req))
+}
+
+// Get the storage backend to use for a request
+func (c *CAS) backendFor(req *http.Request) Backend {
+	if contextual, ok := c.Db.(ContextualBackend); ok {
+		return contextual.WithContext(req.Context())
+	}
+	return c.Db
+}
@@ -0,0 +1,12 @@
+package requestid_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoRequestID(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Request ID Suite")
+}
@@ -0,0 +1,150 @@
+package requestid_test
+
+import (
+	"bytes"
+	"context"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// Memory backend recording the request IDs that service lookups were made for
+type contextualBackend struct {
+	*MemoryBackend
+	mu  *sync.Mutex
+	ids *[]string
+}
+
+type requestScopedBackend struct {
+	contextualBackend
+	requestId string
+}
+
+func (b contextualBackend) WithContext(ctx context.Context) Backend {
+	id, _ := RequestIDFromContext(ctx)
+	return requestScopedBackend{contextualBackend: b, requestId: id}
+}
+
+func (b requestScopedBackend) FindServiceByUrl(serviceUrl string) (*CASService, *CASServerError) {
+	b.mu.Lock()
+	*b.ids = append(*b.ids, b.requestId)
+	b.mu.Unlock()
+	return b.MemoryBackend.FindServiceByUrl(serviceUrl)
+}
+
+func (b contextualBackend) seen() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string{}, *b.ids...)
+}
+
+var _ = Describe("Request IDs", func() {
+	var (
+		server  *CAS
+		backend contextualBackend
+		logs    *bytes.Buffer
+	)
+
+	serve := func(method, path string, form url.Values, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	BeforeEach(func() {
+		backend = contextualBackend{
+			MemoryBackend: NewMemoryBackend(time.Minute),
+			mu:            &sync.Mutex{},
+			ids:           &[]string{},
+		}
+		RegisterBackend("contextual-test", func(c *CAS) (Backend, error) {
+			return backend, nil
+		})
+
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "contextual-test"
+		config["templatesDirectory"] = "../templates"
+		config["csrfEnabled"] = "false"
+
+		logs = &bytes.Buffer{}
+		logger, err := NewStdLogger(logs, DEBUG, LOG_FORMAT_TEXT)
+		Expect(err).To(BeNil())
+		server, err = NewCASServerWithLogger(config, logger)
+		Expect(err).To(BeNil())
+		Expect(backend.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+		Expect(backend.LoadJSONFixture("services", "../../fixtures/services.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		backend.Close()
+	})
+
+	It("Should generate URL-safe UUIDs for requests without an ID", func() {
+		first := serve("GET", "/login", url.Values{}, nil).Header().Get(REQUEST_ID_HEADER)
+		second := serve("GET", "/login", url.Values{}, nil).Header().Get(REQUEST_ID_HEADER)
+
+		Expect(first).To(MatchRegexp(uuidPattern.String()))
+		Expect(url.QueryEscape(first)).To(Equal(first))
+		Expect(second).NotTo(Equal(first))
+	})
+
+	It("Should echo well-formed incoming IDs, replacing others", func() {
+		w := serve("GET", "/login", url.Values{}, map[string]string{"X-Request-ID": "edge-proxy_42"})
+		Expect(w.Header().Get("X-Request-ID")).To(Equal("edge-proxy_42"))
+
+		for _, bad := range []string{"has space", "x/y", strings.Repeat("a", 65)} {
+			w = serve("GET", "/login", url.Values{}, map[string]string{"X-Request-ID": bad})
+			Expect(w.Header().Get("X-Request-ID")).To(MatchRegexp(uuidPattern.String()))
+		}
+	})
+
+	It("Should include the ID in log lines for the request", func() {
+		w := serve("POST", "/login", url.Values{
+			"email":    {"test@test.com"},
+			"password": {"wrong"},
+		}, nil)
+		id := w.Header().Get(REQUEST_ID_HEADER)
+		Expect(id).NotTo(BeEmpty())
+		Expect(logs.String()).To(ContainSubstring(`msg="Login failed"`))
+		Expect(logs.String()).To(ContainSubstring("requestId=" + id))
+	})
+
+	It("Should pass the ID to backends that accept a request context", func() {
+		serve("POST", "/login", url.Values{
+			"email":      {"test@test.com"},
+			"password":   {"wrong"},
+			"serviceUrl": {"localhost:3000/validateCASLogin"},
+		}, map[string]string{REQUEST_ID_HEADER: "login-1"})
+		serve("GET", "/serviceValidate?"+url.Values{
+			"service": {"localhost:3000/validateCASLogin"},
+			"ticket":  {"ST-unknown"},
+		}.Encode(), url.Values{}, map[string]string{REQUEST_ID_HEADER: "validate-1"})
+
+		Expect(backend.seen()).To(ContainElement("login-1"))
+		Expect(backend.seen()).To(ContainElement("validate-1"))
+	})
+
+	It("Should read IDs from contexts", func() {
+		_, ok := RequestIDFromContext(context.Background())
+		Expect(ok).To(BeFalse())
+
+		id, ok := RequestIDFromContext(ContextWithRequestID(context.Background(), "abc"))
+		Expect(ok).To(BeTrue())
+		Expect(id).To(Equal("abc"))
+	})
+})
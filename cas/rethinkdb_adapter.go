@@ -1,32 +1,80 @@
 package cas
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	r "github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/dancannon/gorethink"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
 )
 
-func (db *RethinkDBAdapter) GetDbName() string            { return db.dbName }
-func (db *RethinkDBAdapter) GetTicketsTableName() string  { return db.ticketsTableName }
-func (db *RethinkDBAdapter) GetServicesTableName() string { return db.servicesTableName }
-func (db *RethinkDBAdapter) GetUsersTableName() string    { return db.usersTableName }
-func (db *RethinkDBAdapter) GetApiKeysTableName() string  { return db.apiKeysTableName }
+func (db *RethinkDBAdapter) GetDbName() string                        { return db.dbName }
+func (db *RethinkDBAdapter) GetTicketsTableName() string              { return db.ticketsTableName }
+func (db *RethinkDBAdapter) GetServicesTableName() string             { return db.servicesTableName }
+func (db *RethinkDBAdapter) GetUsersTableName() string                { return db.usersTableName }
+func (db *RethinkDBAdapter) GetApiKeysTableName() string              { return db.apiKeysTableName }
+func (db *RethinkDBAdapter) GetProxyGrantingTicketsTableName() string { return db.pgtsTableName }
+func (db *RethinkDBAdapter) GetProxyTicketsTableName() string         { return db.ptsTableName }
+func (db *RethinkDBAdapter) GetTicketGrantingTicketsTableName() string {
+	return db.tgtsTableName
+}
+func (db *RethinkDBAdapter) GetAuditEventsTableName() string { return db.auditTableName }
+func (db *RethinkDBAdapter) GetOIDCCodesTableName() string   { return db.oidcTableName }
+
+// RethinkDB is the default storage backend
+var _ CASDBAdapter = (*RethinkDBAdapter)(nil)
+var _ SweepableBackend = (*RethinkDBAdapter)(nil)
+var _ AuditableBackend = (*RethinkDBAdapter)(nil)
+
+func init() {
+	RegisterBackend("rethinkdb", func(c *CAS) (Backend, error) {
+		adapter, err := NewRethinkDBAdapter(c)
+		if err != nil {
+			return nil, err
+		}
+		return adapter, nil
+	})
+}
 
 func NewRethinkDBAdapter(c *CAS) (*RethinkDBAdapter, error) {
-	// Database setup
-	dbSession, err := r.Connect(r.ConnectOpts{
+	poolOptions, err := NewConnPoolOptions(c.Config)
+	if err != nil {
+		return nil, err
+	}
+	logger := loggerOrDefault(c.Logger).With("backend", "rethinkdb")
+	poolOptions.Logger = logger
+
+	// Database setup (each pooled session holds a single connection)
+	connectOpts := r.ConnectOpts{
 		Address:  c.Config["dbHost"],
 		Database: c.Config["dbName"],
-	})
+		Timeout:  poolOptions.AcquireTimeout,
+		MaxIdle:  1,
+		MaxOpen:  1,
+	}
+	pool := NewConnPool(func() (PooledConn, error) {
+		session, err := r.Connect(connectOpts)
+		if err != nil {
+			return nil, err
+		}
+		return &rethinkDBConn{session: session, logger: logger}, nil
+	}, poolOptions)
+
+	// Fail early if the database can't be reached at all
+	conn, err := pool.Acquire()
 	if err != nil {
+		pool.Close()
 		return nil, err
 	}
+	pool.Release(conn, false)
 
 	// Create the adapter
 	adapter := &RethinkDBAdapter{
-		session:              dbSession,
+		pool:                 pool,
 		dbName:               c.Config["dbName"],
 		ticketsTableName:     "tickets",
 		ticketsTableOptions:  nil,
@@ -36,21 +84,198 @@ func NewRethinkDBAdapter(c *CAS) (*RethinkDBAdapter, error) {
 		usersTableOptions:    &r.TableCreateOpts{PrimaryKey: "email"},
 		apiKeysTableName:     "api_keys",
 		apiKeysTableOptions:  &r.TableCreateOpts{PrimaryKey: "key"},
-		LogLevel:             c.Config["logLevel"],
+		pgtsTableName:        "proxy_granting_tickets",
+		pgtsTableOptions:     nil,
+		ptsTableName:         "proxy_tickets",
+		ptsTableOptions:      nil,
+		tgtsTableName:        "ticket_granting_tickets",
+		tgtsTableOptions:     nil,
+		auditTableName:       "audit_events",
+		auditTableOptions:    nil,
+		oidcTableName:        "oidc_authorization_codes",
+		oidcTableOptions:     nil,
+		migrationsTableName:  "schema_migrations",
+		logger:               logger,
+		ctx:                  context.Background(),
+		queryTimeout:         configSecondsAsDuration(c.Config, "dbQueryTimeout"),
 	}
 
 	return adapter, nil
 }
 
+// Close all database connections
+func (db *RethinkDBAdapter) Close() {
+	db.pool.Close()
+}
+
+// Check that the database responds to a query, giving up once ctx is done
+func (db *RethinkDBAdapter) Ping(ctx context.Context) *CASServerError {
+	scoped := *db
+	scoped.ctx = ctx
+	conn, connErr := scoped.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer scoped.release(conn)
+
+	cursor, err := conn.Run(r.Expr(1))
+	if err == nil {
+		err = cursor.Close()
+	}
+	if err != nil {
+		conn.failed = true
+		return queryError(&FailedToAcquireDbConnectionError, err)
+	}
+	return nil
+}
+
+// Get a view of the adapter (sharing its connection pool) for operations run on behalf of a request
+// Operations give up when the request's context is done (ex. the client disconnected), and log with its ID
+func (db *RethinkDBAdapter) WithContext(ctx context.Context) Backend {
+	scoped := *db
+	scoped.ctx = ctx
+	if id, ok := RequestIDFromContext(ctx); ok {
+		scoped.logger = db.logger.With("requestId", id)
+	}
+	return &scoped
+}
+
+// Pooled RethinkDB session (holding a single connection)
+// Records whether any query run on it failed, so broken connections can be dropped when released
+type rethinkDBConn struct {
+	session   *r.Session
+	failed    bool
+	logger    Logger
+	ctx       context.Context    // Context of the operation the connection was acquired for
+	cancel    context.CancelFunc // Ends the operation's context (when the connection is released)
+	abandoned chan struct{}      // Closed once a query given up on returns (nil if no query was given up on)
+}
+
+// Result of a query run on a rethinkDBConn
+type rethinkDBResult struct {
+	cursor *r.Cursor
+	res    r.WriteResponse
+	err    error
+}
+
+func (c *rethinkDBConn) Run(term r.Term) (*r.Cursor, error) {
+	result := c.await(func() rethinkDBResult {
+		cursor, err := term.Run(c.session)
+		return rethinkDBResult{cursor: cursor, err: err}
+	})
+	c.recordError(result.err)
+	return result.cursor, result.err
+}
+
+func (c *rethinkDBConn) RunWrite(term r.Term) (r.WriteResponse, error) {
+	result := c.await(func() rethinkDBResult {
+		res, err := term.RunWrite(c.session)
+		return rethinkDBResult{res: res, err: err}
+	})
+	c.recordError(result.err)
+	return result.res, result.err
+}
+
+// Run a query, giving up on it (with the context's error) once the operation's context is done
+// The driver can't cancel queries, so a query given up on keeps the connection until it returns (see release)
+func (c *rethinkDBConn) await(query func() rethinkDBResult) rethinkDBResult {
+	if err := c.ctx.Err(); err != nil {
+		return rethinkDBResult{err: err}
+	}
+
+	results := make(chan rethinkDBResult, 1)
+	go func() { results <- query() }()
+	select {
+	case result := <-results:
+		return result
+	case <-c.ctx.Done():
+		abandoned := make(chan struct{})
+		c.abandoned = abandoned
+		go func() {
+			if result := <-results; result.cursor != nil {
+				result.cursor.Close()
+			}
+			close(abandoned)
+		}()
+		return rethinkDBResult{err: c.ctx.Err()}
+	}
+}
+
+func (c *rethinkDBConn) recordError(err error) {
+	if err != nil {
+		c.failed = true
+		c.logger.Error("Database query failed", "error", err)
+	}
+}
+
+// Attach the error of a failed query to casErr, unless the query was given up on (as its context was done)
+func queryError(casErr *CASServerError, err error) *CASServerError {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		casErr = &DbOperationCancelledError
+	}
+	casErr.err = &err
+	return casErr
+}
+
+func (c *rethinkDBConn) Ping() error {
+	cursor, err := r.Expr(1).Run(c.session)
+	if err != nil {
+		return err
+	}
+	return cursor.Close()
+}
+
+func (c *rethinkDBConn) Close() error {
+	return c.session.Close()
+}
+
+// Acquire a connection from the pool, to be returned with release
+// The operation the connection is used for is limited to queryTimeout, and ends early if the view's context is done
+func (db *RethinkDBAdapter) acquire() (*rethinkDBConn, *CASServerError) {
+	ctx, cancel := context.WithCancel(db.ctx)
+	if db.queryTimeout > 0 {
+		ctx, cancel = context.WithTimeout(db.ctx, db.queryTimeout)
+	}
+
+	conn, err := db.pool.AcquireContext(ctx)
+	if err != nil {
+		cancel()
+		return nil, queryError(&FailedToAcquireDbConnectionError, err)
+	}
+	// Connections are used by one adapter (view) at a time, so log as whoever acquired it
+	rdbConn := conn.(*rethinkDBConn)
+	rdbConn.logger = db.logger
+	rdbConn.ctx, rdbConn.cancel = ctx, cancel
+	return rdbConn, nil
+}
+
+// Return a connection to the pool, once any query given up on has returned
+func (db *RethinkDBAdapter) release(conn *rethinkDBConn) {
+	conn.cancel()
+	failed, abandoned := conn.failed, conn.abandoned
+	conn.failed, conn.abandoned = false, nil
+	if abandoned == nil {
+		db.pool.Release(conn, failed)
+		return
+	}
+	go func() {
+		<-abandoned
+		db.pool.Release(conn, failed)
+	}()
+}
+
 // Check if the database has been setup
 func (db *RethinkDBAdapter) DbExists() (bool, *CASServerError) {
-	cursor, err := r.
-		DBList().
-		Run(db.session)
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return false, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DBList())
 	if err != nil {
-		casErr := &DbExistsCheckFailedError
-		casErr.err = &err
-		return false, casErr
+		return false, queryError(&DbExistsCheckFailedError, err)
 	}
 
 	var response []interface{}
@@ -66,29 +291,37 @@ func (db *RethinkDBAdapter) DbExists() (bool, *CASServerError) {
 }
 
 // Create/Setup all relevant tables in the database
+// Those that already exist (ex. created by the migrations applied at startup) are left as they are
 func (db *RethinkDBAdapter) Setup() *CASServerError {
-
-	// Setup the Database
-	_, err := r.
-		DBCreate(db.dbName).
-		Run(db.session)
-	if err != nil {
-		casError := &FailedToSetupDatabaseError
-		casError.err = &err
-		return casError
+	if casErr := db.ensureDatabase(); casErr != nil {
+		return casErr
 	}
 
-	// Setup tables
-	db.SetupServicesTable()
-	db.SetupTicketsTable()
-	db.SetupUsersTable()
-	db.SetupApiKeysTable()
-
-	return nil
+	casErr := db.ensureTables(
+		db.servicesTableName,
+		db.ticketsTableName,
+		db.usersTableName,
+		db.apiKeysTableName,
+		db.pgtsTableName,
+		db.ptsTableName,
+		db.tgtsTableName,
+		db.auditTableName,
+		db.oidcTableName,
+	)
+	if casErr != nil {
+		return casErr
+	}
+	return db.ensureLookupIndexes()
 }
 
 func (db *RethinkDBAdapter) teardownTable(tableName string) *CASServerError {
-	_, err := r.DB(db.dbName).TableDrop(tableName).Run(db.session)
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	_, err := conn.Run(r.DB(db.dbName).TableDrop(tableName))
 	if err != nil {
 		casError := &FailedToTeardownDatabaseError
 		casError.err = &err
@@ -111,14 +344,20 @@ func (db *RethinkDBAdapter) setupTable(tableName string, dbOptions interface{})
 }
 
 func (db *RethinkDBAdapter) createTableWithOptions(tableName string, rdbOptions *r.TableCreateOpts) *CASServerError {
-	logMessagef(db.LogLevel, "INFO", "Creating table [%s], options: %v", tableName, rdbOptions)
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	db.logger.Info("Creating table", "table", tableName, "options", fmt.Sprintf("%v", rdbOptions))
 
 	// Check again that rdbOptions is not nil, optionally leave out argument
 	var err error
 	if rdbOptions == nil {
 
 		// Create table with no options
-		_, err = r.DB(db.dbName).TableCreate(tableName).Run(db.session)
+		_, err = conn.Run(r.DB(db.dbName).TableCreate(tableName))
 
 	} else {
 
@@ -132,7 +371,7 @@ func (db *RethinkDBAdapter) createTableWithOptions(tableName string, rdbOptions
 		}
 
 		// Create table
-		_, err = r.DB(db.dbName).TableCreate(tableName, *options).Run(db.session)
+		_, err = conn.Run(r.DB(db.dbName).TableCreate(tableName, *options))
 	}
 
 	if err != nil {
@@ -184,6 +423,56 @@ func (db *RethinkDBAdapter) TeardownApiKeysTable() *CASServerError {
 	return db.teardownTable(db.apiKeysTableName)
 }
 
+// Set up the table that holds proxy-granting tickets
+func (db *RethinkDBAdapter) SetupProxyGrantingTicketsTable() *CASServerError {
+	return db.setupTable(db.pgtsTableName, db.pgtsTableOptions)
+}
+
+// Tear down the table that holds proxy-granting tickets
+func (db *RethinkDBAdapter) TeardownProxyGrantingTicketsTable() *CASServerError {
+	return db.teardownTable(db.pgtsTableName)
+}
+
+// Set up the table that holds proxy tickets
+func (db *RethinkDBAdapter) SetupProxyTicketsTable() *CASServerError {
+	return db.setupTable(db.ptsTableName, db.ptsTableOptions)
+}
+
+// Tear down the table that holds proxy tickets
+func (db *RethinkDBAdapter) TeardownProxyTicketsTable() *CASServerError {
+	return db.teardownTable(db.ptsTableName)
+}
+
+// Set up the table that holds ticket-granting tickets
+func (db *RethinkDBAdapter) SetupTicketGrantingTicketsTable() *CASServerError {
+	return db.setupTable(db.tgtsTableName, db.tgtsTableOptions)
+}
+
+// Tear down the table that holds ticket-granting tickets
+func (db *RethinkDBAdapter) TeardownTicketGrantingTicketsTable() *CASServerError {
+	return db.teardownTable(db.tgtsTableName)
+}
+
+// Set up the table that holds audit events
+func (db *RethinkDBAdapter) SetupAuditEventsTable() *CASServerError {
+	return db.setupTable(db.auditTableName, db.auditTableOptions)
+}
+
+// Tear down the table that holds audit events
+func (db *RethinkDBAdapter) TeardownAuditEventsTable() *CASServerError {
+	return db.teardownTable(db.auditTableName)
+}
+
+// Set up the table that holds OpenID Connect authorization codes
+func (db *RethinkDBAdapter) SetupOIDCCodesTable() *CASServerError {
+	return db.setupTable(db.oidcTableName, db.oidcTableOptions)
+}
+
+// Tear down the table that holds OpenID Connect authorization codes
+func (db *RethinkDBAdapter) TeardownOIDCCodesTable() *CASServerError {
+	return db.teardownTable(db.oidcTableName)
+}
+
 // Dynamically setup tables - dispatch because each table might have special implementations
 func (db *RethinkDBAdapter) SetupTable(tableName string) *CASServerError {
 	switch tableName {
@@ -195,6 +484,16 @@ func (db *RethinkDBAdapter) SetupTable(tableName string) *CASServerError {
 		return db.SetupUsersTable()
 	case db.apiKeysTableName:
 		return db.SetupApiKeysTable()
+	case db.pgtsTableName:
+		return db.SetupProxyGrantingTicketsTable()
+	case db.ptsTableName:
+		return db.SetupProxyTicketsTable()
+	case db.tgtsTableName:
+		return db.SetupTicketGrantingTicketsTable()
+	case db.auditTableName:
+		return db.SetupAuditEventsTable()
+	case db.oidcTableName:
+		return db.SetupOIDCCodesTable()
 	default:
 		casError := &FailedToSetupDatabaseError
 		return casError
@@ -210,6 +509,16 @@ func (db *RethinkDBAdapter) TeardownTable(tableName string) *CASServerError {
 		return db.TeardownServicesTable()
 	case db.usersTableName:
 		return db.TeardownUsersTable()
+	case db.pgtsTableName:
+		return db.TeardownProxyGrantingTicketsTable()
+	case db.ptsTableName:
+		return db.TeardownProxyTicketsTable()
+	case db.tgtsTableName:
+		return db.TeardownTicketGrantingTicketsTable()
+	case db.auditTableName:
+		return db.TeardownAuditEventsTable()
+	case db.oidcTableName:
+		return db.TeardownOIDCCodesTable()
 	default:
 		casError := &FailedToTeardownDatabaseError
 		return casError
@@ -227,6 +536,16 @@ func (db *RethinkDBAdapter) getTableSetupOptions(tableName string) (*r.TableCrea
 		return db.usersTableOptions, nil
 	case db.apiKeysTableName:
 		return db.apiKeysTableOptions, nil
+	case db.pgtsTableName:
+		return db.pgtsTableOptions, nil
+	case db.ptsTableName:
+		return db.ptsTableOptions, nil
+	case db.tgtsTableName:
+		return db.tgtsTableOptions, nil
+	case db.auditTableName:
+		return db.auditTableOptions, nil
+	case db.oidcTableName:
+		return db.oidcTableOptions, nil
 	default:
 		return nil, errors.New(fmt.Sprintf("Invalid tableName, can't find setup options for table [%s]", tableName))
 	}
@@ -243,6 +562,16 @@ func (db *RethinkDBAdapter) setTableSetupOptions(tableName string, opts *r.Table
 		db.usersTableOptions = opts
 	case db.apiKeysTableName:
 		db.apiKeysTableOptions = opts
+	case db.pgtsTableName:
+		db.pgtsTableOptions = opts
+	case db.ptsTableName:
+		db.ptsTableOptions = opts
+	case db.tgtsTableName:
+		db.tgtsTableOptions = opts
+	case db.auditTableName:
+		db.auditTableOptions = opts
+	case db.oidcTableName:
+		db.oidcTableOptions = opts
 	default:
 		return errors.New(fmt.Sprintf("Failed to set table setup options for table [%s]", tableName))
 	}
@@ -276,7 +605,7 @@ func (db *RethinkDBAdapter) LoadJSONFixture(dbName, tableName, path string) *CAS
 	if options != nil && options.PrimaryKey != nil {
 		importCmd.Args = append(importCmd.Args, "--pkey", options.PrimaryKey.(string))
 	}
-	logMessagef(db.LogLevel, "INFO", "Args: %v", importCmd.Args)
+	db.logger.Debug("Importing fixture", "table", tableName, "args", strings.Join(importCmd.Args, " "))
 
 	// Run the import command
 	output, err := importCmd.CombinedOutput()
@@ -292,9 +621,14 @@ func (db *RethinkDBAdapter) LoadJSONFixture(dbName, tableName, path string) *CAS
 
 // Clear all relevant databases and/or tables
 func (db *RethinkDBAdapter) Teardown() *CASServerError {
-	_, err := r.
-		DBDrop(db.dbName).
-		Run(db.session)
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	_, err := conn.Run(r.
+		DBDrop(db.dbName))
 	if err != nil {
 		casError := &FailedToTeardownDatabaseError
 		casError.err = &err
@@ -306,24 +640,55 @@ func (db *RethinkDBAdapter) Teardown() *CASServerError {
 
 // Find a service by given URL (callback URL)
 func (db *RethinkDBAdapter) FindServiceByUrl(serviceUrl string) (*CASService, *CASServerError) {
-	// Get the first service with the given name
-	cursor, err := r.DB(db.dbName).
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	// Get the first service with the given URL
+	cursor, err := conn.Run(r.DB(db.dbName).
 		Table(db.servicesTableName).
-		Filter(map[string]string{"url": serviceUrl}).
-		Run(db.session)
+		GetAllByIndex("url", serviceUrl))
 	if err != nil {
-		casErr := &FailedToLookupServiceByUrlError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToLookupServiceByUrlError, err)
 	}
 
 	// Create user object from the returned data cursor
 	var returnedService *CASService
 	err = cursor.One(&returnedService)
 	if err != nil {
-		casErr := &FailedToLookupServiceByUrlError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToLookupServiceByUrlError, err)
+	}
+
+	return returnedService, nil
+}
+
+// Find a service by its (immutable) ID
+func (db *RethinkDBAdapter) FindServiceById(id string) (*CASService, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	if len(id) == 0 {
+		return nil, &ServiceNotFoundError
+	}
+
+	cursor, err := conn.Run(r.DB(db.dbName).
+		Table(db.servicesTableName).
+		GetAllByIndex("id", id))
+	if err != nil {
+		return nil, queryError(&FailedToLookupServiceByUrlError, err)
+	}
+
+	var returnedService *CASService
+	err = cursor.One(&returnedService)
+	if err == r.ErrEmptyResult {
+		return nil, &ServiceNotFoundError
+	} else if err != nil {
+		return nil, queryError(&FailedToLookupServiceByUrlError, err)
 	}
 
 	return returnedService, nil
@@ -331,25 +696,26 @@ func (db *RethinkDBAdapter) FindServiceByUrl(serviceUrl string) (*CASService, *C
 
 // Find a user by email address ("username")
 func (db *RethinkDBAdapter) FindUserByEmail(email string) (*User, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
 	// Find the user
-	cursor, err := r.
+	cursor, err := conn.Run(r.
 		DB(db.dbName).
 		Table(db.usersTableName).
-		Get(email).
-		Run(db.session)
+		Get(email))
 	if err != nil {
-		casErr := &FailedToFindUserByEmailError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToFindUserByEmailError, err)
 	}
 
 	// Get the user from the returned cursor
 	var returnedUser *User
 	err = cursor.One(&returnedUser)
 	if err != nil {
-		casErr := &FailedToFindUserByEmailError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToFindUserByEmailError, err)
 	}
 
 	return returnedUser, nil
@@ -357,29 +723,30 @@ func (db *RethinkDBAdapter) FindUserByEmail(email string) (*User, *CASServerErro
 
 // Find a user by API secret and key
 func (db *RethinkDBAdapter) FindUserByApiKeyAndSecret(key, secret string) (*User, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
 	// Find the user
-	cursor, err := r.
+	cursor, err := conn.Run(r.
 		DB(db.dbName).
 		Table(db.apiKeysTableName).
-		Get(key).
-		Run(db.session)
+		Get(key))
 	if err != nil {
-		casErr := &FailedToFindUserByApiKeyAndSecretError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToFindUserByApiKeyAndSecretError, err)
 	}
 
 	// Get the user from the returned cursor
 	var apiKeyPair *CasgoAPIKeyPair
 	err = cursor.One(&apiKeyPair)
 	if err != nil {
-		casErr := &FailedToFindUserByApiKeyAndSecretError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToFindUserByApiKeyAndSecretError, err)
 	}
 
 	// Return error of the secret is invalid
-	if apiKeyPair.Secret != secret {
+	if !SecretsEqual(secret, apiKeyPair.Secret) {
 		casErr := &FailedToFindUserByApiKeyAndSecretError
 		return nil, casErr
 	}
@@ -389,17 +756,22 @@ func (db *RethinkDBAdapter) FindUserByApiKeyAndSecret(key, secret string) (*User
 
 // Add a new user to the database
 func (db *RethinkDBAdapter) AddNewUser(username, password string) (*User, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
 	user := &User{
 		Email:    username,
 		Password: password,
 	}
 
 	// Insert user into database
-	res, err := r.
+	res, err := conn.RunWrite(r.
 		DB(db.dbName).
 		Table(db.usersTableName).
-		Insert(user, r.InsertOpts{Conflict: "error"}).
-		RunWrite(db.session)
+		Insert(user, r.InsertOpts{Conflict: "error"}))
 	if err != nil || res.Inserted == 0 {
 		return nil, &FailedToCreateUserError
 	} else if res.Errors > 0 {
@@ -410,11 +782,20 @@ func (db *RethinkDBAdapter) AddNewUser(username, password string) (*User, *CASSe
 }
 
 func (db *RethinkDBAdapter) AddNewService(service *CASService) *CASServerError {
-	res, err := r.
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	if casErr := service.ensureId(); casErr != nil {
+		return casErr
+	}
+
+	res, err := conn.RunWrite(r.
 		DB(db.dbName).
 		Table(db.servicesTableName).
-		Insert(service, r.InsertOpts{Conflict: "error"}).
-		RunWrite(db.session)
+		Insert(service, r.InsertOpts{Conflict: "error"}))
 	if err != nil || res.Inserted == 0 {
 		return &FailedToCreateServiceError
 	} else if res.Errors > 0 {
@@ -431,18 +812,21 @@ func (db *RethinkDBAdapter) AddNewService(service *CASService) *CASServerError {
 
 // Add new CASTicket to the database for the given service
 func (db *RethinkDBAdapter) AddTicketForService(ticket *CASTicket, service *CASService) (*CASTicket, *CASServerError) {
-	res, err := r.
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
 		DB(db.dbName).
 		Table(db.ticketsTableName).
-		Insert(ticket).
-		RunWrite(db.session)
-	if err != nil || res.Errors > 0 || len(res.GeneratedKeys) == 0 {
-		casErr := &FailedToCreateTicketError
-		casErr.err = &err
-		return nil, casErr
+		Insert(ticket))
+	if err != nil || res.Errors > 0 || res.Inserted == 0 {
+		return nil, queryError(&FailedToCreateTicketError, err)
 	}
 
-	// Update the passed in ticket with the ID that was given by the database
+	// Update the passed in ticket with the ID that was given by the database (if it did not have one)
 	if len(res.GeneratedKeys) > 0 {
 		ticket.Id = res.GeneratedKeys[0]
 	}
@@ -452,41 +836,503 @@ func (db *RethinkDBAdapter) AddTicketForService(ticket *CASTicket, service *CASS
 
 // Find ticket by Id for a given service
 func (db *RethinkDBAdapter) FindTicketByIdForService(ticketId string, service *CASService) (*CASTicket, *CASServerError) {
-	cursor, err := r.
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
 		DB(db.dbName).
 		Table(db.ticketsTableName).
-		Get(ticketId).
-		Run(db.session)
+		Get(ticketId))
 	if err != nil || cursor.IsNil() {
-		casErr := &FailedToFindTicketError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToFindTicketError, err)
 	}
 
 	// Create CASTicket from result
 	var returnedTicket *CASTicket
 	err = cursor.One(&returnedTicket)
 	if err != nil {
-		casErr := &FailedToFindTicketError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToFindTicketError, err)
+	}
+
+	return returnedTicket, nil
+}
+
+// Mark a ticket as having been validated by its service, unless it already was
+// The check and update are made atomically by the database, so only one of concurrent validations succeeds
+// (tickets that were already validated are left unchanged, and may be validated again within the reuse window)
+func (db *RethinkDBAdapter) ConsumeTicket(ticketId string, now time.Time, reuseWindow time.Duration) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.ticketsTableName).
+		Get(ticketId).
+		Update(r.Branch(
+			r.Row.Field("validated").Default(false),
+			r.Row,
+			map[string]interface{}{"validated": true, "validatedAt": now},
+		)))
+	if err != nil || res.Skipped > 0 {
+		return queryError(&FailedToUpdateTicketError, err)
+	}
+	if res.Replaced > 0 {
+		return nil
+	}
+
+	// Already validated, re-validations within the reuse window don't change the ticket (so need not be atomic)
+	if reuseWindow > 0 {
+		cursor, err := conn.Run(r.
+			DB(db.dbName).
+			Table(db.ticketsTableName).
+			Get(ticketId))
+		var ticket *CASTicket
+		if err != nil || cursor.IsNil() || cursor.One(&ticket) != nil {
+			return queryError(&FailedToUpdateTicketError, err)
+		}
+		if now.Before(ticket.ValidatedAt.Add(reuseWindow)) {
+			return nil
+		}
+	}
+	return &TicketAlreadyValidatedError
+}
+
+// Find all validated tickets issued under a given ticket-granting ticket
+func (db *RethinkDBAdapter) FindValidatedTicketsForTGT(tgtId string) ([]CASTicket, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.ticketsTableName).
+		GetAllByIndex("tgtId", tgtId).
+		Filter(map[string]interface{}{"validated": true}))
+	if err != nil {
+		return nil, queryError(&FailedToFindTicketError, err)
+	}
+
+	var tickets []CASTicket
+	err = cursor.All(&tickets)
+	if err != nil {
+		return nil, queryError(&FailedToFindTicketError, err)
+	}
+
+	return tickets, nil
+}
+
+// Find all tickets issued under a given ticket-granting ticket
+func (db *RethinkDBAdapter) FindTicketsForTGT(tgtId string) ([]CASTicket, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.ticketsTableName).
+		GetAllByIndex("tgtId", tgtId))
+	if err != nil {
+		return nil, queryError(&FailedToFindTicketError, err)
+	}
+
+	var tickets []CASTicket
+	err = cursor.All(&tickets)
+	if err != nil {
+		return nil, queryError(&FailedToFindTicketError, err)
+	}
+
+	return tickets, nil
+}
+
+// Add a new ticket-granting ticket to the database
+func (db *RethinkDBAdapter) AddTicketGrantingTicket(tgt *CASTicketGrantingTicket) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.tgtsTableName).
+		Insert(tgt, r.InsertOpts{Conflict: "error"}))
+	if err != nil || res.Errors > 0 || res.Inserted == 0 {
+		return queryError(&FailedToCreateTicketGrantingTicketError, err)
+	}
+
+	return nil
+}
+
+// Find ticket-granting ticket by Id
+func (db *RethinkDBAdapter) FindTicketGrantingTicketById(tgtId string) (*CASTicketGrantingTicket, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.tgtsTableName).
+		Get(tgtId))
+	if err != nil || cursor.IsNil() {
+		return nil, queryError(&FailedToFindTicketGrantingTicketError, err)
+	}
+
+	var returnedTicket *CASTicketGrantingTicket
+	err = cursor.One(&returnedTicket)
+	if err != nil {
+		return nil, queryError(&FailedToFindTicketGrantingTicketError, err)
+	}
+
+	return returnedTicket, nil
+}
+
+// Find all ticket-granting tickets (login sessions) of a user
+func (db *RethinkDBAdapter) FindTicketGrantingTicketsForUser(email string) ([]CASTicketGrantingTicket, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.tgtsTableName).
+		GetAllByIndex("userEmail", email))
+	if err != nil {
+		return nil, queryError(&FailedToListTicketGrantingTicketsError, err)
+	}
+
+	var tgts []CASTicketGrantingTicket
+	err = cursor.All(&tgts)
+	if err != nil {
+		return nil, queryError(&FailedToListTicketGrantingTicketsError, err)
+	}
+
+	return tgts, nil
+}
+
+// Update a ticket-granting ticket (by Id)
+func (db *RethinkDBAdapter) UpdateTicketGrantingTicket(tgt *CASTicketGrantingTicket) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.tgtsTableName).
+		Get(tgt.Id).
+		Update(tgt))
+	if err != nil || res.Errors > 0 || res.Skipped > 0 {
+		return queryError(&FailedToUpdateTicketGrantingTicketError, err)
+	}
+
+	return nil
+}
+
+// Remove a ticket-granting ticket by Id
+func (db *RethinkDBAdapter) RemoveTicketGrantingTicketById(tgtId string) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	_, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.tgtsTableName).
+		Get(tgtId).
+		Delete())
+	if err != nil {
+		return queryError(&FailedToDeleteTicketGrantingTicketError, err)
+	}
+
+	return nil
+}
+
+// Remove tickets that expired at or before the given time
+// Only tickets whose expiry has passed are matched, so tickets issued while sweeping are never removed
+func (db *RethinkDBAdapter) RemoveExpiredTickets(now time.Time) (SweepResult, *CASServerError) {
+	result := SweepResult{}
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return result, connErr
+	}
+	defer db.release(conn)
+
+	expired := r.Row.Field("expiresAt").Le(now)
+
+	// Find expired ticket-granting tickets first, so the service tickets issued under them can be removed too
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.tgtsTableName).
+		Filter(expired).
+		Pluck("id"))
+	if err != nil {
+		return result, queryError(&FailedToRemoveExpiredTicketsError, err)
+	}
+	var expiredTgts []CASTicketGrantingTicket
+	if err = cursor.All(&expiredTgts); err != nil {
+		return result, queryError(&FailedToRemoveExpiredTicketsError, err)
+	}
+
+	if len(expiredTgts) > 0 {
+		tgtIds := make([]interface{}, len(expiredTgts))
+		for i, tgt := range expiredTgts {
+			tgtIds[i] = tgt.Id
+		}
+
+		res, err := conn.RunWrite(r.
+			DB(db.dbName).
+			Table(db.tgtsTableName).
+			GetAll(tgtIds...).
+			Filter(expired).
+			Delete())
+		if err != nil {
+			return result, queryError(&FailedToRemoveExpiredTicketsError, err)
+		}
+		result.TicketGrantingTickets = res.Deleted
+
+		res, err = conn.RunWrite(r.
+			DB(db.dbName).
+			Table(db.ticketsTableName).
+			GetAllByIndex("tgtId", tgtIds...).
+			Delete())
+		if err != nil {
+			return result, queryError(&FailedToRemoveExpiredTicketsError, err)
+		}
+		result.ServiceTickets = res.Deleted
+	}
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.pgtsTableName).
+		Filter(expired).
+		Delete())
+	if err != nil {
+		return result, queryError(&FailedToRemoveExpiredTicketsError, err)
+	}
+	result.ProxyGrantingTickets = res.Deleted
+
+	res, err = conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.ptsTableName).
+		Filter(expired).
+		Delete())
+	if err != nil {
+		return result, queryError(&FailedToRemoveExpiredTicketsError, err)
+	}
+	result.ProxyTickets = res.Deleted
+
+	res, err = conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.oidcTableName).
+		Filter(expired).
+		Delete())
+	if err != nil {
+		return result, queryError(&FailedToRemoveExpiredTicketsError, err)
+	}
+	result.OIDCCodes = res.Deleted
+
+	return result, nil
+}
+
+// Add a new proxy-granting ticket to the database
+func (db *RethinkDBAdapter) AddProxyGrantingTicket(pgt *CASProxyGrantingTicket) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.pgtsTableName).
+		Insert(pgt, r.InsertOpts{Conflict: "error"}))
+	if err != nil || res.Errors > 0 || res.Inserted == 0 {
+		return queryError(&FailedToCreateProxyGrantingTicketError, err)
+	}
+
+	return nil
+}
+
+// Find proxy-granting ticket by Id
+func (db *RethinkDBAdapter) FindProxyGrantingTicketById(pgtId string) (*CASProxyGrantingTicket, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.pgtsTableName).
+		Get(pgtId))
+	if err != nil || cursor.IsNil() {
+		return nil, queryError(&FailedToFindProxyGrantingTicketError, err)
+	}
+
+	var returnedTicket *CASProxyGrantingTicket
+	err = cursor.One(&returnedTicket)
+	if err != nil {
+		return nil, queryError(&FailedToFindProxyGrantingTicketError, err)
 	}
 
 	return returnedTicket, nil
 }
 
+// Add a new proxy ticket to the database
+func (db *RethinkDBAdapter) AddProxyTicket(pt *CASProxyTicket) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.ptsTableName).
+		Insert(pt, r.InsertOpts{Conflict: "error"}))
+	if err != nil || res.Errors > 0 || res.Inserted == 0 {
+		return queryError(&FailedToCreateProxyTicketError, err)
+	}
+
+	return nil
+}
+
+// Add a new OpenID Connect authorization code to the database
+func (db *RethinkDBAdapter) AddOIDCAuthorizationCode(code *OIDCAuthorizationCode) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.oidcTableName).
+		Insert(code, r.InsertOpts{Conflict: "error"}))
+	if err != nil || res.Errors > 0 || res.Inserted == 0 {
+		return queryError(&FailedToCreateOIDCCodeError, err)
+	}
+
+	return nil
+}
+
+// Remove an OpenID Connect authorization code, returning it (nil if there was none)
+// Only the redemption whose delete removed the code gets it, so concurrent redemptions (on any node) get it at most once
+func (db *RethinkDBAdapter) ConsumeOIDCAuthorizationCode(code string) (*OIDCAuthorizationCode, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.oidcTableName).
+		Get(code))
+	if err != nil {
+		return nil, queryError(&FailedToConsumeOIDCCodeError, err)
+	}
+	if cursor.IsNil() {
+		return nil, nil
+	}
+	var authorization *OIDCAuthorizationCode
+	if err = cursor.One(&authorization); err != nil {
+		return nil, queryError(&FailedToConsumeOIDCCodeError, err)
+	}
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.oidcTableName).
+		Get(code).
+		Delete())
+	if err != nil {
+		return nil, queryError(&FailedToConsumeOIDCCodeError, err)
+	}
+	if res.Deleted == 0 {
+		return nil, nil
+	}
+	return authorization, nil
+}
+
+// Find proxy ticket by Id
+func (db *RethinkDBAdapter) FindProxyTicketById(ptId string) (*CASProxyTicket, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.ptsTableName).
+		Get(ptId))
+	if err != nil || cursor.IsNil() {
+		return nil, queryError(&FailedToFindProxyTicketError, err)
+	}
+
+	var returnedTicket *CASProxyTicket
+	err = cursor.One(&returnedTicket)
+	if err != nil {
+		return nil, queryError(&FailedToFindProxyTicketError, err)
+	}
+
+	return returnedTicket, nil
+}
+
+// Remove a proxy ticket once it has been validated
+// Only the validation whose delete removed the ticket succeeds, so concurrent validations (on any node) succeed at most once
+func (db *RethinkDBAdapter) ConsumeProxyTicket(ptId string) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.ptsTableName).
+		Get(ptId).
+		Delete())
+	if err != nil {
+		return queryError(&FailedToConsumeProxyTicketError, err)
+	}
+	if res.Deleted == 0 {
+		return &FailedToFindProxyTicketError
+	}
+	return nil
+}
+
 // Remove tickets for a given user under a given service
 func (db *RethinkDBAdapter) RemoveTicketsForUserWithService(email string, service *CASService) *CASServerError {
-	_, err := r.
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	_, err := conn.Run(r.
 		DB(db.dbName).
 		Table(db.ticketsTableName).
-		Filter(map[string]string{"userEmail": email}).
-		Delete().
-		Run(db.session)
+		GetAllByIndex("userEmail", email).
+		Delete())
 	if err != nil {
-		casErr := &FailedToDeleteTicketsForUserError
-		casErr.err = &err
-		return casErr
+		return queryError(&FailedToDeleteTicketsForUserError, err)
 	}
 
 	return nil
@@ -494,20 +1340,23 @@ func (db *RethinkDBAdapter) RemoveTicketsForUserWithService(email string, servic
 
 // Remove a service by name (pkey)
 func (db *RethinkDBAdapter) RemoveServiceByName(name string) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
 	if len(name) == 0 {
 		return &InvalidServiceNameError
 	}
 
-	_, err := r.
+	_, err := conn.Run(r.
 		DB(db.dbName).
 		Table(db.servicesTableName).
 		Get(name).
-		Delete().
-		Run(db.session)
+		Delete())
 	if err != nil {
-		casErr := &FailedToDeleteServiceError
-		casErr.err = &err
-		return casErr
+		return queryError(&FailedToDeleteServiceError, err)
 	}
 
 	return nil
@@ -515,20 +1364,23 @@ func (db *RethinkDBAdapter) RemoveServiceByName(name string) *CASServerError {
 
 // Remove a user by email (pkey)
 func (db *RethinkDBAdapter) RemoveUserByEmail(email string) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
 	if len(email) == 0 {
 		return &InvalidUserEmailError
 	}
 
-	_, err := r.
+	_, err := conn.Run(r.
 		DB(db.dbName).
 		Table(db.usersTableName).
 		Get(email).
-		Delete().
-		Run(db.session)
+		Delete())
 	if err != nil {
-		casErr := &FailedToDeleteUserError
-		casErr.err = &err
-		return casErr
+		return queryError(&FailedToDeleteUserError, err)
 	}
 
 	return nil
@@ -536,20 +1388,62 @@ func (db *RethinkDBAdapter) RemoveUserByEmail(email string) *CASServerError {
 
 // Update service with a similar name to the passed in service (key)
 func (db *RethinkDBAdapter) UpdateService(service *CASService) *CASServerError {
+	return db.updateService(service, nil)
+}
+
+// Update service with a similar name to the passed in service (key), if it is at the given version
+func (db *RethinkDBAdapter) UpdateServiceIfVersion(service *CASService, version int) *CASServerError {
+	return db.updateService(service, &version)
+}
+
+// Update a service, if it is at the expected version (any version if nil)
+// The version check and update are done in one query, so concurrent updates of a version can't both succeed
+func (db *RethinkDBAdapter) updateService(service *CASService, version *int) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
 	if len(service.Name) == 0 {
 		return &InvalidServiceNameError
 	}
 
-	res, err := r.
+	// Service IDs never change (services created before they had IDs are given one)
+	unassigned := CASService{}
+	if casErr := unassigned.ensureId(); casErr != nil {
+		return casErr
+	}
+
+	storedVersion := r.Row.Field("version").Default(0)
+	update := r.Expr(service).Without("id", "version").Merge(map[string]interface{}{
+		"id":      r.Row.Field("id").Default(unassigned.Id),
+		"version": storedVersion.Add(1),
+	})
+	if version != nil {
+		// Services at other versions are left unchanged (every update changes the version, so only they can be)
+		update = r.Branch(storedVersion.Eq(*version), update, r.Row)
+	}
+
+	res, err := conn.RunWrite(r.
 		DB(db.dbName).
 		Table(db.servicesTableName).
 		Get(service.Name).
-		Update(service, r.UpdateOpts{ReturnChanges: true}).
-		RunWrite(db.session)
+		Update(update, r.UpdateOpts{ReturnChanges: true}))
+	if err == nil && res.Unchanged > 0 {
+		return &ServiceVersionConflictError
+	}
 	if err != nil || res.Replaced == 0 || len(res.Changes) == 0 {
-		casErr := &FailedToUpdateServiceError
-		casErr.err = &err
-		return casErr
+		return queryError(&FailedToUpdateServiceError, err)
+	}
+
+	if updated, ok := res.Changes[0].NewValue.(map[string]interface{}); ok {
+		if id, ok := updated["id"].(string); ok {
+			service.Id = id
+		}
+		if version, ok := updated["version"].(float64); ok {
+			service.Version = int(version)
+		}
 	}
 
 	return nil
@@ -557,43 +1451,91 @@ func (db *RethinkDBAdapter) UpdateService(service *CASService) *CASServerError {
 
 // Update user with a similar name to the passed in user (key)
 func (db *RethinkDBAdapter) UpdateUser(user *User) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
 	if len(user.Email) == 0 {
 		return &InvalidUserEmailError
 	}
 
-	res, err := r.
+	res, err := conn.RunWrite(r.
 		DB(db.dbName).
 		Table(db.usersTableName).
 		Get(user.Email).
-		Update(user, r.UpdateOpts{ReturnChanges: true}).
-		RunWrite(db.session)
+		Update(user, r.UpdateOpts{ReturnChanges: true}))
 	if err != nil || res.Replaced == 0 || len(res.Changes) == 0 {
-		casErr := &FailedToUpdateUserError
-		casErr.err = &err
-		return casErr
+		return queryError(&FailedToUpdateUserError, err)
 	}
 
 	return nil
 }
 
+// Find a page of services, filtering (and paging) in the database
+func (db *RethinkDBAdapter) FindServices(query CASServiceQuery) ([]CASService, int, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, 0, connErr
+	}
+	defer db.release(conn)
+
+	matching := r.DB(db.dbName).Table(db.servicesTableName)
+	if len(query.Name) > 0 {
+		matching = matching.Filter(r.Row.Field("name").Downcase().Match(regexp.QuoteMeta(strings.ToLower(query.Name))))
+	}
+
+	// Count all matching services
+	cursor, err := conn.Run(matching.Count())
+	if err != nil {
+		return nil, 0, queryError(&FailedToListServicesError, err)
+	}
+
+	var total int
+	err = cursor.One(&total)
+	if err != nil {
+		return nil, 0, queryError(&FailedToListServicesError, err)
+	}
+
+	// Get the requested page
+	page := matching.OrderBy("name").Skip(query.Offset)
+	if query.Limit > 0 {
+		page = page.Limit(query.Limit)
+	}
+	cursor, err = conn.Run(page)
+	if err != nil {
+		return nil, 0, queryError(&FailedToListServicesError, err)
+	}
+
+	services := []CASService{}
+	err = cursor.All(&services)
+	if err != nil {
+		return nil, 0, queryError(&FailedToListServicesError, err)
+	}
+
+	return services, total, nil
+}
+
 // Get all services
 func (db *RethinkDBAdapter) GetAllServices() ([]CASService, *CASServerError) {
-	cursor, err := r.
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
 		DB(db.dbName).
-		Table(db.servicesTableName).
-		Run(db.session)
+		Table(db.servicesTableName))
 	if err != nil {
-		casErr := &FailedToListServicesError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToListServicesError, err)
 	}
 
 	var services []CASService
 	err = cursor.All(&services)
 	if err != nil {
-		casErr := &FailedToListServicesError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToListServicesError, err)
 	}
 
 	return services, nil
@@ -601,24 +1543,134 @@ func (db *RethinkDBAdapter) GetAllServices() ([]CASService, *CASServerError) {
 
 // Get all users
 func (db *RethinkDBAdapter) GetAllUsers() ([]User, *CASServerError) {
-	cursor, err := r.
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
 		DB(db.dbName).
 		Table(db.usersTableName).
-		Without("password").
-		Run(db.session)
+		Without("password"))
 	if err != nil {
-		casErr := &FailedToListUsersError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToListUsersError, err)
 	}
 
 	var users []User
 	err = cursor.All(&users)
 	if err != nil {
-		casErr := &FailedToListUsersError
-		casErr.err = &err
-		return nil, casErr
+		return nil, queryError(&FailedToListUsersError, err)
 	}
 
 	return users, nil
 }
+
+// Find a page of users (without their passwords), filtering (and paging) in the database
+func (db *RethinkDBAdapter) FindUsers(query CASUserQuery) ([]User, int, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, 0, connErr
+	}
+	defer db.release(conn)
+
+	matching := r.DB(db.dbName).Table(db.usersTableName)
+	if len(query.Email) > 0 {
+		matching = matching.Filter(r.Row.Field("email").Downcase().Match(regexp.QuoteMeta(strings.ToLower(query.Email))))
+	}
+	switch query.Role {
+	case USER_ROLE_ADMIN:
+		matching = matching.Filter(r.Row.Field("isAdmin").Default(false).Eq(true))
+	case USER_ROLE_REGULAR:
+		matching = matching.Filter(r.Row.Field("isAdmin").Default(false).Eq(false))
+	}
+
+	// Count all matching users
+	cursor, err := conn.Run(matching.Count())
+	if err != nil {
+		return nil, 0, queryError(&FailedToListUsersError, err)
+	}
+
+	var total int
+	err = cursor.One(&total)
+	if err != nil {
+		return nil, 0, queryError(&FailedToListUsersError, err)
+	}
+
+	// Get the requested page
+	page := matching.OrderBy("email").Skip(query.Offset)
+	if query.Limit > 0 {
+		page = page.Limit(query.Limit)
+	}
+	cursor, err = conn.Run(page.Without("password"))
+	if err != nil {
+		return nil, 0, queryError(&FailedToListUsersError, err)
+	}
+
+	users := []User{}
+	err = cursor.All(&users)
+	if err != nil {
+		return nil, 0, queryError(&FailedToListUsersError, err)
+	}
+
+	return users, total, nil
+}
+
+// Add audit events to the database (events are never updated or removed)
+func (db *RethinkDBAdapter) AddAuditEvents(events []AuditEvent) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.auditTableName).
+		Insert(events, r.InsertOpts{Conflict: "error"}))
+	if err != nil || res.Errors > 0 {
+		return queryError(&FailedToRecordAuditEventsError, err)
+	}
+
+	return nil
+}
+
+// Find audit events matching a query, most recent first
+func (db *RethinkDBAdapter) FindAuditEvents(query AuditEventQuery) ([]AuditEvent, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return nil, connErr
+	}
+	defer db.release(conn)
+
+	matching := r.DB(db.dbName).Table(db.auditTableName)
+	if !query.Since.IsZero() {
+		matching = matching.Filter(r.Row.Field("time").Ge(query.Since))
+	}
+	if !query.Until.IsZero() {
+		matching = matching.Filter(r.Row.Field("time").Lt(query.Until))
+	}
+	if len(query.Actor) > 0 {
+		matching = matching.Filter(r.Row.Field("actor").Eq(query.Actor))
+	}
+	if len(query.Type) > 0 {
+		matching = matching.Filter(r.Row.Field("type").Eq(query.Type))
+	}
+
+	page := matching.OrderBy(r.Desc("time"))
+	if query.Limit > 0 {
+		page = page.Limit(query.Limit)
+	}
+	cursor, err := conn.Run(page)
+	if err != nil {
+		return nil, queryError(&FailedToFindAuditEventsError, err)
+	}
+
+	events := []AuditEvent{}
+	err = cursor.All(&events)
+	if err != nil {
+		return nil, queryError(&FailedToFindAuditEventsError, err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,230 @@
+package cas
+
+import (
+	r "github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/dancannon/gorethink"
+	"time"
+)
+
+/*
+ * Schema migrations of the RethinkDB adapter
+ */
+
+// Applied migration, as recorded in the migrations table
+type rethinkDBMigrationRecord struct {
+	Version   int       `gorethink:"id"`
+	Name      string    `gorethink:"name"`
+	AppliedAt time.Time `gorethink:"appliedAt"`
+}
+
+// Migrations creating the database and its tables (tables added by new features get their own migration)
+func (db *RethinkDBAdapter) Migrations() []Migration {
+	return []Migration{
+		{Version: 1, Name: "create_database", Apply: db.ensureDatabase},
+		{Version: 2, Name: "create_core_tables", Apply: func() *CASServerError {
+			return db.ensureTables(db.servicesTableName, db.ticketsTableName, db.usersTableName, db.apiKeysTableName)
+		}},
+		{Version: 3, Name: "create_proxy_ticket_tables", Apply: func() *CASServerError {
+			return db.ensureTables(db.pgtsTableName, db.ptsTableName)
+		}},
+		{Version: 4, Name: "create_ticket_granting_tickets_table", Apply: func() *CASServerError {
+			return db.ensureTables(db.tgtsTableName)
+		}},
+		{Version: 5, Name: "create_audit_events_table", Apply: func() *CASServerError {
+			return db.ensureTables(db.auditTableName)
+		}},
+		{Version: 6, Name: "create_lookup_indexes", Apply: db.ensureLookupIndexes},
+		{Version: 7, Name: "create_oidc_authorization_codes_table", Apply: func() *CASServerError {
+			return db.ensureTables(db.oidcTableName)
+		}},
+	}
+}
+
+// Secondary index of a table, on the field it is named after
+type rethinkDBIndex struct {
+	table string
+	field string
+}
+
+// Indexes of the fields documents are looked up by (other than their primary keys), queried with GetAllByIndex
+func (db *RethinkDBAdapter) lookupIndexes() []rethinkDBIndex {
+	return []rethinkDBIndex{
+		{db.servicesTableName, "url"},
+		{db.servicesTableName, "id"},
+		{db.ticketsTableName, "tgtId"},
+		{db.ticketsTableName, "userEmail"},
+		{db.tgtsTableName, "userEmail"},
+	}
+}
+
+// Create the lookup indexes that don't exist yet, waiting for them to be ready
+func (db *RethinkDBAdapter) ensureLookupIndexes() *CASServerError {
+	for _, index := range db.lookupIndexes() {
+		if casErr := db.ensureIndex(index.table, index.field); casErr != nil {
+			return casErr
+		}
+	}
+	return nil
+}
+
+// Create an index of a table on a field (named after the field) unless it already exists, waiting for it to be ready
+func (db *RethinkDBAdapter) ensureIndex(tableName, field string) *CASServerError {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	table := r.DB(db.dbName).Table(tableName)
+	cursor, err := conn.Run(table.IndexList())
+	if err != nil {
+		return queryError(&FailedToCreateIndexError, err)
+	}
+	var indexes []string
+	if err = cursor.All(&indexes); err != nil {
+		return queryError(&FailedToCreateIndexError, err)
+	}
+	for _, index := range indexes {
+		if index == field {
+			return nil
+		}
+	}
+
+	db.logger.Info("Creating index", "table", tableName, "index", field)
+	if _, err = conn.Run(table.IndexCreate(field)); err != nil {
+		return queryError(&FailedToCreateIndexError, err)
+	}
+	if _, err = conn.Run(table.IndexWait(field)); err != nil {
+		return queryError(&FailedToCreateIndexError, err)
+	}
+	return nil
+}
+
+// Version of the latest migration recorded in the migrations table (0 if the database or the table don't exist yet)
+func (db *RethinkDBAdapter) SchemaVersion() (int, *CASServerError) {
+	exists, casErr := db.DbExists()
+	if casErr != nil || !exists {
+		return 0, casErr
+	}
+	exists, casErr = db.tableExists(db.migrationsTableName)
+	if casErr != nil || !exists {
+		return 0, casErr
+	}
+
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return 0, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		Table(db.migrationsTableName))
+	if err != nil {
+		return 0, queryError(&FailedToMigrateDatabaseError, err)
+	}
+
+	var records []rethinkDBMigrationRecord
+	if err = cursor.All(&records); err != nil {
+		return 0, queryError(&FailedToMigrateDatabaseError, err)
+	}
+
+	version := 0
+	for _, record := range records {
+		if record.Version > version {
+			version = record.Version
+		}
+	}
+	return version, nil
+}
+
+// Record an applied migration in the migrations table (created along with the first one)
+func (db *RethinkDBAdapter) RecordMigration(migration Migration, appliedAt time.Time) *CASServerError {
+	if casErr := db.ensureTables(db.migrationsTableName); casErr != nil {
+		return casErr
+	}
+
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	record := rethinkDBMigrationRecord{Version: migration.Version, Name: migration.Name, AppliedAt: appliedAt}
+	res, err := conn.RunWrite(r.
+		DB(db.dbName).
+		Table(db.migrationsTableName).
+		Insert(record, r.InsertOpts{Conflict: "replace"}))
+	if err != nil || res.Errors > 0 {
+		return queryError(&FailedToMigrateDatabaseError, err)
+	}
+	return nil
+}
+
+// Create the database unless it already exists
+func (db *RethinkDBAdapter) ensureDatabase() *CASServerError {
+	exists, casErr := db.DbExists()
+	if casErr != nil || exists {
+		return casErr
+	}
+
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return connErr
+	}
+	defer db.release(conn)
+
+	if _, err := conn.Run(r.DBCreate(db.dbName)); err != nil {
+		return queryError(&FailedToSetupDatabaseError, err)
+	}
+	return nil
+}
+
+// Create the tables (with the options they are set up with) that don't exist yet
+func (db *RethinkDBAdapter) ensureTables(tableNames ...string) *CASServerError {
+	for _, tableName := range tableNames {
+		exists, casErr := db.tableExists(tableName)
+		if casErr != nil {
+			return casErr
+		}
+		if exists {
+			continue
+		}
+
+		if tableName == db.migrationsTableName {
+			casErr = db.setupTable(tableName, (*r.TableCreateOpts)(nil))
+		} else {
+			casErr = db.SetupTable(tableName)
+		}
+		if casErr != nil {
+			return casErr
+		}
+	}
+	return nil
+}
+
+// Check whether a table exists in the database
+func (db *RethinkDBAdapter) tableExists(tableName string) (bool, *CASServerError) {
+	conn, connErr := db.acquire()
+	if connErr != nil {
+		return false, connErr
+	}
+	defer db.release(conn)
+
+	cursor, err := conn.Run(r.
+		DB(db.dbName).
+		TableList())
+	if err != nil {
+		return false, queryError(&FailedToSetupTableError, err)
+	}
+
+	var tableNames []string
+	if err = cursor.All(&tableNames); err != nil {
+		return false, queryError(&FailedToSetupTableError, err)
+	}
+	for _, listedTable := range tableNames {
+		if listedTable == tableName {
+			return true, nil
+		}
+	}
+	return false, nil
+}
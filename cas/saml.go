@@ -0,0 +1,401 @@
+package cas
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * SAML 1.1 ticket validation (/samlValidate, CAS protocol 3.0 appendix), for services that expect SAML assertions
+ *
+ * Services POST a SOAP envelope carrying a SAML request whose AssertionArtifact is the service ticket, with the service
+ * URL as the TARGET query parameter, and get back a SAML response carrying an assertion about the user (and the
+ * attributes released to them), optionally signed (XML-DSig, enveloped RSA-SHA256 signature)
+ *
+ * Responses are built as XML already in exclusive canonical form (sorted attributes, no self-closed elements, namespaces
+ * declared where they are used), so the assertion's digest is computed over the bytes sent
+ */
+
+// Namespaces of SOAP, SAML 1.1 (logout requests use SAML 2.0) and XML-DSig elements
+const (
+	SOAP_ENVELOPE_NAMESPACE    = "http://schemas.xmlsoap.org/soap/envelope/"
+	SAML11_PROTOCOL_NAMESPACE  = "urn:oasis:names:tc:SAML:1.0:protocol"
+	SAML11_ASSERTION_NAMESPACE = "urn:oasis:names:tc:SAML:1.0:assertion"
+	XMLDSIG_NAMESPACE          = "http://www.w3.org/2000/09/xmldsig#"
+	SAML_CAS_ATTRIBUTE_NS      = "http://www.ja-sig.org/products/cas/" // Namespace of released attributes
+)
+
+// Algorithms of assertion signatures
+const (
+	XMLDSIG_EXC_C14N      = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	XMLDSIG_ENVELOPED     = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	XMLDSIG_RSA_SHA256    = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	XMLDSIG_DIGEST_SHA256 = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// Largest SOAP request accepted by /samlValidate
+const SAML_MAX_REQUEST_SIZE = 64 * 1024
+
+// SAML status codes validation failures are reported with, by CAS failure code (top-level code, then second-level code)
+var samlFailureStatusCodes = map[string][2]string{
+	CAS_INVALID_REQUEST:      {"samlp:Requester", ""},
+	CAS_INVALID_TICKET:       {"samlp:Requester", "samlp:RequestDenied"},
+	CAS_INVALID_SERVICE:      {"samlp:Requester", "samlp:RequestDenied"},
+	CAS_INTERNAL_ERROR:       {"samlp:Responder", ""},
+	CAS_BAD_PGT:              {"samlp:Requester", "samlp:RequestDenied"},
+	CAS_UNAUTHORIZED_SERVICE: {"samlp:Requester", "samlp:RequestDenied"},
+}
+
+// SOAP envelope of a /samlValidate request (elements are matched by local name, whatever their namespace prefix)
+type samlValidateEnvelope struct {
+	XMLName xml.Name
+	Request struct {
+		RequestID         string `xml:"RequestID,attr"`
+		AssertionArtifact string `xml:"AssertionArtifact"`
+	} `xml:"Body>Request"`
+}
+
+// Parse a /samlValidate SOAP request, returning its request ID and the ticket it carries (as its AssertionArtifact)
+func ParseSAMLValidateRequest(body io.Reader) (requestId, ticket string, err error) {
+	var envelope samlValidateEnvelope
+	decoder := xml.NewDecoder(io.LimitReader(body, SAML_MAX_REQUEST_SIZE))
+	if err := decoder.Decode(&envelope); err != nil {
+		return "", "", err
+	}
+	if envelope.XMLName.Local != "Envelope" || (envelope.XMLName.Space != SOAP_ENVELOPE_NAMESPACE && len(envelope.XMLName.Space) > 0) {
+		return "", "", errors.New("Expected a SOAP envelope")
+	}
+	ticket = strings.TrimSpace(envelope.Request.AssertionArtifact)
+	if len(ticket) == 0 {
+		return "", "", errors.New("Expected a SAML request with an AssertionArtifact")
+	}
+	return strings.TrimSpace(envelope.Request.RequestID), ticket, nil
+}
+
+// Signs SAML assertions (XML-DSig, RSA-SHA256)
+type SAMLSigner struct {
+	Key         *rsa.PrivateKey
+	Certificate []byte // DER encoded certificate of the key, included in signatures (nil if none was configured)
+}
+
+// Create the assertion signer specified by server configuration (samlSigningEnabled, samlSigningKeyFile & samlSigningCertFile)
+// Returns nil if assertions are not signed
+func NewSAMLSignerFromConfig(config map[string]string) (*SAMLSigner, error) {
+	enabled, err := configBool(config, "samlSigningEnabled")
+	if err != nil || !enabled {
+		return nil, err
+	}
+
+	keyFile := strings.TrimSpace(config["samlSigningKeyFile"])
+	if len(keyFile) == 0 {
+		return nil, errors.New("samlSigningKeyFile is required when samlSigningEnabled is set")
+	}
+	key, err := loadRSAPrivateKey("samlSigningKeyFile", keyFile)
+	if err != nil {
+		return nil, err
+	}
+	signer := &SAMLSigner{Key: key}
+
+	if certFile := strings.TrimSpace(config["samlSigningCertFile"]); len(certFile) > 0 {
+		contents, err := ioutil.ReadFile(certFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read samlSigningCertFile [%s], %v", certFile, err)
+		}
+		block, _ := pem.Decode(contents)
+		if block == nil {
+			return nil, fmt.Errorf("Invalid samlSigningCertFile [%s], expected a PEM encoded certificate", certFile)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid samlSigningCertFile [%s], %v", certFile, err)
+		}
+		if publicKey, ok := cert.PublicKey.(*rsa.PublicKey); !ok || publicKey.N.Cmp(key.N) != 0 || publicKey.E != key.E {
+			return nil, fmt.Errorf("Invalid samlSigningCertFile [%s], the certificate is not for samlSigningKeyFile", certFile)
+		}
+		signer.Certificate = block.Bytes
+	}
+	return signer, nil
+}
+
+// Sign an assertion (identified by its AssertionID), appending an enveloped signature to it
+func (s *SAMLSigner) sign(assertion *xmlNode, assertionId string) error {
+	digest := sha256.Sum256(assertion.canonical())
+
+	signedInfo := newXMLNode("ds:SignedInfo", "xmlns:ds", XMLDSIG_NAMESPACE).append(
+		newXMLNode("ds:CanonicalizationMethod", "Algorithm", XMLDSIG_EXC_C14N),
+		newXMLNode("ds:SignatureMethod", "Algorithm", XMLDSIG_RSA_SHA256),
+		newXMLNode("ds:Reference", "URI", "#"+assertionId).append(
+			newXMLNode("ds:Transforms").append(
+				newXMLNode("ds:Transform", "Algorithm", XMLDSIG_ENVELOPED),
+				newXMLNode("ds:Transform", "Algorithm", XMLDSIG_EXC_C14N),
+			),
+			newXMLNode("ds:DigestMethod", "Algorithm", XMLDSIG_DIGEST_SHA256),
+			newXMLNode("ds:DigestValue").withText(base64.StdEncoding.EncodeToString(digest[:])),
+		),
+	)
+	signedDigest := sha256.Sum256(signedInfo.canonical())
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, signedDigest[:])
+	if err != nil {
+		return err
+	}
+
+	keyInfo := newXMLNode("ds:KeyInfo").append(newXMLNode("ds:KeyValue").append(
+		newXMLNode("ds:RSAKeyValue").append(
+			newXMLNode("ds:Modulus").withText(base64.StdEncoding.EncodeToString(s.Key.N.Bytes())),
+			newXMLNode("ds:Exponent").withText(base64.StdEncoding.EncodeToString(big.NewInt(int64(s.Key.E)).Bytes())),
+		),
+	))
+	if s.Certificate != nil {
+		keyInfo.append(newXMLNode("ds:X509Data").append(
+			newXMLNode("ds:X509Certificate").withText(base64.StdEncoding.EncodeToString(s.Certificate)),
+		))
+	}
+
+	assertion.append(newXMLNode("ds:Signature", "xmlns:ds", XMLDSIG_NAMESPACE).append(
+		signedInfo,
+		newXMLNode("ds:SignatureValue").withText(base64.StdEncoding.EncodeToString(signature)),
+		keyInfo,
+	))
+	return nil
+}
+
+// Endpoint for validating service tickets, responding with a SAML 1.1 assertion
+func (c *CAS) HandleSAMLValidate(w http.ResponseWriter, req *http.Request) {
+	defer c.Metrics.ObserveRequest("/samlValidate", time.Now())
+
+	serviceUrl := strings.TrimSpace(req.URL.Query().Get("TARGET"))
+	logger := c.requestLogger(req).With("route", "/samlValidate", "service", serviceUrl)
+	db := c.backendFor(req)
+
+	// Malformed requests fail validation like requests without a ticket
+	requestId, ticket, err := ParseSAMLValidateRequest(req.Body)
+	if err != nil {
+		logger.Warn("Invalid SAML validation request", "error", err)
+	}
+
+	// SAML validation has no renew parameter of its own, one given alongside TARGET is honored
+	renew := strings.TrimSpace(strings.ToLower(req.URL.Query().Get("renew")))
+	casTicket, failureCode, casErr := c.validateServiceTicket(db, serviceUrl, ticket, renew, serviceSecretFromRequest(req))
+	c.Metrics.ObserveValidation("/samlValidate", casErr == nil)
+	c.auditValidation(req, "/samlValidate", casTicket, serviceUrl, casErr)
+
+	now := c.now().UTC()
+	response, err := c.newSAMLResponse(serviceUrl, requestId, now)
+	if err == nil {
+		if casErr != nil {
+			logger.Warn("Ticket validation failed", "error", casErr, "errorCode", casErr.CasgoErrCode)
+			response.append(samlStatus(samlFailureStatusCodes[failureCode], casErr.Msg))
+		} else {
+			logger.Info("Validated service ticket", "username", casTicket.UserEmail)
+			var assertion *xmlNode
+			if assertion, err = c.newSAMLAssertion(db, casTicket, serviceUrl, now); err == nil {
+				response.append(samlStatus([2]string{"samlp:Success", ""}, ""), assertion)
+			}
+		}
+	}
+	if err != nil {
+		logger.Error("Failed to build SAML response", "error", err)
+		http.Error(w, "Failed to build SAML response", http.StatusInternalServerError)
+		return
+	}
+
+	envelope := newXMLNode("SOAP-ENV:Envelope", "xmlns:SOAP-ENV", SOAP_ENVELOPE_NAMESPACE).append(
+		newXMLNode("SOAP-ENV:Header"),
+		newXMLNode("SOAP-ENV:Body").append(response),
+	)
+	w.Header().Set("Content-Type", "text/xml; charset=UTF-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	w.Write(envelope.canonical())
+}
+
+// SAML response (without its status) to a request for a service
+func (c *CAS) newSAMLResponse(serviceUrl, requestId string, now time.Time) (*xmlNode, error) {
+	responseId, err := newTicketId("_")
+	if err != nil {
+		return nil, err
+	}
+	response := newXMLNode("samlp:Response",
+		"xmlns:samlp", SAML11_PROTOCOL_NAMESPACE,
+		"IssueInstant", samlTime(now),
+		"MajorVersion", "1",
+		"MinorVersion", "1",
+		"Recipient", serviceUrl,
+		"ResponseID", responseId,
+	)
+	if len(requestId) > 0 {
+		response.attrs = append(response.attrs, xmlAttr{"InResponseTo", requestId})
+	}
+	return response, nil
+}
+
+// Status of a SAML response, with its (top-level and optional second-level) status codes
+func samlStatus(codes [2]string, message string) *xmlNode {
+	statusCode := newXMLNode("samlp:StatusCode", "Value", codes[0])
+	if len(codes[1]) > 0 {
+		statusCode.append(newXMLNode("samlp:StatusCode", "Value", codes[1]))
+	}
+	status := newXMLNode("samlp:Status").append(statusCode)
+	if len(message) > 0 {
+		status.append(newXMLNode("samlp:StatusMessage").withText(message))
+	}
+	return status
+}
+
+// Assertion that the user a ticket was issued to authenticated (signed if signing is enabled), releasing their attributes
+func (c *CAS) newSAMLAssertion(db Backend, ticket *CASTicket, serviceUrl string, now time.Time) (*xmlNode, error) {
+	assertionId, err := newTicketId("_")
+	if err != nil {
+		return nil, err
+	}
+
+	// Users authenticated when their session (ticket-granting ticket) was created
+	authenticatedAt := now
+	if tgt, casErr := db.FindTicketGrantingTicketById(ticket.TGTId); casErr == nil && !tgt.CreatedAt.IsZero() {
+		authenticatedAt = tgt.CreatedAt.UTC()
+	}
+
+	subject := func() *xmlNode {
+		return newXMLNode("Subject").append(
+			newXMLNode("NameIdentifier").withText(ticket.UserEmail),
+			newXMLNode("SubjectConfirmation").append(
+				newXMLNode("ConfirmationMethod").withText("urn:oasis:names:tc:SAML:1.0:cm:artifact"),
+			),
+		)
+	}
+
+	assertion := newXMLNode("Assertion",
+		"xmlns", SAML11_ASSERTION_NAMESPACE,
+		"AssertionID", assertionId,
+		"IssueInstant", samlTime(now),
+		"Issuer", c.GetAddr(),
+		"MajorVersion", "1",
+		"MinorVersion", "1",
+	).append(newXMLNode("Conditions",
+		"NotBefore", samlTime(now),
+		"NotOnOrAfter", samlTime(now.Add(configSecondsAsDuration(c.Config, "samlAssertionTTL"))),
+	).append(newXMLNode("AudienceRestrictionCondition").append(newXMLNode("Audience").withText(serviceUrl))))
+
+	attributes := c.releasedAttributes(db, serviceUrl, ticket.UserEmail, ticket.UserAttributes, true)
+	if len(attributes) > 0 {
+		names := make([]string, 0, len(attributes))
+		for name := range attributes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		statement := newXMLNode("AttributeStatement").append(subject())
+		for _, name := range names {
+			statement.append(newXMLNode("Attribute", "AttributeName", name, "AttributeNamespace", SAML_CAS_ATTRIBUTE_NS).append(
+				newXMLNode("AttributeValue").withText(attributes[name]),
+			))
+		}
+		assertion.append(statement)
+	}
+
+	assertion.append(newXMLNode("AuthenticationStatement",
+		"AuthenticationInstant", samlTime(authenticatedAt),
+		"AuthenticationMethod", "urn:oasis:names:tc:SAML:1.0:am:password",
+	).append(subject()))
+
+	if c.SAMLSigner != nil {
+		if err := c.SAMLSigner.sign(assertion, assertionId); err != nil {
+			return nil, err
+		}
+	}
+	return assertion, nil
+}
+
+// Time as a SAML (xsd:dateTime, UTC) instant
+func samlTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// Element of a built XML document (see canonical)
+type xmlNode struct {
+	name     string // Qualified name (ex. ds:Signature)
+	attrs    []xmlAttr
+	children []*xmlNode
+	text     string
+}
+
+type xmlAttr struct {
+	name  string // Qualified name, namespace declarations included (ex. xmlns:ds)
+	value string
+}
+
+// Create an element with attributes, given as name, value pairs
+func newXMLNode(name string, attrs ...string) *xmlNode {
+	node := &xmlNode{name: name}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		node.attrs = append(node.attrs, xmlAttr{attrs[i], attrs[i+1]})
+	}
+	return node
+}
+
+func (n *xmlNode) append(children ...*xmlNode) *xmlNode {
+	n.children = append(n.children, children...)
+	return n
+}
+
+func (n *xmlNode) withText(text string) *xmlNode {
+	n.text = text
+	return n
+}
+
+// Serialize the element in exclusive canonical form (http://www.w3.org/2001/10/xml-exc-c14n#)
+// Elements must declare the namespaces they use which their ancestors don't, and attributes must be unqualified
+func (n *xmlNode) canonical() []byte {
+	buf := &bytes.Buffer{}
+	n.writeCanonical(buf)
+	return buf.Bytes()
+}
+
+func (n *xmlNode) writeCanonical(buf *bytes.Buffer) {
+	// Namespace declarations come first (by prefix, the default namespace first), then attributes by name
+	attrs := append([]xmlAttr{}, n.attrs...)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		iNamespace, jNamespace := isNamespaceDeclaration(attrs[i].name), isNamespaceDeclaration(attrs[j].name)
+		if iNamespace != jNamespace {
+			return iNamespace
+		}
+		return attrs[i].name < attrs[j].name
+	})
+
+	buf.WriteString("<" + n.name)
+	for _, attr := range attrs {
+		buf.WriteString(" " + attr.name + `="` + canonicalAttrEscaper.Replace(attr.value) + `"`)
+	}
+	buf.WriteString(">")
+	buf.WriteString(canonicalTextEscaper.Replace(n.text))
+	for _, child := range n.children {
+		child.writeCanonical(buf)
+	}
+	buf.WriteString("</" + n.name + ">")
+}
+
+func isNamespaceDeclaration(name string) bool {
+	return name == "xmlns" || strings.HasPrefix(name, "xmlns:")
+}
+
+// Escaping of canonical XML text and attribute values
+var (
+	canonicalTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	canonicalAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
@@ -0,0 +1,12 @@
+package saml_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoSAML(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo SAML Suite")
+}
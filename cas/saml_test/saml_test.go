@@ -0,0 +1,266 @@
+package saml_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+// SOAP request validating a ticket
+func soapRequest(ticket string) string {
+	return `<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"><SOAP-ENV:Header/><SOAP-ENV:Body>` +
+		`<samlp:Request xmlns:samlp="urn:oasis:names:tc:SAML:1.0:protocol" MajorVersion="1" MinorVersion="1" RequestID="_192.168.16.51.1024506224022" IssueInstant="2002-06-19T17:03:44.022Z">` +
+		`<samlp:AssertionArtifact>` + ticket + `</samlp:AssertionArtifact></samlp:Request></SOAP-ENV:Body></SOAP-ENV:Envelope>`
+}
+
+// Parts of a SAML 1.1 response checked by the tests
+type samlEnvelope struct {
+	Response struct {
+		InResponseTo string `xml:"InResponseTo,attr"`
+		Recipient    string `xml:"Recipient,attr"`
+		Status       struct {
+			Code struct {
+				Value string `xml:"Value,attr"`
+				Sub   struct {
+					Value string `xml:"Value,attr"`
+				} `xml:"StatusCode"`
+			} `xml:"StatusCode"`
+			Message string `xml:"StatusMessage"`
+		} `xml:"Status"`
+		Assertion *struct {
+			AssertionID string `xml:"AssertionID,attr"`
+			Audience    string `xml:"Conditions>AudienceRestrictionCondition>Audience"`
+			Attributes  []struct {
+				Name  string `xml:"AttributeName,attr"`
+				Value string `xml:"AttributeValue"`
+			} `xml:"AttributeStatement>Attribute"`
+			NameIdentifier string `xml:"AuthenticationStatement>Subject>NameIdentifier"`
+			Signature      *struct {
+				Reference struct {
+					URI         string `xml:"URI,attr"`
+					DigestValue string `xml:"DigestValue"`
+				} `xml:"SignedInfo>Reference"`
+				Value       string `xml:"SignatureValue"`
+				Certificate string `xml:"KeyInfo>X509Data>X509Certificate"`
+			} `xml:"Signature"`
+		} `xml:"Assertion"`
+	} `xml:"Body>Response"`
+}
+
+// Write a key and a (self-signed) certificate for it to a directory, returning their paths
+func writeSigningKey(dir string, key *rsa.PrivateKey) (string, string) {
+	keyFile := filepath.Join(dir, "saml.key")
+	Expect(ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600)).To(Succeed())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "casgo"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).To(BeNil())
+	certFile := filepath.Join(dir, "saml.crt")
+	Expect(ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)).To(Succeed())
+	return keyFile, certFile
+}
+
+var _ = Describe("SAML request parsing", func() {
+	It("Should read the ticket and request ID of SOAP requests", func() {
+		requestId, ticket, err := ParseSAMLValidateRequest(strings.NewReader(soapRequest(" ST-1234 ")))
+		Expect(err).To(BeNil())
+		Expect(requestId).To(Equal("_192.168.16.51.1024506224022"))
+		Expect(ticket).To(Equal("ST-1234"))
+
+		// Namespace prefixes don't matter
+		_, ticket, err = ParseSAMLValidateRequest(strings.NewReader(
+			`<soap:Envelope xmlns:soap="http://schemas.xmlsoap.org/soap/envelope/"><soap:Body><Request xmlns="urn:oasis:names:tc:SAML:1.0:protocol"><AssertionArtifact>ST-5678</AssertionArtifact></Request></soap:Body></soap:Envelope>`,
+		))
+		Expect(err).To(BeNil())
+		Expect(ticket).To(Equal("ST-5678"))
+	})
+
+	It("Should refuse requests that are not SOAP requests carrying a ticket", func() {
+		for _, body := range []string{
+			"",
+			"ticket=ST-1234",
+			`<Envelope xmlns="urn:example"><Body><Request><AssertionArtifact>ST-1234</AssertionArtifact></Request></Body></Envelope>`,
+			soapRequest(""),
+			`<SOAP-ENV:Envelope xmlns:SOAP-ENV="http://schemas.xmlsoap.org/soap/envelope/"><SOAP-ENV:Body>`,
+		} {
+			_, _, err := ParseSAMLValidateRequest(strings.NewReader(body))
+			Expect(err).To(HaveOccurred())
+		}
+	})
+})
+
+var _ = Describe("SAML validation", func() {
+	var (
+		server  *CAS
+		db      *MemoryBackend
+		tempDir string
+	)
+
+	newServer := func(overrides map[string]string) {
+		server, db = castest.NewTestServer(overrides)
+		user, _ := db.FindUserByEmail("test@test.com")
+		user.Name = "Test User"
+		Expect(db.UpdateUser(user)).To(BeNil())
+	}
+
+	do := func(method, path, contentType, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", contentType)
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	// Log in to the test service, returning the service ticket issued
+	login := func() string {
+		w := castest.Login(server, url.Values{"serviceUrl": {testServiceUrl}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	validate := func(ticket string) (string, samlEnvelope) {
+		w := do("POST", "/samlValidate?"+url.Values{"TARGET": {testServiceUrl}}.Encode(), "text/xml", soapRequest(ticket))
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/xml"))
+
+		var envelope samlEnvelope
+		Expect(xml.Unmarshal(w.Body.Bytes(), &envelope)).To(Succeed())
+		return w.Body.String(), envelope
+	}
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "casgo-saml")
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			castest.Close(server)
+			server = nil
+		}
+		os.RemoveAll(tempDir)
+	})
+
+	It("Should respond to valid tickets with an assertion about the user and their attributes", func() {
+		newServer(nil)
+		body, envelope := validate(login())
+
+		response := envelope.Response
+		Expect(response.InResponseTo).To(Equal("_192.168.16.51.1024506224022"))
+		Expect(response.Recipient).To(Equal(testServiceUrl))
+		Expect(response.Status.Code.Value).To(Equal("samlp:Success"))
+
+		assertion := response.Assertion
+		Expect(assertion).NotTo(BeNil())
+		Expect(assertion.AssertionID).NotTo(BeEmpty())
+		Expect(assertion.Audience).To(Equal(testServiceUrl))
+		Expect(assertion.NameIdentifier).To(Equal("test@test.com"))
+		attributes := map[string]string{}
+		for _, attribute := range assertion.Attributes {
+			attributes[attribute.Name] = attribute.Value
+		}
+		Expect(attributes).To(HaveKeyWithValue("email", "test@test.com"))
+		Expect(attributes).To(HaveKeyWithValue("name", "Test User"))
+
+		// Unless signing is enabled, assertions are not signed
+		Expect(assertion.Signature).To(BeNil())
+		Expect(body).To(ContainSubstring(`<Assertion xmlns="urn:oasis:names:tc:SAML:1.0:assertion"`))
+	})
+
+	It("Should report tickets that fail validation in the response status", func() {
+		newServer(nil)
+		_, envelope := validate("ST-unknown")
+		Expect(envelope.Response.Status.Code.Value).To(Equal("samlp:Requester"))
+		Expect(envelope.Response.Status.Code.Sub.Value).To(Equal("samlp:RequestDenied"))
+		Expect(envelope.Response.Status.Message).To(Equal(FailedToFindTicketError.Msg))
+		Expect(envelope.Response.Assertion).To(BeNil())
+
+		// Requests without a ticket are invalid
+		w := do("POST", "/samlValidate?"+url.Values{"TARGET": {testServiceUrl}}.Encode(), "text/xml", "not a SOAP request")
+		var invalid samlEnvelope
+		Expect(xml.Unmarshal(w.Body.Bytes(), &invalid)).To(Succeed())
+		Expect(invalid.Response.Status.Code.Value).To(Equal("samlp:Requester"))
+		Expect(invalid.Response.Status.Message).To(Equal(MissingValidationParametersError.Msg))
+	})
+
+	It("Should sign assertions with the configured key when signing is enabled", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		keyFile, certFile := writeSigningKey(tempDir, key)
+		newServer(map[string]string{"samlSigningEnabled": "true", "samlSigningKeyFile": keyFile, "samlSigningCertFile": certFile})
+
+		body, envelope := validate(login())
+		assertion := envelope.Response.Assertion
+		Expect(assertion.Signature).NotTo(BeNil())
+		Expect(assertion.Signature.Reference.URI).To(Equal("#" + assertion.AssertionID))
+
+		// The digest covers the (canonical) assertion without its enveloped signature
+		signed := body[strings.Index(body, "<Assertion ") : strings.Index(body, "</Assertion>")+len("</Assertion>")]
+		signatureStart, signatureEnd := strings.Index(signed, "<ds:Signature "), strings.Index(signed, "</ds:Signature>")+len("</ds:Signature>")
+		digest := sha256.Sum256([]byte(signed[:signatureStart] + signed[signatureEnd:]))
+		Expect(assertion.Signature.Reference.DigestValue).To(Equal(base64.StdEncoding.EncodeToString(digest[:])))
+
+		// The signature covers the (canonical) SignedInfo, and checks out with the key
+		signedInfo := signed[strings.Index(signed, "<ds:SignedInfo") : strings.Index(signed, "</ds:SignedInfo>")+len("</ds:SignedInfo>")]
+		Expect(signedInfo).To(HavePrefix(`<ds:SignedInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">`))
+		signature, err := base64.StdEncoding.DecodeString(assertion.Signature.Value)
+		Expect(err).To(BeNil())
+		signedDigest := sha256.Sum256([]byte(signedInfo))
+		Expect(rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, signedDigest[:], signature)).To(Succeed())
+
+		// Services can check the key against the certificate included
+		der, err := base64.StdEncoding.DecodeString(assertion.Signature.Certificate)
+		Expect(err).To(BeNil())
+		cert, err := x509.ParseCertificate(der)
+		Expect(err).To(BeNil())
+		Expect(cert.PublicKey.(*rsa.PublicKey).N).To(Equal(key.N))
+	})
+
+	It("Should refuse invalid signing configuration at startup", func() {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		keyFile, _ := writeSigningKey(tempDir, key)
+		otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).To(BeNil())
+		otherDir := filepath.Join(tempDir, "other")
+		Expect(os.Mkdir(otherDir, 0700)).To(Succeed())
+		_, otherCertFile := writeSigningKey(otherDir, otherKey)
+
+		for expected, overrides := range map[string]map[string]string{
+			"samlSigningEnabled":  {"samlSigningEnabled": "maybe"},
+			"samlSigningKeyFile":  {"samlSigningEnabled": "true", "samlSigningKeyFile": filepath.Join(tempDir, "missing.key")},
+			"samlSigningCertFile": {"samlSigningEnabled": "true", "samlSigningKeyFile": keyFile, "samlSigningCertFile": otherCertFile},
+		} {
+			_, err := NewCASServerWithLogger(castest.NewTestConfig(overrides), NoopLogger{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(expected))
+		}
+	})
+})
@@ -0,0 +1,134 @@
+package cas
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+/*
+ * Security headers (CSP, HSTS, X-Frame-Options & X-Content-Type-Options) added to responses
+ */
+
+// Value disabling a security header (empty values fall back to the defaults, like other configuration)
+const SECURITY_HEADER_OFF = "off"
+
+// Headers added to responses to protect the HTML pages (ex. against clickjacking of the login form)
+type SecurityHeaders struct {
+	Header http.Header // Headers added to every response (only the enabled ones)
+	OnAPI  bool        // Whether API responses (under /api/) get the headers too
+}
+
+// Create the security headers specified by server configuration
+// (securityHeadersEnabled, securityHeadersOnApi, contentSecurityPolicy, frameOptions, contentTypeOptions & hstsMaxAge)
+// Unless customized, the content security policy allows the branding's logo and stylesheet
+func NewSecurityHeadersFromConfig(config map[string]string, branding *Branding) (*SecurityHeaders, error) {
+	headers := &SecurityHeaders{Header: http.Header{}}
+
+	enabled, err := configBool(config, "securityHeadersEnabled")
+	if err != nil {
+		return nil, err
+	}
+	if headers.OnAPI, err = configBool(config, "securityHeadersOnApi"); err != nil {
+		return nil, err
+	}
+	if !enabled {
+		return headers, nil
+	}
+
+	// Framing is denied by default, so the login form can't be clickjacked
+	frameOptions := strings.ToUpper(configValueOrDefault(config, "frameOptions"))
+	frameAncestors := ""
+	switch frameOptions {
+	case "DENY":
+		frameAncestors = "'none'"
+	case "SAMEORIGIN":
+		frameAncestors = "'self'"
+	case strings.ToUpper(SECURITY_HEADER_OFF):
+	default:
+		return nil, fmt.Errorf("Invalid frameOptions [%s], expected one of DENY, SAMEORIGIN, off", config["frameOptions"])
+	}
+	if len(frameAncestors) > 0 {
+		headers.Header.Set("X-Frame-Options", frameOptions)
+	}
+
+	switch contentTypeOptions := strings.ToLower(configValueOrDefault(config, "contentTypeOptions")); contentTypeOptions {
+	case "nosniff":
+		headers.Header.Set("X-Content-Type-Options", contentTypeOptions)
+	case SECURITY_HEADER_OFF:
+	default:
+		return nil, fmt.Errorf("Invalid contentTypeOptions [%s], expected one of nosniff, off", config["contentTypeOptions"])
+	}
+
+	hstsMaxAge, err := configInt(config, "hstsMaxAge")
+	if err != nil {
+		return nil, err
+	}
+	if hstsMaxAge < 0 {
+		return nil, fmt.Errorf("Invalid hstsMaxAge [%d], must not be negative (0 disables HSTS)", hstsMaxAge)
+	}
+	if hstsMaxAge > 0 {
+		headers.Header.Set("Strict-Transport-Security", "max-age="+strconv.Itoa(hstsMaxAge))
+	}
+
+	switch policy := strings.TrimSpace(config["contentSecurityPolicy"]); policy {
+	case "":
+		headers.Header.Set("Content-Security-Policy", defaultContentSecurityPolicy(branding, frameAncestors))
+	case SECURITY_HEADER_OFF:
+	default:
+		headers.Header.Set("Content-Security-Policy", policy)
+	}
+
+	return headers, nil
+}
+
+// Content security policy allowing what the HTML pages need: assets served by casgo, the branding's logo & stylesheet,
+// inline styles (for the branding's primary color) and evaluated scripts (for the admin UI's knockout bindings)
+func defaultContentSecurityPolicy(branding *Branding, frameAncestors string) string {
+	styleSrc := []string{"'self'", "'unsafe-inline'"}
+	imgSrc := []string{"'self'", "data:"}
+	if branding != nil {
+		if origin := urlOrigin(branding.CustomCSSURL); len(origin) > 0 {
+			styleSrc = append(styleSrc, origin)
+		}
+		if origin := urlOrigin(branding.LogoURL); len(origin) > 0 {
+			imgSrc = append(imgSrc, origin)
+		}
+	}
+
+	directives := []string{
+		"default-src 'self'",
+		"script-src 'self' 'unsafe-eval'",
+		"style-src " + strings.Join(styleSrc, " "),
+		"img-src " + strings.Join(imgSrc, " "),
+		"object-src 'none'",
+		"base-uri 'self'",
+	}
+	if len(frameAncestors) > 0 {
+		directives = append(directives, "frame-ancestors "+frameAncestors)
+	}
+	return strings.Join(directives, "; ")
+}
+
+// Origin (scheme & host) of an absolute URL (empty for paths, which are covered by 'self')
+func urlOrigin(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+		return ""
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// Wrap a handler, adding the security headers to its responses (API responses only get them if OnAPI is set)
+func (c *CAS) withSecurityHeaders(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if c.SecurityHeaders != nil && (c.SecurityHeaders.OnAPI || !strings.HasPrefix(req.URL.Path, "/api/")) {
+			for name := range c.SecurityHeaders.Header {
+				w.Header().Set(name, c.SecurityHeaders.Header.Get(name))
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
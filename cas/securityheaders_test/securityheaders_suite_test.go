@@ -0,0 +1,12 @@
+package securityheaders_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoSecurityHeaders(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Security Headers Suite")
+}
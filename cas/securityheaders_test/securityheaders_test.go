@@ -0,0 +1,136 @@
+package securityheaders_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+var _ = Describe("Security headers", func() {
+	var server *CAS
+
+	do := func(method, path, body string, headers map[string]string) http.Header {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == "POST" {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	get := func(path string) http.Header {
+		return do("GET", path, "", nil)
+	}
+
+	getAPI := func(path string) http.Header {
+		return do("GET", path, "", map[string]string{"X-Api-Key": "adminapikey", "X-Api-Secret": "badsecret"})
+	}
+
+	AfterEach(func() {
+		if server != nil {
+			castest.Close(server)
+			server = nil
+		}
+	})
+
+	It("Should add the default headers to the login, logout and error pages", func() {
+		server, _ = castest.NewTestServer(nil)
+
+		failedLogin := do("POST", "/login", url.Values{"email": {"test@test.com"}, "password": {"wrong"}}.Encode(), nil)
+		for _, header := range []http.Header{get("/login"), get("/logout"), failedLogin} {
+			Expect(header.Get("X-Frame-Options")).To(Equal("DENY"))
+			Expect(header.Get("X-Content-Type-Options")).To(Equal("nosniff"))
+			Expect(header.Get("Strict-Transport-Security")).To(Equal("max-age=31536000"))
+			Expect(header.Get("Content-Security-Policy")).To(Equal(
+				"default-src 'self'; script-src 'self' 'unsafe-eval'; style-src 'self' 'unsafe-inline'; img-src 'self' data:; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+			))
+		}
+
+		// API responses get them too (unless turned off)
+		Expect(getAPI("/api/services").Get("X-Frame-Options")).To(Equal("DENY"))
+	})
+
+	It("Should allow the branding's logo and stylesheet in the default content security policy", func() {
+		server, _ = castest.NewTestServer(map[string]string{
+			"brandingLogoUrl":      "https://cdn.example.com/images/logo.png",
+			"brandingCustomCssUrl": "https://assets.example.com/brand.css",
+		})
+
+		policy := get("/login").Get("Content-Security-Policy")
+		Expect(policy).To(ContainSubstring("img-src 'self' data: https://cdn.example.com;"))
+		Expect(policy).To(ContainSubstring("style-src 'self' 'unsafe-inline' https://assets.example.com;"))
+	})
+
+	It("Should use customized header values", func() {
+		server, _ = castest.NewTestServer(map[string]string{
+			"frameOptions": "sameorigin",
+			"hstsMaxAge":   "600",
+		})
+		header := get("/login")
+		Expect(header.Get("X-Frame-Options")).To(Equal("SAMEORIGIN"))
+		Expect(header.Get("Strict-Transport-Security")).To(Equal("max-age=600"))
+		Expect(header.Get("Content-Security-Policy")).To(HaveSuffix("frame-ancestors 'self'"))
+
+		server.Db.(*MemoryBackend).Close()
+		server, _ = castest.NewTestServer(map[string]string{"contentSecurityPolicy": "default-src 'none'"})
+		Expect(get("/login").Get("Content-Security-Policy")).To(Equal("default-src 'none'"))
+	})
+
+	It("Should allow disabling each header, or all of them", func() {
+		server, _ = castest.NewTestServer(map[string]string{
+			"frameOptions":          "off",
+			"contentTypeOptions":    "off",
+			"hstsMaxAge":            "0",
+			"contentSecurityPolicy": "off",
+		})
+		header := get("/login")
+		for _, name := range []string{"X-Frame-Options", "X-Content-Type-Options", "Strict-Transport-Security", "Content-Security-Policy"} {
+			Expect(header).NotTo(HaveKey(name))
+		}
+
+		// Without X-Frame-Options, the default policy doesn't restrict framing either
+		server.Db.(*MemoryBackend).Close()
+		server, _ = castest.NewTestServer(map[string]string{"frameOptions": "off"})
+		Expect(get("/login").Get("Content-Security-Policy")).NotTo(ContainSubstring("frame-ancestors"))
+
+		server.Db.(*MemoryBackend).Close()
+		server, _ = castest.NewTestServer(map[string]string{"securityHeadersEnabled": "false"})
+		header = get("/login")
+		for _, name := range []string{"X-Frame-Options", "X-Content-Type-Options", "Strict-Transport-Security", "Content-Security-Policy"} {
+			Expect(header).NotTo(HaveKey(name))
+		}
+	})
+
+	It("Should leave the headers off API responses when asked to", func() {
+		server, _ = castest.NewTestServer(map[string]string{"securityHeadersOnApi": "false"})
+
+		api := getAPI("/api/services")
+		Expect(api.Get("Content-Type")).To(ContainSubstring("application/json"))
+		Expect(api).NotTo(HaveKey("X-Frame-Options"))
+		Expect(api).NotTo(HaveKey("Content-Security-Policy"))
+
+		Expect(get("/login").Get("X-Frame-Options")).To(Equal("DENY"))
+	})
+
+	It("Should refuse invalid header values at startup", func() {
+		for key, value := range map[string]string{
+			"frameOptions":           "ALLOW-FROM https://example.com",
+			"contentTypeOptions":     "sniff",
+			"hstsMaxAge":             "-1",
+			"securityHeadersEnabled": "maybe",
+		} {
+			_, err := NewCASServerWithLogger(castest.NewTestConfig(map[string]string{key: value}), NoopLogger{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(key))
+		}
+	})
+})
@@ -0,0 +1,235 @@
+package cas
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+ * Service URL matching (resolving the service parameter to a registered service)
+ */
+
+// Ways a service's URL can be matched against the service parameter of a request
+const (
+	SERVICE_MATCH_EXACT    = "exact"    // The URL must be identical (the default)
+	SERVICE_MATCH_PREFIX   = "prefix"   // The URL must start with the service's URL, ending at a path, query or fragment boundary
+	SERVICE_MATCH_WILDCARD = "wildcard" // The service's URL host may start with a "*." label, standing for any single label
+	SERVICE_MATCH_REGEX    = "regex"    // The whole URL must match the service's URL as a regular expression
+)
+
+// Limits on service URL regular expressions
+// Go regular expressions run in linear time (they never backtrack), so patterns are bounded by the size of their
+// compiled program, and nested unbounded repetitions (which would backtrack catastrophically in other engines, and
+// usually mean the pattern is wrong) are refused
+const (
+	SERVICE_REGEX_MAX_LENGTH       = 1024
+	SERVICE_REGEX_MAX_INSTRUCTIONS = 2000
+)
+
+// Compiled service URL regular expressions, by pattern
+var serviceUrlRegexps sync.Map
+
+// Match mode of a service, defaulting to exact matching
+func (s *CASService) UrlMatchMode() string {
+	if len(s.MatchMode) == 0 {
+		return SERVICE_MATCH_EXACT
+	}
+	return s.MatchMode
+}
+
+// Check that a service's URL is usable with its match mode
+func (s *CASService) ValidateUrlPattern() error {
+	switch s.UrlMatchMode() {
+	case SERVICE_MATCH_EXACT, SERVICE_MATCH_PREFIX:
+		return nil
+	case SERVICE_MATCH_WILDCARD:
+		_, err := parseWildcardServiceUrl(s.Url)
+		return err
+	case SERVICE_MATCH_REGEX:
+		_, err := compileServiceUrlRegexp(s.Url)
+		return err
+	default:
+		return fmt.Errorf("Unknown matchMode [%s], expected one of exact, prefix, wildcard, regex", s.MatchMode)
+	}
+}
+
+// Whether a (requested) service URL is matched by a service
+func (s *CASService) MatchesUrl(serviceUrl string) bool {
+	switch s.UrlMatchMode() {
+	case SERVICE_MATCH_EXACT:
+		return serviceUrl == s.Url
+	case SERVICE_MATCH_PREFIX:
+		return matchesServiceUrlPrefix(s.Url, serviceUrl)
+	case SERVICE_MATCH_WILDCARD:
+		return matchesWildcardServiceUrl(s.Url, serviceUrl)
+	case SERVICE_MATCH_REGEX:
+		re, err := compileServiceUrlRegexp(s.Url)
+		return err == nil && re.MatchString(serviceUrl)
+	default:
+		return false
+	}
+}
+
+// Prefixes only match up to a boundary, so that "https://example.com" does not match "https://example.com.evil.com"
+func matchesServiceUrlPrefix(prefix, serviceUrl string) bool {
+	if len(prefix) == 0 || !strings.HasPrefix(serviceUrl, prefix) {
+		return false
+	}
+	if len(serviceUrl) == len(prefix) || strings.HasSuffix(prefix, "/") {
+		return true
+	}
+	return strings.ContainsRune("/?#", rune(serviceUrl[len(prefix)]))
+}
+
+// Parse a wildcard service URL, which must be absolute with a "*." host prefix (and no other wildcards)
+func parseWildcardServiceUrl(pattern string) (*url.URL, error) {
+	parsed, err := url.Parse(pattern)
+	if err != nil {
+		return nil, err
+	}
+	host := parsed.Hostname()
+	if len(parsed.Scheme) == 0 || !strings.HasPrefix(host, "*.") || strings.Count(pattern, "*") != 1 || len(host) <= 2 {
+		return nil, fmt.Errorf("Invalid wildcard service URL [%s], expected an absolute URL with a host starting with \"*.\"", pattern)
+	}
+	return parsed, nil
+}
+
+// The wildcard stands for exactly one host label, everything else must be identical
+func matchesWildcardServiceUrl(pattern, serviceUrl string) bool {
+	patternUrl, err := parseWildcardServiceUrl(pattern)
+	if err != nil {
+		return false
+	}
+	candidate, err := url.Parse(serviceUrl)
+	if err != nil {
+		return false
+	}
+
+	label, domain, ok := strings.Cut(candidate.Hostname(), ".")
+	if !ok || len(label) == 0 || !strings.EqualFold(domain, patternUrl.Hostname()[2:]) {
+		return false
+	}
+
+	// Compare the rest of the URL with the wildcard host swapped in
+	candidate.Host = patternUrl.Host
+	return candidate.String() == patternUrl.String()
+}
+
+// Compile (and cache) a service URL regular expression, which must match the whole URL
+func compileServiceUrlRegexp(pattern string) (*regexp.Regexp, error) {
+	if cached, ok := serviceUrlRegexps.Load(pattern); ok {
+		return cached.(*regexp.Regexp), nil
+	}
+
+	if len(pattern) == 0 || len(pattern) > SERVICE_REGEX_MAX_LENGTH {
+		return nil, fmt.Errorf("Service URL regex must be between 1 and %d characters long", SERVICE_REGEX_MAX_LENGTH)
+	}
+	anchored := `^(?:` + pattern + `)$`
+	parsed, err := syntax.Parse(anchored, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	if hasNestedRepetition(parsed, false) {
+		return nil, fmt.Errorf("Service URL regex [%s] contains nested repetition", pattern)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, err
+	}
+	if len(prog.Inst) > SERVICE_REGEX_MAX_INSTRUCTIONS {
+		return nil, fmt.Errorf("Service URL regex [%s] is too complex", pattern)
+	}
+
+	re, err := regexp.Compile(anchored)
+	if err != nil {
+		return nil, err
+	}
+	serviceUrlRegexps.Store(pattern, re)
+	return re, nil
+}
+
+// Whether an unbounded repetition (*, + or {n,}) contains another one (ex. "(a+)+")
+func hasNestedRepetition(re *syntax.Regexp, inRepetition bool) bool {
+	isRepetition := re.Op == syntax.OpStar || re.Op == syntax.OpPlus || (re.Op == syntax.OpRepeat && re.Max == -1)
+	if isRepetition && inRepetition {
+		return true
+	}
+	for _, sub := range re.Sub {
+		if hasNestedRepetition(sub, inRepetition || isRepetition) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the service matching a requested service URL
+// Services registered with the exact URL are preferred, followed by (the longest) prefix, wildcard and regex matches
+// Requested URLs are normalized (as registered URLs are) before they are matched, except against regular expressions
+// Services matched by a pattern are returned with their URL set to the requested URL, which tickets are issued for
+// (and users are redirected to)
+func (c *CAS) findServiceForUrl(db Backend, serviceUrl string) (*CASService, *CASServerError) {
+	requested := c.ServiceUrlPolicy.normalizeRequestedUrl(serviceUrl)
+	if service, casErr := db.FindServiceByUrl(requested); casErr == nil && service.MatchesUrl(requested) {
+		return service, nil
+	}
+
+	services, casErr := db.GetAllServices()
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	// Registered URLs are compared in normalized form as well, as services may have been registered before it was
+	matches := []CASService{}
+	for _, service := range services {
+		if service.UrlMatchMode() == SERVICE_MATCH_REGEX {
+			if service.MatchesUrl(serviceUrl) {
+				matches = append(matches, service)
+			}
+			continue
+		}
+		service.Url = c.ServiceUrlPolicy.normalizeRequestedUrl(service.Url)
+		if service.MatchesUrl(requested) {
+			matches = append(matches, service)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, &FailedToFindServiceByUrlError
+	}
+	sort.Sort(servicesByMatchPrecedence(matches))
+
+	resolved := matches[0]
+	switch resolved.UrlMatchMode() {
+	case SERVICE_MATCH_EXACT:
+	case SERVICE_MATCH_REGEX:
+		resolved.Url = serviceUrl
+	default:
+		resolved.Url = requested
+	}
+	return &resolved, nil
+}
+
+var serviceMatchModeRanks = map[string]int{
+	SERVICE_MATCH_EXACT:    0,
+	SERVICE_MATCH_PREFIX:   1,
+	SERVICE_MATCH_WILDCARD: 2,
+	SERVICE_MATCH_REGEX:    3,
+}
+
+type servicesByMatchPrecedence []CASService
+
+func (s servicesByMatchPrecedence) Len() int      { return len(s) }
+func (s servicesByMatchPrecedence) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s servicesByMatchPrecedence) Less(i, j int) bool {
+	if a, b := serviceMatchModeRanks[s[i].UrlMatchMode()], serviceMatchModeRanks[s[j].UrlMatchMode()]; a != b {
+		return a < b
+	}
+	if len(s[i].Url) != len(s[j].Url) {
+		return len(s[i].Url) > len(s[j].Url)
+	}
+	return s[i].Name < s[j].Name
+}
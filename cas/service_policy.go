@@ -0,0 +1,176 @@
+package cas
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"unicode"
+)
+
+/*
+ * Restrictions on service URLs (HTTPS-only and registered services), and how they are normalized
+ */
+
+// Service URLs that may be used
+type ServiceUrlPolicy struct {
+	RequireHTTPS      bool     // Refuse services whose URLs do not use https
+	ExceptionHosts    []string // Hosts allowed to use other schemes anyway (ex. legacy internal apps)
+	RequireRegistered bool     // Refuse logins (and validations) for service URLs matching no registered service
+	Normalize         bool     // Normalize service URLs (as they are registered, and requested) before they are matched
+	KeepQuery         bool     // Whether query parameters are significant (they are removed by normalization otherwise)
+	KeepFragment      bool     // Whether fragments are significant (they are removed by normalization otherwise)
+}
+
+// Default ports of the schemes whose URLs are normalized
+var normalizedServiceUrlPorts = map[string]string{"http": "80", "https": "443"}
+
+// Create the service URL policy specified by server configuration
+func NewServiceUrlPolicyFromConfig(config map[string]string) (*ServiceUrlPolicy, error) {
+	requireHTTPS, err := configBool(config, "requireHTTPSServices")
+	if err != nil {
+		return nil, err
+	}
+	requireRegistered, err := configBool(config, "registeredServicesOnly")
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &ServiceUrlPolicy{
+		RequireHTTPS:      requireHTTPS,
+		ExceptionHosts:    splitConfigList(config["httpsServiceExceptions"]),
+		RequireRegistered: requireRegistered,
+	}
+	for key, value := range map[string]*bool{"normalizeServiceUrls": &policy.Normalize, "serviceUrlKeepQuery": &policy.KeepQuery, "serviceUrlKeepFragment": &policy.KeepFragment} {
+		if *value, err = configBool(config, key); err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// Normalize a service URL, so that equivalent URLs (ex. "HTTPS://Example.com:443/app/" and "https://example.com/app")
+// are identical: the scheme and host are lowercased, default ports removed and the path cleaned (without a trailing
+// slash, other than the root path's), and the query and fragment removed unless they are significant
+// Only absolute http(s) URLs are normalized, others (ex. "localhost:3000/app", which has no scheme) are returned as
+// they are, and malformed URLs (http(s) URLs without a host, or URLs containing whitespace) are refused
+func (p *ServiceUrlPolicy) NormalizeUrl(serviceUrl string) (string, error) {
+	if strings.IndexFunc(serviceUrl, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", fmt.Errorf("Service URL %q contains whitespace", serviceUrl)
+	}
+	parsed, err := url.Parse(serviceUrl)
+	if err != nil {
+		return "", err
+	}
+
+	scheme := strings.ToLower(parsed.Scheme)
+	defaultPort, normalized := normalizedServiceUrlPorts[scheme]
+	if !normalized {
+		return serviceUrl, nil
+	}
+	if len(parsed.Host) == 0 || len(parsed.Opaque) > 0 {
+		return "", fmt.Errorf("Service URL [%s] has no host", serviceUrl)
+	}
+	if p == nil || !p.Normalize {
+		return serviceUrl, nil
+	}
+
+	parsed.Scheme = scheme
+	parsed.Host = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(parsed.Host), ":"+defaultPort), ":")
+
+	cleanPath := path.Clean("/" + parsed.EscapedPath())
+	if parsed.Path, err = url.PathUnescape(cleanPath); err != nil {
+		return "", err
+	}
+	parsed.RawPath = cleanPath
+
+	if !p.KeepQuery || len(parsed.RawQuery) == 0 {
+		parsed.RawQuery, parsed.ForceQuery = "", false
+	}
+	if !p.KeepFragment {
+		parsed.Fragment, parsed.RawFragment = "", ""
+	}
+	return parsed.String(), nil
+}
+
+// Normalize a requested service URL to match it against registered services (URLs that can't be normalized are
+// matched as they are)
+func (p *ServiceUrlPolicy) normalizeRequestedUrl(serviceUrl string) string {
+	if normalized, err := p.NormalizeUrl(serviceUrl); err == nil {
+		return normalized
+	}
+	return serviceUrl
+}
+
+// Whether a ticket issued for a service URL was issued for the given (equivalent) one
+func (c *CAS) sameServiceUrl(issuedUrl, serviceUrl string) bool {
+	if c.ServiceUrlPolicy == nil {
+		return issuedUrl == serviceUrl
+	}
+	return c.ServiceUrlPolicy.normalizeRequestedUrl(issuedUrl) == c.ServiceUrlPolicy.normalizeRequestedUrl(serviceUrl)
+}
+
+// Normalize the URL of a service being registered, refusing malformed URLs
+// Regular expressions are left as they are (requested URLs are matched against them unnormalized)
+func (c *CAS) normalizeServiceUrl(service *CASService) *CASServerError {
+	if len(service.Url) == 0 || service.UrlMatchMode() == SERVICE_MATCH_REGEX {
+		return nil
+	}
+	normalized, err := c.ServiceUrlPolicy.NormalizeUrl(service.Url)
+	if err != nil {
+		casErr := &InvalidServiceUrlError
+		casErr.err = &err
+		return casErr
+	}
+	service.Url = normalized
+	return nil
+}
+
+// Whether a (requested) service URL may be used
+func (p *ServiceUrlPolicy) AllowsUrl(serviceUrl string) bool {
+	if p == nil || !p.RequireHTTPS {
+		return true
+	}
+
+	parsed, err := url.Parse(serviceUrl)
+	if err != nil || len(parsed.Scheme) == 0 || len(parsed.Host) == 0 {
+		return false
+	}
+	return strings.EqualFold(parsed.Scheme, "https") || containsString(p.ExceptionHosts, parsed.Hostname(), true)
+}
+
+// Whether a service may be registered
+// Regular expressions cannot be checked against the exception list, so every URL they match must start with "https://"
+func (p *ServiceUrlPolicy) AllowsService(service *CASService) bool {
+	if p == nil || !p.RequireHTTPS || len(service.Url) == 0 {
+		return true
+	}
+	if service.UrlMatchMode() == SERVICE_MATCH_REGEX {
+		re, err := compileServiceUrlRegexp(service.Url)
+		if err != nil {
+			return false
+		}
+		prefix, _ := re.LiteralPrefix()
+		return strings.HasPrefix(prefix, "https://")
+	}
+	return p.AllowsUrl(service.Url)
+}
+
+// Check that a requested service URL may be used
+func (c *CAS) checkServiceUrlAllowed(serviceUrl string) *CASServerError {
+	if !c.ServiceUrlPolicy.AllowsUrl(serviceUrl) {
+		return &InsecureServiceUrlError
+	}
+	return nil
+}
+
+// Find the service tickets are issued for (and validated against) with a requested service URL
+// Unless registeredServicesOnly is disabled, URLs matching no registered service are refused (so casgo can't be used
+// to redirect users to arbitrary sites), otherwise they are served as unregistered services named after their URL
+func (c *CAS) findServiceForTicket(db Backend, serviceUrl string) (*CASService, *CASServerError) {
+	casService, casErr := c.findServiceForUrl(db, serviceUrl)
+	if casErr == nil || casErr.Code != FailedToFindServiceByUrlError.Code || c.ServiceUrlPolicy == nil || c.ServiceUrlPolicy.RequireRegistered {
+		return casService, casErr
+	}
+	return &CASService{Name: serviceUrl, Url: serviceUrl}, nil
+}
@@ -0,0 +1,72 @@
+package cas
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Service secrets, which services with one must present (along with the ticket) to validate tickets
+ */
+
+// Where services present their secret on validation requests (the header is preferred, as URLs tend to be logged)
+const (
+	SERVICE_SECRET_HEADER = "X-Casgo-Service-Secret"
+	SERVICE_SECRET_PARAM  = "serviceSecret"
+)
+
+// Minimum length of service secrets (they are checked with a fast hash, so they should be random, not passwords)
+const SERVICE_SECRET_MIN_LENGTH = 16
+
+// Hash a service secret for storage (salted SHA-256, as secrets are checked on every validation)
+func HashServiceSecret(secret string) (string, error) {
+	return (&SHA256PasswordHasher{}).Hash(secret)
+}
+
+// Whether a stored service secret is hashed (plaintext secrets are refused rather than compared)
+func isValidServiceSecretHash(hash string) bool {
+	return len(hash) == 0 || strings.HasPrefix(hash, SHA256_PASSWORD_PREFIX) || strings.HasPrefix(hash, "$2")
+}
+
+// Whether validation requests for the service must present its secret
+func (s *CASService) RequiresSecret() bool {
+	return len(s.ValidationSecretHash) > 0
+}
+
+// Check the secret presented on a validation request (in constant time), any secret is accepted for services without one
+func (s *CASService) SecretMatches(secret string) bool {
+	if !s.RequiresSecret() {
+		return true
+	}
+	return isValidServiceSecretHash(s.ValidationSecretHash) && len(secret) > 0 && VerifyPassword(s.ValidationSecretHash, secret)
+}
+
+// Secret presented by the service making a validation request (from the header, or the parameter)
+func serviceSecretFromRequest(req *http.Request) string {
+	if secret := strings.TrimSpace(req.Header.Get(SERVICE_SECRET_HEADER)); len(secret) > 0 {
+		return secret
+	}
+	return strings.TrimSpace(req.FormValue(SERVICE_SECRET_PARAM))
+}
+
+// Hash the (write-only) validationSecret given in a service's JSON, if there is one, replacing the service's secret
+// Returns hashFailure if the secret couldn't be hashed
+func setServiceSecretFromJSON(body []byte, service *CASService, hashFailure *CASServerError) *CASServerError {
+	var fields struct {
+		ValidationSecret string `json:"validationSecret"`
+	}
+	if err := json.Unmarshal(body, &fields); err != nil || len(fields.ValidationSecret) == 0 {
+		return nil
+	}
+	if len(fields.ValidationSecret) < SERVICE_SECRET_MIN_LENGTH {
+		return &WeakServiceSecretError
+	}
+
+	hash, err := HashServiceSecret(fields.ValidationSecret)
+	if err != nil {
+		return hashFailure
+	}
+	service.ValidationSecretHash = hash
+	return nil
+}
@@ -0,0 +1,12 @@
+package servicedelete_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoServiceDelete(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Service Delete Suite")
+}
@@ -0,0 +1,135 @@
+package servicedelete_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+var _ = Describe("Service IDs", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	requestAs := func(apiKey, method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", apiKey)
+		req.Header.Set("X-Api-Secret", "badsecret")
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	request := func(method, path, body string) *httptest.ResponseRecorder {
+		return requestAs("adminapikey", method, path, body)
+	}
+
+	serviceIn := func(w *httptest.ResponseRecorder) CASService {
+		var body struct {
+			Data CASService `json:"data"`
+		}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		return body.Data
+	}
+
+	serviceNames := func() []string {
+		services, casErr := db.GetAllServices()
+		Expect(casErr).To(BeNil())
+		names := []string{}
+		for _, service := range services {
+			names = append(names, service.Name)
+		}
+		return names
+	}
+
+	idOf := func(url string) string {
+		service, casErr := db.FindServiceByUrl(url)
+		Expect(casErr).To(BeNil())
+		Expect(IsServiceId(service.Id)).To(BeTrue())
+		return service.Id
+	}
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+
+		server, err = NewCASServer(config)
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("services", "../../fixtures/services.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.AuditLog.Stop()
+		db.Close()
+	})
+
+	It("Should assign new services an ID, which updates can't change", func() {
+		w := request("POST", "/api/services", `{"id":"SVC-chosen","name":"new_service","url":"localhost:4000/validate","adminEmail":"admin@test.com"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		created := serviceIn(w)
+		Expect(IsServiceId(created.Id)).To(BeTrue())
+		Expect(created.Id).NotTo(Equal("SVC-chosen"))
+
+		w = request("PUT", "/api/services/new_service", `{"id":"SVC-other","name":"new_service","url":"localhost:4001/validate"}`)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(serviceIn(w).Id).To(Equal(created.Id))
+		Expect(idOf("localhost:4001/validate")).To(Equal(created.Id))
+	})
+
+	It("Should refuse service names that look like IDs", func() {
+		w := request("POST", "/api/services", `{"name":"SVC-new_service","url":"localhost:4000/validate","adminEmail":"admin@test.com"}`)
+		Expect(w.Code).To(Equal(InvalidServiceError.HttpCode))
+		Expect(w.Body.String()).To(ContainSubstring(InvalidServiceError.Code))
+		Expect(serviceNames()).NotTo(ContainElement("SVC-new_service"))
+	})
+
+	It("Should remove a service (and its configuration) by ID", func() {
+		id := idOf("localhost:3001/validateCASLogin")
+
+		w := request("DELETE", "/api/services/"+id, "")
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Body.Len()).To(BeZero())
+		Expect(w.Header().Get("Content-Type")).To(BeEmpty())
+		Expect(serviceNames()).To(ConsistOf("test_service", "test_service_3"))
+
+		_, casErr := db.FindServiceById(id)
+		Expect(casErr).To(Equal(&ServiceNotFoundError))
+		_, casErr = db.FindServiceByUrl("localhost:3001/validateCASLogin")
+		Expect(casErr).NotTo(BeNil())
+	})
+
+	It("Should still remove services by name", func() {
+		Expect(request("DELETE", "/api/services/test_service_2", "").Code).To(Equal(http.StatusNoContent))
+		Expect(serviceNames()).To(ConsistOf("test_service", "test_service_3"))
+	})
+
+	It("Should respond with a structured not found error for unknown IDs", func() {
+		w := request("DELETE", "/api/services/SVC-doesnotexist", "")
+		Expect(w.Code).To(Equal(http.StatusNotFound))
+
+		var body map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		Expect(body["status"]).To(Equal("error"))
+		Expect(body["code"]).To(Equal(ServiceNotFoundError.Code))
+		Expect(serviceNames()).To(HaveLen(3))
+	})
+
+	It("Should only let admins remove services", func() {
+		id := idOf("localhost:3001/validateCASLogin")
+
+		w := requestAs("userapikey", "DELETE", "/api/services/"+id, "")
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(serviceNames()).To(HaveLen(3))
+	})
+})
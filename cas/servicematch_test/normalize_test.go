@@ -0,0 +1,171 @@
+package servicematch_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+var _ = Describe("Service URL normalization", func() {
+
+	Describe("#NormalizeUrl", func() {
+		policy := func(overrides map[string]string) *ServiceUrlPolicy {
+			config, err := NewCASServerConfig("")
+			Expect(err).To(BeNil())
+			for key, value := range overrides {
+				config[key] = value
+			}
+			policy, err := NewServiceUrlPolicyFromConfig(config)
+			Expect(err).To(BeNil())
+			return policy
+		}
+
+		normalized := func(policy *ServiceUrlPolicy, serviceUrl string) string {
+			result, err := policy.NormalizeUrl(serviceUrl)
+			Expect(err).To(BeNil())
+			return result
+		}
+
+		It("Should make equivalent URLs identical", func() {
+			defaults := policy(nil)
+			for _, serviceUrl := range []string{
+				"https://app.example.com/cas",
+				"https://app.example.com/cas/",
+				"HTTPS://App.Example.COM/cas",
+				"https://app.example.com:443/cas",
+				"https://app.example.com/./login/../cas//",
+				"https://app.example.com/cas#section",
+			} {
+				Expect(normalized(defaults, serviceUrl)).To(Equal("https://app.example.com/cas"), serviceUrl)
+			}
+			Expect(normalized(defaults, "http://app.example.com:80")).To(Equal("http://app.example.com/"))
+			Expect(normalized(defaults, "http://app.example.com:8080/")).To(Equal("http://app.example.com:8080/"))
+			Expect(normalized(defaults, "https://app.example.com/a%2Fb/")).To(Equal("https://app.example.com/a%2Fb"))
+		})
+
+		It("Should keep query parameters and drop fragments unless configured otherwise", func() {
+			Expect(normalized(policy(nil), "https://app.example.com/cas/?next=/Home#top")).To(Equal("https://app.example.com/cas?next=/Home"))
+
+			custom := policy(map[string]string{"serviceUrlKeepQuery": "false", "serviceUrlKeepFragment": "true"})
+			Expect(normalized(custom, "https://app.example.com/cas/?next=/Home#top")).To(Equal("https://app.example.com/cas#top"))
+		})
+
+		It("Should leave URLs that aren't absolute http(s) URLs alone", func() {
+			defaults := policy(nil)
+			Expect(normalized(defaults, "localhost:3000/validateCASLogin/")).To(Equal("localhost:3000/validateCASLogin/"))
+			Expect(normalized(defaults, "myapp://Callback/")).To(Equal("myapp://Callback/"))
+			Expect(normalized(policy(map[string]string{"normalizeServiceUrls": "false"}), "https://App.example.com/cas/")).To(Equal("https://App.example.com/cas/"))
+		})
+
+		It("Should refuse malformed URLs", func() {
+			for _, serviceUrl := range []string{
+				"https://",
+				"https:///cas",
+				"https:app.example.com/cas",
+				"http://[::1",
+				"https://app.example.com/c as",
+				"https://app.example.com/cas\n",
+			} {
+				_, err := policy(nil).NormalizeUrl(serviceUrl)
+				Expect(err).To(HaveOccurred(), serviceUrl)
+			}
+		})
+	})
+
+	Describe("Registering and resolving services", func() {
+		var (
+			server *CAS
+			db     *MemoryBackend
+			client *castest.Client
+		)
+
+		newServer := func(overrides map[string]string) {
+			server, db = castest.NewTestServer(overrides)
+			client = castest.NewClient(server)
+		}
+
+		register := func(serviceUrl string) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(map[string]string{"name": "normalized", "url": serviceUrl, "adminEmail": "admin@test.com"})
+			req, _ := http.NewRequest("POST", "/api/services", strings.NewReader(string(body)))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("X-Api-Key", "adminapikey")
+			req.Header.Set("X-Api-Secret", "badsecret")
+			return client.Do(req)
+		}
+
+		login := func(serviceUrl string) *httptest.ResponseRecorder {
+			return client.Login(url.Values{"serviceUrl": {serviceUrl}})
+		}
+
+		validate := func(serviceUrl, ticket string) map[string]interface{} {
+			req, _ := http.NewRequest("GET", "/validate?"+url.Values{"service": {serviceUrl}, "ticket": {ticket}}.Encode(), nil)
+			var response map[string]interface{}
+			Expect(json.Unmarshal(client.Do(req).Body.Bytes(), &response)).To(Succeed())
+			return response
+		}
+
+		AfterEach(func() {
+			castest.Close(server)
+		})
+
+		It("Should store the normalized URL of registered services", func() {
+			newServer(nil)
+			Expect(register("HTTPS://App.Example.com:443/cas/").Code).To(Equal(http.StatusOK))
+
+			service, casErr := db.FindServiceByUrl("https://app.example.com/cas")
+			Expect(casErr).To(BeNil())
+			Expect(service.Name).To(Equal("normalized"))
+		})
+
+		It("Should refuse malformed service URLs with a structured error", func() {
+			newServer(nil)
+			for _, serviceUrl := range []string{"https:///cas", "https://app.example.com/c as"} {
+				w := register(serviceUrl)
+				Expect(w.Code).To(Equal(http.StatusBadRequest))
+
+				var body map[string]interface{}
+				Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+				Expect(body["code"]).To(Equal(InvalidServiceUrlError.Code))
+			}
+			// Only the services fixture is registered
+			services, _ := db.GetAllServices()
+			Expect(services).To(HaveLen(3))
+		})
+
+		It("Should treat URLs with and without trailing slashes as the same service", func() {
+			newServer(nil)
+			Expect(register("https://app.example.com/cas/").Code).To(Equal(http.StatusOK))
+
+			// Services registered before URLs were normalized are matched too
+			Expect(db.AddNewService(&CASService{Name: "legacy", Url: "https://Legacy.example.com/cas/", AdminEmail: "admin@test.com"})).To(BeNil())
+
+			for _, pair := range [][2]string{
+				{"https://app.example.com/cas", "https://app.example.com/cas/"},
+				{"https://app.example.com/cas/", "https://APP.example.com:443/cas"},
+				{"https://legacy.example.com/cas", "https://Legacy.example.com/cas/"},
+			} {
+				w := login(pair[0])
+				Expect(w.Code).To(Equal(http.StatusFound), pair[0])
+				location, err := url.Parse(w.Header().Get("Location"))
+				Expect(err).To(BeNil())
+
+				response := validate(pair[1], location.Query().Get("ticket"))
+				Expect(response["status"]).To(Equal("success"), pair[1])
+			}
+		})
+
+		It("Should match URLs exactly with normalization disabled", func() {
+			newServer(map[string]string{"normalizeServiceUrls": "false"})
+			Expect(register("https://app.example.com/cas/").Code).To(Equal(http.StatusOK))
+
+			Expect(login("https://app.example.com/cas").Code).To(Equal(http.StatusNotFound))
+			Expect(login("https://app.example.com/cas/").Code).To(Equal(http.StatusFound))
+		})
+	})
+})
@@ -0,0 +1,12 @@
+package servicematch_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoServiceMatch(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Service Match Suite")
+}
@@ -0,0 +1,202 @@
+package servicematch_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+var _ = Describe("Service URL matching", func() {
+
+	Describe("#MatchesUrl", func() {
+		matches := func(mode, pattern, serviceUrl string) bool {
+			service := &CASService{Url: pattern, MatchMode: mode}
+			Expect(service.ValidateUrlPattern()).To(Succeed())
+			return service.MatchesUrl(serviceUrl)
+		}
+
+		It("Should match exact URLs by default", func() {
+			Expect(matches("", "https://app.example.com/cas", "https://app.example.com/cas")).To(BeTrue())
+			Expect(matches("", "https://app.example.com/cas", "https://app.example.com/cas/")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_EXACT, "https://app.example.com/cas", "https://other.example.com/cas")).To(BeFalse())
+		})
+
+		It("Should match prefixes up to a boundary", func() {
+			Expect(matches(SERVICE_MATCH_PREFIX, "https://app.example.com/cas", "https://app.example.com/cas")).To(BeTrue())
+			Expect(matches(SERVICE_MATCH_PREFIX, "https://app.example.com/cas", "https://app.example.com/cas/login")).To(BeTrue())
+			Expect(matches(SERVICE_MATCH_PREFIX, "https://app.example.com/cas", "https://app.example.com/cas?next=/")).To(BeTrue())
+			Expect(matches(SERVICE_MATCH_PREFIX, "https://app.example.com/cas", "https://app.example.com/castle")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_PREFIX, "https://app.example.com", "https://app.example.com.evil.com")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_PREFIX, "https://app.example.com/", "https://app.example.com/anything")).To(BeTrue())
+		})
+
+		It("Should match a single host label with wildcard hosts", func() {
+			pattern := "https://*.example.com/app"
+			Expect(matches(SERVICE_MATCH_WILDCARD, pattern, "https://one.example.com/app")).To(BeTrue())
+			Expect(matches(SERVICE_MATCH_WILDCARD, pattern, "https://TWO.Example.com/app")).To(BeTrue())
+			Expect(matches(SERVICE_MATCH_WILDCARD, pattern, "https://a.b.example.com/app")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_WILDCARD, pattern, "https://example.com/app")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_WILDCARD, pattern, "https://one.example.com/other")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_WILDCARD, pattern, "http://one.example.com/app")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_WILDCARD, pattern, "https://one.evil.com/app")).To(BeFalse())
+		})
+
+		It("Should match whole URLs against regular expressions", func() {
+			pattern := `https://(www|app)\.example\.com(/.*)?`
+			Expect(matches(SERVICE_MATCH_REGEX, pattern, "https://app.example.com")).To(BeTrue())
+			Expect(matches(SERVICE_MATCH_REGEX, pattern, "https://www.example.com/path")).To(BeTrue())
+			Expect(matches(SERVICE_MATCH_REGEX, pattern, "https://api.example.com/path")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_REGEX, pattern, "https://app.example.com.evil.com")).To(BeFalse())
+			Expect(matches(SERVICE_MATCH_REGEX, pattern, "evil://https://app.example.com")).To(BeFalse())
+		})
+	})
+
+	Describe("#ValidateUrlPattern", func() {
+		invalid := func(mode, pattern string) {
+			service := &CASService{Name: "service", Url: pattern, AdminEmail: "admin@test.com", MatchMode: mode}
+			Expect(service.ValidateUrlPattern()).NotTo(Succeed())
+			Expect(service.IsValid()).To(BeFalse())
+		}
+
+		It("Should reject malformed regular expressions", func() {
+			invalid(SERVICE_MATCH_REGEX, `https://(app\.example\.com`)
+			invalid(SERVICE_MATCH_REGEX, `https://app\.example\.com/[a-`)
+		})
+
+		It("Should reject overly complex regular expressions", func() {
+			invalid(SERVICE_MATCH_REGEX, `https://(a+)+\.example\.com`)
+			invalid(SERVICE_MATCH_REGEX, `https://([a-z]*\.)*example\.com`)
+			invalid(SERVICE_MATCH_REGEX, `https://`+strings.Repeat(`[a-z]{1,99}`, 20))
+			invalid(SERVICE_MATCH_REGEX, strings.Repeat("a", SERVICE_REGEX_MAX_LENGTH+1))
+		})
+
+		It("Should reject malformed wildcard URLs and unknown modes", func() {
+			invalid(SERVICE_MATCH_WILDCARD, "https://app.example.com")
+			invalid(SERVICE_MATCH_WILDCARD, "https://*.*.example.com")
+			invalid(SERVICE_MATCH_WILDCARD, "*.example.com")
+			invalid("glob", "https://app.example.com")
+		})
+	})
+
+	Describe("Resolving services during login and validation", func() {
+		var (
+			server *CAS
+			db     *MemoryBackend
+			client *castest.Client
+		)
+
+		// Log in (as test@test.com) to a service
+		login := func(serviceUrl string) *httptest.ResponseRecorder {
+			return client.Login(url.Values{"serviceUrl": {serviceUrl}})
+		}
+
+		validate := func(serviceUrl, ticket string) map[string]interface{} {
+			w := client.Get("/validate?" + url.Values{"service": {serviceUrl}, "ticket": {ticket}}.Encode())
+			var response map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+			return response
+		}
+
+		addService := func(name, mode, pattern string) {
+			Expect(db.AddNewService(&CASService{Name: name, Url: pattern, AdminEmail: "admin@test.com", MatchMode: mode})).To(BeNil())
+		}
+
+		BeforeEach(func() {
+			server, db = castest.NewTestServer(nil)
+			client = castest.NewClient(server)
+
+			addService("exact", "", "https://exact.example.com/app")
+			addService("wildcard", SERVICE_MATCH_WILDCARD, "https://*.example.com/app")
+			addService("prefix", SERVICE_MATCH_PREFIX, "https://portal.example.com/")
+			addService("regex", SERVICE_MATCH_REGEX, `https://[a-z]+\.example\.org/cas`)
+		})
+
+		AfterEach(func() {
+			castest.Close(server)
+		})
+
+		It("Should issue tickets for (and redirect to) the requested URL of a matching service", func() {
+			for _, serviceUrl := range []string{
+				"https://exact.example.com/app",
+				"https://team.example.com/app",
+				"https://portal.example.com/reports/2015",
+				"https://docs.example.org/cas",
+			} {
+				w := login(serviceUrl)
+				Expect(w.Code).To(Equal(http.StatusFound))
+				location, err := url.Parse(w.Header().Get("Location"))
+				Expect(err).To(BeNil())
+				Expect(strings.SplitN(location.String(), "?", 2)[0]).To(Equal(serviceUrl))
+
+				ticket := location.Query().Get("ticket")
+				Expect(ticket).NotTo(BeEmpty())
+				response := validate(serviceUrl, ticket)
+				Expect(response["status"]).To(Equal("success"))
+				Expect(response["userEmail"]).To(Equal("test@test.com"))
+
+				tickets, casErr := db.FindTicketsForTGT(tgtIdForTicket(db, ticket))
+				Expect(casErr).To(BeNil())
+				Expect(tickets[len(tickets)-1].ServiceUrl).To(Equal(serviceUrl))
+			}
+		})
+
+		It("Should add the ticket to requested URLs that already have a query", func() {
+			serviceUrl := "https://portal.example.com/reports?year=2015"
+			w := login(serviceUrl)
+			Expect(w.Code).To(Equal(http.StatusFound))
+			Expect(w.Header().Get("Location")).To(HavePrefix(serviceUrl + "&ticket="))
+
+			location, err := url.Parse(w.Header().Get("Location"))
+			Expect(err).To(BeNil())
+			Expect(location.Query().Get("year")).To(Equal("2015"))
+
+			response := validate(serviceUrl, location.Query().Get("ticket"))
+			Expect(response["status"]).To(Equal("success"))
+			Expect(response["userEmail"]).To(Equal("test@test.com"))
+		})
+
+		It("Should refuse services that no pattern matches", func() {
+			for _, serviceUrl := range []string{
+				"https://exact.example.com/application",
+				"https://a.b.example.com/app",
+				"https://portal.example.com.evil.com/",
+				"https://docs.example.org/cas/extra",
+			} {
+				w := login(serviceUrl)
+				Expect(w.Code).To(Equal(http.StatusNotFound))
+
+				response := validate(serviceUrl, "ST-unknown")
+				Expect(response["status"]).To(Equal("error"))
+				Expect(response["code"]).To(Equal("102"))
+			}
+		})
+
+		It("Should reject services with malformed regular expressions when they are created", func() {
+			body, _ := json.Marshal(CASService{Name: "bad", Url: `https://(a+)+\.example\.com`, AdminEmail: "admin@test.com", MatchMode: SERVICE_MATCH_REGEX})
+			req, _ := http.NewRequest("POST", "/api/services", bytes.NewReader(body))
+			req.Header.Set("X-Api-Key", "adminapikey")
+			req.Header.Set("X-Api-Secret", "badsecret")
+			w := httptest.NewRecorder()
+			server.ServeMux.ServeHTTP(w, req)
+
+			Expect(w.Code).To(Equal(InvalidServiceUrlPatternError.HttpCode))
+			Expect(w.Body.String()).To(ContainSubstring("Invalid service URL pattern"))
+			_, casErr := db.FindServiceByUrl(`https://(a+)+\.example\.com`)
+			Expect(casErr).NotTo(BeNil())
+		})
+	})
+})
+
+// ID of the ticket-granting ticket a service ticket was issued under
+func tgtIdForTicket(db *MemoryBackend, ticketId string) string {
+	ticket, casErr := db.FindTicketByIdForService(ticketId, nil)
+	Expect(casErr).To(BeNil())
+	return ticket.TGTId
+}
@@ -0,0 +1,254 @@
+package cas
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+/*
+ * Bulk imports (of services, and of users from backups)
+ */
+
+// Outcomes of importing a single service or user
+const (
+	IMPORT_CREATED   = "created"
+	IMPORT_UPDATED   = "updated"
+	IMPORT_UNCHANGED = "unchanged" // Already existed exactly as given
+	IMPORT_FAILED    = "failed"
+	IMPORT_SKIPPED   = "skipped" // Not attempted (or rolled back) because something else failed
+)
+
+// Result of importing a single service or user
+type ImportResult struct {
+	Name    string `json:"name,omitempty"`
+	Url     string `json:"url,omitempty"`
+	Email   string `json:"email,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"` // Reason the import failed
+}
+
+// Per-item results of an import, in the order items were given
+type ImportReport struct {
+	Results   []ImportResult `json:"results"`
+	Created   int            `json:"created"`
+	Updated   int            `json:"updated"`
+	Unchanged int            `json:"unchanged"`
+	Failed    int            `json:"failed"`
+}
+
+// Options controlling how services (and users) are imported
+type ImportOptions struct {
+	Overwrite bool // Replace existing items with the same key (otherwise they are rejected)
+	Partial   bool // Import every valid item, rather than all or none of them
+}
+
+// Checked item waiting to be written
+type importStep struct {
+	result *ImportResult
+	casErr *CASServerError                  // Reason the item cannot be imported, found while checking it
+	write  func() (string, *CASServerError) // Write the item, returning its outcome
+	revert func() *CASServerError           // Undo a successful write
+}
+
+// Create or update services in bulk
+// Unless opts.Partial is set, either every service is imported or none are: if any service is invalid nothing is
+// written, and services already written are reverted if the backend fails part way through
+// The report is returned along with the error when the import fails
+// Imports aren't tied to the context of a request, so a client disconnecting part way through can't interrupt a revert
+func (c *CAS) ImportServices(services []CASService, opts ImportOptions) (*ImportReport, *CASServerError) {
+	// Imports are serialized so that concurrent imports cannot interleave (or revert each other's changes)
+	c.importMu.Lock()
+	defer c.importMu.Unlock()
+
+	existing, casErr := c.Db.GetAllServices()
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	report := newImportReport(len(services))
+	steps := c.serviceImportSteps(services, existing, opts.Overwrite, report)
+	casErr = c.runImport(steps, opts.Partial, &InvalidServiceImportError, &FailedToImportServicesError)
+	report.count()
+	return report, casErr
+}
+
+func newImportReport(n int) *ImportReport {
+	return &ImportReport{Results: make([]ImportResult, n)}
+}
+
+// Write each step, all or nothing unless partial is set
+// invalidErr is returned if any step failed its checks, failedErr if a write failed (and was rolled back)
+func (c *CAS) runImport(steps []importStep, partial bool, invalidErr, failedErr *CASServerError) *CASServerError {
+	// All or nothing imports are only attempted once every item has been checked
+	if !partial {
+		valid := true
+		for _, step := range steps {
+			valid = valid && step.casErr == nil
+		}
+		if !valid {
+			for _, step := range steps {
+				step.setResult(IMPORT_SKIPPED, step.casErr)
+			}
+			return invalidErr
+		}
+	}
+
+	written := []importStep{}
+	for i, step := range steps {
+		if step.casErr != nil {
+			step.setResult("", step.casErr)
+			continue
+		}
+
+		status, casErr := step.write()
+		step.setResult(status, casErr)
+		if casErr == nil {
+			written = append(written, step)
+			continue
+		}
+		if partial {
+			continue
+		}
+
+		// Revert the items written so far (most recent first), and skip the rest
+		for j := len(written) - 1; j >= 0; j-- {
+			if casErr := written[j].revert(); casErr != nil {
+				c.Logger.Error("Failed to revert import", "name", written[j].result.Name, "email", written[j].result.Email, "error", casErr)
+			}
+			written[j].result.Status = IMPORT_SKIPPED
+		}
+		for _, rest := range steps[i+1:] {
+			rest.result.Status = IMPORT_SKIPPED
+		}
+		return failedErr
+	}
+
+	return nil
+}
+
+// Record the outcome of importing an item, which failed if an error is given
+func (step importStep) setResult(status string, casErr *CASServerError) {
+	if casErr != nil {
+		step.result.Status = IMPORT_FAILED
+		step.result.Message = casErr.Msg
+		return
+	}
+	step.result.Status = status
+}
+
+func (r *ImportReport) count() {
+	r.Created, r.Updated, r.Unchanged, r.Failed = 0, 0, 0, 0
+	for _, result := range r.Results {
+		switch result.Status {
+		case IMPORT_CREATED:
+			r.Created++
+		case IMPORT_UPDATED:
+			r.Updated++
+		case IMPORT_UNCHANGED:
+			r.Unchanged++
+		case IMPORT_FAILED:
+			r.Failed++
+		}
+	}
+}
+
+// Check each service to be imported against the existing services and the rest of the import
+// Results for the services are recorded in report
+func (c *CAS) serviceImportSteps(services, existing []CASService, overwrite bool, report *ImportReport) []importStep {
+	byName := map[string]CASService{}
+	nameByUrl := map[string]string{}
+	for _, service := range existing {
+		byName[service.Name] = service
+		nameByUrl[service.Url] = service.Name
+	}
+
+	steps := make([]importStep, len(services))
+	seenNames := map[string]bool{}
+	seenUrls := map[string]bool{}
+	for i := range services {
+		service := services[i]
+		report.Results[i] = ImportResult{Name: service.Name, Url: service.Url}
+
+		var previous *CASService
+		if existingService, ok := byName[service.Name]; ok {
+			previous = &existingService
+		}
+
+		// Valid URLs are imported normalized (as the API registers them)
+		var urlErr *CASServerError
+		if len(service.Url) > 0 && service.ValidateUrlPattern() != nil {
+			urlErr = &InvalidServiceUrlPatternError
+		} else {
+			urlErr = c.normalizeServiceUrl(&service)
+		}
+
+		step := c.serviceImportStep(service, previous)
+		step.result = &report.Results[i]
+		switch {
+		case urlErr != nil:
+			step.casErr = urlErr
+		case !c.ServiceUrlPolicy.AllowsService(&service):
+			step.casErr = &InsecureServiceUrlError
+		case !isValidImportedService(&service):
+			step.casErr = &InvalidServiceError
+		case seenNames[service.Name] || seenUrls[service.Url]:
+			step.casErr = &DuplicateImportedServiceError
+		case previous != nil && !overwrite:
+			step.casErr = &ServiceNameAlreadyTakenError
+		case len(nameByUrl[service.Url]) > 0 && nameByUrl[service.Url] != service.Name:
+			step.casErr = &ServiceUrlAlreadyTakenError
+		}
+
+		seenNames[service.Name] = true
+		seenUrls[service.Url] = true
+		steps[i] = step
+	}
+	return steps
+}
+
+// Imported services must be complete, with a name and a URL that can be parsed (unless it is a regular expression)
+func isValidImportedService(service *CASService) bool {
+	if !service.IsValid() || strings.TrimSpace(service.Name) != service.Name || strings.TrimSpace(service.Url) != service.Url {
+		return false
+	}
+	if service.UrlMatchMode() == SERVICE_MATCH_REGEX {
+		return true
+	}
+	_, err := url.Parse(service.Url)
+	return err == nil
+}
+
+// Services that already exist exactly as given are left alone (some backends refuse updates that change nothing)
+// Existing services keep their IDs and versions (new services keep any they were given, so restored backups keep theirs)
+func (c *CAS) serviceImportStep(service CASService, previous *CASService) importStep {
+	if previous != nil {
+		service.Id, service.Version = previous.Id, previous.Version
+	}
+	return importStep{
+		write: func() (string, *CASServerError) {
+			if previous == nil {
+				return IMPORT_CREATED, c.Db.AddNewService(&service)
+			}
+			if reflect.DeepEqual(*previous, service) {
+				return IMPORT_UNCHANGED, nil
+			}
+			return IMPORT_UPDATED, c.Db.UpdateService(&service)
+		},
+		revert: func() *CASServerError {
+			if previous == nil {
+				return c.Db.RemoveServiceByName(service.Name)
+			}
+			if reflect.DeepEqual(*previous, service) {
+				return nil
+			}
+
+			// Updated services are put back exactly as they were (updating them would give them a new version)
+			if casErr := c.Db.RemoveServiceByName(previous.Name); casErr != nil {
+				return casErr
+			}
+			restored := *previous
+			return c.Db.AddNewService(&restored)
+		},
+	}
+}
@@ -0,0 +1,12 @@
+package servicesecret_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoServiceSecret(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo ServiceSecret Suite")
+}
@@ -0,0 +1,209 @@
+package servicesecret_test
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	secretServiceUrl = "localhost:3000/confidential"
+	plainServiceUrl  = "localhost:3001/public"
+	secret           = "s3cr3t-0f-the-confidential-service"
+)
+
+// Service response, as read by services
+type serviceResponse struct {
+	Success *struct {
+		User string `xml:"user"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code string `xml:"code,attr"`
+	} `xml:"authenticationFailure"`
+}
+
+var _ = Describe("Service secrets", func() {
+	var (
+		server  *CAS
+		db      *MemoryBackend
+		tickets int
+	)
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+
+		hash, err := HashServiceSecret(secret)
+		Expect(err).To(BeNil())
+		Expect(db.AddNewService(&CASService{Name: "confidential", Url: secretServiceUrl, AdminEmail: "admin@test.com", ValidationSecretHash: hash})).To(BeNil())
+		Expect(db.AddNewService(&CASService{Name: "public", Url: plainServiceUrl, AdminEmail: "admin@test.com"})).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.AuditLog.Stop()
+		db.Close()
+	})
+
+	// Issue a service ticket, as a login would
+	issueTicket := func(serviceUrl string) string {
+		service, casErr := db.FindServiceByUrl(serviceUrl)
+		Expect(casErr).To(BeNil())
+		tickets++
+		ticket, casErr := db.AddTicketForService(&CASTicket{
+			Id:         "ST-" + strings.Repeat("1", tickets),
+			UserEmail:  "test@test.com",
+			ServiceUrl: serviceUrl,
+			ExpiresAt:  time.Now().Add(time.Minute),
+		}, service)
+		Expect(casErr).To(BeNil())
+		return ticket.Id
+	}
+
+	// Validate a ticket, presenting the secret in the given header (and parameters)
+	validate := func(path, serviceUrl, ticket string, headers map[string]string, params url.Values) serviceResponse {
+		if params == nil {
+			params = url.Values{}
+		}
+		params.Set("service", serviceUrl)
+		params.Set("ticket", ticket)
+		req := httptest.NewRequest("GET", path+"?"+params.Encode(), nil)
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+
+		var response serviceResponse
+		Expect(xml.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		return response
+	}
+
+	expectRefused := func(response serviceResponse) {
+		Expect(response.Success).To(BeNil())
+		Expect(response.Failure).NotTo(BeNil())
+		Expect(response.Failure.Code).To(Equal(CAS_INVALID_REQUEST))
+	}
+
+	It("Should validate tickets of services presenting their secret, in the header or as a parameter", func() {
+		for _, path := range []string{"/serviceValidate", "/p3/serviceValidate", "/proxyValidate"} {
+			response := validate(path, secretServiceUrl, issueTicket(secretServiceUrl), map[string]string{SERVICE_SECRET_HEADER: secret}, nil)
+			Expect(response.Success).NotTo(BeNil(), path)
+			Expect(response.Success.User).To(Equal("test@test.com"))
+
+			response = validate(path, secretServiceUrl, issueTicket(secretServiceUrl), nil, url.Values{SERVICE_SECRET_PARAM: {secret}})
+			Expect(response.Success).NotTo(BeNil(), path)
+		}
+	})
+
+	It("Should refuse tickets of services that don't present their secret, without consuming them", func() {
+		for _, path := range []string{"/serviceValidate", "/p3/serviceValidate", "/proxyValidate"} {
+			ticket := issueTicket(secretServiceUrl)
+			expectRefused(validate(path, secretServiceUrl, ticket, nil, nil))
+			expectRefused(validate(path, secretServiceUrl, ticket, map[string]string{SERVICE_SECRET_HEADER: "not-the-secret-of-the-service"}, nil))
+			expectRefused(validate(path, secretServiceUrl, ticket, nil, url.Values{SERVICE_SECRET_PARAM: {strings.ToUpper(secret)}}))
+
+			// The service itself can still validate the ticket
+			Expect(validate(path, secretServiceUrl, ticket, map[string]string{SERVICE_SECRET_HEADER: secret}, nil).Success).NotTo(BeNil(), path)
+		}
+
+		req := httptest.NewRequest("GET", "/validate?"+url.Values{"service": {secretServiceUrl}, "ticket": {issueTicket(secretServiceUrl)}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("error"))
+		Expect(response["failureCode"]).To(Equal(CAS_INVALID_REQUEST))
+	})
+
+	It("Should require the secret to validate proxy tickets for the service", func() {
+		Expect(db.AddProxyTicket(&CASProxyTicket{
+			Id:            "PT-1",
+			UserEmail:     "test@test.com",
+			TargetService: secretServiceUrl,
+			Proxies:       []string{"https://proxy.example.com/cb"},
+			ExpiresAt:     time.Now().Add(time.Minute),
+		})).To(BeNil())
+
+		expectRefused(validate("/proxyValidate", secretServiceUrl, "PT-1", nil, nil))
+		Expect(validate("/proxyValidate", secretServiceUrl, "PT-1", map[string]string{SERVICE_SECRET_HEADER: secret}, nil).Success).NotTo(BeNil())
+	})
+
+	It("Should validate tickets of services without a secret as before", func() {
+		Expect(validate("/serviceValidate", plainServiceUrl, issueTicket(plainServiceUrl), nil, nil).Success).NotTo(BeNil())
+		Expect(validate("/serviceValidate", plainServiceUrl, issueTicket(plainServiceUrl), map[string]string{SERVICE_SECRET_HEADER: "anything"}, nil).Success).NotTo(BeNil())
+	})
+
+	It("Should refuse secrets that aren't hashed", func() {
+		service, _ := db.FindServiceByUrl(secretServiceUrl)
+		service.ValidationSecretHash = secret
+		Expect(service.IsValid()).To(BeFalse())
+		Expect(service.SecretMatches(secret)).To(BeFalse())
+	})
+
+	Describe("Through the API", func() {
+		send := func(method, path, body string) (*httptest.ResponseRecorder, map[string]interface{}) {
+			req, _ := http.NewRequest(method, path, strings.NewReader(body))
+			req.Header.Set("X-Api-Key", "adminapikey")
+			req.Header.Set("X-Api-Secret", "badsecret")
+			w := httptest.NewRecorder()
+			server.ServeMux.ServeHTTP(w, req)
+			var parsed map[string]interface{}
+			json.Unmarshal(w.Body.Bytes(), &parsed)
+			return w, parsed
+		}
+
+		It("Should store the secrets of services hashed", func() {
+			w, body := send("POST", "/api/services", `{"name": "new", "url": "localhost:3002/new", "adminEmail": "admin@test.com", "validationSecret": "`+secret+`"}`)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Body.String()).NotTo(ContainSubstring(secret))
+
+			service, casErr := db.FindServiceByUrl("localhost:3002/new")
+			Expect(casErr).To(BeNil())
+			Expect(service.ValidationSecretHash).NotTo(ContainSubstring(secret))
+			Expect(service.SecretMatches(secret)).To(BeTrue())
+			Expect(service.SecretMatches("another secret of the service")).To(BeFalse())
+			Expect(body["data"].(map[string]interface{})["validationSecretHash"]).To(Equal(service.ValidationSecretHash))
+		})
+
+		It("Should change secrets on update, keeping the hash sent back unchanged", func() {
+			service, _ := db.FindServiceByUrl(secretServiceUrl)
+			update, err := json.Marshal(service)
+			Expect(err).To(BeNil())
+			w, _ := send("PUT", "/api/services/confidential", string(update))
+			Expect(w.Code).To(Equal(http.StatusOK))
+			updated, _ := db.FindServiceByUrl(secretServiceUrl)
+			Expect(updated.SecretMatches(secret)).To(BeTrue())
+
+			w, _ = send("PUT", "/api/services/confidential", `{"url": "`+secretServiceUrl+`", "adminEmail": "admin@test.com", "validationSecret": "the-new-secret-of-the-service"}`)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			updated, _ = db.FindServiceByUrl(secretServiceUrl)
+			Expect(updated.SecretMatches(secret)).To(BeFalse())
+			Expect(updated.SecretMatches("the-new-secret-of-the-service")).To(BeTrue())
+		})
+
+		It("Should refuse short secrets, and secrets given as plaintext hashes", func() {
+			w, body := send("POST", "/api/services", `{"name": "new", "url": "localhost:3002/new", "adminEmail": "admin@test.com", "validationSecret": "short"}`)
+			Expect(w.Code).To(Equal(WeakServiceSecretError.HttpCode))
+			Expect(body["code"]).To(Equal(WeakServiceSecretError.Code))
+
+			w, _ = send("POST", "/api/services", `{"name": "new", "url": "localhost:3002/new", "adminEmail": "admin@test.com", "validationSecretHash": "`+secret+`"}`)
+			Expect(w.Code).To(Equal(InvalidServiceError.HttpCode))
+			_, casErr := db.FindServiceByUrl("localhost:3002/new")
+			Expect(casErr).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,12 @@
+package serviceurlpolicy_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoServiceUrlPolicy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo ServiceUrlPolicy Suite")
+}
@@ -0,0 +1,193 @@
+package serviceurlpolicy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+)
+
+var _ = Describe("Service URL policy", func() {
+
+	Describe("#AllowsUrl", func() {
+		policy := &ServiceUrlPolicy{RequireHTTPS: true, ExceptionHosts: []string{"legacy.example.com"}}
+
+		It("Should only allow HTTPS URLs, and URLs for exception hosts", func() {
+			Expect(policy.AllowsUrl("https://app.example.com/cas")).To(BeTrue())
+			Expect(policy.AllowsUrl("HTTPS://app.example.com/cas")).To(BeTrue())
+			Expect(policy.AllowsUrl("http://app.example.com/cas")).To(BeFalse())
+			Expect(policy.AllowsUrl("app.example.com/cas")).To(BeFalse())
+			Expect(policy.AllowsUrl("http://legacy.example.com:8080/cas")).To(BeTrue())
+			Expect(policy.AllowsUrl("http://LEGACY.example.com/cas")).To(BeTrue())
+			Expect(policy.AllowsUrl("http://legacy.example.com.evil.com/cas")).To(BeFalse())
+		})
+
+		It("Should allow any URL when disabled", func() {
+			Expect((&ServiceUrlPolicy{}).AllowsUrl("http://app.example.com/cas")).To(BeTrue())
+			Expect((&ServiceUrlPolicy{}).AllowsUrl("localhost:3000")).To(BeTrue())
+		})
+	})
+
+	Describe("#AllowsService", func() {
+		policy := &ServiceUrlPolicy{RequireHTTPS: true}
+		allows := func(mode, pattern string) bool {
+			return policy.AllowsService(&CASService{Url: pattern, MatchMode: mode})
+		}
+
+		It("Should only allow regular expressions that cannot match non-HTTPS URLs", func() {
+			Expect(allows(SERVICE_MATCH_REGEX, `https://[a-z]+\.example\.com/cas`)).To(BeTrue())
+			Expect(allows(SERVICE_MATCH_REGEX, `https://a\.example\.com|https://b\.example\.com`)).To(BeTrue())
+			Expect(allows(SERVICE_MATCH_REGEX, `https://a\.example\.com|http://b\.example\.com`)).To(BeFalse())
+			Expect(allows(SERVICE_MATCH_REGEX, `https?://a\.example\.com`)).To(BeFalse())
+			Expect(allows(SERVICE_MATCH_REGEX, `.*`)).To(BeFalse())
+		})
+
+		It("Should check prefix and wildcard services by their URL", func() {
+			Expect(allows(SERVICE_MATCH_PREFIX, "https://portal.example.com/")).To(BeTrue())
+			Expect(allows(SERVICE_MATCH_PREFIX, "http://portal.example.com/")).To(BeFalse())
+			Expect(allows(SERVICE_MATCH_WILDCARD, "http://*.example.com/app")).To(BeFalse())
+		})
+	})
+
+	Describe("Requests for services", func() {
+		var (
+			server *CAS
+			db     *MemoryBackend
+		)
+
+		newServer := func(requireHTTPS, exceptions, registeredOnly string) {
+			server, db = castest.NewTestServer(map[string]string{
+				"requireHTTPSServices":   requireHTTPS,
+				"httpsServiceExceptions": exceptions,
+				"registeredServicesOnly": registeredOnly,
+			})
+
+			// Registered directly, as if they predate the policy being enabled
+			for name, serviceUrl := range map[string]string{
+				"secure":   "https://app.example.com/cas",
+				"insecure": "http://app.example.com/cas",
+				"legacy":   "http://legacy.example.com/cas",
+			} {
+				Expect(db.AddNewService(&CASService{Name: name, Url: serviceUrl, AdminEmail: "admin@test.com"})).To(BeNil())
+			}
+		}
+
+		login := func(serviceUrl string) *httptest.ResponseRecorder {
+			return castest.Login(server, url.Values{"serviceUrl": {serviceUrl}})
+		}
+
+		validateTicket := func(serviceUrl, ticket string) map[string]interface{} {
+			req, _ := http.NewRequest("GET", "/validate?"+url.Values{"service": {serviceUrl}, "ticket": {ticket}}.Encode(), nil)
+			w := httptest.NewRecorder()
+			server.ServeMux.ServeHTTP(w, req)
+			var response map[string]interface{}
+			Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+			return response
+		}
+
+		validate := func(serviceUrl string) map[string]interface{} {
+			return validateTicket(serviceUrl, "ST-unknown")
+		}
+
+		createService := func(serviceUrl string) *httptest.ResponseRecorder {
+			body, _ := json.Marshal(CASService{Name: "created", Url: serviceUrl, AdminEmail: "admin@test.com"})
+			req, _ := http.NewRequest("POST", "/api/services", bytes.NewReader(body))
+			req.Header.Set("X-Api-Key", "adminapikey")
+			req.Header.Set("X-Api-Secret", "badsecret")
+			w := httptest.NewRecorder()
+			server.ServeMux.ServeHTTP(w, req)
+			return w
+		}
+
+		AfterEach(func() {
+			castest.Close(server)
+		})
+
+		It("Should refuse logins and validation for non-HTTPS services", func() {
+			newServer("true", "", "")
+
+			w := login("http://app.example.com/cas")
+			Expect(w.Code).To(Equal(InsecureServiceUrlError.HttpCode))
+			Expect(w.Body.String()).To(ContainSubstring(InsecureServiceUrlError.Msg))
+
+			response := validate("http://app.example.com/cas")
+			Expect(response["status"]).To(Equal("error"))
+			Expect(response["code"]).To(Equal(strconv.Itoa(InsecureServiceUrlError.CasgoErrCode)))
+			Expect(response["message"]).To(Equal(InsecureServiceUrlError.Msg))
+
+			Expect(login("https://app.example.com/cas").Code).To(Equal(http.StatusFound))
+		})
+
+		It("Should refuse to register non-HTTPS services", func() {
+			newServer("true", "", "")
+
+			w := createService("http://new.example.com/cas")
+			Expect(w.Code).To(Equal(InsecureServiceUrlError.HttpCode))
+			Expect(w.Body.String()).To(ContainSubstring(InsecureServiceUrlError.Msg))
+			_, casErr := db.FindServiceByUrl("http://new.example.com/cas")
+			Expect(casErr).NotTo(BeNil())
+
+			Expect(createService("https://new.example.com/cas").Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should allow non-HTTPS services on exception hosts", func() {
+			newServer("true", "legacy.example.com, other.example.com", "")
+
+			Expect(login("http://legacy.example.com/cas").Code).To(Equal(http.StatusFound))
+			Expect(login("http://app.example.com/cas").Code).To(Equal(InsecureServiceUrlError.HttpCode))
+			Expect(createService("http://other.example.com/cas").Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should allow non-HTTPS services by default", func() {
+			newServer("", "", "")
+
+			Expect(login("http://app.example.com/cas").Code).To(Equal(http.StatusFound))
+			Expect(validate("http://app.example.com/cas")["code"]).NotTo(Equal(strconv.Itoa(InsecureServiceUrlError.CasgoErrCode)))
+			Expect(createService("http://new.example.com/cas").Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should issue tickets for registered services", func() {
+			newServer("", "", "")
+
+			w := login("https://app.example.com/cas")
+			Expect(w.Code).To(Equal(http.StatusFound))
+			Expect(w.Header().Get("Location")).To(HavePrefix("https://app.example.com/cas?ticket="))
+			Expect(validateTicket("https://app.example.com/cas", castest.Ticket(w))["status"]).To(Equal("success"))
+		})
+
+		It("Should refuse logins and validation for unregistered services by default", func() {
+			newServer("", "", "")
+
+			w := login("https://evil.example.com/phish")
+			Expect(w.Code).To(Equal(http.StatusNotFound))
+			Expect(w.Header().Get("Location")).To(BeEmpty())
+			Expect(w.Body.String()).To(ContainSubstring("Failed to find matching service with URL [https://evil.example.com/phish]"))
+			Expect(w.Body.String()).To(ContainSubstring(`id="login-without-service"`))
+			Expect(w.Body.String()).NotTo(ContainSubstring(`id="frmLogin"`))
+
+			response := validate("https://evil.example.com/phish")
+			Expect(response["status"]).To(Equal("error"))
+			Expect(response["code"]).To(Equal(strconv.Itoa(FailedToFindServiceError.CasgoErrCode)))
+		})
+
+		It("Should issue tickets for unregistered services when registeredServicesOnly is disabled", func() {
+			newServer("", "", "false")
+
+			w := login("https://unregistered.example.com/cas")
+			Expect(w.Code).To(Equal(http.StatusFound))
+			Expect(w.Header().Get("Location")).To(HavePrefix("https://unregistered.example.com/cas?ticket="))
+			Expect(validateTicket("https://unregistered.example.com/cas", castest.Ticket(w))["status"]).To(Equal("success"))
+
+			// The HTTPS policy still applies
+			db.Close()
+			newServer("true", "", "false")
+			Expect(login("http://unregistered.example.com/cas").Code).To(Equal(InsecureServiceUrlError.HttpCode))
+		})
+	})
+})
@@ -0,0 +1,12 @@
+package serviceversion_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoServiceVersion(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Service Version Suite")
+}
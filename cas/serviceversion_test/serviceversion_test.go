@@ -0,0 +1,144 @@
+package serviceversion_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+)
+
+const updatedUrl = "localhost:4001/validate"
+
+var _ = Describe("PUT /api/services/{name} versions", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	request := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Api-Key", "adminapikey")
+		req.Header.Set("X-Api-Secret", "badsecret")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.ServeMux.ServeHTTP(w, req)
+		return w
+	}
+
+	create := func() *httptest.ResponseRecorder {
+		return request("POST", "/api/services", `{"name":"versioned","url":"localhost:4000/validate","adminEmail":"admin@test.com"}`, nil)
+	}
+
+	update := func(body string, headers map[string]string) *httptest.ResponseRecorder {
+		return request("PUT", "/api/services/versioned", body, headers)
+	}
+
+	// Body of an update of the whole service
+	service := func(url, adminEmail string) string {
+		return `{"name":"versioned","url":"` + url + `","adminEmail":"` + adminEmail + `"}`
+	}
+
+	stored := func() CASService {
+		services, casErr := db.GetAllServices()
+		Expect(casErr).To(BeNil())
+		versioned := []CASService{}
+		for _, service := range services {
+			if service.Name == "versioned" {
+				versioned = append(versioned, service)
+			}
+		}
+		Expect(versioned).To(HaveLen(1))
+		return versioned[0]
+	}
+
+	errorCode := func(w *httptest.ResponseRecorder) interface{} {
+		var body map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &body)).To(Succeed())
+		return body["code"]
+	}
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	Describe("By default", func() {
+		BeforeEach(func() {
+			server, db = castest.NewTestServer(nil)
+			w := create()
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("ETag")).To(Equal(`"1"`))
+		})
+
+		It("Should update the version given by If-Match", func() {
+			w := update(service(updatedUrl, "admin@test.com"), map[string]string{"If-Match": `"1"`})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("ETag")).To(Equal(`"2"`))
+			Expect(w.Body.String()).To(ContainSubstring(`"version":2`))
+			Expect(stored().Url).To(Equal(updatedUrl))
+			Expect(stored().Version).To(Equal(2))
+		})
+
+		It("Should refuse updates of a stale version without applying them", func() {
+			Expect(update(service("localhost:4000/validate", "other@test.com"), map[string]string{"If-Match": `"1"`}).Code).To(Equal(http.StatusOK))
+
+			w := update(service(updatedUrl, "admin@test.com"), map[string]string{"If-Match": `"1"`})
+			Expect(w.Code).To(Equal(http.StatusConflict))
+			Expect(errorCode(w)).To(Equal(ServiceVersionConflictError.Code))
+			Expect(stored().Url).To(Equal("localhost:4000/validate"))
+			Expect(stored().Version).To(Equal(2))
+		})
+
+		It("Should check the version in the update when there is no If-Match header", func() {
+			Expect(update(`{"version":1,"name":"versioned","url":"localhost:4000/validate","adminEmail":"other@test.com"}`, nil).Code).To(Equal(http.StatusOK))
+
+			w := update(`{"version":1,"name":"versioned","url":"`+updatedUrl+`","adminEmail":"admin@test.com"}`, nil)
+			Expect(w.Code).To(Equal(http.StatusConflict))
+			Expect(stored().Url).To(Equal("localhost:4000/validate"))
+		})
+
+		It("Should refuse malformed and weak entity tags", func() {
+			for _, tag := range []string{"1", `W/"1"`, `"one"`, `"1", "2"`} {
+				w := update(service(updatedUrl, "admin@test.com"), map[string]string{"If-Match": tag})
+				Expect(w.Code).To(Equal(http.StatusConflict), tag)
+			}
+			Expect(stored().Version).To(Equal(1))
+		})
+
+		It("Should apply updates without a precondition to any version", func() {
+			Expect(update(service("localhost:4000/validate", "other@test.com"), nil).Code).To(Equal(http.StatusOK))
+
+			w := update(service(updatedUrl, "admin@test.com"), nil)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("ETag")).To(Equal(`"3"`))
+			Expect(stored().Url).To(Equal(updatedUrl))
+		})
+	})
+
+	Describe("With requireServiceIfMatch", func() {
+		BeforeEach(func() {
+			server, db = castest.NewTestServer(map[string]string{"requireServiceIfMatch": "true"})
+			Expect(create().Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should refuse updates without a precondition", func() {
+			w := update(service(updatedUrl, "admin@test.com"), nil)
+			Expect(w.Code).To(Equal(http.StatusPreconditionRequired))
+			Expect(errorCode(w)).To(Equal(ServiceVersionRequiredError.Code))
+			Expect(stored().Url).To(Equal("localhost:4000/validate"))
+			Expect(stored().Version).To(Equal(1))
+		})
+
+		It("Should accept updates of any version with If-Match: *", func() {
+			w := update(service(updatedUrl, "admin@test.com"), map[string]string{"If-Match": "*"})
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(stored().Version).To(Equal(2))
+		})
+	})
+})
@@ -0,0 +1,352 @@
+package cas
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/securecookie"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Session cookie setup
+ */
+
+// Create the session store specified by server configuration (sessionStore): cookie (the default) keeps session
+// values in the cookie itself, redis keeps them in Redis so they are shared between nodes
+func NewSessionStoreFromConfig(config map[string]string) (sessions.Store, error) {
+	switch strings.ToLower(configValueOrDefault(config, "sessionStore")) {
+	case "cookie":
+		return NewSessionCookieStore(config)
+	case "redis":
+		return NewSessionRedisStore(config)
+	default:
+		return nil, fmt.Errorf("Unknown sessionStore [%s], expected cookie or redis", config["sessionStore"])
+	}
+}
+
+// Create the session cookie store, with cookie attributes taken from server configuration
+func NewSessionCookieStore(config map[string]string) (*sessions.CookieStore, error) {
+	options, err := NewSessionCookieOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	serializer, err := NewSessionSerializerFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	codecMaxAge, err := sessionCodecMaxAge(config, options)
+	if err != nil {
+		return nil, err
+	}
+
+	cookieStore := sessions.NewCookieStore([]byte(config["cookieSecret"]))
+	cookieStore.Options = options
+	for _, codec := range cookieStore.Codecs {
+		if secureCookie, ok := codec.(*securecookie.SecureCookie); ok {
+			secureCookie.SetSerializer(&sessionValuesSerializer{serializer})
+			if codecMaxAge > 0 {
+				secureCookie.MaxAge(codecMaxAge)
+			}
+		}
+	}
+	return cookieStore, nil
+}
+
+// Create the Redis session store, with cookie attributes taken from server configuration and sessions kept for
+// as long as their cookie, or for the lifetime of login sessions (tgtTTL) when it lasts as long as the browser
+func NewSessionRedisStore(config map[string]string) (*RedisSessionStore, error) {
+	options, err := NewSessionCookieOptions(config)
+	if err != nil {
+		return nil, err
+	}
+
+	serializer, err := NewSessionSerializerFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	codecMaxAge, err := sessionCodecMaxAge(config, options)
+	if err != nil {
+		return nil, err
+	}
+
+	redisConfig, err := NewRedisConfigFromConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	store := NewRedisSessionStore(NewRedisClient(redisConfig), serializer, configValueOrDefault(config, "sessionRedisPrefix"), configSecondsAsDuration(config, "tgtTTL"), []byte(config["cookieSecret"]))
+	store.Options = options
+	for _, codec := range store.Codecs {
+		if secureCookie, ok := codec.(*securecookie.SecureCookie); ok && codecMaxAge > 0 {
+			secureCookie.MaxAge(codecMaxAge)
+		}
+	}
+	return store, nil
+}
+
+// How long session cookies must stay decodable: for as long as the longest lived (remembered) session
+func sessionCodecMaxAge(config map[string]string, options *sessions.Options) (int, error) {
+	codecMaxAge := options.MaxAge
+	rememberMe, err := configBool(config, "rememberMeEnabled")
+	if err != nil {
+		return 0, err
+	}
+	if rememberMe {
+		rememberMeTTL, err := configInt(config, "rememberMeTTL")
+		if err != nil {
+			return 0, err
+		}
+		if rememberMeTTL <= 0 {
+			return 0, fmt.Errorf("Invalid rememberMeTTL [%d], must be a positive number of seconds", rememberMeTTL)
+		}
+		if rememberMeTTL > codecMaxAge {
+			codecMaxAge = rememberMeTTL
+		}
+	}
+	return codecMaxAge, nil
+}
+
+// Build (and validate) session cookie options from server configuration
+func NewSessionCookieOptions(config map[string]string) (*sessions.Options, error) {
+	secure, err := configBool(config, "cookieSecure")
+	if err != nil {
+		return nil, err
+	}
+
+	httpOnly, err := configBool(config, "cookieHttpOnly")
+	if err != nil {
+		return nil, err
+	}
+
+	sameSite, err := parseSameSite(config["cookieSameSite"])
+	if err != nil {
+		return nil, err
+	}
+	if sameSite == http.SameSiteNoneMode && !secure {
+		return nil, fmt.Errorf("cookieSameSite [none] requires cookieSecure (browsers reject SameSite=None cookies that aren't secure)")
+	}
+
+	maxAge, err := configInt(config, "cookieMaxAge")
+	if err != nil {
+		return nil, err
+	}
+
+	basePath, err := ParseBasePath(config["basePath"])
+	if err != nil {
+		return nil, err
+	}
+
+	return &sessions.Options{
+		Path:     cookiePath(basePath),
+		Domain:   config["cookieDomain"],
+		MaxAge:   maxAge,
+		Secure:   secure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}, nil
+}
+
+// Parse a SameSite cookie attribute value ("lax", "strict", "none")
+func parseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("Invalid cookieSameSite value [%s], expected one of lax, strict, none", value)
+	}
+}
+
+// Get a boolean configuration value, falling back to the default if unset
+func configBool(config map[string]string, key string) (bool, error) {
+	value := config[key]
+	if len(value) == 0 {
+		value = CONFIG_DEFAULTS[key]
+	}
+
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s value [%s], expected true or false", key, value)
+	}
+	return parsed, nil
+}
+
+// Get an integer configuration value, falling back to the default if unset
+func configInt(config map[string]string, key string) (int, error) {
+	value := config[key]
+	if len(value) == 0 {
+		value = CONFIG_DEFAULTS[key]
+	}
+
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid %s value [%s], expected a number", key, value)
+	}
+	return parsed, nil
+}
+
+/*
+ * Session serialization
+ */
+
+// Contents of a casgo login session
+type Session struct {
+	CurrentUser *User                  `json:"currentUser,omitempty"`
+	TGTId       string                 `json:"tgtId,omitempty"`
+	CreatedAt   time.Time              `json:"createdAt,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"` // Any other session values
+}
+
+// Format used to store sessions
+type SessionSerializer interface {
+	Serialize(Session) ([]byte, error)
+	Deserialize([]byte) (Session, error)
+}
+
+// Create the session serializer specified by server configuration
+func NewSessionSerializerFromConfig(config map[string]string) (SessionSerializer, error) {
+	switch strings.ToLower(config["sessionSerializer"]) {
+	case "", "json":
+		return &JSONSessionSerializer{}, nil
+	case "gob":
+		return &GobSessionSerializer{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown sessionSerializer [%s], expected json or gob", config["sessionSerializer"])
+	}
+}
+
+// Stores sessions as JSON, which is easy to inspect (values in Data are decoded as generic JSON types)
+type JSONSessionSerializer struct{}
+
+func (s *JSONSessionSerializer) Serialize(session Session) ([]byte, error) {
+	return json.Marshal(session)
+}
+
+func (s *JSONSessionSerializer) Deserialize(data []byte) (Session, error) {
+	var session Session
+	err := json.Unmarshal(data, &session)
+	return session, err
+}
+
+// Stores sessions with encoding/gob (types of values in Data must be registered with gob.Register)
+type GobSessionSerializer struct{}
+
+func (s *GobSessionSerializer) Serialize(session Session) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(session)
+	return buf.Bytes(), err
+}
+
+func (s *GobSessionSerializer) Deserialize(data []byte) (Session, error) {
+	var session Session
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&session)
+	return session, err
+}
+
+func init() {
+	// Types of the values casgo stores in sessions
+	gob.Register([]CASService{})
+	gob.Register(User{})
+
+	// Allow generic (JSON-like) values to be stored in gob encoded sessions
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+	gob.Register(time.Time{})
+}
+
+// Adapts a SessionSerializer to the securecookie serializer interface, which encodes raw session values
+type sessionValuesSerializer struct {
+	serializer SessionSerializer
+}
+
+func (s *sessionValuesSerializer) Serialize(src interface{}) ([]byte, error) {
+	values, ok := src.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Unexpected session values type [%T]", src)
+	}
+
+	session, err := sessionFromValues(values)
+	if err != nil {
+		return nil, err
+	}
+	return s.serializer.Serialize(session)
+}
+
+func (s *sessionValuesSerializer) Deserialize(src []byte, dst interface{}) error {
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("Unexpected session values type [%T]", dst)
+	}
+
+	session, err := s.serializer.Deserialize(src)
+	if err != nil {
+		// Cookies set before sessions had a serializer hold gob encoded values, keep accepting them
+		if legacyErr := (securecookie.GobEncoder{}).Deserialize(src, dst); legacyErr == nil {
+			return nil
+		}
+		return err
+	}
+
+	*values = valuesFromSession(session)
+	return nil
+}
+
+// Convert raw session values into a Session
+func sessionFromValues(values map[interface{}]interface{}) (Session, error) {
+	session := Session{}
+	for rawKey, value := range values {
+		key, ok := rawKey.(string)
+		if !ok {
+			return session, fmt.Errorf("Session keys must be strings, got [%T]", rawKey)
+		}
+
+		switch key {
+		case "currentUser":
+			user, ok := value.(User)
+			if !ok {
+				return session, fmt.Errorf("Unexpected type [%T] for session value currentUser", value)
+			}
+			session.CurrentUser = &user
+		case "tgtId":
+			session.TGTId, _ = value.(string)
+		case "createdAt":
+			session.CreatedAt, _ = value.(time.Time)
+		default:
+			if session.Data == nil {
+				session.Data = make(map[string]interface{})
+			}
+			session.Data[key] = value
+		}
+	}
+	return session, nil
+}
+
+// Convert a Session into raw session values
+func valuesFromSession(session Session) map[interface{}]interface{} {
+	values := make(map[interface{}]interface{})
+	for key, value := range session.Data {
+		values[key] = value
+	}
+	if session.CurrentUser != nil {
+		values["currentUser"] = *session.CurrentUser
+	}
+	if len(session.TGTId) > 0 {
+		values["tgtId"] = session.TGTId
+	}
+	if !session.CreatedAt.IsZero() {
+		values["createdAt"] = session.CreatedAt
+	}
+	return values
+}
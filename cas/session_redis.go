@@ -0,0 +1,141 @@
+package cas
+
+import (
+	"encoding/base32"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/securecookie"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"net/http"
+	"strings"
+	"time"
+)
+
+/*
+ * Redis session store, sharing sessions between casgo nodes
+ */
+
+// Stores session values in Redis, under a key derived from a random session ID (the only thing kept in the
+// cookie, signed and encrypted with the cookie secret), so every node configured with the same Redis server
+// (and cookieSecret) sees the same sessions. Keys expire with the session: after the cookie's Max-Age, or after
+// TTL for sessions whose cookie lasts as long as the browser session
+type RedisSessionStore struct {
+	Client    *RedisClient
+	Codecs    []securecookie.Codec // Encode session IDs in cookies
+	Options   *sessions.Options    // Default cookie options
+	KeyPrefix string               // Prefix of the keys sessions are stored under (ex. casgo:session:)
+	TTL       time.Duration        // Lifetime of sessions without a Max-Age
+
+	serializer *sessionValuesSerializer
+}
+
+// Create a Redis session store, with session values encoded by serializer
+func NewRedisSessionStore(client *RedisClient, serializer SessionSerializer, keyPrefix string, ttl time.Duration, keyPairs ...[]byte) *RedisSessionStore {
+	return &RedisSessionStore{
+		Client:     client,
+		Codecs:     securecookie.CodecsFromPairs(keyPairs...),
+		Options:    &sessions.Options{Path: "/"},
+		KeyPrefix:  keyPrefix,
+		TTL:        ttl,
+		serializer: &sessionValuesSerializer{serializer},
+	}
+}
+
+// Get returns a session for the given name after adding it to the registry
+func (s *RedisSessionStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns a session for the given name without adding it to the registry, loading its values from Redis
+// if the request carries the cookie of an existing session
+func (s *RedisSessionStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, cookie.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+
+	found, err := s.load(session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		// Expired (or deleted) sessions get a new ID when saved
+		session.ID = ""
+		return session, nil
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save stores the session's values in Redis and sets the session cookie, or deletes both if the session's
+// Max-Age is negative
+func (s *RedisSessionStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if len(session.ID) > 0 {
+			if err := s.Client.Del(s.key(session.ID)); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, sessions.NewCookie(session.Name(), "", session.Options))
+		return nil
+	}
+
+	if len(session.ID) == 0 {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), "=")
+	}
+	if err := s.save(session); err != nil {
+		return err
+	}
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Close idle Redis connections
+func (s *RedisSessionStore) Close() {
+	s.Client.Close()
+}
+
+// Key a session is stored under
+func (s *RedisSessionStore) key(id string) string {
+	return s.KeyPrefix + id
+}
+
+// Lifetime of a session in Redis: as long as its cookie, or TTL for browser session cookies
+func (s *RedisSessionStore) ttl(session *sessions.Session) time.Duration {
+	if session.Options.MaxAge > 0 {
+		return time.Duration(session.Options.MaxAge) * time.Second
+	}
+	return s.TTL
+}
+
+// Write the session's values to Redis
+func (s *RedisSessionStore) save(session *sessions.Session) error {
+	data, err := s.serializer.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	return s.Client.Set(s.key(session.ID), data, s.ttl(session))
+}
+
+// Read the session's values from Redis, reporting whether the session exists
+func (s *RedisSessionStore) load(session *sessions.Session) (bool, error) {
+	data, err := s.Client.Get(s.key(session.ID))
+	if err != nil || data == nil {
+		return false, err
+	}
+	if err := s.serializer.Deserialize(data, &session.Values); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,197 @@
+package session_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Mock Redis server supporting AUTH, SELECT, PING, GET, SET (with EX) and DEL
+ */
+
+type mockRedisValue struct {
+	data []byte
+	ttl  time.Duration
+}
+
+type mockRedisServer struct {
+	listener net.Listener
+	password string
+	mu       sync.Mutex
+	dbs      map[int]map[string]mockRedisValue
+	conns    []net.Conn
+	dials    int
+	commands []string
+}
+
+// Start a mock Redis server, requiring AUTH with password if it is set
+func newMockRedisServer(password string) *mockRedisServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+	server := &mockRedisServer{
+		listener: listener,
+		password: password,
+		dbs:      map[int]map[string]mockRedisValue{},
+	}
+	go server.serve()
+	return server
+}
+
+func (s *mockRedisServer) Addr() string { return s.listener.Addr().String() }
+func (s *mockRedisServer) Close()       { s.listener.Close(); s.DropConnections() }
+
+func (s *mockRedisServer) Dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+// Names of the commands received, in order
+func (s *mockRedisServer) Commands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// Keys stored in a database, with their value and TTL
+func (s *mockRedisServer) Keys(db int) map[string]mockRedisValue {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := map[string]mockRedisValue{}
+	for key, value := range s.dbs[db] {
+		keys[key] = value
+	}
+	return keys
+}
+
+// Expire a key, as if its TTL elapsed
+func (s *mockRedisServer) Expire(db int, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.dbs[db], key)
+}
+
+// Close open connections (as Redis does with idle clients after its timeout)
+func (s *mockRedisServer) DropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *mockRedisServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.dials++
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *mockRedisServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	db, authenticated := 0, len(s.password) == 0
+
+	for {
+		args, err := readCommand(reader)
+		if err != nil || len(args) == 0 {
+			return
+		}
+		name := strings.ToUpper(args[0])
+		s.mu.Lock()
+		s.commands = append(s.commands, name)
+		s.mu.Unlock()
+
+		if name == "AUTH" {
+			if len(args) == 2 && args[1] == s.password {
+				authenticated = true
+				io.WriteString(conn, "+OK\r\n")
+			} else {
+				io.WriteString(conn, "-WRONGPASS invalid username-password pair\r\n")
+			}
+			continue
+		}
+		if !authenticated {
+			io.WriteString(conn, "-NOAUTH Authentication required.\r\n")
+			continue
+		}
+
+		switch {
+		case name == "PING":
+			io.WriteString(conn, "+PONG\r\n")
+		case name == "SELECT" && len(args) == 2:
+			db, _ = strconv.Atoi(args[1])
+			io.WriteString(conn, "+OK\r\n")
+		case name == "GET" && len(args) == 2:
+			s.mu.Lock()
+			value, ok := s.dbs[db][args[1]]
+			s.mu.Unlock()
+			if !ok {
+				io.WriteString(conn, "$-1\r\n")
+				continue
+			}
+			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value.data), value.data)
+		case name == "SET" && len(args) == 5 && strings.ToUpper(args[3]) == "EX":
+			seconds, _ := strconv.Atoi(args[4])
+			s.mu.Lock()
+			if s.dbs[db] == nil {
+				s.dbs[db] = map[string]mockRedisValue{}
+			}
+			s.dbs[db][args[1]] = mockRedisValue{data: []byte(args[2]), ttl: time.Duration(seconds) * time.Second}
+			s.mu.Unlock()
+			io.WriteString(conn, "+OK\r\n")
+		case name == "DEL" && len(args) == 2:
+			s.mu.Lock()
+			_, ok := s.dbs[db][args[1]]
+			delete(s.dbs[db], args[1])
+			s.mu.Unlock()
+			deleted := 0
+			if ok {
+				deleted = 1
+			}
+			fmt.Fprintf(conn, ":%d\r\n", deleted)
+		default:
+			fmt.Fprintf(conn, "-ERR unknown command '%s'\r\n", args[0])
+		}
+	}
+}
+
+// Read a command sent as an array of bulk strings
+func readCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("expected an array, got [%q] (%v)", line, err)
+	}
+	count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		line, err := reader.ReadString('\n')
+		if err != nil || !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected a bulk string, got [%q] (%v)", line, err)
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:size]))
+	}
+	return args, nil
+}
@@ -0,0 +1,80 @@
+package session_test
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/url"
+)
+
+// Ticket generator that issues service tickets, but fails to generate ticket-granting tickets
+type failingTGTGenerator struct{ DefaultTicketGenerator }
+
+func (g *failingTGTGenerator) GenerateTGT() (string, error) {
+	return "", errors.New("entropy exhausted")
+}
+
+var _ = Describe("Saving sessions on login", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+	)
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should render the login page with an error when the session can't be saved", func() {
+		redis := newMockRedisServer("hunter2")
+		defer redis.Close()
+		server, db = castest.NewTestServer(map[string]string{"sessionStore": "redis", "redisAddr": redis.Addr(), "redisPassword": "wrong"})
+
+		for _, form := range []url.Values{
+			{},
+			{"serviceUrl": {"localhost:3000/validateCASLogin"}},
+		} {
+			w := castest.Login(server, form)
+			Expect(w.Code).To(Equal(FailedToSaveSessionError.HttpCode))
+			Expect(w.Header().Get("Location")).To(BeEmpty())
+			Expect(w.Body.String()).To(ContainSubstring(FailedToSaveSessionError.Msg))
+		}
+
+		w := castest.Login(server, url.Values{"serviceUrl": {"localhost:3000/validateCASLogin"}, "gateway": {"true"}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal("localhost:3000/validateCASLogin"))
+
+		// Ticket-granting tickets of sessions that were never saved are removed
+		sessions, casErr := db.FindTicketGrantingTicketsForUser("test@test.com")
+		Expect(casErr).To(BeNil())
+		Expect(sessions).To(BeEmpty())
+	})
+
+	It("Should render the login page with an error when the ticket-granting ticket can't be created", func() {
+		server, db = castest.NewTestServer(nil)
+		server.TicketGenerator = &failingTGTGenerator{}
+
+		for _, form := range []url.Values{
+			{},
+			{"rememberMe": {"true"}},
+			{"serviceUrl": {"localhost:3000/validateCASLogin"}},
+			{"gateway": {"true"}},
+		} {
+			w := castest.Login(server, form)
+			Expect(w.Code).To(Equal(FailedToCreateTicketGrantingTicketError.HttpCode))
+			Expect(w.Header().Get("Location")).To(BeEmpty())
+			Expect(w.Body.String()).To(ContainSubstring(FailedToCreateTicketGrantingTicketError.Msg))
+		}
+
+		// Gateway logins never stop at the login page, the service gets no ticket
+		w := castest.Login(server, url.Values{"serviceUrl": {"localhost:3000/validateCASLogin"}, "gateway": {"true"}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal("localhost:3000/validateCASLogin"))
+
+		sessions, casErr := db.FindTicketGrantingTicketsForUser("test@test.com")
+		Expect(casErr).To(BeNil())
+		Expect(sessions).To(BeEmpty())
+	})
+})
@@ -0,0 +1,178 @@
+package session_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("Redis session store", func() {
+	var redis *mockRedisServer
+
+	BeforeEach(func() {
+		redis = newMockRedisServer("")
+	})
+
+	AfterEach(func() {
+		redis.Close()
+	})
+
+	redisConfig := func(overrides map[string]string) map[string]string {
+		config := configWith(map[string]string{"sessionStore": "redis", "redisAddr": redis.Addr()})
+		for k, v := range overrides {
+			config[k] = v
+		}
+		return config
+	}
+
+	// Create a store, as each casgo node would
+	newStore := func(config map[string]string) *RedisSessionStore {
+		store, err := NewSessionStoreFromConfig(config)
+		Expect(err).To(BeNil())
+		Expect(store).To(BeAssignableToTypeOf(&RedisSessionStore{}))
+		return store.(*RedisSessionStore)
+	}
+
+	// Save a session with the given values, returning the Set-Cookie header
+	save := func(store sessions.Store, cookie string, values map[string]interface{}) (*sessions.Session, string) {
+		req, _ := http.NewRequest("GET", "/login", nil)
+		if len(cookie) > 0 {
+			req.Header.Set("Cookie", cookie)
+		}
+		w := httptest.NewRecorder()
+		session, _ := store.Get(req, "casgo-session")
+		for key, value := range values {
+			session.Values[key] = value
+		}
+		Expect(session.Save(req, w)).To(BeNil())
+		return session, w.Header().Get("Set-Cookie")
+	}
+
+	load := func(store sessions.Store, cookie string) *sessions.Session {
+		req, _ := http.NewRequest("GET", "/login", nil)
+		req.Header.Set("Cookie", cookie)
+		session, err := store.Get(req, "casgo-session")
+		Expect(err).To(BeNil())
+		return session
+	}
+
+	It("Should default to the cookie store", func() {
+		store, err := NewSessionStoreFromConfig(configWith(nil))
+		Expect(err).To(BeNil())
+		Expect(store).To(BeAssignableToTypeOf(&sessions.CookieStore{}))
+	})
+
+	It("Should share sessions between store instances", func() {
+		user := User{Email: "test@test.com", Name: "Test"}
+		config := redisConfig(nil)
+		_, cookie := save(newStore(config), "", map[string]interface{}{"currentUser": user, "tgtId": "TGT-1"})
+
+		loaded := load(newStore(config), cookie)
+		Expect(loaded.IsNew).To(BeFalse())
+		Expect(loaded.Values["currentUser"]).To(Equal(user))
+		Expect(loaded.Values["tgtId"]).To(Equal("TGT-1"))
+
+		// Only the (encoded) session ID is kept in the cookie
+		Expect(cookie).ToNot(ContainSubstring("test@test.com"))
+		Expect(len(cookie)).To(BeNumerically("<", 400))
+	})
+
+	It("Should store sessions under prefixed keys, expiring with the session", func() {
+		store := newStore(redisConfig(map[string]string{"sessionRedisPrefix": "sso:", "tgtTTL": "600"}))
+		session, _ := save(store, "", map[string]interface{}{"tgtId": "TGT-1"})
+		keys := redis.Keys(0)
+		Expect(keys).To(HaveLen(1))
+		Expect(keys).To(HaveKey("sso:" + session.ID))
+		Expect(keys["sso:"+session.ID].ttl).To(Equal(600 * time.Second))
+
+		// Sessions with a Max-Age (ex. remembered logins) last as long as their cookie
+		req, _ := http.NewRequest("GET", "/login", nil)
+		remembered, _ := store.New(req, "casgo-session")
+		remembered.Options.MaxAge = 7200
+		Expect(remembered.Save(req, httptest.NewRecorder())).To(BeNil())
+		Expect(redis.Keys(0)["sso:"+remembered.ID].ttl).To(Equal(7200 * time.Second))
+	})
+
+	It("Should delete sessions with a negative Max-Age", func() {
+		store := newStore(redisConfig(nil))
+		_, cookie := save(store, "", map[string]interface{}{"tgtId": "TGT-1"})
+		Expect(redis.Keys(0)).To(HaveLen(1))
+
+		req, _ := http.NewRequest("GET", "/logout", nil)
+		req.Header.Set("Cookie", cookie)
+		w := httptest.NewRecorder()
+		session, _ := store.Get(req, "casgo-session")
+		session.Options.MaxAge = -1
+		Expect(session.Save(req, w)).To(BeNil())
+		Expect(redis.Keys(0)).To(BeEmpty())
+		Expect(w.Header().Get("Set-Cookie")).To(ContainSubstring("Max-Age=0"))
+
+		Expect(load(store, cookie).IsNew).To(BeTrue())
+	})
+
+	It("Should start new sessions, with a new ID, when they expired in Redis", func() {
+		store := newStore(redisConfig(nil))
+		saved, cookie := save(store, "", map[string]interface{}{"tgtId": "TGT-1"})
+		redis.Expire(0, "casgo:session:"+saved.ID)
+
+		loaded := load(store, cookie)
+		Expect(loaded.IsNew).To(BeTrue())
+		Expect(loaded.Values).To(BeEmpty())
+		Expect(loaded.ID).To(BeEmpty())
+	})
+
+	It("Should refuse session cookies that weren't issued with the cookie secret", func() {
+		_, cookie := save(newStore(redisConfig(map[string]string{"cookieSecret": "another secret"})), "", nil)
+		req, _ := http.NewRequest("GET", "/login", nil)
+		req.Header.Set("Cookie", cookie)
+		session, err := newStore(redisConfig(nil)).Get(req, "casgo-session")
+		Expect(err).To(HaveOccurred())
+		Expect(session.IsNew).To(BeTrue())
+	})
+
+	It("Should reuse pooled connections, authenticating and selecting the database on each", func() {
+		redis.Close()
+		redis = newMockRedisServer("hunter2")
+		store := newStore(redisConfig(map[string]string{"redisPassword": "hunter2", "redisDB": "3"}))
+		for i := 0; i < 5; i++ {
+			_, cookie := save(store, "", map[string]interface{}{"tgtId": "TGT-1"})
+			Expect(load(store, cookie).Values["tgtId"]).To(Equal("TGT-1"))
+		}
+		Expect(redis.Dials()).To(Equal(1))
+		Expect(redis.Commands()[:2]).To(Equal([]string{"AUTH", "SELECT"}))
+		Expect(redis.Keys(3)).To(HaveLen(5))
+		Expect(redis.Keys(0)).To(BeEmpty())
+
+		// Connections closed by the server are replaced
+		redis.DropConnections()
+		_, cookie := save(store, "", map[string]interface{}{"tgtId": "TGT-2"})
+		Expect(load(store, cookie).Values["tgtId"]).To(Equal("TGT-2"))
+		Expect(redis.Dials()).To(Equal(2))
+	})
+
+	It("Should fail to save sessions when Redis refuses commands", func() {
+		redis.Close()
+		redis = newMockRedisServer("hunter2")
+		store := newStore(redisConfig(map[string]string{"redisPassword": "wrong"}))
+		req, _ := http.NewRequest("GET", "/login", nil)
+		session, _ := store.Get(req, "casgo-session")
+		err := session.Save(req, httptest.NewRecorder())
+		Expect(err).To(HaveOccurred())
+		Expect(err).To(BeAssignableToTypeOf(&RedisError{}))
+	})
+
+	It("Should refuse invalid Redis options", func() {
+		config := redisConfig(map[string]string{"redisAddr": "localhost", "redisDB": "-1", "redisPoolSize": "0"})
+		Expect(ValidateConfig(config)).To(ConsistOf(
+			"redisAddr [localhost] must be a host:port address",
+			"redisDB [-1] must not be negative",
+			"redisPoolSize [0] must be at least 1",
+		))
+		config["sessionStore"] = "memcached"
+		Expect(ValidateConfig(config)).To(ContainElement(ContainSubstring("sessionStore [memcached]")))
+	})
+})
@@ -0,0 +1,119 @@
+package session_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = Describe("Session serialization", func() {
+
+	createdAt := time.Date(2015, 6, 1, 12, 30, 0, 0, time.UTC)
+	session := Session{
+		CurrentUser: &User{
+			Email:      "test@test.com",
+			Name:       "Test User",
+			Attributes: map[string]string{"department": "R&D", "role": "admin"},
+			IsAdmin:    true,
+		},
+		TGTId:     "TGT-node1-abc",
+		CreatedAt: createdAt,
+		Data: map[string]interface{}{
+			"preferences": map[string]interface{}{
+				"theme": "dark",
+				"nested": map[string]interface{}{
+					"language": "en",
+				},
+			},
+		},
+	}
+
+	for _, format := range []string{"json", "gob"} {
+		format := format
+
+		It("Should round-trip sessions with the "+format+" serializer", func() {
+			serializer, err := NewSessionSerializerFromConfig(map[string]string{"sessionSerializer": format})
+			Expect(err).To(BeNil())
+
+			data, err := serializer.Serialize(session)
+			Expect(err).To(BeNil())
+
+			decoded, err := serializer.Deserialize(data)
+			Expect(err).To(BeNil())
+			Expect(decoded.CurrentUser).To(Equal(session.CurrentUser))
+			Expect(decoded.CurrentUser.Attributes).To(HaveKeyWithValue("department", "R&D"))
+			Expect(decoded.TGTId).To(Equal(session.TGTId))
+			Expect(decoded.CreatedAt.Equal(createdAt)).To(BeTrue())
+			Expect(decoded.Data).To(Equal(session.Data))
+		})
+
+		It("Should store sessions in cookies with the "+format+" serializer", func() {
+			config := configWith(map[string]string{"sessionSerializer": format})
+			store, err := NewSessionCookieStore(config)
+			Expect(err).To(BeNil())
+
+			// Save a session
+			req, _ := http.NewRequest("GET", "/login", nil)
+			w := httptest.NewRecorder()
+			saved, _ := store.Get(req, "casgo-session")
+			saved.Values["currentUser"] = *session.CurrentUser
+			saved.Values["tgtId"] = session.TGTId
+			saved.Values["createdAt"] = createdAt
+			Expect(saved.Save(req, w)).To(BeNil())
+
+			// Load it in a new request, using the cookie that was set
+			nextReq, _ := http.NewRequest("GET", "/login", nil)
+			nextReq.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+			loaded, err := store.Get(nextReq, "casgo-session")
+			Expect(err).To(BeNil())
+			Expect(loaded.Values["currentUser"]).To(Equal(*session.CurrentUser))
+			Expect(loaded.Values["tgtId"]).To(Equal(session.TGTId))
+			Expect(loaded.Values["createdAt"].(time.Time).Equal(createdAt)).To(BeTrue())
+		})
+	}
+
+	It("Should load session cookies set before sessions had a serializer", func() {
+		config := configWith(nil)
+		legacy := sessions.NewCookieStore([]byte(config["cookieSecret"]))
+
+		req, _ := http.NewRequest("GET", "/login", nil)
+		w := httptest.NewRecorder()
+		saved, _ := legacy.Get(req, "casgo-session")
+		saved.Values["currentUser"] = *session.CurrentUser
+		saved.Values["tgtId"] = session.TGTId
+		Expect(saved.Save(req, w)).To(BeNil())
+
+		for _, format := range []string{"json", "gob"} {
+			store, err := NewSessionCookieStore(configWith(map[string]string{"sessionSerializer": format}))
+			Expect(err).To(BeNil())
+
+			nextReq, _ := http.NewRequest("GET", "/login", nil)
+			nextReq.Header.Set("Cookie", w.Header().Get("Set-Cookie"))
+			loaded, err := store.Get(nextReq, "casgo-session")
+			Expect(err).To(BeNil(), format)
+			Expect(loaded.Values["currentUser"]).To(Equal(*session.CurrentUser))
+			Expect(loaded.Values["tgtId"]).To(Equal(session.TGTId))
+		}
+	})
+
+	It("Should default to JSON", func() {
+		serializer, err := NewSessionSerializerFromConfig(map[string]string{})
+		Expect(err).To(BeNil())
+		Expect(serializer).To(BeAssignableToTypeOf(&JSONSessionSerializer{}))
+
+		data, _ := serializer.Serialize(Session{TGTId: "TGT-1"})
+		Expect(string(data)).To(ContainSubstring(`"tgtId":"TGT-1"`))
+	})
+
+	It("Should reject unknown serializers", func() {
+		_, err := NewSessionSerializerFromConfig(map[string]string{"sessionSerializer": "xml"})
+		Expect(err).ToNot(BeNil())
+		_, err = NewSessionCookieStore(configWith(map[string]string{"sessionSerializer": "xml"}))
+		Expect(err).ToNot(BeNil())
+	})
+
+})
@@ -0,0 +1,12 @@
+package session_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoSession(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Session Suite")
+}
@@ -0,0 +1,99 @@
+package session_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net/http"
+	"net/http/httptest"
+)
+
+// Save a new session using a cookie store built from the given config, returning the Set-Cookie header
+func setCookieHeaderForConfig(config map[string]string) string {
+	store, err := NewSessionCookieStore(config)
+	Expect(err).To(BeNil())
+
+	req, _ := http.NewRequest("GET", "/login", nil)
+	w := httptest.NewRecorder()
+	session, _ := store.Get(req, "casgo-session")
+	session.Values["key"] = "value"
+	Expect(session.Save(req, w)).To(BeNil())
+
+	return w.Header().Get("Set-Cookie")
+}
+
+// Copy the default configuration, applying overrides
+func configWith(overrides map[string]string) map[string]string {
+	config := make(map[string]string)
+	for k, v := range CONFIG_DEFAULTS {
+		config[k] = v
+	}
+	for k, v := range overrides {
+		config[k] = v
+	}
+	return config
+}
+
+var _ = Describe("Session cookies", func() {
+
+	It("Should default to a browser-session cookie, HttpOnly and SameSite=Lax, without Secure", func() {
+		header := setCookieHeaderForConfig(configWith(nil))
+		Expect(header).To(HavePrefix("casgo-session="))
+		Expect(header).To(ContainSubstring("; HttpOnly"))
+		Expect(header).To(ContainSubstring("; SameSite=Lax"))
+		Expect(header).ToNot(ContainSubstring("Max-Age="))
+		Expect(header).ToNot(ContainSubstring("; Secure"))
+		Expect(header).ToNot(ContainSubstring("Domain="))
+	})
+
+	It("Should apply configured cookie attributes", func() {
+		header := setCookieHeaderForConfig(configWith(map[string]string{
+			"cookieSecure":   "true",
+			"cookieSameSite": "Strict",
+			"cookieDomain":   "example.com",
+			"cookieMaxAge":   "3600",
+		}))
+		Expect(header).To(ContainSubstring("; Secure"))
+		Expect(header).To(ContainSubstring("; SameSite=Strict"))
+		Expect(header).To(ContainSubstring("; Domain=example.com"))
+		Expect(header).To(ContainSubstring("; Max-Age=3600"))
+	})
+
+	It("Should allow HttpOnly to be disabled", func() {
+		header := setCookieHeaderForConfig(configWith(map[string]string{"cookieHttpOnly": "false"}))
+		Expect(header).ToNot(ContainSubstring("HttpOnly"))
+	})
+
+	It("Should reject invalid SameSite values", func() {
+		_, err := NewSessionCookieOptions(configWith(map[string]string{"cookieSameSite": "sometimes"}))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Should reject SameSite=None cookies that aren't secure", func() {
+		_, err := NewSessionCookieOptions(configWith(map[string]string{"cookieSameSite": "none", "cookieSecure": "false"}))
+		Expect(err).ToNot(BeNil())
+		_, err = NewSessionCookieStore(configWith(map[string]string{"cookieSameSite": "none"}))
+		Expect(err).ToNot(BeNil())
+
+		options, err := NewSessionCookieOptions(configWith(map[string]string{"cookieSameSite": "none", "cookieSecure": "true"}))
+		Expect(err).To(BeNil())
+		Expect(options.SameSite).To(Equal(http.SameSiteNoneMode))
+	})
+
+	It("Should reject invalid boolean and numeric values", func() {
+		_, err := NewSessionCookieOptions(configWith(map[string]string{"cookieSecure": "yes please"}))
+		Expect(err).ToNot(BeNil())
+		_, err = NewSessionCookieOptions(configWith(map[string]string{"cookieMaxAge": "a week"}))
+		Expect(err).ToNot(BeNil())
+	})
+
+	It("Should reject invalid remember me lifetimes, unless remember me is disabled", func() {
+		_, err := NewSessionCookieStore(configWith(map[string]string{"rememberMeTTL": "0"}))
+		Expect(err).ToNot(BeNil())
+		_, err = NewSessionCookieStore(configWith(map[string]string{"rememberMeTTL": "forever"}))
+		Expect(err).ToNot(BeNil())
+		_, err = NewSessionCookieStore(configWith(map[string]string{"rememberMeEnabled": "false", "rememberMeTTL": "0"}))
+		Expect(err).To(BeNil())
+	})
+
+})
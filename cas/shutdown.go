@@ -0,0 +1,105 @@
+package cas
+
+import (
+	"context"
+	"net"
+	"sync"
+)
+
+/*
+ * Graceful shutdown
+ */
+
+// Components holding resources (connections, background workers) that must be released when the server stops
+// Storage backends, authenticators and session stores implementing it are closed by Shutdown
+type closer interface {
+	Close()
+}
+
+// Background work started by request handlers (ex. single logout notifications), waited for on shutdown
+type backgroundTasks struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	stopped bool
+}
+
+// Run a function in the background, or (once shutdown has begun) immediately, so it is not lost
+func (t *backgroundTasks) Go(f func()) {
+	t.mu.Lock()
+	if t.stopped {
+		t.mu.Unlock()
+		f()
+		return
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+
+	go func() {
+		defer t.wg.Done()
+		f()
+	}()
+}
+
+// Wait for running tasks to finish, until the context is done
+func (t *backgroundTasks) Wait(ctx context.Context) error {
+	t.mu.Lock()
+	t.stopped = true
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Serve casgo endpoints (over TLS, with the configured certificate, reloaded when renewed) on a listener
+// Unlike Start, the ticket sweeper is not started (see TicketSweeper.Start)
+// Returns http.ErrServerClosed once the server has been shut down
+func (c *CAS) Serve(listener net.Listener) error {
+	if err := c.setupTLS(); err != nil {
+		listener.Close()
+		return err
+	}
+	return c.server.ServeTLS(listener, "", "")
+}
+
+// Stop the server gracefully: new connections are refused, and in-flight requests and background work are
+// allowed to finish until the context is done, after which the ticket sweeper is stopped, queued audit events are
+// written (pending webhook deliveries are abandoned) and the storage backend and authenticator are closed
+// Returns the first error encountered (ex. context.DeadlineExceeded if requests were still running)
+func (c *CAS) Shutdown(ctx context.Context) error {
+	err := c.server.Shutdown(ctx)
+
+	if c.metricsServer != nil {
+		if metricsErr := c.metricsServer.Shutdown(ctx); err == nil {
+			err = metricsErr
+		}
+	}
+
+	if tasksErr := c.background.Wait(ctx); err == nil {
+		err = tasksErr
+	}
+	c.TicketSweeper.Stop()
+	c.AuditLog.Stop()
+	c.Webhooks.Stop()
+
+	// Resources are released even if draining timed out
+	if authenticator, ok := c.Authenticator.(closer); ok {
+		authenticator.Close()
+	}
+	if db, ok := c.Db.(closer); ok {
+		db.Close()
+	}
+	if store, ok := c.sessionStore.(closer); ok {
+		store.Close()
+	}
+	return err
+}
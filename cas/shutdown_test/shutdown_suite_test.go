@@ -0,0 +1,12 @@
+package shutdown_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoShutdown(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Shutdown Suite")
+}
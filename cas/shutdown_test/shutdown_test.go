@@ -0,0 +1,142 @@
+package shutdown_test
+
+import (
+	"context"
+	"crypto/tls"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Memory backend recording whether it was closed
+type closeRecordingBackend struct {
+	*MemoryBackend
+	mu     *sync.Mutex
+	closed *bool
+}
+
+func (b closeRecordingBackend) Close() {
+	b.MemoryBackend.Close()
+	b.mu.Lock()
+	*b.closed = true
+	b.mu.Unlock()
+}
+
+func (b closeRecordingBackend) wasClosed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return *b.closed
+}
+
+var _ = Describe("Graceful shutdown", func() {
+	var (
+		server   *CAS
+		backend  closeRecordingBackend
+		address  string
+		served   chan error
+		started  chan struct{}
+		release  chan struct{}
+		client   *http.Client
+		released sync.Once
+	)
+
+	BeforeEach(func() {
+		backend = closeRecordingBackend{MemoryBackend: NewMemoryBackend(time.Minute), mu: &sync.Mutex{}, closed: new(bool)}
+		RegisterBackend("shutdown-test", func(c *CAS) (Backend, error) {
+			return backend, nil
+		})
+
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "shutdown-test"
+		config["templatesDirectory"] = "../templates"
+		config["tlsCertFile"] = "../../fixtures/ssl/cert.pem"
+		config["tlsKeyFile"] = "../../fixtures/ssl/eckey.pem"
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+
+		// Handler that runs until the test releases it
+		started, release, released = make(chan struct{}), make(chan struct{}), sync.Once{}
+		server.ServeMux.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+			close(started)
+			<-release
+			w.Write([]byte("done"))
+		})
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		address = listener.Addr().String()
+		served = make(chan error, 1)
+		go func(server *CAS, served chan error) { served <- server.Serve(listener) }(server, served)
+
+		client = &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+		}
+	})
+
+	AfterEach(func() {
+		released.Do(func() { close(release) })
+		server.Shutdown(context.Background())
+	})
+
+	// Send a request to the slow endpoint, returning its status code (or an error) once it completes
+	slowRequest := func() (chan int, chan error) {
+		codes, errs := make(chan int, 1), make(chan error, 1)
+		go func() {
+			res, err := client.Get("https://" + address + "/slow")
+			if err != nil {
+				errs <- err
+				return
+			}
+			res.Body.Close()
+			codes <- res.StatusCode
+		}()
+		return codes, errs
+	}
+
+	It("Should let in-flight requests finish while refusing new connections", func() {
+		codes, errs := slowRequest()
+		Eventually(started, 5*time.Second).Should(BeClosed())
+
+		shutdownErr := make(chan error, 1)
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			shutdownErr <- server.Shutdown(ctx)
+		}()
+
+		// The server stops listening right away, but waits for the slow request
+		Eventually(served, 5*time.Second).Should(Receive(Equal(http.ErrServerClosed)))
+		_, err := net.DialTimeout("tcp", address, time.Second)
+		Expect(err).NotTo(BeNil())
+		Consistently(shutdownErr, 200*time.Millisecond).ShouldNot(Receive())
+		Expect(backend.wasClosed()).To(BeFalse())
+
+		released.Do(func() { close(release) })
+		Eventually(codes, 5*time.Second).Should(Receive(Equal(http.StatusOK)))
+		Expect(errs).NotTo(Receive())
+		Eventually(shutdownErr, 5*time.Second).Should(Receive(BeNil()))
+		Expect(backend.wasClosed()).To(BeTrue())
+	})
+
+	It("Should give up on in-flight requests once the deadline passes, still closing the backend", func() {
+		slowRequest()
+		Eventually(started, 5*time.Second).Should(BeClosed())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		Expect(server.Shutdown(ctx)).To(Equal(context.DeadlineExceeded))
+		Expect(backend.wasClosed()).To(BeTrue())
+	})
+
+	It("Should have a configurable shutdown timeout", func() {
+		Expect(CONFIG_DEFAULTS["shutdownTimeout"]).To(Equal("30"))
+		Expect(CONFIG_ENV_OVERRIDE_MAP["shutdownTimeout"]).To(Equal("CASGO_SHUTDOWN_TIMEOUT"))
+	})
+})
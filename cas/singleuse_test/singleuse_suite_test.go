@@ -0,0 +1,12 @@
+package singleuse_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoSingleUse(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Single Use Suite")
+}
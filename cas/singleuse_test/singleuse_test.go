@@ -0,0 +1,164 @@
+package singleuse_test
+
+import (
+	"encoding/xml"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+// Service response, as read by services
+type serviceResponse struct {
+	Success *struct {
+		User string `xml:"user"`
+	} `xml:"authenticationSuccess"`
+	Failure *struct {
+		Code string `xml:"code,attr"`
+	} `xml:"authenticationFailure"`
+}
+
+var _ = Describe("Single-use service tickets", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	// Log in (with single sign on after the first login), returning the issued service ticket
+	ticket := func() string {
+		var w *httptest.ResponseRecorder
+		if len(client.Cookies) == 0 {
+			w = client.Login(url.Values{"serviceUrl": {testServiceUrl}})
+		} else {
+			w = client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode())
+		}
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	// Validate a ticket like a service would (without cookies)
+	validate := func(path, ticket string) serviceResponse {
+		w := castest.NewClient(server).Get(path + "?" + url.Values{"service": {testServiceUrl}, "ticket": {ticket}}.Encode())
+
+		var response serviceResponse
+		Expect(xml.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		return response
+	}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+		client = castest.NewClient(server)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should refuse a ticket that was already validated with INVALID_TICKET", func() {
+		st := ticket()
+		Expect(validate("/serviceValidate", st).Success).NotTo(BeNil())
+
+		for _, path := range []string{"/serviceValidate", "/p3/serviceValidate", "/proxyValidate"} {
+			response := validate(path, st)
+			Expect(response.Success).To(BeNil(), path)
+			Expect(response.Failure).NotTo(BeNil(), path)
+			Expect(response.Failure.Code).To(Equal(CAS_INVALID_TICKET), path)
+		}
+	})
+
+	It("Should only let one of two simultaneous validations of a ticket succeed", func() {
+		for round := 0; round < 25; round++ {
+			st := ticket()
+
+			// Both validations are released at once
+			start := make(chan struct{})
+			responses := make([]serviceResponse, 2)
+			var wg sync.WaitGroup
+			for i := range responses {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					<-start
+					responses[i] = validate("/serviceValidate", st)
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			succeeded := 0
+			for _, response := range responses {
+				if response.Success != nil {
+					succeeded++
+					Expect(response.Success.User).To(Equal("test@test.com"))
+				} else {
+					Expect(response.Failure).NotTo(BeNil())
+					Expect(response.Failure.Code).To(Equal(CAS_INVALID_TICKET))
+				}
+			}
+			Expect(succeeded).To(Equal(1), st)
+		}
+	})
+
+	addProxyTicket := func(ptId string) {
+		Expect(db.AddProxyTicket(&CASProxyTicket{
+			Id:            ptId,
+			UserEmail:     "test@test.com",
+			TargetService: testServiceUrl,
+			Proxies:       []string{"https://proxy.example.com/cb"},
+			ExpiresAt:     time.Now().Add(time.Minute),
+		})).To(BeNil())
+	}
+
+	It("Should refuse a proxy ticket that was already validated with INVALID_TICKET", func() {
+		addProxyTicket("PT-1")
+		Expect(validate("/proxyValidate", "PT-1").Success).NotTo(BeNil())
+
+		response := validate("/proxyValidate", "PT-1")
+		Expect(response.Success).To(BeNil())
+		Expect(response.Failure).NotTo(BeNil())
+		Expect(response.Failure.Code).To(Equal(CAS_INVALID_TICKET))
+	})
+
+	It("Should only let one of two simultaneous validations of a proxy ticket succeed", func() {
+		for round := 0; round < 25; round++ {
+			pt := "PT-" + strconv.Itoa(round)
+			addProxyTicket(pt)
+
+			start := make(chan struct{})
+			responses := make([]serviceResponse, 2)
+			var wg sync.WaitGroup
+			for i := range responses {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					<-start
+					responses[i] = validate("/proxyValidate", pt)
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			succeeded := 0
+			for _, response := range responses {
+				if response.Success != nil {
+					succeeded++
+				} else {
+					Expect(response.Failure).NotTo(BeNil())
+					Expect(response.Failure.Code).To(Equal(CAS_INVALID_TICKET))
+				}
+			}
+			Expect(succeeded).To(Equal(1), pt)
+		}
+	})
+})
@@ -0,0 +1,154 @@
+package cas
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ * Static assets (stylesheets, scripts, images)
+ */
+
+// Serves static assets from a file system under a path, with caching headers (Cache-Control, ETag & Last-Modified)
+// and conditional requests. Assets with a pre-compressed variant (ex. casgo.js.gz next to casgo.js) are served
+// compressed to clients that accept gzip. Assets need no login session and aren't rate limited
+type StaticHandler struct {
+	Path   string // Path assets are served under, ending with / (ex. /public/)
+	Files  http.FileSystem
+	MaxAge time.Duration // How long clients may cache assets without revalidating them
+}
+
+// Create the static asset handler specified by server configuration (staticPath, staticDirectory & staticMaxAge)
+// Assets are served from embedded files unless staticDirectory is set
+func NewStaticHandlerFromConfig(config map[string]string, embedded http.FileSystem) (*StaticHandler, error) {
+	staticPath, err := ParseStaticPath(configValueOrDefault(config, "staticPath"))
+	if err != nil {
+		return nil, err
+	}
+
+	files := embedded
+	if directory := strings.TrimSpace(config["staticDirectory"]); len(directory) > 0 {
+		if info, err := os.Stat(directory); err != nil || !info.IsDir() {
+			return nil, fmt.Errorf("Invalid staticDirectory [%s], expected a directory", directory)
+		}
+		files = http.Dir(directory)
+	}
+
+	return &StaticHandler{Path: staticPath, Files: files, MaxAge: configSecondsAsDuration(config, "staticMaxAge")}, nil
+}
+
+// Parse the path static assets are served under (staticPath), normalized to end with /
+func ParseStaticPath(value string) (string, error) {
+	staticPath := strings.TrimRight(strings.TrimSpace(value), "/") + "/"
+	if staticPath == "/" || !strings.HasPrefix(staticPath, "/") || path.Clean(staticPath)+"/" != staticPath {
+		return "", fmt.Errorf("Invalid staticPath [%s], expected a path starting with / other than the root (ex. /public/)", value)
+	}
+	return staticPath, nil
+}
+
+// Whether a path (as requested) tries to go up a directory
+func containsDotDot(name string) bool {
+	for _, segment := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a client accepts gzip encoded responses
+func acceptsGzip(req *http.Request) bool {
+	for _, encoding := range strings.Split(req.Header.Get("Accept-Encoding"), ",") {
+		params := strings.Split(strings.Replace(encoding, " ", "", -1), ";")
+		if params[0] != "gzip" {
+			continue
+		}
+		// Refused with a quality of 0 (ex. gzip;q=0)
+		for _, param := range params[1:] {
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+			if quality, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil && quality == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// Open an asset that is a regular file
+func (h *StaticHandler) open(name string) (http.File, os.FileInfo, error) {
+	file, err := h.Files.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, nil, os.ErrNotExist
+	}
+	return file, info, nil
+}
+
+func (h *StaticHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !strings.HasPrefix(req.URL.Path, h.Path) || containsDotDot(req.URL.Path) {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	name := "/" + strings.TrimPrefix(req.URL.Path, h.Path)
+
+	file, info, err := h.open(name)
+	if err != nil {
+		http.NotFound(w, req)
+		return
+	}
+	defer file.Close()
+
+	// Responses differ with Accept-Encoding when there is a pre-compressed variant
+	w.Header().Set("Vary", "Accept-Encoding")
+	content, contentInfo := io.ReadSeeker(file), info
+	if acceptsGzip(req) {
+		if gzFile, gzInfo, err := h.open(name + ".gz"); err == nil {
+			defer gzFile.Close()
+
+			// The content type is the asset's, sniffed from the uncompressed file if its extension doesn't give it
+			contentType := mime.TypeByExtension(path.Ext(name))
+			if len(contentType) == 0 {
+				sniffed := make([]byte, 512)
+				n, _ := io.ReadFull(file, sniffed)
+				contentType = http.DetectContentType(sniffed[:n])
+			}
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Encoding", "gzip")
+			content, contentInfo = gzFile, gzInfo
+		}
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.MaxAge.Seconds())))
+	w.Header().Set("ETag", assetETag(contentInfo))
+	http.ServeContent(w, req, name, contentInfo.ModTime(), content)
+}
+
+// Path of a static asset as seen by browsers (ex. /cas/public/js/casgo.js, under the base path)
+// Templates link to assets with the asset function (ex. {{asset "js/casgo.js"}})
+func (c *CAS) AssetURLPath(name string) string {
+	return c.URLPath(c.Static.Path + strings.TrimPrefix(name, "/"))
+}
+
+// Entity tag of an asset, which changes with its size or modification time
+func assetETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
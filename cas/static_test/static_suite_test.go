@@ -0,0 +1,12 @@
+package static_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoStatic(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Static Assets Suite")
+}
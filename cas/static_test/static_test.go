@@ -0,0 +1,195 @@
+package static_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const script = "window.App = {};\n"
+
+// PNG signature (and the start of an image header), for content sniffing
+var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func gzipped(contents string) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(contents))
+	writer.Close()
+	return buf.Bytes()
+}
+
+var _ = Describe("Static assets", func() {
+	var (
+		server    *CAS
+		directory string
+		modTime   = time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	)
+
+	// Assets directory, inside a directory holding a file that must not be served
+	BeforeEach(func() {
+		root, err := ioutil.TempDir("", "casgo-static")
+		Expect(err).To(BeNil())
+		Expect(ioutil.WriteFile(filepath.Join(root, "secret.json"), []byte(`{"cookieSecret": "hunter2"}`), 0600)).To(Succeed())
+
+		directory = filepath.Join(root, "public")
+		files := map[string][]byte{
+			"style.css":    []byte("body { color: red; }"),
+			"app.js":       []byte(script),
+			"app.js.gz":    gzipped(script),
+			"images/logo":  png,
+			"images/a.txt": []byte("plain"),
+		}
+		for name, contents := range files {
+			path := filepath.Join(directory, name)
+			Expect(os.MkdirAll(filepath.Dir(path), 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(path, contents, 0600)).To(Succeed())
+			Expect(os.Chtimes(path, modTime, modTime)).To(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			castest.Close(server)
+			server = nil
+		}
+		os.RemoveAll(filepath.Dir(directory))
+	})
+
+	newServer := func(overrides map[string]string) {
+		config := map[string]string{"staticDirectory": directory}
+		for key, value := range overrides {
+			config[key] = value
+		}
+		server, _ = castest.NewTestServer(config)
+	}
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		client := castest.NewClient(server)
+		for name, value := range headers {
+			client.Header.Set(name, value)
+		}
+		return client.Get(path)
+	}
+
+	It("Should serve assets with caching headers", func() {
+		newServer(map[string]string{"staticMaxAge": "600"})
+		w := get("/public/style.css", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(Equal("body { color: red; }"))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/css"))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("public, max-age=600"))
+		Expect(w.Header().Get("ETag")).To(MatchRegexp(`^"[0-9a-f]+-[0-9a-f]+"$`))
+		Expect(w.Header().Get("Last-Modified")).To(Equal(modTime.Format(http.TimeFormat)))
+	})
+
+	It("Should answer conditional requests for unchanged assets with 304", func() {
+		newServer(nil)
+		etag := get("/public/style.css", nil).Header().Get("ETag")
+
+		w := get("/public/style.css", map[string]string{"If-None-Match": etag})
+		Expect(w.Code).To(Equal(http.StatusNotModified))
+		Expect(w.Body.Len()).To(Equal(0))
+
+		w = get("/public/style.css", map[string]string{"If-Modified-Since": modTime.Add(time.Hour).Format(http.TimeFormat)})
+		Expect(w.Code).To(Equal(http.StatusNotModified))
+
+		changed := modTime.Add(time.Minute)
+		Expect(os.Chtimes(filepath.Join(directory, "style.css"), changed, changed)).To(Succeed())
+		w = get("/public/style.css", map[string]string{"If-None-Match": etag})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("ETag")).ToNot(Equal(etag))
+	})
+
+	It("Should give assets their content type, sniffing it when the extension doesn't", func() {
+		newServer(nil)
+		Expect(get("/public/app.js", nil).Header().Get("Content-Type")).To(HavePrefix("text/javascript"))
+		Expect(get("/public/images/a.txt", nil).Header().Get("Content-Type")).To(HavePrefix("text/plain"))
+		Expect(get("/public/images/logo", nil).Header().Get("Content-Type")).To(Equal("image/png"))
+	})
+
+	It("Should serve pre-compressed variants to clients accepting gzip", func() {
+		newServer(nil)
+		w := get("/public/app.js", map[string]string{"Accept-Encoding": "br, gzip"})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Content-Encoding")).To(Equal("gzip"))
+		Expect(w.Header().Get("Content-Type")).To(HavePrefix("text/javascript"))
+		Expect(w.Header().Get("Vary")).To(Equal("Accept-Encoding"))
+		reader, err := gzip.NewReader(w.Body)
+		Expect(err).To(BeNil())
+		contents, err := ioutil.ReadAll(reader)
+		Expect(err).To(BeNil())
+		Expect(string(contents)).To(Equal(script))
+
+		compressedETag := w.Header().Get("ETag")
+		for _, acceptEncoding := range []string{"", "gzip;q=0", "br"} {
+			w = get("/public/app.js", map[string]string{"Accept-Encoding": acceptEncoding})
+			Expect(w.Header().Get("Content-Encoding")).To(BeEmpty(), acceptEncoding)
+			Expect(w.Body.String()).To(Equal(script))
+			Expect(w.Header().Get("ETag")).ToNot(Equal(compressedETag))
+		}
+
+		// Assets without a variant are served as is
+		Expect(get("/public/style.css", map[string]string{"Accept-Encoding": "gzip"}).Header().Get("Content-Encoding")).To(BeEmpty())
+	})
+
+	It("Should reject path traversal attempts", func() {
+		newServer(nil)
+		for _, path := range []string{"/public/../secret.json", "/public/..%2fsecret.json", "/public/images/..%2f..%2fsecret.json", "/public/..%5csecret.json"} {
+			w := get(path, nil)
+			Expect(w.Code).ToNot(Equal(http.StatusOK), path)
+			Expect(w.Body.String()).ToNot(ContainSubstring("hunter2"), path)
+		}
+
+		// Requests the router doesn't clean up first are refused by the handler itself
+		req := httptest.NewRequest("GET", "/public/x", nil)
+		req.URL.Path = "/public/images/../../secret.json"
+		w := httptest.NewRecorder()
+		server.Static.ServeHTTP(w, req)
+		Expect(w.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Should not list directories or serve missing assets", func() {
+		newServer(nil)
+		Expect(get("/public/images/", nil).Code).To(Equal(http.StatusNotFound))
+		Expect(get("/public/missing.css", nil).Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should serve assets under a configured path, and link to it from pages", func() {
+		newServer(map[string]string{"staticPath": "/assets", "basePath": "/cas"})
+		Expect(server.Static.Path).To(Equal("/assets/"))
+		Expect(server.AssetURLPath("app.js")).To(Equal("/cas/assets/app.js"))
+		Expect(get("/cas/assets/app.js", nil).Code).To(Equal(http.StatusOK))
+		Expect(get("/cas/public/app.js", nil).Code).ToNot(Equal(http.StatusOK))
+		Expect(get("/cas/login", nil).Body.String()).To(ContainSubstring(`href="/cas/assets/style/css/casgo.css"`))
+	})
+
+	It("Should serve the embedded assets by default", func() {
+		newServer(map[string]string{"staticDirectory": ""})
+		w := get("/public/js/casgo.js", nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Cache-Control")).To(Equal("public, max-age=3600"))
+		Expect(w.Header().Get("ETag")).ToNot(BeEmpty())
+	})
+
+	It("Should refuse invalid static asset options", func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["staticPath"] = "/"
+		config["staticDirectory"] = filepath.Join(directory, "missing")
+		Expect(ValidateConfig(config)).To(ConsistOf(
+			"staticPath [/] must be a path starting with / other than the root (ex. /public/)",
+			"staticDirectory ["+filepath.Join(directory, "missing")+"] must be a directory",
+		))
+	})
+})
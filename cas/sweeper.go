@@ -0,0 +1,145 @@
+package cas
+
+import (
+	"sync"
+	"time"
+)
+
+/*
+ * Removal of expired tickets
+ */
+
+// Numbers of expired tickets removed by a sweep, by type
+type SweepResult struct {
+	TicketGrantingTickets int
+	ServiceTickets        int
+	ProxyGrantingTickets  int
+	ProxyTickets          int
+	OIDCCodes             int // OpenID Connect authorization codes (of backends keeping them)
+}
+
+// Total number of tickets removed
+func (r SweepResult) Total() int {
+	return r.TicketGrantingTickets + r.ServiceTickets + r.ProxyGrantingTickets + r.ProxyTickets + r.OIDCCodes
+}
+
+// Periodically removes expired tickets from a storage backend (expired tickets are otherwise only removed when they are used)
+// Sweeps never run concurrently, and a stopped sweeper cannot be restarted
+type TicketSweeper struct {
+	db       Backend
+	interval time.Duration
+	metrics  *CASMetrics
+	logger   Logger
+	clock    Clock
+	health   *WorkerHealth
+
+	sweepMu  sync.Mutex // Held while sweeping
+	mu       sync.Mutex
+	started  bool
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+// Create a sweeper for a backend, sweeping at the given interval once started (0 disables periodic sweeping)
+// Backends that do not implement SweepableBackend are never swept
+func NewTicketSweeper(db Backend, interval time.Duration, metrics *CASMetrics, logger Logger) *TicketSweeper {
+	if metrics == nil {
+		metrics = NewCASMetrics()
+	}
+	return &TicketSweeper{
+		db:       db,
+		interval: interval,
+		metrics:  metrics,
+		logger:   loggerOrDefault(logger),
+		clock:    RealClock,
+		health:   newWorkerHealth(WORKER_TICKET_SWEEPER, interval, true, nil, 0),
+		stop:     make(chan struct{}),
+	}
+}
+
+// State the sweeper publishes as it runs
+func (s *TicketSweeper) Health() *WorkerHealth {
+	return s.health
+}
+
+// Replace the clock used to determine which tickets have expired
+func (s *TicketSweeper) SetClock(clock Clock) {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+	s.clock = clock
+}
+
+// Start sweeping in the background (does nothing if periodic sweeping is disabled, or the sweeper was already started)
+func (s *TicketSweeper) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.started || s.interval <= 0 {
+		return
+	}
+	s.started = true
+	s.health.Started()
+
+	s.stopped.Add(1)
+	go s.sweepLoop()
+}
+
+// Stop sweeping, waiting for a sweep in progress to finish
+func (s *TicketSweeper) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.stopped.Wait()
+}
+
+func (s *TicketSweeper) sweepLoop() {
+	defer s.stopped.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.Sweep()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Remove tickets that have expired, recording how many were removed
+func (s *TicketSweeper) Sweep() (SweepResult, *CASServerError) {
+	s.sweepMu.Lock()
+	defer s.sweepMu.Unlock()
+
+	db, ok := s.db.(SweepableBackend)
+	if !ok {
+		s.health.Ran(nil)
+		return SweepResult{}, nil
+	}
+
+	result, casErr := db.RemoveExpiredTickets(s.clock.Now())
+	if casErr != nil {
+		s.health.Ran(casErr)
+		s.logger.Error("Failed to remove expired tickets", "error", casErr)
+		return result, casErr
+	}
+	s.health.Ran(nil)
+
+	removed := map[string]int{
+		"ticket_granting": result.TicketGrantingTickets,
+		"service":         result.ServiceTickets,
+		"proxy_granting":  result.ProxyGrantingTickets,
+		"proxy":           result.ProxyTickets,
+		"oidc_code":       result.OIDCCodes,
+	}
+	for ticketType, count := range removed {
+		s.metrics.TicketsSwept.Add(float64(count), ticketType)
+		s.metrics.LastSweepRemoved.Set(float64(count), ticketType)
+	}
+
+	if total := result.Total(); total > 0 {
+		s.logger.Info("Removed expired tickets", "removed", total,
+			"ticketGranting", result.TicketGrantingTickets, "service", result.ServiceTickets,
+			"proxyGranting", result.ProxyGrantingTickets, "proxy", result.ProxyTickets, "oidcCode", result.OIDCCodes)
+	}
+	return result, nil
+}
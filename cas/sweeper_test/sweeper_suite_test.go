@@ -0,0 +1,12 @@
+package sweeper_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoSweeper(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Sweeper Suite")
+}
@@ -0,0 +1,124 @@
+package sweeper_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"sync"
+	"time"
+)
+
+var _ = Describe("TicketSweeper", func() {
+	var (
+		server  *CAS
+		db      *MemoryBackend
+		service *CASService
+		now     time.Time
+	)
+
+	BeforeEach(func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		db = server.Db.(*MemoryBackend)
+		service = &CASService{Name: "test_service", Url: "localhost:3000/validateCASLogin"}
+
+		now = time.Date(2015, time.March, 1, 12, 0, 0, 0, time.UTC)
+		server.Clock = ClockFunc(func() time.Time { return now })
+	})
+
+	AfterEach(func() {
+		server.TicketSweeper.Stop()
+		db.Close()
+	})
+
+	It("Should be configured by ticketSweepInterval", func() {
+		Expect(CONFIG_DEFAULTS["ticketSweepInterval"]).To(Equal("60"))
+		Expect(CONFIG_ENV_OVERRIDE_MAP["ticketSweepInterval"]).To(Equal("CASGO_TICKET_SWEEP_INTERVAL"))
+	})
+
+	It("Should only remove tickets that have expired as the clock advances", func() {
+		db.AddTicketGrantingTicket(&CASTicketGrantingTicket{Id: "TGT-old", ExpiresAt: now.Add(time.Hour)})
+		db.AddTicketGrantingTicket(&CASTicketGrantingTicket{Id: "TGT-new", ExpiresAt: now.Add(3 * time.Hour)})
+		db.AddTicketForService(&CASTicket{Id: "ST-old", TGTId: "TGT-old"}, service)
+		db.AddTicketForService(&CASTicket{Id: "ST-new", TGTId: "TGT-new"}, service)
+		db.AddProxyGrantingTicket(&CASProxyGrantingTicket{Id: "PGT-1", ExpiresAt: now.Add(time.Hour)})
+		db.AddProxyTicket(&CASProxyTicket{Id: "PT-1", ExpiresAt: now.Add(2 * time.Hour)})
+
+		result, casErr := server.TicketSweeper.Sweep()
+		Expect(casErr).To(BeNil())
+		Expect(result.Total()).To(Equal(0))
+
+		now = now.Add(2 * time.Hour)
+		result, casErr = server.TicketSweeper.Sweep()
+		Expect(casErr).To(BeNil())
+		Expect(result).To(Equal(SweepResult{TicketGrantingTickets: 1, ServiceTickets: 1, ProxyGrantingTickets: 1, ProxyTickets: 1}))
+
+		_, casErr = db.FindTicketGrantingTicketById("TGT-old")
+		Expect(casErr).NotTo(BeNil())
+		_, casErr = db.FindTicketByIdForService("ST-old", service)
+		Expect(casErr).NotTo(BeNil())
+		_, casErr = db.FindProxyGrantingTicketById("PGT-1")
+		Expect(casErr).NotTo(BeNil())
+		_, casErr = db.FindProxyTicketById("PT-1")
+		Expect(casErr).NotTo(BeNil())
+
+		// Tickets that have not expired (including those issued just now) are kept
+		_, casErr = db.FindTicketGrantingTicketById("TGT-new")
+		Expect(casErr).To(BeNil())
+		_, casErr = db.FindTicketByIdForService("ST-new", service)
+		Expect(casErr).To(BeNil())
+	})
+
+	It("Should record how many tickets each sweep removed", func() {
+		db.AddTicketGrantingTicket(&CASTicketGrantingTicket{Id: "TGT-1", ExpiresAt: now})
+		db.AddTicketForService(&CASTicket{Id: "ST-1", TGTId: "TGT-1"}, service)
+		db.AddTicketForService(&CASTicket{Id: "ST-2", TGTId: "TGT-1"}, service)
+
+		server.TicketSweeper.Sweep()
+		Expect(server.Metrics.TicketsSwept.Value("ticket_granting")).To(Equal(1.0))
+		Expect(server.Metrics.TicketsSwept.Value("service")).To(Equal(2.0))
+		Expect(server.Metrics.LastSweepRemoved.Value("service")).To(Equal(2.0))
+
+		server.TicketSweeper.Sweep()
+		Expect(server.Metrics.TicketsSwept.Value("service")).To(Equal(2.0))
+		Expect(server.Metrics.LastSweepRemoved.Value("service")).To(Equal(0.0))
+	})
+
+	It("Should sweep in the background until stopped", func() {
+		sweeper := NewTicketSweeper(db, 10*time.Millisecond, nil, NoopLogger{})
+		sweeper.SetClock(ClockFunc(func() time.Time { return now }))
+
+		db.AddProxyTicket(&CASProxyTicket{Id: "PT-1", ExpiresAt: now.Add(-time.Second)})
+		sweeper.Start()
+		Eventually(func() *CASServerError {
+			_, casErr := db.FindProxyTicketById("PT-1")
+			return casErr
+		}).ShouldNot(BeNil())
+
+		sweeper.Stop()
+		sweeper.Stop()
+
+		db.AddProxyTicket(&CASProxyTicket{Id: "PT-2", ExpiresAt: now.Add(-time.Second)})
+		Consistently(func() *CASServerError {
+			_, casErr := db.FindProxyTicketById("PT-2")
+			return casErr
+		}, 50*time.Millisecond).Should(BeNil())
+	})
+
+	It("Should be safe to sweep from several goroutines", func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				server.TicketSweeper.Sweep()
+			}()
+		}
+		wg.Wait()
+	})
+})
@@ -0,0 +1,196 @@
+package cas
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+ * Ticket-granting tickets (login sessions), including long-lived "remember me" sessions
+ */
+
+// How often the last time a login session was used is recorded
+const TGT_LAST_SEEN_RESOLUTION = time.Minute
+
+// Whether users may choose to be remembered (receive a long-lived ticket-granting ticket) at login
+func (c *CAS) rememberMeEnabled() bool {
+	enabled, err := configBool(c.Config, "rememberMeEnabled")
+	if err != nil {
+		c.Logger.Warn("Invalid configuration value, remember me disabled", "key", "rememberMeEnabled", "error", err)
+	}
+	return enabled
+}
+
+// Whether the login form asked for the user to be remembered (ignored if the feature is disabled)
+func (c *CAS) rememberMeRequested(req *http.Request) bool {
+	value := strings.TrimSpace(strings.ToLower(req.FormValue("rememberMe")))
+	return (value == "on" || value == "true") && c.rememberMeEnabled()
+}
+
+// Lifetime of a newly issued ticket-granting ticket
+func (c *CAS) ticketGrantingTicketTTL(rememberMe bool) time.Duration {
+	if rememberMe {
+		return configSecondsAsDuration(c.Config, "rememberMeTTL")
+	}
+	return configSecondsAsDuration(c.Config, "tgtTTL")
+}
+
+// Create and store a new ticket-granting ticket for a user
+func (c *CAS) makeNewTicketGrantingTicket(db Backend, user *User, rememberMe bool) (*CASTicketGrantingTicket, *CASServerError) {
+	tgtId, err := c.TicketGenerator.GenerateTGT()
+	if err != nil {
+		casErr := &FailedToCreateTicketGrantingTicketError
+		casErr.err = &err
+		return nil, casErr
+	}
+
+	now := c.now()
+	tgt := &CASTicketGrantingTicket{
+		Id:         tgtId,
+		UserEmail:  user.Email,
+		RememberMe: rememberMe,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(c.ticketGrantingTicketTTL(rememberMe)),
+	}
+
+	if casErr := db.AddTicketGrantingTicket(tgt); casErr != nil {
+		return nil, casErr
+	}
+	return tgt, nil
+}
+
+// Find the ticket-granting ticket for a session, ensuring it has not expired and belongs to the given user
+// Expired tickets are removed as they are found
+func (c *CAS) validateTicketGrantingTicket(db Backend, tgtId string, user *User) (*CASTicketGrantingTicket, *CASServerError) {
+	if len(tgtId) == 0 {
+		return nil, &FailedToFindTicketGrantingTicketError
+	}
+
+	tgt, casErr := db.FindTicketGrantingTicketById(tgtId)
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	if tgt.IsExpired(c.now()) {
+		if casErr := db.RemoveTicketGrantingTicketById(tgtId); casErr != nil {
+			c.Logger.Error("Failed to remove expired ticket-granting ticket", "sessionId", sessionIdForTGT(tgtId), "error", casErr)
+		}
+		return nil, &ExpiredTicketGrantingTicketError
+	}
+
+	if tgt.UserEmail != user.Email {
+		return nil, &FailedToFindTicketGrantingTicketError
+	}
+
+	c.touchTicketGrantingTicket(db, tgt)
+	return tgt, nil
+}
+
+// Record that a ticket-granting ticket was used (at most once per TGT_LAST_SEEN_RESOLUTION)
+func (c *CAS) touchTicketGrantingTicket(db Backend, tgt *CASTicketGrantingTicket) {
+	now := c.now()
+	if now.Sub(tgt.LastSeenAt) < TGT_LAST_SEEN_RESOLUTION {
+		return
+	}
+
+	tgt.LastSeenAt = now
+	if casErr := db.UpdateTicketGrantingTicket(tgt); casErr != nil {
+		c.Logger.Error("Failed to update last use of ticket-granting ticket", "sessionId", sessionIdForTGT(tgt.Id), "error", casErr)
+	}
+}
+
+// List the active (unexpired) login sessions of a user
+func (c *CAS) activeSessionsForUser(db Backend, email string) ([]CASSession, *CASServerError) {
+	tgts, casErr := db.FindTicketGrantingTicketsForUser(email)
+	if casErr != nil {
+		return nil, casErr
+	}
+
+	now := c.now()
+	sessions := []CASSession{}
+	for _, tgt := range tgts {
+		if tgt.IsExpired(now) {
+			continue
+		}
+
+		tickets, casErr := db.FindTicketsForTGT(tgt.Id)
+		if casErr != nil {
+			return nil, casErr
+		}
+		services := []string{}
+		seen := map[string]bool{}
+		for _, ticket := range tickets {
+			if !seen[ticket.ServiceUrl] {
+				seen[ticket.ServiceUrl] = true
+				services = append(services, ticket.ServiceUrl)
+			}
+		}
+		sort.Strings(services)
+
+		sessions = append(sessions, CASSession{
+			Id:         sessionIdForTGT(tgt.Id),
+			RememberMe: tgt.RememberMe,
+			CreatedAt:  tgt.CreatedAt,
+			LastSeenAt: tgt.LastSeenAt,
+			ExpiresAt:  tgt.ExpiresAt,
+			Services:   services,
+		})
+	}
+	return sessions, nil
+}
+
+// Revoke a login session of a user, notifying services (with a logout URL) of the logout
+func (c *CAS) revokeSession(db Backend, email, sessionId string) *CASServerError {
+	tgts, casErr := db.FindTicketGrantingTicketsForUser(email)
+	if casErr != nil {
+		return casErr
+	}
+
+	for _, tgt := range tgts {
+		if sessionIdForTGT(tgt.Id) != sessionId {
+			continue
+		}
+
+		logoutNotifications := c.logoutNotificationsForTGT(db, tgt.Id)
+		if casErr := db.RemoveTicketGrantingTicketById(tgt.Id); casErr != nil {
+			return casErr
+		}
+		c.Metrics.ActiveSessions.Dec()
+
+		c.notifyLogout(logoutNotifications)
+		return nil
+	}
+
+	return &FailedToFindSessionError
+}
+
+// Identifier of the login session held by a ticket-granting ticket
+// Session IDs are safe to share (with administrators), as the ticket-granting ticket cannot be derived from them
+func sessionIdForTGT(tgtId string) string {
+	digest := sha256.Sum256([]byte(tgtId))
+	return hex.EncodeToString(digest[:16])
+}
+
+// Get the user logged in to a session, if the session's ticket-granting ticket is still valid
+func (c *CAS) sessionUser(db Backend, session *sessions.Session) (*User, bool) {
+	if session == nil {
+		return nil, false
+	}
+
+	user, ok := session.Values["currentUser"].(User)
+	if !ok {
+		return nil, false
+	}
+
+	if _, casErr := c.validateTicketGrantingTicket(db, tgtIdFromSession(session), &user); casErr != nil {
+		return nil, false
+	}
+
+	return &user, true
+}
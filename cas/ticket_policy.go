@@ -0,0 +1,28 @@
+package cas
+
+import (
+	"time"
+)
+
+/*
+ * Service ticket policies, how long service tickets are valid for and whether they can be validated more than once
+ *
+ * By default tickets can only be validated once (as CAS requires) within stTTL seconds of being issued, services
+ * that need to can configure a different lifetime, and a window after the first validation they can be reused in
+ */
+
+// Lifetime of the service tickets issued for a service
+func (c *CAS) serviceTicketTTL(service *CASService) time.Duration {
+	if service.TicketPolicy != nil && service.TicketPolicy.TTL > 0 {
+		return time.Duration(service.TicketPolicy.TTL) * time.Second
+	}
+	return configSecondsAsDuration(c.Config, "stTTL")
+}
+
+// Window after their first validation within which tickets issued for a service can be validated again (0 if single-use)
+func serviceTicketReuseWindow(service *CASService) time.Duration {
+	if service.TicketPolicy == nil {
+		return 0
+	}
+	return time.Duration(service.TicketPolicy.ReuseWindow) * time.Second
+}
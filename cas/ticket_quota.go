@@ -0,0 +1,89 @@
+package cas
+
+import (
+	"fmt"
+	"time"
+)
+
+/*
+ * Service ticket quotas of ticket-granting tickets, limiting how many service tickets (and how quickly) a stolen
+ * login session can be used to obtain
+ *
+ * Counters are stored on the ticket-granting ticket, so they hold across casgo nodes sharing a storage backend
+ */
+
+// Limits on the service tickets issued under a ticket-granting ticket (0 if unlimited)
+type ServiceTicketQuota struct {
+	MaxTickets int           // Tickets issued over the ticket-granting ticket's lifetime
+	MaxRate    int           // Tickets issued within each Window
+	Window     time.Duration // Length of the (fixed) windows MaxRate applies to
+}
+
+// Create the service ticket quota specified by server configuration
+// (tgtMaxServiceTickets, tgtTicketRateLimit & tgtTicketRateWindow)
+func NewServiceTicketQuotaFromConfig(config map[string]string) (*ServiceTicketQuota, error) {
+	quota := &ServiceTicketQuota{}
+	for key, value := range map[string]*int{"tgtMaxServiceTickets": &quota.MaxTickets, "tgtTicketRateLimit": &quota.MaxRate} {
+		parsed, err := configInt(config, key)
+		if err != nil {
+			return nil, err
+		}
+		if parsed < 0 {
+			return nil, fmt.Errorf("Invalid %s [%d], must be 0 (unlimited) or more", key, parsed)
+		}
+		*value = parsed
+	}
+
+	window, err := configInt(config, "tgtTicketRateWindow")
+	if err != nil {
+		return nil, err
+	}
+	if window < 1 && quota.MaxRate > 0 {
+		return nil, fmt.Errorf("Invalid tgtTicketRateWindow [%d], must be at least 1 second", window)
+	}
+	quota.Window = time.Duration(window) * time.Second
+	return quota, nil
+}
+
+// Whether tickets issued under ticket-granting tickets are limited
+func (q *ServiceTicketQuota) Enabled() bool {
+	return q != nil && (q.MaxTickets > 0 || q.MaxRate > 0)
+}
+
+// Count a service ticket issued under a ticket-granting ticket at the given time, unless the quota is exhausted
+// (in which case the ticket-granting ticket is left unchanged)
+func (q *ServiceTicketQuota) consume(tgt *CASTicketGrantingTicket, now time.Time) *CASServerError {
+	if q.MaxTickets > 0 && tgt.ServiceTicketCount >= q.MaxTickets {
+		return &ServiceTicketLimitReachedError
+	}
+
+	windowStart, windowCount := tgt.TicketWindowStart, tgt.TicketWindowCount
+	if windowStart.IsZero() || !now.Before(windowStart.Add(q.Window)) {
+		windowStart, windowCount = now, 0
+	}
+	if q.MaxRate > 0 && windowCount >= q.MaxRate {
+		return &ServiceTicketRateExceededError
+	}
+
+	tgt.ServiceTicketCount++
+	tgt.TicketWindowStart, tgt.TicketWindowCount = windowStart, windowCount+1
+	return nil
+}
+
+// Count a service ticket about to be issued under a ticket-granting ticket against its quota
+// Tickets issued without a ticket-granting ticket, or while quotas are disabled, are not counted
+func (c *CAS) consumeServiceTicketQuota(db Backend, tgtId string) *CASServerError {
+	if !c.ServiceTicketQuota.Enabled() || len(tgtId) == 0 {
+		return nil
+	}
+
+	tgt, casErr := db.FindTicketGrantingTicketById(tgtId)
+	if casErr != nil {
+		return casErr
+	}
+	if casErr := c.ServiceTicketQuota.consume(tgt, c.now()); casErr != nil {
+		c.Logger.Warn("Service ticket quota exhausted", "sessionId", sessionIdForTGT(tgtId), "issued", tgt.ServiceTicketCount, "error", casErr)
+		return casErr
+	}
+	return db.UpdateTicketGrantingTicket(tgt)
+}
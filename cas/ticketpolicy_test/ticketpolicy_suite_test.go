@@ -0,0 +1,12 @@
+package ticketpolicy_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoTicketPolicy(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Ticket Policy Suite")
+}
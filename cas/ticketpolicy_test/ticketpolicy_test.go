@@ -0,0 +1,143 @@
+package ticketpolicy_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	testServiceUrl     = "localhost:3000/validateCASLogin"
+	windowedServiceUrl = "localhost:4000/validateCASLogin"
+)
+
+var _ = Describe("Service ticket policies", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		now    time.Time
+	)
+
+	newServer := func(overrides map[string]string) {
+		server, db = castest.NewTestServer(overrides)
+		now = time.Now()
+		server.Clock = ClockFunc(func() time.Time { return now })
+
+		Expect(db.AddNewService(&CASService{
+			Name:         "windowed_service",
+			Url:          windowedServiceUrl,
+			AdminEmail:   "admin@test.com",
+			TicketPolicy: &CASServiceTicketPolicy{TTL: 60, ReuseWindow: 30},
+		})).To(BeNil())
+	}
+
+	// Log in with credentials, returning the service ticket issued for the given service
+	login := func(serviceUrl string) string {
+		w := castest.Login(server, url.Values{"serviceUrl": {serviceUrl}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	validate := func(serviceUrl, ticket string) map[string]string {
+		req := httptest.NewRequest("GET", "/validate?"+url.Values{"service": {serviceUrl}, "ticket": {ticket}}.Encode(), nil)
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		result := map[string]string{}
+		for _, key := range []string{"status", "code", "failureCode"} {
+			if value, ok := response[key].(string); ok {
+				result[key] = value
+			}
+		}
+		return result
+	}
+
+	refusedWith := func(casErr CASServerError) map[string]string {
+		return map[string]string{"status": "error", "code": strconv.Itoa(casErr.CasgoErrCode), "failureCode": CAS_INVALID_TICKET}
+	}
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	Describe("By default", func() {
+		BeforeEach(func() {
+			newServer(nil)
+		})
+
+		It("Should refuse tickets that expired before they were validated", func() {
+			ticket := login(testServiceUrl)
+			now = now.Add(10 * time.Second)
+			Expect(validate(testServiceUrl, ticket)).To(Equal(refusedWith(ExpiredServiceTicketError)))
+		})
+
+		It("Should validate tickets before they expire, only once", func() {
+			ticket := login(testServiceUrl)
+			now = now.Add(9 * time.Second)
+			Expect(validate(testServiceUrl, ticket)["status"]).To(Equal("success"))
+
+			now = now.Add(time.Second)
+			Expect(validate(testServiceUrl, ticket)).To(Equal(refusedWith(TicketAlreadyValidatedError)))
+		})
+	})
+
+	It("Should use the configured global lifetime", func() {
+		newServer(map[string]string{"stTTL": "120"})
+		ticket := login(testServiceUrl)
+		now = now.Add(90 * time.Second)
+		Expect(validate(testServiceUrl, ticket)["status"]).To(Equal("success"))
+	})
+
+	Describe("With a service ticket policy", func() {
+		BeforeEach(func() {
+			newServer(nil)
+		})
+
+		It("Should use the service's lifetime", func() {
+			ticket := login(windowedServiceUrl)
+			now = now.Add(45 * time.Second)
+			Expect(validate(windowedServiceUrl, ticket)["status"]).To(Equal("success"))
+
+			expired := login(windowedServiceUrl)
+			now = now.Add(60 * time.Second)
+			Expect(validate(windowedServiceUrl, expired)).To(Equal(refusedWith(ExpiredServiceTicketError)))
+		})
+
+		It("Should validate tickets again within the reuse window after their first validation", func() {
+			ticket := login(windowedServiceUrl)
+			now = now.Add(50 * time.Second)
+			Expect(validate(windowedServiceUrl, ticket)["status"]).To(Equal("success"))
+
+			// Re-validations within the window succeed even once the ticket's lifetime has passed
+			now = now.Add(20 * time.Second)
+			Expect(validate(windowedServiceUrl, ticket)["status"]).To(Equal("success"))
+			now = now.Add(9 * time.Second)
+			Expect(validate(windowedServiceUrl, ticket)["status"]).To(Equal("success"))
+
+			now = now.Add(time.Second)
+			Expect(validate(windowedServiceUrl, ticket)).To(Equal(refusedWith(TicketAlreadyValidatedError)))
+		})
+
+		It("Should refuse services with invalid policies", func() {
+			service := &CASService{Name: "invalid", Url: "localhost:5000/validate", AdminEmail: "admin@test.com"}
+			for _, policy := range []*CASServiceTicketPolicy{nil, {}, {TTL: 5, ReuseWindow: 5}} {
+				service.TicketPolicy = policy
+				Expect(service.IsValid()).To(BeTrue())
+			}
+			for _, policy := range []*CASServiceTicketPolicy{{TTL: -1}, {ReuseWindow: -1}} {
+				service.TicketPolicy = policy
+				Expect(service.IsValid()).To(BeFalse())
+				Expect(service.IsValidUpdate()).To(BeFalse())
+			}
+		})
+	})
+})
@@ -0,0 +1,12 @@
+package ticketquota_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoTicketQuota(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Ticket Quota Suite")
+}
@@ -0,0 +1,140 @@
+package ticketquota_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+var _ = Describe("Service ticket quotas", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+		now    time.Time
+	)
+
+	newServer := func(overrides map[string]string) {
+		server, db = castest.NewTestServer(overrides)
+		now = time.Now()
+		server.Clock = ClockFunc(func() time.Time { return now })
+		client = castest.NewClient(server)
+		client.Header.Set("Accept-Language", "en")
+	}
+
+	// Log in with credentials (issuing a new ticket-granting ticket, and a service ticket under it)
+	login := func() *httptest.ResponseRecorder {
+		return client.Login(url.Values{"serviceUrl": {testServiceUrl}})
+	}
+
+	// Get a service ticket through single sign on
+	sso := func() *httptest.ResponseRecorder {
+		return client.Get("/login?" + url.Values{"service": {testServiceUrl}}.Encode())
+	}
+
+	issuedTicket := func(w *httptest.ResponseRecorder) bool {
+		return w.Code == http.StatusFound && strings.Contains(w.Header().Get("Location"), "ticket=")
+	}
+
+	// Login sessions of the test user
+	tgts := func() []CASTicketGrantingTicket {
+		tgts, casErr := db.FindTicketGrantingTicketsForUser("test@test.com")
+		Expect(casErr).To(BeNil())
+		return tgts
+	}
+
+	AfterEach(func() {
+		if server != nil {
+			castest.Close(server)
+			server = nil
+		}
+	})
+
+	It("Should issue tickets up to the limit of a login session, then refuse them", func() {
+		newServer(map[string]string{"tgtMaxServiceTickets": "3"})
+
+		Expect(issuedTicket(login())).To(BeTrue())
+		Expect(issuedTicket(sso())).To(BeTrue())
+		Expect(issuedTicket(sso())).To(BeTrue())
+
+		w := sso()
+		Expect(w.Code).To(Equal(http.StatusForbidden))
+		Expect(w.Header()).NotTo(HaveKey("Location"))
+		Expect(w.Body.String()).To(ContainSubstring(ServiceTicketLimitReachedError.Msg))
+
+		// The count is stored with the ticket-granting ticket (refused tickets aren't counted)
+		sessions := tgts()
+		Expect(sessions).To(HaveLen(1))
+		Expect(sessions[0].ServiceTicketCount).To(Equal(3))
+	})
+
+	It("Should reset the count for a new ticket-granting ticket", func() {
+		newServer(map[string]string{"tgtMaxServiceTickets": "2"})
+
+		Expect(issuedTicket(login())).To(BeTrue())
+		Expect(issuedTicket(sso())).To(BeTrue())
+		Expect(sso().Code).To(Equal(http.StatusForbidden))
+
+		// Logging in again replaces the session's ticket-granting ticket
+		Expect(issuedTicket(login())).To(BeTrue())
+		Expect(issuedTicket(sso())).To(BeTrue())
+		Expect(sso().Code).To(Equal(http.StatusForbidden))
+
+		sessions := tgts()
+		Expect(sessions).To(HaveLen(1))
+		Expect(sessions[0].ServiceTicketCount).To(Equal(2))
+	})
+
+	It("Should limit the rate tickets are issued at", func() {
+		newServer(map[string]string{"tgtTicketRateLimit": "2", "tgtTicketRateWindow": "60"})
+
+		Expect(issuedTicket(login())).To(BeTrue())
+		now = now.Add(30 * time.Second)
+		Expect(issuedTicket(sso())).To(BeTrue())
+		w := sso()
+		Expect(w.Code).To(Equal(http.StatusTooManyRequests))
+		Expect(w.Body.String()).To(ContainSubstring(ServiceTicketRateExceededError.Msg))
+
+		// Tickets can be issued again once the window has passed
+		now = now.Add(31 * time.Second)
+		Expect(issuedTicket(sso())).To(BeTrue())
+		Expect(issuedTicket(sso())).To(BeTrue())
+		Expect(sso().Code).To(Equal(http.StatusTooManyRequests))
+		Expect(tgts()[0].ServiceTicketCount).To(Equal(4))
+	})
+
+	It("Should not limit tickets by default", func() {
+		newServer(nil)
+		Expect(issuedTicket(login())).To(BeTrue())
+		for i := 0; i < 20; i++ {
+			Expect(issuedTicket(sso())).To(BeTrue())
+		}
+	})
+
+	It("Should refuse invalid quotas at startup", func() {
+		for key, overrides := range map[string]map[string]string{
+			"tgtMaxServiceTickets": {"tgtMaxServiceTickets": "-1"},
+			"tgtTicketRateLimit":   {"tgtTicketRateLimit": "many"},
+			"tgtTicketRateWindow":  {"tgtTicketRateLimit": "5", "tgtTicketRateWindow": "0"},
+		} {
+			config, err := NewCASServerConfig("")
+			Expect(err).To(BeNil())
+			config["dbBackend"] = "memory"
+			for name, value := range overrides {
+				config[name] = value
+			}
+
+			_, err = NewCASServerWithLogger(config, NoopLogger{})
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(key))
+		}
+	})
+})
@@ -0,0 +1,91 @@
+package cas
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+)
+
+/*
+ * Ticket ID generation
+ */
+
+// Ticket ID prefixes used by the prefixed ticket generator
+const (
+	SERVICE_TICKET_PREFIX         = "ST"
+	TICKET_GRANTING_TICKET_PREFIX = "TGT"
+	PREFIXED_TICKET_RANDOM_BYTES  = 20
+)
+
+// Node prefixes may only contain characters that are safe in URLs and ticket IDs
+var validNodePrefix = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// Strategy for generating service ticket and ticket-granting ticket IDs
+type TicketGenerator interface {
+	GenerateServiceTicket() (string, error)
+	GenerateTGT() (string, error)
+}
+
+// Default ticket generator, produces random (v4) UUIDs like those RethinkDB generates for new documents
+type DefaultTicketGenerator struct{}
+
+func (g *DefaultTicketGenerator) GenerateServiceTicket() (string, error) { return newUUID() }
+func (g *DefaultTicketGenerator) GenerateTGT() (string, error)           { return newUUID() }
+
+// Ticket generator that tags IDs with a type and node prefix (ex. "ST-node1-<random hex>"),
+// useful for debugging and sharding when running multiple casgo nodes
+type PrefixedTicketGenerator struct {
+	NodePrefix string
+}
+
+// Create a new prefixed ticket generator for the given node prefix
+func NewPrefixedTicketGenerator(nodePrefix string) (*PrefixedTicketGenerator, error) {
+	if !validNodePrefix.MatchString(nodePrefix) {
+		return nil, fmt.Errorf("Invalid ticket node prefix [%s], prefixes may only contain letters, numbers and underscores", nodePrefix)
+	}
+	return &PrefixedTicketGenerator{NodePrefix: nodePrefix}, nil
+}
+
+func (g *PrefixedTicketGenerator) GenerateServiceTicket() (string, error) {
+	return g.generate(SERVICE_TICKET_PREFIX)
+}
+
+func (g *PrefixedTicketGenerator) GenerateTGT() (string, error) {
+	return g.generate(TICKET_GRANTING_TICKET_PREFIX)
+}
+
+func (g *PrefixedTicketGenerator) generate(ticketPrefix string) (string, error) {
+	buf := make([]byte, PREFIXED_TICKET_RANDOM_BYTES)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return ticketPrefix + "-" + g.NodePrefix + "-" + hex.EncodeToString(buf), nil
+}
+
+// Create the ticket generator specified by server configuration
+func NewTicketGeneratorFromConfig(config map[string]string) (TicketGenerator, error) {
+	switch config["ticketGenerator"] {
+	case "", "default":
+		return &DefaultTicketGenerator{}, nil
+	case "prefixed":
+		generator, err := NewPrefixedTicketGenerator(config["ticketNodePrefix"])
+		if err != nil {
+			return nil, err
+		}
+		return generator, nil
+	default:
+		return nil, fmt.Errorf("Unknown ticket generator [%s]", config["ticketGenerator"])
+	}
+}
+
+// Generate a random (v4) UUID
+func newUUID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	buf[6] = (buf[6] & 0x0f) | 0x40
+	buf[8] = (buf[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", buf[0:4], buf[4:6], buf[6:8], buf[8:10], buf[10:16]), nil
+}
@@ -0,0 +1,12 @@
+package tickets_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoTickets(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Tickets Suite")
+}
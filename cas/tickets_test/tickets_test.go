@@ -0,0 +1,136 @@
+package tickets_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Ticket generator that always generates the same IDs
+type fixedTicketGenerator struct{}
+
+func (g *fixedTicketGenerator) GenerateServiceTicket() (string, error) { return "ST-fixed", nil }
+func (g *fixedTicketGenerator) GenerateTGT() (string, error)           { return "TGT-fixed", nil }
+
+var _ = Describe("DefaultTicketGenerator", func() {
+
+	It("Should generate UUID service tickets", func() {
+		generator := &DefaultTicketGenerator{}
+		ticket, err := generator.GenerateServiceTicket()
+		Expect(err).To(BeNil())
+		Expect(ticket).To(MatchRegexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`))
+	})
+
+	It("Should generate unique tickets", func() {
+		generator := &DefaultTicketGenerator{}
+		first, _ := generator.GenerateTGT()
+		second, _ := generator.GenerateTGT()
+		Expect(first).ToNot(Equal(second))
+	})
+
+})
+
+var _ = Describe("PrefixedTicketGenerator", func() {
+
+	It("Should generate service tickets tagged with the node prefix", func() {
+		generator, err := NewPrefixedTicketGenerator("node1")
+		Expect(err).To(BeNil())
+		ticket, err := generator.GenerateServiceTicket()
+		Expect(err).To(BeNil())
+		Expect(ticket).To(MatchRegexp(`^ST-node1-[0-9a-f]{40}$`))
+	})
+
+	It("Should generate ticket-granting tickets tagged with the node prefix", func() {
+		generator, _ := NewPrefixedTicketGenerator("node1")
+		ticket, err := generator.GenerateTGT()
+		Expect(err).To(BeNil())
+		Expect(ticket).To(MatchRegexp(`^TGT-node1-[0-9a-f]{40}$`))
+	})
+
+	It("Should reject invalid node prefixes", func() {
+		_, err := NewPrefixedTicketGenerator("bad-prefix/")
+		Expect(err).ToNot(BeNil())
+		_, err = NewPrefixedTicketGenerator("")
+		Expect(err).ToNot(BeNil())
+	})
+
+})
+
+var _ = Describe("NewTicketGeneratorFromConfig", func() {
+
+	It("Should use the default generator when none is specified", func() {
+		generator, err := NewTicketGeneratorFromConfig(map[string]string{})
+		Expect(err).To(BeNil())
+		Expect(generator).To(BeAssignableToTypeOf(&DefaultTicketGenerator{}))
+	})
+
+	It("Should create a prefixed generator", func() {
+		generator, err := NewTicketGeneratorFromConfig(map[string]string{
+			"ticketGenerator":  "prefixed",
+			"ticketNodePrefix": "east",
+		})
+		Expect(err).To(BeNil())
+		ticket, _ := generator.GenerateServiceTicket()
+		Expect(ticket).To(HavePrefix("ST-east-"))
+	})
+
+	It("Should fail for a prefixed generator with an invalid node prefix", func() {
+		generator, err := NewTicketGeneratorFromConfig(map[string]string{"ticketGenerator": "prefixed"})
+		Expect(err).ToNot(BeNil())
+		Expect(generator).To(BeNil())
+	})
+
+	It("Should fail for unknown generators", func() {
+		_, err := NewTicketGeneratorFromConfig(map[string]string{"ticketGenerator": "sequential"})
+		Expect(err).ToNot(BeNil())
+	})
+
+})
+
+var _ = Describe("CASTicketGrantingTicket", func() {
+
+	It("Should expire once its lifetime has passed", func() {
+		now := time.Now()
+		tgt := &CASTicketGrantingTicket{CreatedAt: now, ExpiresAt: now.Add(8 * time.Hour)}
+		Expect(tgt.IsExpired(now)).To(BeFalse())
+		Expect(tgt.IsExpired(now.Add(8 * time.Hour))).To(BeTrue())
+	})
+
+	It("Should support long-lived (remember me) lifetimes", func() {
+		now := time.Now()
+		tgt := &CASTicketGrantingTicket{RememberMe: true, CreatedAt: now, ExpiresAt: now.Add(30 * 24 * time.Hour)}
+		Expect(tgt.IsExpired(now.Add(29 * 24 * time.Hour))).To(BeFalse())
+		Expect(tgt.IsExpired(now.Add(31 * 24 * time.Hour))).To(BeTrue())
+	})
+
+})
+
+var _ = Describe("Injected ticket generators", func() {
+
+	It("Should issue (and validate) tickets with the ticket generator the server was created with", func() {
+		server := castest.NewTestServerWithOptions(nil, CASServerOptions{TicketGenerator: &fixedTicketGenerator{}})
+		defer castest.Close(server)
+		client := castest.NewClient(server)
+
+		serviceUrl := castest.TestServiceUrl
+		w := client.Login(url.Values{"serviceUrl": {serviceUrl}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal(serviceUrl + "?ticket=ST-fixed"))
+
+		tgt, casErr := server.Db.FindTicketGrantingTicketById("TGT-fixed")
+		Expect(casErr).To(BeNil())
+		Expect(tgt.UserEmail).To(Equal(castest.TestUserEmail))
+
+		w = client.Get("/validate?" + url.Values{"service": {serviceUrl}, "ticket": {"ST-fixed"}}.Encode())
+		var response map[string]interface{}
+		Expect(json.Unmarshal(w.Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("success"))
+		Expect(response["userEmail"]).To(Equal(castest.TestUserEmail))
+	})
+
+})
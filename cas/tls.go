@@ -0,0 +1,188 @@
+package cas
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * TLS termination, with certificates reloaded when they are renewed
+ */
+
+// TLS versions accepted by tlsMinVersion
+var TLS_VERSIONS = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Serves the certificate in a pair of files, reloading it when either file changes on disk (ex. on renewal)
+// Files are checked on every handshake (a stat of each), so renewed certificates are served to the next client
+// without a restart. If the new files can't be loaded (ex. the key was not written yet), the previous certificate
+// keeps being served until the files change again
+type CertificateReloader struct {
+	CertFile string
+	KeyFile  string
+
+	logger   Logger
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certStat fileStamp
+	keyStat  fileStamp
+}
+
+// Modification time and size of a file, compared to detect changes
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	return fileStamp{modTime: info.ModTime(), size: info.Size()}, nil
+}
+
+// Create a certificate reloader, failing if the certificate can't be loaded initially
+func NewCertificateReloader(certFile, keyFile string, logger Logger) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{CertFile: certFile, KeyFile: keyFile, logger: loggerOrDefault(logger)}
+	certStat, keyStat, err := reloader.stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := reloader.load(certStat, keyStat); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+func (r *CertificateReloader) stat() (fileStamp, fileStamp, error) {
+	certStat, err := statFile(r.CertFile)
+	if err != nil {
+		return fileStamp{}, fileStamp{}, err
+	}
+	keyStat, err := statFile(r.KeyFile)
+	if err != nil {
+		return fileStamp{}, fileStamp{}, err
+	}
+	return certStat, keyStat, nil
+}
+
+// Load the certificate, recording the state of the files it was loaded from (even if loading fails, so broken
+// files aren't reloaded on every handshake)
+func (r *CertificateReloader) load(certStat, keyStat fileStamp) error {
+	r.certStat, r.keyStat = certStat, keyStat
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("Failed to load TLS certificate [%s] and key [%s], %v", r.CertFile, r.KeyFile, err)
+	}
+	r.cert = &cert
+	return nil
+}
+
+// Reload the certificate if its files changed since it was last loaded
+func (r *CertificateReloader) reloadIfChanged() {
+	certStat, keyStat, err := r.stat()
+	if err != nil {
+		r.logger.Warn("Failed to check TLS certificate files, serving the previous certificate", "certFile", r.CertFile, "keyFile", r.KeyFile, "error", err)
+		return
+	}
+	if certStat == r.certStat && keyStat == r.keyStat {
+		return
+	}
+	if err := r.load(certStat, keyStat); err != nil {
+		r.logger.Warn("Failed to reload TLS certificate, serving the previous certificate", "error", err)
+		return
+	}
+	r.logger.Info("Reloaded TLS certificate", "certFile", r.CertFile, "keyFile", r.KeyFile)
+}
+
+// Certificate to present to a client (for tls.Config.GetCertificate)
+func (r *CertificateReloader) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.reloadIfChanged()
+	return r.cert, nil
+}
+
+// Parse a minimum TLS version (1.0, 1.1, 1.2 or 1.3)
+func ParseTLSVersion(version string) (uint16, error) {
+	if parsed, ok := TLS_VERSIONS[strings.TrimSpace(version)]; ok {
+		return parsed, nil
+	}
+	return 0, fmt.Errorf("Invalid tlsMinVersion [%s], expected one of 1.0, 1.1, 1.2, 1.3", version)
+}
+
+// Parse a comma separated list of cipher suite names (ex. TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+// Only suites without known security issues (tls.CipherSuites) are accepted, and HTTP/2 (RFC 7540, 9.2.2) requires
+// one of the ECDHE AES-128-GCM suites to be included. An empty list leaves Go's defaults
+func ParseTLSCipherSuites(list string) ([]uint16, error) {
+	known := map[string]uint16{}
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	var suites []uint16
+	for _, name := range splitConfigList(list) {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("Invalid tlsCipherSuites entry [%s], not a supported (secure) cipher suite", name)
+		}
+		suites = append(suites, id)
+	}
+
+	if len(suites) == 0 {
+		return nil, nil
+	}
+	for _, suite := range suites {
+		if suite == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || suite == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			return suites, nil
+		}
+	}
+	return nil, fmt.Errorf("Invalid tlsCipherSuites, HTTP/2 requires TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+}
+
+// Create the TLS configuration specified by server configuration (tlsCertFile, tlsKeyFile, tlsMinVersion and
+// tlsCipherSuites, which only apply to TLS 1.2 and below as TLS 1.3 suites aren't configurable)
+func NewTLSConfigFromConfig(config map[string]string, logger Logger) (*tls.Config, *CertificateReloader, error) {
+	minVersion, err := ParseTLSVersion(configValueOrDefault(config, "tlsMinVersion"))
+	if err != nil {
+		return nil, nil, err
+	}
+	cipherSuites, err := ParseTLSCipherSuites(config["tlsCipherSuites"])
+	if err != nil {
+		return nil, nil, err
+	}
+	reloader, err := NewCertificateReloader(config["tlsCertFile"], config["tlsKeyFile"], logger)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+		GetCertificate: reloader.GetCertificate,
+	}
+	return tlsConfig, reloader, nil
+}
+
+// Setup the server's TLS configuration, before it starts serving
+func (c *CAS) setupTLS() error {
+	if err := ValidateTLSFiles(c.Config); err != nil {
+		return err
+	}
+	tlsConfig, reloader, err := NewTLSConfigFromConfig(c.Config, c.Logger)
+	if err != nil {
+		return err
+	}
+	c.server.TLSConfig = tlsConfig
+	c.Certificates = reloader
+	return nil
+}
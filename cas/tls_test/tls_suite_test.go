@@ -0,0 +1,12 @@
+package tls_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoTLS(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo TLS Suite")
+}
@@ -0,0 +1,204 @@
+package tls_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Write a self-signed certificate for a common name (and its key) to files, replacing them atomically as
+// certificate renewal tools do
+func writeCertificate(certFile, keyFile, commonName string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).To(BeNil())
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	Expect(err).To(BeNil())
+
+	writeFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}))
+	writeFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func writeFile(path string, contents []byte) {
+	Expect(ioutil.WriteFile(path+".tmp", contents, 0600)).To(Succeed())
+	Expect(os.Rename(path+".tmp", path)).To(Succeed())
+}
+
+var _ = Describe("TLS termination", func() {
+	var (
+		tempDir  string
+		certFile string
+		keyFile  string
+		server   *CAS
+		address  string
+		served   chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "casgo-tls")
+		Expect(err).To(BeNil())
+		certFile = filepath.Join(tempDir, "cert.pem")
+		keyFile = filepath.Join(tempDir, "key.pem")
+		writeCertificate(certFile, keyFile, "first.casgo.test")
+	})
+
+	AfterEach(func() {
+		if server != nil {
+			server.Shutdown(context.Background())
+			server = nil
+		}
+		os.RemoveAll(tempDir)
+	})
+
+	serve := func(overrides map[string]string) {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+		config["tlsCertFile"] = certFile
+		config["tlsKeyFile"] = keyFile
+		for key, value := range overrides {
+			config[key] = value
+		}
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		address = listener.Addr().String()
+		served = make(chan error, 1)
+		go func(server *CAS, served chan error) { served <- server.Serve(listener) }(server, served)
+	}
+
+	// Connect to the server, returning the state of the TLS connection
+	connect := func(clientConfig *tls.Config) (tls.ConnectionState, error) {
+		clientConfig.InsecureSkipVerify = true
+		conn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", address, clientConfig)
+		if err != nil {
+			return tls.ConnectionState{}, err
+		}
+		defer conn.Close()
+		return conn.ConnectionState(), nil
+	}
+
+	// Common name of the certificate served
+	servedCommonName := func() string {
+		state, err := connect(&tls.Config{})
+		Expect(err).To(BeNil())
+		Expect(state.PeerCertificates).ToNot(BeEmpty())
+		return state.PeerCertificates[0].Subject.CommonName
+	}
+
+	It("Should serve HTTPS with the configured certificate", func() {
+		serve(nil)
+		Expect(servedCommonName()).To(Equal("first.casgo.test"))
+		Expect(server.Certificates.CertFile).To(Equal(certFile))
+	})
+
+	It("Should serve a renewed certificate without a restart", func() {
+		serve(nil)
+		Expect(servedCommonName()).To(Equal("first.casgo.test"))
+
+		writeCertificate(certFile, keyFile, "renewed.casgo.test")
+		Expect(servedCommonName()).To(Equal("renewed.casgo.test"))
+		Consistently(served).ShouldNot(Receive())
+	})
+
+	It("Should keep serving the previous certificate if the new one can't be loaded", func() {
+		serve(nil)
+		Expect(servedCommonName()).To(Equal("first.casgo.test"))
+
+		writeFile(keyFile, []byte("not a key"))
+		Expect(servedCommonName()).To(Equal("first.casgo.test"))
+
+		// Once the pair is complete again, it is picked up
+		writeCertificate(certFile, keyFile, "fixed.casgo.test")
+		Expect(servedCommonName()).To(Equal("fixed.casgo.test"))
+	})
+
+	It("Should refuse clients below the minimum TLS version", func() {
+		serve(map[string]string{"tlsMinVersion": "1.3"})
+		_, err := connect(&tls.Config{MaxVersion: tls.VersionTLS12})
+		Expect(err).To(HaveOccurred())
+
+		state, err := connect(&tls.Config{})
+		Expect(err).To(BeNil())
+		Expect(state.Version).To(Equal(uint16(tls.VersionTLS13)))
+	})
+
+	It("Should only negotiate the configured cipher suites", func() {
+		serve(map[string]string{"tlsCipherSuites": "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+		state, err := connect(&tls.Config{MaxVersion: tls.VersionTLS12})
+		Expect(err).To(BeNil())
+		Expect(state.CipherSuite).To(Equal(tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256))
+
+		_, err = connect(&tls.Config{
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should fail to serve without a loadable certificate", func() {
+		writeFile(certFile, []byte("not a certificate"))
+		serve(nil)
+		Eventually(served).Should(Receive(MatchError(ContainSubstring("Failed to load TLS certificate"))))
+	})
+
+	Describe("Configuration", func() {
+		It("Should parse TLS versions and cipher suites", func() {
+			version, err := ParseTLSVersion("1.3")
+			Expect(err).To(BeNil())
+			Expect(version).To(Equal(uint16(tls.VersionTLS13)))
+			_, err = ParseTLSVersion("1.4")
+			Expect(err).To(HaveOccurred())
+
+			suites, err := ParseTLSCipherSuites("TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384")
+			Expect(err).To(BeNil())
+			Expect(suites).To(Equal([]uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}))
+			suites, err = ParseTLSCipherSuites("")
+			Expect(err).To(BeNil())
+			Expect(suites).To(BeEmpty())
+
+			_, err = ParseTLSCipherSuites("TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256")
+			Expect(err).To(MatchError(ContainSubstring("HTTP/2 requires")))
+		})
+
+		It("Should refuse insecure or unknown cipher suites and versions at startup", func() {
+			config, err := NewCASServerConfig("")
+			Expect(err).To(BeNil())
+			config["dbBackend"] = "memory"
+			config["tlsMinVersion"] = "1.4"
+			config["tlsCipherSuites"] = "TLS_RSA_WITH_RC4_128_SHA"
+			Expect(ValidateConfig(config)).To(ConsistOf(
+				"tlsMinVersion [1.4] must be one of 1.0, 1.1, 1.2, 1.3",
+				"Invalid tlsCipherSuites entry [TLS_RSA_WITH_RC4_128_SHA], not a supported (secure) cipher suite",
+			))
+		})
+	})
+})
@@ -0,0 +1,312 @@
+package cas
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+/*
+ * Time-based one-time password (TOTP, RFC 6238) two-factor authentication
+ */
+
+// TOTP parameters (the defaults used by authenticator apps)
+const (
+	TOTP_PERIOD       = 30 * time.Second
+	TOTP_DIGITS       = 6
+	TOTP_SECRET_BYTES = 20
+
+	totpModulus = 1000000 // 10^TOTP_DIGITS
+)
+
+// Secrets are shared with authenticator apps as unpadded base32
+var totpSecretEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Generate a new random TOTP secret (base32 encoded)
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, TOTP_SECRET_BYTES)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpSecretEncoding.EncodeToString(secret), nil
+}
+
+// Time step (number of periods since the Unix epoch) that a time falls in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / int64(TOTP_PERIOD/time.Second)
+}
+
+// Compute the code for a (base32 encoded) secret at a time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpSecretEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTP_DIGITS, value%totpModulus), nil
+}
+
+// Check a code against a secret, allowing for clock skew of up to skew time steps in either direction
+// Codes for steps at or before lastUsedStep are rejected, so that a code cannot be replayed
+// Returns the step the code matched
+func ValidateTOTPCode(secret, code string, now time.Time, skew int, lastUsedStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTP_DIGITS {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for step := current - int64(skew); step <= current+int64(skew); step++ {
+		if step <= lastUsedStep {
+			continue
+		}
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// Build the otpauth:// URI used to enroll a secret in an authenticator app (usually rendered as a QR code)
+func TOTPProvisioningURI(issuer, accountName, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", TOTP_DIGITS))
+	params.Set("period", fmt.Sprintf("%d", int(TOTP_PERIOD/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(accountName)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// Issuer shown in authenticator apps
+func (c *CAS) totpIssuer() string {
+	if issuer := c.Config["totpIssuer"]; len(issuer) > 0 {
+		return issuer
+	}
+	return c.Config["companyName"]
+}
+
+// Time steps of clock skew tolerated when checking codes (validated when the server is created)
+func (c *CAS) totpSkew() int {
+	skew, _ := configInt(c.Config, "totpSkew")
+	return skew
+}
+
+// Check a TOTP code for a user with two-factor authentication enabled
+// The matched time step is recorded, so each code can only be used once
+// Returns the (updated) user if the code is valid
+func (c *CAS) verifyTOTPCode(db Backend, email, code string) (*User, *CASServerError) {
+	// Verification is serialized so concurrent requests cannot both use the same code
+	c.totpMu.Lock()
+	defer c.totpMu.Unlock()
+
+	user, casErr := db.FindUserByEmail(email)
+	if casErr != nil {
+		return nil, &FailedToFindUserError
+	}
+	if !user.TOTPEnabled() {
+		return nil, &TOTPNotEnrolledError
+	}
+
+	step, ok := ValidateTOTPCode(user.TOTP.Secret, code, c.now(), c.totpSkew(), user.TOTP.LastUsedStep)
+	if !ok {
+		return nil, &InvalidTOTPCodeError
+	}
+
+	totp := *user.TOTP
+	totp.LastUsedStep = step
+	user.TOTP = &totp
+	if casErr := db.UpdateUser(user); casErr != nil {
+		return nil, casErr
+	}
+	return user, nil
+}
+
+// Generate a new secret for a user, which is enabled once a code generated from it has been verified
+// Returns the secret and its provisioning URI
+func (c *CAS) startTOTPEnrollment(db Backend, email string) (string, string, *CASServerError) {
+	c.totpMu.Lock()
+	defer c.totpMu.Unlock()
+
+	user, casErr := db.FindUserByEmail(email)
+	if casErr != nil {
+		return "", "", &FailedToFindUserError
+	}
+
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		casErr := &FailedToCreateTOTPSecretError
+		casErr.err = &err
+		return "", "", casErr
+	}
+
+	totp := UserTOTP{}
+	if user.TOTP != nil {
+		totp = *user.TOTP
+	}
+	totp.PendingSecret = secret
+	user.TOTP = &totp
+	if casErr := db.UpdateUser(user); casErr != nil {
+		return "", "", casErr
+	}
+
+	return secret, TOTPProvisioningURI(c.totpIssuer(), user.Email, secret), nil
+}
+
+// Enable a user's pending secret, if the given code was generated from it
+func (c *CAS) confirmTOTPEnrollment(db Backend, email, code string) *CASServerError {
+	c.totpMu.Lock()
+	defer c.totpMu.Unlock()
+
+	user, casErr := db.FindUserByEmail(email)
+	if casErr != nil {
+		return &FailedToFindUserError
+	}
+	if user.TOTP == nil || len(user.TOTP.PendingSecret) == 0 {
+		return &TOTPNotEnrolledError
+	}
+
+	step, ok := ValidateTOTPCode(user.TOTP.PendingSecret, code, c.now(), c.totpSkew(), 0)
+	if !ok {
+		return &InvalidTOTPCodeError
+	}
+
+	user.TOTP = &UserTOTP{
+		Secret:       user.TOTP.PendingSecret,
+		LastUsedStep: step,
+	}
+	return db.UpdateUser(user)
+}
+
+// Remove a user's two-factor authentication secrets (ex. if their device has been lost)
+func (c *CAS) disableTOTP(db Backend, email string) *CASServerError {
+	c.totpMu.Lock()
+	defer c.totpMu.Unlock()
+
+	user, casErr := db.FindUserByEmail(email)
+	if casErr != nil {
+		return &FailedToFindUserError
+	}
+	user.TOTP = nil
+	return db.UpdateUser(user)
+}
+
+/*
+ * Two-factor login
+ */
+
+// Keys of session values holding a login that is waiting for a TOTP code
+const (
+	TOTP_PENDING_EMAIL_KEY       = "totpPendingEmail"
+	TOTP_PENDING_REMEMBER_ME_KEY = "totpPendingRememberMe"
+	TOTP_PENDING_EXPIRES_AT_KEY  = "totpPendingExpiresAt"
+)
+
+// Remember a user whose password has been verified, and ask for their TOTP code
+// No ticket-granting ticket is issued until the code has been verified
+func (c *CAS) beginTOTPLogin(w http.ResponseWriter, req *http.Request, logger Logger, context map[string]interface{}, user *User, rememberMe bool) {
+	session, _ := c.sessionStore.Get(req, "casgo-session")
+	expiresAt := c.now().Add(configSecondsAsDuration(c.Config, "totpLoginTTL"))
+
+	// Expiry is stored as a string, as it must survive both session serializers
+	session.Values[TOTP_PENDING_EMAIL_KEY] = user.Email
+	session.Values[TOTP_PENDING_REMEMBER_ME_KEY] = rememberMe
+	session.Values[TOTP_PENDING_EXPIRES_AT_KEY] = expiresAt.UTC().Format(time.RFC3339)
+	if err := session.Save(req, w); err != nil {
+		logger.Error("Failed to save pending two-factor login", "error", err)
+		context["Error"] = c.localizeError(context, &FailedToSaveSessionError)
+		c.render.HTML(w, FailedToSaveSessionError.HttpCode, "login", context)
+		return
+	}
+
+	logger.Info("Password verified, waiting for two-factor code")
+	context["TOTPRequired"] = true
+	c.render.HTML(w, http.StatusOK, "login", context)
+}
+
+// Check the TOTP code for a pending login, completing the login if it is valid
+// Codes are rate limited per client IP and per user
+func (c *CAS) finishTOTPLogin(w http.ResponseWriter, req *http.Request, context map[string]interface{}, casService *CASService, code, clientIP string) {
+	session, _ := c.sessionStore.Get(req, "casgo-session")
+	email, rememberMe, ok := pendingTOTPLogin(session, c.now())
+	logger := c.requestLogger(req).With("username", email, "twoFactor", true)
+	serviceUrl := ""
+	if casService != nil {
+		serviceUrl = casService.Url
+		logger = logger.With("service", serviceUrl)
+	}
+	if !ok {
+		logger.Warn("Two-factor code submitted without a pending login")
+		clearPendingTOTPLogin(session)
+		session.Save(req, w)
+		context["Error"] = c.localizeError(context, &TOTPLoginExpiredError)
+		c.render.HTML(w, TOTPLoginExpiredError.HttpCode, "login", context)
+		return
+	}
+
+	context["TOTPRequired"] = true
+	userLimitKey := "totp:" + email
+	if !c.LoginRateLimiter.Allow(userLimitKey) {
+		c.Metrics.LoginAttempts.Inc("rate_limited")
+		logger.Warn("Login refused, too many failed two-factor attempts")
+		c.audit(req, NewAuditEvent(AUDIT_LOGIN, email, serviceUrl, &TooManyLoginAttemptsError))
+		context["Error"] = c.localizeError(context, &TooManyLoginAttemptsError)
+		c.render.HTML(w, TooManyLoginAttemptsError.HttpCode, "login", context)
+		return
+	}
+
+	user, casErr := c.verifyTOTPCode(c.backendFor(req), email, code)
+	c.recordLoginAttempt(req, logger, email, serviceUrl, clientIP, casErr)
+	if casErr != nil {
+		c.LoginRateLimiter.RecordFailure(userLimitKey)
+		context["Error"] = c.localizeError(context, casErr)
+		c.render.HTML(w, casErr.HttpCode, "login", context)
+		return
+	}
+
+	delete(context, "TOTPRequired")
+	c.completeLogin(w, req, context, user, casService, rememberMe)
+}
+
+// Get the login waiting for a TOTP code in a session, if it has not expired
+func pendingTOTPLogin(session *sessions.Session, now time.Time) (string, bool, bool) {
+	email, _ := session.Values[TOTP_PENDING_EMAIL_KEY].(string)
+	rememberMe, _ := session.Values[TOTP_PENDING_REMEMBER_ME_KEY].(bool)
+	rawExpiresAt, _ := session.Values[TOTP_PENDING_EXPIRES_AT_KEY].(string)
+
+	expiresAt, err := time.Parse(time.RFC3339, rawExpiresAt)
+	if len(email) == 0 || err != nil || !now.Before(expiresAt) {
+		return "", false, false
+	}
+	return email, rememberMe, true
+}
+
+func clearPendingTOTPLogin(session *sessions.Session) {
+	delete(session.Values, TOTP_PENDING_EMAIL_KEY)
+	delete(session.Values, TOTP_PENDING_REMEMBER_ME_KEY)
+	delete(session.Values, TOTP_PENDING_EXPIRES_AT_KEY)
+}
@@ -0,0 +1,12 @@
+package totp_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoTOTP(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo TOTP Suite")
+}
@@ -0,0 +1,308 @@
+package totp_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+// Secret from the RFC 6238 test vectors ("12345678901234567890", base32 encoded)
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var _ = Describe("TOTP", func() {
+
+	It("Should generate the RFC 6238 test vector codes", func() {
+		// Test vectors are 8 digits long, codes are their last 6 digits
+		for unixTime, code := range map[int64]string{
+			59:         "287082",
+			1111111109: "081804",
+			1234567890: "005924",
+			2000000000: "279037",
+		} {
+			generated, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(unixTime, 0)))
+			Expect(err).To(BeNil())
+			Expect(generated).To(Equal(code))
+		}
+	})
+
+	It("Should generate distinct base32 secrets", func() {
+		first, err := GenerateTOTPSecret()
+		Expect(err).To(BeNil())
+		second, err := GenerateTOTPSecret()
+		Expect(err).To(BeNil())
+		Expect(first).To(HaveLen(32))
+		Expect(first).NotTo(Equal(second))
+
+		_, err = TOTPCode(first, 1)
+		Expect(err).To(BeNil())
+	})
+
+	It("Should accept codes within the allowed clock skew", func() {
+		now := time.Unix(1234567890, 0)
+		step := TOTPStep(now)
+		previous, _ := TOTPCode(rfcSecret, step-1)
+		tooOld, _ := TOTPCode(rfcSecret, step-2)
+
+		matched, ok := ValidateTOTPCode(rfcSecret, previous, now, 1, 0)
+		Expect(ok).To(BeTrue())
+		Expect(matched).To(Equal(step - 1))
+
+		_, ok = ValidateTOTPCode(rfcSecret, previous, now, 0, 0)
+		Expect(ok).To(BeFalse())
+		_, ok = ValidateTOTPCode(rfcSecret, tooOld, now, 1, 0)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Should reject codes for steps that have already been used", func() {
+		now := time.Unix(1234567890, 0)
+		step := TOTPStep(now)
+		code, _ := TOTPCode(rfcSecret, step)
+		previous, _ := TOTPCode(rfcSecret, step-1)
+
+		_, ok := ValidateTOTPCode(rfcSecret, code, now, 1, step)
+		Expect(ok).To(BeFalse())
+		_, ok = ValidateTOTPCode(rfcSecret, previous, now, 1, step-1)
+		Expect(ok).To(BeFalse())
+		_, ok = ValidateTOTPCode(rfcSecret, code, now, 1, step-1)
+		Expect(ok).To(BeTrue())
+	})
+
+	It("Should reject malformed codes", func() {
+		now := time.Unix(1234567890, 0)
+		_, ok := ValidateTOTPCode(rfcSecret, "", now, 1, 0)
+		Expect(ok).To(BeFalse())
+		_, ok = ValidateTOTPCode(rfcSecret, "89005924", now, 1, 0)
+		Expect(ok).To(BeFalse())
+	})
+
+	It("Should build otpauth:// provisioning URIs", func() {
+		uri, err := url.Parse(TOTPProvisioningURI("companyABC", "test@test.com", rfcSecret))
+		Expect(err).To(BeNil())
+		Expect(uri.Scheme).To(Equal("otpauth"))
+		Expect(uri.Host).To(Equal("totp"))
+		Expect(uri.Path).To(Equal("/companyABC:test@test.com"))
+		Expect(uri.Query().Get("secret")).To(Equal(rfcSecret))
+		Expect(uri.Query().Get("issuer")).To(Equal("companyABC"))
+		Expect(uri.Query().Get("digits")).To(Equal("6"))
+		Expect(uri.Query().Get("period")).To(Equal("30"))
+	})
+})
+
+var _ = Describe("Two-factor login", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	userKey := map[string]string{"X-Api-Key": "userapikey", "X-Api-Secret": "badsecret"}
+	adminKey := map[string]string{"X-Api-Key": "adminapikey", "X-Api-Secret": "badsecret"}
+
+	doJSON := func(method, path, body string, headers map[string]string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		apiClient := castest.NewClient(server)
+		for k, v := range headers {
+			apiClient.Header.Set(k, v)
+		}
+		w := apiClient.Do(httptest.NewRequest(method, path, strings.NewReader(body)))
+
+		var parsed map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &parsed)
+		return w, parsed
+	}
+
+	submitPassword := func(serviceUrl string) *httptest.ResponseRecorder {
+		return client.Login(url.Values{"serviceUrl": {serviceUrl}})
+	}
+
+	submitCode := func(code, serviceUrl string) *httptest.ResponseRecorder {
+		return client.PostForm("/login", url.Values{"totpCode": {code}, "serviceUrl": {serviceUrl}})
+	}
+
+	currentCode := func(offset int64) string {
+		code, err := TOTPCode(rfcSecret, TOTPStep(time.Now())+offset)
+		Expect(err).To(BeNil())
+		return code
+	}
+
+	// Code that is not valid for any step within the allowed clock skew
+	invalidCode := func() string {
+		for _, code := range []string{"000000", "111111", "222222", "333333"} {
+			if code != currentCode(-1) && code != currentCode(0) && code != currentCode(1) {
+				return code
+			}
+		}
+		return ""
+	}
+
+	sessionCount := func() int {
+		tgts, casErr := db.FindTicketGrantingTicketsForUser("test@test.com")
+		Expect(casErr).To(BeNil())
+		return len(tgts)
+	}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+		client = castest.NewClient(server)
+
+		user, _ := db.FindUserByEmail("test@test.com")
+		user.TOTP = &UserTOTP{Secret: rfcSecret}
+		Expect(db.UpdateUser(user)).To(BeNil())
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should ask for a code after the password, without creating a session", func() {
+		w := submitPassword(testServiceUrl)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`name="totpCode"`))
+		Expect(sessionCount()).To(Equal(0))
+
+		w = client.Get("/api/sessions")
+		Expect(w.Code).NotTo(Equal(http.StatusOK))
+	})
+
+	It("Should log in and redirect to the service once the code is verified", func() {
+		submitPassword(testServiceUrl)
+
+		w := submitCode(currentCode(0), testServiceUrl)
+		Expect(w.Code).To(Equal(http.StatusFound))
+		location, _ := url.Parse(w.Header().Get("Location"))
+		Expect(location.Query().Get("ticket")).NotTo(BeEmpty())
+		Expect(sessionCount()).To(Equal(1))
+	})
+
+	It("Should reject invalid codes, allowing another attempt", func() {
+		submitPassword("")
+
+		w := submitCode(invalidCode(), "")
+		Expect(w.Code).To(Equal(InvalidTOTPCodeError.HttpCode))
+		Expect(w.Body.String()).To(ContainSubstring(`name="totpCode"`))
+		Expect(sessionCount()).To(Equal(0))
+
+		w = submitCode(currentCode(0), "")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(sessionCount()).To(Equal(1))
+	})
+
+	It("Should not accept a code that has already been used", func() {
+		code := currentCode(0)
+		submitPassword("")
+		Expect(submitCode(code, "").Code).To(Equal(http.StatusOK))
+
+		client = castest.NewClient(server)
+		submitPassword("")
+		w := submitCode(code, "")
+		Expect(w.Code).To(Equal(InvalidTOTPCodeError.HttpCode))
+		Expect(sessionCount()).To(Equal(1))
+	})
+
+	It("Should not accept codes without a pending login", func() {
+		w := submitCode(currentCode(0), "")
+		Expect(w.Code).To(Equal(TOTPLoginExpiredError.HttpCode))
+		Expect(sessionCount()).To(Equal(0))
+	})
+
+	It("Should not issue tickets through gateway logins", func() {
+		w := client.Get("/login?" + url.Values{
+			"service":  {testServiceUrl},
+			"gateway":  {"true"},
+			"email":    {castest.TestUserEmail},
+			"password": {castest.TestPassword},
+		}.Encode())
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(Equal(testServiceUrl))
+		Expect(sessionCount()).To(Equal(0))
+	})
+
+	It("Should not store secrets in session cookies", func() {
+		submitPassword("")
+		submitCode(currentCode(0), "")
+
+		for _, cookie := range client.Cookies {
+			Expect(cookie.Value).NotTo(ContainSubstring(rfcSecret))
+		}
+	})
+
+	Describe("Enrollment", func() {
+		It("Should generate a secret and enable it once a code is verified", func() {
+			user, _ := db.FindUserByEmail("test@test.com")
+			user.TOTP = nil
+			Expect(db.UpdateUser(user)).To(BeNil())
+
+			w, body := doJSON("POST", "/api/users/test@test.com/totp", "", userKey)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			data := body["data"].(map[string]interface{})
+			secret := data["secret"].(string)
+			Expect(data["uri"]).To(HavePrefix("otpauth://totp/companyABC:test@test.com?"))
+			Expect(data["uri"]).To(ContainSubstring("secret=" + secret))
+
+			// Pending secrets are not required on login until verified
+			user, _ = db.FindUserByEmail("test@test.com")
+			Expect(user.TOTPEnabled()).To(BeFalse())
+
+			code, _ := TOTPCode(secret, TOTPStep(time.Now()))
+			w, _ = doJSON("POST", "/api/users/test@test.com/totp/verify", `{"code": "not-a-code"}`, userKey)
+			Expect(w.Code).To(Equal(InvalidTOTPCodeError.HttpCode))
+
+			w, _ = doJSON("POST", "/api/users/test@test.com/totp/verify", `{"code": "`+code+`"}`, userKey)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			user, _ = db.FindUserByEmail("test@test.com")
+			Expect(user.TOTPEnabled()).To(BeTrue())
+			Expect(user.TOTP.Secret).To(Equal(secret))
+			Expect(user.TOTP.PendingSecret).To(BeEmpty())
+		})
+
+		It("Should keep the enabled secret while a new one is pending", func() {
+			w, _ := doJSON("POST", "/api/users/test@test.com/totp", "", userKey)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			user, _ := db.FindUserByEmail("test@test.com")
+			Expect(user.TOTP.Secret).To(Equal(rfcSecret))
+			Expect(user.TOTP.PendingSecret).NotTo(BeEmpty())
+		})
+
+		It("Should not verify codes without a pending secret", func() {
+			w, _ := doJSON("POST", "/api/users/test@test.com/totp/verify", `{"code": "123456"}`, userKey)
+			Expect(w.Code).To(Equal(TOTPNotEnrolledError.HttpCode))
+		})
+
+		It("Should only allow users to enroll themselves", func() {
+			w, _ := doJSON("POST", "/api/users/test@test.com/totp", "", adminKey)
+			Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+		})
+
+		It("Should allow admins to disable two-factor authentication", func() {
+			w, _ := doJSON("DELETE", "/api/users/admin@test.com/totp", "", userKey)
+			Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+
+			w, _ = doJSON("DELETE", "/api/users/test@test.com/totp", "", adminKey)
+			Expect(w.Code).To(Equal(http.StatusNoContent))
+
+			user, _ := db.FindUserByEmail("test@test.com")
+			Expect(user.TOTPEnabled()).To(BeFalse())
+		})
+
+		It("Should not expose secrets or drop them on user updates", func() {
+			w, _ := doJSON("GET", "/api/users", "", adminKey)
+			Expect(w.Body.String()).NotTo(ContainSubstring(rfcSecret))
+
+			w, _ = doJSON("PUT", "/api/users/test@test.com", `{"email": "test@test.com", "name": "Test"}`, adminKey)
+			Expect(w.Code).To(Equal(http.StatusOK))
+
+			user, _ := db.FindUserByEmail("test@test.com")
+			Expect(user.TOTPEnabled()).To(BeTrue())
+		})
+	})
+})
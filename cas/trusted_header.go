@@ -0,0 +1,123 @@
+package cas
+
+import (
+	"fmt"
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"net/http"
+	"strings"
+)
+
+/*
+ * Trusted header authentication (users pre-authenticated by a reverse proxy)
+ */
+
+// Logs in users authenticated by a reverse proxy, which names them (by email) in a request header
+// The header is only read from requests whose peer is one of the trusted proxies, so clients reaching casgo
+// directly (or through proxies that aren't trusted) can't log in as anyone by sending it themselves
+type TrustedHeaderAuth struct {
+	Header    string // Canonical name of the header naming the user (ex. X-Remote-User)
+	Provision bool   // Create users that don't exist yet, without a password (as directory users are)
+}
+
+// Set up trusted header authentication from configuration (nil if trustedHeaderAuth is disabled)
+func NewTrustedHeaderAuthFromConfig(config map[string]string, proxies TrustedProxies) (*TrustedHeaderAuth, error) {
+	enabled, err := configBool(config, "trustedHeaderAuth")
+	if err != nil || !enabled {
+		return nil, err
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("trustedHeaderAuth requires trustedProxies, the proxies allowed to set the header")
+	}
+
+	header := strings.TrimSpace(config["trustedHeaderName"])
+	if !validHeaderName(header) {
+		return nil, fmt.Errorf("Invalid trustedHeaderName [%s], expected a header name (ex. X-Remote-User)", config["trustedHeaderName"])
+	}
+
+	provision, err := configBool(config, "trustedHeaderProvision")
+	if err != nil {
+		return nil, err
+	}
+
+	return &TrustedHeaderAuth{Header: http.CanonicalHeaderKey(header), Provision: provision}, nil
+}
+
+// Email of the user named by a request's header (empty if the header isn't set)
+func (a *TrustedHeaderAuth) Username(req *http.Request) string {
+	return strings.TrimSpace(strings.ToLower(req.Header.Get(a.Header)))
+}
+
+// Find the user named by the header, creating them if provisioning is enabled
+func (a *TrustedHeaderAuth) User(db Backend, email string) (*User, *CASServerError) {
+	user, casErr := db.FindUserByEmail(email)
+	if casErr == nil {
+		return user, nil
+	}
+	if !a.Provision {
+		return nil, &UnknownTrustedHeaderUserError
+	}
+
+	// Provisioned users have no local password, so they can only log in through the proxy
+	return db.AddNewUser(email, "")
+}
+
+// Whether a header name only contains token characters (RFC 7230)
+func validHeaderName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, char := range name {
+		if char > '~' || char <= ' ' || strings.ContainsRune("\"(),/:;<=>?@[\\]{}", char) {
+			return false
+		}
+	}
+	return true
+}
+
+// Log in the user named by the trusted header, if the request carries it, reporting whether the response was handled
+// Requests from peers that aren't trusted proxies have the header ignored (and fall back to the login form), as do
+// requests whose session already belongs to the named user, which single sign on handles (unless renew is set)
+func (c *CAS) loginWithTrustedHeader(w http.ResponseWriter, req *http.Request, context map[string]interface{}, session *sessions.Session, casService *CASService, serviceUrl, clientIP string, renew, gateway bool) bool {
+	if c.TrustedHeaderAuth == nil {
+		return false
+	}
+	email := c.TrustedHeaderAuth.Username(req)
+	if len(email) == 0 {
+		return false
+	}
+	logger := c.requestLogger(req).With("username", email, "service", serviceUrl)
+
+	if !c.TrustedProxies.IsPeer(req) {
+		logger.Warn("Ignored trusted authentication header sent by a peer that isn't a trusted proxy", "header", c.TrustedHeaderAuth.Header, "clientIp", clientIP)
+		return false
+	}
+	if sessionUser, ok := c.sessionUser(c.backendFor(req), session); ok && sessionUser.Email == email && !renew {
+		return false
+	}
+
+	user, casErr := c.TrustedHeaderAuth.User(c.backendFor(req), email)
+
+	// Two-factor authentication is still required, the proxy only stands in for the password
+	if casErr == nil && user.TOTPEnabled() {
+		if gateway && casService != nil {
+			http.Redirect(w, req, casService.Url, http.StatusFound)
+		} else {
+			c.beginTOTPLogin(w, req, logger, context, user, false)
+		}
+		return true
+	}
+
+	c.recordLoginAttempt(req, logger, email, serviceUrl, clientIP, casErr)
+	if casErr != nil {
+		if gateway && casService != nil {
+			http.Redirect(w, req, casService.Url, http.StatusFound)
+		} else {
+			context["Error"] = c.localizeError(context, casErr)
+			c.render.HTML(w, casErr.HttpCode, "login", context)
+		}
+		return true
+	}
+
+	c.completeLogin(w, req, context, user, casService, false)
+	return true
+}
@@ -0,0 +1,12 @@
+package trustedheader_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoTrustedHeader(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo TrustedHeader Suite")
+}
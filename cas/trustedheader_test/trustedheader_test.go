@@ -0,0 +1,173 @@
+package trustedheader_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+)
+
+const (
+	testServiceUrl = "localhost:3000/validateCASLogin"
+	proxyAddr      = "10.0.0.1:41000"
+	clientAddr     = "203.0.113.5:52000"
+)
+
+var _ = Describe("Trusted header authentication", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	// Send a request from a peer, with the given headers
+	do := func(remoteAddr, method, path string, form url.Values, headers map[string]string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if method == "POST" {
+			req, _ = http.NewRequest(method, path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(method, path+"?"+form.Encode(), nil)
+		}
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		return client.Do(req)
+	}
+
+	login := func(remoteAddr string, headers map[string]string) *httptest.ResponseRecorder {
+		return do(remoteAddr, "GET", "/login", url.Values{"service": {testServiceUrl}}, headers)
+	}
+
+	// User a ticket (from a login redirect) was issued for
+	ticketUser := func(w *httptest.ResponseRecorder) string {
+		Expect(w.Code).To(Equal(http.StatusFound))
+		location, err := url.Parse(w.Header().Get("Location"))
+		Expect(err).To(BeNil())
+		Expect(location.Query().Get("ticket")).NotTo(BeEmpty())
+
+		validation := do(clientAddr, "GET", "/validate", url.Values{"service": {testServiceUrl}, "ticket": {location.Query().Get("ticket")}}, nil)
+		var response map[string]interface{}
+		Expect(json.Unmarshal(validation.Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("success"))
+		return response["userEmail"].(string)
+	}
+
+	sessionCount := func(email string) int {
+		tgts, casErr := db.FindTicketGrantingTicketsForUser(email)
+		Expect(casErr).To(BeNil())
+		return len(tgts)
+	}
+
+	newServer := func(overrides map[string]string) {
+		config := map[string]string{"trustedProxies": "10.0.0.0/24", "trustedHeaderAuth": "true"}
+		for key, value := range overrides {
+			config[key] = value
+		}
+		server, db = castest.NewTestServer(config)
+		client = castest.NewClient(server)
+	}
+
+	BeforeEach(func() {
+		newServer(nil)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should log in users named by the header of a trusted proxy, without prompting them", func() {
+		w := login(proxyAddr, map[string]string{"X-Remote-User": "Test@Test.com"})
+		Expect(ticketUser(w)).To(Equal("test@test.com"))
+		Expect(sessionCount("test@test.com")).To(Equal(1))
+
+		// The session started is used for single sign on, like one started with a password
+		Expect(ticketUser(login(clientAddr, nil))).To(Equal("test@test.com"))
+		Expect(sessionCount("test@test.com")).To(Equal(1))
+	})
+
+	It("Should ignore the header when it is sent by peers that aren't trusted proxies", func() {
+		for _, headers := range []map[string]string{
+			{"X-Remote-User": "test@test.com"},
+			{"X-Remote-User": "test@test.com", "X-Forwarded-For": "10.0.0.1"},
+		} {
+			w := login(clientAddr, headers)
+			Expect(w.Code).To(Equal(http.StatusOK))
+			Expect(w.Header().Get("Location")).To(BeEmpty())
+			Expect(w.Body.String()).To(ContainSubstring(`name="password"`))
+		}
+		Expect(sessionCount("test@test.com")).To(Equal(0))
+	})
+
+	It("Should ignore the header when trusted header authentication is disabled", func() {
+		newServer(map[string]string{"trustedHeaderAuth": "false"})
+		w := login(proxyAddr, map[string]string{"X-Remote-User": "test@test.com"})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(sessionCount("test@test.com")).To(Equal(0))
+	})
+
+	It("Should fall back to the login form when trusted proxies don't send the header", func() {
+		w := login(proxyAddr, nil)
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`name="password"`))
+
+		w = do(proxyAddr, "POST", "/login", url.Values{"email": {"test@test.com"}, "password": {"secret"}, "serviceUrl": {testServiceUrl}}, nil)
+		Expect(ticketUser(w)).To(Equal("test@test.com"))
+	})
+
+	It("Should read the configured header", func() {
+		newServer(map[string]string{"trustedHeaderName": "remote-email"})
+		Expect(login(proxyAddr, map[string]string{"X-Remote-User": "test@test.com"}).Code).To(Equal(http.StatusOK))
+		Expect(ticketUser(login(proxyAddr, map[string]string{"Remote-Email": "test@test.com"}))).To(Equal("test@test.com"))
+	})
+
+	It("Should log the session in as the user named by the header, even if another user was logged in", func() {
+		Expect(ticketUser(login(proxyAddr, map[string]string{"X-Remote-User": "test@test.com"}))).To(Equal("test@test.com"))
+		Expect(ticketUser(login(proxyAddr, map[string]string{"X-Remote-User": "admin@test.com"}))).To(Equal("admin@test.com"))
+		Expect(sessionCount("test@test.com")).To(Equal(0))
+	})
+
+	It("Should refuse users that don't exist unless provisioning is enabled", func() {
+		w := login(proxyAddr, map[string]string{"X-Remote-User": "new@test.com"})
+		Expect(w.Code).To(Equal(UnknownTrustedHeaderUserError.HttpCode))
+		_, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).NotTo(BeNil())
+
+		newServer(map[string]string{"trustedHeaderProvision": "true"})
+		Expect(ticketUser(login(proxyAddr, map[string]string{"X-Remote-User": "new@test.com"}))).To(Equal("new@test.com"))
+		user, casErr := db.FindUserByEmail("new@test.com")
+		Expect(casErr).To(BeNil())
+		Expect(user.Password).To(BeEmpty())
+	})
+
+	It("Should still ask users with two-factor authentication enabled for a code", func() {
+		user, _ := db.FindUserByEmail("test@test.com")
+		user.TOTP = &UserTOTP{Secret: "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"}
+		Expect(db.UpdateUser(user)).To(BeNil())
+
+		w := login(proxyAddr, map[string]string{"X-Remote-User": "test@test.com"})
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Body.String()).To(ContainSubstring(`name="totpCode"`))
+		Expect(sessionCount("test@test.com")).To(Equal(0))
+	})
+
+	It("Should refuse to enable trusted header authentication without trusted proxies", func() {
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["trustedHeaderAuth"] = "true"
+		config["trustedHeaderName"] = "X-Remote User"
+		Expect(ValidateConfig(config)).To(ConsistOf(
+			"trustedHeaderAuth requires trustedProxies (the proxies allowed to set trustedHeaderName)",
+			"trustedHeaderName [X-Remote User] must be a header name (ex. X-Remote-User)",
+		))
+
+		_, err = NewTrustedHeaderAuthFromConfig(config, nil)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -1,11 +1,15 @@
 package cas
 
 import (
+	"context"
 	r "github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/dancannon/gorethink"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/mux"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/unrolled/render"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Small string tuple class implementation (see util.go)
@@ -14,10 +18,55 @@ type StringTuple [2]string
 // CasGo user
 type User struct {
 	Email      string            `gorethink:"email" json:"email"`
+	Name       string            `gorethink:"name" json:"name"`
 	Attributes map[string]string `gorethink:"attributes" json:"attributes"`
-	Password   string            `gorethink:"password" json:"password"`
+	Password   string            `gorethink:"password" json:"password"` // Password hash (see PasswordHasher)
 	Services   []CASService      `gorethink:"services" json:"services"`
 	IsAdmin    bool              `gorethink:"isAdmin" json:"isAdmin"`
+	TOTP       *UserTOTP         `gorethink:"totp" json:"-"` // Two-factor authentication secrets, never exposed through the API
+
+	// Always ask the user to confirm before redirecting them (with a ticket) to a service
+	WarnBeforeServiceLogin bool `gorethink:"warnBeforeServiceLogin" json:"warnBeforeServiceLogin"`
+}
+
+// Roles users can be filtered by (see CASUserQuery)
+const (
+	USER_ROLE_ADMIN   = "admin"
+	USER_ROLE_REGULAR = "regular"
+)
+
+// Page of users to find, by (case-insensitive) email substring and role
+type CASUserQuery struct {
+	Email  string // Substring the user's email must contain (empty matches all users)
+	Role   string // Role users must have (USER_ROLE_ADMIN or USER_ROLE_REGULAR, empty matches all users)
+	Offset int    // Number of matching users (ordered by email) to skip
+	Limit  int    // Maximum number of users to return (0 returns all remaining users)
+}
+
+// Whether a user matches the email and role of a query
+func (q CASUserQuery) Matches(user *User) bool {
+	if !strings.Contains(strings.ToLower(user.Email), strings.ToLower(q.Email)) {
+		return false
+	}
+	switch q.Role {
+	case USER_ROLE_ADMIN:
+		return user.IsAdmin
+	case USER_ROLE_REGULAR:
+		return !user.IsAdmin
+	}
+	return true
+}
+
+// Two-factor authentication (TOTP) state of a user
+type UserTOTP struct {
+	Secret        string `gorethink:"secret"`        // Enabled secret, codes generated from it are required on login
+	PendingSecret string `gorethink:"pendingSecret"` // Secret awaiting verification during enrollment
+	LastUsedStep  int64  `gorethink:"lastUsedStep"`  // Time step of the last accepted code (codes may not be reused)
+}
+
+// Whether a user must provide a TOTP code to log in
+func (u *User) TOTPEnabled() bool {
+	return u.TOTP != nil && len(u.TOTP.Secret) > 0
 }
 
 // Enforce schema for Users
@@ -41,20 +90,98 @@ func compareUsers(a, b User) bool {
 
 // CasGo registered service
 type CASService struct {
-	Url        string `gorethink:"url" json:"url"`
-	Name       string `gorethink:"name" json:"name"`
-	AdminEmail string `gorethink:"adminEmail" json:"adminEmail"`
+	Id                     string                     `gorethink:"id,omitempty" json:"id,omitempty"` // Immutable ID, assigned when the service is created (see SERVICE_ID_PREFIX)
+	Url                    string                     `gorethink:"url" json:"url"`
+	Name                   string                     `gorethink:"name" json:"name"`
+	AdminEmail             string                     `gorethink:"adminEmail" json:"adminEmail"`
+	LogoutUrl              string                     `gorethink:"logoutUrl" json:"logoutUrl"`
+	AttributeReleasePolicy *CASAttributeReleasePolicy `gorethink:"attributeReleasePolicy,omitempty" json:"attributeReleasePolicy,omitempty"`
+	MatchMode              string                     `gorethink:"matchMode,omitempty" json:"matchMode,omitempty"` // How Url is matched (see SERVICE_MATCH_EXACT), exact if empty
+	TicketPolicy           *CASServiceTicketPolicy    `gorethink:"ticketPolicy,omitempty" json:"ticketPolicy,omitempty"`
+	Version                int                        `gorethink:"version" json:"version"` // Incremented by every update, from 1 for services created through the API (0 if created without one)
+
+	// Hash of the secret validation requests for the service must present (see HashServiceSecret), none if empty
+	// Set through the API by giving the (write-only) secret as validationSecret
+	ValidationSecretHash string `gorethink:"validationSecretHash,omitempty" json:"validationSecretHash,omitempty"`
+
+	oidcLogin bool // Whether the service is the OIDC authorization endpoint (which users are sent back to without a ticket)
+}
+
+// Prefix of service IDs ("SVC-<random hex>"), which service names may not start with
+const SERVICE_ID_PREFIX = "SVC-"
+
+// Whether a value (ex. from a URL path that accepts either) is a service ID rather than a service name
+func IsServiceId(value string) bool {
+	return strings.HasPrefix(value, SERVICE_ID_PREFIX)
+}
+
+// Assign the service a new ID, unless it already has one
+func (s *CASService) ensureId() *CASServerError {
+	if len(s.Id) > 0 {
+		return nil
+	}
+	id, err := newTicketId("SVC")
+	if err != nil {
+		casErr := &FailedToCreateServiceError
+		casErr.err = &err
+		return casErr
+	}
+	s.Id = id
+	return nil
+}
+
+// Page of services to find, by (case-insensitive) name substring
+type CASServiceQuery struct {
+	Name   string // Substring the service name must contain (empty matches all services)
+	Offset int    // Number of matching services (ordered by name) to skip
+	Limit  int    // Maximum number of services to return (0 returns all remaining services)
 }
 
 // Enforce schema for CASService
 func (s *CASService) IsValid() bool {
-	return len(s.Url) > 0 && len(s.Name) > 0 && len(s.AdminEmail) > 0
+	return len(s.Url) > 0 && len(s.Name) > 0 && !IsServiceId(s.Name) && len(s.AdminEmail) > 0 && isValidLogoutUrl(s.LogoutUrl) && s.AttributeReleasePolicy.IsValid() && s.TicketPolicy.IsValid() && isValidServiceSecretHash(s.ValidationSecretHash) && s.ValidateUrlPattern() == nil
 }
 
 // Enforce lax schema for CASService updates (as they may not include some otherwise required fields)
 // at least the name must be present (used when getting the service, as it is the PK)
 func (s *CASService) IsValidUpdate() bool {
-	return len(s.Name) > 0
+	return len(s.Name) > 0 && isValidLogoutUrl(s.LogoutUrl) && s.AttributeReleasePolicy.IsValid() && s.TicketPolicy.IsValid() && isValidServiceSecretHash(s.ValidationSecretHash) && (len(s.Url) == 0 || s.ValidateUrlPattern() == nil)
+}
+
+// How long service tickets issued for a service are valid, and whether they can be validated more than once
+// Services without a policy get tickets valid for stTTL seconds, which can only be validated once (as CAS requires)
+type CASServiceTicketPolicy struct {
+	TTL         int `gorethink:"ttl" json:"ttl"`                 // Seconds tickets are valid for until they are first validated (stTTL if 0)
+	ReuseWindow int `gorethink:"reuseWindow" json:"reuseWindow"` // Seconds after their first validation that tickets can be validated again (single-use if 0)
+}
+
+// Enforce schema for CASServiceTicketPolicy (services need not have a policy)
+func (p *CASServiceTicketPolicy) IsValid() bool {
+	return p == nil || (p.TTL >= 0 && p.ReuseWindow >= 0)
+}
+
+// Attributes a service is permitted to receive during ticket validation
+type CASAttributeReleasePolicy struct {
+	Allowed []string          `gorethink:"allowed" json:"allowed"`                     // Attribute keys to release ("*" for all)
+	Mapping map[string]string `gorethink:"mapping,omitempty" json:"mapping,omitempty"` // Attribute key -> name it is released as
+}
+
+// Enforce schema for CASAttributeReleasePolicy (services need not have a policy)
+func (p *CASAttributeReleasePolicy) IsValid() bool {
+	if p == nil {
+		return true
+	}
+	for _, key := range p.Allowed {
+		if len(key) == 0 {
+			return false
+		}
+	}
+	for from, to := range p.Mapping {
+		if len(from) == 0 || len(to) == 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // CasGo ticket
@@ -63,6 +190,97 @@ type CASTicket struct {
 	UserEmail      string            `gorethink:"userEmail" json:"userEmail"`
 	UserAttributes map[string]string `gorethink:"userAttributes" json:"userAttributes"`
 	WasSSO         bool              `gorethink:"wasSSO" json:"wasSSO"`
+	Renewed        bool              `gorethink:"renewed" json:"renewed"` // Issued by a login requested with renew (so from credentials)
+	TGTId          string            `gorethink:"tgtId" json:"tgtId"`
+	ServiceUrl     string            `gorethink:"serviceUrl" json:"serviceUrl"`
+	ExpiresAt      time.Time         `gorethink:"expiresAt" json:"expiresAt"` // Until when the ticket can be (first) validated
+	Validated      bool              `gorethink:"validated" json:"validated"`
+	ValidatedAt    time.Time         `gorethink:"validatedAt" json:"validatedAt"` // When the ticket was first validated
+}
+
+// Check whether a service ticket has expired (can no longer be validated for the first time) as of the given time
+// Tickets issued before service tickets expired never do
+func (t *CASTicket) IsExpired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// CasGo ticket-granting ticket (login session)
+type CASTicketGrantingTicket struct {
+	Id         string    `gorethink:"id" json:"id"`
+	UserEmail  string    `gorethink:"userEmail" json:"userEmail"`
+	RememberMe bool      `gorethink:"rememberMe" json:"rememberMe"`
+	CreatedAt  time.Time `gorethink:"createdAt" json:"createdAt"`
+	LastSeenAt time.Time `gorethink:"lastSeenAt" json:"lastSeenAt"`
+	ExpiresAt  time.Time `gorethink:"expiresAt" json:"expiresAt"`
+
+	// Service tickets issued under the ticket-granting ticket, in total and in the current rate window (see ServiceTicketQuota)
+	ServiceTicketCount int       `gorethink:"serviceTicketCount" json:"serviceTicketCount"`
+	TicketWindowStart  time.Time `gorethink:"ticketWindowStart" json:"ticketWindowStart"`
+	TicketWindowCount  int       `gorethink:"ticketWindowCount" json:"ticketWindowCount"`
+}
+
+// Check whether a ticket-granting ticket has expired as of the given time
+func (t *CASTicketGrantingTicket) IsExpired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// Active login session of a user (as listed by the API)
+type CASSession struct {
+	Id         string    `json:"id"`
+	RememberMe bool      `json:"rememberMe"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Services   []string  `json:"services"` // URLs of services tickets were issued for
+}
+
+// CasGo proxy-granting ticket (CAS 2.0)
+type CASProxyGrantingTicket struct {
+	Id             string            `gorethink:"id" json:"id"`
+	Iou            string            `gorethink:"iou" json:"iou"`
+	UserEmail      string            `gorethink:"userEmail" json:"userEmail"`
+	UserAttributes map[string]string `gorethink:"userAttributes" json:"userAttributes"`
+	Proxies        []string          `gorethink:"proxies" json:"proxies"`
+	ExpiresAt      time.Time         `gorethink:"expiresAt" json:"expiresAt"`
+}
+
+// Check whether a proxy-granting ticket has expired as of the given time
+func (t *CASProxyGrantingTicket) IsExpired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// CasGo proxy ticket (CAS 2.0)
+type CASProxyTicket struct {
+	Id             string            `gorethink:"id" json:"id"`
+	UserEmail      string            `gorethink:"userEmail" json:"userEmail"`
+	UserAttributes map[string]string `gorethink:"userAttributes" json:"userAttributes"`
+	TargetService  string            `gorethink:"targetService" json:"targetService"`
+	Proxies        []string          `gorethink:"proxies" json:"proxies"`
+	ExpiresAt      time.Time         `gorethink:"expiresAt" json:"expiresAt"`
+}
+
+// Check whether a proxy ticket has expired as of the given time
+func (t *CASProxyTicket) IsExpired(now time.Time) bool {
+	return !now.Before(t.ExpiresAt)
+}
+
+// OpenID Connect authorization code, redeemed once by the client (service) it was issued to
+type OIDCAuthorizationCode struct {
+	Code                string    `gorethink:"id" json:"code"`
+	ClientId            string    `gorethink:"clientId" json:"clientId"`
+	RedirectUri         string    `gorethink:"redirectUri" json:"redirectUri"`
+	UserEmail           string    `gorethink:"userEmail" json:"userEmail"`
+	Scope               string    `gorethink:"scope" json:"scope"`
+	Nonce               string    `gorethink:"nonce" json:"nonce"`
+	CodeChallenge       string    `gorethink:"codeChallenge" json:"codeChallenge"`
+	CodeChallengeMethod string    `gorethink:"codeChallengeMethod" json:"codeChallengeMethod"`
+	AuthTime            time.Time `gorethink:"authTime" json:"authTime"`
+	ExpiresAt           time.Time `gorethink:"expiresAt" json:"expiresAt"`
+}
+
+// Check whether an authorization code has expired as of the given time
+func (c *OIDCAuthorizationCode) IsExpired(now time.Time) bool {
+	return !now.Before(c.ExpiresAt)
 }
 
 // CasGo API keypair
@@ -82,8 +300,10 @@ func CompareTickets(a, b CASTicket) bool {
 
 type CASServerError struct {
 	Msg          string // Message string
+	MsgKey       string // Key of the message in locale catalogs (see Translator), Msg is used when there is no translation
 	HttpCode     int    // HTTP error code, if applicable
 	CasgoErrCode int    // CASGO specific error code
+	Code         string // Stable, machine-readable error code reported to API clients (ex. "AUTH_FAILED")
 	err          *error // Actual error that was thrown (if any)
 }
 
@@ -96,13 +316,17 @@ type CASServer interface {
 	HandleServiceValidate(w http.ResponseWriter, r *http.Request)
 	HandleProxyValidate(w http.ResponseWriter, r *http.Request)
 	HandleProxy(w http.ResponseWriter, r *http.Request)
+	HandleServiceValidateV3(w http.ResponseWriter, r *http.Request)
 }
 
-// CAS DB interface
+// Validates user credentials, returning the authenticated user
+type CASAuthenticator interface {
+	Authenticate(email, password string) (*User, *CASServerError)
+}
+
+// Table based storage backend, which additionally supports table management and fixture loading
 type CASDBAdapter interface {
-	// Database setup & teardown logic
-	Setup() *CASServerError
-	Teardown() *CASServerError
+	Backend
 
 	// Table setup & teardown logic
 	DbExists() (bool, *CASServerError)
@@ -118,31 +342,17 @@ type CASDBAdapter interface {
 	// Fixture loading utility function
 	LoadJSONFixture(string, string, string) *CASServerError
 
-	// App functions
-	FindServiceByUrl(string) (*CASService, *CASServerError)
-	FindUserByEmail(string) (*User, *CASServerError)
-	FindUserByApiKeyAndSecret(string, string) (*User, *CASServerError)
-	AddTicketForService(ticket *CASTicket, service *CASService) (*CASTicket, *CASServerError)
-	RemoveTicketsForUserWithService(string, *CASService) *CASServerError
-	FindTicketByIdForService(string, *CASService) (*CASTicket, *CASServerError)
-	AddNewUser(string, string) (*User, *CASServerError)
-
-	// REST API functions (CRUD)
-	GetAllUsers() ([]User, *CASServerError)
-	UpdateUser(*User) *CASServerError
-	RemoveUserByEmail(string) *CASServerError
-
-	GetAllServices() ([]CASService, *CASServerError)
-	AddNewService(*CASService) *CASServerError
-	RemoveServiceByName(string) *CASServerError
-	UpdateService(*CASService) *CASServerError
-
 	// Property getter utility functions
 	GetDbName() string
 	GetTicketsTableName() string
 	GetServicesTableName() string
 	GetUsersTableName() string
 	GetApiKeysTableName() string
+	GetProxyGrantingTicketsTableName() string
+	GetProxyTicketsTableName() string
+	GetTicketGrantingTicketsTableName() string
+	GetAuditEventsTableName() string
+	GetOIDCCodesTableName() string
 }
 
 type CasgoFrontendAPI interface {
@@ -159,19 +369,130 @@ type CasgoFrontendAPI interface {
 
 // CAS Server
 type CAS struct {
-	server      *http.Server
-	ServeMux    *mux.Router
-	Config      map[string]string
-	Db          CASDBAdapter
-	Api         CasgoFrontendAPI
-	render      *render.Render
-	cookieStore *sessions.CookieStore
-	LogLevel    int
+	server   *http.Server
+	ServeMux *mux.Router
+	Config   map[string]string
+	Db       Backend
+	Api      CasgoFrontendAPI
+	render   *render.Render
+
+	// Store login (and CSRF) sessions are kept in, and the default options of their cookies
+	sessionStore   sessions.Store
+	sessionOptions *sessions.Options
+
+	// Structured logger used for all server logging
+	Logger Logger
+
+	// Time source for ticket issuance & expiry (RealClock unless replaced, ex. by tests)
+	Clock Clock
+
+	// HTTP client used to deliver proxy-granting tickets to proxy callback URLs
+	ProxyCallbackClient *http.Client
+
+	// Strategy used to generate service ticket and ticket-granting ticket IDs
+	TicketGenerator TicketGenerator
+
+	// Method used to validate user credentials on login (a CASFieldsAuthenticator also gets the extra login fields)
+	Authenticator CASAuthenticator
+
+	// Extra fields of the login form, whose values are passed to the authenticator
+	LoginFields []LoginField
+
+	// Hashes passwords of users created through registration or the API
+	PasswordHasher PasswordHasher
+
+	// Limits on the service tickets issued under each ticket-granting ticket
+	ServiceTicketQuota *ServiceTicketQuota
+
+	// Rules passwords must comply with when they are set (on registration, user creation and password changes)
+	PasswordPolicy *PasswordPolicy
+
+	// Metrics exposed (when enabled) at /metrics
+	Metrics *CASMetrics
+
+	// Serves /metrics when metricsAddr is set (started by Start)
+	metricsServer *http.Server
+
+	// Certificate served over TLS, reloaded when its files change (set up by Start and Serve)
+	Certificates *CertificateReloader
+
+	// Removes expired tickets from the storage backend (started by Start)
+	TicketSweeper *TicketSweeper
+
+	// Records security-relevant events in the storage backend (when it supports it)
+	AuditLog *AuditLog
+
+	// Notifies webhooks of audited events (does nothing when no webhooks are configured)
+	Webhooks *WebhookNotifier
+
+	// Background workers whose health is reported by the readiness probe and metrics
+	Workers *WorkerRegistry
+
+	// State published by single logout notifications (sent in the background)
+	sloHealth *WorkerHealth
+
+	// Limits failed login attempts per client IP
+	LoginRateLimiter LoginRateLimiter
+
+	// Proxies trusted to report client IPs (via X-Forwarded-For, or ClientIPHeader)
+	TrustedProxies TrustedProxies
+	ClientIPHeader string
+
+	// Logs in users named by a header set by trusted proxies (nil unless trustedHeaderAuth is enabled)
+	TrustedHeaderAuth *TrustedHeaderAuth
+
+	// Upstream CAS server logins may be delegated to (nil unless delegationEnabled is enabled)
+	Delegation *CASDelegation
+
+	// HTTP client used to deliver single logout requests to services
+	SingleLogoutClient *http.Client
+
+	// Cross-origin requests allowed to API endpoints
+	CORS *CORSPolicy
+
+	// Wraps API response bodies before they are rendered (raw bodies are rendered if nil)
+	APIEnvelope APIEnvelope
+
+	// Schemes service URLs may use, and how they are normalized
+	ServiceUrlPolicy *ServiceUrlPolicy
+
+	// Signs assertions of SAML validation responses (nil unless samlSigningEnabled is set)
+	SAMLSigner *SAMLSigner
+
+	// OpenID Connect provider bridging casgo logins to OIDC clients (nil unless oidcEnabled is set)
+	OIDC *OIDCProvider
+
+	// Tracks whether the storage backend is reachable, for the readiness probe
+	Readiness *ReadinessChecker
+
+	// Names, logo and colors the HTML pages are rendered with
+	Branding *Branding
+
+	// Path casgo is mounted under ("" at the root, otherwise ex. /cas), see URLPath
+	BasePath string
+
+	// Serves stylesheets, scripts and images (see AssetURLPath)
+	Static *StaticHandler
+
+	// Headers added to responses (CSP, HSTS, ...)
+	SecurityHeaders *SecurityHeaders
+
+	// Message catalogs HTML pages are translated with
+	Translator *Translator
+
+	// Serializes TOTP code checks and enrollment changes
+	totpMu sync.Mutex
+
+	// Serializes bulk imports (of services and backups)
+	importMu sync.Mutex
+
+	// Work started by handlers that must finish before the server stops
+	background backgroundTasks
 }
 
 // RethinkDB Adapter
 type RethinkDBAdapter struct {
-	session              *r.Session
+	pool                 *ConnPool
 	dbName               string
 	ticketsTableName     string
 	ticketsTableOptions  *r.TableCreateOpts
@@ -181,10 +502,25 @@ type RethinkDBAdapter struct {
 	usersTableOptions    *r.TableCreateOpts
 	apiKeysTableName     string
 	apiKeysTableOptions  *r.TableCreateOpts
-	LogLevel             string
+	pgtsTableName        string
+	pgtsTableOptions     *r.TableCreateOpts
+	ptsTableName         string
+	ptsTableOptions      *r.TableCreateOpts
+	tgtsTableName        string
+	tgtsTableOptions     *r.TableCreateOpts
+	auditTableName       string
+	auditTableOptions    *r.TableCreateOpts
+	oidcTableName        string
+	oidcTableOptions     *r.TableCreateOpts
+	migrationsTableName  string // Applied schema migrations (see Migrations)
+	logger               Logger
+	ctx                  context.Context // Context of the request the adapter (view) is used for
+	queryTimeout         time.Duration   // Longest each operation may take (0 if unlimited)
 }
 
 // CasGo frontend RESTful API
 type FrontendAPI struct {
-	casServer *CAS
+	casServer      *CAS
+	MaxBodySize    int64 // Largest request body accepted, in bytes (0 if unlimited)
+	RequireIfMatch bool  // Whether service updates must give the version they expect to replace
 }
@@ -0,0 +1,32 @@
+package cas
+
+/*
+ * User creation, shared by the API and the createadmin command
+ */
+
+// Create a user, with a password that complies with the password policy (stored hashed with PasswordHasher)
+// Returns the rules the password failed (along with WeakPasswordError) if it was refused
+func (c *CAS) createUser(db Backend, email, password string) (*User, []PasswordRuleFailure, *CASServerError) {
+	hashedPassword, failures, casErr := c.hashNewPassword(password)
+	if casErr != nil {
+		return nil, failures, casErr
+	}
+
+	user, casErr := db.AddNewUser(email, hashedPassword)
+	return user, nil, casErr
+}
+
+// Hash a password to be stored for a user, if it complies with the password policy
+func (c *CAS) hashNewPassword(password string) (string, []PasswordRuleFailure, *CASServerError) {
+	if failures := c.PasswordPolicy.Check(password); len(failures) > 0 {
+		return "", failures, &WeakPasswordError
+	}
+
+	hashedPassword, err := c.PasswordHasher.Hash(password)
+	if err != nil {
+		casErr := &FailedToCreateUserError
+		casErr.err = &err
+		return "", nil, casErr
+	}
+	return hashedPassword, nil, nil
+}
@@ -0,0 +1,12 @@
+package usersessions_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoUserSessions(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo User Sessions Suite")
+}
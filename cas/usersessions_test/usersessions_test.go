@@ -0,0 +1,178 @@
+package usersessions_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+var _ = Describe("User sessions API", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	userKey := map[string]string{"X-Api-Key": "userapikey", "X-Api-Secret": "badsecret"}
+	adminKey := map[string]string{"X-Api-Key": "adminapikey", "X-Api-Secret": "badsecret"}
+
+	// Perform a request against the server, passing along (and collecting) the client's cookies
+	do := func(method, path string, form url.Values, headers map[string]string) *httptest.ResponseRecorder {
+		var req *http.Request
+		if method == "POST" {
+			req, _ = http.NewRequest(method, path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			req, _ = http.NewRequest(method, path+"?"+form.Encode(), nil)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		return client.Do(req)
+	}
+
+	// Log in (as test@test.com) to a service, returning the issued ticket
+	login := func(serviceUrl string) string {
+		w := client.Login(url.Values{"serviceUrl": {serviceUrl}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+		return castest.Ticket(w)
+	}
+
+	listSessions := func(email string, headers map[string]string) (int, []CASSession) {
+		w := do("GET", "/api/users/"+email+"/sessions", url.Values{}, headers)
+		var body struct {
+			Data []CASSession `json:"data"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body.Data
+	}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+		client = castest.NewClient(server)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should list a user's active sessions, with the services they logged in to", func() {
+		login(testServiceUrl)
+
+		code, sessions := listSessions("test@test.com", userKey)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(sessions).To(HaveLen(1))
+		Expect(sessions[0].Services).To(Equal([]string{testServiceUrl}))
+		Expect(sessions[0].CreatedAt.IsZero()).To(BeFalse())
+		Expect(sessions[0].LastSeenAt).To(Equal(sessions[0].CreatedAt))
+
+		// Session IDs do not reveal ticket-granting tickets
+		tgts, _ := db.FindTicketGrantingTicketsForUser("test@test.com")
+		Expect(tgts).To(HaveLen(1))
+		Expect(sessions[0].Id).NotTo(Equal(tgts[0].Id))
+	})
+
+	It("Should leave out expired sessions", func() {
+		db.AddTicketGrantingTicket(&CASTicketGrantingTicket{
+			Id:        "TGT-expired",
+			UserEmail: "test@test.com",
+			ExpiresAt: time.Now().Add(-time.Minute),
+		})
+
+		code, sessions := listSessions("test@test.com", userKey)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(sessions).To(BeEmpty())
+	})
+
+	It("Should record when sessions were last used", func() {
+		login(testServiceUrl)
+		tgts, _ := db.FindTicketGrantingTicketsForUser("test@test.com")
+		tgt := tgts[0]
+		tgt.LastSeenAt = tgt.CreatedAt.Add(-time.Hour)
+		Expect(db.UpdateTicketGrantingTicket(&tgt)).To(BeNil())
+
+		w := do("GET", "/login", url.Values{"service": {testServiceUrl}, "gateway": {"true"}}, nil)
+		Expect(w.Code).To(Equal(http.StatusFound))
+
+		updated, _ := db.FindTicketGrantingTicketById(tgt.Id)
+		Expect(updated.LastSeenAt.After(tgt.CreatedAt)).To(BeTrue())
+	})
+
+	It("Should not let regular users see or revoke other users' sessions", func() {
+		code, _ := listSessions("admin@test.com", userKey)
+		Expect(code).To(Equal(InsufficientPermissionsError.HttpCode))
+
+		w := do("DELETE", "/api/users/admin@test.com/sessions/abc", url.Values{}, userKey)
+		Expect(w.Code).To(Equal(InsufficientPermissionsError.HttpCode))
+	})
+
+	It("Should require authentication", func() {
+		code, _ := listSessions("test@test.com", nil)
+		Expect(code).To(Equal(FailedToAuthenticateUserError.HttpCode))
+	})
+
+	It("Should let admins revoke sessions, ending single sign on", func() {
+		login(testServiceUrl)
+		code, sessions := listSessions("test@test.com", adminKey)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(sessions).To(HaveLen(1))
+
+		w := do("DELETE", "/api/users/test@test.com/sessions/"+sessions[0].Id, url.Values{}, adminKey)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+		Expect(w.Body.Len()).To(BeZero())
+
+		_, sessions = listSessions("test@test.com", adminKey)
+		Expect(sessions).To(BeEmpty())
+
+		w = do("GET", "/login", url.Values{"service": {testServiceUrl}, "gateway": {"true"}}, nil)
+		Expect(w.Header().Get("Location")).NotTo(ContainSubstring("ticket="))
+	})
+
+	It("Should fail to revoke sessions that do not exist", func() {
+		login(testServiceUrl)
+		w := do("DELETE", "/api/users/test@test.com/sessions/unknown", url.Values{}, adminKey)
+		Expect(w.Code).To(Equal(FailedToFindSessionError.HttpCode))
+	})
+
+	It("Should send logout requests to services when sessions are revoked", func() {
+		var mu sync.Mutex
+		logoutRequests := []string{}
+		logoutServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+			logoutRequests = append(logoutRequests, req.FormValue("logoutRequest"))
+		}))
+		defer logoutServer.Close()
+
+		Expect(db.AddNewService(&CASService{
+			Name:       "slo_service",
+			Url:        "localhost:4000/validateCASLogin",
+			AdminEmail: "admin@test.com",
+			LogoutUrl:  logoutServer.URL,
+		})).To(BeNil())
+
+		ticket := login("localhost:4000/validateCASLogin")
+		w := do("GET", "/validate", url.Values{"service": {"localhost:4000/validateCASLogin"}, "ticket": {ticket}}, nil)
+		Expect(w.Body.String()).To(ContainSubstring("success"))
+
+		_, sessions := listSessions("test@test.com", userKey)
+		w = do("DELETE", "/api/users/test@test.com/sessions/"+sessions[0].Id, url.Values{}, userKey)
+		Expect(w.Code).To(Equal(http.StatusNoContent))
+
+		Eventually(func() []string {
+			mu.Lock()
+			defer mu.Unlock()
+			return logoutRequests
+		}).Should(ContainElement(ContainSubstring(ticket)))
+	})
+})
@@ -1,33 +1,13 @@
 package cas
 
 import (
-	"fmt"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/GeertJohan/go.rice"
-	"log"
 	"os"
 )
 
-const (
-	_ = iota
-	DEBUG
-	INFO
-	WARN
-)
-
-// Utility functions for logging messages
-func logMessage(actualLogLevel, msgLogLevel, msg string) {
-	if actualLogLevel <= msgLogLevel {
-		log.Printf("[%s] %s", msgLogLevel, msg)
-	}
-}
-
-// Utility function for logging message
-func logMessagef(actualLogLevel, msgLogLevel, format string, msgArgs ...interface{}) {
-	if actualLogLevel <= msgLogLevel {
-		log.Printf("[%s] %s", msgLogLevel, fmt.Sprintf(format, msgArgs...))
-	}
-}
-
 // Small tuple implementation
 func (t *StringTuple) First() string {
 	return t[0]
@@ -59,3 +39,17 @@ func ListFilesInBox(box *rice.Box, prefix string) ([]string, error) {
 
 	return files, nil
 }
+
+// Generate a new random ticket ID with the given prefix (ex. "PGT" produces "PGT-<random hex>")
+func newTicketId(prefix string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + "-" + hex.EncodeToString(buf), nil
+}
+
+// Compare secrets (ex. API secrets) in constant time, so the time taken doesn't reveal how much of a guess was right
+func SecretsEqual(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
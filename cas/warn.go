@@ -0,0 +1,74 @@
+package cas
+
+import (
+	"github.com/t3hmrman/casgo/cas/Godeps/_workspace/src/github.com/gorilla/sessions"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+/*
+ * Warning users before they are logged in to services (the CAS warn feature)
+ */
+
+// Whether users may ask to be warned before being redirected to services
+func (c *CAS) serviceWarningEnabled() bool {
+	enabled, err := configBool(c.Config, "serviceWarningEnabled")
+	if err != nil {
+		c.Logger.Warn("Invalid configuration value, service warnings disabled", "key", "serviceWarningEnabled", "error", err)
+	}
+	return enabled
+}
+
+// Whether the login request asked for the user to be warned before being logged in to services
+func warnRequested(req *http.Request) bool {
+	value := strings.TrimSpace(strings.ToLower(req.FormValue("warn")))
+	return value == "on" || value == "true"
+}
+
+// Remember (for the rest of the session) whether the user asked to be warned before being logged in to services
+func setSessionWarning(session *sessions.Session, req *http.Request) {
+	if warnRequested(req) {
+		session.Values["warn"] = true
+	} else {
+		delete(session.Values, "warn")
+	}
+}
+
+// Whether a user should confirm being redirected to a service, either because they asked to be warned when they
+// logged in or because they always want to be warned
+func (c *CAS) shouldWarnBeforeRedirect(req *http.Request, user *User) bool {
+	if !c.serviceWarningEnabled() {
+		return false
+	}
+	if user.WarnBeforeServiceLogin {
+		return true
+	}
+	session, _ := c.sessionStore.Get(req, "casgo-session")
+	warn, _ := session.Values["warn"].(bool)
+	return warn
+}
+
+// Show the page asking the user to confirm being redirected to a service (with the ticket already issued)
+func (c *CAS) renderServiceWarning(w http.ResponseWriter, req *http.Request, service *CASService, redirectUrl string) {
+	context := c.templateContext(w, req)
+	context["ServiceName"] = service.Name
+	context["serviceUrl"] = service.Url
+	context["RedirectUrl"] = serviceRedirectLink(redirectUrl)
+	c.render.HTML(w, http.StatusOK, "warn", context)
+}
+
+// Service URLs are registered by admins and may have no (or a custom) scheme, which templates would otherwise refuse
+// to link to, but URLs that run scripts are still left for the template to sanitize
+func serviceRedirectLink(redirectUrl string) interface{} {
+	parsed, err := url.Parse(redirectUrl)
+	if err != nil {
+		return redirectUrl
+	}
+	switch strings.ToLower(parsed.Scheme) {
+	case "javascript", "vbscript", "data":
+		return redirectUrl
+	}
+	return template.URL(redirectUrl)
+}
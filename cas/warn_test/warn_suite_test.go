@@ -0,0 +1,12 @@
+package warn_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoWarn(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Warn Suite")
+}
@@ -0,0 +1,115 @@
+package warn_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"github.com/t3hmrman/casgo/cas/castest"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const testServiceUrl = "localhost:3000/validateCASLogin"
+
+// Link used to continue to the service from the warning page
+var continueLink = regexp.MustCompile(`id="continue"[^>]* href="([^"]+)"`)
+
+var _ = Describe("Warning before redirecting to services", func() {
+	var (
+		server *CAS
+		db     *MemoryBackend
+		client *castest.Client
+	)
+
+	do := func(method, path string, form url.Values) *httptest.ResponseRecorder {
+		if method == "POST" {
+			return client.PostForm(path, form)
+		}
+		return client.Get(path + "?" + form.Encode())
+	}
+
+	login := func(warn string) *httptest.ResponseRecorder {
+		return client.Login(url.Values{"serviceUrl": {testServiceUrl}, "warn": {warn}})
+	}
+
+	// Ticket in the link to continue to the service, which must validate
+	expectWarning := func(w *httptest.ResponseRecorder) {
+		Expect(w.Code).To(Equal(http.StatusOK))
+		Expect(w.Header().Get("Location")).To(BeEmpty())
+		Expect(w.Body.String()).To(ContainSubstring("test_service"))
+
+		matches := continueLink.FindStringSubmatch(w.Body.String())
+		Expect(matches).To(HaveLen(2))
+		location, err := url.Parse(strings.Replace(matches[1], "&amp;", "&", -1))
+		Expect(err).To(BeNil())
+		Expect(strings.SplitN(location.String(), "?", 2)[0]).To(Equal(testServiceUrl))
+
+		validation := do("GET", "/validate", url.Values{"service": {testServiceUrl}, "ticket": {location.Query().Get("ticket")}})
+		var response map[string]interface{}
+		Expect(json.Unmarshal(validation.Body.Bytes(), &response)).To(Succeed())
+		Expect(response["status"]).To(Equal("success"))
+	}
+
+	setUserPreference := func(warn bool) {
+		user, _ := db.FindUserByEmail("test@test.com")
+		user.WarnBeforeServiceLogin = warn
+		Expect(db.UpdateUser(user)).To(BeNil())
+	}
+
+	BeforeEach(func() {
+		server, db = castest.NewTestServer(nil)
+		client = castest.NewClient(server)
+	})
+
+	AfterEach(func() {
+		castest.Close(server)
+	})
+
+	It("Should redirect immediately when no warning was asked for", func() {
+		w := login("")
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+
+		w = do("GET", "/login", url.Values{"service": {testServiceUrl}})
+		Expect(w.Code).To(Equal(http.StatusFound))
+	})
+
+	It("Should warn for the rest of the session when asked to on login", func() {
+		expectWarning(login("true"))
+		expectWarning(do("GET", "/login", url.Values{"service": {testServiceUrl}}))
+		expectWarning(do("GET", "/login", url.Values{"service": {testServiceUrl}, "gateway": {"true"}}))
+
+		// Logging in again without asking for warnings stops them
+		Expect(login("").Code).To(Equal(http.StatusFound))
+		Expect(do("GET", "/login", url.Values{"service": {testServiceUrl}}).Code).To(Equal(http.StatusFound))
+	})
+
+	It("Should always warn users who prefer to be warned", func() {
+		setUserPreference(true)
+		expectWarning(login(""))
+		expectWarning(do("GET", "/login", url.Values{"service": {testServiceUrl}}))
+	})
+
+	It("Should offer the warning option on the login form", func() {
+		w := do("GET", "/login", url.Values{"service": {testServiceUrl}})
+		Expect(w.Body.String()).To(ContainSubstring(`name="warn"`))
+	})
+
+	It("Should never warn when service warnings are disabled", func() {
+		castest.Close(server)
+		server, db = castest.NewTestServer(map[string]string{"serviceWarningEnabled": "false"})
+		client = castest.NewClient(server)
+
+		w := do("GET", "/login", url.Values{"service": {testServiceUrl}})
+		Expect(w.Body.String()).NotTo(ContainSubstring(`name="warn"`))
+
+		setUserPreference(true)
+		w = login("true")
+		Expect(w.Code).To(Equal(http.StatusFound))
+		Expect(w.Header().Get("Location")).To(ContainSubstring("ticket="))
+	})
+})
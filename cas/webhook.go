@@ -0,0 +1,350 @@
+package cas
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Webhook notifications of audited events (ex. to feed a SIEM)
+ */
+
+// Headers sent with every webhook delivery
+const (
+	WEBHOOK_EVENT_HEADER     = "X-Casgo-Event"
+	WEBHOOK_DELIVERY_HEADER  = "X-Casgo-Delivery"
+	WEBHOOK_SIGNATURE_HEADER = "X-Casgo-Signature" // "sha256=" followed by the hex HMAC-SHA256 of the body (if the webhook has a secret)
+)
+
+// Endpoint notified of (a selection of) audited events
+type Webhook struct {
+	Url    string   `json:"url"`
+	Events []string `json:"events"` // Types of events to send (all of them if empty)
+	Secret string   `json:"secret"` // Key the payload is signed with (unsigned if empty)
+}
+
+// Whether the webhook should be notified of events of the given type
+func (h *Webhook) Wants(eventType string) bool {
+	return len(h.Events) == 0 || containsString(h.Events, eventType, false)
+}
+
+// Check that a webhook has an absolute HTTP(S) URL and only filters on known event types
+func (h *Webhook) Validate() error {
+	parsed, err := url.Parse(h.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || len(parsed.Host) == 0 {
+		return fmt.Errorf("Invalid webhook url [%s], expected an absolute http(s) URL", h.Url)
+	}
+	for _, eventType := range h.Events {
+		if !containsString(AUDIT_EVENT_TYPES, eventType, false) {
+			return fmt.Errorf("Invalid event [%s] for webhook [%s], expected one of %s", eventType, h.Url, strings.Join(AUDIT_EVENT_TYPES, ", "))
+		}
+	}
+	return nil
+}
+
+// Body POSTed to webhooks (as JSON)
+type WebhookPayload struct {
+	Id        string            `json:"id"` // Same for every webhook notified of the event (and every attempt)
+	Type      string            `json:"type"`
+	Time      time.Time         `json:"time"`
+	Actor     string            `json:"actor"`
+	SourceIP  string            `json:"sourceIp"`
+	Outcome   string            `json:"outcome"`
+	Target    string            `json:"target,omitempty"`
+	RequestId string            `json:"requestId,omitempty"`
+	Details   map[string]string `json:"details,omitempty"`
+}
+
+// Signature of a webhook payload, as sent in the WEBHOOK_SIGNATURE_HEADER
+func SignWebhookPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type WebhookOptions struct {
+	Workers     int           // Deliveries made concurrently
+	QueueSize   int           // Deliveries waiting for a worker, beyond which new ones are dropped
+	Timeout     time.Duration // Time allowed for each attempt
+	MaxAttempts int           // Attempts made at each delivery (including the first)
+	MinBackoff  time.Duration // Initial delay between attempts
+	MaxBackoff  time.Duration // Maximum delay between attempts
+	Logger      Logger        // Receives delivery failures (the default logger is used if nil)
+}
+
+// Build (and validate) webhook delivery options from server configuration
+func NewWebhookOptions(config map[string]string) (WebhookOptions, error) {
+	opts := WebhookOptions{}
+
+	for key, dest := range map[string]*int{"webhookWorkers": &opts.Workers, "webhookQueueSize": &opts.QueueSize, "webhookMaxAttempts": &opts.MaxAttempts} {
+		value, err := configInt(config, key)
+		if err != nil {
+			return opts, err
+		}
+		if value < 1 {
+			return opts, fmt.Errorf("Invalid %s [%d], must be at least 1", key, value)
+		}
+		*dest = value
+	}
+
+	opts.Timeout = configSecondsAsDuration(config, "webhookTimeout")
+	opts.MinBackoff = time.Second
+	opts.MaxBackoff = configSecondsAsDuration(config, "webhookMaxBackoff")
+	return opts, nil
+}
+
+// Read webhooks from a JSON file (an array of webhooks)
+func LoadWebhooksFile(path string) ([]Webhook, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read webhooksFile [%s], %v", path, err)
+	}
+
+	var hooks []Webhook
+	if err := json.Unmarshal(contents, &hooks); err != nil {
+		return nil, fmt.Errorf("Invalid webhooksFile [%s], %v", path, err)
+	}
+	for i := range hooks {
+		if err := hooks[i].Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return hooks, nil
+}
+
+type webhookDelivery struct {
+	hook      *Webhook
+	eventType string
+	id        string
+	body      []byte
+}
+
+// Delivers events to webhooks from a bounded pool of workers, so slow endpoints never hold up requests
+// Failed deliveries (errors and non-2xx responses) are retried with exponential backoff
+// Without webhooks nothing is started and notifying does nothing
+type WebhookNotifier struct {
+	hooks   []Webhook
+	opts    WebhookOptions
+	client  *http.Client
+	metrics *CASMetrics
+	queue   chan webhookDelivery
+	health  *WorkerHealth
+
+	mu       sync.RWMutex // Held (for writing) while stopping, so nothing is queued once the workers are gone
+	closed   bool
+	stop     chan struct{}
+	stopped  sync.WaitGroup
+	stopOnce sync.Once
+}
+
+func NewWebhookNotifier(hooks []Webhook, opts WebhookOptions, metrics *CASMetrics) *WebhookNotifier {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	if opts.QueueSize < 1 {
+		opts.QueueSize = 1
+	}
+	if opts.MaxAttempts < 1 {
+		opts.MaxAttempts = 1
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = opts.MinBackoff
+	}
+	opts.Logger = loggerOrDefault(opts.Logger)
+	if metrics == nil {
+		metrics = NewCASMetrics()
+	}
+
+	notifier := &WebhookNotifier{
+		hooks:   hooks,
+		opts:    opts,
+		client:  &http.Client{Timeout: opts.Timeout},
+		metrics: metrics,
+		stop:    make(chan struct{}),
+	}
+	notifier.health = newWorkerHealth(WORKER_WEBHOOKS, 0, false, func() int { return len(notifier.queue) }, opts.QueueSize)
+
+	if len(hooks) > 0 {
+		notifier.queue = make(chan webhookDelivery, opts.QueueSize)
+		for i := 0; i < opts.Workers; i++ {
+			notifier.stopped.Add(1)
+			go notifier.deliverLoop()
+		}
+	}
+	return notifier
+}
+
+// Create the webhook notifier specified by server configuration (webhooksFile & webhook* options)
+func NewWebhookNotifierFromConfig(config map[string]string, metrics *CASMetrics, logger Logger) (*WebhookNotifier, error) {
+	opts, err := NewWebhookOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	opts.Logger = logger
+
+	var hooks []Webhook
+	if path := config["webhooksFile"]; len(path) > 0 {
+		if hooks, err = LoadWebhooksFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return NewWebhookNotifier(hooks, opts, metrics), nil
+}
+
+// Queue deliveries of an event to the webhooks that want it (deliveries that don't fit in the queue are dropped)
+func (n *WebhookNotifier) Notify(event AuditEvent) {
+	if n == nil || len(n.hooks) == 0 {
+		return
+	}
+
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+
+	var body []byte
+	for i := range n.hooks {
+		hook := &n.hooks[i]
+		if !hook.Wants(event.Type) {
+			continue
+		}
+
+		// The payload is the same for every webhook
+		if body == nil {
+			var err error
+			body, err = json.Marshal(WebhookPayload{
+				Id:        newRequestId(),
+				Type:      event.Type,
+				Time:      event.Time,
+				Actor:     event.Actor,
+				SourceIP:  event.SourceIP,
+				Outcome:   event.Outcome,
+				Target:    event.Target,
+				RequestId: event.RequestId,
+				Details:   event.Details,
+			})
+			if err != nil {
+				n.opts.Logger.Error("Failed to encode webhook payload", "type", event.Type, "error", err)
+				return
+			}
+		}
+
+		select {
+		case n.queue <- webhookDelivery{hook: hook, eventType: event.Type, id: newRequestId(), body: body}:
+			n.health.Queued(1)
+		default:
+			n.metrics.WebhookDeliveries.Inc("dropped")
+			n.opts.Logger.Error("Dropped webhook delivery, too many pending", "url", hook.Url, "type", event.Type)
+		}
+	}
+}
+
+// State the notifier's workers publish as they deliver (nil if there are no webhooks, so nothing is started)
+func (n *WebhookNotifier) Health() *WorkerHealth {
+	if n == nil || len(n.hooks) == 0 {
+		return nil
+	}
+	return n.health
+}
+
+// Stop delivering, giving up on queued deliveries and retries (deliveries in flight are allowed to finish)
+func (n *WebhookNotifier) Stop() {
+	if n == nil || len(n.hooks) == 0 {
+		return
+	}
+
+	n.mu.Lock()
+	n.closed = true
+	n.mu.Unlock()
+
+	n.stopOnce.Do(func() { close(n.stop) })
+	n.stopped.Wait()
+
+	for abandoned := len(n.queue); abandoned > 0; abandoned-- {
+		<-n.queue
+		n.metrics.WebhookDeliveries.Inc("abandoned")
+	}
+}
+
+func (n *WebhookNotifier) deliverLoop() {
+	defer n.stopped.Done()
+
+	for {
+		select {
+		case delivery := <-n.queue:
+			n.deliver(delivery)
+		case <-n.stop:
+			return
+		}
+	}
+}
+
+// Attempt a delivery until it succeeds, it has been attempted MaxAttempts times or the notifier is stopped
+func (n *WebhookNotifier) deliver(delivery webhookDelivery) {
+	backoff := n.opts.MinBackoff
+	for attempt := 1; ; attempt++ {
+		err := n.post(delivery)
+		n.health.Ran(err)
+		if err == nil {
+			n.metrics.WebhookDeliveries.Inc("delivered")
+			return
+		}
+
+		if attempt >= n.opts.MaxAttempts {
+			n.metrics.WebhookDeliveries.Inc("failed")
+			n.opts.Logger.Warn("Failed to deliver webhook", "url", delivery.hook.Url, "type", delivery.eventType, "attempts", attempt, "error", err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-n.stop:
+			n.metrics.WebhookDeliveries.Inc("abandoned")
+			return
+		}
+		backoff *= 2
+		if backoff > n.opts.MaxBackoff {
+			backoff = n.opts.MaxBackoff
+		}
+	}
+}
+
+func (n *WebhookNotifier) post(delivery webhookDelivery) error {
+	req, err := http.NewRequest("POST", delivery.hook.Url, bytes.NewReader(delivery.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WEBHOOK_EVENT_HEADER, delivery.eventType)
+	req.Header.Set(WEBHOOK_DELIVERY_HEADER, delivery.id)
+	if len(delivery.hook.Secret) > 0 {
+		req.Header.Set(WEBHOOK_SIGNATURE_HEADER, SignWebhookPayload(delivery.hook.Secret, delivery.body))
+	}
+
+	res, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with status %d", res.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+package webhook_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoWebhook(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo Webhook Suite")
+}
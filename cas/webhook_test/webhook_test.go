@@ -0,0 +1,242 @@
+package webhook_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Request received by a stub webhook endpoint
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// Webhook endpoint recording the requests it receives, responding with the given statuses in turn (then 200)
+type stubReceiver struct {
+	*httptest.Server
+	requests chan received
+
+	mu       sync.Mutex
+	statuses []int
+}
+
+func newStubReceiver(statuses ...int) *stubReceiver {
+	stub := &stubReceiver{requests: make(chan received, 100), statuses: statuses}
+	stub.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		stub.requests <- received{header: req.Header, body: body}
+
+		stub.mu.Lock()
+		status := http.StatusOK
+		if len(stub.statuses) > 0 {
+			status, stub.statuses = stub.statuses[0], stub.statuses[1:]
+		}
+		stub.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	return stub
+}
+
+func receive(stub *stubReceiver) received {
+	var request received
+	Eventually(stub.requests, 5*time.Second).Should(Receive(&request))
+	return request
+}
+
+var _ = Describe("Webhooks", func() {
+	var (
+		server  *CAS
+		all     *stubReceiver
+		logins  *stubReceiver
+		tempDir string
+	)
+
+	do := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if method == "POST" && !strings.HasPrefix(path, "/api/") {
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	login := func(password string) {
+		form := url.Values{"email": {"test@test.com"}, "password": {password}}
+		do("POST", "/login", form.Encode(), nil)
+	}
+
+	BeforeEach(func() {
+		all = newStubReceiver()
+		logins = newStubReceiver()
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "casgo-webhooks")
+		Expect(err).To(BeNil())
+		hooks, _ := json.Marshal([]Webhook{
+			{Url: all.URL, Secret: "shared-secret"},
+			{Url: logins.URL, Events: []string{AUDIT_LOGIN}},
+		})
+		hooksFile := filepath.Join(tempDir, "webhooks.json")
+		Expect(ioutil.WriteFile(hooksFile, hooks, 0600)).To(Succeed())
+
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+		config["csrfEnabled"] = "false"
+		config["webhooksFile"] = hooksFile
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+
+		db := server.Db.(*MemoryBackend)
+		Expect(db.LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+		Expect(db.LoadJSONFixture("api_keys", "../../fixtures/api_keys.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		server.Webhooks.Stop()
+		server.AuditLog.Stop()
+		server.Db.(*MemoryBackend).Close()
+		all.Close()
+		logins.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	It("Should POST signed JSON payloads of events to webhooks", func() {
+		login("wrong")
+
+		request := receive(all)
+		Expect(request.header.Get("Content-Type")).To(Equal("application/json"))
+		Expect(request.header.Get(WEBHOOK_EVENT_HEADER)).To(Equal(AUDIT_LOGIN))
+		Expect(request.header.Get(WEBHOOK_DELIVERY_HEADER)).NotTo(BeEmpty())
+		Expect(request.header.Get(WEBHOOK_SIGNATURE_HEADER)).To(Equal(SignWebhookPayload("shared-secret", request.body)))
+		Expect(request.header.Get(WEBHOOK_SIGNATURE_HEADER)).To(HavePrefix("sha256="))
+
+		var payload map[string]interface{}
+		Expect(json.Unmarshal(request.body, &payload)).To(Succeed())
+		Expect(payload["id"]).NotTo(BeEmpty())
+		Expect(payload["type"]).To(Equal(AUDIT_LOGIN))
+		Expect(payload["actor"]).To(Equal("test@test.com"))
+		Expect(payload["outcome"]).To(Equal(AUDIT_FAILURE))
+		Expect(payload["sourceIp"]).To(Equal("192.0.2.1"))
+		Expect(payload["requestId"]).NotTo(BeEmpty())
+		Expect(payload["details"]).To(HaveKeyWithValue("errorCode", InvalidCredentialsError.Code))
+		_, err := time.Parse(time.RFC3339, payload["time"].(string))
+		Expect(err).To(BeNil())
+
+		// Webhooks without a secret get unsigned payloads
+		request = receive(logins)
+		Expect(request.header.Get(WEBHOOK_SIGNATURE_HEADER)).To(BeEmpty())
+		Expect(request.header.Get(WEBHOOK_EVENT_HEADER)).To(Equal(AUDIT_LOGIN))
+	})
+
+	It("Should only send webhooks the events they subscribed to", func() {
+		admin := map[string]string{"X-Api-Key": "adminapikey", "X-Api-Secret": "badsecret"}
+		Expect(do("POST", "/api/services", `{"name":"hooked","url":"localhost:4000/cas","adminEmail":"admin@test.com"}`, admin).Code).To(Equal(http.StatusOK))
+		login("wrong")
+
+		types := []string{}
+		for i := 0; i < 3; i++ {
+			types = append(types, receive(all).header.Get(WEBHOOK_EVENT_HEADER))
+		}
+		Expect(types).To(ConsistOf(AUDIT_API_KEY_USED, AUDIT_SERVICE_CREATED, AUDIT_LOGIN))
+
+		Expect(receive(logins).header.Get(WEBHOOK_EVENT_HEADER)).To(Equal(AUDIT_LOGIN))
+		Consistently(logins.requests, 200*time.Millisecond).ShouldNot(Receive())
+	})
+})
+
+var _ = Describe("WebhookNotifier", func() {
+	var (
+		metrics *CASMetrics
+		opts    WebhookOptions
+	)
+
+	BeforeEach(func() {
+		metrics = NewCASMetrics()
+		opts = WebhookOptions{Workers: 2, QueueSize: 10, Timeout: time.Second, MaxAttempts: 3, MinBackoff: 10 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Logger: NoopLogger{}}
+	})
+
+	It("Should retry deliveries that fail, sending the same payload", func() {
+		stub := newStubReceiver(http.StatusInternalServerError, http.StatusBadGateway)
+		defer stub.Close()
+		notifier := NewWebhookNotifier([]Webhook{{Url: stub.URL, Secret: "s"}}, opts, metrics)
+		defer notifier.Stop()
+
+		notifier.Notify(AuditEvent{Type: AUDIT_LOGOUT, Actor: "test@test.com", Outcome: AUDIT_SUCCESS})
+
+		first, second, third := receive(stub), receive(stub), receive(stub)
+		Expect(second.body).To(Equal(first.body))
+		Expect(third.body).To(Equal(first.body))
+		Expect(third.header.Get(WEBHOOK_DELIVERY_HEADER)).To(Equal(first.header.Get(WEBHOOK_DELIVERY_HEADER)))
+		Eventually(func() float64 { return metrics.WebhookDeliveries.Value("delivered") }).Should(Equal(1.0))
+	})
+
+	It("Should give up after the maximum number of attempts", func() {
+		stub := newStubReceiver(500, 500, 500, 500)
+		defer stub.Close()
+		notifier := NewWebhookNotifier([]Webhook{{Url: stub.URL}}, opts, metrics)
+		defer notifier.Stop()
+
+		notifier.Notify(AuditEvent{Type: AUDIT_LOGIN})
+		Eventually(func() float64 { return metrics.WebhookDeliveries.Value("failed") }).Should(Equal(1.0))
+		Expect(stub.requests).To(HaveLen(3))
+	})
+
+	It("Should not block when endpoints are slow, dropping deliveries beyond the queue", func() {
+		release := make(chan struct{})
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			<-release
+		}))
+		defer slow.Close()
+
+		opts.Workers, opts.QueueSize = 1, 2
+		notifier := NewWebhookNotifier([]Webhook{{Url: slow.URL}}, opts, metrics)
+		defer notifier.Stop()
+		defer close(release)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 10; i++ {
+				notifier.Notify(AuditEvent{Type: AUDIT_LOGIN})
+			}
+		}()
+		Eventually(done).Should(BeClosed())
+		Expect(metrics.WebhookDeliveries.Value("dropped")).To(BeNumerically(">=", 7))
+	})
+
+	It("Should do nothing without webhooks", func() {
+		notifier := NewWebhookNotifier(nil, opts, metrics)
+		notifier.Notify(AuditEvent{Type: AUDIT_LOGIN})
+		notifier.Stop()
+		Expect(metrics.WebhookDeliveries.Value("dropped")).To(BeZero())
+
+		var unset *WebhookNotifier
+		unset.Notify(AuditEvent{Type: AUDIT_LOGIN})
+		unset.Stop()
+	})
+
+	It("Should refuse webhooks with invalid URLs or unknown events", func() {
+		Expect((&Webhook{Url: "https://siem.test/hook", Events: []string{AUDIT_LOGIN, AUDIT_SERVICE_DELETED}}).Validate()).To(Succeed())
+		Expect((&Webhook{Url: "siem.test/hook"}).Validate()).NotTo(Succeed())
+		Expect((&Webhook{Url: "ftp://siem.test/hook"}).Validate()).NotTo(Succeed())
+		Expect((&Webhook{Url: "https://siem.test/hook", Events: []string{"everything"}}).Validate()).NotTo(Succeed())
+	})
+})
@@ -0,0 +1,12 @@
+package workerhealth_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestCasgoWorkerHealth(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "CasGo WorkerHealth Suite")
+}
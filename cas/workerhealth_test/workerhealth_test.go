@@ -0,0 +1,252 @@
+package workerhealth_test
+
+import (
+	"encoding/json"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/t3hmrman/casgo/cas"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clock that only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Webhook endpoint whose responses can be held up
+type stalledEndpoint struct {
+	*httptest.Server
+	mu       sync.Mutex
+	received int
+	release  chan struct{}
+}
+
+func newStalledEndpoint() *stalledEndpoint {
+	endpoint := &stalledEndpoint{release: make(chan struct{})}
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		endpoint.mu.Lock()
+		endpoint.received++
+		endpoint.mu.Unlock()
+		<-endpoint.release
+	}))
+	return endpoint
+}
+
+func (e *stalledEndpoint) Received() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.received
+}
+
+// Status of a worker in a readiness report
+func workerStatus(report HealthReport, name string) WorkerStatus {
+	for _, status := range report.Workers {
+		if status.Name == name {
+			return status
+		}
+	}
+	Fail("No status reported for worker " + name)
+	return WorkerStatus{}
+}
+
+var _ = Describe("Background worker health", func() {
+	var (
+		server   *CAS
+		clock    *fakeClock
+		endpoint *stalledEndpoint
+		tempDir  string
+	)
+
+	BeforeEach(func() {
+		endpoint = newStalledEndpoint()
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "casgo-worker-health")
+		Expect(err).To(BeNil())
+		hooks, _ := json.Marshal([]Webhook{{Url: endpoint.URL, Events: []string{AUDIT_LOGIN}}})
+		hooksFile := filepath.Join(tempDir, "webhooks.json")
+		Expect(ioutil.WriteFile(hooksFile, hooks, 0600)).To(Succeed())
+
+		config, err := NewCASServerConfig("")
+		Expect(err).To(BeNil())
+		config["dbBackend"] = "memory"
+		config["templatesDirectory"] = "../templates"
+		config["csrfEnabled"] = "false"
+		config["metricsEnabled"] = "true"
+		config["webhooksFile"] = hooksFile
+		config["webhookWorkers"] = "1"
+		config["webhookQueueSize"] = "2"
+		config["workerStallTimeout"] = "60"
+		config["ticketSweepInterval"] = "30"
+
+		server, err = NewCASServerWithLogger(config, NoopLogger{})
+		Expect(err).To(BeNil())
+		clock = &fakeClock{now: time.Now()}
+		server.Clock = clock
+		Expect(server.Db.(*MemoryBackend).LoadJSONFixture("users", "../../fixtures/users.json")).To(BeNil())
+	})
+
+	AfterEach(func() {
+		close(endpoint.release)
+		server.TicketSweeper.Stop()
+		server.Webhooks.Stop()
+		server.AuditLog.Stop()
+		server.Db.(*MemoryBackend).Close()
+		endpoint.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		server.Handler().ServeHTTP(w, req)
+		return w
+	}
+
+	ready := func() HealthReport {
+		w := get("/readyz")
+		Expect(w.Code).To(Equal(http.StatusOK))
+		var report HealthReport
+		Expect(json.Unmarshal(w.Body.Bytes(), &report)).To(Succeed())
+		return report
+	}
+
+	// Fail a login, which is audited (and sent to the webhook)
+	failLogin := func() {
+		form := url.Values{"email": {"test@test.com"}, "password": {"wrong"}}
+		req := httptest.NewRequest("POST", "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		server.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	It("Should report every worker as healthy when idle", func() {
+		report := ready()
+		Expect(report.Status).To(Equal(HEALTH_OK))
+		Expect(report.Components["workers"].Status).To(Equal(HEALTH_OK))
+
+		names := []string{}
+		for _, status := range report.Workers {
+			Expect(status.Status).To(Equal(HEALTH_OK))
+			names = append(names, status.Name)
+		}
+		Expect(names).To(Equal([]string{WORKER_AUDIT_LOG, WORKER_SINGLE_LOGOUT, WORKER_TICKET_SWEEPER, WORKER_WEBHOOKS}))
+		Expect(workerStatus(report, WORKER_WEBHOOKS).QueueCapacity).To(Equal(2))
+	})
+
+	It("Should report a worker that makes no progress with work queued as degraded", func() {
+		// The first delivery holds up the only worker, the second waits in the queue
+		failLogin()
+		Eventually(endpoint.Received).Should(Equal(1))
+		failLogin()
+
+		status := workerStatus(ready(), WORKER_WEBHOOKS)
+		Expect(status.Status).To(Equal(HEALTH_OK))
+		Expect(status.QueueLength).To(Equal(1))
+
+		server.AuditLog.Flush()
+		clock.Advance(61 * time.Second)
+		report := ready()
+		Expect(report.Status).To(Equal(HEALTH_DEGRADED))
+		Expect(report.Components["workers"].Status).To(Equal(HEALTH_DEGRADED))
+		Expect(report.Components["workers"].Message).To(ContainSubstring(WORKER_WEBHOOKS))
+		status = workerStatus(report, WORKER_WEBHOOKS)
+		Expect(status.Status).To(Equal(HEALTH_DEGRADED))
+		Expect(status.Message).To(ContainSubstring("no progress in 1m1s"))
+
+		// Other workers are unaffected
+		Expect(workerStatus(report, WORKER_AUDIT_LOG).Status).To(Equal(HEALTH_OK))
+	})
+
+	It("Should report a worker with a full queue as degraded", func() {
+		failLogin()
+		Eventually(endpoint.Received).Should(Equal(1))
+		failLogin()
+		failLogin()
+
+		status := workerStatus(ready(), WORKER_WEBHOOKS)
+		Expect(status.Status).To(Equal(HEALTH_DEGRADED))
+		Expect(status.QueueLength).To(Equal(2))
+		Expect(status.Message).To(ContainSubstring("Queue is full"))
+
+		// Once the endpoint responds again, the queue drains
+		endpoint.release <- struct{}{}
+		endpoint.release <- struct{}{}
+		endpoint.release <- struct{}{}
+		Eventually(func() string { return workerStatus(ready(), WORKER_WEBHOOKS).Status }).Should(Equal(HEALTH_OK))
+		status = workerStatus(ready(), WORKER_WEBHOOKS)
+		Expect(status.QueueLength).To(Equal(0))
+		Expect(status.LastRun).NotTo(BeNil())
+		Expect(*status.LastRun).To(BeTemporally("==", clock.Now()))
+	})
+
+	It("Should report a periodic worker that has not run within its interval as degraded", func() {
+		server.TicketSweeper.Start()
+
+		clock.Advance(59 * time.Second)
+		Expect(workerStatus(ready(), WORKER_TICKET_SWEEPER).Status).To(Equal(HEALTH_OK))
+
+		clock.Advance(2 * time.Second)
+		status := workerStatus(ready(), WORKER_TICKET_SWEEPER)
+		Expect(status.Status).To(Equal(HEALTH_DEGRADED))
+		Expect(status.Message).To(ContainSubstring("Has not run in 1m1s"))
+
+		_, casErr := server.TicketSweeper.Sweep()
+		Expect(casErr).To(BeNil())
+		status = workerStatus(ready(), WORKER_TICKET_SWEEPER)
+		Expect(status.Status).To(Equal(HEALTH_OK))
+		Expect(*status.LastRun).To(BeTemporally("==", clock.Now()))
+	})
+
+	It("Should report the state of workers in the metrics", func() {
+		failLogin()
+		Eventually(endpoint.Received).Should(Equal(1))
+		failLogin()
+		failLogin()
+
+		metrics := get("/metrics").Body.String()
+		Expect(metrics).To(ContainSubstring(`casgo_worker_queue_length{worker="webhooks"} 2`))
+		Expect(metrics).To(ContainSubstring(`casgo_worker_degraded{worker="webhooks"} 1`))
+		Expect(metrics).To(ContainSubstring(`casgo_worker_degraded{worker="ticketSweeper"} 0`))
+		Expect(metrics).To(ContainSubstring(`casgo_worker_errors_total{worker="webhooks"} 0`))
+	})
+})
+
+var _ = Describe("WorkerRegistry", func() {
+	It("Should count failed runs", func() {
+		clock := &fakeClock{now: time.Now()}
+		registry := NewWorkerRegistry(clock, time.Minute)
+		db := NewMemoryBackend(0)
+		defer db.Close()
+		sweeper := NewTicketSweeper(db, time.Minute, nil, NoopLogger{})
+		registry.Register(sweeper.Health())
+
+		sweeper.Health().Ran(nil)
+		sweeper.Health().Ran(&FailedToAcquireDbConnectionError)
+
+		statuses := registry.Statuses()
+		Expect(statuses).To(HaveLen(1))
+		Expect(statuses[0].Errors).To(Equal(1))
+		Expect(statuses[0].LastError).To(Equal(FailedToAcquireDbConnectionError.Msg))
+	})
+})
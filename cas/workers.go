@@ -0,0 +1,217 @@
+package cas
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+ * Health of background workers (single logout notifier, webhook sender, audit log writer & ticket sweeper)
+ */
+
+// Names background workers are reported under
+const (
+	WORKER_SINGLE_LOGOUT  = "singleLogout"
+	WORKER_WEBHOOKS       = "webhooks"
+	WORKER_AUDIT_LOG      = "auditLog"
+	WORKER_TICKET_SWEEPER = "ticketSweeper"
+)
+
+// State of a background worker, as reported by the readiness probe
+type WorkerStatus struct {
+	Name          string     `json:"name"`
+	Status        string     `json:"status"` // HEALTH_OK, or HEALTH_DEGRADED if its queue is full or it has stalled
+	Message       string     `json:"message,omitempty"`
+	LastRun       *time.Time `json:"lastRun,omitempty"`
+	QueueLength   int        `json:"queueLength"`
+	QueueCapacity int        `json:"queueCapacity,omitempty"` // 0 if the queue is unbounded
+	Errors        int        `json:"errors"`
+	LastError     string     `json:"lastError,omitempty"`
+}
+
+// State published by a background worker as it runs
+// Periodic workers must run within twice their interval, other workers must make progress within their interval
+// while they have work queued
+type WorkerHealth struct {
+	Name          string
+	Interval      time.Duration
+	Periodic      bool
+	QueueCapacity int        // Work that can be queued before more is dropped (0 if unbounded)
+	queueLength   func() int // nil if queued work is counted by Queued & Ran
+
+	mu           sync.Mutex
+	clock        Clock
+	since        time.Time // When a periodic worker was started (or last ran)
+	waitingSince time.Time // When work queued for a non-periodic worker was last waited on without progress
+	lastRun      time.Time
+	pending      int
+	errors       int
+	lastError    string
+}
+
+func newWorkerHealth(name string, interval time.Duration, periodic bool, queueLength func() int, queueCapacity int) *WorkerHealth {
+	return &WorkerHealth{
+		Name:          name,
+		Interval:      interval,
+		Periodic:      periodic,
+		QueueCapacity: queueCapacity,
+		queueLength:   queueLength,
+		clock:         RealClock,
+	}
+}
+
+// Record that a periodic worker was started (it is expected to run within twice its interval from now)
+func (h *WorkerHealth) Started() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.since = h.clock.Now()
+}
+
+// Record that work was queued for the worker
+func (h *WorkerHealth) Queued(n int) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.queueLength == nil {
+		h.pending += n
+	}
+	if h.waitingSince.IsZero() {
+		h.waitingSince = h.clock.Now()
+	}
+}
+
+// Record that the worker ran (processing one unit of queued work, if it has a queue), failing if err is not nil
+func (h *WorkerHealth) Ran(err error) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.clock.Now()
+	h.lastRun, h.since = now, now
+	if h.queueLength == nil && h.pending > 0 {
+		h.pending--
+	}
+	if h.queueLengthLocked() > 0 {
+		h.waitingSince = now
+	} else {
+		h.waitingSince = time.Time{}
+	}
+	if err != nil {
+		h.errors++
+		h.lastError = err.Error()
+	}
+}
+
+func (h *WorkerHealth) queueLengthLocked() int {
+	if h.queueLength == nil {
+		return h.pending
+	}
+	return h.queueLength()
+}
+
+// Current state of the worker
+func (h *WorkerHealth) Status() WorkerStatus {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.clock.Now()
+	status := WorkerStatus{
+		Name:          h.Name,
+		Status:        HEALTH_OK,
+		QueueLength:   h.queueLengthLocked(),
+		QueueCapacity: h.QueueCapacity,
+		Errors:        h.errors,
+		LastError:     h.lastError,
+	}
+	if !h.lastRun.IsZero() {
+		lastRun := h.lastRun
+		status.LastRun = &lastRun
+	}
+
+	switch {
+	case h.QueueCapacity > 0 && status.QueueLength >= h.QueueCapacity:
+		status.Status = HEALTH_DEGRADED
+		status.Message = fmt.Sprintf("Queue is full (%d pending)", status.QueueLength)
+	case h.Periodic && !h.since.IsZero() && h.Interval > 0 && now.Sub(h.since) > 2*h.Interval:
+		status.Status = HEALTH_DEGRADED
+		status.Message = fmt.Sprintf("Has not run in %s (expected every %s)", now.Sub(h.since).Round(time.Second), h.Interval)
+	case !h.Periodic && status.QueueLength > 0 && !h.waitingSince.IsZero() && h.Interval > 0 && now.Sub(h.waitingSince) > h.Interval:
+		status.Status = HEALTH_DEGRADED
+		status.Message = fmt.Sprintf("Has made no progress in %s with %d pending", now.Sub(h.waitingSince).Round(time.Second), status.QueueLength)
+	}
+	return status
+}
+
+// Background workers whose health is reported by the readiness probe (and metrics)
+type WorkerRegistry struct {
+	clock        Clock
+	stallTimeout time.Duration // Interval of workers that do not run periodically (unless they have their own)
+
+	mu      sync.Mutex
+	workers map[string]*WorkerHealth
+}
+
+// Create a registry of workers, reading the time from a clock
+// Workers that do not run periodically are reported as stalled if they make no progress within stallTimeout
+// while they have work queued
+func NewWorkerRegistry(clock Clock, stallTimeout time.Duration) *WorkerRegistry {
+	if clock == nil {
+		clock = RealClock
+	}
+	return &WorkerRegistry{clock: clock, stallTimeout: stallTimeout, workers: map[string]*WorkerHealth{}}
+}
+
+// Report the health of a worker (replacing any worker registered under the same name)
+func (r *WorkerRegistry) Register(health *WorkerHealth) {
+	health.mu.Lock()
+	health.clock = r.clock
+	if !health.Periodic && health.Interval == 0 {
+		health.Interval = r.stallTimeout
+	}
+	health.mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.workers[health.Name] = health
+}
+
+// Current state of every worker, by name
+func (r *WorkerRegistry) Statuses() []WorkerStatus {
+	r.mu.Lock()
+	workers := make([]*WorkerHealth, 0, len(r.workers))
+	for _, health := range r.workers {
+		workers = append(workers, health)
+	}
+	r.mu.Unlock()
+
+	statuses := make([]WorkerStatus, len(workers))
+	for i, health := range workers {
+		statuses[i] = health.Status()
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Overall health of a set of workers (degraded, naming the degraded workers, if any of them is)
+func workersHealth(statuses []WorkerStatus) ComponentHealth {
+	degraded := []string{}
+	for _, status := range statuses {
+		if status.Status != HEALTH_OK {
+			degraded = append(degraded, status.Name+": "+status.Message)
+		}
+	}
+	if len(degraded) > 0 {
+		return ComponentHealth{Status: HEALTH_DEGRADED, Message: strings.Join(degraded, "; ")}
+	}
+	return ComponentHealth{Status: HEALTH_OK}
+}
@@ -4,16 +4,27 @@ import (
 	"flag"
 	"github.com/t3hmrman/casgo/cas"
 	"log"
+	"os"
 )
 
 func main() {
 
-	// Flag handling
-	var configFilePath = flag.String("config", "", "Read configuration from specified (JSON) file")
+	// Subcommands (ex. casgo createadmin --email admin@example.com --password ...) run instead of the server
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "createadmin":
+			os.Exit(cas.RunCreateAdminCommand(os.Args[2:], os.Stdout))
+		case "migrate":
+			os.Exit(cas.RunMigrateCommand(os.Args[2:], os.Stdout))
+		}
+	}
+
+	// Flag handling (each option can be set with a flag, ex. -port 8443, overriding the file and ENV)
+	configFlags := cas.NewConfigFlags(flag.CommandLine)
 	flag.Parse()
 
 	// Create new CAS Server config with default values
-	config, err := cas.NewCASServerConfig(*configFilePath)
+	config, err := configFlags.Config()
 	if err != nil {
 		log.Fatalf("Failed to create new CAS server configuration, err: %v", err)
 	}
@@ -25,6 +36,6 @@ func main() {
 	}
 
 	// Start the CAS Server
-	log.Printf("Starting CasGo on port %s...\n", casServer.Config["port"])
+	casServer.Logger.Info("Starting CasGo", "port", casServer.Config["port"])
 	casServer.Start()
 }
@@ -10,6 +10,29 @@ window.App = {
   Routes: {}
 };
 
+/**
+ * URL of an API endpoint, under the base path casgo is mounted at (given by the page)
+ *
+ * @param {string} path - Path of the endpoint (ex. /api/users)
+ * @returns The URL of the endpoint
+ */
+function apiUrl(path) {
+  var basePathMeta = document.querySelector('meta[name="base-path"]');
+  return (basePathMeta ? basePathMeta.getAttribute('content') : '') + path;
+}
+
+/**
+ * Headers for JSON API requests, with the CSRF token of the page (required by requests that change state)
+ *
+ * @returns The request headers
+ */
+function jsonRequestHeaders() {
+  var headers = { 'Accept': 'application/json', 'Content-Type': 'application/json'};
+  var csrfMeta = document.querySelector('meta[name="csrf-token"]');
+  if (csrfMeta) { headers['X-CSRF-Token'] = csrfMeta.getAttribute('content'); }
+  return headers;
+}
+
 /**
  * View model for top-level casgo app
  *
@@ -109,7 +132,7 @@ function CasgoViewModel() {
     fetchCurrentUser: function() {
       var svc = vm.SessionService;
       return new Promise(function(resolve, reject) {
-        fetch('/api/sessions', {credentials: 'same-origin'})
+        fetch(apiUrl('/api/sessions'), {credentials: 'same-origin'})
           .then(function(resp){
             return resp.json();
           }).then(function(json) {
@@ -165,7 +188,7 @@ function CasgoViewModel() {
     getAllServices: function() {
       var svc = vm.ServicesService;
       return new Promise(function(resolve, reject) {
-        fetch('/api/services', {credentials: 'same-origin'})
+        fetch(apiUrl('/api/services'), {credentials: 'same-origin'})
           .then(function(resp) { return resp.json(); })
           .then(function(json) {
             if (json.status === "success") {
@@ -190,7 +213,7 @@ function CasgoViewModel() {
       var self = vm.ServicesService;
       // Get user's services
       return new Promise(function(resolve, reject) {
-        fetch('/api/sessions/' + user.email + "/services", {credentials: 'same-origin'})
+        fetch(apiUrl('/api/sessions/' + user.email + "/services"), {credentials: 'same-origin'})
           .then(function(resp) { return resp.json();})
           .then(function(json) {
             if (json.status === "success") {
@@ -215,10 +238,10 @@ function CasgoViewModel() {
       var self = vm.ServicesService;
       if (_.isUndefined(svc) || !self.isValidService(svc)) throw new Error("Invalid service:", svc);
 
-      return fetch('/api/services', {
+      return fetch(apiUrl('/api/services'), {
         credentials: 'same-origin',
         method: 'post',
-        headers: { 'Accept': 'application/json', 'Content-Type': 'application/json'},
+        headers: jsonRequestHeaders(),
         body: JSON.stringify(svc)
       });
     },
@@ -233,10 +256,10 @@ function CasgoViewModel() {
       var self = vm.ServicesService;
       if (_.isUndefined(svc) || !self.isValidService(svc)) throw new Error("Invalid service:", svc);
 
-      return fetch('/api/services/' + svc.name, {
+      return fetch(apiUrl('/api/services/' + svc.name), {
         credentials: 'same-origin',
         method: 'put',
-        headers: { 'Accept': 'application/json', 'Content-Type': 'application/json'},
+        headers: jsonRequestHeaders(),
         body: JSON.stringify(svc)
       });
     },
@@ -259,10 +282,10 @@ function CasgoViewModel() {
     deleteService: function(svc) {
       var self = vm.ServicesService;
       if (_.isUndefined(svc) || !self.isValidService(svc)) throw new Error("Invalid service:", svc);
-      return fetch('/api/services/' + svc.name, {
+      return fetch(apiUrl('/api/services/' + svc.name), {
         credentials: 'same-origin',
         method: 'delete',
-        headers: { 'Accept': 'application/json', 'Content-Type': 'application/json'}
+        headers: jsonRequestHeaders()
       });
     }
 
@@ -287,7 +310,7 @@ function CasgoViewModel() {
     getAllUsers: function() {
       var svc = vm.UsersService;
       return new Promise(function(resolve, reject) {
-        fetch('/api/users', {credentials: 'same-origin'})
+        fetch(apiUrl('/api/users'), {credentials: 'same-origin'})
           .then(function(resp) { return resp.json(); })
           .then(function(json) {
             if (json.status === "success") {
@@ -312,10 +335,10 @@ function CasgoViewModel() {
       var self = vm.UsersService;
       if (_.isUndefined(user) || !self.isValidUser(user)) throw new Error("Invalid user:", user);
 
-      return fetch('/api/users', {
+      return fetch(apiUrl('/api/users'), {
         credentials: 'same-origin',
         method: 'post',
-        headers: { 'Accept': 'application/json', 'Content-Type': 'application/json'},
+        headers: jsonRequestHeaders(),
         body: JSON.stringify(user)
       });
     },
@@ -329,10 +352,10 @@ function CasgoViewModel() {
     deleteUser: function(user) {
       var self = vm.UsersService;
       if (_.isUndefined(user) || !self.isValidUser(user)) throw new Error("Invalid user:", user);
-      return fetch('/api/users/' + user.email, {
+      return fetch(apiUrl('/api/users/' + user.email), {
         credentials: 'same-origin',
         method: 'delete',
-        headers: { 'Accept': 'application/json', 'Content-Type': 'application/json'}
+        headers: jsonRequestHeaders()
       });
     },
 
@@ -346,10 +369,10 @@ function CasgoViewModel() {
       var self = vm.UsersService;
       if (_.isUndefined(user) || !self.isValidUser(user)) throw new Error("Invalid user:", user);
 
-      return fetch('/api/users/' + user.email, {
+      return fetch(apiUrl('/api/users/' + user.email), {
         credentials: 'same-origin',
         method: 'put',
-        headers: { 'Accept': 'application/json', 'Content-Type': 'application/json'},
+        headers: jsonRequestHeaders(),
         body: JSON.stringify(user)
       });
     }
@@ -552,6 +575,7 @@ function CasgoViewModel() {
     svcName: ko.observable(""),
     svcUrl: ko.observable(""),
     svcAdminEmail: ko.observable(""),
+    svcLogoutUrl: ko.observable(""),
 
     /**
      * Load the controller with an existing service's data
@@ -561,6 +585,7 @@ function CasgoViewModel() {
       ctrl.svcName(svc.name || "");
       ctrl.svcUrl(svc.url || "");
       ctrl.svcAdminEmail(svc.adminEmail || "");
+      ctrl.svcLogoutUrl(svc.logoutUrl || "");
     },
 
     /**
@@ -573,7 +598,8 @@ function CasgoViewModel() {
       var svc =  {
         name: ctrl.svcName(),
         url: ctrl.svcUrl(),
-        adminEmail: ctrl.svcAdminEmail()
+        adminEmail: ctrl.svcAdminEmail(),
+        logoutUrl: ctrl.svcLogoutUrl()
       };
 
       // Add ID if present
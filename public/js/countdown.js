@@ -0,0 +1,13 @@
+'use strict';
+
+// Countdown redirect (to the index once the #count reaches zero)
+setTimeout(function countdownFunc() {
+  var countDOM = document.getElementById("count");
+  var nextCount = parseInt(countDOM.innerHTML) - 1;
+  countDOM.innerHTML = nextCount;
+  if (nextCount > 0) {
+    setTimeout(countdownFunc, 1000);
+  } else {
+    location.href="/";
+  }
+}, 1000);